@@ -0,0 +1,46 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// Abort ends the negotiation in progress, for example because the user
+// canceled it or the connection is closing.
+// The negotiator is left in a terminal state in which State().Aborted() is
+// true and Step returns ErrAborted until it is reset.
+//
+// Servers return the message, if any, that the mechanism defines for telling
+// the client that the exchange failed at the current step (for example, a SCRAM
+// server-final-message with "e=other-error"), which the protocol should send
+// with its failure outcome or abort command.
+// Clients always return nil since aborting is done by the protocol, for example
+// with the "*" response of IMAP and SMTP.
+//
+// If the negotiation has already completed or failed Abort does nothing and
+// returns nil.
+// Abort must not be called while a step is in progress; to abandon a step use
+// the context passed to StepContext, or wrap the negotiator with Synchronized.
+func (c *Negotiator) Abort() []byte {
+	if c.flags.Errored() || c.completed {
+		return nil
+	}
+	var msg []byte
+	if c.isServer && c.mechanism.Abort != nil {
+		msg = c.mechanism.Abort(c, c.cache)
+	}
+
+	oldState := c.State()
+	c.fail(true)
+	if c.debugEnabled() {
+		c.debug("negotiation aborted")
+	}
+	if c.timing.steps > 0 {
+		c.observeOutcome(false)
+	}
+	if c.wipeSecrets {
+		c.wipe()
+	}
+	c.stateChanged(oldState)
+	c.emit(Sent, msg, ErrAborted)
+	return msg
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestAbort(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	newPair := func() (*Negotiator, *Negotiator) {
+		return NewClient(ScramSha256, scramClientOpts...), NewServer(ScramSha256, acceptAll, Store(store))
+	}
+
+	t.Run("before response", func(t *testing.T) {
+		_, server := newPair()
+		if msg := server.Abort(); msg != nil {
+			t.Errorf("Unexpected abort message before the client sent anything: %q", msg)
+		}
+	})
+	t.Run("after server-first", func(t *testing.T) {
+		client, server := newPair()
+		_, clientFirst, err := client.Step(nil)
+		if err != nil {
+			t.Fatalf("Unexpected client error: %v", err)
+		}
+		if _, _, err = server.Step(clientFirst); err != nil {
+			t.Fatalf("Unexpected server error: %v", err)
+		}
+		if msg := server.Abort(); string(msg) != "e=other-error" {
+			t.Errorf("Wrong abort message: want=%q, got=%q", "e=other-error", msg)
+		}
+		state := server.State()
+		if !state.Aborted() || !state.Errored() {
+			t.Errorf("Expected aborted and errored state, got %b", state)
+		}
+		if _, _, err = server.Step([]byte("c=biws")); err != ErrAborted {
+			t.Errorf("Expected ErrAborted after abort, got %v", err)
+		}
+		if code := ErrorCode(ErrAborted); code != CodeAborted {
+			t.Errorf("Wrong code: want=%q, got=%q", CodeAborted, code)
+		}
+		if client.Abort() != nil || !client.State().Aborted() {
+			t.Errorf("Expected client to abort without a message")
+		}
+
+		server.Reset()
+		client.Reset()
+		if server.State().Aborted() {
+			t.Errorf("Reset did not clear the aborted state")
+		}
+		if err = negotiate(client, server); err != nil {
+			t.Errorf("Unexpected error after reset: %v", err)
+		}
+		if server.Abort() != nil || server.State().Aborted() {
+			t.Errorf("Abort changed a completed negotiation")
+		}
+	})
+	t.Run("roles", func(t *testing.T) {
+		server := NewServerNegotiator(ScramSha256, acceptAll, Store(store))
+		server.Abort()
+		if state := server.State(); state != ServerAborted {
+			t.Errorf("Wrong server state: want=%v, got=%v", ServerAborted, state)
+		}
+	})
+}
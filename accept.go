@@ -0,0 +1,58 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// Accept starts a server negotiation for the mechanism that the client selected
+// by name, which must be one of mechs.
+// It is a convenience for servers that receive the mechanism name and the
+// optional initial response together (for example, in an IMAP AUTHENTICATE
+// command or an XMPP auth element) so that they do not need to look up the
+// mechanism themselves.
+// Permissions and opts are used as if they had been passed to NewServer.
+//
+// Initial is the client's initial response, which must be nil if the client did
+// not send one and empty (but not nil) if it sent an empty initial response.
+// If there is no initial response the returned challenge is empty and more is
+// true, otherwise the initial response is passed to Step and its results are
+// returned.
+//
+// If the mechanism is not in mechs ErrMechanismNotSupported is returned and the
+// negotiator is nil.
+// Mechanisms that are not allowed by policy, such as mechanisms that require
+// TLS when the Confidential option is used, are rejected before any challenge
+// is sent.
+func Accept(mechs []Mechanism, permissions func(*Negotiator) bool, name string, initial []byte, opts ...Option) (n *Negotiator, more bool, challenge []byte, err error) {
+	for _, m := range mechs {
+		if m.Name == name {
+			n = NewServer(m, permissions, opts...)
+			more, challenge, err = n.accept(initial)
+			return n, more, challenge, err
+		}
+	}
+	return nil, false, nil, ErrMechanismNotSupported
+}
+
+// Accept is like the Accept function but uses the mechanisms and configuration
+// for host (see NewServer).
+func (f *ServerFactory) Accept(name, host string, initial []byte, opts ...Option) (n *Negotiator, more bool, challenge []byte, err error) {
+	n, err = f.NewServer(name, host, opts...)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	more, challenge, err = n.accept(initial)
+	return n, more, challenge, err
+}
+
+// accept processes the client's initial response, if any.
+func (c *Negotiator) accept(initial []byte) (more bool, challenge []byte, err error) {
+	if initial != nil {
+		return c.Step(initial)
+	}
+	if err := c.checkPolicy(); err != nil {
+		c.fail(false)
+		return false, nil, err
+	}
+	return true, []byte{}, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestAccept(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	mechs := []Mechanism{ScramSha256, Plain}
+
+	if _, _, _, err := Accept(mechs, acceptAll, "SCRAM-SHA-1", nil); err != ErrMechanismNotSupported {
+		t.Errorf("Unexpected error for unknown mechanism: %v", err)
+	}
+
+	// The initial response is processed by the server.
+	client := NewClient(ScramSha256, scramClientOpts...)
+	_, initial, err := client.Step(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error starting client: %v", err)
+	}
+	server, more, challenge, err := Accept(mechs, acceptAll, "SCRAM-SHA-256", initial, Store(store))
+	if err != nil || !more || len(challenge) == 0 {
+		t.Fatalf("Unexpected result of initial response: more=%t, challenge=%q, err=%v", more, challenge, err)
+	}
+	if server.Mechanism().Name != "SCRAM-SHA-256" {
+		t.Errorf("Wrong mechanism selected: %s", server.Mechanism().Name)
+	}
+	_, resp, err := client.Step(challenge)
+	if err != nil {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+	if _, _, err := server.Step(resp); err != nil || !server.Authenticated() {
+		t.Errorf("Authentication failed: %v", err)
+	}
+
+	// Without an initial response the server sends an empty challenge.
+	server, more, challenge, err = Accept(mechs, acceptAll, "PLAIN", nil)
+	if err != nil || !more || challenge == nil || len(challenge) != 0 {
+		t.Fatalf("Expected empty challenge: more=%t, challenge=%q, err=%v", more, challenge, err)
+	}
+	_, resp, _ = NewClient(Plain, plainClientOpts...).Step(nil)
+	if more, _, err := server.Step(resp); err != nil || more || !server.Authenticated() {
+		t.Errorf("Authentication failed: more=%t, err=%v", more, err)
+	}
+
+	// Policy is enforced before a challenge is sent.
+	server, _, challenge, err = Accept(mechs, acceptAll, "PLAIN", nil, Confidential(false))
+	if !errors.As(err, new(InsecureTransportError)) || challenge != nil {
+		t.Errorf("Expected insecure transport error, got challenge=%q, err=%v", challenge, err)
+	}
+	if !server.State().Errored() {
+		t.Error("Expected negotiator to be in the error state")
+	}
+}
+
+func TestServerFactoryAccept(t *testing.T) {
+	f := NewServerFactory([]Mechanism{Plain}, acceptAll)
+	if _, _, _, err := f.Accept("SCRAM-SHA-256", "", nil); err != ErrMechanismNotSupported {
+		t.Errorf("Unexpected error for unknown mechanism: %v", err)
+	}
+	_, resp, _ := NewClient(Plain, plainClientOpts...).Step(nil)
+	server, more, _, err := f.Accept("PLAIN", "example.com", resp)
+	if err != nil || more || !server.Authenticated() {
+		t.Errorf("Authentication failed: more=%t, err=%v", more, err)
+	}
+}
@@ -0,0 +1,448 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package amqpsasl implements the SASL layer of AMQP 1.0 (OASIS AMQP 1.0 part 5
+// §5.3) using negotiators from the sasl package.
+package amqpsasl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/jh125486/sasl"
+)
+
+// ProtocolHeader is sent by both peers before the SASL frames.
+var ProtocolHeader = []byte{'A', 'M', 'Q', 'P', 3, 1, 0, 0}
+
+// Outcome codes.
+const (
+	CodeOK      byte = 0
+	CodeAuth    byte = 1
+	CodeSys     byte = 2
+	CodeSysPerm byte = 3
+	CodeSysTemp byte = 4
+)
+
+// Performative descriptors.
+const (
+	descMechanisms = 0x40
+	descInit       = 0x41
+	descChallenge  = 0x42
+	descResponse   = 0x43
+	descOutcome    = 0x44
+)
+
+const frameTypeSASL = 0x01
+
+// Errors returned while decoding frames.
+var (
+	ErrMalformed        = errors.New("amqpsasl: malformed frame")
+	ErrUnexpectedFrame  = errors.New("amqpsasl: unexpected frame")
+	ErrMechanismMissing = errors.New("amqpsasl: mechanism not offered by the server")
+)
+
+// Mechanisms is the sasl-mechanisms performative.
+type Mechanisms struct {
+	Mechanisms []string
+}
+
+// Init is the sasl-init performative.
+type Init struct {
+	Mechanism       string
+	InitialResponse []byte
+	Hostname        string
+}
+
+// Challenge is the sasl-challenge performative.
+type Challenge struct {
+	Challenge []byte
+}
+
+// Response is the sasl-response performative.
+type Response struct {
+	Response []byte
+}
+
+// Outcome is the sasl-outcome performative.
+type Outcome struct {
+	Code           byte
+	AdditionalData []byte
+}
+
+// OutcomeError is returned when the server sends an outcome other than
+// CodeOK.
+type OutcomeError struct {
+	Code byte
+}
+
+func (e *OutcomeError) Error() string {
+	return "amqpsasl: authentication failed with outcome code " + strconv.Itoa(int(e.Code))
+}
+
+// Unwrap returns sasl.ErrAuthn if the failure was caused by the credentials.
+func (e *OutcomeError) Unwrap() error {
+	if e.Code == CodeAuth {
+		return sasl.ErrAuthn
+	}
+	return nil
+}
+
+// Temporary reports whether the outcome was CodeSysTemp, meaning that
+// authentication may succeed if it is retried later.
+func (e *OutcomeError) Temporary() bool {
+	return e.Code == CodeSysTemp
+}
+
+// WriteFrame writes a SASL frame containing one of the performative types.
+func WriteFrame(w io.Writer, performative interface{}) error {
+	var (
+		desc   byte
+		fields []byte
+		count  uint32
+	)
+	switch p := performative.(type) {
+	case Mechanisms:
+		desc, count = descMechanisms, 1
+		fields = appendSymbols(nil, p.Mechanisms)
+	case Init:
+		desc, count = descInit, 3
+		fields = appendSymbol(nil, p.Mechanism)
+		fields = appendBinary(fields, p.InitialResponse)
+		if p.Hostname == "" {
+			fields = append(fields, 0x40)
+		} else {
+			fields = appendVar(fields, 0xb1, []byte(p.Hostname))
+		}
+	case Challenge:
+		desc, count = descChallenge, 1
+		fields = appendVar(nil, 0xb0, p.Challenge)
+	case Response:
+		desc, count = descResponse, 1
+		fields = appendVar(nil, 0xb0, p.Response)
+	case Outcome:
+		desc, count = descOutcome, 2
+		fields = append(fields, 0x50, p.Code)
+		fields = appendBinary(fields, p.AdditionalData)
+	default:
+		return ErrUnexpectedFrame
+	}
+
+	body := []byte{0x00, 0x53, desc, 0xd0}
+	body = binary.BigEndian.AppendUint32(body, uint32(4+len(fields)))
+	body = binary.BigEndian.AppendUint32(body, count)
+	body = append(body, fields...)
+
+	frame := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	frame = append(frame, 2, frameTypeSASL, 0, 0)
+	_, err := w.Write(append(frame, body...))
+	return err
+}
+
+// ReadFrame reads a SASL frame and returns the performative it contains as one
+// of the performative types.
+func ReadFrame(r io.Reader) (interface{}, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	doff := int(hdr[4]) * 4
+	if hdr[5] != frameTypeSASL || doff < 8 || size < uint32(doff) {
+		return nil, ErrMalformed
+	}
+	if size > sasl.DefaultMaxMessageSize {
+		return nil, sasl.ErrMessageTooLarge
+	}
+	frame := make([]byte, size-8)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	d := decoder(frame[doff-8:])
+
+	if d.byte() != 0x00 {
+		return nil, ErrMalformed
+	}
+	var desc uint64
+	switch d.byte() {
+	case 0x53:
+		desc = uint64(d.byte())
+	case 0x80:
+		if b := d.take(8); b != nil {
+			desc = binary.BigEndian.Uint64(b)
+		}
+	default:
+		return nil, ErrMalformed
+	}
+	fields := d.list()
+	field := func(i int) decoder {
+		if i < len(fields) {
+			return fields[i]
+		}
+		return decoder{0x40}
+	}
+
+	if d == nil {
+		return nil, ErrMalformed
+	}
+	ok := true
+	variable := func(i int) []byte {
+		b, valid := field(i).variable()
+		ok = ok && valid
+		return b
+	}
+
+	var p interface{}
+	switch desc {
+	case descMechanisms:
+		var mechs []string
+		mechs, ok = field(0).symbols()
+		p = Mechanisms{Mechanisms: mechs}
+	case descInit:
+		p = Init{Mechanism: string(variable(0)), InitialResponse: variable(1), Hostname: string(variable(2))}
+	case descChallenge:
+		p = Challenge{Challenge: variable(0)}
+	case descResponse:
+		p = Response{Response: variable(0)}
+	case descOutcome:
+		f := field(0)
+		ok = f.byte() == 0x50
+		p = Outcome{Code: f.byte(), AdditionalData: variable(1)}
+	default:
+		return nil, ErrUnexpectedFrame
+	}
+	if !ok {
+		return nil, ErrMalformed
+	}
+	return p, nil
+}
+
+// Authenticate performs the client side of the SASL layer over rw using the
+// client negotiator n, starting with the protocol header exchange.
+// The hostname is sent in the sasl-init frame if it is not empty.
+func Authenticate(rw io.ReadWriter, n *sasl.Negotiator, hostname string) error {
+	if _, err := rw.Write(ProtocolHeader); err != nil {
+		return err
+	}
+	hdr := make([]byte, len(ProtocolHeader))
+	if _, err := io.ReadFull(rw, hdr); err != nil {
+		return err
+	}
+	if !bytes.Equal(hdr, ProtocolHeader) {
+		return ErrMalformed
+	}
+
+	frame, err := ReadFrame(rw)
+	if err != nil {
+		return err
+	}
+	mechs, ok := frame.(Mechanisms)
+	if !ok {
+		return ErrUnexpectedFrame
+	}
+	name := n.Mechanism().Name
+	offered := false
+	for _, m := range mechs.Mechanisms {
+		offered = offered || m == name
+	}
+	if !offered {
+		return ErrMechanismMissing
+	}
+
+	_, resp, err := n.Step(nil)
+	if err != nil {
+		return err
+	}
+	if err = WriteFrame(rw, Init{Mechanism: name, InitialResponse: resp, Hostname: hostname}); err != nil {
+		return err
+	}
+	for {
+		frame, err := ReadFrame(rw)
+		if err != nil {
+			return err
+		}
+		switch f := frame.(type) {
+		case Challenge:
+			if _, resp, err = n.Step(f.Challenge); err != nil {
+				return err
+			}
+			if err = WriteFrame(rw, Response{Response: resp}); err != nil {
+				return err
+			}
+		case Outcome:
+			if f.Code != CodeOK {
+				return &OutcomeError{Code: f.Code}
+			}
+			return n.Finish(f.AdditionalData)
+		default:
+			return ErrUnexpectedFrame
+		}
+	}
+}
+
+func appendVar(dst []byte, code byte, b []byte) []byte {
+	dst = append(dst, code)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(b)))
+	return append(dst, b...)
+}
+
+func appendBinary(dst, b []byte) []byte {
+	if b == nil {
+		return append(dst, 0x40)
+	}
+	return appendVar(dst, 0xb0, b)
+}
+
+func appendSymbol(dst []byte, s string) []byte {
+	return appendVar(dst, 0xb3, []byte(s))
+}
+
+func appendSymbols(dst []byte, syms []string) []byte {
+	var elems []byte
+	for _, s := range syms {
+		elems = binary.BigEndian.AppendUint32(elems, uint32(len(s)))
+		elems = append(elems, s...)
+	}
+	dst = append(dst, 0xf0)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(4+1+len(elems)))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(syms)))
+	dst = append(dst, 0xb3)
+	return append(dst, elems...)
+}
+
+// decoder reads AMQP encoded values.
+// If the input is malformed it is set to nil and all further reads return zero
+// values.
+type decoder []byte
+
+func (d *decoder) take(n int) []byte {
+	if n < 0 || len(*d) < n {
+		*d = nil
+		return nil
+	}
+	b := (*d)[:n:n]
+	*d = (*d)[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) size(wide bool) int {
+	if !wide {
+		return int(d.byte())
+	}
+	if b := d.take(4); b != nil {
+		return int(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+// list decodes a list and returns a decoder for each field.
+func (d *decoder) list() []decoder {
+	var wide bool
+	switch d.byte() {
+	case 0x45:
+		return nil
+	case 0xc0:
+	case 0xd0:
+		wide = true
+	default:
+		*d = nil
+		return nil
+	}
+	body := decoder(d.take(d.size(wide)))
+	count := body.size(wide)
+	if body == nil || count > len(body) {
+		*d = nil
+		return nil
+	}
+	fields := make([]decoder, 0, count)
+	for i := 0; i < count; i++ {
+		start := body
+		body.skip()
+		if body == nil {
+			*d = nil
+			return nil
+		}
+		fields = append(fields, start[:len(start)-len(body)])
+	}
+	return fields
+}
+
+// skip advances past a single value.
+func (d *decoder) skip() {
+	switch code := d.byte(); code {
+	case 0x40, 0x41, 0x42, 0x45:
+	case 0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56:
+		d.take(1)
+	case 0xa0, 0xa1, 0xa3, 0xc0, 0xe0:
+		d.take(d.size(false))
+	case 0xb0, 0xb1, 0xb3, 0xd0, 0xf0:
+		d.take(d.size(true))
+	default:
+		*d = nil
+	}
+}
+
+// variable decodes a binary, string, or symbol value.
+// A null value decodes as nil.
+func (d decoder) variable() ([]byte, bool) {
+	var b []byte
+	switch d.byte() {
+	case 0x40:
+		return nil, true
+	case 0xa0, 0xa1, 0xa3:
+		b = d.take(d.size(false))
+	case 0xb0, 0xb1, 0xb3:
+		b = d.take(d.size(true))
+	default:
+		return nil, false
+	}
+	return append([]byte{}, b...), d != nil
+}
+
+// symbols decodes a single symbol or an array of symbols.
+func (d decoder) symbols() ([]string, bool) {
+	var wide bool
+	switch d[0] {
+	case 0x40:
+		return nil, true
+	case 0xa3, 0xb3:
+		sym, ok := d.variable()
+		return []string{string(sym)}, ok
+	case 0xe0:
+	case 0xf0:
+		wide = true
+	default:
+		return nil, false
+	}
+	d.byte()
+	body := decoder(d.take(d.size(wide)))
+	count := body.size(wide)
+	var elemWide bool
+	switch body.byte() {
+	case 0xa3:
+	case 0xb3:
+		elemWide = true
+	default:
+		return nil, false
+	}
+	syms := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		sym := body.take(body.size(elemWide))
+		if body == nil {
+			return nil, false
+		}
+		syms = append(syms, string(sym))
+	}
+	return syms, len(body) == 0
+}
@@ -0,0 +1,154 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package amqpsasl_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/amqpsasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	for i, p := range []interface{}{
+		amqpsasl.Mechanisms{Mechanisms: []string{"SCRAM-SHA-256", "PLAIN", "ANONYMOUS"}},
+		amqpsasl.Init{Mechanism: "PLAIN", InitialResponse: []byte("\x00user\x00pencil"), Hostname: "example.net"},
+		amqpsasl.Init{Mechanism: "ANONYMOUS"},
+		amqpsasl.Challenge{Challenge: []byte("challenge")},
+		amqpsasl.Response{Response: []byte{}},
+		amqpsasl.Outcome{Code: amqpsasl.CodeOK, AdditionalData: []byte("v=abc")},
+		amqpsasl.Outcome{Code: amqpsasl.CodeAuth},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := amqpsasl.WriteFrame(&buf, p); err != nil {
+				t.Fatalf("Unexpected error writing frame: %v", err)
+			}
+			got, err := amqpsasl.ReadFrame(&buf)
+			if err != nil {
+				t.Fatalf("Unexpected error reading frame: %v", err)
+			}
+			if !reflect.DeepEqual(got, p) {
+				t.Errorf("Frame did not round trip: want=%#v, got=%#v", p, got)
+			}
+		})
+	}
+}
+
+func TestReadCompactFrame(t *testing.T) {
+	// A sasl-mechanisms frame using the compact list8, array8, and sym8
+	// encodings.
+	body := []byte{
+		0x00, 0x53, 0x40,
+		0xc0, 0x14, 0x01,
+		0xe0, 0x11, 0x02, 0xa3,
+		0x05, 'P', 'L', 'A', 'I', 'N',
+		0x08, 'E', 'X', 'T', 'E', 'R', 'N', 'A', 'L',
+	}
+	frame := append([]byte{0, 0, 0, byte(8 + len(body)), 2, 1, 0, 0}, body...)
+
+	got, err := amqpsasl.ReadFrame(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := amqpsasl.Mechanisms{Mechanisms: []string{"PLAIN", "EXTERNAL"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected frame: want=%#v, got=%#v", want, got)
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	for i, frame := range [][]byte{
+		{0, 0, 0, 8, 2, 0, 0, 0},
+		{0, 0, 0, 12, 2, 1, 0, 0, 0x00, 0x53, 0x41, 0xc0},
+		{0, 0, 0, 14, 2, 1, 0, 0, 0x00, 0x53, 0x41, 0xc0, 0x05, 0x01},
+		{0, 0, 0, 14, 2, 1, 0, 0, 0x00, 0x53, 0x41, 0xc0, 0x02, 0x01, 0xa3, 0x09},
+		{0, 0, 0, 16, 2, 1, 0, 0, 0x00, 0x53, 0x40, 0xc0, 0x04, 0x01, 0xe0, 0x01, 0x02, 0xa3},
+		{0, 0, 0, 14, 2, 1, 0, 0, 0x00, 0x53, 0x42, 0xc0, 0x02, 0x01, 0x50, 0x01},
+	} {
+		if _, err := amqpsasl.ReadFrame(bytes.NewReader(frame)); err != amqpsasl.ErrMalformed {
+			t.Errorf("%d: Expected malformed error, got %v", i, err)
+		}
+	}
+}
+
+// serve runs the server side of the SASL layer for a single negotiator.
+func serve(conn net.Conn, server *sasl.Negotiator) {
+	defer conn.Close()
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return
+	}
+	conn.Write(amqpsasl.ProtocolHeader)
+	amqpsasl.WriteFrame(conn, amqpsasl.Mechanisms{Mechanisms: []string{"ANONYMOUS", server.Mechanism().Name}})
+
+	frame, err := amqpsasl.ReadFrame(conn)
+	if err != nil {
+		return
+	}
+	resp := frame.(amqpsasl.Init).InitialResponse
+	for {
+		more, challenge, err := server.Step(resp)
+		switch {
+		case err != nil:
+			amqpsasl.WriteFrame(conn, amqpsasl.Outcome{Code: amqpsasl.CodeAuth})
+			return
+		case !more:
+			amqpsasl.WriteFrame(conn, amqpsasl.Outcome{Code: amqpsasl.CodeOK, AdditionalData: challenge})
+			return
+		}
+		amqpsasl.WriteFrame(conn, amqpsasl.Challenge{Challenge: challenge})
+		if frame, err = amqpsasl.ReadFrame(conn); err != nil {
+			return
+		}
+		resp = frame.(amqpsasl.Response).Response
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	for _, tc := range []struct {
+		name     string
+		password string
+		fail     bool
+	}{
+		{name: "scram", password: "pencil"},
+		{name: "scram-bad-password", password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			go serve(serverConn, sasl.NewServer(sasl.ScramSha256, func(*sasl.Negotiator) bool { return true }, sasl.Store(s)))
+
+			client := sasl.NewClient(sasl.ScramSha256, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(tc.password), nil
+			}))
+			err := amqpsasl.Authenticate(clientConn, client, "example.net")
+			switch {
+			case tc.fail && !errors.Is(err, sasl.ErrAuthn):
+				t.Errorf("Expected authentication error, got %v", err)
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
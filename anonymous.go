@@ -0,0 +1,58 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// maxTraceLen is the maximum length of the ANONYMOUS trace information in
+// characters (RFC 4505 §3).
+const maxTraceLen = 255
+
+// Trace is the property that holds the trace information sent by an ANONYMOUS
+// client, such as an email address.
+// On servers it is set on the negotiator passed to the permissions callback.
+var Trace = NewProperty[string]("ANONYMOUS trace")
+
+// checkTrace makes sure that the trace information can be used in an ANONYMOUS
+// message as defined in RFC 4505 §3.
+func checkTrace(trace []byte) error {
+	switch {
+	case !utf8.Valid(trace):
+		return CredentialError{Field: "trace", Reason: "is not valid UTF-8"}
+	case bytes.IndexByte(trace, 0) != -1:
+		return CredentialError{Field: "trace", Reason: "contains a NUL byte"}
+	case utf8.RuneCount(trace) > maxTraceLen:
+		return CredentialError{Field: "trace", Reason: "is longer than 255 characters"}
+	}
+	return nil
+}
+
+var anonymous = Mechanism{
+	Name: "ANONYMOUS",
+	Start: func(m *Negotiator) (more bool, resp []byte, _ interface{}, err error) {
+		trace, _ := Trace.Get(m)
+		if err = checkTrace([]byte(trace)); err != nil {
+			return false, nil, nil, err
+		}
+		m.session = m.newNonce()
+		return false, []byte(trace), nil, nil
+	},
+	Next: func(m *Negotiator, challenge []byte, _ interface{}) (more bool, resp []byte, _ interface{}, err error) {
+		if !m.State().IsServer() || m.State().Step() != AuthTextSent {
+			return false, nil, nil, ErrTooManySteps
+		}
+		if err = checkTrace(challenge); err != nil {
+			return false, nil, nil, err
+		}
+		if !m.Permissions(Trace.Set(string(challenge))) {
+			return false, nil, nil, ErrAuthn
+		}
+		m.session = m.newNonce()
+		return false, nil, nil, nil
+	},
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAnonymous(t *testing.T) {
+	var trace string
+	client := NewClient(Anonymous, Trace.Set("guest@example.net"))
+	server := NewServer(Anonymous, func(n *Negotiator) bool {
+		trace, _ = Trace.Get(n)
+		return true
+	})
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if trace != "guest@example.net" {
+		t.Errorf("Unexpected trace: want=%q, got=%q", "guest@example.net", trace)
+	}
+	if server.SessionToken() == nil || client.SessionToken() == nil {
+		t.Error("Expected completed negotiation to have a session token")
+	}
+
+	client = NewClient(Anonymous, Trace.Set(strings.Repeat("a", 256)))
+	var credErr CredentialError
+	if _, _, err := client.Step(nil); !errors.As(err, &credErr) || credErr.Field != "trace" {
+		t.Errorf("Expected CredentialError for long trace, got %v", err)
+	}
+
+	server = NewServer(Anonymous, nil)
+	if _, _, err := server.Step([]byte("guest")); err != ErrAuthn {
+		t.Errorf("Expected ErrAuthn when permissions denies, got %v", err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	var linked []byte
+	client := NewClient(Anonymous)
+	server := NewServer(Anonymous, func(n *Negotiator) bool {
+		linked = n.SessionToken()
+		return true
+	})
+	if err := client.Upgrade(ScramSha256); err != ErrInvalidState {
+		t.Errorf("Expected ErrInvalidState before negotiating, got %v", err)
+	}
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	token := bytes.Clone(server.SessionToken())
+
+	if err := client.Upgrade(ScramSha256, scramClientOpts...); err != nil {
+		t.Fatalf("Unexpected error upgrading client: %v", err)
+	}
+	if err := server.Upgrade(ScramSha256, Store(store)); err != nil {
+		t.Fatalf("Unexpected error upgrading server: %v", err)
+	}
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error negotiating upgrade: %v", err)
+	}
+	if !bytes.Equal(linked, token) || !bytes.Equal(server.SessionToken(), token) {
+		t.Errorf("Session token was not kept: want=%x, got=%x", token, linked)
+	}
+	if id, _ := server.Identity(); string(id.Username) != "user" {
+		t.Errorf("Unexpected identity after upgrade: %q", id.Username)
+	}
+	if _, ok := server.PreviousIdentity(); !ok {
+		t.Error("Expected the anonymous identity to be available")
+	}
+	if err := server.Upgrade(Plain); err != ErrInvalidState {
+		t.Errorf("Expected ErrInvalidState upgrading a non-anonymous session, got %v", err)
+	}
+
+	server.Reset()
+	if server.SessionToken() != nil {
+		t.Error("Expected Reset to discard the session token")
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build !sasl_tiny
+
+package sasl
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/secure/precis"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+const (
+	maxDomainpart = 1023
+	maxLabel      = 63
+)
+
+// AuthzIDDomains causes servers to prepare authorization identities with
+// PrepareAuthzID before they are passed to the permissions callback, so that
+// the callback and Identity see a single canonical form of identities such as
+// "Juliet@Example.COM" and "juliet@example.com", and to call policy with the
+// domainpart of each identity.
+//
+// If policy returns an error, or the identity cannot be prepared, the
+// permissions callback is not called and the client is not authorized.
+// Identities without a domainpart are passed to policy with an empty domain so
+// that servers that host several domains can reject them.
+// A nil policy accepts every domain.
+// Empty authorization identities are not prepared and policy is not called.
+//
+// This is used by multi-domain servers, such as XMPP and mail servers with
+// virtual hosts, to decide which domains a user may act on behalf of.
+// AuthzIDDomains is not available in builds with the sasl_tiny build tag.
+func AuthzIDDomains(policy func(n *Negotiator, domain string) error) Option {
+	return func(n *Negotiator) {
+		n.authzPrep = func(n *Negotiator, identity []byte) ([]byte, error) {
+			prepared, err := PrepareAuthzID(identity)
+			if err != nil {
+				return nil, err
+			}
+			if policy != nil {
+				var domain string
+				if i := strings.LastIndexByte(string(prepared), '@'); i != -1 {
+					domain = string(prepared[i+1:])
+				}
+				if err = policy(n, domain); err != nil {
+					return nil, err
+				}
+			}
+			return prepared, nil
+		}
+	}
+}
+
+// PrepareAuthzID returns the canonical form of an authorization identity of the
+// form "localpart@domainpart" (or just "localpart") as described in RFC 7622
+// §3.
+// The localpart is prepared with the PRECIS UsernameCaseMapped profile (RFC
+// 8265) and the domainpart has its A-labels converted to U-labels and is width
+// mapped, case mapped, and normalized to NFC, so that internationalized domain
+// names compare equal however the client encoded them.
+// A trailing dot is removed and IP literals are returned unchanged.
+//
+// Clients can use it to prepare the identity given to AuthorizationIdentity.
+// Errors wrap ErrAuthzID.
+// PrepareAuthzID is not available in builds with the sasl_tiny build tag.
+func PrepareAuthzID(identity []byte) ([]byte, error) {
+	// Split at the last "@" since the localpart of a mail address may contain a
+	// quoted "@".
+	local, domain := string(identity), ""
+	i := strings.LastIndexByte(local, '@')
+	if i != -1 {
+		local, domain = local[:i], local[i+1:]
+	}
+	if local == "" {
+		return nil, fmt.Errorf("%w: empty localpart", ErrAuthzID)
+	}
+	local, err := precis.UsernameCaseMapped.String(local)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid localpart: %v", ErrAuthzID, err)
+	}
+	if i == -1 {
+		return []byte(local), nil
+	}
+	domain, err = prepareDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(local + "@" + domain), nil
+}
+
+// prepareDomain prepares a domainpart as described in RFC 7622 §3.2.
+func prepareDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" || len(domain) > maxDomainpart {
+		return "", fmt.Errorf("%w: domainpart is empty or too long", ErrAuthzID)
+	}
+	if strings.HasPrefix(domain, "[") && strings.HasSuffix(domain, "]") {
+		addr, err := netip.ParseAddr(domain[1 : len(domain)-1])
+		if err != nil || !addr.Is6() {
+			return "", fmt.Errorf("%w: invalid IPv6 literal", ErrAuthzID)
+		}
+		return domain, nil
+	}
+	if !utf8.ValidString(domain) {
+		return "", fmt.Errorf("%w: domainpart is not valid UTF-8", ErrAuthzID)
+	}
+
+	domain = cases.Lower(language.Und).String(width.Fold.String(domain))
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if len(label) > maxLabel {
+			return "", fmt.Errorf("%w: domain label is too long", ErrAuthzID)
+		}
+		if strings.HasPrefix(label, "xn--") {
+			u, err := decodePunycode(label[len("xn--"):])
+			if err != nil {
+				return "", err
+			}
+			labels[i] = u
+		}
+	}
+	domain = norm.NFC.String(strings.Join(labels, "."))
+	for _, label := range strings.Split(domain, ".") {
+		if err := checkLabel(label); err != nil {
+			return "", err
+		}
+	}
+	return domain, nil
+}
+
+// checkLabel returns an error if label is not a valid domain label.
+// It is less strict than IDNA2008, which only allows certain letters and
+// digits in U-labels, but does disallow the punctuation and symbols that are
+// used to confuse users and that have meaning in identities.
+func checkLabel(label string) error {
+	if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+		return fmt.Errorf("%w: empty domain label or label with a leading or trailing hyphen", ErrAuthzID)
+	}
+	for _, r := range label {
+		if r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r) {
+			return fmt.Errorf("%w: domain label contains %U", ErrAuthzID, r)
+		}
+	}
+	return nil
+}
+
+// Punycode parameters (RFC 3492 §5).
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// decodePunycode decodes the Punycode encoded part of an A-label (RFC 3492
+// §6.2).
+func decodePunycode(s string) (string, error) {
+	errInvalid := fmt.Errorf("%w: invalid A-label", ErrAuthzID)
+	var out []rune
+	if pos := strings.LastIndexByte(s, '-'); pos != -1 {
+		for i := 0; i < pos; i++ {
+			if s[i] >= utf8.RuneSelf {
+				return "", errInvalid
+			}
+			out = append(out, rune(s[i]))
+		}
+		s = s[pos+1:]
+	}
+
+	n, bias, i := punyInitialN, punyInitialBias, 0
+	for len(s) > 0 {
+		oldi, w := i, 1
+		for k := punyBase; ; k += punyBase {
+			if len(s) == 0 {
+				return "", errInvalid
+			}
+			d := punyDigit(s[0])
+			s = s[1:]
+			if d < 0 || d > (math.MaxInt32-i)/w {
+				return "", errInvalid
+			}
+			i += d * w
+			t := k - bias
+			switch {
+			case t < punyTMin:
+				t = punyTMin
+			case t > punyTMax:
+				t = punyTMax
+			}
+			if d < t {
+				break
+			}
+			if w > math.MaxInt32/(punyBase-t) {
+				return "", errInvalid
+			}
+			w *= punyBase - t
+		}
+		bias = punyAdapt(i-oldi, len(out)+1, oldi == 0)
+		if i/(len(out)+1) > unicode.MaxRune-n {
+			return "", errInvalid
+		}
+		n += i / (len(out) + 1)
+		i %= len(out) + 1
+		if n < punyInitialN || (n >= 0xd800 && n <= 0xdfff) {
+			return "", errInvalid
+		}
+		out = append(out, 0)
+		copy(out[i+1:], out[i:])
+		out[i] = rune(n)
+		i++
+	}
+	return string(out), nil
+}
+
+func punyDigit(b byte) int {
+	switch {
+	case b >= '0' && b <= '9':
+		return int(b-'0') + 26
+	case b >= 'a' && b <= 'z':
+		return int(b - 'a')
+	case b >= 'A' && b <= 'Z':
+		return int(b - 'A')
+	}
+	return -1
+}
+
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build !sasl_tiny
+
+package sasl
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPrepareAuthzID(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		out string
+		err bool
+	}{
+		{in: "juliet@example.com", out: "juliet@example.com"},
+		{in: "Juliet@Example.COM.", out: "juliet@example.com"},
+		{in: "juliet", out: "juliet"},
+		{in: "juliet@xn--bcher-kva.example", out: "juliet@bücher.example"},
+		{in: "juliet@XN--BCHER-KVA.example", out: "juliet@bücher.example"},
+		{in: "juliet@BÜCHER.example", out: "juliet@bücher.example"},
+		{in: "juliet@ｅｘａｍｐｌｅ.com", out: "juliet@example.com"},
+		{in: "juliet@xn--mnchen-3ya.de", out: "juliet@münchen.de"},
+		{in: "\"a@b\"@example.com", out: "\"a@b\"@example.com"},
+		{in: "juliet@[2001:db8::1]", out: "juliet@[2001:db8::1]"},
+		{in: "juliet@192.0.2.1", out: "juliet@192.0.2.1"},
+		{in: "juliet@", err: true},
+		{in: "@example.com", err: true},
+		{in: "juliet@exa mple.com", err: true},
+		{in: "juliet@example..com", err: true},
+		{in: "juliet@-example.com", err: true},
+		{in: "juliet@[192.0.2.1]", err: true},
+		{in: "juliet@xn--bcher-!!!.example", err: true},
+		{in: "juliet@xn--99999999999.example", err: true},
+		{in: "juliet@example.com/balcony", err: true},
+		{in: "juliet@ex\xffample.com", err: true},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			out, err := PrepareAuthzID([]byte(tc.in))
+			switch {
+			case tc.err && !errors.Is(err, ErrAuthzID):
+				t.Fatalf("Expected ErrAuthzID, got %v (%q)", err, out)
+			case !tc.err && err != nil:
+				t.Fatalf("Unexpected error: %v", err)
+			case string(out) != tc.out:
+				t.Errorf("Wrong output: want=%q, got=%q", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestAuthzIDDomains(t *testing.T) {
+	errDomain := errors.New("domain not hosted")
+	policy := func(_ *Negotiator, domain string) error {
+		if domain != "bücher.example" {
+			return errDomain
+		}
+		return nil
+	}
+	for _, tc := range []struct {
+		name     string
+		identity string
+		ok       bool
+	}{
+		{name: "a-label", identity: "Juliet@XN--BCHER-KVA.example.", ok: true},
+		{name: "u-label", identity: "juliet@BÜCHER.example", ok: true},
+		{name: "other domain", identity: "juliet@example.com"},
+		{name: "no domain", identity: "juliet"},
+		{name: "invalid", identity: "juliet@bücher example"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			server := NewServer(Plain, func(n *Negotiator) bool {
+				_, _, identity := n.Credentials()
+				got = string(identity)
+				return true
+			}, AuthzIDDomains(policy))
+			_, _, err := server.Step([]byte(tc.identity + "\x00Kurt\x00xipj3plmq"))
+			if (err == nil) != tc.ok {
+				t.Fatalf("Unexpected result: want ok=%t, got err=%v", tc.ok, err)
+			}
+			if !tc.ok {
+				if got != "" {
+					t.Errorf("Permissions callback called with %q", got)
+				}
+				return
+			}
+			if got != "juliet@bücher.example" {
+				t.Errorf("Wrong identity passed to permissions: %q", got)
+			}
+			if id, _ := server.Identity(); string(id.Identity) != got {
+				t.Errorf("Wrong identity reported: %q", id.Identity)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"time"
+)
+
+// Clock sets the function used by the negotiator to get the current time.
+// Mechanisms that depend on the time, such as those that check token expiry or
+// one-time passwords, get it from the Now method so that they can be tested
+// deterministically.
+// The durations reported to a MetricsRecorder are also measured with now.
+// The default is time.Now.
+func Clock(now func() time.Time) Option {
+	return func(n *Negotiator) {
+		n.clock = now
+	}
+}
+
+// Now returns the current time according to the Clock option.
+// Mechanisms should use it instead of calling time.Now directly.
+func (c *Negotiator) Now() time.Time {
+	if c.clock != nil {
+		return c.clock()
+	}
+	return time.Now()
+}
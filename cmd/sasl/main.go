@@ -0,0 +1,343 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// The sasl command is a diagnostic tool that acts as a SASL client or server
+// and prints each step of the exchange to stderr.
+//
+// By default the exchange runs over stdin and stdout, use -connect or -listen
+// to run it over TCP instead.
+// Clients can speak the framing used by several protocols (see -proto), the
+// server only speaks the raw framing: each message is a single line of base64
+// ("=" for an empty message), challenges are prefixed with "+ ", and the
+// exchange ends with "OK" (optionally followed by additional data) or "NO".
+//
+// Usage:
+//
+//	sasl [options]
+//
+// Run sasl -h for a list of options.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/imapsasl"
+	"github.com/jh125486/sasl/nntpsasl"
+	"github.com/jh125486/sasl/pop3sasl"
+	"github.com/jh125486/sasl/sievesasl"
+)
+
+var mechanisms = map[string]sasl.Mechanism{
+	sasl.Plain.Name:           sasl.Plain,
+	sasl.ScramSha1.Name:       sasl.ScramSha1,
+	sasl.ScramSha1Plus.Name:   sasl.ScramSha1Plus,
+	sasl.ScramSha256.Name:     sasl.ScramSha256,
+	sasl.ScramSha256Plus.Name: sasl.ScramSha256Plus,
+	sasl.ScramSha512.Name:     sasl.ScramSha512,
+	sasl.ScramSha512Plus.Name: sasl.ScramSha512Plus,
+}
+
+func main() {
+	var (
+		server      bool
+		mechName    = sasl.ScramSha256.Name
+		username    string
+		password    string
+		authzid     string
+		connect     string
+		listen      string
+		useTLS      bool
+		insecure    bool
+		certFile    string
+		keyFile     string
+		proto       = "raw"
+		tag         = "a001"
+		showSecrets bool
+	)
+	flags := flag.NewFlagSet("sasl", flag.ExitOnError)
+	flags.BoolVar(&server, "server", server, "act as a server instead of a client")
+	flags.StringVar(&mechName, "mech", mechName, "the mechanism to use, one of "+strings.Join(mechanismNames(), ", "))
+	flags.StringVar(&username, "user", username, "the username")
+	flags.StringVar(&password, "pass", password, "the password (or the only valid password in server mode)")
+	flags.StringVar(&authzid, "authz", authzid, "the authorization identity to request")
+	flags.StringVar(&connect, "connect", connect, "connect to this TCP address instead of using stdio")
+	flags.StringVar(&listen, "listen", listen, "accept a single connection on this TCP address instead of using stdio")
+	flags.BoolVar(&useTLS, "tls", useTLS, "use TLS on the TCP connection")
+	flags.BoolVar(&insecure, "insecure", insecure, "do not verify the server's certificate")
+	flags.StringVar(&certFile, "cert", certFile, "the server's TLS certificate")
+	flags.StringVar(&keyFile, "key", keyFile, "the server's TLS key")
+	flags.StringVar(&proto, "proto", proto, "the client framing, one of raw, imap, pop3, nntp, sieve")
+	flags.StringVar(&tag, "tag", tag, "the command tag used by the imap framing")
+	flags.BoolVar(&showSecrets, "show-secrets", showSecrets, "print passwords sent by mechanisms such as PLAIN")
+	flags.Parse(os.Args[1:])
+
+	logger := log.New(os.Stderr, "", 0)
+	mech, ok := mechanisms[strings.ToUpper(mechName)]
+	if !ok {
+		logger.Fatalf("unknown mechanism %q", mechName)
+	}
+	mech = trace(mech, logger, showSecrets)
+
+	rw, tlsState, err := dial(connect, listen, useTLS, insecure, certFile, keyFile)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if c, ok := rw.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	var opts []sasl.Option
+	if tlsState != nil {
+		opts = append(opts, sasl.TLSState(*tlsState))
+	}
+
+	if server {
+		err = serve(rw, mech, username, password, opts)
+		if err != nil {
+			logger.Fatalf("authentication failed: %v", err)
+		}
+		logger.Print("authentication succeeded")
+		return
+	}
+
+	opts = append(opts, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte(username), []byte(password), []byte(authzid)
+	}))
+	n := sasl.NewClient(mech, opts...)
+	var codec sasl.Codec
+	switch proto {
+	case "raw":
+		codec = &rawCodec{r: bufio.NewReader(rw)}
+	case "imap":
+		codec = imapsasl.NewCodec(rw, tag, mech.Name, true)
+	case "pop3":
+		codec = pop3sasl.NewCodec(rw, mech.Name)
+	case "nntp":
+		codec = nntpsasl.NewCodec(rw, mech.Name)
+	case "sieve":
+		codec = sievesasl.NewCodec(rw, mech.Name)
+	default:
+		logger.Fatalf("unknown framing %q", proto)
+	}
+	if err = sasl.NegotiateConn(context.Background(), rw, n, codec); err != nil {
+		logger.Fatalf("authentication failed: %v", err)
+	}
+	logger.Printf("authentication succeeded (server verified: %t)", n.VerifiedServer())
+}
+
+func mechanismNames() []string {
+	names := make([]string, 0, len(mechanisms))
+	for name := range mechanisms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dial returns the connection to use for the exchange and the TLS state, if
+// any.
+func dial(connect, listen string, useTLS, insecure bool, certFile, keyFile string) (io.ReadWriter, *tls.ConnectionState, error) {
+	var conn net.Conn
+	switch {
+	case connect != "" && listen != "":
+		return nil, nil, errors.New("-connect and -listen cannot be used together")
+	case connect != "":
+		var err error
+		conn, err = net.Dial("tcp", connect)
+		if err != nil {
+			return nil, nil, err
+		}
+		if useTLS {
+			host, _, _ := net.SplitHostPort(connect)
+			conn = tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: insecure})
+		}
+	case listen != "":
+		ln, err := net.Listen("tcp", listen)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer ln.Close()
+		conn, err = ln.Accept()
+		if err != nil {
+			return nil, nil, err
+		}
+		if useTLS {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				conn.Close()
+				return nil, nil, err
+			}
+			conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+		}
+	default:
+		if useTLS {
+			return nil, nil, errors.New("-tls requires -connect or -listen")
+		}
+		return struct {
+			io.Reader
+			io.Writer
+		}{Reader: os.Stdin, Writer: os.Stdout}, nil, nil
+	}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return conn, nil, nil
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	state := tlsConn.ConnectionState()
+	return conn, &state, nil
+}
+
+// trace wraps m so that every step is printed to l.
+func trace(m sasl.Mechanism, l *log.Logger, showSecrets bool) sasl.Mechanism {
+	start, next := m.Start, m.Next
+	m.Start = func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+		more, resp, cache, err := start(n)
+		printStep(l, m.Name, nil, resp, more, err, showSecrets)
+		return more, resp, cache, err
+	}
+	m.Next = func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+		more, resp, cache, err := next(n, challenge, data)
+		printStep(l, m.Name, challenge, resp, more, err, showSecrets)
+		return more, resp, cache, err
+	}
+	return m
+}
+
+func printStep(l *log.Logger, mech string, challenge, resp []byte, more bool, err error, showSecrets bool) {
+	if mech == sasl.Plain.Name && !showSecrets {
+		challenge, resp = redact(challenge), redact(resp)
+	}
+	if challenge != nil {
+		l.Printf("<- %q", challenge)
+	}
+	if err != nil {
+		l.Printf("!! %v", err)
+		return
+	}
+	l.Printf("-> %q (more: %t)", resp, more)
+}
+
+// redact replaces the password in a PLAIN message.
+func redact(msg []byte) []byte {
+	i := bytes.LastIndexByte(msg, 0)
+	if i == -1 {
+		return msg
+	}
+	return append(msg[:i+1:i+1], "[REDACTED]"...)
+}
+
+// serve runs a server negotiation using the raw framing.
+func serve(rw io.ReadWriter, mech sasl.Mechanism, username, password string, opts []sasl.Option) error {
+	if h, ok := sasl.ScramHash(mech.Name); ok {
+		opts = append(opts, sasl.Store(store{
+			username: sasl.DeriveStoredCredentials(h, []byte(password), []byte("sasl diagnostic salt"), 4096),
+		}))
+	}
+	n := sasl.NewServer(mech, func(n *sasl.Negotiator) bool {
+		user, pass, _ := n.Credentials()
+		if mech.Name != sasl.Plain.Name {
+			return string(user) == username
+		}
+		return string(user) == username && sasl.ConstantTimeEqual(pass, []byte(password))
+	}, opts...)
+
+	r := bufio.NewReader(rw)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		resp, err := decode(strings.TrimRight(line, "\r\n"))
+		if err != nil {
+			fmt.Fprint(rw, "NO malformed response\r\n")
+			return err
+		}
+		more, challenge, err := n.Step(resp)
+		switch {
+		case err != nil:
+			fmt.Fprint(rw, "NO authentication failed\r\n")
+			return err
+		case !more && len(challenge) == 0:
+			_, err = fmt.Fprint(rw, "OK\r\n")
+			return err
+		case !more:
+			_, err = fmt.Fprintf(rw, "OK %s\r\n", encode(challenge))
+			return err
+		}
+		if _, err = fmt.Fprintf(rw, "+ %s\r\n", encode(challenge)); err != nil {
+			return err
+		}
+	}
+}
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+// rawCodec is the client side of the raw framing.
+type rawCodec struct {
+	r *bufio.Reader
+}
+
+func (c *rawCodec) WriteResponse(w io.Writer, resp []byte) error {
+	_, err := fmt.Fprintf(w, "%s\r\n", encode(resp))
+	return err
+}
+
+func (c *rawCodec) ReadChallenge(io.Reader) ([]byte, bool, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	status, data, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+	switch status {
+	case "+":
+		challenge, err := decode(data)
+		return challenge, false, err
+	case "OK":
+		challenge, err := decode(data)
+		return challenge, true, err
+	case "NO":
+		return nil, false, fmt.Errorf("%w: %s", sasl.ErrAuthn, data)
+	}
+	return nil, false, fmt.Errorf("unexpected line %q", line)
+}
+
+func encode(b []byte) string {
+	if len(b) == 0 {
+		return "="
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	if s == "=" || s == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
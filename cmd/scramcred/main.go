@@ -0,0 +1,146 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// The scramcred command generates the SCRAM credentials that a server stores
+// for a password, for provisioning user databases.
+//
+// The password is read from the first line of stdin so that it does not show
+// up in the process list or shell history.
+// The credentials are written to stdout in one of the following formats
+// (see -format):
+//
+//	raw       one attribute per line: salt and the keys in base64, and the
+//	          iteration count
+//	postgres  a PostgreSQL verifier, suitable for the rolpassword column:
+//	          SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+//	dovecot   a Dovecot password scheme:
+//	          {SCRAM-SHA-256}<iterations>,<salt>,<StoredKey>,<ServerKey>
+//
+// Usage:
+//
+//	scramcred [options] < password
+//
+// Run scramcred -h for a list of options.
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jh125486/sasl"
+)
+
+func main() {
+	var (
+		mechName = sasl.ScramSha256.Name
+		iter     = 4096
+		saltLen  = 16
+		salt     string
+		format   = "raw"
+		noPrep   bool
+	)
+	flags := flag.NewFlagSet("scramcred", flag.ExitOnError)
+	flags.StringVar(&mechName, "mech", mechName, "the SCRAM mechanism the credentials are for")
+	flags.IntVar(&iter, "iter", iter, "the iteration count")
+	flags.IntVar(&saltLen, "saltlen", saltLen, "the length of the random salt in bytes")
+	flags.StringVar(&salt, "salt", salt, "use this base64 encoded salt instead of a random one")
+	flags.StringVar(&format, "format", format, "the output format, one of raw, postgres, dovecot")
+	flags.BoolVar(&noPrep, "nosaslprep", noPrep, "do not normalize the password with SASLprep")
+	flags.Parse(os.Args[1:])
+
+	logger := log.New(os.Stderr, "", 0)
+	name := strings.ToUpper(mechName)
+	h, ok := sasl.ScramHash(name)
+	if !ok {
+		logger.Fatalf("unknown SCRAM mechanism %q", mechName)
+	}
+	name = sasl.TrimPlus(name)
+	if iter < 1 {
+		logger.Fatalf("invalid iteration count %d", iter)
+	}
+
+	var saltBytes []byte
+	if salt != "" {
+		var err error
+		saltBytes, err = base64.StdEncoding.DecodeString(salt)
+		if err != nil || len(saltBytes) == 0 {
+			logger.Fatalf("invalid salt %q", salt)
+		}
+	} else {
+		if saltLen < 1 {
+			logger.Fatalf("invalid salt length %d", saltLen)
+		}
+		saltBytes = make([]byte, saltLen)
+		if _, err := rand.Read(saltBytes); err != nil {
+			logger.Fatalf("error generating salt: %v", err)
+		}
+	}
+
+	password, err := readPassword(os.Stdin)
+	if err != nil {
+		logger.Fatalf("error reading password: %v", err)
+	}
+	if !noPrep {
+		password, err = sasl.SASLprep(password)
+		if err != nil {
+			logger.Fatalf("error normalizing password: %v", err)
+		}
+	}
+
+	creds := sasl.DeriveStoredCredentials(h, password, saltBytes, iter)
+	out, err := formatCredentials(format, name, creds)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	fmt.Println(out)
+}
+
+// readPassword returns the first line of r without the line ending.
+func readPassword(r io.Reader) ([]byte, error) {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+	}
+	if len(line) == 0 {
+		return nil, errors.New("empty password")
+	}
+	return line, nil
+}
+
+// formatCredentials encodes creds for the SCRAM mechanism name (without the
+// -PLUS suffix) in the named format.
+func formatCredentials(format, name string, creds sasl.StoredCredentials) (string, error) {
+	b64 := base64.StdEncoding.EncodeToString
+	switch format {
+	case "raw":
+		return "mechanism=" + name +
+			"\nsalt=" + b64(creds.Salt) +
+			"\niterations=" + strconv.Itoa(creds.Iterations) +
+			"\nstored-key=" + b64(creds.StoredKey) +
+			"\nserver-key=" + b64(creds.ServerKey), nil
+	case "postgres":
+		if name != sasl.ScramSha256.Name {
+			return "", fmt.Errorf("PostgreSQL only supports %s", sasl.ScramSha256.Name)
+		}
+		return fmt.Sprintf("%s$%d:%s$%s:%s", name, creds.Iterations, b64(creds.Salt), b64(creds.StoredKey), b64(creds.ServerKey)), nil
+	case "dovecot":
+		return fmt.Sprintf("{%s}%d,%s,%s,%s", name, creds.Iterations, b64(creds.Salt), b64(creds.StoredKey), b64(creds.ServerKey)), nil
+	}
+	return "", fmt.Errorf("unknown format %q", format)
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"errors"
+)
+
+// A Code is a stable, machine-readable identifier for a class of errors.
+// Unlike error messages, codes never change between releases, so they can be
+// used in metrics and alerts and compared across services written in other
+// languages.
+type Code string
+
+// Codes for the errors returned by this package.
+const (
+	CodeUnknown               Code = "unknown"
+	CodeInvalidState          Code = "invalid-state"
+	CodeInvalidChallenge      Code = "invalid-challenge"
+	CodeAuthn                 Code = "authentication-failed"
+	CodeTooManySteps          Code = "too-many-steps"
+	CodeMessageTooLarge       Code = "message-too-large"
+	CodeUnknownUser           Code = "unknown-user"
+	CodeServerSignature       Code = "server-signature"
+	CodeMutualAuth            Code = "mutual-auth-required"
+	CodeFIPS                  Code = "fips"
+	CodeSecretMismatch        Code = "secret-mismatch"
+	CodeStepTimeout           Code = "step-timeout"
+	CodeConcurrentStep        Code = "concurrent-step"
+	CodeMechanismNotSupported Code = "mechanism-not-supported"
+	CodeDowngrade             Code = "downgrade"
+	CodeLockedMemory          Code = "locked-memory"
+	CodeRealm                 Code = "realm"
+	CodeQOP                   Code = "qop"
+	CodeServerKeyChanged      Code = "server-key-changed"
+	CodeSecretNotFound        Code = "secret-not-found"
+	CodeBusy                  Code = "busy"
+	CodeRedacted              Code = "redacted"
+	CodeAuthzID               Code = "invalid-authzid"
+	CodeAborted               Code = "aborted"
+	CodeReplayMismatch        Code = "replay-mismatch"
+	CodeInvalidTransition     Code = "invalid-transition"
+	CodeChannelBinding        Code = "channel-binding-mismatch"
+	CodeNonceMismatch         Code = "nonce-mismatch"
+	CodeInvalidNonce          Code = "invalid-nonce"
+	CodeInsecureTransport     Code = "insecure-transport"
+	CodeInvalidCredential     Code = "invalid-credential"
+	CodeIterationCount        Code = "iteration-count"
+	CodeTemporary             Code = "temporary"
+)
+
+// codes maps sentinel errors to their codes.
+// ErrAuthn is handled separately because many other errors wrap it.
+var codes = []struct {
+	err  error
+	code Code
+}{
+	{err: ErrInvalidState, code: CodeInvalidState},
+	{err: ErrInvalidChallenge, code: CodeInvalidChallenge},
+	{err: ErrTooManySteps, code: CodeTooManySteps},
+	{err: ErrMessageTooLarge, code: CodeMessageTooLarge},
+	{err: ErrUnknownUser, code: CodeUnknownUser},
+	{err: ErrServerSignature, code: CodeServerSignature},
+	{err: ErrMutualAuth, code: CodeMutualAuth},
+	{err: ErrFIPS, code: CodeFIPS},
+	{err: ErrSecretMismatch, code: CodeSecretMismatch},
+	{err: ErrStepTimeout, code: CodeStepTimeout},
+	{err: ErrConcurrentStep, code: CodeConcurrentStep},
+	{err: ErrMechanismNotSupported, code: CodeMechanismNotSupported},
+	{err: ErrDowngrade, code: CodeDowngrade},
+	{err: ErrLockedMemory, code: CodeLockedMemory},
+	{err: ErrRealm, code: CodeRealm},
+	{err: ErrQOP, code: CodeQOP},
+	{err: ErrServerKeyChanged, code: CodeServerKeyChanged},
+	{err: ErrSecretNotFound, code: CodeSecretNotFound},
+	{err: ErrBusy, code: CodeBusy},
+	{err: ErrRedacted, code: CodeRedacted},
+	{err: ErrAuthzID, code: CodeAuthzID},
+	{err: ErrAborted, code: CodeAborted},
+	{err: errChannelBinding, code: CodeChannelBinding},
+}
+
+// ErrorCode returns the code for err.
+//
+// If an error in the tree of err has a method "Code() sasl.Code", as do the
+// error types in this package, its code is returned.
+// Otherwise the code of the first error in the tree that is one of the errors
+// defined by this package is returned, falling back to CodeTemporary for
+// other temporary errors (see IsTemporary), CodeAuthn for errors that wrap
+// ErrAuthn (such as the errors returned by the protocol packages when the
+// server rejects the credentials), and CodeUnknown for everything else.
+// If err is nil ErrorCode returns the empty string.
+func ErrorCode(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var coder interface{ Code() Code }
+	if errors.As(err, &coder) {
+		return coder.Code()
+	}
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	switch {
+	case IsTemporary(err):
+		return CodeTemporary
+	case errors.Is(err, ErrAuthn):
+		return CodeAuthn
+	}
+	return CodeUnknown
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type busyError struct{}
+
+func (busyError) Error() string   { return "busy" }
+func (busyError) Unwrap() error   { return ErrAuthn }
+func (busyError) Temporary() bool { return true }
+
+func TestErrorCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code Code
+	}{
+		{err: nil, code: ""},
+		{err: errors.New("other"), code: CodeUnknown},
+		{err: ErrAuthn, code: CodeAuthn},
+		{err: fmt.Errorf("imap: %w", ErrAuthn), code: CodeAuthn},
+		{err: ErrInvalidChallenge, code: CodeInvalidChallenge},
+		{err: fmt.Errorf("wrapped: %w", ErrDowngrade), code: CodeDowngrade},
+		{err: ErrStepTimeout, code: CodeStepTimeout},
+		{err: errChannelBinding, code: CodeChannelBinding},
+		{err: Temporary(errors.New("store unavailable")), code: CodeTemporary},
+		{err: context.DeadlineExceeded, code: CodeTemporary},
+		{err: busyError{}, code: CodeTemporary},
+		{err: InsecureTransportError{Mechanism: "PLAIN"}, code: CodeInsecureTransport},
+		{err: CredentialError{Field: "username", Reason: "is too long"}, code: CodeInvalidCredential},
+		{err: IterationCountError{Iterations: 1, Min: 4096}, code: CodeIterationCount},
+		{err: NonceError{Mismatch: true}, code: CodeNonceMismatch},
+		{err: NonceError{Reason: "is too short"}, code: CodeInvalidNonce},
+		{err: ScramInvalidProof, code: "scram-invalid-proof"},
+		{err: ScramUnknownUser, code: "scram-unknown-user"},
+		{err: ScramError("made-up"), code: "scram-other-error"},
+		{err: errors.Join(errors.New("other"), ErrQOP), code: CodeQOP},
+	} {
+		if code := ErrorCode(tc.err); code != tc.code {
+			t.Errorf("ErrorCode(%v): want=%q, got=%q", tc.err, tc.code, code)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"log/slog"
+	"slices"
+)
+
+// Config is an immutable set of options that can be shared by any number of
+// negotiators, including ones used concurrently from different goroutines, for
+// example by a connection pool.
+// The options are applied once when the Config is built instead of every time
+// a negotiator is created, and because nothing can modify a Config after it
+// has been built its settings can be inspected safely at any time.
+//
+// The zero Config has the default settings.
+type Config struct {
+	tmpl *Negotiator
+}
+
+// A ConfigBuilder collects options and builds a Config from them.
+// The zero value is ready to use.
+type ConfigBuilder struct {
+	opts []Option
+}
+
+// With adds options to the builder and returns it so that calls can be
+// chained.
+// Options are applied in the order they were added, so later options override
+// earlier ones.
+func (b *ConfigBuilder) With(opts ...Option) *ConfigBuilder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// Build returns a Config with the options added so far.
+// The builder can continue to be used afterwards without affecting the
+// returned Config.
+func (b *ConfigBuilder) Build() Config {
+	tmpl := &Negotiator{}
+	getOpts(tmpl, b.opts...)
+	return Config{tmpl: tmpl}
+}
+
+// template returns the negotiator that holds the settings of cfg.
+func (cfg Config) template() *Negotiator {
+	if cfg.tmpl == nil {
+		return new(ConfigBuilder).Build().tmpl
+	}
+	return cfg.tmpl
+}
+
+// With returns a new Config with opts applied on top of the settings of cfg.
+func (cfg Config) With(opts ...Option) Config {
+	tmpl := new(Negotiator)
+	*tmpl = *cfg.template()
+	for _, o := range opts {
+		o(tmpl)
+	}
+	return Config{tmpl: tmpl}
+}
+
+// NewClient is like the package level NewClient function except that it uses
+// the settings of cfg.
+// Opts are applied after the settings of cfg and should be used for settings
+// that are specific to the connection, such as TLSState.
+func (cfg Config) NewClient(m Mechanism, opts ...Option) *Negotiator {
+	machine := cfg.newNegotiator(m, false, opts)
+	machine.debug("selected client mechanism", slog.Bool("remote_cb", machine.flags.RemoteSupportsCB()))
+	return machine
+}
+
+// NewServer is like the package level NewServer function except that it uses
+// the settings of cfg.
+// If permissions is nil the permissions function set by the Permissions
+// option (if any) is used.
+func (cfg Config) NewServer(m Mechanism, permissions func(*Negotiator) bool, opts ...Option) *Negotiator {
+	if permissions != nil {
+		opts = append(opts[:len(opts):len(opts)], func(n *Negotiator) { n.permissions = permissions })
+	}
+	machine := cfg.newNegotiator(m, true, opts)
+	machine.debug("selected server mechanism", slog.Bool("remote_cb", machine.flags.RemoteSupportsCB()))
+	return machine
+}
+
+func (cfg Config) newNegotiator(m Mechanism, server bool, opts []Option) *Negotiator {
+	machine := new(Negotiator)
+	*machine = *cfg.template()
+	machine.mechanism = m
+	machine.isServer = server
+	machine.applyScoped()
+	for _, o := range opts {
+		o(machine)
+	}
+	machine.applyWorkarounds()
+	machine.nonce = machine.newNonce()
+	machine.negotiationID = machine.newNegotiationID()
+	machine.setRemoteCB()
+	return machine
+}
+
+// Config returns the settings of the negotiator as a Config, for example so
+// that a mechanism can inspect them or so that a negotiator that was
+// configured with options can be used as a template for others.
+func (c *Negotiator) Config() Config {
+	tmpl := c.Clone()
+	tmpl.mechanism = Mechanism{}
+	tmpl.flags = 0
+	tmpl.isServer = false
+	tmpl.nonce = nil
+	return Config{tmpl: tmpl}
+}
+
+// MaxMessageSize returns the largest challenge or response that will be
+// accepted, or a value of zero or less if there is no limit.
+func (cfg Config) MaxMessageSize() int {
+	return cfg.template().maxMessageSize
+}
+
+// Iterations returns the smallest and largest SCRAM iteration counts that
+// clients will accept.
+// A max of 0 means that there is no upper limit.
+func (cfg Config) Iterations() (min, max int) {
+	t := cfg.template()
+	return t.minIterations, t.maxIterations
+}
+
+// Limits returns the limits on fields parsed from messages (see FieldLimits)
+// as set, without replacing zero values with the defaults.
+func (cfg Config) Limits() Limits {
+	return cfg.template().limits
+}
+
+// FIPS reports whether only FIPS approved mechanisms are allowed.
+func (cfg Config) FIPS() bool {
+	return cfg.template().fips
+}
+
+// Confidential reports whether the transport is considered confidential (see
+// the Confidential option).
+func (cfg Config) Confidential() bool {
+	return !cfg.template().insecure
+}
+
+// RequireMutualAuth reports whether clients require the server to be
+// authenticated (see the RequireMutualAuth option).
+func (cfg Config) RequireMutualAuth() bool {
+	return cfg.template().requireMutual
+}
+
+// RemoteMechanisms returns the mechanisms advertised by the remote side.
+func (cfg Config) RemoteMechanisms() []string {
+	return slices.Clone(cfg.template().remoteMechanisms)
+}
+
+// PreferredMechanisms returns the mechanism names set with the
+// PreferMechanisms option.
+func (cfg Config) PreferredMechanisms() []string {
+	return slices.Clone(cfg.template().mechPrefs)
+}
+
+// Service returns the service name set with the Service option.
+func (cfg Config) Service() string {
+	return cfg.template().service
+}
+
+// Host returns the host name set with the Host option.
+func (cfg Config) Host() string {
+	return cfg.template().host
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	var zero Config
+	if min, max := zero.Iterations(); min != DefaultMinIterations || max != DefaultMaxIterations {
+		t.Errorf("Zero config has wrong iteration limits: min=%d, max=%d", min, max)
+	}
+	if !zero.Confidential() || zero.FIPS() != fipsBuild || zero.MaxMessageSize() != DefaultMaxMessageSize {
+		t.Error("Zero config does not have the default settings")
+	}
+
+	b := new(ConfigBuilder).With(MinIterations(10000), RemoteMechanisms("SCRAM-SHA-256", "PLAIN"))
+	cfg := b.Build()
+	b.With(MaxMessageSize(100))
+	if cfg.MaxMessageSize() != DefaultMaxMessageSize {
+		t.Error("Options added to the builder after Build changed the config")
+	}
+	if min, _ := cfg.Iterations(); min != 10000 {
+		t.Errorf("Unexpected min iterations: want=10000, got=%d", min)
+	}
+
+	mechs := cfg.RemoteMechanisms()
+	mechs[0] = "EXTERNAL"
+	if got, want := cfg.RemoteMechanisms(), []string{"SCRAM-SHA-256", "PLAIN"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Config was modified through returned slice: want=%v, got=%v", want, got)
+	}
+
+	derived := cfg.With(Confidential(false))
+	if !cfg.Confidential() || derived.Confidential() {
+		t.Error("With modified the original config")
+	}
+	if min, _ := derived.Iterations(); min != 10000 {
+		t.Errorf("With did not keep the original settings: min=%d", min)
+	}
+
+	client := cfg.NewClient(Plain, Confidential(false))
+	if client.State() != Initial || !client.insecure || !cfg.Confidential() {
+		t.Error("Connection options were not applied to the client only")
+	}
+	if got := client.Config().RemoteMechanisms(); !reflect.DeepEqual(got, []string{"SCRAM-SHA-256", "PLAIN"}) {
+		t.Errorf("Unexpected remote mechanisms from negotiator config: %v", got)
+	}
+}
+
+func TestConfigConcurrent(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	server := new(ConfigBuilder).With(Store(store)).Build()
+	client := new(ConfigBuilder).With(scramClientOpts...).Build()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- negotiate(client.NewClient(ScramSha256), server.NewServer(ScramSha256, acceptAll))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
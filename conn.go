@@ -0,0 +1,98 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// A Codec frames SASL messages for a particular protocol so that NegotiateConn
+// can drive a client negotiation over a connection.
+type Codec interface {
+	// WriteResponse writes a response to the server.
+	// The first response written is the initial response, which is nil if the
+	// mechanism does not send one and empty (but not nil) if it sends an empty
+	// initial response.
+	WriteResponse(w io.Writer, resp []byte) error
+
+	// ReadChallenge reads the next message from the server.
+	// If the message indicates that authentication succeeded done is true and
+	// challenge contains any additional data sent with the success message.
+	// If the server indicated that authentication failed an error should be
+	// returned.
+	ReadChallenge(r io.Reader) (challenge []byte, done bool, err error)
+}
+
+// An Aborter is a Codec that can tell the server that the client is canceling
+// the exchange.
+// If the codec passed to NegotiateConn implements Aborter, Abort is called
+// when the negotiator returns an error after the exchange has started.
+type Aborter interface {
+	Abort(w io.Writer) error
+}
+
+// A Base64Codec is a Codec that decodes base64 encoded challenges.
+// If the codec passed to NegotiateConn implements Base64Codec, SetDecoder is
+// called with the negotiator's DecodeBase64 method before the exchange starts
+// so that the codec respects the TolerantBase64 option.
+type Base64Codec interface {
+	Codec
+	SetDecoder(decode func(string) ([]byte, error))
+}
+
+// NegotiateConn drives the client negotiation n over rw using codec to frame
+// messages until the server indicates success or an error occurs.
+//
+// If the negotiation completes successfully but the mechanism did not finish
+// (for example, because the server did not send a SCRAM server signature) an
+// error is returned.
+// If rw has a SetDeadline method it is used to abort blocking reads and writes
+// when ctx is canceled or its deadline expires.
+func NegotiateConn(ctx context.Context, rw io.ReadWriter, n *Negotiator, codec Codec) (err error) {
+	if conn, ok := rw.(interface{ SetDeadline(time.Time) error }); ok {
+		stop := context.AfterFunc(ctx, func() {
+			conn.SetDeadline(time.Unix(1, 0))
+		})
+		defer func() {
+			if !stop() && err != nil {
+				err = ctx.Err()
+			}
+			conn.SetDeadline(time.Time{})
+		}()
+	}
+
+	if c, ok := codec.(Base64Codec); ok {
+		c.SetDecoder(n.DecodeBase64)
+	}
+
+	var challenge, resp []byte
+	for started := false; ; started = true {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if _, resp, err = n.StepContext(ctx, challenge); err != nil {
+			if a, ok := codec.(Aborter); ok && started {
+				a.Abort(rw)
+			}
+			return err
+		}
+		if err = codec.WriteResponse(rw, resp); err != nil {
+			return err
+		}
+
+		var done bool
+		challenge, done, err = codec.ReadChallenge(rw)
+		if err != nil {
+			return err
+		}
+		if done {
+			// The server has already finished the exchange so there is nothing to
+			// abort.
+			return n.Finish(challenge)
+		}
+	}
+}
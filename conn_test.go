@@ -0,0 +1,171 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lineCodec is a simple protocol where each message is a line containing a
+// base64 encoded payload, the server prefixes challenges with "+ " and
+// indicates success with "OK " and failure with "NO".
+type lineCodec struct {
+	r *bufio.Reader
+}
+
+func (lineCodec) WriteResponse(w io.Writer, resp []byte) error {
+	_, err := io.WriteString(w, base64.StdEncoding.EncodeToString(resp)+"\n")
+	return err
+}
+
+func (c lineCodec) ReadChallenge(r io.Reader) ([]byte, bool, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	switch {
+	case strings.HasPrefix(line, "+ "):
+		b, err := base64.StdEncoding.DecodeString(line[2:])
+		return b, false, err
+	case strings.HasPrefix(line, "OK "):
+		b, err := base64.StdEncoding.DecodeString(line[3:])
+		return b, true, err
+	}
+	return nil, false, errors.New("authentication failed")
+}
+
+// serveLines runs the server side of the line protocol over conn.
+// If final is true the last challenge is sent with the success message.
+func serveLines(conn net.Conn, server *Negotiator, final bool) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		resp, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			io.WriteString(conn, "NO\n")
+			return
+		}
+		if server.Completed() {
+			io.WriteString(conn, "OK \n")
+			return
+		}
+		more, challenge, err := server.Step(resp)
+		switch {
+		case err != nil:
+			io.WriteString(conn, "NO\n")
+			return
+		case !more && (final || len(challenge) == 0):
+			io.WriteString(conn, "OK "+base64.StdEncoding.EncodeToString(challenge)+"\n")
+			return
+		}
+		io.WriteString(conn, "+ "+base64.StdEncoding.EncodeToString(challenge)+"\n")
+	}
+}
+
+func TestNegotiateConn(t *testing.T) {
+	store := mapStore{
+		"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096),
+	}
+	for _, tc := range []struct {
+		name     string
+		mech     Mechanism
+		password string
+		final    bool
+		fail     bool
+	}{
+		{name: "plain", mech: Plain, password: "pencil"},
+		{name: "scram", mech: ScramSha256, password: "pencil"},
+		{name: "scram-final-with-success", mech: ScramSha256, password: "pencil", final: true},
+		{name: "scram-bad-password", mech: ScramSha256, password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			server := NewServer(tc.mech, func(n *Negotiator) bool {
+				user, pass, _ := n.Credentials()
+				return tc.mech.Name != Plain.Name || (string(user) == "user" && string(pass) == "pencil")
+			}, Store(store))
+			go serveLines(serverConn, server, tc.final)
+
+			client := NewClient(tc.mech, Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(tc.password), nil
+			}))
+			err := NegotiateConn(context.Background(), clientConn, client, lineCodec{r: bufio.NewReader(clientConn)})
+			switch {
+			case tc.fail && err == nil:
+				t.Error("Expected negotiation to fail")
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNegotiateConnCanceled(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	// Read the initial response but never reply.
+	go io.Copy(io.Discard, serverConn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	client := NewClient(Plain, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	err := NegotiateConn(ctx, clientConn, client, lineCodec{r: bufio.NewReader(clientConn)})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error: want=%v, got=%v", context.DeadlineExceeded, err)
+	}
+}
+
+type abortCodec struct {
+	lineCodec
+	aborted bool
+}
+
+func (c *abortCodec) Abort(w io.Writer) error {
+	c.aborted = true
+	_, err := io.WriteString(w, "*\n")
+	return err
+}
+
+func TestNegotiateConnAbort(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		r := bufio.NewReader(serverConn)
+		r.ReadString('\n')
+		// Send a server-first-message with a nonce that does not match.
+		io.WriteString(serverConn, "+ "+base64.StdEncoding.EncodeToString([]byte("r=abc,s=c2FsdA==,i=4096"))+"\n")
+		r.ReadString('\n')
+	}()
+
+	client := NewClient(ScramSha256, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	codec := &abortCodec{lineCodec: lineCodec{r: bufio.NewReader(clientConn)}}
+	if err := NegotiateConn(context.Background(), clientConn, client, codec); err == nil {
+		t.Fatal("Expected negotiation to fail")
+	}
+	if !codec.aborted {
+		t.Error("Expected exchange to be aborted")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+// ctxStore is a ContextCredentialStore that blocks until its context is done
+// if block is set.
+type ctxStore struct {
+	mapStore
+	block bool
+	got   chan context.Context
+}
+
+func (s ctxStore) ScramCredentialsContext(ctx context.Context, mechanism string, username []byte) (StoredCredentials, error) {
+	s.got <- ctx
+	if s.block {
+		<-ctx.Done()
+		return StoredCredentials{}, ctx.Err()
+	}
+	return s.ScramCredentials(mechanism, username)
+}
+
+func TestStepContext(t *testing.T) {
+	store := ctxStore{
+		mapStore: mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)},
+		got:      make(chan context.Context, 1),
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "conn-1")
+
+	var permitted interface{}
+	client := NewClient(ScramSha256, scramClientOpts...)
+	server := NewServer(ScramSha256, func(n *Negotiator) bool {
+		permitted = n.Context().Value(ctxKey{})
+		return true
+	}, Store(store))
+	var challenge []byte
+	for {
+		clientMore, resp, err := client.Step(challenge)
+		if err != nil {
+			t.Fatalf("Unexpected client error: %v", err)
+		}
+		if server.Completed() {
+			break
+		}
+		var serverMore bool
+		serverMore, challenge, err = server.StepContext(ctx, resp)
+		if err != nil {
+			t.Fatalf("Unexpected server error: %v", err)
+		}
+		if !serverMore && !clientMore {
+			break
+		}
+	}
+	if got := (<-store.got).Value(ctxKey{}); got != "conn-1" {
+		t.Errorf("Credential store did not get the step context: %v", got)
+	}
+	if permitted != "conn-1" {
+		t.Errorf("Permissions callback did not get the step context: %v", permitted)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	client = NewClient(ScramSha256, scramClientOpts...)
+	if _, _, err := client.StepContext(canceled, nil); err != context.Canceled || !client.State().Errored() {
+		t.Errorf("Expected context.Canceled and errored state, got %v", err)
+	}
+}
+
+func TestNegotiationTimeout(t *testing.T) {
+	store := ctxStore{
+		mapStore: mapStore{},
+		block:    true,
+		got:      make(chan context.Context, 1),
+	}
+	client := NewClient(ScramSha256, scramClientOpts...)
+	server := NewServer(ScramSha256, acceptAll, Store(store), NegotiationTimeout(20*time.Millisecond))
+	_, resp, _ := client.Step(nil)
+
+	start := time.Now()
+	_, _, err := server.Step(resp)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Step took too long to give up: %v", d)
+	}
+	if _, ok := (<-store.got).Deadline(); !ok {
+		t.Error("Expected credential store context to have a deadline")
+	}
+	server.Reset()
+	if server.Context() != context.Background() {
+		t.Error("Expected Reset to discard the step context")
+	}
+}
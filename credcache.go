@@ -0,0 +1,241 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxCachedUsers is the maximum number of users whose results are kept by a
+// CredentialCache.
+const maxCachedUsers = 10000
+
+// A CredentialCache caches the results of credential store lookups and
+// password checks so that bursts of reconnects, for example after a network
+// outage, do not overload the user database.
+//
+// Successful lookups are cached for the positive TTL and failures (unknown
+// users and wrong passwords) for the negative TTL; temporary failures (see
+// IsTemporary) and other errors are never cached.
+// Usernames and passwords are only kept as keyed hashes.
+// Applications that change, lock, or delete a user must call Forget so that
+// the old credentials stop being accepted before the TTL expires.
+//
+// A CredentialCache is safe for concurrent use and should be shared between
+// negotiations.
+type CredentialCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	key         []byte
+	now         func() time.Time
+
+	mu    sync.Mutex
+	users map[[sha256.Size]byte]*cachedUser
+	// gen is incremented by Forget and Purge so that results of lookups that
+	// were in progress at the time are not cached.
+	gen uint64
+}
+
+type cachedUser struct {
+	creds     map[string]cachedCreds
+	passwords map[[sha256.Size]byte]cachedResult
+}
+
+type cachedCreds struct {
+	creds StoredCredentials
+	cachedResult
+}
+
+type cachedResult struct {
+	err     error
+	expires time.Time
+}
+
+// NewCredentialCache returns a cache that keeps successful results for ttl and
+// failures for negativeTTL.
+// A TTL of zero disables caching of that kind of result.
+func NewCredentialCache(ttl, negativeTTL time.Duration) *CredentialCache {
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(entropy, key); err != nil {
+		panic("sasl: failed to generate credential cache key: " + err.Error())
+	}
+	return &CredentialCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		key:         key,
+		now:         time.Now,
+	}
+}
+
+// Store returns a credential store that caches the results of s.
+func (c *CredentialCache) Store(s CredentialStore) CredentialStore {
+	return cachedStore{cache: c, store: s}
+}
+
+// Verifier returns a password verifier that caches the results of v.
+func (c *CredentialCache) Verifier(v PasswordVerifier) PasswordVerifier {
+	return PasswordVerifierFunc(func(ctx context.Context, username, password []byte) error {
+		userKey := c.hash(username)
+		pwKey := c.hash(binary.BigEndian.AppendUint32(nil, uint32(len(username))), username, password)
+		now := c.now()
+
+		c.mu.Lock()
+		gen := c.gen
+		if u := c.users[userKey]; u != nil {
+			if r, ok := u.passwords[pwKey]; ok && now.Before(r.expires) {
+				c.mu.Unlock()
+				return r.err
+			}
+		}
+		c.mu.Unlock()
+
+		err := v.VerifyPassword(ctx, username, password)
+		if ttl := c.resultTTL(err, ErrAuthn); ttl > 0 {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if gen != c.gen {
+				return err
+			}
+			u := c.user(userKey, now)
+			if u.passwords == nil {
+				u.passwords = make(map[[sha256.Size]byte]cachedResult)
+			}
+			u.passwords[pwKey] = cachedResult{err: err, expires: now.Add(ttl)}
+		}
+		return err
+	})
+}
+
+// Forget removes all cached results for username.
+func (c *CredentialCache) Forget(username []byte) {
+	key := c.hash(username)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	delete(c.users, key)
+}
+
+// Purge removes all cached results.
+func (c *CredentialCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	clear(c.users)
+}
+
+// resultTTL returns how long err may be cached, where failure is the error
+// that indicates a cacheable failure.
+func (c *CredentialCache) resultTTL(err, failure error) time.Duration {
+	switch {
+	case err == nil:
+		return c.ttl
+	case errors.Is(err, failure) && !IsTemporary(err):
+		return c.negativeTTL
+	}
+	return 0
+}
+
+// user returns the entry for the user with the hashed name key, creating it if
+// necessary.
+// It must be called with c.mu held.
+func (c *CredentialCache) user(key [sha256.Size]byte, now time.Time) *cachedUser {
+	if u := c.users[key]; u != nil {
+		return u
+	}
+	if c.users == nil {
+		c.users = make(map[[sha256.Size]byte]*cachedUser)
+	}
+	if len(c.users) >= maxCachedUsers {
+		c.evict(now)
+	}
+	u := &cachedUser{}
+	c.users[key] = u
+	return u
+}
+
+// evict removes users with no unexpired results, or all users if that does not
+// free any space.
+// It must be called with c.mu held.
+func (c *CredentialCache) evict(now time.Time) {
+	for k, u := range c.users {
+		live := false
+		for _, r := range u.creds {
+			live = live || now.Before(r.expires)
+		}
+		for _, r := range u.passwords {
+			live = live || now.Before(r.expires)
+		}
+		if !live {
+			delete(c.users, k)
+		}
+	}
+	if len(c.users) >= maxCachedUsers {
+		clear(c.users)
+	}
+}
+
+func (c *CredentialCache) hash(parts ...[]byte) [sha256.Size]byte {
+	var sum [sha256.Size]byte
+	mac := hmac.New(sha256.New, c.key)
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	mac.Sum(sum[:0])
+	return sum
+}
+
+// cachedStore is the credential store returned by CredentialCache.Store.
+type cachedStore struct {
+	cache *CredentialCache
+	store CredentialStore
+}
+
+func (s cachedStore) ScramCredentials(mechanism string, username []byte) (StoredCredentials, error) {
+	return s.ScramCredentialsContext(context.Background(), mechanism, username)
+}
+
+func (s cachedStore) ScramCredentialsContext(ctx context.Context, mechanism string, username []byte) (StoredCredentials, error) {
+	c := s.cache
+	key := c.hash(username)
+	now := c.now()
+
+	c.mu.Lock()
+	gen := c.gen
+	if u := c.users[key]; u != nil {
+		if r, ok := u.creds[mechanism]; ok && now.Before(r.expires) {
+			c.mu.Unlock()
+			return r.creds, r.err
+		}
+	}
+	c.mu.Unlock()
+
+	var creds StoredCredentials
+	var err error
+	if cs, ok := s.store.(ContextCredentialStore); ok {
+		creds, err = cs.ScramCredentialsContext(ctx, mechanism, username)
+	} else {
+		creds, err = s.store.ScramCredentials(mechanism, username)
+	}
+	if ttl := c.resultTTL(err, ErrUnknownUser); ttl > 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if gen != c.gen {
+			return creds, err
+		}
+		u := c.user(key, now)
+		if u.creds == nil {
+			u.creds = make(map[string]cachedCreds)
+		}
+		u.creds[mechanism] = cachedCreds{creds: creds, cachedResult: cachedResult{err: err, expires: now.Add(ttl)}}
+	}
+	return creds, err
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingStore counts lookups in a mapStore.
+type countingStore struct {
+	mapStore
+	calls int
+	err   error
+}
+
+func (s *countingStore) ScramCredentials(mechanism string, username []byte) (StoredCredentials, error) {
+	s.calls++
+	if s.err != nil {
+		return StoredCredentials{}, s.err
+	}
+	return s.mapStore.ScramCredentials(mechanism, username)
+}
+
+func TestCredentialCacheStore(t *testing.T) {
+	store := &countingStore{mapStore: mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}}
+	cache := NewCredentialCache(time.Minute, time.Second)
+	now := time.Unix(1000, 0)
+	cache.now = func() time.Time { return now }
+	cached := cache.Store(store)
+
+	for i := 0; i < 2; i++ {
+		if err := negotiate(NewClient(ScramSha256, scramClientOpts...), NewServer(ScramSha256, acceptAll, Store(cached))); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := cached.ScramCredentials("SCRAM-SHA-256", []byte("nobody")); !errors.Is(err, ErrUnknownUser) {
+			t.Fatalf("Expected ErrUnknownUser, got %v", err)
+		}
+	}
+	if store.calls != 2 {
+		t.Errorf("Expected results to be cached, got %d lookups", store.calls)
+	}
+
+	now = now.Add(time.Second)
+	cached.ScramCredentials("SCRAM-SHA-256", []byte("nobody"))
+	cached.ScramCredentials("SCRAM-SHA-256", []byte("user"))
+	if store.calls != 3 {
+		t.Errorf("Expected only the negative result to expire, got %d lookups", store.calls)
+	}
+
+	cache.Forget([]byte("user"))
+	cached.ScramCredentials("SCRAM-SHA-256", []byte("user"))
+	if store.calls != 4 {
+		t.Errorf("Expected forgotten user to be looked up again, got %d lookups", store.calls)
+	}
+
+	store.err = Temporary(errors.New("database unavailable"))
+	cache.Purge()
+	for i := 0; i < 2; i++ {
+		if _, err := cached.ScramCredentials("SCRAM-SHA-256", []byte("user")); !IsTemporary(err) {
+			t.Fatalf("Expected temporary error, got %v", err)
+		}
+	}
+	if store.calls != 6 {
+		t.Errorf("Expected temporary failures not to be cached, got %d lookups", store.calls)
+	}
+}
+
+func TestCredentialCacheVerifier(t *testing.T) {
+	var calls int
+	cache := NewCredentialCache(time.Minute, time.Minute)
+	v := cache.Verifier(PasswordVerifierFunc(func(_ context.Context, _, password []byte) error {
+		calls++
+		if string(password) != "pencil" {
+			return ErrAuthn
+		}
+		return nil
+	}))
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := v.VerifyPassword(ctx, []byte("user"), []byte("pencil")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := v.VerifyPassword(ctx, []byte("user"), []byte("pen")); !errors.Is(err, ErrAuthn) {
+			t.Fatalf("Expected ErrAuthn, got %v", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected results to be cached, got %d calls", calls)
+	}
+	cache.Forget([]byte("user"))
+	v.VerifyPassword(ctx, []byte("user"), []byte("pencil"))
+	if calls != 3 {
+		t.Errorf("Expected forgotten user to be checked again, got %d calls", calls)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build cgo && sasl_cyrus
+
+package cyrussasl
+
+/*
+#cgo LDFLAGS: -lsasl2
+#include <stdlib.h>
+#include <sasl/sasl.h>
+
+static sasl_interact_t *interact_at(sasl_interact_t *p, int i) {
+	return &p[i];
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/jh125486/sasl"
+)
+
+var (
+	clientInit, serverInit       sync.Once
+	clientInitErr, serverInitErr error
+)
+
+// Error is returned when a libsasl2 call fails.
+type Error struct {
+	// Code is the libsasl2 result code, for example -13 (SASL_BADAUTH).
+	Code int
+
+	// Detail is the error detail returned by sasl_errdetail, if any.
+	Detail string
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return "cyrussasl: " + e.Detail
+	}
+	return "cyrussasl: " + C.GoString(C.sasl_errstring(C.int(e.Code), nil, nil))
+}
+
+// Unwrap returns sasl.ErrAuthn if libsasl2 reported that authentication
+// failed.
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case C.SASL_BADAUTH, C.SASL_NOAUTHZ, C.SASL_NOUSER, C.SASL_EXPIRED, C.SASL_DISABLED:
+		return sasl.ErrAuthn
+	}
+	return nil
+}
+
+// Temporary reports whether libsasl2 reported a temporary failure.
+func (e *Error) Temporary() bool {
+	return e.Code == C.SASL_TRYAGAIN || e.Code == C.SASL_UNAVAIL
+}
+
+// ErrInteraction is returned if a client plugin asks for a value that cannot
+// be provided from the negotiator's credentials.
+var ErrInteraction = errors.New("cyrussasl: plugin requested an unsupported interaction")
+
+// conn wraps a libsasl2 connection and the C strings that must remain valid
+// until it is disposed.
+type conn struct {
+	c       *C.sasl_conn_t
+	strings []*C.char
+}
+
+func (c *conn) dispose() {
+	for _, s := range c.strings {
+		C.free(unsafe.Pointer(s))
+	}
+	c.strings = nil
+	if c.c != nil {
+		C.sasl_dispose(&c.c)
+		c.c = nil
+	}
+	runtime.SetFinalizer(c, nil)
+}
+
+func (c *conn) cstring(s string) *C.char {
+	if s == "" {
+		return nil
+	}
+	cs := C.CString(s)
+	c.strings = append(c.strings, cs)
+	return cs
+}
+
+func (c *conn) err(code C.int) error {
+	e := &Error{Code: int(code)}
+	if c.c != nil {
+		e.Detail = C.GoString(C.sasl_errdetail(c.c))
+	}
+	return e
+}
+
+// empty is passed to libsasl2 for empty messages.
+var empty [1]byte
+
+// bytesArg returns a pointer to and the length of b for passing to libsasl2.
+// A nil slice is passed as a NULL pointer so that libsasl2 can distinguish a
+// missing message from an empty one.
+func bytesArg(b []byte) (*C.char, C.uint) {
+	if b == nil {
+		return nil, 0
+	}
+	if len(b) == 0 {
+		return (*C.char)(unsafe.Pointer(&empty[0])), 0
+	}
+	return (*C.char)(unsafe.Pointer(&b[0])), C.uint(len(b))
+}
+
+func goBytes(p *C.char, n C.uint) []byte {
+	if p == nil {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(p), C.int(n))
+}
+
+// Client returns a mechanism that authenticates using the named libsasl2
+// client plugin.
+// The username, password, and authorization identity requested by the plugin
+// are taken from the negotiator's credentials.
+func Client(mechanism string) sasl.Mechanism {
+	return sasl.Mechanism{
+		Name: mechanism,
+		Start: func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+			clientInit.Do(func() {
+				if code := C.sasl_client_init(nil); code != C.SASL_OK {
+					clientInitErr = &Error{Code: int(code)}
+				}
+			})
+			if clientInitErr != nil {
+				return false, nil, nil, clientInitErr
+			}
+
+			c := &conn{}
+			runtime.SetFinalizer(c, (*conn).dispose)
+			service := c.cstring(n.Service())
+			fqdn := c.cstring(n.ServerFQDN())
+			if code := C.sasl_client_new(service, fqdn, nil, nil, nil, 0, &c.c); code != C.SASL_OK {
+				err := c.err(code)
+				c.dispose()
+				return false, nil, nil, err
+			}
+			mech := c.cstring(mechanism)
+
+			var prompts *C.sasl_interact_t
+			var out *C.char
+			var outlen C.uint
+			var chosen *C.char
+			for {
+				code := C.sasl_client_start(c.c, mech, &prompts, &out, &outlen, &chosen)
+				if code == C.SASL_INTERACT {
+					if err := c.interact(n, prompts); err != nil {
+						c.dispose()
+						return false, nil, nil, err
+					}
+					continue
+				}
+				if code != C.SASL_OK && code != C.SASL_CONTINUE {
+					err := c.err(code)
+					c.dispose()
+					return false, nil, nil, err
+				}
+				resp := goBytes(out, outlen)
+				if code == C.SASL_OK {
+					c.dispose()
+					return false, resp, nil, nil
+				}
+				return true, resp, c, nil
+			}
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			c, ok := data.(*conn)
+			if !ok || c.c == nil {
+				return false, nil, nil, sasl.ErrInvalidState
+			}
+			in, inlen := bytesArg(challenge)
+			var prompts *C.sasl_interact_t
+			var out *C.char
+			var outlen C.uint
+			for {
+				code := C.sasl_client_step(c.c, in, inlen, &prompts, &out, &outlen)
+				runtime.KeepAlive(challenge)
+				if code == C.SASL_INTERACT {
+					if err := c.interact(n, prompts); err != nil {
+						c.dispose()
+						return false, nil, nil, err
+					}
+					continue
+				}
+				if code != C.SASL_OK && code != C.SASL_CONTINUE {
+					err := c.err(code)
+					c.dispose()
+					return false, nil, nil, err
+				}
+				resp := goBytes(out, outlen)
+				if code == C.SASL_OK {
+					c.dispose()
+					return false, resp, nil, nil
+				}
+				return true, resp, c, nil
+			}
+		},
+	}
+}
+
+// interact fills in the values requested by a client plugin.
+func (c *conn) interact(n *sasl.Negotiator, prompts *C.sasl_interact_t) error {
+	username, password, identity := n.Credentials()
+	for i := 0; ; i++ {
+		p := C.interact_at(prompts, C.int(i))
+		var v []byte
+		switch p.id {
+		case C.SASL_CB_LIST_END:
+			return nil
+		case C.SASL_CB_AUTHNAME:
+			v = username
+		case C.SASL_CB_PASS:
+			v = password
+		case C.SASL_CB_USER:
+			v = identity
+		case C.SASL_CB_GETREALM:
+			realm, err := n.SelectRealm(nil)
+			if err != nil {
+				return err
+			}
+			v = []byte(realm)
+		default:
+			return ErrInteraction
+		}
+		cs := C.CString(string(v))
+		c.strings = append(c.strings, cs)
+		p.result = unsafe.Pointer(cs)
+		p.len = C.uint(len(v))
+	}
+}
+
+// Server returns a mechanism that authenticates clients using the named
+// libsasl2 server plugin.
+// appName is used by libsasl2 to find its configuration file (appName.conf).
+// libsasl2 is only initialized once per process, so the appName passed to the
+// first server that is used applies to all of them.
+//
+// Once libsasl2 has authenticated the user, the username it reports is passed
+// to the negotiator's permissions callback (along with the authorization
+// identity, if the client requested one) so that the application can make the
+// final authorization decision.
+func Server(appName, mechanism string) sasl.Mechanism {
+	return sasl.Mechanism{
+		Name: mechanism,
+		Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+			return false, nil, nil, sasl.ErrInvalidState
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			c, _ := data.(*conn)
+			var code C.int
+			var out *C.char
+			var outlen C.uint
+			in, inlen := bytesArg(challenge)
+			if c == nil {
+				serverInit.Do(func() {
+					// libsasl2 may keep a reference to the name, so it is never freed.
+					name := C.CString(appName)
+					if code := C.sasl_server_init(nil, name); code != C.SASL_OK {
+						serverInitErr = &Error{Code: int(code)}
+					}
+				})
+				if serverInitErr != nil {
+					return false, nil, nil, serverInitErr
+				}
+
+				c = &conn{}
+				runtime.SetFinalizer(c, (*conn).dispose)
+				realm, err := n.SelectRealm(nil)
+				if err != nil {
+					return false, nil, nil, err
+				}
+				service := c.cstring(n.Service())
+				fqdn := c.cstring(n.ServerFQDN())
+				userRealm := c.cstring(realm)
+				if code = C.sasl_server_new(service, fqdn, userRealm, nil, nil, nil, 0, &c.c); code != C.SASL_OK {
+					err := c.err(code)
+					c.dispose()
+					return false, nil, nil, err
+				}
+				code = C.sasl_server_start(c.c, c.cstring(mechanism), in, inlen, &out, &outlen)
+			} else {
+				if c.c == nil {
+					return false, nil, nil, sasl.ErrInvalidState
+				}
+				code = C.sasl_server_step(c.c, in, inlen, &out, &outlen)
+			}
+			runtime.KeepAlive(challenge)
+
+			switch code {
+			case C.SASL_CONTINUE:
+				return true, goBytes(out, outlen), c, nil
+			case C.SASL_OK:
+			default:
+				err := c.err(code)
+				c.dispose()
+				return false, nil, nil, err
+			}
+
+			resp := goBytes(out, outlen)
+			username, identity := c.prop(C.SASL_AUTHUSER), c.prop(C.SASL_USERNAME)
+			if string(identity) == string(username) {
+				identity = nil
+			}
+			c.dispose()
+			username, err := n.CanonicalUsername(username)
+			if err != nil {
+				return false, nil, nil, err
+			}
+			if !n.Permissions(sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return username, nil, identity
+			})) {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, resp, nil, nil
+		},
+	}
+}
+
+// prop returns a string property of the connection.
+func (c *conn) prop(num C.int) []byte {
+	var v unsafe.Pointer
+	if C.sasl_getprop(c.c, num, &v) != C.SASL_OK || v == nil {
+		return nil
+	}
+	return []byte(C.GoString((*C.char)(v)))
+}
@@ -0,0 +1,18 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package cyrussasl exposes mechanisms implemented by Cyrus SASL (libsasl2)
+// plugins as sasl.Mechanism values so that deployments relying on plugins
+// without a Go implementation can migrate incrementally.
+//
+// The package uses cgo and is only built when the sasl_cyrus build tag is set,
+// for example:
+//
+//	go build -tags sasl_cyrus
+//
+// The libsasl2 development headers and library must be installed.
+// The service name and host of the negotiator (see sasl.Service, sasl.Host,
+// and sasl.ServerFQDN) are passed to libsasl2 when each connection is created,
+// and servers use the realm set with sasl.Realm as the user realm.
+package cyrussasl
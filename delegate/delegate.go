@@ -0,0 +1,183 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package delegate implements sasl.PasswordVerifier by forwarding the
+// credentials received by PLAIN servers to an upstream authority, such as an
+// LDAP directory or an HTTP endpoint, so that servers can front existing
+// identity systems.
+//
+// The backends (see LDAP and HTTP) check each password with the authority and
+// New wraps one of them with a timeout and a cache so that a busy server does
+// not contact the authority for every login.
+// A RADIUS backend is provided by the radius subpackage.
+package delegate // import "github.com/jh125486/sasl/delegate"
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jh125486/sasl"
+)
+
+// Defaults used by New.
+const (
+	// DefaultTimeout is how long the backend has to check a password.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultCacheTTL is how long the result of checking a password is reused.
+	DefaultCacheTTL = 5 * time.Minute
+
+	// DefaultMaxEntries is the maximum number of cached results.
+	DefaultMaxEntries = 10000
+)
+
+// Option configures a Verifier.
+type Option func(*Verifier)
+
+// Timeout sets how long the backend has to check each password, after which
+// the context passed to it is canceled.
+// A timeout of zero only uses the deadline of the negotiation, if any.
+// The default is DefaultTimeout.
+func Timeout(d time.Duration) Option {
+	return func(v *Verifier) {
+		v.timeout = d
+	}
+}
+
+// CacheTTL sets how long results are cached.
+// A TTL of zero disables the cache so that password changes take effect
+// immediately.
+// The default is DefaultCacheTTL.
+func CacheTTL(d time.Duration) Option {
+	return func(v *Verifier) {
+		v.ttl = d
+	}
+}
+
+// MaxEntries sets the maximum number of cached results.
+// The default is DefaultMaxEntries.
+func MaxEntries(n int) Option {
+	return func(v *Verifier) {
+		v.maxEntries = n
+	}
+}
+
+// Clock sets the function used to get the current time.
+// The default is time.Now.
+func Clock(now func() time.Time) Option {
+	return func(v *Verifier) {
+		v.now = now
+	}
+}
+
+// Verifier checks passwords with a backend, applying a timeout and caching the
+// results.
+// It is safe for concurrent use and should be shared between negotiations so
+// that results are cached.
+type Verifier struct {
+	backend    sasl.PasswordVerifier
+	timeout    time.Duration
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	// key is a random key used to derive cache keys so that the cache does not
+	// hold anything that could be used to guess the passwords.
+	key []byte
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]entry
+}
+
+type entry struct {
+	err     error
+	expires time.Time
+}
+
+// New returns a verifier that checks passwords with backend.
+func New(backend sasl.PasswordVerifier, opts ...Option) *Verifier {
+	v := &Verifier{
+		backend:    backend,
+		timeout:    DefaultTimeout,
+		ttl:        DefaultCacheTTL,
+		maxEntries: DefaultMaxEntries,
+		now:        time.Now,
+		key:        make([]byte, sha256.Size),
+	}
+	if _, err := rand.Read(v.key); err != nil {
+		panic("delegate: failed to generate cache key: " + err.Error())
+	}
+	for _, o := range opts {
+		o(v)
+	}
+	return v
+}
+
+var _ sasl.PasswordVerifier = (*Verifier)(nil)
+
+// VerifyPassword implements sasl.PasswordVerifier.
+// Both accepted and rejected passwords are cached, but temporary failures (see
+// sasl.IsTemporary) and other errors are not.
+func (v *Verifier) VerifyPassword(ctx context.Context, username, password []byte) error {
+	key := v.cacheKey(username, password)
+	now := v.now()
+
+	v.mu.Lock()
+	e, ok := v.cache[key]
+	v.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.err
+	}
+
+	if v.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.timeout)
+		defer cancel()
+	}
+	err := v.backend.VerifyPassword(ctx, username, password)
+	if err == nil || (errors.Is(err, sasl.ErrAuthn) && !sasl.IsTemporary(err)) {
+		v.store(key, entry{err: err, expires: now.Add(v.ttl)}, now)
+	}
+	return err
+}
+
+func (v *Verifier) cacheKey(username, password []byte) [sha256.Size]byte {
+	var key [sha256.Size]byte
+	mac := hmac.New(sha256.New, v.key)
+	// The username is length prefixed so that different splits of the same
+	// bytes do not collide.
+	mac.Write(binary.BigEndian.AppendUint32(nil, uint32(len(username))))
+	mac.Write(username)
+	mac.Write(password)
+	mac.Sum(key[:0])
+	return key
+}
+
+func (v *Verifier) store(key [sha256.Size]byte, e entry, now time.Time) {
+	if v.ttl <= 0 || v.maxEntries <= 0 {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cache == nil {
+		v.cache = make(map[[sha256.Size]byte]entry)
+	}
+	if len(v.cache) >= v.maxEntries {
+		for k, old := range v.cache {
+			if !now.Before(old.expires) {
+				delete(v.cache, k)
+			}
+		}
+		if len(v.cache) >= v.maxEntries {
+			clear(v.cache)
+		}
+	}
+	v.cache[key] = e
+}
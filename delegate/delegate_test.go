@@ -0,0 +1,85 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package delegate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/delegate"
+)
+
+// counter is a backend that accepts the password "pencil" and counts calls.
+type counter struct {
+	calls int
+	err   error
+}
+
+func (c *counter) VerifyPassword(_ context.Context, _, password []byte) error {
+	c.calls++
+	if c.err != nil {
+		return c.err
+	}
+	if string(password) != "pencil" {
+		return sasl.ErrAuthn
+	}
+	return nil
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	backend := &counter{}
+	v := delegate.New(backend, delegate.CacheTTL(time.Minute), delegate.Clock(func() time.Time { return now }))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := v.VerifyPassword(ctx, []byte("user"), []byte("pencil")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := v.VerifyPassword(ctx, []byte("user"), []byte("pen")); !errors.Is(err, sasl.ErrAuthn) {
+			t.Fatalf("Expected ErrAuthn, got %v", err)
+		}
+	}
+	if backend.calls != 2 {
+		t.Errorf("Expected results to be cached, got %d calls", backend.calls)
+	}
+	// Splitting the same bytes differently must not hit the cache.
+	if err := v.VerifyPassword(ctx, []byte("userp"), []byte("encil")); !errors.Is(err, sasl.ErrAuthn) {
+		t.Errorf("Expected ErrAuthn, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	v.VerifyPassword(ctx, []byte("user"), []byte("pencil"))
+	if backend.calls != 4 {
+		t.Errorf("Expected expired result to be checked again, got %d calls", backend.calls)
+	}
+}
+
+func TestTemporaryNotCached(t *testing.T) {
+	backend := &counter{err: sasl.Temporary(errors.New("down"))}
+	v := delegate.New(backend)
+	for i := 0; i < 2; i++ {
+		if err := v.VerifyPassword(context.Background(), []byte("user"), []byte("pencil")); !sasl.IsTemporary(err) {
+			t.Fatalf("Expected temporary error, got %v", err)
+		}
+	}
+	if backend.calls != 2 {
+		t.Errorf("Expected temporary failures not to be cached, got %d calls", backend.calls)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	v := delegate.New(sasl.PasswordVerifierFunc(func(ctx context.Context, _, _ []byte) error {
+		<-ctx.Done()
+		return sasl.Temporary(ctx.Err())
+	}), delegate.Timeout(time.Millisecond))
+	err := v.VerifyPassword(context.Background(), []byte("user"), []byte("pencil"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline to be exceeded, got %v", err)
+	}
+}
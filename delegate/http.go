@@ -0,0 +1,54 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package delegate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jh125486/sasl"
+)
+
+// HTTP returns a backend that checks passwords by sending them to endpoint in
+// a form encoded POST request with the fields "username" and "password".
+// If client is nil http.DefaultClient is used.
+//
+// Any 2xx status accepts the password and 401 or 403 rejects it.
+// Server errors and 429 are temporary failures (see sasl.IsTemporary), and any
+// other status is an error.
+func HTTP(endpoint string, client *http.Client) sasl.PasswordVerifier {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return sasl.PasswordVerifierFunc(func(ctx context.Context, username, password []byte) error {
+		form := url.Values{"username": {string(username)}, "password": {string(password)}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return sasl.Temporary(err)
+		}
+		// Drain the body so that the connection can be reused.
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		switch code := resp.StatusCode; {
+		case code >= 200 && code < 300:
+			return nil
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			return sasl.ErrAuthn
+		case code >= 500 || code == http.StatusTooManyRequests:
+			return sasl.Temporary(errors.New("delegate: endpoint returned status " + resp.Status))
+		}
+		return errors.New("delegate: endpoint returned status " + resp.Status)
+	})
+}
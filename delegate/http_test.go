@@ -0,0 +1,62 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package delegate_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/delegate"
+)
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.PostFormValue("password") {
+		case "pencil":
+			if r.PostFormValue("username") != "user" {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		case "busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "teapot":
+			w.WriteHeader(http.StatusTeapot)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	v := delegate.HTTP(srv.URL, srv.Client())
+	for _, tc := range []struct {
+		password  string
+		err       error
+		temporary bool
+	}{
+		{password: "pencil"},
+		{password: "pen", err: sasl.ErrAuthn},
+		{password: "busy", temporary: true},
+		{password: "teapot"},
+	} {
+		t.Run(tc.password, func(t *testing.T) {
+			err := v.VerifyPassword(context.Background(), []byte("user"), []byte(tc.password))
+			switch {
+			case tc.temporary:
+				if !sasl.IsTemporary(err) {
+					t.Errorf("Expected temporary error, got %v", err)
+				}
+			case tc.password == "teapot":
+				if err == nil || errors.Is(err, sasl.ErrAuthn) || sasl.IsTemporary(err) {
+					t.Errorf("Expected permanent error, got %v", err)
+				}
+			case !errors.Is(err, tc.err):
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
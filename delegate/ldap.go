@@ -0,0 +1,129 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package delegate
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/ldapsasl"
+)
+
+// maxLDAPResponse is the largest BindResponse that will be read.
+const maxLDAPResponse = 64 << 10
+
+var errLDAPResponse = errors.New("delegate: malformed LDAP response")
+
+// LDAP returns a backend that checks passwords with a simple bind to the LDAP
+// server at addr (a host and port), using a new connection for each password.
+// The dn function maps usernames to the distinguished name to bind as, for
+// example by inserting the username escaped with EscapeDN into a template.
+//
+// If config is not nil the connection uses TLS (LDAPS), otherwise the
+// password is sent in the clear, which should only be done over a trusted
+// network.
+// Empty passwords are always rejected since the server would treat the bind
+// as an unauthenticated bind and report success (RFC 4513 §5.1.2).
+func LDAP(addr string, config *tls.Config, dn func(username string) string) sasl.PasswordVerifier {
+	return sasl.PasswordVerifierFunc(func(ctx context.Context, username, password []byte) error {
+		if len(password) == 0 {
+			return sasl.ErrAuthn
+		}
+		var d interface {
+			DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+		} = &net.Dialer{}
+		if config != nil {
+			d = &tls.Dialer{Config: config}
+		}
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return sasl.Temporary(err)
+		}
+		defer conn.Close()
+		stop := context.AfterFunc(ctx, func() {
+			conn.SetDeadline(time.Unix(1, 0))
+		})
+		defer stop()
+
+		if _, err = conn.Write(ldapsasl.MarshalSimpleBindRequest(1, dn(string(username)), password)); err != nil {
+			return sasl.Temporary(err)
+		}
+		msg, err := readLDAPMessage(bufio.NewReader(conn))
+		if err != nil {
+			return sasl.Temporary(err)
+		}
+		_, resp, err := ldapsasl.UnmarshalBindResponse(msg)
+		if err != nil {
+			return err
+		}
+		switch resp.ResultCode {
+		case ldapsasl.Success:
+			return nil
+		case ldapsasl.InvalidCredentials:
+			return sasl.ErrAuthn
+		}
+		return &ldapsasl.ResultError{ResultCode: resp.ResultCode, DiagnosticMessage: resp.DiagnosticMessage}
+	})
+}
+
+// readLDAPMessage reads one BER encoded LDAPMessage.
+func readLDAPMessage(r *bufio.Reader) ([]byte, error) {
+	hdr := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	l := int(hdr[1])
+	if l&0x80 != 0 {
+		n := l &^ 0x80
+		if n == 0 || n > 3 {
+			return nil, errLDAPResponse
+		}
+		hdr = hdr[:2+n]
+		if _, err := io.ReadFull(r, hdr[2:]); err != nil {
+			return nil, err
+		}
+		l = 0
+		for _, c := range hdr[2:] {
+			l = l<<8 | int(c)
+		}
+	}
+	if l > maxLDAPResponse {
+		return nil, errLDAPResponse
+	}
+	msg := make([]byte, len(hdr)+l)
+	copy(msg, hdr)
+	if _, err := io.ReadFull(r, msg[len(hdr):]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// EscapeDN escapes s for use as an attribute value in a distinguished name
+// (RFC 4514 §2.4) so that usernames cannot change the structure of the name
+// that is bound to.
+func EscapeDN(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == 0:
+			b.WriteString(`\00`)
+			continue
+		case strings.IndexByte(`"+,;<>\=`, c) != -1,
+			c == ' ' && (i == 0 || i == len(s)-1),
+			c == '#' && i == 0:
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package delegate_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/delegate"
+	"github.com/jh125486/sasl/ldapsasl"
+)
+
+// serveLDAP answers simple binds for cn=user with the password "pencil".
+func serveLDAP(t *testing.T, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			want := ldapsasl.MarshalSimpleBindRequest(1, "cn=user", []byte("pencil"))
+			// The requests are small enough to arrive in a single read.
+			got := make([]byte, 512)
+			n, err := conn.Read(got)
+			if err != nil {
+				t.Errorf("Error reading bind request: %v", err)
+				return
+			}
+			got = got[:n]
+			code := byte(ldapsasl.InvalidCredentials)
+			if bytes.Equal(got, want) {
+				code = ldapsasl.Success
+			}
+			conn.Write([]byte{
+				0x30, 0x0c, // LDAPMessage
+				0x02, 0x01, 0x01, // messageID
+				0x61, 0x07, // BindResponse
+				0x0a, 0x01, code, // resultCode
+				0x04, 0x00, // matchedDN
+				0x04, 0x00, // diagnosticMessage
+			})
+		}()
+	}
+}
+
+func TestLDAP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+	go serveLDAP(t, l)
+
+	v := delegate.LDAP(l.Addr().String(), nil, func(username string) string {
+		return "cn=" + delegate.EscapeDN(username)
+	})
+	ctx := context.Background()
+	if err := v.VerifyPassword(ctx, []byte("user"), []byte("pencil")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := v.VerifyPassword(ctx, []byte("user"), []byte("pen")); !errors.Is(err, sasl.ErrAuthn) {
+		t.Errorf("Expected ErrAuthn, got %v", err)
+	}
+	if err := v.VerifyPassword(ctx, []byte("user"), nil); !errors.Is(err, sasl.ErrAuthn) {
+		t.Errorf("Expected empty password to be rejected, got %v", err)
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	for in, want := range map[string]string{
+		"user":          "user",
+		"a,cn=admin":    `a\,cn\=admin`,
+		" #lead trail ": `\ #lead trail\ `,
+		"#x":            `\#x`,
+		"nul\x00":       `nul\00`,
+	} {
+		if got := delegate.EscapeDN(in); got != want {
+			t.Errorf("EscapeDN(%q): want=%q, got=%q", in, want, got)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package radius implements a sasl.PasswordVerifier that checks the passwords
+// received by PLAIN servers with a RADIUS server (RFC 2865) using PAP or CHAP,
+// as is common on ISP mail platforms.
+//
+// Requests always include a Message-Authenticator attribute (RFC 3579 §3.2)
+// and responses are only accepted if their Response Authenticator, and
+// Message-Authenticator if present, are valid for the shared secret.
+// The verifier can be wrapped with delegate.New to add caching.
+package radius // import "github.com/jh125486/sasl/delegate/radius"
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/jh125486/sasl"
+)
+
+// Errors returned by Client.
+var (
+	ErrChallenge  = errors.New("radius: server sent an Access-Challenge, which is not supported")
+	ErrNoResponse = errors.New("radius: no valid response from server")
+	ErrTooLong    = errors.New("radius: username, password, or NAS-Identifier is too long")
+)
+
+// Method is the way passwords are sent to the server.
+type Method uint8
+
+// Methods supported by Client.
+const (
+	// PAP sends the password hidden with the shared secret (RFC 2865 §5.2).
+	PAP Method = iota
+
+	// CHAP sends a CHAP response computed from the password (RFC 2865 §5.3),
+	// for servers that store passwords for CHAP.
+	CHAP
+)
+
+// Packet codes.
+const (
+	codeAccessRequest   = 1
+	codeAccessAccept    = 2
+	codeAccessReject    = 3
+	codeAccessChallenge = 11
+)
+
+// Attribute types.
+const (
+	attrUserName             = 1
+	attrUserPassword         = 2
+	attrCHAPPassword         = 3
+	attrNASIdentifier        = 32
+	attrMessageAuthenticator = 80
+)
+
+const (
+	headerSize    = 20
+	maxPacketSize = 4096
+	maxPassword   = 128
+	maxAttrValue  = 253
+)
+
+// Defaults used by New.
+const (
+	// DefaultRetries is the number of times a request is retransmitted.
+	DefaultRetries = 2
+
+	// DefaultRetryInterval is how long to wait for a response before
+	// retransmitting a request.
+	DefaultRetryInterval = 2 * time.Second
+
+	// DefaultNASIdentifier is the NAS-Identifier sent in each request.
+	DefaultNASIdentifier = "sasl"
+)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithMethod sets the method used to send passwords.
+// The default is PAP.
+func WithMethod(m Method) Option {
+	return func(c *Client) {
+		c.method = m
+	}
+}
+
+// NASIdentifier sets the NAS-Identifier sent in each request.
+// The default is DefaultNASIdentifier.
+func NASIdentifier(id string) Option {
+	return func(c *Client) {
+		c.nasID = id
+	}
+}
+
+// Retries sets the number of times a request is retransmitted if no response
+// is received within the retry interval.
+// The default is DefaultRetries.
+func Retries(n int) Option {
+	return func(c *Client) {
+		c.retries = n
+	}
+}
+
+// RetryInterval sets how long to wait for a response before retransmitting a
+// request.
+// The default is DefaultRetryInterval.
+func RetryInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.interval = d
+	}
+}
+
+// RequireMessageAuthenticator makes the client ignore responses that do not
+// include a Message-Authenticator, which protects against forged responses on
+// servers that support it.
+// By default a Message-Authenticator is checked if present.
+func RequireMessageAuthenticator() Option {
+	return func(c *Client) {
+		c.requireMA = true
+	}
+}
+
+// Client checks passwords with a RADIUS server.
+// It is safe for concurrent use.
+type Client struct {
+	addr      string
+	secret    []byte
+	method    Method
+	nasID     string
+	retries   int
+	interval  time.Duration
+	requireMA bool
+}
+
+// New returns a client that sends requests to the RADIUS server at addr (a
+// host and port, normally port 1812) using the shared secret.
+func New(addr string, secret []byte, opts ...Option) *Client {
+	c := &Client{
+		addr:     addr,
+		secret:   append([]byte(nil), secret...),
+		nasID:    DefaultNASIdentifier,
+		retries:  DefaultRetries,
+		interval: DefaultRetryInterval,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+var _ sasl.PasswordVerifier = (*Client)(nil)
+
+// VerifyPassword implements sasl.PasswordVerifier.
+// An Access-Accept accepts the password and an Access-Reject rejects it with
+// sasl.ErrAuthn.
+// If the server cannot be reached or does not respond the error is temporary
+// (see sasl.IsTemporary).
+func (c *Client) VerifyPassword(ctx context.Context, username, password []byte) error {
+	if len(password) > maxPassword || len(username) > maxAttrValue || len(c.nasID) > maxAttrValue {
+		return ErrTooLong
+	}
+	req, err := c.request(username, password)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", c.addr)
+	if err != nil {
+		return sasl.Temporary(err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	buf := make([]byte, maxPacketSize)
+	for try := 0; try <= c.retries; try++ {
+		if _, err = conn.Write(req); err != nil {
+			break
+		}
+		deadline, atCtxDeadline := time.Now().Add(c.interval), false
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline, atCtxDeadline = d, true
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			var n int
+			n, err = conn.Read(buf)
+			if err != nil {
+				break
+			}
+			code, ok := c.checkResponse(req, buf[:n])
+			if !ok {
+				// Ignore stray or forged packets and keep waiting for the response.
+				continue
+			}
+			switch code {
+			case codeAccessAccept:
+				return nil
+			case codeAccessReject:
+				return sasl.ErrAuthn
+			case codeAccessChallenge:
+				return ErrChallenge
+			}
+		}
+		var netErr net.Error
+		if atCtxDeadline && errors.As(err, &netErr) && netErr.Timeout() {
+			// The read can time out just before the context notices that its
+			// deadline has passed.
+			<-ctx.Done()
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return sasl.Temporary(ctxErr)
+		}
+	}
+	if err != nil {
+		return sasl.Temporary(errors.Join(ErrNoResponse, err))
+	}
+	return sasl.Temporary(ErrNoResponse)
+}
+
+// request returns an Access-Request for username and password.
+func (c *Client) request(username, password []byte) ([]byte, error) {
+	pkt := make([]byte, headerSize, 256)
+	pkt[0] = codeAccessRequest
+	// The identifier and Request Authenticator are random so that responses to
+	// concurrent and earlier requests are not mistaken for the response.
+	if _, err := rand.Read(pkt[1:2]); err != nil {
+		return nil, err
+	}
+	auth := pkt[4:headerSize]
+	if _, err := rand.Read(auth); err != nil {
+		return nil, err
+	}
+
+	pkt = appendAttr(pkt, attrUserName, username)
+	switch c.method {
+	case CHAP:
+		// The Request Authenticator is used as the CHAP challenge since no
+		// CHAP-Challenge attribute is sent.
+		var id [1]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return nil, err
+		}
+		h := md5.New()
+		h.Write(id[:])
+		h.Write(password)
+		h.Write(auth)
+		pkt = appendAttr(pkt, attrCHAPPassword, h.Sum(id[:]))
+	default:
+		pkt = appendAttr(pkt, attrUserPassword, hidePassword(password, c.secret, auth))
+	}
+	pkt = appendAttr(pkt, attrNASIdentifier, []byte(c.nasID))
+	pkt = appendAttr(pkt, attrMessageAuthenticator, make([]byte, md5.Size))
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+
+	mac := hmac.New(md5.New, c.secret)
+	mac.Write(pkt)
+	copy(pkt[len(pkt)-md5.Size:], mac.Sum(nil))
+	return pkt, nil
+}
+
+// checkResponse returns the code of resp and reports whether it is a valid
+// response to req.
+func (c *Client) checkResponse(req, resp []byte) (code byte, ok bool) {
+	if len(resp) < headerSize || resp[1] != req[1] {
+		return 0, false
+	}
+	length := int(binary.BigEndian.Uint16(resp[2:4]))
+	if length < headerSize || length > len(resp) {
+		return 0, false
+	}
+	resp = resp[:length]
+	reqAuth := req[4:headerSize]
+
+	h := md5.New()
+	h.Write(resp[:4])
+	h.Write(reqAuth)
+	h.Write(resp[headerSize:])
+	h.Write(c.secret)
+	if subtle.ConstantTimeCompare(h.Sum(nil), resp[4:headerSize]) != 1 {
+		return 0, false
+	}
+
+	off, valid := findAttr(resp[headerSize:], attrMessageAuthenticator)
+	switch {
+	case !valid || (off == -1 && c.requireMA):
+		return 0, false
+	case off != -1:
+		off += headerSize
+		ma := resp[off : off+int(resp[off-1])-2]
+		if len(ma) != md5.Size {
+			return 0, false
+		}
+		// The Message-Authenticator of a response is computed with the Request
+		// Authenticator in place of the Response Authenticator and with itself
+		// zeroed.
+		check := append([]byte(nil), resp...)
+		copy(check[4:headerSize], reqAuth)
+		clear(check[off : off+md5.Size])
+		mac := hmac.New(md5.New, c.secret)
+		mac.Write(check)
+		if !hmac.Equal(mac.Sum(nil), ma) {
+			return 0, false
+		}
+	}
+	return resp[0], true
+}
+
+// hidePassword hides password as described in RFC 2865 §5.2.
+func hidePassword(password, secret, auth []byte) []byte {
+	n := (len(password) + 15) / 16 * 16
+	if n == 0 {
+		n = 16
+	}
+	hidden := make([]byte, n)
+	copy(hidden, password)
+	prev := auth
+	for i := 0; i < n; i += 16 {
+		h := md5.New()
+		h.Write(secret)
+		h.Write(prev)
+		b := h.Sum(nil)
+		for j := range b {
+			hidden[i+j] ^= b[j]
+		}
+		prev = hidden[i : i+16]
+	}
+	return hidden
+}
+
+func appendAttr(pkt []byte, typ byte, value []byte) []byte {
+	pkt = append(pkt, typ, byte(2+len(value)))
+	return append(pkt, value...)
+}
+
+// findAttr returns the offset in attrs of the value of the first attribute of
+// type typ, or -1 if there is none.
+// If attrs is not a valid list of attributes valid is false.
+func findAttr(attrs []byte, typ byte) (off int, valid bool) {
+	off = -1
+	for i := 0; i < len(attrs); i += int(attrs[i+1]) {
+		if len(attrs)-i < 2 || attrs[i+1] < 2 || int(attrs[i+1]) > len(attrs)-i {
+			return -1, false
+		}
+		if attrs[i] == typ && off == -1 {
+			off = i + 2
+		}
+	}
+	return off, true
+}
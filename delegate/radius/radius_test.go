@@ -0,0 +1,188 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package radius_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/delegate/radius"
+)
+
+var secret = []byte("testing123")
+
+// longPassword is hidden in more than one block.
+const longPassword = "correct horse battery staple"
+
+// server is a minimal RADIUS server that accepts the user "user" with the
+// password "pencil", or longPassword with PAP.
+type server struct {
+	secret []byte
+	// noMA omits the Message-Authenticator from responses.
+	noMA bool
+	// silent drops all requests.
+	silent bool
+}
+
+func (s server) serve(t *testing.T, conn net.PacketConn) {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if s.silent {
+			continue
+		}
+		req := buf[:n]
+		code := byte(3)
+		if checkRequest(t, req) {
+			code = 2
+		}
+		conn.WriteTo(s.response(code, req), addr)
+	}
+}
+
+func (s server) response(code byte, req []byte) []byte {
+	resp := make([]byte, 20)
+	resp[0], resp[1] = code, req[1]
+	maOff := -1
+	if !s.noMA {
+		maOff = len(resp) + 2
+		resp = append(resp, 80, 18)
+		resp = append(resp, make([]byte, 16)...)
+	}
+	binary.BigEndian.PutUint16(resp[2:4], uint16(len(resp)))
+	copy(resp[4:20], req[4:20])
+	if maOff != -1 {
+		mac := hmac.New(md5.New, s.secret)
+		mac.Write(resp)
+		copy(resp[maOff:], mac.Sum(nil))
+	}
+	h := md5.New()
+	h.Write(resp)
+	h.Write(s.secret)
+	copy(resp[4:20], h.Sum(nil))
+	return resp
+}
+
+// checkRequest reports whether req carries the correct password for "user".
+func checkRequest(t *testing.T, req []byte) bool {
+	auth := req[4:20]
+	attrs := map[byte][]byte{}
+	for a := req[20:]; len(a) > 0; a = a[a[1]:] {
+		attrs[a[0]] = a[2:a[1]]
+	}
+	if string(attrs[1]) != "user" {
+		return false
+	}
+
+	ma := attrs[80]
+	check := bytes.Replace(req, ma, make([]byte, 16), 1)
+	mac := hmac.New(md5.New, secret)
+	mac.Write(check)
+	if !hmac.Equal(mac.Sum(nil), ma) {
+		t.Errorf("Invalid Message-Authenticator in request")
+		return false
+	}
+
+	if hidden, ok := attrs[2]; ok {
+		password := make([]byte, len(hidden))
+		prev := auth
+		for i := 0; i < len(hidden); i += 16 {
+			b := md5.Sum(append(append([]byte{}, secret...), prev...))
+			for j := range b {
+				password[i+j] = hidden[i+j] ^ b[j]
+			}
+			prev = hidden[i : i+16]
+		}
+		password = bytes.TrimRight(password, "\x00")
+		return string(password) == "pencil" || string(password) == longPassword
+	}
+	chap := attrs[3]
+	want := md5.Sum(append(append([]byte{chap[0]}, "pencil"...), auth...))
+	return bytes.Equal(chap[1:], want[:])
+}
+
+func listen(t *testing.T, s server) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve(t, conn)
+	return conn.LocalAddr().String()
+}
+
+func TestVerifyPassword(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		server   server
+		opts     []radius.Option
+		password string
+		err      error
+		temp     bool
+	}{
+		{name: "pap", server: server{secret: secret}, password: "pencil"},
+		{name: "pap reject", server: server{secret: secret}, password: "pen", err: sasl.ErrAuthn},
+		{name: "pap long", server: server{secret: secret}, password: longPassword},
+		{name: "nas identifier", server: server{secret: secret}, password: "pencil", opts: []radius.Option{radius.NASIdentifier("imap.example.net")}},
+		{name: "chap", server: server{secret: secret}, opts: []radius.Option{radius.WithMethod(radius.CHAP)}, password: "pencil"},
+		{name: "chap reject", server: server{secret: secret}, opts: []radius.Option{radius.WithMethod(radius.CHAP)}, password: "pen", err: sasl.ErrAuthn},
+		{name: "no message authenticator", server: server{secret: secret, noMA: true}, password: "pencil"},
+		{name: "require message authenticator", server: server{secret: secret, noMA: true}, opts: []radius.Option{radius.RequireMessageAuthenticator()}, password: "pencil", err: radius.ErrNoResponse, temp: true},
+		{name: "wrong secret", server: server{secret: []byte("forged")}, password: "pencil", err: radius.ErrNoResponse, temp: true},
+		{name: "silent", server: server{silent: true}, password: "pencil", err: radius.ErrNoResponse, temp: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := listen(t, tc.server)
+			opts := append([]radius.Option{radius.Retries(1), radius.RetryInterval(50 * time.Millisecond)}, tc.opts...)
+			err := radius.New(addr, secret, opts...).VerifyPassword(context.Background(), []byte("user"), []byte(tc.password))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if sasl.IsTemporary(err) != tc.temp {
+				t.Errorf("Unexpected temporary=%t for error %v", !tc.temp, err)
+			}
+		})
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	addr := listen(t, server{silent: true})
+	client := radius.New(addr, secret)
+	// The deadline is well before the first retry, so it is also the read
+	// deadline.
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		err := client.VerifyPassword(ctx, []byte("user"), []byte("pencil"))
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) || !sasl.IsTemporary(err) {
+			t.Fatalf("Expected deadline to be exceeded, got %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+	err := client.VerifyPassword(ctx, []byte("user"), []byte("pencil"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context to be canceled, got %v", err)
+	}
+}
+
+func TestTooLong(t *testing.T) {
+	err := radius.New("127.0.0.1:1812", secret).VerifyPassword(context.Background(), []byte("user"), make([]byte, 129))
+	if err != radius.ErrTooLong {
+		t.Errorf("Expected ErrTooLong, got %v", err)
+	}
+}
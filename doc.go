@@ -11,5 +11,18 @@
 // create a Mechanism struct which will likely use the other methods on the
 // Negotiator.
 //
+// All comparisons of secret values made by the mechanisms in this package
+// (such as checking a SCRAM client proof or server signature) run in constant
+// time.
+// Permissions functions and custom mechanisms that compare secrets should use
+// ConstantTimeEqual to do the same.
+//
+// Building with the sasl_tiny build tag leaves out the parts of the package
+// that pull in large dependencies or are not supported on embedded platforms
+// (for example, when building MQTT or XMPP clients with TinyGo): the PRECIS
+// option, the JSON encoding of transcripts, locked memory for secrets, and
+// crypto/rand, which must be replaced using SetEntropySource.
+// PLAIN and SCRAM work as usual.
+//
 // Be advised: This API is still unstable and is subject to change.
 package sasl // import "mellium.im/sasl"
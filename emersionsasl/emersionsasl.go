@@ -0,0 +1,141 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package emersionsasl adapts between the sasl package and the Client and
+// Server interfaces from github.com/emersion/go-sasl that are used by go-imap,
+// go-smtp, and much of the rest of the Go mail ecosystem.
+package emersionsasl
+
+import (
+	"errors"
+
+	gosasl "github.com/emersion/go-sasl"
+
+	"github.com/jh125486/sasl"
+)
+
+// Client returns a go-sasl client that authenticates using the client
+// negotiator n.
+// The negotiator is reset each time the client is started.
+func Client(n *sasl.Negotiator) gosasl.Client {
+	return client{n: n}
+}
+
+type client struct {
+	n *sasl.Negotiator
+}
+
+func (c client) Start() (string, []byte, error) {
+	c.n.Reset()
+	_, ir, err := c.n.Step(nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if ir == nil {
+		// An empty initial response must still be sent.
+		ir = []byte{}
+	}
+	return c.n.Mechanism().Name, ir, nil
+}
+
+func (c client) Next(challenge []byte) ([]byte, error) {
+	_, resp, err := c.n.Step(challenge)
+	return resp, err
+}
+
+// Server returns a go-sasl server that authenticates clients using the server
+// negotiator n.
+//
+// go-sasl servers cannot send data with the final success message so if the
+// mechanism has final data (such as the SCRAM server signature) it is sent as a
+// challenge and the exchange finishes after the client's empty response.
+func Server(n *sasl.Negotiator) gosasl.Server {
+	return &server{n: n}
+}
+
+type server struct {
+	n       *sasl.Negotiator
+	started bool
+	final   bool
+}
+
+func (s *server) Next(response []byte) ([]byte, bool, error) {
+	if !s.started {
+		s.started = true
+		if response == nil {
+			// The client did not send an initial response, so ask for one.
+			return []byte{}, false, nil
+		}
+	}
+	if s.final {
+		if len(response) != 0 {
+			return nil, false, gosasl.ErrUnexpectedClientResponse
+		}
+		return nil, true, nil
+	}
+	more, challenge, err := s.n.Step(response)
+	if err != nil {
+		return nil, false, err
+	}
+	if !more && len(challenge) > 0 {
+		s.final = true
+		return challenge, false, nil
+	}
+	return challenge, !more, nil
+}
+
+// ErrMechanismMismatch is returned when a go-sasl client starts a different
+// mechanism than the one it was registered as.
+var ErrMechanismMismatch = errors.New("emersionsasl: client started an unexpected mechanism")
+
+// Mechanism returns a sasl.Mechanism that uses go-sasl clients and servers.
+// A new client or server is created for each negotiation by calling newClient
+// or newServer, either of which may be nil if the mechanism is only used on one
+// side.
+//
+// go-sasl clients do not report when they are finished, so client negotiators
+// using the mechanism always report that more steps are expected and success
+// must be determined by the protocol.
+func Mechanism(name string, newClient func(*sasl.Negotiator) gosasl.Client, newServer func(*sasl.Negotiator) gosasl.Server) sasl.Mechanism {
+	return sasl.Mechanism{
+		Name: name,
+		Start: func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+			if newClient == nil {
+				return false, nil, nil, sasl.ErrInvalidState
+			}
+			c := newClient(n)
+			mech, ir, err := c.Start()
+			if err != nil {
+				return false, nil, nil, err
+			}
+			if mech != name {
+				return false, nil, nil, ErrMechanismMismatch
+			}
+			return true, ir, c, nil
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			if !n.State().IsServer() {
+				c, ok := data.(gosasl.Client)
+				if !ok {
+					return false, nil, nil, sasl.ErrInvalidState
+				}
+				resp, err := c.Next(challenge)
+				return err == nil, resp, c, err
+			}
+
+			s, ok := data.(gosasl.Server)
+			if !ok {
+				if newServer == nil {
+					return false, nil, nil, sasl.ErrInvalidState
+				}
+				s = newServer(n)
+			}
+			challenge, done, err := s.Next(challenge)
+			if err != nil {
+				return false, nil, nil, err
+			}
+			return !done, challenge, s, nil
+		},
+	}
+}
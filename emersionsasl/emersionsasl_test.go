@@ -0,0 +1,130 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package emersionsasl_test
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	gosasl "github.com/emersion/go-sasl"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/emersionsasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func credentials(password string) sasl.Option {
+	return sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte(password), nil
+	})
+}
+
+// authenticate drives c against s the way go-imap and go-smtp do.
+func authenticate(c gosasl.Client, s gosasl.Server) error {
+	_, resp, err := c.Start()
+	if err != nil {
+		return err
+	}
+	for {
+		challenge, done, err := s.Next(resp)
+		if err != nil || done {
+			return err
+		}
+		if resp, err = c.Next(challenge); err != nil {
+			return err
+		}
+	}
+}
+
+func TestAdapters(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		password string
+		fail     bool
+	}{
+		{name: "plain", mech: sasl.Plain, password: "pencil"},
+		{name: "plain-bad-password", mech: sasl.Plain, password: "pen", fail: true},
+		{name: "scram", mech: sasl.ScramSha256, password: "pencil"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := emersionsasl.Client(sasl.NewClient(tc.mech, credentials(tc.password)))
+			srv := emersionsasl.Server(sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s)))
+			err := authenticate(c, srv)
+			switch {
+			case tc.fail && err == nil:
+				t.Error("Expected authentication to fail")
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestServerNoInitialResponse(t *testing.T) {
+	srv := emersionsasl.Server(sasl.NewServer(sasl.Plain, func(*sasl.Negotiator) bool { return true }))
+	challenge, done, err := srv.Next(nil)
+	if err != nil || done || len(challenge) != 0 {
+		t.Fatalf("Expected empty challenge, got challenge=%q, done=%t, err=%v", challenge, done, err)
+	}
+	_, done, err = srv.Next([]byte("\x00user\x00pencil"))
+	if err != nil || !done {
+		t.Errorf("Expected authentication to finish, got done=%t, err=%v", done, err)
+	}
+}
+
+func TestMechanism(t *testing.T) {
+	var authenticated string
+	mech := emersionsasl.Mechanism(gosasl.Plain,
+		func(*sasl.Negotiator) gosasl.Client {
+			return gosasl.NewPlainClient("", "user", "pencil")
+		},
+		func(*sasl.Negotiator) gosasl.Server {
+			return gosasl.NewPlainServer(func(identity, username, password string) error {
+				if password != "pencil" {
+					return sasl.ErrAuthn
+				}
+				authenticated = username
+				return nil
+			})
+		},
+	)
+
+	client := sasl.NewClient(mech)
+	server := sasl.NewServer(mech, nil)
+	_, resp, err := client.Step(nil)
+	if err != nil {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+	more, _, err := server.Step(resp)
+	if err != nil {
+		t.Fatalf("Unexpected server error: %v", err)
+	}
+	if more || !server.Completed() {
+		t.Error("Expected server to finish")
+	}
+	if authenticated != "user" {
+		t.Errorf("Unexpected user: want=%q, got=%q", "user", authenticated)
+	}
+}
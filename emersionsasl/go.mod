@@ -0,0 +1,15 @@
+module github.com/jh125486/sasl/emersionsasl
+
+go 1.25.0
+
+replace github.com/jh125486/sasl => ../
+
+require (
+	github.com/emersion/go-sasl v0.0.0-20241020182733-b788ff22d5a6
+	github.com/jh125486/sasl v0.0.0-00010101000000-000000000000
+)
+
+require (
+	golang.org/x/crypto v0.0.0-20190320223903-b7391e95e576 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
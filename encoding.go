@@ -0,0 +1,116 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"encoding/base64"
+)
+
+// EncodeMessage base64 encodes a challenge or response for protocols that
+// distinguish between a missing message and an empty one (such as IMAP, POP3,
+// and XMPP).
+// An empty message is encoded as "=" and a nil message, which means that there
+// is no message at all, is encoded as the empty string.
+// Protocols that use "=" generally expect the caller to omit the message
+// entirely (or send an empty line) when EncodeMessage returns an empty string.
+func EncodeMessage(msg []byte) string {
+	switch {
+	case msg == nil:
+		return ""
+	case len(msg) == 0:
+		return "="
+	}
+	return base64.StdEncoding.EncodeToString(msg)
+}
+
+// DecodeMessage reverses EncodeMessage.
+// It returns nil if s is empty and an empty, non-nil slice if s is "=".
+func DecodeMessage(s string) ([]byte, error) {
+	switch s {
+	case "":
+		return nil, nil
+	case "=":
+		return []byte{}, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// InitialResponseStyle is a convention for encoding the initial response in
+// the command that starts an exchange.
+// Protocols agree on how to encode non-empty initial responses (as base64) and
+// on omitting a missing one, but differ on how to send one that is empty
+// (RFC 4422 §4).
+type InitialResponseStyle uint8
+
+// Conventions for encoding an empty initial response.
+const (
+	// EmptyAsEquals sends an empty initial response as "=", as required by IMAP
+	// with SASL-IR, SMTP, POP3, NNTP, and XMPP.
+	EmptyAsEquals InitialResponseStyle = iota
+
+	// EmptyOmitted is used by protocols that cannot represent an empty initial
+	// response.
+	// The initial response is omitted from the command and sent as an empty
+	// response to the first challenge, which must also be empty.
+	EmptyOmitted
+
+	// EmptyAsBase64 sends an empty initial response as the base64 encoding of
+	// the empty string, for protocols that carry it in a field that may be empty
+	// (such as a ManageSieve string or an LDAP octet string).
+	EmptyAsBase64
+)
+
+// EncodeInitialResponse encodes resp for inclusion in the command that starts
+// the exchange.
+// If ok is false the command must not include an initial response, either
+// because resp is nil or because it is empty and style is EmptyOmitted.
+// In the latter case the client still sends the empty response once the server
+// sends its first challenge.
+func EncodeInitialResponse(resp []byte, style InitialResponseStyle) (s string, ok bool) {
+	switch {
+	case resp == nil:
+		return "", false
+	case len(resp) > 0:
+		return base64.StdEncoding.EncodeToString(resp), true
+	}
+	switch style {
+	case EmptyAsEquals:
+		return "=", true
+	case EmptyAsBase64:
+		return "", true
+	}
+	return "", false
+}
+
+// TolerantBase64 makes DecodeBase64, and the codecs that use it, accept
+// challenges encoded with unpadded or URL-safe base64, which some servers send
+// instead of the standard encoding required by most protocols.
+// By default only standard, padded base64 is accepted.
+func TolerantBase64() Option {
+	return func(n *Negotiator) {
+		n.tolerantBase64 = true
+	}
+}
+
+// DecodeBase64 decodes a base64 encoded challenge (or response, for servers)
+// for protocols that encode SASL messages as base64.
+// If the TolerantBase64 option was used, unpadded and URL-safe encodings are
+// tried if the message is not valid standard base64.
+//
+// It does not treat "=" or the empty string specially; protocols that use them
+// to distinguish empty and missing messages should use DecodeMessage or check
+// for them first.
+func (c *Negotiator) DecodeBase64(s string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err == nil || !c.tolerantBase64 {
+		return b, err
+	}
+	for _, enc := range []*base64.Encoding{base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"testing"
+)
+
+var messageTestCases = [...]struct {
+	msg     []byte
+	encoded string
+}{
+	{msg: nil, encoded: ""},
+	{msg: []byte{}, encoded: "="},
+	{msg: []byte("hello"), encoded: "aGVsbG8="},
+}
+
+func TestEncodeMessage(t *testing.T) {
+	for _, tc := range messageTestCases {
+		if got := EncodeMessage(tc.msg); got != tc.encoded {
+			t.Errorf("Unexpected encoding of %#v: want=%q, got=%q", tc.msg, tc.encoded, got)
+		}
+		got, err := DecodeMessage(tc.encoded)
+		switch {
+		case err != nil:
+			t.Errorf("Unexpected error decoding %q: %v", tc.encoded, err)
+		case (got == nil) != (tc.msg == nil) || string(got) != string(tc.msg):
+			t.Errorf("Unexpected decoding of %q: want=%#v, got=%#v", tc.encoded, tc.msg, got)
+		}
+	}
+	if _, err := DecodeMessage("!"); err == nil {
+		t.Error("Expected error decoding invalid base64")
+	}
+}
+
+func TestDecodeBase64(t *testing.T) {
+	// "hello?>" encodes to a string that differs between the standard and
+	// URL-safe alphabets.
+	const want = "hello?>"
+	for _, enc := range []string{"aGVsbG8/Pg==", "aGVsbG8/Pg", "aGVsbG8_Pg==", "aGVsbG8_Pg"} {
+		strict := NewClient(plain)
+		got, err := strict.DecodeBase64(enc)
+		if std := enc == "aGVsbG8/Pg=="; (err == nil) != std {
+			t.Errorf("Unexpected strict result decoding %q: %q, %v", enc, got, err)
+		}
+
+		got, err = NewClient(plain, TolerantBase64()).DecodeBase64(enc)
+		if err != nil || string(got) != want {
+			t.Errorf("Unexpected tolerant result decoding %q: want=%q, got=%q, %v", enc, want, got, err)
+		}
+	}
+	if _, err := NewClient(plain, TolerantBase64()).DecodeBase64("!"); err == nil {
+		t.Error("Expected error decoding invalid base64")
+	}
+}
+
+func TestEncodeInitialResponse(t *testing.T) {
+	for _, tc := range []struct {
+		resp    []byte
+		style   InitialResponseStyle
+		encoded string
+		ok      bool
+	}{
+		{resp: nil, style: EmptyAsEquals},
+		{resp: nil, style: EmptyOmitted},
+		{resp: nil, style: EmptyAsBase64},
+		{resp: []byte{}, style: EmptyAsEquals, encoded: "=", ok: true},
+		{resp: []byte{}, style: EmptyOmitted},
+		{resp: []byte{}, style: EmptyAsBase64, encoded: "", ok: true},
+		{resp: []byte("hello"), style: EmptyAsEquals, encoded: "aGVsbG8=", ok: true},
+		{resp: []byte("hello"), style: EmptyOmitted, encoded: "aGVsbG8=", ok: true},
+		{resp: []byte("hello"), style: EmptyAsBase64, encoded: "aGVsbG8=", ok: true},
+	} {
+		encoded, ok := EncodeInitialResponse(tc.resp, tc.style)
+		if encoded != tc.encoded || ok != tc.ok {
+			t.Errorf("Unexpected encoding of %#v with style %d: want=%q, %t, got=%q, %t", tc.resp, tc.style, tc.encoded, tc.ok, encoded, ok)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Direction is the direction of the message described by an Event.
+type Direction uint8
+
+// Directions of messages.
+const (
+	// Received messages are challenges received by clients or responses received
+	// by servers.
+	Received Direction = iota + 1
+
+	// Sent messages are responses sent by clients or challenges sent by servers.
+	Sent
+)
+
+// String returns "received" or "sent".
+func (d Direction) String() string {
+	switch d {
+	case Received:
+		return "received"
+	case Sent:
+		return "sent"
+	}
+	return "unknown"
+}
+
+// An Event describes a message processed by a negotiator.
+// Events never contain the contents of messages, so they are safe to show in
+// debugging tools even if the negotiation uses credentials that must not be
+// disclosed.
+type Event struct {
+	// Mechanism is the name of the negotiator's mechanism.
+	Mechanism string
+
+	// NegotiationID is the ID of the negotiation (see NegotiationID).
+	NegotiationID string
+
+	// Direction is whether the message was received or sent.
+	Direction Direction
+
+	// Length is the length of the message in bytes (after base64 decoding), or
+	// -1 if there was no message (for example, because the mechanism does not
+	// have an initial response).
+	Length int
+
+	// Attributes contains the names of the attributes in the message, in the
+	// order they appear, for mechanisms whose messages are lists of attributes
+	// such as SCRAM.
+	// Their values are always redacted.
+	Attributes []string
+
+	// State is the state of the negotiator after the message was processed.
+	// For received messages this is the state before Step runs the mechanism.
+	State State
+
+	// Err is the error returned by Step, if any.
+	// Only sent events have errors, in which case no message is sent and Length
+	// is -1.
+	Err error
+}
+
+// Events causes the negotiator to call f with an event for each message that
+// it receives or sends.
+// Received events are emitted before the mechanism processes the message and
+// sent events once Step returns, so there is normally one of each per call to
+// Step (except for the first step of clients, which has no received event).
+func Events(f func(Event)) Option {
+	return func(n *Negotiator) {
+		n.onEvent = f
+	}
+}
+
+// emit calls the events callback, if any, with an event for msg and records the
+// message if there is a recorder.
+func (c *Negotiator) emit(dir Direction, msg []byte, err error) {
+	if c.recorder != nil {
+		c.recorder.record(c, dir, msg, err)
+	}
+	if c.onEvent == nil {
+		return
+	}
+	e := Event{
+		Mechanism:     c.mechanism.Name,
+		NegotiationID: c.negotiationID,
+		Direction:     dir,
+		Length:        -1,
+		State:         c.State(),
+		Err:           err,
+	}
+	if msg != nil {
+		e.Length = len(msg)
+		e.Attributes = c.attributeNames(msg)
+	}
+	c.onEvent(e)
+}
+
+// attributeNames returns the names of the attributes in a SCRAM message.
+func (c *Negotiator) attributeNames(msg []byte) []string {
+	if !strings.HasPrefix(c.mechanism.Name, "SCRAM-") {
+		return nil
+	}
+	var names []string
+	for _, field := range bytes.Split(msg, []byte{','}) {
+		if len(field) >= 2 && field[1] == '=' && isASCIILetter(field[0]) {
+			names = append(names, string(field[:1]))
+		}
+	}
+	return names
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha1"
+	"reflect"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	var events []Event
+	tc := saslTestCases[1]
+	client := NewClient(scram("SCRAM-SHA-1", sha1.New), append([]Option{Events(func(e Event) {
+		events = append(events, e)
+	})}, tc.clientOpts...)...)
+	client.nonce = testNonce
+	for _, step := range tc.steps {
+		if _, _, err := client.Step(step.challenge); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	want := []struct {
+		dir   Direction
+		attrs []string
+		state State
+	}{
+		{dir: Sent, attrs: []string{"n", "r"}, state: AuthTextSent},
+		{dir: Received, attrs: []string{"r", "s", "i"}, state: AuthTextSent},
+		{dir: Sent, attrs: []string{"c", "r", "p"}, state: ResponseSent},
+		{dir: Received, attrs: []string{"v"}, state: ResponseSent},
+		{dir: Sent, state: ValidServerResponse},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Wrong number of events: want=%d, got=%d", len(want), len(events))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Direction != w.dir || e.State != w.state || !reflect.DeepEqual(e.Attributes, w.attrs) || e.Err != nil {
+			t.Errorf("Unexpected event %d: want=%v %v %v, got=%+v", i, w.dir, w.attrs, w.state, e)
+		}
+		if e.Mechanism != "SCRAM-SHA-1" {
+			t.Errorf("Wrong mechanism in event %d: %q", i, e.Mechanism)
+		}
+	}
+	if events[4].Length != -1 {
+		t.Errorf("Expected missing final response to have length -1, got %d", events[4].Length)
+	}
+
+	events = nil
+	client.Reset()
+	client.nonce = testNonce
+	client.Step(nil)
+	client.Step([]byte("r=wrongnonce,s=QSXCR+Q6sek8bf92,i=4096"))
+	if last := events[len(events)-1]; last.Direction != Sent || last.Err == nil || last.Length != -1 || !last.State.Errored() {
+		t.Errorf("Expected failed sent event, got %+v", last)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+
+	"mellium.im/sasl"
+)
+
+func ExamplePasswordHook_mongoDB() {
+	// MongoDB's SCRAM-SHA-1 uses a digest of the username and password in place
+	// of the password and does not normalize either of them.
+	client := sasl.NewClient(sasl.ScramSha1,
+		sasl.NoSASLprep(),
+		sasl.PasswordHook(func(username, password []byte) ([]byte, error) {
+			digest := md5.Sum([]byte(string(username) + ":mongo:" + string(password)))
+			return []byte(hex.EncodeToString(digest[:])), nil
+		}),
+		sasl.Credentials(func() ([]byte, []byte, []byte) {
+			return []byte("user"), []byte("pencil"), nil
+		}),
+	)
+
+	_, resp, err := client.Step(nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// The client-first-message is sent to the server in a saslStart command.
+	fmt.Printf("%s\n", resp[:10])
+
+	// Output: n,,n=user,
+}
@@ -23,9 +23,9 @@ func Example_plainSuccess() {
 
 	server := sasl.NewServer(sasl.Plain, func(n *sasl.Negotiator) bool {
 		user, pass, ident := n.Credentials()
-		// In a real auth system you might want to consider a constant time
-		// comparison and this would probably involve hashing and a database lookup.
-		if len(ident) == 0 && string(user) == username && string(pass) == password {
+		// In a real auth system this would probably involve hashing and a database
+		// lookup.
+		if len(ident) == 0 && string(user) == username && sasl.ConstantTimeEqual(pass, []byte(password)) {
 			fmt.Println("auth success!")
 			return true
 		}
@@ -64,9 +64,9 @@ func Example_plainFailure() {
 
 	server := sasl.NewServer(sasl.Plain, func(n *sasl.Negotiator) bool {
 		user, pass, ident := n.Credentials()
-		// In a real auth system you might want to consider a constant time
-		// comparison and this would probably involve hashing and a database lookup.
-		if len(ident) == 0 && string(user) == username && string(pass) == password {
+		// In a real auth system this would probably involve hashing and a database
+		// lookup.
+		if len(ident) == 0 && string(user) == username && sasl.ConstantTimeEqual(pass, []byte(password)) {
 			fmt.Println("auth success!")
 			return true
 		}
@@ -39,7 +39,7 @@ var xoauth2 = sasl.Mechanism{
 		// If we're a client or a server that's past the AuthTextSent step, we
 		// should never actually hit this step for the XOAUTH2 mechanism so return
 		// an error.
-		if state&sasl.Receiving != sasl.Receiving || state&sasl.StepMask != sasl.AuthTextSent {
+		if !state.IsServer() || state.Step() != sasl.AuthTextSent {
 			return false, nil, nil, sasl.ErrTooManySteps
 		}
 
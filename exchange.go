@@ -0,0 +1,117 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jh125486/sasl/scramwire"
+)
+
+// ScramExchange is a complete SCRAM exchange, for example one recorded by an
+// authentication proxy that terminates SASL on behalf of a backend.
+// The messages must be exactly as they were sent, without any protocol
+// framing or base64 encoding.
+type ScramExchange struct {
+	// Mechanism is the name of the SCRAM mechanism, eg. "SCRAM-SHA-256".
+	Mechanism string
+
+	ClientFirst []byte
+	ServerFirst []byte
+	ClientFinal []byte
+	ServerFinal []byte
+
+	// ChannelBinding is the channel binding data of the TLS connection (for
+	// example, the tls-unique value) that was used by a -PLUS mechanism.
+	ChannelBinding []byte
+}
+
+// Verify checks offline that the exchange is a successful authentication of
+// the user with the stored credentials creds and returns the identity that was
+// authenticated.
+//
+// Verify checks that the nonces, salt, and iteration count are consistent
+// between the messages and with creds, that the channel binding data matches,
+// that the client proof is valid, and that the server sent the correct server
+// signature.
+// If the client proof is invalid ErrAuthn is returned, if the server signature
+// is invalid ErrServerSignature is returned, and if the server reported an
+// error it is returned as a ScramError.
+func (e ScramExchange) Verify(creds StoredCredentials) (AuthenticatedIdentity, error) {
+	var id AuthenticatedIdentity
+	fn, ok := ScramHash(e.Mechanism)
+	if !ok {
+		return id, errNotScram
+	}
+
+	clientFirst, err := scramwire.ParseClientFirst(e.ClientFirst)
+	if err != nil {
+		return id, err
+	}
+	serverFirst, err := scramwire.ParseServerFirst(e.ServerFirst)
+	if err != nil {
+		return id, err
+	}
+	clientFinal, err := scramwire.ParseClientFinal(e.ClientFinal)
+	if err != nil {
+		return id, err
+	}
+	serverFinal, err := scramwire.ParseServerFinal(e.ServerFinal)
+	if err != nil {
+		return id, err
+	}
+
+	if !bytes.HasPrefix(serverFirst.Nonce, clientFirst.Nonce) || len(serverFirst.Nonce) == len(clientFirst.Nonce) {
+		return id, NonceError{Mismatch: true, Reason: "server nonce does not start with the client nonce"}
+	}
+	if !bytes.Equal(clientFinal.Nonce, serverFirst.Nonce) {
+		return id, NonceError{Mismatch: true, Reason: "client-final nonce does not match the client-first and server-first nonces"}
+	}
+	if !bytes.Equal(serverFirst.Salt, creds.Salt) || serverFirst.Iterations != creds.Iterations {
+		return id, ErrSecretMismatch
+	}
+
+	// The GS2 header and AuthMessage must be taken from the messages exactly as
+	// they were sent.
+	comma := bytes.IndexByte(e.ClientFirst, ',')
+	comma += 1 + bytes.IndexByte(e.ClientFirst[comma+1:], ',')
+	gs2Header, clientFirstBare := e.ClientFirst[:comma+1], e.ClientFirst[comma+1:]
+	wantCB := gs2Header
+	if IsPlus(e.Mechanism) {
+		if !strings.HasPrefix(clientFirst.CBFlag, "p=") {
+			return id, errChannelBinding
+		}
+		wantCB = append(wantCB[:len(wantCB):len(wantCB)], e.ChannelBinding...)
+	} else if clientFirst.CBFlag != "n" && clientFirst.CBFlag != "y" {
+		return id, errChannelBinding
+	}
+	if !bytes.Equal(clientFinal.ChannelBinding, wantCB) {
+		return id, errChannelBinding
+	}
+
+	clientFinalWithoutProof := e.ClientFinal[:bytes.LastIndex(e.ClientFinal, []byte(",p="))]
+	authMessage := make([]byte, 0, len(clientFirstBare)+len(e.ServerFirst)+len(clientFinalWithoutProof)+2)
+	authMessage = append(authMessage, clientFirstBare...)
+	authMessage = append(authMessage, ',')
+	authMessage = append(authMessage, e.ServerFirst...)
+	authMessage = append(authMessage, ',')
+	authMessage = append(authMessage, clientFinalWithoutProof...)
+
+	hs := newScramHash(fn, fipsBuild)
+	if !verifyScramProof(hs, creds.StoredKey, authMessage, clientFinal.Proof) {
+		return id, ErrAuthn
+	}
+	if serverFinal.Error != "" {
+		return id, ScramError(serverFinal.Error)
+	}
+	if !ConstantTimeEqual(serverFinal.Verifier, hs.mac(nil, creds.ServerKey, authMessage)) {
+		return id, ErrServerSignature
+	}
+
+	id.Username = clientFirst.Username
+	id.Identity = clientFirst.Authzid
+	return id, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScramExchangeVerify(t *testing.T) {
+	// RFC 7677 §3
+	rfc := ScramExchange{
+		Mechanism:   "SCRAM-SHA-256",
+		ClientFirst: []byte("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"),
+		ServerFirst: []byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"),
+		ClientFinal: []byte("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="),
+		ServerFinal: []byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="),
+	}
+	creds := DeriveStoredCredentials(sha256.New, []byte("pencil"), mustDecode("W22ZaJ0SNY7soEsUEjb6gQ=="), 4096)
+
+	replace := func(b []byte, old, new string) []byte {
+		return []byte(strings.Replace(string(b), old, new, 1))
+	}
+	for _, tc := range []struct {
+		name  string
+		mod   func(*ScramExchange)
+		creds StoredCredentials
+		err   error
+	}{
+		{name: "valid"},
+		{name: "proof", mod: func(e *ScramExchange) { e.ClientFinal = replace(e.ClientFinal, "p=dHz", "p=dHy") }, err: ErrAuthn},
+		{name: "signature", mod: func(e *ScramExchange) { e.ServerFinal = replace(e.ServerFinal, "v=6", "v=7") }, err: ErrServerSignature},
+		{name: "server error", mod: func(e *ScramExchange) { e.ServerFinal = []byte("e=other-error") }, err: ScramOtherError},
+		{name: "salt", creds: DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096), err: ErrSecretMismatch},
+		{name: "nonce", mod: func(e *ScramExchange) { e.ClientFinal = replace(e.ClientFinal, "$k0,", "$k1,") }, err: NonceError{Mismatch: true, Reason: "client-final nonce does not match the client-first and server-first nonces"}},
+		{name: "channel binding", mod: func(e *ScramExchange) { e.Mechanism = "SCRAM-SHA-256-PLUS" }, err: errChannelBinding},
+		{name: "mechanism", mod: func(e *ScramExchange) { e.Mechanism = "PLAIN" }, err: errNotScram},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := rfc
+			if tc.mod != nil {
+				tc.mod(&e)
+			}
+			c := creds
+			if tc.creds.Salt != nil {
+				c = tc.creds
+			}
+			id, err := e.Verify(c)
+			if err != tc.err {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if err == nil && string(id.Username) != "user" {
+				t.Errorf("Unexpected identity: %q", id.Username)
+			}
+		})
+	}
+}
+
+func TestScramExchangeVerifyPlus(t *testing.T) {
+	cs := tls.ConnectionState{TLSUnique: []byte("finishedmessage")}
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	client := NewClient(ScramSha256Plus, append(scramClientOpts, TLSState(cs), RemoteMechanisms("SCRAM-SHA-256-PLUS"), AuthorizationIdentity([]byte("admin")))...)
+	server := NewServer(ScramSha256Plus, acceptAll, Store(store), TLSState(cs))
+
+	var msgs [][]byte
+	var challenge []byte
+	for i := 0; i < 2; i++ {
+		_, resp, err := client.Step(challenge)
+		if err != nil {
+			t.Fatalf("Unexpected client error: %v", err)
+		}
+		_, challenge, err = server.Step(resp)
+		if err != nil {
+			t.Fatalf("Unexpected server error: %v", err)
+		}
+		msgs = append(msgs, resp, challenge)
+	}
+
+	e := ScramExchange{
+		Mechanism:      "SCRAM-SHA-256-PLUS",
+		ClientFirst:    msgs[0],
+		ServerFirst:    msgs[1],
+		ClientFinal:    msgs[2],
+		ServerFinal:    msgs[3],
+		ChannelBinding: cs.TLSUnique,
+	}
+	id, err := e.Verify(store["user"])
+	if err != nil || string(id.Identity) != "admin" {
+		t.Errorf("Unexpected result: id=%+v, err=%v", id, err)
+	}
+	e.ChannelBinding = []byte("othersession")
+	if _, err = e.Verify(store["user"]); !errors.Is(err, errChannelBinding) {
+		t.Errorf("Expected channel binding error, got %v", err)
+	}
+}
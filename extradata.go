@@ -0,0 +1,61 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// ExtraDataPolicy is what a negotiator does with a message passed to Step
+// after the negotiation has completed, that is after a step returned more as
+// false without an error.
+// The mechanism is not asked to handle such messages, except that a client
+// which completed with its initial response still passes the first challenge
+// to the mechanism since it has not heard from the server yet (for example,
+// OAUTHBEARER clients receive the server's error document this way).
+type ExtraDataPolicy uint8
+
+// Policies for messages received after completion.
+const (
+	// ExtraDataError rejects the message with a TransitionError, after which the
+	// negotiator is errored and no longer reports that it is authenticated.
+	// This is the default.
+	ExtraDataError ExtraDataPolicy = iota
+
+	// ExtraDataIgnore discards the message and Step returns no response and
+	// more as false, leaving the completed negotiation as it was.
+	ExtraDataIgnore
+)
+
+// ExtraData sets what the negotiator does with messages received after the
+// negotiation completed.
+// It has no effect if a PostAuthHandler is set.
+func ExtraData(p ExtraDataPolicy) Option {
+	return func(n *Negotiator) {
+		n.extraData = p
+	}
+}
+
+// PostAuthHandler passes messages received after the negotiation completed to
+// f, for protocols that exchange additional data over the SASL channel once
+// authentication is done.
+// The response returned by f is returned by Step with more set to false, and
+// any error fails the negotiation as if the policy were ExtraDataError.
+func PostAuthHandler(f func(n *Negotiator, msg []byte) (resp []byte, err error)) Option {
+	return func(n *Negotiator) {
+		n.postAuth = f
+	}
+}
+
+// handleExtraData handles a message received after completion.
+func (c *Negotiator) handleExtraData(msg []byte) ([]byte, error) {
+	switch {
+	case c.postAuth != nil:
+		return c.postAuth(c, msg)
+	case c.extraData == ExtraDataIgnore:
+		return nil, nil
+	}
+	got := "challenge"
+	if c.isServer {
+		got = "response"
+	}
+	return nil, TransitionError{Mechanism: c.mechanism.Name, Got: got + " after completion"}
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestExtraData(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	for _, tc := range []struct {
+		name   string
+		opts   []Option
+		resp   string
+		err    error
+		authed bool
+	}{
+		{name: "default", err: ErrInvalidChallenge},
+		{name: "error", opts: []Option{ExtraData(ExtraDataError)}, err: ErrInvalidChallenge},
+		{name: "ignore", opts: []Option{ExtraData(ExtraDataIgnore)}, authed: true},
+		{
+			name: "handler",
+			opts: []Option{ExtraData(ExtraDataError), PostAuthHandler(func(n *Negotiator, msg []byte) ([]byte, error) {
+				return append([]byte("echo "), msg...), nil
+			})},
+			resp:   "echo extra",
+			authed: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewClient(ScramSha256, append(tc.opts, scramClientOpts...)...)
+			server := NewServer(ScramSha256, acceptAll, append(tc.opts, Store(store))...)
+			if err := negotiate(client, server); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for _, n := range []*Negotiator{client, server} {
+				more, resp, err := n.Step([]byte("extra"))
+				if !errors.Is(err, tc.err) || more || string(resp) != tc.resp {
+					t.Errorf("Unexpected result: more=%t, resp=%q, err=%v", more, resp, err)
+				}
+				if n.Authenticated() != tc.authed {
+					t.Errorf("Unexpected authenticated state: want=%t", tc.authed)
+				}
+			}
+		})
+	}
+}
+
+func TestExtraDataTransitionError(t *testing.T) {
+	server := NewServer(Plain, acceptAll)
+	if _, _, err := server.Step(plainResp); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, _, err := server.Step([]byte("extra"))
+	var e TransitionError
+	if !errors.As(err, &e) || e.Got != "response after completion" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// A ServerFactory creates server negotiators that share a set of mechanisms,
+// a permissions callback, and options such as credential stores and policies.
+// The options are applied once when the factory (or a tenant) is configured,
+// so creating a negotiator for a connection only copies the resulting
+// configuration.
+//
+// Tenants override the configuration for connections to a particular host, as
+// selected by the SNI server name or a virtual host.
+// The configuration can be replaced at runtime with Reconfigure without
+// affecting negotiators that have already been created.
+// A ServerFactory is safe for concurrent use.
+type ServerFactory struct {
+	mu      sync.RWMutex
+	base    tenant
+	tenants map[string]tenant
+}
+
+type tenant struct {
+	mechs []Mechanism
+	tmpl  *Negotiator
+
+	// The arguments to Tenant, used to rebuild the tenant when the factory is
+	// reconfigured.
+	ownMechs []Mechanism
+	opts     []Option
+}
+
+// NewServerFactory returns a factory for server negotiators that offer mechs
+// and use permissions and opts as if they had been passed to NewServer.
+// Mechanisms are advertised in the order of mechs unless the PreferMechanisms
+// option is used.
+func NewServerFactory(mechs []Mechanism, permissions func(*Negotiator) bool, opts ...Option) *ServerFactory {
+	return &ServerFactory{base: newBaseTenant(mechs, permissions, opts)}
+}
+
+func newBaseTenant(mechs []Mechanism, permissions func(*Negotiator) bool, opts []Option) tenant {
+	tmpl := &Negotiator{}
+	getOpts(tmpl, opts...)
+	if permissions != nil {
+		tmpl.permissions = permissions
+	}
+	return tenant{mechs: orderMechanisms(mechs, tmpl.mechPrefs), tmpl: tmpl}
+}
+
+// newTenant applies the configuration of a tenant on top of base.
+func newTenant(base tenant, mechs []Mechanism, opts []Option) tenant {
+	tmpl := new(Negotiator)
+	*tmpl = *base.tmpl
+	for _, o := range opts {
+		o(tmpl)
+	}
+	t := tenant{tmpl: tmpl, ownMechs: mechs, opts: opts}
+	if mechs == nil {
+		mechs = base.mechs
+	}
+	t.mechs = orderMechanisms(mechs, tmpl.mechPrefs)
+	return t
+}
+
+// Tenant configures the negotiators created for connections to host.
+// The options are applied on top of the factory's options and, if mechs is not
+// nil, the tenant offers mechs instead of the factory's mechanisms.
+// Host names are compared case-insensitively.
+// Calling Tenant again for the same host replaces its configuration.
+func (f *ServerFactory) Tenant(host string, mechs []Mechanism, opts ...Option) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.tenants == nil {
+		f.tenants = make(map[string]tenant)
+	}
+	f.tenants[strings.ToLower(host)] = newTenant(f.base, mechs, opts)
+}
+
+// RemoveTenant removes the configuration for host so that connections to it
+// use the factory's configuration.
+func (f *ServerFactory) RemoveTenant(host string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tenants, strings.ToLower(host))
+}
+
+// Reconfigure atomically replaces the mechanisms, permissions callback, and
+// options of the factory as if it had been created again with
+// NewServerFactory, for example to pick up a new credential store or policy
+// when the process receives SIGHUP.
+// Tenants are kept and their options are applied on top of the new
+// configuration.
+//
+// Negotiators that were created before the call keep using the old
+// configuration, so in-flight negotiations are not affected.
+func (f *ServerFactory) Reconfigure(mechs []Mechanism, permissions func(*Negotiator) bool, opts ...Option) {
+	base := newBaseTenant(mechs, permissions, opts)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.base = base
+	for host, t := range f.tenants {
+		f.tenants[host] = newTenant(base, t.ownMechs, t.opts)
+	}
+}
+
+// tenant returns the configuration for host.
+func (f *ServerFactory) tenant(host string) tenant {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if t, ok := f.tenants[strings.ToLower(host)]; ok {
+		return t
+	}
+	return f.base
+}
+
+// Mechanisms returns the names of the mechanisms offered to connections to
+// host, for example to advertise them to the client.
+// Opts are the options specific to the connection that will be passed to
+// NewServer.
+// The -PLUS variants are only offered if they let the server bind the exchange
+// to the connection, so opts must include the TLSState option and either the
+// TLS connection must have a tls-unique value (TLS 1.3 connections do not) or
+// the ServerCertificate option must be used.
+func (f *ServerFactory) Mechanisms(host string, opts ...Option) []string {
+	t := f.tenant(host)
+	conn := new(Negotiator)
+	*conn = *t.tmpl
+	for _, o := range opts {
+		o(conn)
+	}
+	names := make([]string, 0, len(t.mechs))
+	for _, m := range t.mechs {
+		if IsPlus(m.Name) && !conn.canBindChannel() {
+			continue
+		}
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+// NewServer returns a server negotiator for the mechanism named mechanism
+// using the configuration for host, which is normally the SNI server name from
+// the TLS state or the virtual host requested by the client.
+// Opts are applied after the factory and tenant options and should be used for
+// options that are specific to the connection such as TLSState.
+//
+// If the mechanism is not offered to host, or is a -PLUS variant that cannot
+// bind to the connection, ErrMechanismNotSupported is returned.
+func (f *ServerFactory) NewServer(mechanism, host string, opts ...Option) (*Negotiator, error) {
+	t := f.tenant(host)
+	for _, m := range t.mechs {
+		if m.Name != mechanism {
+			continue
+		}
+		machine := new(Negotiator)
+		*machine = *t.tmpl
+		machine.mechanism = m
+		machine.flags, machine.isServer = 0, true
+		machine.noPlus = !slices.ContainsFunc(t.mechs, func(m Mechanism) bool {
+			return IsPlus(m.Name)
+		})
+		machine.applyScoped()
+		for _, o := range opts {
+			o(machine)
+		}
+		if IsPlus(m.Name) && !machine.canBindChannel() {
+			break
+		}
+		machine.applyWorkarounds()
+		machine.nonce = machine.newNonce()
+		machine.negotiationID = machine.newNegotiationID()
+		machine.setRemoteCB()
+		machine.debug("selected server mechanism", slog.Bool("remote_cb", machine.flags.RemoteSupportsCB()))
+		return machine, nil
+	}
+	return nil, ErrMechanismNotSupported
+}
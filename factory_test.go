@@ -0,0 +1,110 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+func TestServerFactory(t *testing.T) {
+	base := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	other := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("other"), []byte("salt"), 4096)}
+
+	f := NewServerFactory([]Mechanism{ScramSha256, Plain}, acceptAll, Store(base))
+	f.Tenant("Example.ORG", []Mechanism{ScramSha256}, Store(other))
+
+	if got, want := f.Mechanisms("example.com"), []string{"SCRAM-SHA-256", "PLAIN"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected mechanisms for default tenant: want=%v, got=%v", want, got)
+	}
+	if got, want := f.Mechanisms("example.org"), []string{"SCRAM-SHA-256"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected mechanisms for tenant: want=%v, got=%v", want, got)
+	}
+	if _, err := f.NewServer("PLAIN", "example.org"); err != ErrMechanismNotSupported {
+		t.Errorf("Unexpected error for mechanism not offered to tenant: %v", err)
+	}
+	if _, err := f.NewServer("SCRAM-SHA-1", "example.com"); err != ErrMechanismNotSupported {
+		t.Errorf("Unexpected error for unknown mechanism: %v", err)
+	}
+
+	for _, tc := range []struct {
+		host     string
+		password string
+		err      error
+	}{
+		{host: "example.com", password: "pencil"},
+		{host: "example.com", password: "other", err: ErrAuthn},
+		{host: "example.org", password: "other"},
+		{host: "EXAMPLE.org", password: "pencil", err: ErrAuthn},
+	} {
+		server, err := f.NewServer("SCRAM-SHA-256", tc.host)
+		if err != nil {
+			t.Fatalf("Unexpected error creating server: %v", err)
+		}
+		if server.State() != AuthTextSent|Receiving || server.Nonce() == nil {
+			t.Errorf("Server was not initialized: state=%v", server.State())
+		}
+		client := NewClient(ScramSha256, Credentials(func() ([]byte, []byte, []byte) {
+			return []byte("user"), []byte(tc.password), nil
+		}))
+		if err := negotiate(client, server); err != tc.err {
+			t.Errorf("%s with password %q: want=%v, got=%v", tc.host, tc.password, tc.err, err)
+		}
+	}
+
+	// Servers do not share per-negotiation state.
+	s1, _ := f.NewServer("PLAIN", "", Confidential(false))
+	s2, _ := f.NewServer("PLAIN", "")
+	if string(s1.Nonce()) == string(s2.Nonce()) {
+		t.Error("Expected servers to have different nonces")
+	}
+	if !s1.insecure || s2.insecure {
+		t.Error("Connection options leaked between servers")
+	}
+}
+
+func TestServerFactoryReconfigure(t *testing.T) {
+	old := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	rotated := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("rotated"), []byte("salt"), 4096)}
+	login := func(password string) *Negotiator {
+		return NewClient(ScramSha256, Credentials(func() ([]byte, []byte, []byte) {
+			return []byte("user"), []byte(password), nil
+		}))
+	}
+
+	f := NewServerFactory([]Mechanism{ScramSha256, Plain}, acceptAll, Store(old))
+	f.Tenant("example.org", []Mechanism{ScramSha256}, Confidential(false))
+	inFlight, err := f.NewServer("SCRAM-SHA-256", "example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error creating server: %v", err)
+	}
+
+	f.Reconfigure([]Mechanism{ScramSha256}, acceptAll, Store(rotated))
+
+	if err := negotiate(login("pencil"), inFlight); err != nil {
+		t.Errorf("In-flight negotiation was affected by reconfiguration: %v", err)
+	}
+	if _, err := f.NewServer("PLAIN", "example.com"); err != ErrMechanismNotSupported {
+		t.Errorf("Unexpected error for removed mechanism: %v", err)
+	}
+	for _, host := range []string{"example.com", "example.org"} {
+		server, err := f.NewServer("SCRAM-SHA-256", host)
+		if err != nil {
+			t.Fatalf("Unexpected error creating server for %s: %v", host, err)
+		}
+		if err := negotiate(login("rotated"), server); err != nil {
+			t.Errorf("Expected %s to use the new store: %v", host, err)
+		}
+	}
+	if server, _ := f.NewServer("SCRAM-SHA-256", "example.org"); !server.insecure {
+		t.Errorf("Expected tenant options to be kept after reconfiguration")
+	}
+
+	f.RemoveTenant("EXAMPLE.org")
+	if server, _ := f.NewServer("SCRAM-SHA-256", "example.org"); server.insecure {
+		t.Errorf("Expected removed tenant to use the factory configuration")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"errors"
+	"slices"
+)
+
+// Retryable wraps err so that a FailoverClient moves on to the next mechanism
+// instead of giving up.
+// Mechanisms should use it for failures that are specific to the mechanism
+// such as an expired token.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return retryableError{err: err}
+}
+
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string { return e.err.Error() }
+func (e retryableError) Unwrap() error { return e.err }
+
+// IsRetryable reports whether err was wrapped with Retryable or is
+// ErrMechanismNotSupported.
+func IsRetryable(err error) bool {
+	var r retryableError
+	return errors.As(err, &r) || errors.Is(err, ErrMechanismNotSupported)
+}
+
+// FailoverClient tries a list of mechanisms in order, moving on to the next one
+// when authentication fails with a retryable error.
+// The order can be changed with the PreferMechanisms option.
+type FailoverClient struct {
+	mechs []Mechanism
+	opts  []Option
+	n     *Negotiator
+}
+
+// NewFailoverClient returns a client that tries each mechanism in mechs in
+// order.
+// The options are used to create the client negotiator for each mechanism.
+// If the RemoteMechanisms option is used, mechanisms that the server did not
+// advertise are skipped, as are mechanisms that are not allowed by the
+// Confidential option.
+func NewFailoverClient(mechs []Mechanism, opts ...Option) *FailoverClient {
+	return &FailoverClient{mechs: mechs, opts: opts}
+}
+
+// Negotiate calls attempt with a new client negotiator for each mechanism until
+// attempt succeeds or returns an error for which IsRetryable is false.
+// Attempt is expected to run a complete exchange, for example by calling
+// NegotiateConn.
+//
+// If every mechanism fails the returned error wraps the errors from each
+// attempt.
+func (f *FailoverClient) Negotiate(attempt func(*Negotiator) error) error {
+	var prefs Negotiator
+	for _, o := range f.opts {
+		o(&prefs)
+	}
+	var errs []error
+	for _, m := range orderMechanisms(f.mechs, prefs.mechPrefs) {
+		n := NewClient(m, f.opts...)
+		if remote := n.RemoteMechanisms(); remote != nil && !slices.Contains(remote, m.Name) {
+			continue
+		}
+		if n.insecureTransport() && m.Capabilities.RequiresTLS {
+			errs = append(errs, InsecureTransportError{Mechanism: m.Name})
+			continue
+		}
+		f.n = n
+		err := attempt(n)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+		if !IsRetryable(err) {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return ErrMechanismNotSupported
+	}
+	return errors.Join(errs...)
+}
+
+// Negotiator returns the negotiator used for the last attempt, or nil if no
+// attempt has been made.
+// After Negotiate succeeds its mechanism is the one that authenticated.
+func (f *FailoverClient) Negotiator() *Negotiator {
+	return f.n
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFailoverClient(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	errExpired := errors.New("token expired")
+	expired := Mechanism{
+		Name: "X-TOKEN",
+		Start: func(*Negotiator) (bool, []byte, interface{}, error) {
+			return false, nil, nil, Retryable(errExpired)
+		},
+	}
+	creds := Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	})
+	attempt := func(supported ...string) func(*Negotiator) error {
+		return func(client *Negotiator) error {
+			name := client.Mechanism().Name
+			for _, s := range supported {
+				if s == name {
+					return negotiate(client, NewServer(client.Mechanism(), acceptAll, Store(store)))
+				}
+			}
+			return fmt.Errorf("server rejected %s: %w", name, ErrMechanismNotSupported)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		mechs   []Mechanism
+		opts    []Option
+		attempt func(*Negotiator) error
+		want    string
+		err     error
+	}{
+		{
+			name:    "first",
+			mechs:   []Mechanism{ScramSha256, Plain},
+			attempt: attempt("SCRAM-SHA-256", "PLAIN"),
+			want:    "SCRAM-SHA-256",
+		},
+		{
+			name:    "unsupported",
+			mechs:   []Mechanism{ScramSha256Plus, ScramSha256},
+			attempt: attempt("SCRAM-SHA-256"),
+			want:    "SCRAM-SHA-256",
+		},
+		{
+			name:    "retryable",
+			mechs:   []Mechanism{expired, ScramSha256},
+			attempt: attempt("X-TOKEN", "SCRAM-SHA-256"),
+			want:    "SCRAM-SHA-256",
+		},
+		{
+			name:    "remote-mechanisms",
+			mechs:   []Mechanism{ScramSha256Plus, Plain, ScramSha256},
+			opts:    []Option{RemoteMechanisms("SCRAM-SHA-256")},
+			attempt: attempt("SCRAM-SHA-256-PLUS", "PLAIN", "SCRAM-SHA-256"),
+			want:    "SCRAM-SHA-256",
+		},
+		{
+			name:    "preference",
+			mechs:   []Mechanism{ScramSha256, Plain},
+			opts:    []Option{PreferMechanisms("PLAIN")},
+			attempt: attempt("SCRAM-SHA-256", "PLAIN"),
+			want:    "PLAIN",
+		},
+		{
+			name:  "not-retryable",
+			mechs: []Mechanism{ScramSha256, Plain},
+			opts: []Option{Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte("pen"), nil
+			})},
+			attempt: attempt("SCRAM-SHA-256", "PLAIN"),
+			want:    "SCRAM-SHA-256",
+			err:     ErrAuthn,
+		},
+		{
+			name:    "exhausted",
+			mechs:   []Mechanism{expired, ScramSha256},
+			attempt: attempt("X-TOKEN"),
+			want:    "SCRAM-SHA-256",
+			err:     errExpired,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := NewFailoverClient(tc.mechs, append([]Option{creds}, tc.opts...)...)
+			err := f.Negotiate(tc.attempt)
+			switch {
+			case tc.err == nil && err != nil:
+				t.Fatalf("Unexpected error: %v", err)
+			case tc.err != nil && !errors.Is(err, tc.err):
+				t.Fatalf("Expected error %v, got %v", tc.err, err)
+			}
+			if got := f.Negotiator().Mechanism().Name; got != tc.want {
+				t.Errorf("Unexpected mechanism: want=%s, got=%s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFailoverClientConfidential(t *testing.T) {
+	f := NewFailoverClient([]Mechanism{Plain}, Confidential(false))
+	err := f.Negotiate(func(*Negotiator) error {
+		t.Fatal("Attempt should not be made with an insecure mechanism")
+		return nil
+	})
+	var insecureErr InsecureTransportError
+	if !errors.As(err, &insecureErr) {
+		t.Errorf("Expected InsecureTransportError, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// FailurePolicy controls how much servers reveal about why a negotiation
+// failed.
+type FailurePolicy uint8
+
+// Policies for reporting failures.
+const (
+	// FailureDetailed returns the errors of the mechanism as they are, so that
+	// the protocol can tell the client whether, for example, the user was
+	// unknown or the password was wrong.
+	// This is the default.
+	FailureDetailed FailurePolicy = iota
+
+	// FailureGeneric makes every failure look the same to the client, for
+	// deployments that must not reveal whether a username exists or which
+	// credential was wrong:
+	//
+	//   - Step returns ErrAuthn for every failure, or ErrAuthn wrapped with
+	//     Temporary if the cause was temporary (see IsTemporary), and the
+	//     original error is available from FailureCause for logging.
+	//   - SCRAM servers continue negotiations for unknown users as if
+	//     FakeUnknownUsers had been used, so that they fail at the same step as
+	//     a wrong password. If FakeUnknownUsers was not used a random key is
+	//     generated for the life of the process, so the option should still be
+	//     used to keep the salts of unknown users the same across restarts and
+	//     servers.
+	//   - Mechanisms that send errors to the client, such as OAUTHBEARER, send a
+	//     generic error.
+	FailureGeneric
+)
+
+// FailureReasons sets how much servers reveal about failed negotiations.
+// It has no effect on clients.
+func FailureReasons(p FailurePolicy) Option {
+	return func(n *Negotiator) {
+		n.failures = p
+	}
+}
+
+// FailurePolicy returns the policy set by the FailureReasons option.
+// Mechanisms that send errors to the client should send a generic error if it
+// is FailureGeneric.
+func (c *Negotiator) FailurePolicy() FailurePolicy {
+	return c.failures
+}
+
+// FailureCause returns the error that caused the last step to fail before it
+// was replaced because of the FailureGeneric policy, or nil if there is none.
+// It should only be logged on the server and never sent to the client.
+func (c *Negotiator) FailureCause() error {
+	return c.failureCause
+}
+
+// genericFailures reports whether failures must not reveal their cause.
+func (c *Negotiator) genericFailures() bool {
+	return c.failures == FailureGeneric && c.isServer
+}
+
+// maskFailure returns the error that Step should return for err.
+func (c *Negotiator) maskFailure(err error) error {
+	if err == nil || !c.genericFailures() {
+		return err
+	}
+	c.failureCause = err
+	if IsTemporary(err) {
+		return Temporary(ErrAuthn)
+	}
+	return ErrAuthn
+}
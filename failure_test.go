@@ -0,0 +1,75 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestFailureReasonsPlain(t *testing.T) {
+	errDown := Temporary(errors.New("directory unavailable"))
+	for _, tc := range []struct {
+		name   string
+		cause  error
+		policy FailurePolicy
+		err    error
+		temp   bool
+	}{
+		{name: "detailed unknown", cause: ErrUnknownUser, err: ErrUnknownUser},
+		{name: "generic unknown", cause: ErrUnknownUser, policy: FailureGeneric, err: ErrAuthn},
+		{name: "generic password", cause: ErrAuthn, policy: FailureGeneric, err: ErrAuthn},
+		{name: "generic temporary", cause: errDown, policy: FailureGeneric, err: ErrAuthn, temp: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer(Plain, acceptAll, FailureReasons(tc.policy), VerifyPasswords(PasswordVerifierFunc(func(context.Context, []byte, []byte) error {
+				return tc.cause
+			})))
+			_, _, err := server.Step(plainResp)
+			if !errors.Is(err, tc.err) || IsTemporary(err) != tc.temp {
+				t.Fatalf("Unexpected error: want=%v (temporary=%t), got=%v", tc.err, tc.temp, err)
+			}
+			if tc.policy == FailureGeneric {
+				if errors.Is(err, ErrUnknownUser) {
+					t.Errorf("Generic error reveals that the user is unknown")
+				}
+				if cause := server.FailureCause(); cause != tc.cause {
+					t.Errorf("Wrong failure cause: want=%v, got=%v", tc.cause, cause)
+				}
+			} else if server.FailureCause() != nil {
+				t.Errorf("Unexpected failure cause with detailed policy: %v", server.FailureCause())
+			}
+		})
+	}
+}
+
+func TestFailureReasonsScram(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	for _, tc := range []struct {
+		name     string
+		username string
+		password string
+	}{
+		{name: "unknown user", username: "nobody", password: "pencil"},
+		{name: "wrong password", username: "user", password: "wrong"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewClient(ScramSha256, Credentials(func() ([]byte, []byte, []byte) {
+				return []byte(tc.username), []byte(tc.password), nil
+			}))
+			server := NewServer(ScramSha256, acceptAll, Store(store), FailureReasons(FailureGeneric))
+			err := negotiate(client, server)
+			if err != ErrAuthn {
+				t.Fatalf("Expected ErrAuthn, got %v", err)
+			}
+			// Both failures happen when the proof is checked.
+			if step := server.State().Step(); step != ValidServerResponse {
+				t.Errorf("Failed at the wrong step: %v", step)
+			}
+		})
+	}
+}
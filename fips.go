@@ -0,0 +1,33 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// fipsMechanisms is the list of mechanisms that only use FIPS approved
+// primitives.
+var fipsMechanisms = map[string]struct{}{
+	"SCRAM-SHA-256":      {},
+	"SCRAM-SHA-256-PLUS": {},
+	"SCRAM-SHA-512":      {},
+	"SCRAM-SHA-512-PLUS": {},
+	"GSSAPI":             {},
+}
+
+// FIPS restricts the negotiator to mechanisms that only use FIPS approved
+// primitives (SCRAM-SHA-256, SCRAM-SHA-512, and GSSAPI).
+// Any other mechanism fails with ErrFIPS on the first call to Step.
+//
+// FIPS mode is enabled by default if the package is built with the sasl_fips
+// build tag.
+func FIPS() Option {
+	return func(n *Negotiator) {
+		n.fips = true
+	}
+}
+
+// FIPSApproved reports whether the named mechanism is allowed in FIPS mode.
+func FIPSApproved(mechanism string) bool {
+	_, ok := fipsMechanisms[mechanism]
+	return ok
+}
@@ -1,3 +1,8 @@
 module github.com/jh125486/sasl
 
-require golang.org/x/crypto v0.0.0-20190320223903-b7391e95e576
+go 1.21
+
+require (
+	golang.org/x/crypto v0.0.0-20190320223903-b7391e95e576
+	golang.org/x/text v0.14.0
+)
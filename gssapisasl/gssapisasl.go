@@ -0,0 +1,472 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package gssapisasl implements the GSSAPI mechanism (RFC 4752) so that Go
+// services can accept Kerberos logins and Go clients can use them.
+//
+// This package does not contain a Kerberos implementation.
+// Processing context tokens is delegated to an Acceptor (or an Initiator on
+// clients), usually a thin wrapper around a Kerberos library, which is created
+// for the service principal name of the negotiator.
+// Servers normally load the keys for the acceptor from a keytab with
+// LoadKeytab or ParseKeytab.
+//
+// The integrity and confidentiality security layers of RFC 4752 are negotiated
+// using the sasl.QOPPreference and sasl.RequireQOP options, and confidentiality
+// is only available if the Acceptor or Initiator implements Sealer.
+// If a layer was negotiated the negotiator's SecurityLayer method returns it
+// and all data exchanged after authentication must be protected with it, for
+// example using sasl.WrapConn.
+// To negotiate no security layer (for example, because the connection is
+// already protected with TLS) use sasl.QOPPreference(sasl.QOPAuth).
+package gssapisasl
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/jh125486/sasl"
+)
+
+// Errors returned by the mechanism.
+var (
+	ErrNoService     = errors.New("gssapisasl: service name and host must be set to build the service principal name")
+	ErrSecurityLayer = errors.New("gssapisasl: unsupported security layer")
+)
+
+// The security layers from RFC 4752 §3.3 use the same bits as sasl.QOP, and the
+// maximum buffer size is sent as a three octet integer.
+const maxBufferSize = 1<<24 - 1
+
+// An Acceptor is the acceptor side of a GSS-API security context
+// (RFC 2743 §2.2.2).
+type Acceptor interface {
+	// AcceptSecContext processes a context token sent by the initiator and
+	// returns the token to send back, if any.
+	// Established is true once the context has been fully established.
+	AcceptSecContext(token []byte) (out []byte, established bool, err error)
+
+	// SourceName returns the name of the authenticated initiator, for example
+	// "user@EXAMPLE.COM".
+	// It is only called after the context has been established.
+	SourceName() string
+
+	// Wrap and Unwrap protect and verify messages using the established
+	// context.
+	Wrap(msg []byte) ([]byte, error)
+	Unwrap(token []byte) ([]byte, error)
+}
+
+// A Sealer is an Acceptor or Initiator that can encrypt messages as well as
+// protect their integrity, which is needed to negotiate the confidentiality
+// security layer.
+type Sealer interface {
+	// Seal is like Wrap but also encrypts msg (GSS_Wrap with conf_req_flag
+	// set).
+	// Unwrap must accept the messages returned by Seal.
+	Seal(msg []byte) ([]byte, error)
+}
+
+// A SizeLimiter is an Acceptor or Initiator that can report the overhead added
+// by Wrap and Seal.
+// The security layer uses it to keep the messages that it sends within the
+// maximum buffer size advertised by the peer; without it the layer assumes that
+// there is no overhead.
+type SizeLimiter interface {
+	// WrapSizeLimit returns the size of the largest message that can be passed
+	// to Wrap (or Seal if conf is true) without the result being larger than
+	// maxOutput (GSS_Wrap_size_limit).
+	WrapSizeLimit(maxOutput int, conf bool) int
+}
+
+// A Delegator is an Acceptor that can return the credentials delegated by the
+// initiator.
+type Delegator interface {
+	// DelegatedCredential returns the credential delegated by the initiator, if
+	// any.
+	// It is only called after the context has been established.
+	DelegatedCredential() (cred interface{}, ok bool)
+}
+
+// Delegate is a property that makes clients request credential delegation and
+// servers accept delegated credentials.
+// It is set using the option returned by Delegate.Set(true).
+//
+// Servers that accept delegation can act on behalf of the user with the
+// credential returned by DelegatedCredential.
+var Delegate = sasl.NewProperty[bool]("gssapisasl delegate")
+
+var delegatedCred = sasl.NewProperty[interface{}]("gssapisasl delegated credential")
+
+// DelegatedCredential returns the credential delegated by the client.
+// It can only be called from the permissions callback of a server that
+// accepts delegation, and the acceptor must implement Delegator.
+// The type of the credential depends on the acceptor.
+func DelegatedCredential(n *sasl.Negotiator) (cred interface{}, ok bool) {
+	return delegatedCred.Get(n)
+}
+
+// NewAcceptorFunc creates an acceptor for the service principal name spn (for
+// example "imap/mail.example.net", or "imap/mail.example.net@EXAMPLE.NET" if a
+// realm was set with the sasl.Realm option) using keys from kt.
+type NewAcceptorFunc func(spn string, kt *Keytab) (Acceptor, error)
+
+// SPN returns the service principal name that a negotiator accepts logins
+// for, built from the sasl.Service and sasl.ServerFQDN (or sasl.Host)
+// options.
+func SPN(n *sasl.Negotiator) (string, error) {
+	service, host := n.Service(), n.ServerFQDN()
+	if service == "" || host == "" {
+		return "", ErrNoService
+	}
+	spn := service + "/" + strings.ToLower(host)
+	if realm, _ := n.SelectRealm(nil); realm != "" {
+		spn += "@" + realm
+	}
+	return spn, nil
+}
+
+type phase uint8
+
+const (
+	phaseContext phase = iota
+	phaseEstablished
+	phaseLayerSent
+)
+
+type state struct {
+	acc   Acceptor
+	phase phase
+	offer sasl.QOP
+}
+
+// Server returns a server-side GSSAPI mechanism that accepts logins for the
+// service principal name returned by SPN using keys from kt.
+// Once the context is established the principal name of the client is passed
+// to the negotiator's permissions callback as the username along with the
+// authorization identity requested by the client, if any.
+func Server(newAcceptor NewAcceptorFunc, kt *Keytab) sasl.Mechanism {
+	return sasl.Mechanism{
+		Name: "GSSAPI",
+		Capabilities: sasl.Capabilities{
+			MutualAuth: true,
+		},
+		Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+			return false, nil, nil, sasl.ErrInvalidState
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			if !n.State().IsServer() {
+				return false, nil, nil, sasl.ErrInvalidState
+			}
+			st, _ := data.(*state)
+			if st == nil {
+				spn, err := SPN(n)
+				if err != nil {
+					return false, nil, nil, err
+				}
+				acc, err := newAcceptor(spn, kt)
+				if err != nil {
+					return false, nil, nil, err
+				}
+				st = &state{acc: acc}
+			}
+
+			switch st.phase {
+			case phaseContext:
+				out, established, err := st.acc.AcceptSecContext(challenge)
+				if err != nil {
+					return false, nil, nil, err
+				}
+				switch {
+				case !established:
+					return true, out, st, nil
+				case len(out) > 0:
+					// The client replies to the final context token with an empty
+					// response before the security layers are offered.
+					st.phase = phaseEstablished
+					return true, out, st, nil
+				}
+				return sendLayers(n, st)
+			case phaseEstablished:
+				if len(challenge) > 0 {
+					return false, nil, nil, sasl.ErrInvalidChallenge
+				}
+				return sendLayers(n, st)
+			case phaseLayerSent:
+				return acceptLayer(n, st, challenge)
+			}
+			return false, nil, nil, sasl.ErrInvalidState
+		},
+	}
+}
+
+// maxBuffer returns the maximum buffer size advertised to the peer.
+func maxBuffer(n *sasl.Negotiator) int {
+	if size := n.MaxBuffer(); size < maxBufferSize {
+		return size
+	}
+	return maxBufferSize
+}
+
+// supportedQOP returns the security layers that can be provided by ctx.
+func supportedQOP(ctx wrapper) sasl.QOP {
+	supported := sasl.QOPAuth | sasl.QOPAuthInt
+	if _, ok := ctx.(Sealer); ok {
+		supported |= sasl.QOPAuthConf
+	}
+	return supported
+}
+
+// layerMessage returns the security layers and maximum buffer size sent by
+// either side once the context is established.
+// The maximum buffer size is zero if no security layer is offered.
+func layerMessage(n *sasl.Negotiator, layers sasl.QOP) []byte {
+	var size int
+	if layers != sasl.QOPAuth {
+		size = maxBuffer(n)
+	}
+	return []byte{byte(layers), byte(size >> 16), byte(size >> 8), byte(size)}
+}
+
+func sendLayers(n *sasl.Negotiator, st *state) (bool, []byte, interface{}, error) {
+	// Offer every layer that the acceptor supports and that the options allow the
+	// client to select.
+	supported := supportedQOP(st.acc)
+	for q := sasl.QOPAuth; q <= sasl.QOPAuthConf; q <<= 1 {
+		if _, err := n.SelectQOP(q); err == nil && supported&q != 0 {
+			st.offer |= q
+		}
+	}
+	if st.offer == 0 {
+		return false, nil, nil, sasl.ErrQOP
+	}
+	offer, err := st.acc.Wrap(layerMessage(n, st.offer))
+	if err != nil {
+		return false, nil, nil, err
+	}
+	st.phase = phaseLayerSent
+	return true, offer, st, nil
+}
+
+func acceptLayer(n *sasl.Negotiator, st *state, resp []byte) (bool, []byte, interface{}, error) {
+	msg, err := st.acc.Unwrap(resp)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	if len(msg) < 4 {
+		return false, nil, nil, sasl.ErrInvalidChallenge
+	}
+	// The client must select exactly one of the offered layers.
+	qop := sasl.QOP(msg[0])
+	if qop == 0 || qop&(qop-1) != 0 || qop&st.offer != qop {
+		return false, nil, nil, ErrSecurityLayer
+	}
+	l, err := newLayer(n, st.acc, qop, msg[1:4])
+	if err != nil {
+		return false, nil, nil, err
+	}
+	username, err := n.CanonicalUsername([]byte(st.acc.SourceName()))
+	if err != nil {
+		return false, nil, nil, err
+	}
+	identity := msg[4:]
+	opts := []sasl.Option{sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return username, nil, identity
+	})}
+	if d, ok := st.acc.(Delegator); ok {
+		if accept, _ := Delegate.Get(n); accept {
+			if cred, ok := d.DelegatedCredential(); ok {
+				opts = append(opts, delegatedCred.Set(cred))
+			}
+		}
+	}
+	if !n.Permissions(opts...) {
+		return false, nil, nil, sasl.ErrAuthn
+	}
+	return false, nil, l, nil
+}
+
+// An Initiator is the initiator side of a GSS-API security context
+// (RFC 2743 §2.2.1).
+// As required by RFC 4752 it must request mutual authentication
+// (GSS_C_MUTUAL_FLAG), and InitSecContext must fail if the context is
+// established without it.
+type Initiator interface {
+	// InitSecContext processes a context token sent by the acceptor (nil on the
+	// first call) and returns the token to send next, if any.
+	// Established is true once the context has been fully established.
+	InitSecContext(token []byte) (out []byte, established bool, err error)
+
+	// Wrap and Unwrap protect and verify messages using the established
+	// context.
+	Wrap(msg []byte) ([]byte, error)
+	Unwrap(token []byte) ([]byte, error)
+}
+
+// NewInitiatorFunc creates an initiator for the service principal name spn.
+// If delegate is true the initiator should request credential delegation
+// (the GSS_C_DELEG_FLAG).
+// If the initiator implements io.Closer it is closed when the negotiation
+// ends, or if a security layer was negotiated, when the layer is closed.
+type NewInitiatorFunc func(spn string, delegate bool) (Initiator, error)
+
+type clientState struct {
+	init  Initiator
+	phase phase
+}
+
+// Client returns a client-side GSSAPI mechanism that authenticates to the
+// service principal name returned by SPN.
+// The authorization identity from the sasl.Credentials or
+// sasl.AuthorizationIdentity options is sent to the server, the username and
+// password are not used.
+func Client(newInitiator NewInitiatorFunc) sasl.Mechanism {
+	return sasl.Mechanism{
+		Name: "GSSAPI",
+		Capabilities: sasl.Capabilities{
+			MutualAuth: true,
+		},
+		Start: func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+			spn, err := SPN(n)
+			if err != nil {
+				return false, nil, nil, err
+			}
+			delegate, _ := Delegate.Get(n)
+			init, err := newInitiator(spn, delegate)
+			if err != nil {
+				return false, nil, nil, err
+			}
+			st := &clientState{init: init}
+			out, established, err := init.InitSecContext(nil)
+			if err != nil {
+				st.close()
+				return false, nil, nil, err
+			}
+			if established {
+				st.phase = phaseEstablished
+			}
+			return true, out, st, nil
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			st, ok := data.(*clientState)
+			if !ok {
+				return false, nil, nil, sasl.ErrInvalidState
+			}
+			more, resp, cache, err := st.next(n, challenge)
+			if err != nil || (!more && cache == nil) {
+				st.close()
+			}
+			return more, resp, cache, err
+		},
+	}
+}
+
+// close closes the initiator if it implements io.Closer.
+func (st *clientState) close() {
+	if c, ok := st.init.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func (st *clientState) next(n *sasl.Negotiator, challenge []byte) (bool, []byte, interface{}, error) {
+	switch st.phase {
+	case phaseContext:
+		out, established, err := st.init.InitSecContext(challenge)
+		if err != nil {
+			return false, nil, nil, err
+		}
+		if established {
+			st.phase = phaseEstablished
+			if out == nil {
+				out = []byte{}
+			}
+		}
+		return true, out, st, nil
+	case phaseEstablished:
+		msg, err := st.init.Unwrap(challenge)
+		if err != nil {
+			return false, nil, nil, err
+		}
+		if len(msg) < 4 {
+			return false, nil, nil, sasl.ErrInvalidChallenge
+		}
+		qop, err := n.SelectQOP(sasl.QOP(msg[0]) & supportedQOP(st.init))
+		if err != nil {
+			return false, nil, nil, err
+		}
+		l, err := newLayer(n, st.init, qop, msg[1:4])
+		if err != nil {
+			return false, nil, nil, err
+		}
+		// The context was established with mutual authentication and the offer was
+		// protected with it, so it came from the server.
+		n.SetServerVerified()
+		_, _, identity := n.Credentials()
+		resp := append(layerMessage(n, qop), identity...)
+		if resp, err = st.init.Wrap(resp); err != nil {
+			return false, nil, nil, err
+		}
+		return false, resp, l, nil
+	}
+	return false, nil, nil, sasl.ErrTooManySteps
+}
+
+// wrapper is the part of the Acceptor and Initiator interfaces used by the
+// security layer.
+type wrapper interface {
+	Wrap(msg []byte) ([]byte, error)
+	Unwrap(token []byte) ([]byte, error)
+}
+
+// layer is the security layer negotiated by the mechanism.
+// Closing it closes the context if the context implements io.Closer.
+type layer struct {
+	ctx     wrapper
+	qop     sasl.QOP
+	maxSend int
+	maxRecv int
+}
+
+// newLayer returns the security layer for qop, or nil if qop is sasl.QOPAuth.
+// peerMax is the maximum buffer size sent by the peer, which limits the size of
+// the messages that the layer may wrap.
+func newLayer(n *sasl.Negotiator, ctx wrapper, qop sasl.QOP, peerMax []byte) (interface{}, error) {
+	if qop == sasl.QOPAuth {
+		return nil, nil
+	}
+	maxSend := int(peerMax[0])<<16 | int(peerMax[1])<<8 | int(peerMax[2])
+	if sl, ok := ctx.(SizeLimiter); ok && maxSend > 0 {
+		maxSend = sl.WrapSizeLimit(maxSend, qop == sasl.QOPAuthConf)
+		if maxSend < 1 {
+			return nil, ErrSecurityLayer
+		}
+	}
+	return &layer{
+		ctx:     ctx,
+		qop:     qop,
+		maxSend: maxSend,
+		maxRecv: maxBuffer(n),
+	}, nil
+}
+
+func (l *layer) QOP() sasl.QOP { return l.qop }
+
+func (l *layer) Wrap(p []byte) ([]byte, error) {
+	if l.qop == sasl.QOPAuthConf {
+		return l.ctx.(Sealer).Seal(p)
+	}
+	return l.ctx.Wrap(p)
+}
+
+func (l *layer) Unwrap(p []byte) ([]byte, error) { return l.ctx.Unwrap(p) }
+func (l *layer) MaxSendSize() int                { return l.maxSend }
+func (l *layer) MaxRecvSize() int                { return l.maxRecv }
+
+func (l *layer) Close() error {
+	if c, ok := l.ctx.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,416 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package gssapisasl_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/gssapisasl"
+	"github.com/jh125486/sasl/sasltest"
+)
+
+// fakeInitiator is the initiator side of fakeAcceptor.
+type fakeInitiator struct {
+	fakeAcceptor
+}
+
+func (i *fakeInitiator) InitSecContext(token []byte) ([]byte, bool, error) {
+	i.tokens++
+	switch {
+	case i.tokens == 1 && token == nil:
+		return []byte("token1"), false, nil
+	case i.tokens == 2 && string(token) == "continue":
+		return []byte("token2"), false, nil
+	case i.tokens == 3 && string(token) == "final":
+		return nil, true, nil
+	}
+	return nil, false, errors.New("unexpected token")
+}
+
+// fakeAcceptor establishes a context after two tokens and "wraps" messages by
+// prefixing them with "wrapped:".
+type fakeAcceptor struct {
+	spn      string
+	tokens   int
+	delegate bool
+}
+
+func (a *fakeAcceptor) DelegatedCredential() (interface{}, bool) {
+	return "ticket", a.delegate
+}
+
+func (a *fakeAcceptor) AcceptSecContext(token []byte) ([]byte, bool, error) {
+	a.tokens++
+	switch a.tokens {
+	case 1:
+		return []byte("continue"), false, nil
+	case 2:
+		return []byte("final"), true, nil
+	}
+	return nil, false, errors.New("too many tokens")
+}
+
+func (a *fakeAcceptor) SourceName() string { return "user@EXAMPLE.NET" }
+
+func (a *fakeAcceptor) Wrap(msg []byte) ([]byte, error) {
+	return append([]byte("wrapped:"), msg...), nil
+}
+
+func (a *fakeAcceptor) Unwrap(token []byte) ([]byte, error) {
+	msg, ok := bytes.CutPrefix(token, []byte("wrapped:"))
+	if !ok {
+		msg, ok = bytes.CutPrefix(token, []byte("sealed:"))
+	}
+	if !ok {
+		return nil, errors.New("bad token")
+	}
+	return msg, nil
+}
+
+func (a *fakeAcceptor) WrapSizeLimit(maxOutput int, conf bool) int {
+	if conf {
+		return maxOutput - len("sealed:")
+	}
+	return maxOutput - len("wrapped:")
+}
+
+// sealingAcceptor and sealingInitiator also support the confidentiality
+// security layer by "encrypting" messages with a different prefix.
+type sealingAcceptor struct {
+	*fakeAcceptor
+}
+
+func (sealingAcceptor) Seal(msg []byte) ([]byte, error) {
+	return append([]byte("sealed:"), msg...), nil
+}
+
+type sealingInitiator struct {
+	*fakeInitiator
+}
+
+func (sealingInitiator) Seal(msg []byte) ([]byte, error) {
+	return append([]byte("sealed:"), msg...), nil
+}
+
+func TestServer(t *testing.T) {
+	var acc *fakeAcceptor
+	var username, identity string
+	kt := &gssapisasl.Keytab{}
+	mech := gssapisasl.Server(func(spn string, k *gssapisasl.Keytab) (gssapisasl.Acceptor, error) {
+		if k != kt {
+			t.Errorf("Wrong keytab passed to acceptor")
+		}
+		acc = &fakeAcceptor{spn: spn}
+		return acc, nil
+	}, kt)
+	server := sasl.NewServer(mech, func(n *sasl.Negotiator) bool {
+		u, _, id := n.Credentials()
+		username, identity = string(u), string(id)
+		return true
+	}, sasl.Service("imap"), sasl.Host("Mail.Example.net", 993), sasl.Realm("EXAMPLE.NET"))
+
+	for i, step := range []struct {
+		resp      string
+		challenge string
+		more      bool
+	}{
+		{resp: "token1", challenge: "continue", more: true},
+		{resp: "token2", challenge: "final", more: true},
+		{resp: "", challenge: "wrapped:\x03\x01\x00\x00", more: true},
+		{resp: "wrapped:\x01\x00\x00\x00admin"},
+	} {
+		more, challenge, err := server.Step([]byte(step.resp))
+		if err != nil {
+			t.Fatalf("%d: Unexpected error: %v", i, err)
+		}
+		if more != step.more || string(challenge) != step.challenge {
+			t.Errorf("%d: want=%t, %q, got=%t, %q", i, step.more, step.challenge, more, challenge)
+		}
+	}
+	if !server.Completed() {
+		t.Errorf("Expected server to complete")
+	}
+	if acc.spn != "imap/mail.example.net@EXAMPLE.NET" {
+		t.Errorf("Unexpected SPN: %q", acc.spn)
+	}
+	if username != "user@EXAMPLE.NET" || identity != "admin" {
+		t.Errorf("Unexpected credentials: %q, %q", username, identity)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	newAcceptor := func(string, *gssapisasl.Keytab) (gssapisasl.Acceptor, error) {
+		return &fakeAcceptor{tokens: 1}, nil
+	}
+	server := sasl.NewServer(gssapisasl.Server(newAcceptor, nil), nil)
+	if _, _, err := server.Step([]byte("token")); err != gssapisasl.ErrNoService {
+		t.Errorf("Unexpected error: want=%v, got=%v", gssapisasl.ErrNoService, err)
+	}
+
+	server = sasl.NewServer(gssapisasl.Server(newAcceptor, nil), func(*sasl.Negotiator) bool {
+		return true
+	}, sasl.Service("imap"), sasl.Host("mail.example.net", 0))
+	if _, _, err := server.Step([]byte("token")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := server.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := server.Step([]byte("wrapped:\x04\x00\x10\x00")); err != gssapisasl.ErrSecurityLayer {
+		t.Errorf("Unexpected error: want=%v, got=%v", gssapisasl.ErrSecurityLayer, err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		request  bool
+		accept   bool
+		wantCred bool
+	}{
+		{name: "none"},
+		{name: "requested", request: true},
+		{name: "accepted", accept: true},
+		{name: "delegated", request: true, accept: true, wantCred: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var spn string
+			var delegated bool
+			client := sasl.NewClient(gssapisasl.Client(func(s string, delegate bool) (gssapisasl.Initiator, error) {
+				spn, delegated = s, delegate
+				return &fakeInitiator{}, nil
+			}), sasl.Service("imap"), sasl.Host("mail.example.net", 143), sasl.AuthorizationIdentity([]byte("admin")), gssapisasl.Delegate.Set(tc.request), sasl.RequireMutualAuth())
+
+			var identity string
+			var cred interface{}
+			var gotCred bool
+			server := sasl.NewServer(gssapisasl.Server(func(string, *gssapisasl.Keytab) (gssapisasl.Acceptor, error) {
+				// The fake acceptor only has a credential if the client asked for one.
+				return &fakeAcceptor{delegate: delegated}, nil
+			}, nil), func(n *sasl.Negotiator) bool {
+				_, _, id := n.Credentials()
+				identity = string(id)
+				cred, gotCred = gssapisasl.DelegatedCredential(n)
+				return true
+			}, sasl.Service("imap"), sasl.Host("mail.example.net", 143), gssapisasl.Delegate.Set(tc.accept))
+
+			var challenge []byte
+			for i := 0; ; i++ {
+				more, resp, err := client.Step(challenge)
+				if err != nil {
+					t.Fatalf("%d: Unexpected client error: %v", i, err)
+				}
+				_, challenge, err = server.Step(resp)
+				if err != nil {
+					t.Fatalf("%d: Unexpected server error: %v", i, err)
+				}
+				if !more {
+					break
+				}
+			}
+			if !client.Completed() || !server.Completed() {
+				t.Fatalf("Expected negotiation to complete")
+			}
+			if !client.Authenticated() || !client.SecurityProperties().MutualAuth {
+				t.Errorf("Expected client to have authenticated the server")
+			}
+			if spn != "imap/mail.example.net" {
+				t.Errorf("Unexpected SPN: %q", spn)
+			}
+			if identity != "admin" {
+				t.Errorf("Unexpected authorization identity: %q", identity)
+			}
+			if gotCred != tc.wantCred || (gotCred && cred != "ticket") {
+				t.Errorf("Unexpected delegated credential: want=%t, got=%v (%t)", tc.wantCred, cred, gotCred)
+			}
+		})
+	}
+}
+
+func TestSecurityLayer(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		clientSeal bool
+		serverSeal bool
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		want       sasl.QOP
+		err        error
+	}{
+		{name: "integrity", want: sasl.QOPAuthInt},
+		{name: "confidentiality", clientSeal: true, serverSeal: true, want: sasl.QOPAuthConf},
+		{name: "client cannot seal", serverSeal: true, want: sasl.QOPAuthInt},
+		{name: "server cannot seal", clientSeal: true, want: sasl.QOPAuthInt},
+		{name: "client prefers none", clientOpts: []sasl.Option{sasl.QOPPreference(sasl.QOPAuth, sasl.QOPAuthInt)}, want: sasl.QOPAuth},
+		{name: "server offers none", serverOpts: []sasl.Option{sasl.QOPPreference(sasl.QOPAuth)}, want: sasl.QOPAuth},
+		{name: "client requires conf", clientOpts: []sasl.Option{sasl.RequireQOP(sasl.QOPAuthConf)}, err: sasl.ErrQOP},
+		{name: "server requires conf", serverOpts: []sasl.Option{sasl.RequireQOP(sasl.QOPAuthConf)}, err: sasl.ErrQOP},
+		{name: "server requires integrity", clientOpts: []sasl.Option{sasl.QOPPreference(sasl.QOPAuth)}, serverOpts: []sasl.Option{sasl.RequireQOP(sasl.QOPAuthInt)}, err: sasl.ErrQOP},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := sasl.NewClient(gssapisasl.Client(func(string, bool) (gssapisasl.Initiator, error) {
+				if tc.clientSeal {
+					return sealingInitiator{&fakeInitiator{}}, nil
+				}
+				return &fakeInitiator{}, nil
+			}), append([]sasl.Option{sasl.Service("imap"), sasl.Host("mail.example.net", 143)}, tc.clientOpts...)...)
+			server := sasl.NewServer(gssapisasl.Server(func(string, *gssapisasl.Keytab) (gssapisasl.Acceptor, error) {
+				if tc.serverSeal {
+					return sealingAcceptor{&fakeAcceptor{}}, nil
+				}
+				return &fakeAcceptor{}, nil
+			}, nil), func(*sasl.Negotiator) bool {
+				return true
+			}, append([]sasl.Option{sasl.Service("imap"), sasl.Host("mail.example.net", 143)}, tc.serverOpts...)...)
+
+			_, err := sasltest.Negotiate(client, server)
+			if err != tc.err {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			for _, n := range []*sasl.Negotiator{client, server} {
+				if q := n.NegotiatedQOP(); q != tc.want {
+					t.Errorf("Wrong QOP: want=%v, got=%v", tc.want, q)
+				}
+				l := n.SecurityLayer()
+				if (l == nil) != (tc.want == sasl.QOPAuth) {
+					t.Fatalf("Unexpected security layer for %v: %v", tc.want, l)
+				}
+				if l == nil {
+					continue
+				}
+				prefix := "wrapped:"
+				if tc.want == sasl.QOPAuthConf {
+					prefix = "sealed:"
+				}
+				if p, err := l.Wrap([]byte("data")); err != nil || string(p) != prefix+"data" {
+					t.Errorf("Wrong wrapped data: want=%q, got=%q (%v)", prefix+"data", p, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxBuffer(t *testing.T) {
+	newPair := func(clientMax, serverMax int) (client, server *sasl.Negotiator) {
+		client = sasl.NewClient(gssapisasl.Client(func(string, bool) (gssapisasl.Initiator, error) {
+			return &fakeInitiator{}, nil
+		}), sasl.Service("imap"), sasl.Host("mail.example.net", 143), sasl.MaxBuffer(clientMax))
+		server = sasl.NewServer(gssapisasl.Server(func(string, *gssapisasl.Keytab) (gssapisasl.Acceptor, error) {
+			return &fakeAcceptor{}, nil
+		}, nil), func(*sasl.Negotiator) bool {
+			return true
+		}, sasl.Service("imap"), sasl.Host("mail.example.net", 143), sasl.MaxBuffer(serverMax))
+		return client, server
+	}
+
+	client, server := newPair(20, 30)
+	if _, err := sasltest.Negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Each side may send the peer's maximum buffer size less the length of the
+	// "wrapped:" prefix.
+	if send, recv := client.BufferSizes(); send != 22 || recv != 20 {
+		t.Errorf("Wrong client buffer sizes: want=22, 20, got=%d, %d", send, recv)
+	}
+	if send, recv := server.BufferSizes(); send != 12 || recv != 30 {
+		t.Errorf("Wrong server buffer sizes: want=12, 30, got=%d, %d", send, recv)
+	}
+
+	// Writes are split so that the server accepts every protected buffer.
+	const msg = "the quick brown fox jumps over the lazy dog"
+	var buf bytes.Buffer
+	if _, err := sasl.WrapConn(&buf, client.SecurityLayer()).Write([]byte(msg)); err != nil {
+		t.Fatalf("Unexpected error writing: %v", err)
+	}
+	got, err := io.ReadAll(sasl.WrapConn(&buf, server.SecurityLayer()))
+	if err != nil || string(got) != msg {
+		t.Errorf("Wrong data read: want=%q, got=%q (%v)", msg, got, err)
+	}
+
+	// The server's buffer is too small for the client to send anything.
+	client, server = newPair(20, 8)
+	if _, err := sasltest.Negotiate(client, server); err != gssapisasl.ErrSecurityLayer {
+		t.Errorf("Unexpected error: want=%v, got=%v", gssapisasl.ErrSecurityLayer, err)
+	}
+}
+
+func appendData(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func keytabEntry(kvno uint32, enctype uint16, key string) []byte {
+	var e []byte
+	e = binary.BigEndian.AppendUint16(e, 2)
+	e = appendData(e, "EXAMPLE.NET")
+	e = appendData(e, "imap")
+	e = appendData(e, "mail.example.net")
+	e = binary.BigEndian.AppendUint32(e, 1)
+	e = binary.BigEndian.AppendUint32(e, 1700000000)
+	e = append(e, byte(kvno))
+	e = binary.BigEndian.AppendUint16(e, enctype)
+	e = appendData(e, key)
+	e = binary.BigEndian.AppendUint32(e, kvno)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(e))), e...)
+}
+
+func TestParseKeytab(t *testing.T) {
+	b := []byte{5, 2}
+	for _, e := range [][]byte{
+		keytabEntry(3, 18, "key3"),
+		// A deleted entry.
+		{0xff, 0xff, 0xff, 0xfe, 0, 0},
+		keytabEntry(300, 18, "key300"),
+		keytabEntry(300, 17, "key300-aes128"),
+	} {
+		b = append(b, e...)
+	}
+	kt, err := gssapisasl.ParseKeytab(b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(kt.Entries) != 3 {
+		t.Fatalf("Unexpected number of entries: %d", len(kt.Entries))
+	}
+	if p := kt.Entries[0].Principal(); p != "imap/mail.example.net@EXAMPLE.NET" {
+		t.Errorf("Unexpected principal: %q", p)
+	}
+
+	for _, tc := range []struct {
+		kvno    uint32
+		enctype int32
+		key     string
+	}{
+		{kvno: 0, enctype: 18, key: "key300"},
+		{kvno: 3, enctype: 18, key: "key3"},
+		{kvno: 0, enctype: 17, key: "key300-aes128"},
+		{kvno: 4, enctype: 18},
+		{kvno: 0, enctype: 23},
+	} {
+		e, ok := kt.Find("imap/MAIL.example.net@EXAMPLE.NET", tc.kvno, tc.enctype)
+		if ok != (tc.key != "") || string(e.Key) != tc.key {
+			t.Errorf("Find(%d, %d): want=%q, got=%q (%t)", tc.kvno, tc.enctype, tc.key, e.Key, ok)
+		}
+	}
+
+	for _, bad := range [][]byte{
+		nil,
+		{5, 1},
+		{5, 2, 0, 0, 0, 10, 0},
+		append([]byte{5, 2}, keytabEntry(1, 18, "key")[:20]...),
+	} {
+		if _, err := gssapisasl.ParseKeytab(bad); err == nil {
+			t.Errorf("Expected error parsing %x", bad)
+		}
+	}
+}
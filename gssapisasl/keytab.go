@@ -0,0 +1,171 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package gssapisasl
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// Errors returned when parsing keytabs.
+var (
+	ErrKeytabVersion = errors.New("gssapisasl: unsupported keytab version")
+	ErrKeytabFormat  = errors.New("gssapisasl: malformed keytab")
+)
+
+// KeytabEntry is a single key from a keytab.
+type KeytabEntry struct {
+	Realm      string
+	Components []string
+	NameType   uint32
+	Timestamp  time.Time
+	KVNO       uint32
+	EncType    int32
+	Key        []byte
+}
+
+// Principal returns the name of the principal in the form
+// "component/component@REALM".
+func (e KeytabEntry) Principal() string {
+	return strings.Join(e.Components, "/") + "@" + e.Realm
+}
+
+// Keytab is a parsed MIT keytab file.
+type Keytab struct {
+	Entries []KeytabEntry
+}
+
+// LoadKeytab reads and parses the keytab file at path.
+func LoadKeytab(path string) (*Keytab, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeytab(b)
+}
+
+// ParseKeytab parses a keytab in the format used by MIT Kerberos and most
+// other implementations (version 0x502).
+func ParseKeytab(b []byte) (*Keytab, error) {
+	if len(b) < 2 || b[0] != 5 {
+		return nil, ErrKeytabFormat
+	}
+	if b[1] != 2 {
+		return nil, ErrKeytabVersion
+	}
+	b = b[2:]
+
+	kt := &Keytab{}
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, ErrKeytabFormat
+		}
+		size := int32(binary.BigEndian.Uint32(b))
+		b = b[4:]
+		if size < 0 {
+			// Deleted entries are left as holes with a negative length.
+			size = -size
+			if int(size) > len(b) {
+				return nil, ErrKeytabFormat
+			}
+			b = b[size:]
+			continue
+		}
+		if int(size) > len(b) {
+			return nil, ErrKeytabFormat
+		}
+		e, err := parseKeytabEntry(b[:size])
+		if err != nil {
+			return nil, err
+		}
+		kt.Entries = append(kt.Entries, e)
+		b = b[size:]
+	}
+	return kt, nil
+}
+
+func parseKeytabEntry(b []byte) (e KeytabEntry, err error) {
+	r := keytabReader{b: b}
+	n := r.uint16()
+	e.Realm = string(r.data())
+	for i := 0; i < int(n) && !r.failed; i++ {
+		e.Components = append(e.Components, string(r.data()))
+	}
+	e.NameType = r.uint32()
+	e.Timestamp = time.Unix(int64(r.uint32()), 0)
+	e.KVNO = uint32(r.uint8())
+	e.EncType = int32(r.uint16())
+	e.Key = r.data()
+	if r.failed {
+		return e, ErrKeytabFormat
+	}
+	// Newer keytabs store a 32-bit key version number after the key which
+	// overrides the 8-bit one if it is non-zero.
+	if len(r.b) >= 4 {
+		if kvno := r.uint32(); kvno != 0 {
+			e.KVNO = kvno
+		}
+	}
+	return e, nil
+}
+
+// Find returns the key for principal with the given encryption type.
+// If kvno is zero the entry with the highest key version number is returned.
+func (kt *Keytab) Find(principal string, kvno uint32, enctype int32) (KeytabEntry, bool) {
+	var found KeytabEntry
+	var ok bool
+	for _, e := range kt.Entries {
+		if e.EncType != enctype || !strings.EqualFold(e.Principal(), principal) {
+			continue
+		}
+		if kvno != 0 {
+			if e.KVNO == kvno {
+				return e, true
+			}
+			continue
+		}
+		if !ok || e.KVNO > found.KVNO {
+			found, ok = e, true
+		}
+	}
+	return found, ok
+}
+
+// keytabReader reads big endian values from a keytab entry.
+// Once a read fails, failed is set and all further reads return zero values.
+type keytabReader struct {
+	b      []byte
+	failed bool
+}
+
+func (r *keytabReader) next(n int) []byte {
+	if r.failed || len(r.b) < n {
+		r.failed, r.b = true, nil
+		return make([]byte, n)
+	}
+	p := r.b[:n]
+	r.b = r.b[n:]
+	return p
+}
+
+func (r *keytabReader) uint8() uint8 {
+	return r.next(1)[0]
+}
+
+func (r *keytabReader) uint16() uint16 {
+	return binary.BigEndian.Uint16(r.next(2))
+}
+
+func (r *keytabReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(r.next(4))
+}
+
+func (r *keytabReader) data() []byte {
+	n := r.uint16()
+	return append([]byte(nil), r.next(int(n))...)
+}
@@ -0,0 +1,303 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build windows
+
+package gssapisasl
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// Security packages that can be used with SSPI.
+const (
+	// SSPINegotiate uses Kerberos if possible and falls back to NTLM, for
+	// example when the client is not joined to the domain of the server.
+	SSPINegotiate = "Negotiate"
+
+	// SSPIKerberos only uses Kerberos.
+	SSPIKerberos = "Kerberos"
+
+	// SSPINTLM only uses NTLM.
+	SSPINTLM = "NTLM"
+)
+
+var (
+	secur32                        = syscall.NewLazyDLL("secur32.dll")
+	procAcquireCredentialsHandleW  = secur32.NewProc("AcquireCredentialsHandleW")
+	procInitializeSecurityContextW = secur32.NewProc("InitializeSecurityContextW")
+	procQueryContextAttributesW    = secur32.NewProc("QueryContextAttributesW")
+	procEncryptMessage             = secur32.NewProc("EncryptMessage")
+	procDecryptMessage             = secur32.NewProc("DecryptMessage")
+	procFreeContextBuffer          = secur32.NewProc("FreeContextBuffer")
+	procDeleteSecurityContext      = secur32.NewProc("DeleteSecurityContext")
+	procFreeCredentialsHandle      = secur32.NewProc("FreeCredentialsHandle")
+)
+
+const (
+	secEOK                = 0
+	secIContinueNeeded    = 0x00090312
+	secpkgCredOutbound    = 2
+	securityNativeDrep    = 0x10
+	secpkgAttrSizes       = 0
+	secbufferData         = 1
+	secbufferToken        = 2
+	secbufferPadding      = 9
+	secbufferStream       = 10
+	secqopWrapNoEncrypt   = 0x80000001
+	iscReqDelegate        = 0x1
+	iscReqMutualAuth      = 0x2
+	iscReqSequenceDetect  = 0x8
+	iscReqConfidentiality = 0x10
+	iscReqAllocateMemory  = 0x100
+	iscReqIntegrity       = 0x10000
+	iscRetMutualAuth      = 0x2
+	sspiFlags             = iscReqMutualAuth | iscReqSequenceDetect | iscReqConfidentiality | iscReqAllocateMemory | iscReqIntegrity
+	sspiBufferDescVersion = 0
+)
+
+// ErrSSPIMutualAuth is returned if SSPI establishes a context without
+// authenticating the server.
+var ErrSSPIMutualAuth = errors.New("gssapisasl: SSPI did not authenticate the server")
+
+type secHandle struct {
+	lower, upper uintptr
+}
+
+type secBuffer struct {
+	size       uint32
+	bufferType uint32
+	buffer     *byte
+}
+
+type secBufferDesc struct {
+	version uint32
+	count   uint32
+	buffers *secBuffer
+}
+
+type secPkgContextSizes struct {
+	maxToken        uint32
+	maxSignature    uint32
+	blockSize       uint32
+	securityTrailer uint32
+}
+
+type timestamp struct {
+	low  uint32
+	high int32
+}
+
+func sspiError(call string, status uintptr) error {
+	return errors.New("gssapisasl: " + call + " failed with status 0x" + strconv.FormatUint(uint64(status), 16))
+}
+
+// sspiInitiator is an Initiator, Sealer, and SizeLimiter that uses the Windows
+// Security Support Provider Interface.
+type sspiInitiator struct {
+	cred   secHandle
+	ctx    secHandle
+	hasCtx bool
+	target *uint16
+	flags  uint32
+	sizes  secPkgContextSizes
+	closed bool
+}
+
+// SSPI returns a function that creates initiators using the Windows Security
+// Support Provider Interface with the given security package (normally
+// SSPINegotiate).
+// The credentials of the logged on user are used, so domain joined machines
+// get single sign-on without a keytab or password.
+//
+// The returned initiators implement io.Closer and are closed by the client
+// mechanism when the negotiation ends, or by the security layer if one was
+// negotiated.
+func SSPI(pkg string) NewInitiatorFunc {
+	return func(spn string, delegate bool) (Initiator, error) {
+		pkgName, err := syscall.UTF16PtrFromString(pkg)
+		if err != nil {
+			return nil, err
+		}
+		target, err := syscall.UTF16PtrFromString(spn)
+		if err != nil {
+			return nil, err
+		}
+		i := &sspiInitiator{target: target, flags: sspiFlags}
+		if delegate {
+			i.flags |= iscReqDelegate
+		}
+		var expiry timestamp
+		status, _, _ := procAcquireCredentialsHandleW.Call(
+			0,
+			uintptr(unsafe.Pointer(pkgName)),
+			secpkgCredOutbound,
+			0, 0, 0, 0,
+			uintptr(unsafe.Pointer(&i.cred)),
+			uintptr(unsafe.Pointer(&expiry)),
+		)
+		if status != secEOK {
+			return nil, sspiError("AcquireCredentialsHandle", status)
+		}
+		runtime.SetFinalizer(i, (*sspiInitiator).Close)
+		return i, nil
+	}
+}
+
+func (i *sspiInitiator) InitSecContext(token []byte) ([]byte, bool, error) {
+	out := secBuffer{bufferType: secbufferToken}
+	outDesc := secBufferDesc{version: sspiBufferDescVersion, count: 1, buffers: &out}
+
+	var inDesc *secBufferDesc
+	var in secBuffer
+	if len(token) > 0 {
+		in = secBuffer{size: uint32(len(token)), bufferType: secbufferToken, buffer: &token[0]}
+		inDesc = &secBufferDesc{version: sspiBufferDescVersion, count: 1, buffers: &in}
+	}
+
+	var ctx *secHandle
+	if i.hasCtx {
+		ctx = &i.ctx
+	}
+	var attrs uint32
+	var expiry timestamp
+	status, _, _ := procInitializeSecurityContextW.Call(
+		uintptr(unsafe.Pointer(&i.cred)),
+		uintptr(unsafe.Pointer(ctx)),
+		uintptr(unsafe.Pointer(i.target)),
+		uintptr(i.flags),
+		0,
+		securityNativeDrep,
+		uintptr(unsafe.Pointer(inDesc)),
+		0,
+		uintptr(unsafe.Pointer(&i.ctx)),
+		uintptr(unsafe.Pointer(&outDesc)),
+		uintptr(unsafe.Pointer(&attrs)),
+		uintptr(unsafe.Pointer(&expiry)),
+	)
+	runtime.KeepAlive(token)
+	if status != secEOK && status != secIContinueNeeded {
+		return nil, false, sspiError("InitializeSecurityContext", status)
+	}
+	i.hasCtx = true
+
+	var resp []byte
+	if out.buffer != nil {
+		resp = append([]byte(nil), unsafe.Slice(out.buffer, out.size)...)
+		procFreeContextBuffer.Call(uintptr(unsafe.Pointer(out.buffer)))
+	}
+	if status == secIContinueNeeded {
+		return resp, false, nil
+	}
+
+	if attrs&iscRetMutualAuth == 0 {
+		return nil, false, ErrSSPIMutualAuth
+	}
+	status, _, _ = procQueryContextAttributesW.Call(
+		uintptr(unsafe.Pointer(&i.ctx)),
+		secpkgAttrSizes,
+		uintptr(unsafe.Pointer(&i.sizes)),
+	)
+	if status != secEOK {
+		return nil, false, sspiError("QueryContextAttributes", status)
+	}
+	return resp, true, nil
+}
+
+// Wrap signs msg without encrypting it.
+func (i *sspiInitiator) Wrap(msg []byte) ([]byte, error) {
+	return i.encrypt(msg, secqopWrapNoEncrypt)
+}
+
+// Seal signs and encrypts msg.
+func (i *sspiInitiator) Seal(msg []byte) ([]byte, error) {
+	return i.encrypt(msg, 0)
+}
+
+// WrapSizeLimit subtracts the size of the trailer and the largest padding from
+// maxOutput.
+func (i *sspiInitiator) WrapSizeLimit(maxOutput int, conf bool) int {
+	return maxOutput - int(i.sizes.securityTrailer) - int(i.sizes.blockSize)
+}
+
+func (i *sspiInitiator) encrypt(msg []byte, qop uintptr) ([]byte, error) {
+	trailer := make([]byte, i.sizes.securityTrailer)
+	data := append([]byte(nil), msg...)
+	padding := make([]byte, i.sizes.blockSize)
+	bufs := []secBuffer{
+		{size: uint32(len(trailer)), bufferType: secbufferToken},
+		{size: uint32(len(data)), bufferType: secbufferData},
+		{size: uint32(len(padding)), bufferType: secbufferPadding},
+	}
+	if len(trailer) > 0 {
+		bufs[0].buffer = &trailer[0]
+	}
+	if len(data) > 0 {
+		bufs[1].buffer = &data[0]
+	}
+	if len(padding) > 0 {
+		bufs[2].buffer = &padding[0]
+	}
+	desc := secBufferDesc{version: sspiBufferDescVersion, count: uint32(len(bufs)), buffers: &bufs[0]}
+	status, _, _ := procEncryptMessage.Call(
+		uintptr(unsafe.Pointer(&i.ctx)),
+		qop,
+		uintptr(unsafe.Pointer(&desc)),
+		0,
+	)
+	runtime.KeepAlive(trailer)
+	runtime.KeepAlive(data)
+	runtime.KeepAlive(padding)
+	if status != secEOK {
+		return nil, sspiError("EncryptMessage", status)
+	}
+	out := make([]byte, 0, bufs[0].size+bufs[1].size+bufs[2].size)
+	out = append(out, trailer[:bufs[0].size]...)
+	out = append(out, data[:bufs[1].size]...)
+	return append(out, padding[:bufs[2].size]...), nil
+}
+
+func (i *sspiInitiator) Unwrap(token []byte) ([]byte, error) {
+	if len(token) == 0 {
+		return nil, syscall.EINVAL
+	}
+	stream := append([]byte(nil), token...)
+	bufs := []secBuffer{
+		{size: uint32(len(stream)), bufferType: secbufferStream, buffer: &stream[0]},
+		{bufferType: secbufferData},
+	}
+	desc := secBufferDesc{version: sspiBufferDescVersion, count: uint32(len(bufs)), buffers: &bufs[0]}
+	var qop uint32
+	status, _, _ := procDecryptMessage.Call(
+		uintptr(unsafe.Pointer(&i.ctx)),
+		uintptr(unsafe.Pointer(&desc)),
+		0,
+		uintptr(unsafe.Pointer(&qop)),
+	)
+	if status != secEOK {
+		return nil, sspiError("DecryptMessage", status)
+	}
+	// The data buffer points into stream.
+	msg := append([]byte(nil), unsafe.Slice(bufs[1].buffer, bufs[1].size)...)
+	runtime.KeepAlive(stream)
+	return msg, nil
+}
+
+// Close releases the security context and credentials.
+func (i *sspiInitiator) Close() error {
+	if i.closed {
+		return nil
+	}
+	i.closed = true
+	runtime.SetFinalizer(i, nil)
+	if i.hasCtx {
+		procDeleteSecurityContext.Call(uintptr(unsafe.Pointer(&i.ctx)))
+	}
+	procFreeCredentialsHandle.Call(uintptr(unsafe.Pointer(&i.cred)))
+	return nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package imapsasl implements the client side of the IMAP AUTHENTICATE command
+// (RFC 3501 §6.2.2) including the SASL-IR initial response extension
+// (RFC 4959) for any client negotiator.
+package imapsasl
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/jh125486/sasl"
+)
+
+// Error is returned when the server completes the AUTHENTICATE command with a
+// status other than OK.
+type Error struct {
+	// Status is the status of the tagged response, NO or BAD.
+	Status string
+
+	// Text is the human readable text sent by the server.
+	Text string
+}
+
+func (e *Error) Error() string {
+	return "imapsasl: authentication failed: " + e.Status + " " + e.Text
+}
+
+// Unwrap returns sasl.ErrAuthn so that all failures can be detected with
+// errors.Is.
+func (e *Error) Unwrap() error {
+	return sasl.ErrAuthn
+}
+
+// Temporary reports whether the server sent the UNAVAILABLE response code
+// (RFC 5530), meaning that authentication may succeed if it is retried later.
+func (e *Error) Temporary() bool {
+	if e.Status != "NO" || !strings.HasPrefix(e.Text, "[") {
+		return false
+	}
+	code, _, ok := strings.Cut(e.Text[1:], "]")
+	code, _, _ = strings.Cut(code, " ")
+	return ok && strings.EqualFold(code, "UNAVAILABLE")
+}
+
+var errMalformed = errors.New("imapsasl: malformed server response")
+
+// Authenticate runs the AUTHENTICATE command with the given tag over rw using
+// the client negotiator n.
+// If saslIR is true the server must have advertised the SASL-IR capability and
+// the initial response is sent with the command.
+//
+// Untagged responses received during the exchange are ignored.
+// If rw is a *bufio.ReadWriter its reader is used directly so that no data is
+// lost after the command completes.
+func Authenticate(ctx context.Context, rw io.ReadWriter, tag string, n *sasl.Negotiator, saslIR bool) error {
+	return sasl.NegotiateConn(ctx, rw, n, NewCodec(rw, tag, n.Mechanism().Name, saslIR))
+}
+
+// NewCodec returns a codec that frames the AUTHENTICATE command for use with
+// sasl.NegotiateConn.
+// Responses from the server are read from r.
+func NewCodec(r io.Reader, tag, mechanism string, saslIR bool) sasl.Codec {
+	c := &codec{tag: tag, mechanism: mechanism, saslIR: saslIR, decode: base64.StdEncoding.DecodeString}
+	if brw, ok := r.(*bufio.ReadWriter); ok {
+		c.r = brw.Reader
+	} else if br, ok := r.(*bufio.Reader); ok {
+		c.r = br
+	} else {
+		c.r = bufio.NewReader(r)
+	}
+	return c
+}
+
+type codec struct {
+	tag       string
+	mechanism string
+	saslIR    bool
+	started   bool
+	r         *bufio.Reader
+	decode    func(string) ([]byte, error)
+}
+
+func (c *codec) WriteResponse(w io.Writer, resp []byte) error {
+	if c.started {
+		return writeLine(w, base64.StdEncoding.EncodeToString(resp))
+	}
+	c.started = true
+
+	cmd := c.tag + " AUTHENTICATE " + c.mechanism
+	if resp == nil {
+		// The mechanism has no initial response so the first continuation request
+		// is its first challenge.
+		return writeLine(w, cmd)
+	}
+	if c.saslIR {
+		ir, _ := sasl.EncodeInitialResponse(resp, sasl.EmptyAsEquals)
+		return writeLine(w, cmd+" "+ir)
+	}
+	if err := writeLine(w, cmd); err != nil {
+		return err
+	}
+	// Without SASL-IR the server prompts for the initial response with an empty
+	// continuation request.
+	challenge, done, err := c.ReadChallenge(nil)
+	switch {
+	case err != nil:
+		return err
+	case done || len(challenge) != 0:
+		return errMalformed
+	}
+	return writeLine(w, base64.StdEncoding.EncodeToString(resp))
+}
+
+// SetDecoder implements sasl.Base64Codec.
+func (c *codec) SetDecoder(decode func(string) ([]byte, error)) {
+	c.decode = decode
+}
+
+func (c *codec) ReadChallenge(io.Reader) ([]byte, bool, error) {
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, false, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "+" || strings.HasPrefix(line, "+ "):
+			challenge, err := c.decode(strings.TrimSpace(line[1:]))
+			if err != nil {
+				return nil, false, errMalformed
+			}
+			return challenge, false, nil
+		case strings.HasPrefix(line, "* "):
+			continue
+		case strings.HasPrefix(line, c.tag+" "):
+			status, text, _ := strings.Cut(line[len(c.tag)+1:], " ")
+			if strings.EqualFold(status, "OK") {
+				return nil, true, nil
+			}
+			return nil, false, &Error{Status: strings.ToUpper(status), Text: text}
+		}
+		return nil, false, errMalformed
+	}
+}
+
+// Abort cancels the exchange as described in RFC 3501 §6.2.2.
+func (c *codec) Abort(w io.Writer) error {
+	if err := writeLine(w, "*"); err != nil {
+		return err
+	}
+	// Consume the tagged BAD response.
+	_, _, err := c.ReadChallenge(nil)
+	var imapErr *Error
+	if errors.As(err, &imapErr) {
+		return nil
+	}
+	return err
+}
+
+func writeLine(w io.Writer, s string) error {
+	_, err := io.WriteString(w, s+"\r\n")
+	if err != nil {
+		return err
+	}
+	if bw, ok := w.(interface{ Flush() error }); ok {
+		return bw.Flush()
+	}
+	return nil
+}
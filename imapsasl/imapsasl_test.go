@@ -0,0 +1,241 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package imapsasl_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/imapsasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+// serve implements just enough of an IMAP server to handle a single
+// AUTHENTICATE command. It returns the lines received from the client.
+func serve(conn net.Conn, server *sasl.Negotiator, saslIR bool) []string {
+	defer conn.Close()
+	var lines []string
+	r := bufio.NewReader(conn)
+	readLine := func() (string, bool) {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSuffix(line, "\r\n")
+		lines = append(lines, line)
+		return line, err == nil
+	}
+
+	cmd, ok := readLine()
+	if !ok {
+		return lines
+	}
+	fields := strings.Fields(cmd)
+	tag := fields[0]
+	io.WriteString(conn, "* OK untagged responses are ignored\r\n")
+
+	var resp string
+	switch {
+	case len(fields) == 4 && saslIR:
+		resp = fields[3]
+		if resp == "=" {
+			resp = ""
+		}
+	case len(fields) == 3:
+		io.WriteString(conn, "+ \r\n")
+		if resp, ok = readLine(); !ok {
+			return lines
+		}
+	default:
+		io.WriteString(conn, tag+" BAD unexpected command\r\n")
+		return lines
+	}
+
+	for {
+		if resp == "*" {
+			io.WriteString(conn, tag+" BAD canceled\r\n")
+			return lines
+		}
+		b, _ := base64.StdEncoding.DecodeString(resp)
+		if server.Completed() {
+			io.WriteString(conn, tag+" OK done\r\n")
+			return lines
+		}
+		more, challenge, err := server.Step(b)
+		switch {
+		case err != nil:
+			io.WriteString(conn, tag+" NO [AUTHENTICATIONFAILED] invalid credentials\r\n")
+			return lines
+		case !more && len(challenge) == 0:
+			io.WriteString(conn, tag+" OK done\r\n")
+			return lines
+		}
+		io.WriteString(conn, "+ "+base64.StdEncoding.EncodeToString(challenge)+"\r\n")
+		if resp, ok = readLine(); !ok {
+			return lines
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		password string
+		saslIR   bool
+		fail     bool
+	}{
+		{name: "plain-ir", mech: sasl.Plain, password: "pencil", saslIR: true},
+		{name: "plain", mech: sasl.Plain, password: "pencil"},
+		{name: "plain-bad-password", mech: sasl.Plain, password: "pen", saslIR: true, fail: true},
+		{name: "scram-ir", mech: sasl.ScramSha256, password: "pencil", saslIR: true},
+		{name: "scram", mech: sasl.ScramSha256, password: "pencil"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			server := sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s))
+			go serve(serverConn, server, tc.saslIR)
+
+			client := sasl.NewClient(tc.mech, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(tc.password), nil
+			}))
+			err := imapsasl.Authenticate(context.Background(), clientConn, "a001", client, tc.saslIR)
+			switch {
+			case tc.fail && !errors.Is(err, sasl.ErrAuthn):
+				t.Errorf("Expected authentication error, got %v", err)
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestEmptyInitialResponse(t *testing.T) {
+	empty := sasl.Mechanism{
+		Name: "X-EMPTY",
+		Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+			return false, []byte{}, nil, nil
+		},
+		Next: func(*sasl.Negotiator, []byte, interface{}) (bool, []byte, interface{}, error) {
+			return false, nil, nil, nil
+		},
+	}
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	lines := make(chan []string, 1)
+	go func() {
+		lines <- serve(serverConn, sasl.NewServer(empty, nil), true)
+	}()
+	if err := imapsasl.Authenticate(context.Background(), clientConn, "a001", sasl.NewClient(empty), true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := <-lines; got[0] != "a001 AUTHENTICATE X-EMPTY =" {
+		t.Errorf("Unexpected command: %q", got[0])
+	}
+}
+
+func TestNoInitialResponse(t *testing.T) {
+	// A server-first mechanism that answers the first challenge with a fixed
+	// response.
+	serverFirst := sasl.Mechanism{
+		Name: "X-SERVER-FIRST",
+		Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+			return true, nil, nil, nil
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+			if n.State()&sasl.Receiving == sasl.Receiving {
+				if string(challenge) != "hello" {
+					return false, nil, nil, sasl.ErrAuthn
+				}
+				return false, nil, nil, nil
+			}
+			return false, []byte("hello"), nil, nil
+		},
+	}
+	for _, saslIR := range []bool{true, false} {
+		clientConn, serverConn := net.Pipe()
+		lines := make(chan []string, 1)
+		go func() {
+			lines <- serve(serverConn, sasl.NewServer(serverFirst, nil), saslIR)
+		}()
+		err := imapsasl.Authenticate(context.Background(), clientConn, "a001", sasl.NewClient(serverFirst), saslIR)
+		clientConn.Close()
+		if err != nil {
+			t.Fatalf("SASL-IR %t: unexpected error: %v", saslIR, err)
+		}
+		if got := <-lines; got[0] != "a001 AUTHENTICATE X-SERVER-FIRST" {
+			t.Errorf("SASL-IR %t: unexpected command: %q", saslIR, got[0])
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	lines := make(chan []string, 1)
+	go func() {
+		defer serverConn.Close()
+		r := bufio.NewReader(serverConn)
+		cmd, _ := r.ReadString('\n')
+		// Reply with a server-first-message containing a nonce that does not
+		// match.
+		io.WriteString(serverConn, "+ "+base64.StdEncoding.EncodeToString([]byte("r=abc,s=c2FsdA==,i=4096"))+"\r\n")
+		abort, _ := r.ReadString('\n')
+		io.WriteString(serverConn, "a001 BAD canceled\r\n")
+		lines <- []string{cmd, abort}
+	}()
+
+	client := sasl.NewClient(sasl.ScramSha256, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	if err := imapsasl.Authenticate(context.Background(), clientConn, "a001", client, true); err == nil {
+		t.Fatal("Expected authentication to fail")
+	}
+	if got := <-lines; got[1] != "*\r\n" {
+		t.Errorf("Expected exchange to be canceled, got %q", got[1])
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	for _, tc := range []struct {
+		err  *imapsasl.Error
+		temp bool
+	}{
+		{err: &imapsasl.Error{Status: "NO", Text: "[UNAVAILABLE] try again later"}, temp: true},
+		{err: &imapsasl.Error{Status: "NO", Text: "[unavailable]"}, temp: true},
+		{err: &imapsasl.Error{Status: "NO", Text: "[AUTHENTICATIONFAILED] invalid credentials"}},
+		{err: &imapsasl.Error{Status: "NO", Text: "UNAVAILABLE"}},
+		{err: &imapsasl.Error{Status: "BAD", Text: "[UNAVAILABLE]"}},
+	} {
+		if temp := sasl.IsTemporary(tc.err); temp != tc.temp {
+			t.Errorf("IsTemporary(%v) = %t, want %t", tc.err, temp, tc.temp)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/tls"
+)
+
+// Stepper is the minimal interface implemented by Negotiator, SyncNegotiator,
+// and any wrappers around them.
+// Code that only needs to drive a negotiation can accept a Stepper and discover
+// optional capabilities with a type assertion on one of the interfaces below,
+// which lets new capabilities be added without breaking existing
+// implementations.
+type Stepper interface {
+	Step(challenge []byte) (more bool, resp []byte, err error)
+	Completed() bool
+}
+
+// Identityer is implemented by negotiators that can report the credentials
+// used in the negotiation.
+type Identityer interface {
+	Credentials() (username, password, identity []byte)
+}
+
+// ChannelBinder is implemented by negotiators that can bind the negotiation to
+// a TLS connection.
+type ChannelBinder interface {
+	TLSState() *tls.ConnectionState
+}
+
+// ServerVerifier is implemented by client negotiators that can report whether
+// the mechanism authenticated the server.
+type ServerVerifier interface {
+	VerifiedServer() bool
+}
+
+var (
+	_ Stepper        = (*Negotiator)(nil)
+	_ Identityer     = (*Negotiator)(nil)
+	_ ChannelBinder  = (*Negotiator)(nil)
+	_ ServerVerifier = (*Negotiator)(nil)
+	_ Stepper        = (*SyncNegotiator)(nil)
+	_ Identityer     = (*SyncNegotiator)(nil)
+	_ ChannelBinder  = (*SyncNegotiator)(nil)
+	_ ServerVerifier = (*SyncNegotiator)(nil)
+)
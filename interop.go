@@ -0,0 +1,87 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Workaround is a set of workarounds for remote clients and servers that do
+// not follow the specifications.
+type Workaround uint8
+
+// Workarounds for common broken implementations.
+// None of them are enabled by default.
+const (
+	// WorkaroundMissingGS2Header makes SCRAM servers accept client-first
+	// messages that start with the username instead of a GS2 header, treating
+	// them as if they started with "n,," (no channel binding and no
+	// authorization identity).
+	WorkaroundMissingGS2Header Workaround = 1 << iota
+
+	// WorkaroundWhitespace ignores spaces, tabs, and line endings around SCRAM
+	// attributes.
+	WorkaroundWhitespace
+
+	// WorkaroundUnpaddedBase64 accepts base64 encoded SCRAM attributes (the
+	// salt, channel binding, proof, and server signature) without padding.
+	WorkaroundUnpaddedBase64
+
+	// WorkaroundMechanismCase matches the names passed to the RemoteMechanisms
+	// option without regard to case, so that peers that advertise lowercase
+	// mechanism names can still use channel binding and mechanism pinning.
+	WorkaroundMechanismCase
+
+	// AllWorkarounds enables every workaround.
+	AllWorkarounds = WorkaroundMissingGS2Header | WorkaroundWhitespace | WorkaroundUnpaddedBase64 | WorkaroundMechanismCase
+)
+
+// Interop enables workarounds for broken remote clients or servers.
+// It may be used more than once to enable several workarounds.
+//
+// The workarounds only change how received messages are parsed: messages are
+// always included in the SCRAM AuthMessage exactly as they were received and
+// the messages that are sent are unchanged.
+func Interop(w Workaround) Option {
+	return func(n *Negotiator) {
+		n.workarounds |= w
+	}
+}
+
+// applyWorkarounds adjusts options that depend on workarounds once all options
+// have been applied.
+func (c *Negotiator) applyWorkarounds() {
+	if c.workarounds&WorkaroundMechanismCase != 0 && c.remoteMechanisms != nil {
+		remote := make([]string, len(c.remoteMechanisms))
+		for i, name := range c.remoteMechanisms {
+			remote[i] = strings.ToUpper(name)
+		}
+		c.remoteMechanisms = remote
+	}
+}
+
+// normalizeScram returns a SCRAM message with the whitespace and base64
+// workarounds applied so that it can be parsed as if it were well formed.
+// If neither workaround is enabled msg is returned unchanged.
+func (c *Negotiator) normalizeScram(msg []byte) []byte {
+	w := c.workarounds & (WorkaroundWhitespace | WorkaroundUnpaddedBase64)
+	if w == 0 {
+		return msg
+	}
+	fields := bytes.Split(msg, []byte{','})
+	for i, field := range fields {
+		if w&WorkaroundWhitespace != 0 {
+			field = bytes.TrimSpace(field)
+		}
+		if w&WorkaroundUnpaddedBase64 != 0 && len(field) > 2 && field[1] == '=' && strings.IndexByte("scpv", field[0]) != -1 {
+			if pad := (4 - (len(field)-2)%4) % 4; pad < 3 {
+				field = append(field[:len(field):len(field)], "=="[:pad]...)
+			}
+		}
+		fields[i] = field
+	}
+	return bytes.Join(fields, []byte{','})
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// negotiateMangled is like negotiate except that each client message is passed
+// through mangleResp and each server message through mangleChallenge.
+func negotiateMangled(client, server *Negotiator, mangleResp, mangleChallenge func(int, []byte) []byte) error {
+	var challenge []byte
+	for i := 0; ; i++ {
+		clientMore, resp, err := client.Step(challenge)
+		if err != nil || server.Completed() {
+			return err
+		}
+		var serverMore bool
+		serverMore, challenge, err = server.Step(mangleResp(i, resp))
+		if err != nil || (!serverMore && !clientMore) {
+			return err
+		}
+		challenge = mangleChallenge(i, challenge)
+	}
+}
+
+func TestInterop(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	creds := Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	})
+	unchanged := func(_ int, b []byte) []byte { return b }
+
+	for _, tc := range []struct {
+		name            string
+		w               Workaround
+		mangleResp      func(int, []byte) []byte
+		mangleChallenge func(int, []byte) []byte
+	}{
+		{
+			name: "missing-gs2-header",
+			w:    WorkaroundMissingGS2Header,
+			mangleResp: func(i int, b []byte) []byte {
+				if i == 0 {
+					return bytes.TrimPrefix(b, []byte("n,,"))
+				}
+				return b
+			},
+			mangleChallenge: unchanged,
+		},
+		{
+			name: "whitespace",
+			w:    WorkaroundWhitespace,
+			mangleResp: func(i int, b []byte) []byte {
+				if i == 1 {
+					return append(b, " \r\n"...)
+				}
+				return b
+			},
+			mangleChallenge: func(_ int, b []byte) []byte {
+				if bytes.HasPrefix(b, []byte("v=")) {
+					return append([]byte(" "), append(b, "\r\n"...)...)
+				}
+				return b
+			},
+		},
+		{
+			name: "unpadded-base64",
+			w:    WorkaroundUnpaddedBase64,
+			mangleResp: func(i int, b []byte) []byte {
+				if i == 1 {
+					return bytes.TrimRight(b, "=")
+				}
+				return b
+			},
+			mangleChallenge: func(_ int, b []byte) []byte {
+				if bytes.HasPrefix(b, []byte("v=")) {
+					return bytes.TrimRight(b, "=")
+				}
+				return b
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			newPair := func(opts ...Option) (*Negotiator, *Negotiator) {
+				return NewClient(ScramSha256, append(opts, creds)...), NewServer(ScramSha256, acceptAll, append(opts, Store(store))...)
+			}
+			client, server := newPair()
+			if err := negotiateMangled(client, server, tc.mangleResp, tc.mangleChallenge); err == nil {
+				t.Errorf("Expected negotiation to fail without the workaround")
+			}
+			client, server = newPair(Interop(tc.w))
+			if err := negotiateMangled(client, server, tc.mangleResp, tc.mangleChallenge); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !client.Authenticated() || !server.Authenticated() {
+				t.Errorf("Expected both sides to authenticate")
+			}
+		})
+	}
+}
+
+func TestInteropMechanismCase(t *testing.T) {
+	remote := RemoteMechanisms("scram-sha-256-plus")
+	if NewClient(ScramSha256Plus, remote).State().RemoteSupportsCB() {
+		t.Errorf("Lowercase mechanism matched without the workaround")
+	}
+	if !NewClient(ScramSha256Plus, remote, Interop(WorkaroundMechanismCase)).State().RemoteSupportsCB() {
+		t.Errorf("Lowercase mechanism did not match with the workaround")
+	}
+}
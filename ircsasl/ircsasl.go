@@ -0,0 +1,171 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package ircsasl implements the IRCv3 SASL AUTHENTICATE framing for
+// negotiators from the sasl package.
+//
+// Messages are base64 encoded and split into 400 byte chunks, each sent as the
+// parameter of an AUTHENTICATE command.
+// A message whose encoding is an exact multiple of 400 bytes (including an
+// empty message) is terminated by an AUTHENTICATE command with the parameter
+// "+".
+// Reading and writing IRC lines is left to the caller.
+package ircsasl
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"github.com/jh125486/sasl"
+)
+
+// ChunkSize is the maximum length of an AUTHENTICATE parameter.
+const ChunkSize = 400
+
+// Numerics sent by the server at the end of the exchange.
+const (
+	RplLoggedIn    = "900"
+	RplSASLSuccess = "903"
+	ErrSASLFail    = "904"
+	ErrSASLTooLong = "905"
+	ErrSASLAborted = "906"
+	ErrSASLAlready = "907"
+)
+
+// Abort is the AUTHENTICATE parameter used to cancel the exchange.
+const Abort = "*"
+
+var errMalformed = errors.New("ircsasl: malformed AUTHENTICATE parameter")
+
+// Error is returned when the server ends the exchange with a failure numeric.
+type Error struct {
+	Numeric string
+}
+
+func (e *Error) Error() string {
+	return "ircsasl: authentication failed with numeric " + e.Numeric
+}
+
+// Unwrap returns sasl.ErrAuthn if the server rejected the credentials.
+func (e *Error) Unwrap() error {
+	if e.Numeric == ErrSASLFail {
+		return sasl.ErrAuthn
+	}
+	return nil
+}
+
+// Encode returns the AUTHENTICATE parameters needed to send msg.
+func Encode(msg []byte) []string {
+	enc := base64.StdEncoding.EncodeToString(msg)
+	var params []string
+	for len(enc) >= ChunkSize {
+		params = append(params, enc[:ChunkSize])
+		enc = enc[ChunkSize:]
+	}
+	if enc == "" {
+		enc = "+"
+	}
+	return append(params, enc)
+}
+
+// Decoder reassembles messages from AUTHENTICATE parameters.
+// The zero value is ready to use.
+type Decoder struct {
+	buf []byte
+
+	// DecodeBase64 decodes each complete message.
+	// If it is nil, standard base64 is required.
+	DecodeBase64 func(string) ([]byte, error)
+}
+
+// Decode adds the AUTHENTICATE parameter param to the current message.
+// Once the message is complete it is returned with done set to true and the
+// decoder is reset.
+func (d *Decoder) Decode(param string) (msg []byte, done bool, err error) {
+	if param != "+" {
+		if len(param) > ChunkSize {
+			return nil, false, errMalformed
+		}
+		if len(d.buf)+len(param) > base64.StdEncoding.EncodedLen(sasl.DefaultMaxMessageSize) {
+			d.buf = nil
+			return nil, false, sasl.ErrMessageTooLarge
+		}
+		d.buf = append(d.buf, param...)
+		if len(param) == ChunkSize {
+			return nil, false, nil
+		}
+	}
+	enc := d.buf
+	d.buf = nil
+	if d.DecodeBase64 != nil {
+		if msg, err = d.DecodeBase64(string(enc)); err != nil {
+			return nil, false, errMalformed
+		}
+		return msg, true, nil
+	}
+	msg = make([]byte, base64.StdEncoding.DecodedLen(len(enc)))
+	n, err := base64.StdEncoding.Decode(msg, enc)
+	if err != nil {
+		return nil, false, errMalformed
+	}
+	return msg[:n], true, nil
+}
+
+// Client drives a client negotiator using AUTHENTICATE commands.
+type Client struct {
+	n       *sasl.Negotiator
+	dec     Decoder
+	started bool
+}
+
+// NewClient returns a client that authenticates using the client negotiator n.
+func NewClient(n *sasl.Negotiator) *Client {
+	return &Client{n: n, dec: Decoder{DecodeBase64: n.DecodeBase64}}
+}
+
+// Mechanism returns the parameter of the first AUTHENTICATE command, which
+// selects the mechanism.
+func (c *Client) Mechanism() string {
+	return c.n.Mechanism().Name
+}
+
+// Authenticate handles an AUTHENTICATE command from the server and returns the
+// parameters of the AUTHENTICATE commands to send in response, if any.
+// If an error is returned the caller should send an AUTHENTICATE command with
+// the Abort parameter.
+func (c *Client) Authenticate(param string) ([]string, error) {
+	challenge, done, err := c.dec.Decode(param)
+	if err != nil || !done {
+		return nil, err
+	}
+	if !c.started {
+		// The server responds to the mechanism selection with an empty challenge
+		// to request the initial response.
+		c.started = true
+		if len(challenge) != 0 {
+			return nil, sasl.ErrInvalidChallenge
+		}
+		challenge = nil
+	}
+	_, resp, err := c.n.Step(challenge)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(resp), nil
+}
+
+// Numeric handles the numeric that ends the exchange.
+// RplLoggedIn is informational and is ignored.
+func (c *Client) Numeric(numeric string) error {
+	switch numeric {
+	case RplLoggedIn:
+		return nil
+	case RplSASLSuccess:
+		if !c.n.Completed() {
+			return sasl.ErrAuthn
+		}
+		return nil
+	}
+	return &Error{Numeric: numeric}
+}
@@ -0,0 +1,163 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package ircsasl_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/ircsasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for i, tc := range []struct {
+		size   int
+		chunks []int
+	}{
+		0: {size: 0, chunks: []int{1}},
+		1: {size: 3, chunks: []int{4}},
+		2: {size: 300, chunks: []int{400, 1}},
+		3: {size: 301, chunks: []int{400, 4}},
+		4: {size: 600, chunks: []int{400, 400, 1}},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			msg := bytes.Repeat([]byte{'x'}, tc.size)
+			params := ircsasl.Encode(msg)
+			var lens []int
+			for _, p := range params {
+				lens = append(lens, len(p))
+			}
+			if !reflect.DeepEqual(lens, tc.chunks) {
+				t.Fatalf("Unexpected chunk sizes: want=%v, got=%v", tc.chunks, lens)
+			}
+			if params[len(params)-1] != "+" && len(params[len(params)-1]) == ircsasl.ChunkSize {
+				t.Error("Message ending on a chunk boundary was not terminated")
+			}
+
+			var d ircsasl.Decoder
+			for j, p := range params {
+				out, done, err := d.Decode(p)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if last := j == len(params)-1; done != last {
+					t.Fatalf("Unexpected done on chunk %d: want=%t, got=%t", j, last, done)
+				}
+				if done && !bytes.Equal(out, msg) {
+					t.Errorf("Message did not round trip: want=%q, got=%q", msg, out)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	var d ircsasl.Decoder
+	if _, _, err := d.Decode("not base64!"); err == nil {
+		t.Error("Expected error decoding invalid base64")
+	}
+	if _, _, err := d.Decode(strings.Repeat("A", ircsasl.ChunkSize+1)); err == nil {
+		t.Error("Expected error decoding oversized chunk")
+	}
+}
+
+// exchange drives the client against a server negotiator as an IRC server
+// would, returning the final numeric.
+func exchange(t *testing.T, c *ircsasl.Client, server *sasl.Negotiator) error {
+	t.Helper()
+	if c.Mechanism() != server.Mechanism().Name {
+		return c.Numeric(ircsasl.ErrSASLFail)
+	}
+	var d ircsasl.Decoder
+	toClient := []string{"+"}
+	for {
+		var toServer []string
+		for _, p := range toClient {
+			params, err := c.Authenticate(p)
+			if err != nil {
+				return err
+			}
+			toServer = append(toServer, params...)
+		}
+		if len(toServer) == 0 {
+			t.Fatal("Client did not respond")
+		}
+		var resp []byte
+		for _, p := range toServer {
+			msg, done, err := d.Decode(p)
+			if err != nil {
+				t.Fatalf("Server could not decode response: %v", err)
+			}
+			if done {
+				resp = msg
+			}
+		}
+		if server.Completed() {
+			return c.Numeric(ircsasl.RplSASLSuccess)
+		}
+		more, challenge, err := server.Step(resp)
+		switch {
+		case err != nil:
+			return c.Numeric(ircsasl.ErrSASLFail)
+		case !more && len(challenge) == 0:
+			return c.Numeric(ircsasl.RplSASLSuccess)
+		}
+		toClient = ircsasl.Encode(challenge)
+	}
+}
+
+func TestClient(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		username string
+		password string
+		fail     bool
+	}{
+		{name: "plain", mech: sasl.Plain, username: "user", password: "pencil"},
+		{name: "plain-long", mech: sasl.Plain, username: strings.Repeat("u", 600), password: "pencil"},
+		{name: "plain-bad-password", mech: sasl.Plain, username: "user", password: "pen", fail: true},
+		{name: "scram", mech: sasl.ScramSha256, username: "user", password: "pencil"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, username: "user", password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := ircsasl.NewClient(sasl.NewClient(tc.mech, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte(tc.username), []byte(tc.password), nil
+			})))
+			err := exchange(t, client, sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s)))
+			switch {
+			case tc.fail && !errors.Is(err, sasl.ErrAuthn):
+				t.Errorf("Expected authentication error, got %v", err)
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package kafkasasl authenticates to Kafka brokers using the SaslHandshake (v1)
+// and SaslAuthenticate (v1) requests and negotiators from the sasl package.
+package kafkasasl
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jh125486/sasl"
+)
+
+// API keys and versions of the requests sent by Client.
+const (
+	APIKeySaslHandshake    int16 = 17
+	APIKeySaslAuthenticate int16 = 36
+	apiVersion             int16 = 1
+)
+
+// Kafka error codes returned during authentication.
+const (
+	ErrCodeNone                     int16 = 0
+	ErrCodeUnsupportedSaslMechanism int16 = 33
+	ErrCodeIllegalSaslState         int16 = 34
+	ErrCodeSaslAuthenticationFailed int16 = 58
+)
+
+const (
+	maxResponseSize  = sasl.DefaultMaxMessageSize
+	requestHeaderLen = 10
+)
+
+var errMalformed = errors.New("kafkasasl: malformed response")
+
+// Error is returned when the broker responds with a non-zero error code.
+type Error struct {
+	Code       int16
+	Message    string
+	Mechanisms []string
+}
+
+func (e *Error) Error() string {
+	s := "kafkasasl: broker returned error code " + strconv.Itoa(int(e.Code))
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+// Unwrap returns sasl.ErrAuthn if the broker rejected the credentials.
+func (e *Error) Unwrap() error {
+	if e.Code == ErrCodeSaslAuthenticationFailed {
+		return sasl.ErrAuthn
+	}
+	return nil
+}
+
+// Client sends SASL requests to a broker.
+type Client struct {
+	// ClientID is sent in the header of each request.
+	ClientID string
+
+	correlationID int32
+}
+
+// Handshake sends a SaslHandshake request selecting mechanism and returns the
+// mechanisms enabled on the broker.
+// If the mechanism is not enabled an *Error is returned that includes the
+// enabled mechanisms.
+func (c *Client) Handshake(rw io.ReadWriter, mechanism string) ([]string, error) {
+	body := appendString(nil, mechanism)
+	resp, err := c.roundTrip(rw, APIKeySaslHandshake, body)
+	if err != nil {
+		return nil, err
+	}
+	d := decoder(resp)
+	code := d.int16()
+	n := d.int32()
+	if n < 0 || int(n) > len(d) {
+		return nil, errMalformed
+	}
+	mechs := make([]string, 0, n)
+	for i := int32(0); i < n; i++ {
+		mechs = append(mechs, string(d.string16()))
+	}
+	if d == nil {
+		return nil, errMalformed
+	}
+	if code != ErrCodeNone {
+		return mechs, &Error{Code: code, Mechanisms: mechs}
+	}
+	return mechs, nil
+}
+
+// Authenticate sends a SaslHandshake request followed by SaslAuthenticate
+// requests carrying the raw messages from the client negotiator n until the
+// negotiation completes.
+// It returns the session lifetime reported by the broker, which is zero if the
+// broker does not require re-authentication.
+func (c *Client) Authenticate(rw io.ReadWriter, n *sasl.Negotiator) (time.Duration, error) {
+	if _, err := c.Handshake(rw, n.Mechanism().Name); err != nil {
+		return 0, err
+	}
+	_, token, err := n.Step(nil)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		resp, err := c.roundTrip(rw, APIKeySaslAuthenticate, appendBytes(nil, token))
+		if err != nil {
+			return 0, err
+		}
+		d := decoder(resp)
+		code := d.int16()
+		msg := d.string16()
+		challenge := d.bytes32()
+		lifetime := time.Duration(d.int64()) * time.Millisecond
+		if d == nil {
+			return 0, errMalformed
+		}
+		if code != ErrCodeNone {
+			return 0, &Error{Code: code, Message: string(msg)}
+		}
+		if n.Completed() {
+			return lifetime, nil
+		}
+		if _, token, err = n.Step(challenge); err != nil {
+			return 0, err
+		}
+		if n.Completed() && len(token) == 0 {
+			return lifetime, nil
+		}
+	}
+}
+
+func (c *Client) roundTrip(rw io.ReadWriter, apiKey int16, body []byte) ([]byte, error) {
+	c.correlationID++
+	req := make([]byte, 4, 4+requestHeaderLen+len(c.ClientID)+len(body))
+	req = binary.BigEndian.AppendUint16(req, uint16(apiKey))
+	req = binary.BigEndian.AppendUint16(req, uint16(apiVersion))
+	req = binary.BigEndian.AppendUint32(req, uint32(c.correlationID))
+	req = appendString(req, c.ClientID)
+	req = append(req, body...)
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4))
+	if _, err := rw.Write(req); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(rw, size[:]); err != nil {
+		return nil, err
+	}
+	l := binary.BigEndian.Uint32(size[:])
+	if l < 4 {
+		return nil, errMalformed
+	}
+	if l > maxResponseSize {
+		return nil, sasl.ErrMessageTooLarge
+	}
+	resp := make([]byte, l)
+	if _, err := io.ReadFull(rw, resp); err != nil {
+		return nil, err
+	}
+	if int32(binary.BigEndian.Uint32(resp)) != c.correlationID {
+		return nil, errMalformed
+	}
+	return resp[4:], nil
+}
+
+func appendString(dst []byte, s string) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(s)))
+	return append(dst, s...)
+}
+
+func appendBytes(dst, b []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(b)))
+	return append(dst, b...)
+}
+
+// decoder reads Kafka protocol primitives.
+// If the input is too short it is set to nil and all further reads return zero
+// values.
+type decoder []byte
+
+func (d *decoder) take(n int) []byte {
+	if n < 0 || len(*d) < n {
+		*d = nil
+		return nil
+	}
+	b := (*d)[:n:n]
+	*d = (*d)[n:]
+	return b
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string16 reads a nullable string; null is returned as nil.
+func (d *decoder) string16() []byte {
+	n := d.int16()
+	if n == -1 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+// bytes32 reads a nullable byte array; null is returned as nil.
+func (d *decoder) bytes32() []byte {
+	n := d.int32()
+	if n == -1 {
+		return nil
+	}
+	return d.take(int(n))
+}
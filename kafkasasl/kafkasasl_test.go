@@ -0,0 +1,139 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package kafkasasl_test
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/kafkasasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+// broker implements the broker side of the SASL requests.
+func broker(t *testing.T, conn net.Conn, server *sasl.Negotiator) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		io.ReadFull(conn, req)
+		apiKey := int16(binary.BigEndian.Uint16(req))
+		if v := binary.BigEndian.Uint16(req[2:]); v != 1 {
+			t.Errorf("Unexpected API version %d", v)
+		}
+		correlationID := req[4:8]
+		clientIDLen := int(binary.BigEndian.Uint16(req[8:]))
+		if clientID := string(req[10 : 10+clientIDLen]); clientID != "test" {
+			t.Errorf("Unexpected client ID %q", clientID)
+		}
+		body := req[10+clientIDLen:]
+
+		resp := append([]byte{}, correlationID...)
+		switch apiKey {
+		case kafkasasl.APIKeySaslHandshake:
+			mech := string(body[2:])
+			code := kafkasasl.ErrCodeNone
+			if mech != server.Mechanism().Name {
+				code = kafkasasl.ErrCodeUnsupportedSaslMechanism
+			}
+			resp = binary.BigEndian.AppendUint16(resp, uint16(code))
+			resp = binary.BigEndian.AppendUint32(resp, 1)
+			resp = binary.BigEndian.AppendUint16(resp, uint16(len(server.Mechanism().Name)))
+			resp = append(resp, server.Mechanism().Name...)
+		case kafkasasl.APIKeySaslAuthenticate:
+			_, challenge, err := server.Step(body[4:])
+			if err != nil {
+				msg := "Authentication failed"
+				resp = binary.BigEndian.AppendUint16(resp, uint16(kafkasasl.ErrCodeSaslAuthenticationFailed))
+				resp = binary.BigEndian.AppendUint16(resp, uint16(len(msg)))
+				resp = append(resp, msg...)
+				resp = binary.BigEndian.AppendUint32(resp, 0)
+				resp = binary.BigEndian.AppendUint64(resp, 0)
+				break
+			}
+			resp = binary.BigEndian.AppendUint16(resp, 0)
+			resp = binary.BigEndian.AppendUint16(resp, 0xffff)
+			resp = binary.BigEndian.AppendUint32(resp, uint32(len(challenge)))
+			resp = append(resp, challenge...)
+			resp = binary.BigEndian.AppendUint64(resp, 3600000)
+		}
+		conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(resp))), resp...))
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha512.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		client   sasl.Mechanism
+		server   sasl.Mechanism
+		password string
+		err      error
+	}{
+		{name: "plain", client: sasl.Plain, server: sasl.Plain, password: "pencil"},
+		{name: "plain-bad-password", client: sasl.Plain, server: sasl.Plain, password: "pen", err: sasl.ErrAuthn},
+		{name: "scram", client: sasl.ScramSha512, server: sasl.ScramSha512, password: "pencil"},
+		{name: "scram-bad-password", client: sasl.ScramSha512, server: sasl.ScramSha512, password: "pen", err: sasl.ErrAuthn},
+		{name: "unsupported", client: sasl.Plain, server: sasl.ScramSha512, password: "pencil"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			go broker(t, serverConn, sasl.NewServer(tc.server, acceptPencil, sasl.Store(s)))
+
+			client := sasl.NewClient(tc.client, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(tc.password), nil
+			}))
+			c := &kafkasasl.Client{ClientID: "test"}
+			lifetime, err := c.Authenticate(clientConn, client)
+
+			if tc.client.Name != tc.server.Name {
+				var kafkaErr *kafkasasl.Error
+				if !errors.As(err, &kafkaErr) || kafkaErr.Code != kafkasasl.ErrCodeUnsupportedSaslMechanism {
+					t.Fatalf("Expected unsupported mechanism error, got %v", err)
+				}
+				if want := []string{tc.server.Name}; !reflect.DeepEqual(kafkaErr.Mechanisms, want) {
+					t.Errorf("Unexpected mechanisms: want=%v, got=%v", want, kafkaErr.Mechanisms)
+				}
+				return
+			}
+			switch {
+			case tc.err != nil && !errors.Is(err, tc.err):
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			case tc.err == nil && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			case tc.err == nil && lifetime != time.Hour:
+				t.Errorf("Unexpected session lifetime: want=%v, got=%v", time.Hour, lifetime)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"sync"
+)
+
+// ScramKeys are the keys derived from a password by a SCRAM client.
+type ScramKeys struct {
+	ClientKey []byte
+	ServerKey []byte
+}
+
+// ScramSecret is a precomputed secret used by SCRAM clients in place of a
+// password.
+// Either SaltedPassword or both ClientKey and ServerKey must be set, and Salt
+// and Iterations must match the values sent by the server.
+type ScramSecret struct {
+	Salt           []byte
+	Iterations     int
+	SaltedPassword []byte
+	ClientKey      []byte
+	ServerKey      []byte
+}
+
+// String implements fmt.Stringer without revealing the secret.
+func (ScramSecret) String() string {
+	return "[REDACTED]"
+}
+
+// GoString is like String but is used by the %#v verb.
+func (ScramSecret) GoString() string {
+	return "sasl.ScramSecret{[REDACTED]}"
+}
+
+// PrecomputedSecret configures SCRAM clients to authenticate using a secret
+// derived ahead of time instead of the password returned by the credentials
+// callback.
+// The username and identity are still taken from the callback.
+func PrecomputedSecret(s ScramSecret) Option {
+	return func(n *Negotiator) {
+		n.scramSecret = &s
+	}
+}
+
+// KeyCacheID identifies a set of cached SCRAM keys.
+// Mechanism is the name of the SCRAM mechanism without the "-PLUS" suffix, so
+// that it identifies the hash function in use.
+type KeyCacheID struct {
+	Mechanism  string
+	Username   string
+	Salt       string
+	Iterations int
+}
+
+// A KeyCache stores the keys derived by SCRAM clients so that subsequent
+// authentications with the same salt and iteration count can skip the
+// expensive key derivation function as permitted by RFC 5802 §5.1.
+//
+// Keys are only added to the cache after the server signature has been
+// verified.
+// Because the password is not part of the ID, applications that change the
+// password of a user must remove any cached keys for that user.
+type KeyCache interface {
+	GetKeys(id KeyCacheID) (keys ScramKeys, ok bool)
+	PutKeys(id KeyCacheID, keys ScramKeys)
+}
+
+// CacheKeys sets a cache used by SCRAM clients to store derived keys.
+func CacheKeys(c KeyCache) Option {
+	return func(n *Negotiator) {
+		n.keyCache = c
+	}
+}
+
+// MemoryKeyCache is a KeyCache that stores keys in memory.
+// The zero value is an empty cache ready to use.
+type MemoryKeyCache struct {
+	mu   sync.Mutex
+	keys map[KeyCacheID]ScramKeys
+}
+
+// GetKeys returns the keys stored for id, if any.
+func (c *MemoryKeyCache) GetKeys(id KeyCacheID) (ScramKeys, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys, ok := c.keys[id]
+	return keys, ok
+}
+
+// PutKeys stores keys for id.
+func (c *MemoryKeyCache) PutKeys(id KeyCacheID, keys ScramKeys) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[KeyCacheID]ScramKeys)
+	}
+	c.keys[id] = keys
+}
+
+// Forget removes all keys cached for username.
+func (c *MemoryKeyCache) Forget(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.keys {
+		if id.Username == username {
+			delete(c.keys, id)
+		}
+	}
+}
+
+func keyCacheID(name string, username, salt []byte, iter int) KeyCacheID {
+	return KeyCacheID{
+		Mechanism:  TrimPlus(name),
+		Username:   string(username),
+		Salt:       string(salt),
+		Iterations: iter,
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package keyring implements sasl.SecretSource on top of the credential store
+// provided by the operating system so that desktop clients do not need to
+// store passwords in configuration files.
+//
+// The macOS Keychain is accessed using the security command, Windows
+// Credential Manager using the CredRead API, and the Secret Service (as
+// provided by GNOME Keyring and KWallet) on Linux and the BSDs using the
+// secret-tool command from libsecret.
+package keyring
+
+import (
+	"errors"
+
+	"github.com/jh125486/sasl"
+)
+
+// ErrUnsupported is returned on platforms without a supported credential
+// store.
+var ErrUnsupported = errors.New("keyring: no credential store is supported on this platform")
+
+// Keyring is a sasl.SecretSource that looks up secrets stored by an
+// application in the credential store of the operating system.
+// The key passed to Get is the account name, for example the user's email
+// address.
+//
+// Secrets are looked up as follows:
+//
+//   - macOS: a generic password with the service Service and the account key
+//   - Windows: a generic credential with the target name "Service:key"
+//   - Linux and the BSDs: a secret with the attributes service=Service and
+//     account=key
+//
+// If there is no such secret the error wraps sasl.ErrSecretNotFound.
+type Keyring struct {
+	Service string
+}
+
+var _ sasl.SecretSource = Keyring{}
@@ -0,0 +1,32 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/jh125486/sasl"
+)
+
+// errSecItemNotFound is the exit status of the security command when the item
+// does not exist.
+const errSecItemNotFound = 44
+
+// Get returns the password of the generic password item for key.
+func (k Keyring) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "security", "find-generic-password", "-s", k.Service, "-a", key, "-w").Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == errSecItemNotFound {
+		return nil, fmt.Errorf("%w: no keychain item for %s in %s", sasl.ErrSecretNotFound, key, k.Service)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+	return bytes.TrimSuffix(out, []byte{'\n'}), nil
+}
@@ -0,0 +1,16 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package keyring
+
+import (
+	"context"
+)
+
+// Get always returns ErrUnsupported.
+func (Keyring) Get(context.Context, string) ([]byte, error) {
+	return nil, ErrUnsupported
+}
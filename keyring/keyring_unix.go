@@ -0,0 +1,36 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build dragonfly || freebsd || linux || netbsd || openbsd
+
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/jh125486/sasl"
+)
+
+// secretTool is the command used to query the Secret Service.
+var secretTool = "secret-tool"
+
+// Get returns the secret with the attributes service=k.Service and
+// account=key.
+func (k Keyring) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, secretTool, "lookup", "service", k.Service, "account", key).Output()
+	var exitErr *exec.ExitError
+	// secret-tool exits with status 1 and no output if there is no matching
+	// secret.
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(exitErr.Stderr) == 0 {
+		return nil, fmt.Errorf("%w: no secret for %s in %s", sasl.ErrSecretNotFound, key, k.Service)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+	return bytes.TrimSuffix(out, []byte{'\n'}), nil
+}
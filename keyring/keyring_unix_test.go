@@ -0,0 +1,51 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build dragonfly || freebsd || linux || netbsd || openbsd
+
+package keyring
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jh125486/sasl"
+)
+
+// fakeSecretTool stores a single secret for the mail service.
+const fakeSecretTool = `#!/bin/sh
+if [ "$1 $2 $3 $4 $5" = "lookup service mail account kurt@example.com" ]; then
+	printf 'xipj3plmq\n'
+	exit 0
+fi
+if [ "$3" = "broken" ]; then
+	echo "Cannot create an item in a locked collection" >&2
+	exit 1
+fi
+exit 1
+`
+
+func TestSecretService(t *testing.T) {
+	tool := filepath.Join(t.TempDir(), "secret-tool")
+	if err := os.WriteFile(tool, []byte(fakeSecretTool), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { secretTool = old }(secretTool)
+	secretTool = tool
+
+	ctx := context.Background()
+	if b, err := (Keyring{Service: "mail"}).Get(ctx, "kurt@example.com"); err != nil || string(b) != "xipj3plmq" {
+		t.Errorf("Unexpected result: %q, %v", b, err)
+	}
+	if _, err := (Keyring{Service: "mail"}).Get(ctx, "ursel@example.com"); !errors.Is(err, sasl.ErrSecretNotFound) {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+	_, err := (Keyring{Service: "broken"}).Get(ctx, "kurt@example.com")
+	if err == nil || errors.Is(err, sasl.ErrSecretNotFound) {
+		t.Errorf("Expected error from a locked keyring, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package keyring
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/jh125486/sasl"
+)
+
+var (
+	advapi32     = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead = advapi32.NewProc("CredReadW")
+	procCredFree = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric = 1
+	errorNotFound   = syscall.Errno(1168)
+)
+
+// credential is the CREDENTIALW structure.
+type credential struct {
+	flags              uint32
+	typ                uint32
+	targetName         *uint16
+	comment            *uint16
+	lastWritten        syscall.Filetime
+	credentialBlobSize uint32
+	credentialBlob     *byte
+	persist            uint32
+	attributeCount     uint32
+	attributes         uintptr
+	targetAlias        *uint16
+	userName           *uint16
+}
+
+// Get returns the blob of the generic credential with the target name
+// "k.Service:key" as it was stored.
+func (k Keyring) Get(_ context.Context, key string) ([]byte, error) {
+	target, err := syscall.UTF16PtrFromString(k.Service + ":" + key)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+	var cred *credential
+	r, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if err == errorNotFound {
+			return nil, fmt.Errorf("%w: no credential for %s in %s", sasl.ErrSecretNotFound, key, k.Service)
+		}
+		return nil, fmt.Errorf("keyring: CredRead: %w", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.credentialBlobSize == 0 {
+		return []byte{}, nil
+	}
+	blob := unsafe.Slice(cred.credentialBlob, cred.credentialBlobSize)
+	return append([]byte(nil), blob...), nil
+}
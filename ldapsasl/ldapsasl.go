@@ -0,0 +1,255 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package ldapsasl performs LDAP SASL binds (RFC 4513 §5.2.2) using the
+// mechanisms in the sasl package.
+//
+// Bind drives multi-round binds given a function that sends a single bind
+// request, so it can be used with any LDAP library, and MarshalBindRequest and
+// UnmarshalBindResponse are provided for clients that build their own
+// protocol messages.
+package ldapsasl
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/jh125486/sasl"
+)
+
+// LDAP result codes used during SASL and simple binds.
+const (
+	Success            = 0
+	SASLBindInProgress = 14
+	InvalidCredentials = 49
+	Busy               = 51
+	Unavailable        = 52
+)
+
+// BindResponse is the subset of an LDAP BindResponse used during SASL binds.
+type BindResponse struct {
+	ResultCode        int
+	MatchedDN         string
+	DiagnosticMessage string
+
+	// ServerSASLCreds holds the raw (not base64 encoded) challenge or additional
+	// success data from the server, if any.
+	ServerSASLCreds []byte
+}
+
+// ResultError is returned by Bind when the server finishes the bind with a
+// result other than success.
+type ResultError struct {
+	ResultCode        int
+	DiagnosticMessage string
+}
+
+func (e *ResultError) Error() string {
+	s := "ldapsasl: bind failed with result code " + strconv.Itoa(e.ResultCode)
+	if e.DiagnosticMessage != "" {
+		s += ": " + e.DiagnosticMessage
+	}
+	return s
+}
+
+// Unwrap returns sasl.ErrAuthn so that all failures can be detected with
+// errors.Is.
+func (e *ResultError) Unwrap() error {
+	return sasl.ErrAuthn
+}
+
+// Temporary reports whether the server was busy or unavailable, meaning that
+// the bind may succeed if it is retried later.
+func (e *ResultError) Temporary() bool {
+	return e.ResultCode == Busy || e.ResultCode == Unavailable
+}
+
+// Bind performs a SASL bind using the client negotiator n.
+// The bind function is called for each round with the mechanism name and the
+// raw credentials (nil if no credentials should be sent) and must return the
+// server's response.
+func Bind(n *sasl.Negotiator, bind func(mechanism string, credentials []byte) (BindResponse, error)) error {
+	mechanism := n.Mechanism().Name
+	_, creds, err := n.Step(nil)
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := bind(mechanism, creds)
+		if err != nil {
+			return err
+		}
+		switch resp.ResultCode {
+		case SASLBindInProgress:
+			if _, creds, err = n.Step(resp.ServerSASLCreds); err != nil {
+				return err
+			}
+		case Success:
+			// The final data from the server (such as the SCRAM server signature)
+			// is sent with the success result.
+			return n.Finish(resp.ServerSASLCreds)
+		default:
+			return &ResultError{ResultCode: resp.ResultCode, DiagnosticMessage: resp.DiagnosticMessage}
+		}
+	}
+}
+
+// BER tags used by the bind operation.
+const (
+	tagInteger         = 0x02
+	tagOctetString     = 0x04
+	tagEnumerated      = 0x0a
+	tagSequence        = 0x30
+	tagBindRequest     = 0x60 // [APPLICATION 0] constructed
+	tagBindResponse    = 0x61 // [APPLICATION 1] constructed
+	tagSimple          = 0x80 // [0] primitive
+	tagSASLCredentials = 0xa3 // [3] constructed
+	tagReferral        = 0xa3 // [3] constructed
+	tagServerSASLCreds = 0x87 // [7] primitive
+)
+
+var errMalformed = errors.New("ldapsasl: malformed BindResponse")
+
+// MarshalBindRequest returns the BER encoding of an LDAPMessage containing a
+// SASL BindRequest.
+// If credentials is nil they are omitted from the request.
+func MarshalBindRequest(messageID int32, mechanism string, credentials []byte) []byte {
+	creds := appendTLV(nil, tagOctetString, []byte(mechanism))
+	if credentials != nil {
+		creds = appendTLV(creds, tagOctetString, credentials)
+	}
+	req := appendTLV(nil, tagInteger, []byte{3})
+	req = appendTLV(req, tagOctetString, nil)
+	req = appendTLV(req, tagSASLCredentials, creds)
+
+	msg := appendTLV(nil, tagInteger, marshalInt(int64(messageID)))
+	msg = appendTLV(msg, tagBindRequest, req)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// MarshalSimpleBindRequest returns the BER encoding of an LDAPMessage
+// containing a simple BindRequest (RFC 4513 §5.1) for name and password, for
+// servers that check passwords with a directory that does not support SASL.
+// The response can be decoded with UnmarshalBindResponse.
+func MarshalSimpleBindRequest(messageID int32, name string, password []byte) []byte {
+	req := appendTLV(nil, tagInteger, []byte{3})
+	req = appendTLV(req, tagOctetString, []byte(name))
+	req = appendTLV(req, tagSimple, password)
+
+	msg := appendTLV(nil, tagInteger, marshalInt(int64(messageID)))
+	msg = appendTLV(msg, tagBindRequest, req)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// UnmarshalBindResponse decodes an LDAPMessage containing a BindResponse.
+// Any controls attached to the message are ignored.
+func UnmarshalBindResponse(b []byte) (messageID int32, resp BindResponse, err error) {
+	msg, rest, err := readTLV(b, tagSequence)
+	if err != nil || len(rest) != 0 {
+		return 0, resp, errMalformed
+	}
+	id, msg, err := readTLV(msg, tagInteger)
+	if err != nil {
+		return 0, resp, err
+	}
+	i, err := unmarshalInt(id)
+	if err != nil {
+		return 0, resp, err
+	}
+	messageID = int32(i)
+
+	op, _, err := readTLV(msg, tagBindResponse)
+	if err != nil {
+		return messageID, resp, err
+	}
+	code, op, err := readTLV(op, tagEnumerated)
+	if err != nil {
+		return messageID, resp, err
+	}
+	if i, err = unmarshalInt(code); err != nil {
+		return messageID, resp, err
+	}
+	resp.ResultCode = int(i)
+	matched, op, err := readTLV(op, tagOctetString)
+	if err != nil {
+		return messageID, resp, err
+	}
+	resp.MatchedDN = string(matched)
+	diag, op, err := readTLV(op, tagOctetString)
+	if err != nil {
+		return messageID, resp, err
+	}
+	resp.DiagnosticMessage = string(diag)
+	if len(op) > 0 && op[0] == tagReferral {
+		if _, op, err = readTLV(op, tagReferral); err != nil {
+			return messageID, resp, err
+		}
+	}
+	if len(op) > 0 {
+		if resp.ServerSASLCreds, _, err = readTLV(op, tagServerSASLCreds); err != nil {
+			return messageID, resp, err
+		}
+	}
+	return messageID, resp, nil
+}
+
+func appendTLV(dst []byte, tag byte, value []byte) []byte {
+	dst = append(dst, tag)
+	switch l := len(value); {
+	case l < 0x80:
+		dst = append(dst, byte(l))
+	default:
+		var lb []byte
+		for ; l > 0; l >>= 8 {
+			lb = append([]byte{byte(l)}, lb...)
+		}
+		dst = append(dst, 0x80|byte(len(lb)))
+		dst = append(dst, lb...)
+	}
+	return append(dst, value...)
+}
+
+func readTLV(b []byte, tag byte) (value, rest []byte, err error) {
+	if len(b) < 2 || b[0] != tag {
+		return nil, nil, errMalformed
+	}
+	l, b := int(b[1]), b[2:]
+	if l&0x80 != 0 {
+		n := l &^ 0x80
+		if n == 0 || n > 4 || len(b) < n {
+			return nil, nil, errMalformed
+		}
+		l = 0
+		for _, c := range b[:n] {
+			l = l<<8 | int(c)
+		}
+		b = b[n:]
+	}
+	if l < 0 || l > len(b) {
+		return nil, nil, errMalformed
+	}
+	return b[:l], b[l:], nil
+}
+
+func marshalInt(i int64) []byte {
+	b := []byte{byte(i)}
+	for i >>= 8; ; i >>= 8 {
+		// Stop once the remaining bits are just sign extension.
+		if (i == 0 && b[0]&0x80 == 0) || (i == -1 && b[0]&0x80 != 0) {
+			return b
+		}
+		b = append([]byte{byte(i)}, b...)
+	}
+}
+
+func unmarshalInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errMalformed
+	}
+	i := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		i = i<<8 | int64(c)
+	}
+	return i, nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package ldapsasl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jh125486/sasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+// marshalBindResponse is the inverse of UnmarshalBindResponse.
+func marshalBindResponse(messageID int32, resp BindResponse) []byte {
+	op := appendTLV(nil, tagEnumerated, marshalInt(int64(resp.ResultCode)))
+	op = appendTLV(op, tagOctetString, []byte(resp.MatchedDN))
+	op = appendTLV(op, tagOctetString, []byte(resp.DiagnosticMessage))
+	if resp.ServerSASLCreds != nil {
+		op = appendTLV(op, tagServerSASLCreds, resp.ServerSASLCreds)
+	}
+	msg := appendTLV(nil, tagInteger, marshalInt(int64(messageID)))
+	msg = appendTLV(msg, tagBindResponse, op)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// unmarshalBindRequest extracts the mechanism and credentials from a request
+// created by MarshalBindRequest.
+func unmarshalBindRequest(b []byte) (mechanism string, credentials []byte, err error) {
+	msg, _, err := readTLV(b, tagSequence)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, msg, err = readTLV(msg, tagInteger); err != nil {
+		return "", nil, err
+	}
+	req, _, err := readTLV(msg, tagBindRequest)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, req, err = readTLV(req, tagInteger); err != nil {
+		return "", nil, err
+	}
+	if _, req, err = readTLV(req, tagOctetString); err != nil {
+		return "", nil, err
+	}
+	creds, _, err := readTLV(req, tagSASLCredentials)
+	if err != nil {
+		return "", nil, err
+	}
+	mech, creds, err := readTLV(creds, tagOctetString)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(creds) > 0 {
+		credentials, _, err = readTLV(creds, tagOctetString)
+	}
+	return string(mech), credentials, err
+}
+
+func TestBind(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		password string
+		fail     bool
+	}{
+		{name: "plain", mech: sasl.Plain, password: "pencil"},
+		{name: "plain-bad-password", mech: sasl.Plain, password: "pen", fail: true},
+		{name: "scram", mech: sasl.ScramSha256, password: "pencil"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s))
+			client := sasl.NewClient(tc.mech, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(tc.password), nil
+			}))
+
+			var id int32
+			err := Bind(client, func(mechanism string, credentials []byte) (BindResponse, error) {
+				// Send everything through the wire encoding to exercise it.
+				id++
+				mech, creds, err := unmarshalBindRequest(MarshalBindRequest(id, mechanism, credentials))
+				if err != nil {
+					return BindResponse{}, err
+				}
+				if mech != tc.mech.Name {
+					t.Fatalf("Unexpected mechanism: want=%q, got=%q", tc.mech.Name, mech)
+				}
+				var resp BindResponse
+				more, challenge, err := server.Step(creds)
+				switch {
+				case err != nil:
+					resp = BindResponse{ResultCode: 49, DiagnosticMessage: "invalid credentials"}
+				case more:
+					resp = BindResponse{ResultCode: SASLBindInProgress, ServerSASLCreds: challenge}
+				default:
+					resp = BindResponse{ResultCode: Success, ServerSASLCreds: challenge}
+				}
+				gotID, resp, err := UnmarshalBindResponse(marshalBindResponse(id, resp))
+				if gotID != id {
+					t.Errorf("Unexpected message ID: want=%d, got=%d", id, gotID)
+				}
+				return resp, err
+			})
+			switch {
+			case tc.fail:
+				var resultErr *ResultError
+				if !errors.As(err, &resultErr) || !errors.Is(err, sasl.ErrAuthn) || resultErr.ResultCode != 49 {
+					t.Errorf("Expected invalid credentials result, got %v", err)
+				}
+			case err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMarshalBindRequest(t *testing.T) {
+	got := MarshalBindRequest(1, "PLAIN", []byte("\x00u\x00p"))
+	want := []byte{
+		0x30, 0x19, // LDAPMessage
+		0x02, 0x01, 0x01, // messageID
+		0x60, 0x14, // BindRequest
+		0x02, 0x01, 0x03, // version
+		0x04, 0x00, // name
+		0xa3, 0x0d, // SaslCredentials
+		0x04, 0x05, 'P', 'L', 'A', 'I', 'N',
+		0x04, 0x04, 0x00, 'u', 0x00, 'p',
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Unexpected encoding:\nwant=%x\n got=%x", want, got)
+	}
+}
+
+func TestMarshalSimpleBindRequest(t *testing.T) {
+	got := MarshalSimpleBindRequest(2, "cn=u", []byte("pw"))
+	want := []byte{
+		0x30, 0x12, // LDAPMessage
+		0x02, 0x01, 0x02, // messageID
+		0x60, 0x0d, // BindRequest
+		0x02, 0x01, 0x03, // version
+		0x04, 0x04, 'c', 'n', '=', 'u', // name
+		0x80, 0x02, 'p', 'w', // simple
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Unexpected encoding:\nwant=%x\n got=%x", want, got)
+	}
+}
+
+func TestUnmarshalBindResponse(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	for i, tc := range []struct {
+		id   int32
+		resp BindResponse
+	}{
+		0: {id: 1, resp: BindResponse{ResultCode: Success}},
+		1: {id: 128, resp: BindResponse{ResultCode: SASLBindInProgress, ServerSASLCreds: []byte{}}},
+		2: {id: 70000, resp: BindResponse{ResultCode: 49, DiagnosticMessage: long, ServerSASLCreds: []byte(long)}},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			id, resp, err := UnmarshalBindResponse(marshalBindResponse(tc.id, tc.resp))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if id != tc.id {
+				t.Errorf("Unexpected message ID: want=%d, got=%d", tc.id, id)
+			}
+			if resp.ResultCode != tc.resp.ResultCode || resp.DiagnosticMessage != tc.resp.DiagnosticMessage || !bytes.Equal(resp.ServerSASLCreds, tc.resp.ServerSASLCreds) {
+				t.Errorf("Unexpected response: want=%+v, got=%+v", tc.resp, resp)
+			}
+		})
+	}
+
+	for _, b := range [][]byte{
+		nil,
+		{0x30, 0x05, 0x02, 0x01},
+		{0x30, 0x03, 0x02, 0x01, 0x01},
+		{0x30, 0x84, 0xff, 0xff, 0xff, 0xff},
+	} {
+		if _, _, err := UnmarshalBindResponse(b); err != errMalformed {
+			t.Errorf("Expected malformed error for %x, got %v", b, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"time"
+)
+
+// A Limiter caps the number of steps and expensive operations, such as key
+// derivation functions, that may run at the same time across all of the
+// negotiators that share it.
+// It protects a server from running out of CPU when it is flooded with
+// authentication attempts.
+//
+// Slots are held for the duration of a single step, not a whole negotiation,
+// so a client that stops responding part way through a negotiation does not
+// keep others from authenticating.
+// When all slots are in use, steps wait for up to the limiter's maximum wait
+// time for one to become free before failing with ErrBusy.
+// A Limiter is safe for concurrent use.
+type Limiter struct {
+	steps   chan struct{}
+	kdf     chan struct{}
+	maxWait time.Duration
+}
+
+// NewLimiter returns a limiter that allows up to maxSteps steps and maxKDF
+// expensive operations to run at once, waiting up to maxWait for a slot.
+// If either limit is less than one that kind of operation is not limited.
+func NewLimiter(maxSteps, maxKDF int, maxWait time.Duration) *Limiter {
+	l := &Limiter{maxWait: maxWait}
+	if maxSteps > 0 {
+		l.steps = make(chan struct{}, maxSteps)
+	}
+	if maxKDF > 0 {
+		l.kdf = make(chan struct{}, maxKDF)
+	}
+	return l
+}
+
+// Limit sets a limiter shared by the negotiator and any others it is passed
+// to.
+// It is normally used with NewServer or NewServerFactory.
+func Limit(l *Limiter) Option {
+	return func(n *Negotiator) {
+		n.limiter = l
+	}
+}
+
+// acquire takes a slot from sem, waiting up to the maximum wait time, and
+// reports whether it succeeded.
+// A nil sem is not limited.
+func (l *Limiter) acquire(sem chan struct{}) bool {
+	if sem == nil {
+		return true
+	}
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+	}
+	if l.maxWait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (l *Limiter) release(sem chan struct{}) {
+	if sem != nil {
+		<-sem
+	}
+}
+
+// Expensive runs f, which should be a CPU intensive operation such as a key
+// derivation function or a password hash verification, once the limiter set
+// with the Limit option allows it.
+// Mechanisms, credential stores, and permissions callbacks should use it to
+// wrap such operations.
+// If no slot becomes free in time f is not called and ErrBusy is returned.
+// If the negotiator does not have a limiter f is always called.
+func (c *Negotiator) Expensive(f func()) error {
+	if c.limiter != nil {
+		if !c.limiter.acquire(c.limiter.kdf) {
+			return ErrBusy
+		}
+		defer c.limiter.release(c.limiter.kdf)
+	}
+	f()
+	return nil
+}
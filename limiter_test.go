@@ -0,0 +1,87 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"testing"
+	"time"
+)
+
+// blockingMech returns a mechanism whose first step signals started and then
+// blocks until release is closed.
+func blockingMech(started chan<- struct{}, release <-chan struct{}) Mechanism {
+	return Mechanism{
+		Name: "BLOCK",
+		Start: func(*Negotiator) (bool, []byte, interface{}, error) {
+			started <- struct{}{}
+			<-release
+			return false, nil, nil, nil
+		},
+	}
+}
+
+func TestLimiterSteps(t *testing.T) {
+	l := NewLimiter(1, 0, 0)
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, _, err := NewClient(blockingMech(started, release), Limit(l)).Step(nil)
+		done <- err
+	}()
+	<-started
+
+	_, _, err := NewClient(Plain, append(plainClientOpts, Limit(l))...).Step(nil)
+	if err != ErrBusy {
+		t.Errorf("Expected ErrBusy while the only slot is in use, got %v", err)
+	}
+	if !IsTemporary(err) || ErrorCode(err) != CodeBusy {
+		t.Errorf("Expected ErrBusy to be temporary with code %q", CodeBusy)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error from blocked step: %v", err)
+	}
+	if _, _, err := NewClient(Plain, append(plainClientOpts, Limit(l))...).Step(nil); err != nil {
+		t.Errorf("Expected slot to be released, got %v", err)
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	l := NewLimiter(1, 0, 5*time.Second)
+	started, release := make(chan struct{}), make(chan struct{})
+	go NewClient(blockingMech(started, release), Limit(l)).Step(nil)
+	<-started
+
+	time.AfterFunc(10*time.Millisecond, func() { close(release) })
+	if _, _, err := NewClient(Plain, append(plainClientOpts, Limit(l))...).Step(nil); err != nil {
+		t.Errorf("Expected step to wait for a free slot, got %v", err)
+	}
+}
+
+func TestLimiterExpensive(t *testing.T) {
+	l := NewLimiter(0, 1, 0)
+	n := NewServer(Plain, acceptAll, Limit(l))
+	started, release := make(chan struct{}), make(chan struct{})
+	go n.Expensive(func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	called := false
+	if err := n.Expensive(func() { called = true }); err != ErrBusy || called {
+		t.Errorf("Expected ErrBusy without calling f, got %v (called=%t)", err, called)
+	}
+	close(release)
+
+	// Only expensive operations are limited, not steps.
+	if err := negotiate(NewClient(Plain, plainClientOpts...), NewServer(Plain, acceptAll, Limit(l))); err != nil {
+		t.Errorf("Unexpected error with unlimited steps: %v", err)
+	}
+	if err := (&Negotiator{}).Expensive(func() { called = true }); err != nil || !called {
+		t.Errorf("Expected f to be called without a limiter, got %v (called=%t)", err, called)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"encoding/base64"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits are the maximum lengths in bytes of fields parsed from messages
+// received from the peer.
+// Limits protect servers and clients from peers that send absurdly large
+// values, which would otherwise be passed to credential stores and permission
+// callbacks or, in the case of SCRAM salts, used in expensive computations.
+type Limits struct {
+	Username int
+	Identity int
+	Nonce    int
+
+	// Salt is the limit for the decoded SCRAM salt.
+	Salt int
+}
+
+// DefaultLimits are the limits used unless the FieldLimits option is used.
+var DefaultLimits = Limits{
+	Username: 1024,
+	Identity: 1024,
+	Nonce:    1024,
+	Salt:     1024,
+}
+
+// FieldLimits sets the limits on fields received from the peer.
+// Zero fields are set to the corresponding field of DefaultLimits and negative
+// fields disable the limit.
+//
+// Usernames and authorization identities must also not contain control
+// characters.
+// Fields that violate the limits are rejected with a CredentialError.
+func FieldLimits(l Limits) Option {
+	return func(n *Negotiator) {
+		n.limits = l
+	}
+}
+
+// fieldLimit returns the effective limit for field or 0 if there is none.
+func (c *Negotiator) fieldLimit(field string) int {
+	var limit, def int
+	switch field {
+	case "username":
+		limit, def = c.limits.Username, DefaultLimits.Username
+	case "identity":
+		limit, def = c.limits.Identity, DefaultLimits.Identity
+	case "nonce":
+		limit, def = c.limits.Nonce, DefaultLimits.Nonce
+	case "salt":
+		limit, def = c.limits.Salt, DefaultLimits.Salt
+	}
+	switch {
+	case limit < 0:
+		return 0
+	case limit == 0:
+		return def
+	}
+	return limit
+}
+
+// CheckField returns a CredentialError if value, received from the peer as the
+// named field ("username", "identity", "nonce", or "salt"), violates the limits
+// set by the FieldLimits option.
+// Mechanisms should call it for every such field that they parse before the
+// value is used.
+func (c *Negotiator) CheckField(field string, value []byte) error {
+	if limit := c.fieldLimit(field); limit > 0 && len(value) > limit {
+		return CredentialError{Field: field, Reason: "is too long"}
+	}
+	if (field == "username" || field == "identity") && hasControl(value) {
+		return CredentialError{Field: field, Reason: "contains a control character"}
+	}
+	return nil
+}
+
+// checkEncodedSalt is like CheckField for a base64 encoded salt, which lets
+// the limit be checked before the salt is decoded.
+func (c *Negotiator) checkEncodedSalt(encoded []byte) error {
+	if limit := c.fieldLimit("salt"); limit > 0 && base64.StdEncoding.DecodedLen(len(encoded)) > limit+2 {
+		return CredentialError{Field: "salt", Reason: "is too long"}
+	}
+	return nil
+}
+
+func hasControl(b []byte) bool {
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if unicode.IsControl(r) {
+			return true
+		}
+		b = b[size:]
+	}
+	return false
+}
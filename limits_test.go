@@ -0,0 +1,70 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFieldLimits(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	long := strings.Repeat("a", 1025)
+	for i, tc := range []struct {
+		mech Mechanism
+		opts []Option
+		msg  string
+		err  error
+	}{
+		0: {mech: Plain, msg: "\x00" + long + "\x00pencil", err: CredentialError{Field: "username", Reason: "is too long"}},
+		1: {mech: Plain, msg: long + "\x00user\x00pencil", err: CredentialError{Field: "identity", Reason: "is too long"}},
+		2: {mech: Plain, msg: "\x00us\x1ber\x00pencil", err: CredentialError{Field: "username", Reason: "contains a control character"}},
+		3: {mech: Plain, msg: "\u0085\x00user\x00pencil", err: CredentialError{Field: "identity", Reason: "contains a control character"}},
+		4: {mech: Plain, opts: []Option{FieldLimits(Limits{Username: 3})}, msg: "\x00user\x00pencil", err: CredentialError{Field: "username", Reason: "is too long"}},
+		5: {mech: Plain, opts: []Option{FieldLimits(Limits{Username: -1})}, msg: "\x00" + long + "\x00pencil", err: ErrAuthn},
+		6: {mech: ScramSha256, msg: "n,,n=" + long + ",r=abc", err: CredentialError{Field: "username", Reason: "is too long"}},
+		7: {mech: ScramSha256, msg: "n,a=ad\x7fmin,n=user,r=abc", err: CredentialError{Field: "identity", Reason: "contains a control character"}},
+		8: {mech: ScramSha256, msg: "n,,n=user,r=" + long, err: CredentialError{Field: "nonce", Reason: "is too long"}},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			server := NewServer(tc.mech, func(n *Negotiator) bool {
+				user, _, _ := n.Credentials()
+				return string(user) == "user"
+			}, append([]Option{Store(store)}, tc.opts...)...)
+			if _, _, err := server.Step([]byte(tc.msg)); err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestFieldLimitsSalt(t *testing.T) {
+	for i, tc := range []struct {
+		salt []byte
+		opts []Option
+		err  error
+	}{
+		0: {salt: make([]byte, 1024)},
+		1: {salt: make([]byte, 1025), err: CredentialError{Field: "salt", Reason: "is too long"}},
+		2: {salt: make([]byte, 32<<10), err: CredentialError{Field: "salt", Reason: "is too long"}},
+		3: {salt: make([]byte, 17), opts: []Option{FieldLimits(Limits{Salt: 16})}, err: CredentialError{Field: "salt", Reason: "is too long"}},
+		4: {salt: make([]byte, 4096), opts: []Option{FieldLimits(Limits{Salt: -1})}},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			client := NewClient(ScramSha256, append(scramClientOpts, tc.opts...)...)
+			client.nonce = testNonce
+			if _, _, err := client.Step(nil); err != nil {
+				t.Fatalf("Unexpected error on first step: %v", err)
+			}
+			challenge := "r=" + string(testNonce) + "server,s=" + base64.StdEncoding.EncodeToString(tc.salt) + ",i=4096"
+			if _, _, err := client.Step([]byte(challenge)); err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogValue implements slog.LogValuer.
+// Credentials are always redacted.
+func (c *Negotiator) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("mechanism", c.mechanism.Name),
+		slog.String("negotiation_id", c.negotiationID),
+		slog.Any("state", c.State()),
+		slog.Int("steps", c.timing.steps),
+		slog.String("credentials", c.redactedCreds()),
+	)
+}
+
+// debug logs a message at the debug level if a logger has been configured.
+// Callers must never pass challenges, responses, or credentials as arguments.
+func (c *Negotiator) debug(msg string, args ...interface{}) {
+	if !c.debugEnabled() {
+		return
+	}
+	c.logger.Debug(msg, append([]interface{}{
+		slog.String("mechanism", c.mechanism.Name),
+		slog.String("negotiation_id", c.negotiationID),
+	}, args...)...)
+}
+
+// debugEnabled reports whether debug messages would be logged.
+// It lets callers in the Step hot path avoid building arguments that would be
+// thrown away.
+func (c *Negotiator) debugEnabled() bool {
+	return c.logger != nil && c.logger.Enabled(context.Background(), slog.LevelDebug)
+}
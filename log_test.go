@@ -0,0 +1,36 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRedacts(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := NewClient(plain, append([]Option{Logger(logger)}, plainClientOpts...)...)
+
+	if _, _, err := client.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := client.Step([]byte("xipj3plmq")); err == nil {
+		t.Fatal("Expected error on second step")
+	}
+	logger.Debug("negotiator", "n", client)
+
+	out := buf.String()
+	for _, want := range []string{"selected client mechanism", "state changed", "step failed", "mechanism=PLAIN"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected log output to contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "xipj3plmq") {
+		t.Errorf("Log output leaked the password:\n%s", out)
+	}
+}
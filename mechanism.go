@@ -7,15 +7,36 @@ package sasl
 import (
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"errors"
 )
 
 // Define common errors used by SASL mechanisms and negotiators.
 var (
-	ErrInvalidState     = errors.New("Invalid state")
-	ErrInvalidChallenge = errors.New("Invalid or missing challenge")
-	ErrAuthn            = errors.New("Authentication error")
-	ErrTooManySteps     = errors.New("Step called too many times")
+	ErrInvalidState          = errors.New("Invalid state")
+	ErrInvalidChallenge      = errors.New("Invalid or missing challenge")
+	ErrAuthn                 = errors.New("Authentication error")
+	ErrTooManySteps          = errors.New("Step called too many times")
+	ErrMessageTooLarge       = errors.New("Challenge or response exceeds the maximum message size")
+	ErrUnknownUser           = errors.New("Unknown user")
+	ErrServerSignature       = errors.New("Server signature is missing or invalid")
+	ErrMutualAuth            = errors.New("Mechanism did not authenticate the server")
+	ErrFIPS                  = errors.New("Mechanism is not allowed in FIPS mode")
+	ErrSecretMismatch        = errors.New("Precomputed secret does not match the server salt or iteration count")
+	ErrStepTimeout           = errors.New("Step did not complete before the step timeout")
+	ErrConcurrentStep        = errors.New("Step called while another step was in progress")
+	ErrMechanismNotSupported = errors.New("Mechanism is not supported by the remote side")
+	ErrDowngrade             = errors.New("Mechanism is weaker than one previously advertised by the server")
+	ErrLockedMemory          = errors.New("Unable to allocate locked memory for secrets")
+	ErrRealm                 = errors.New("Multiple realms were offered but none was selected")
+	ErrQOP                   = errors.New("No acceptable quality of protection was offered")
+	ErrServerKeyChanged      = errors.New("Server key does not match the key pinned for the server")
+	ErrSecretNotFound        = errors.New("Secret not found")
+	ErrBusy                  = errors.New("Too many authentications are in progress")
+	ErrRedacted              = errors.New("Transcript message was redacted")
+	ErrAuthzID               = errors.New("Invalid authorization identity")
+	ErrAborted               = errors.New("Negotiation was aborted")
 )
 
 var (
@@ -23,9 +44,26 @@ var (
 	// as defined by RFC 4616.
 	Plain Mechanism = plain
 
+	// Anonymous is a Mechanism that implements the ANONYMOUS mechanism as
+	// defined by RFC 4505.
+	// Clients send the trace information set with the Trace property, if any.
+	Anonymous Mechanism = anonymous
+
+	// ScramSha512Plus is a Mechanism that implements the SCRAM-SHA-512-PLUS
+	// authentication mechanism. Channel binding uses tls-unique or, with the
+	// PostgreSQL option on clients and the ServerCertificate option on servers,
+	// tls-server-end-point as defined in RFC 5929.
+	ScramSha512Plus Mechanism = scram("SCRAM-SHA-512-PLUS", sha512.New)
+
+	// ScramSha512 is a Mechanism that implements the SCRAM-SHA-512
+	// authentication mechanism.
+	ScramSha512 Mechanism = scram("SCRAM-SHA-512", sha512.New)
+
 	// ScramSha256Plus is a Mechanism that implements the SCRAM-SHA-256-PLUS
-	// authentication mechanism defined in RFC 7677. The only supported channel
-	// binding type is tls-unique as defined in RFC 5929.
+	// authentication mechanism defined in RFC 7677. Channel binding uses
+	// tls-unique or, with the PostgreSQL option on clients and the
+	// ServerCertificate option on servers, tls-server-end-point as defined in
+	// RFC 5929.
 	ScramSha256Plus Mechanism = scram("SCRAM-SHA-256-PLUS", sha256.New)
 
 	// ScramSha256 is a Mechanism that implements the SCRAM-SHA-256
@@ -33,8 +71,10 @@ var (
 	ScramSha256 Mechanism = scram("SCRAM-SHA-256", sha256.New)
 
 	// ScramSha1Plus is a Mechanism that implements the SCRAM-SHA-1-PLUS
-	// authentication mechanism defined in RFC 5802. The only supported channel
-	// binding type is tls-unique as defined in RFC 5929.
+	// authentication mechanism defined in RFC 5802. Channel binding uses
+	// tls-unique or, with the PostgreSQL option on clients and the
+	// ServerCertificate option on servers, tls-server-end-point as defined in
+	// RFC 5929.
 	ScramSha1Plus Mechanism = scram("SCRAM-SHA-1-PLUS", sha1.New)
 
 	// ScramSha1 is a Mechanism that implements the SCRAM-SHA-1 authentication
@@ -51,8 +91,112 @@ var (
 // anything that it needs to store and the value will be cached by the
 // negotiator and passed in as the data parameter when the next challenge is
 // received.
+//
+// A nil response or challenge means that there is no message to send, while an
+// empty, non-nil slice is an empty message.
+// Many protocols encode the two differently (for example, a client that has no
+// initial response omits it from the IMAP AUTHENTICATE command while an empty
+// initial response is sent as "="), so mechanisms must preserve the difference.
+//
+// Errors returned by mechanisms must never contain credentials, proofs, or
+// other secret material since they are likely to end up in logs.
+// None of the mechanisms provided by this package do so.
+//
+// Abort is optional and is called with the cached state when a server
+// negotiator is aborted (see the Abort method on Negotiator).
+// It returns the message that tells the client that the exchange failed in the
+// way defined by the mechanism, such as a SCRAM server-final-message with an
+// error, or nil if the mechanism has nothing to send at the current step.
 type Mechanism struct {
-	Name  string
-	Start func(n *Negotiator) (more bool, resp []byte, cache interface{}, err error)
-	Next  func(n *Negotiator, challenge []byte, data interface{}) (more bool, resp []byte, cache interface{}, err error)
+	Name         string
+	Start        func(n *Negotiator) (more bool, resp []byte, cache interface{}, err error)
+	Next         func(n *Negotiator, challenge []byte, data interface{}) (more bool, resp []byte, cache interface{}, err error)
+	Abort        func(n *Negotiator, data interface{}) []byte
+	Capabilities Capabilities
+}
+
+// Capabilities describes the security properties of a mechanism so that
+// servers and mechanism selection logic can apply policy without hard coding
+// lists of mechanism names.
+type Capabilities struct {
+	// RequiresTLS is true if the mechanism should only be used over a
+	// confidential transport.
+	RequiresTLS bool
+
+	// Plaintext is true if the mechanism transmits credentials in the clear.
+	Plaintext bool
+
+	// ChannelBinding is true if the mechanism binds the authentication to the
+	// underlying TLS connection.
+	ChannelBinding bool
+
+	// MutualAuth is true if the mechanism authenticates the server to the client
+	// as well as the client to the server.
+	MutualAuth bool
+
+	// RoundTrips is the number of challenge/response pairs needed to complete a
+	// successful negotiation (not including the initial response).
+	RoundTrips int
+
+	// ServerFirst is true if the server sends the first message, so clients
+	// never have an initial response (for example, CRAM-MD5 and DIGEST-MD5).
+	ServerFirst bool
+}
+
+// TypedMechanism is like Mechanism except that the state stored between steps
+// has a concrete type, which removes the need for type assertions in Next.
+// It must be converted to a Mechanism using its Mechanism method before it can
+// be used with a Negotiator.
+type TypedMechanism[T any] struct {
+	Name         string
+	Start        func(n *Negotiator) (more bool, resp []byte, cache T, err error)
+	Next         func(n *Negotiator, challenge []byte, data T) (more bool, resp []byte, cache T, err error)
+	Abort        func(n *Negotiator, data T) []byte
+	Capabilities Capabilities
+}
+
+// Mechanism returns a Mechanism that calls the typed Start, Next, and Abort
+// functions.
+// If the negotiator has no cached state (for example, when Next is called on a
+// server before Start has ever run) the zero value of T is passed to Next and
+// Abort.
+func (m TypedMechanism[T]) Mechanism() Mechanism {
+	mech := Mechanism{
+		Name:         m.Name,
+		Capabilities: m.Capabilities,
+		Start: func(n *Negotiator) (bool, []byte, interface{}, error) {
+			more, resp, cache, err := m.Start(n)
+			return more, resp, cache, err
+		},
+		Next: func(n *Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			var state T
+			if data != nil {
+				var ok bool
+				if state, ok = data.(T); !ok {
+					return false, nil, nil, ErrInvalidState
+				}
+			}
+			more, resp, cache, err := m.Next(n, challenge, state)
+			return more, resp, cache, err
+		},
+	}
+	if m.Abort != nil {
+		mech.Abort = func(n *Negotiator, data interface{}) []byte {
+			state, _ := data.(T)
+			return m.Abort(n, state)
+		}
+	}
+	return mech
+}
+
+// ConstantTimeEqual reports whether a and b are equal without leaking where
+// they differ through timing.
+// The time taken still depends on the length of the inputs, so secrets of
+// varying length such as passwords should be hashed before they are compared.
+//
+// Mechanisms must use it (or an equivalent such as hmac.Equal) to compare
+// passwords, proofs, signatures, and other secret values.
+// All of the mechanisms provided by this package do so.
+func ConstantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
 }
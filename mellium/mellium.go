@@ -0,0 +1,92 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package sasl mirrors the API of mellium.im/sasl so that projects using that
+// module can switch to this one by changing their import path:
+//
+//	import "github.com/jh125486/sasl/mellium"
+//
+// The package name is sasl, as it is for mellium.im/sasl, so no other code has
+// to change.
+// All of the types are aliases for the types in github.com/jh125486/sasl, so
+// negotiators and mechanisms created through this package can be passed to
+// the rest of this module (for example, to use mechanisms that
+// mellium.im/sasl does not have or the protocol packages) and all of the new
+// methods and options are available by importing the main package alongside
+// this one.
+package sasl // import "github.com/jh125486/sasl/mellium"
+
+import (
+	"crypto/tls"
+
+	"github.com/jh125486/sasl"
+)
+
+// Errors defined by mellium.im/sasl.
+var (
+	ErrInvalidState     = sasl.ErrInvalidState
+	ErrInvalidChallenge = sasl.ErrInvalidChallenge
+	ErrAuthn            = sasl.ErrAuthn
+	ErrTooManySteps     = sasl.ErrTooManySteps
+)
+
+// Mechanisms defined by mellium.im/sasl.
+var (
+	Plain           = sasl.Plain
+	ScramSha1       = sasl.ScramSha1
+	ScramSha1Plus   = sasl.ScramSha1Plus
+	ScramSha256     = sasl.ScramSha256
+	ScramSha256Plus = sasl.ScramSha256Plus
+	ScramSha512     = sasl.ScramSha512
+	ScramSha512Plus = sasl.ScramSha512Plus
+)
+
+// Mechanism is an alias for sasl.Mechanism.
+type Mechanism = sasl.Mechanism
+
+// Negotiator is an alias for sasl.Negotiator.
+type Negotiator = sasl.Negotiator
+
+// Option is an alias for sasl.Option.
+type Option = sasl.Option
+
+// State is an alias for sasl.State.
+type State = sasl.State
+
+// States of the negotiator, see sasl.State.
+const (
+	Initial             = sasl.Initial
+	AuthTextSent        = sasl.AuthTextSent
+	ResponseSent        = sasl.ResponseSent
+	ValidServerResponse = sasl.ValidServerResponse
+	StepMask            = sasl.StepMask
+	RemoteCB            = sasl.RemoteCB
+	Errored             = sasl.Errored
+	Receiving           = sasl.Receiving
+)
+
+// NewClient calls sasl.NewClient.
+func NewClient(m Mechanism, opts ...Option) *Negotiator {
+	return sasl.NewClient(m, opts...)
+}
+
+// NewServer calls sasl.NewServer.
+func NewServer(m Mechanism, permissions func(*Negotiator) bool, opts ...Option) *Negotiator {
+	return sasl.NewServer(m, permissions, opts...)
+}
+
+// Credentials calls sasl.Credentials.
+func Credentials(f func() (Username, Password, Identity []byte)) Option {
+	return sasl.Credentials(f)
+}
+
+// RemoteMechanisms calls sasl.RemoteMechanisms.
+func RemoteMechanisms(m ...string) Option {
+	return sasl.RemoteMechanisms(m...)
+}
+
+// TLSState calls sasl.TLSState.
+func TLSState(cs tls.ConnectionState) Option {
+	return sasl.TLSState(cs)
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl_test
+
+import (
+	"crypto/tls"
+	"testing"
+
+	upstream "github.com/jh125486/sasl"
+	sasl "github.com/jh125486/sasl/mellium"
+)
+
+// The signatures of the functions and methods in mellium.im/sasl.
+var (
+	_ func(sasl.Mechanism, ...sasl.Option) *sasl.Negotiator                              = sasl.NewClient
+	_ func(sasl.Mechanism, func(*sasl.Negotiator) bool, ...sasl.Option) *sasl.Negotiator = sasl.NewServer
+	_ func(func() (Username, Password, Identity []byte)) sasl.Option                     = sasl.Credentials
+	_ func(...string) sasl.Option                                                        = sasl.RemoteMechanisms
+	_ func(tls.ConnectionState) sasl.Option                                              = sasl.TLSState
+	_ func(*sasl.Negotiator, []byte) (bool, []byte, error)                               = (*sasl.Negotiator).Step
+	_ func(*sasl.Negotiator) sasl.State                                                  = (*sasl.Negotiator).State
+	_ func(*sasl.Negotiator) []byte                                                      = (*sasl.Negotiator).Nonce
+	_ func(*sasl.Negotiator)                                                             = (*sasl.Negotiator).Reset
+	_ func(*sasl.Negotiator) (username, password, identity []byte)                       = (*sasl.Negotiator).Credentials
+	_ func(*sasl.Negotiator, ...sasl.Option) bool                                        = (*sasl.Negotiator).Permissions
+	_ func(*sasl.Negotiator) *tls.ConnectionState                                        = (*sasl.Negotiator).TLSState
+	_ func(*sasl.Negotiator) []string                                                    = (*sasl.Negotiator).RemoteMechanisms
+)
+
+func TestCompat(t *testing.T) {
+	client := sasl.NewClient(sasl.Plain, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	server := sasl.NewServer(sasl.Plain, func(n *sasl.Negotiator) bool {
+		user, pass, _ := n.Credentials()
+		return string(user) == "user" && string(pass) == "pencil"
+	})
+
+	// Negotiators can be used with the rest of the module.
+	var _ *upstream.Negotiator = client
+
+	_, resp, err := client.Step(nil)
+	if err != nil {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+	if more, _, err := server.Step(resp); err != nil || more {
+		t.Fatalf("Unexpected server result: more=%t, err=%v", more, err)
+	}
+	if server.State()&sasl.Errored != 0 || server.State()&sasl.Receiving == 0 {
+		t.Errorf("Unexpected server state: %v", server.State())
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package memcachesasl authenticates to memcached servers using the SASL
+// commands of the memcached binary protocol.
+package memcachesasl
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/jh125486/sasl"
+)
+
+// Binary protocol opcodes used for SASL.
+const (
+	OpListMechs byte = 0x20
+	OpAuth      byte = 0x21
+	OpStep      byte = 0x22
+)
+
+// Binary protocol response statuses used for SASL.
+const (
+	StatusSuccess  uint16 = 0x0000
+	StatusAuthErr  uint16 = 0x0020
+	StatusContinue uint16 = 0x0021
+	StatusTempFail uint16 = 0x0086
+)
+
+const (
+	magicRequest  = 0x80
+	magicResponse = 0x81
+	headerLen     = 24
+)
+
+var errMalformed = errors.New("memcachesasl: malformed response")
+
+// Error is returned when the server responds with an unexpected status.
+type Error struct {
+	Status  uint16
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "memcachesasl: authentication failed: " + e.Message
+}
+
+// Unwrap returns sasl.ErrAuthn if the server rejected the credentials.
+func (e *Error) Unwrap() error {
+	if e.Status == StatusAuthErr {
+		return sasl.ErrAuthn
+	}
+	return nil
+}
+
+// Temporary reports whether the server responded with StatusTempFail, meaning
+// that authentication may succeed if it is retried later.
+func (e *Error) Temporary() bool {
+	return e.Status == StatusTempFail
+}
+
+// ListMechanisms asks the server for the mechanisms it supports.
+func ListMechanisms(rw io.ReadWriter) ([]string, error) {
+	if err := writeRequest(rw, OpListMechs, "", nil); err != nil {
+		return nil, err
+	}
+	status, value, err := readResponse(rw, OpListMechs)
+	if err != nil {
+		return nil, err
+	}
+	if status != StatusSuccess {
+		return nil, &Error{Status: status, Message: string(value)}
+	}
+	return strings.Fields(string(value)), nil
+}
+
+// Authenticate authenticates over rw using the client negotiator n.
+func Authenticate(rw io.ReadWriter, n *sasl.Negotiator) error {
+	mechanism := n.Mechanism().Name
+	_, resp, err := n.Step(nil)
+	if err != nil {
+		return err
+	}
+	op := OpAuth
+	for {
+		if err = writeRequest(rw, op, mechanism, resp); err != nil {
+			return err
+		}
+		status, value, err := readResponse(rw, op)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case StatusContinue:
+			if _, resp, err = n.Step(value); err != nil {
+				return err
+			}
+			op = OpStep
+		case StatusSuccess:
+			return n.Finish(value)
+		default:
+			return &Error{Status: status, Message: string(value)}
+		}
+	}
+}
+
+func writeRequest(w io.Writer, op byte, key string, value []byte) error {
+	pkt := make([]byte, headerLen+len(key)+len(value))
+	pkt[0] = magicRequest
+	pkt[1] = op
+	binary.BigEndian.PutUint16(pkt[2:], uint16(len(key)))
+	binary.BigEndian.PutUint32(pkt[8:], uint32(len(key)+len(value)))
+	copy(pkt[headerLen:], key)
+	copy(pkt[headerLen+len(key):], value)
+	_, err := w.Write(pkt)
+	return err
+}
+
+func readResponse(r io.Reader, op byte) (status uint16, value []byte, err error) {
+	var hdr [headerLen]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	if hdr[0] != magicResponse || hdr[1] != op {
+		return 0, nil, errMalformed
+	}
+	keyLen := int(binary.BigEndian.Uint16(hdr[2:]))
+	extrasLen := int(hdr[4])
+	bodyLen := binary.BigEndian.Uint32(hdr[8:])
+	if bodyLen > sasl.DefaultMaxMessageSize || int(bodyLen) < keyLen+extrasLen {
+		return 0, nil, errMalformed
+	}
+	body := make([]byte, bodyLen)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint16(hdr[6:]), body[keyLen+extrasLen:], nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package memcachesasl_test
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/memcachesasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func respond(w io.Writer, op byte, status uint16, value []byte) {
+	pkt := make([]byte, 24+len(value))
+	pkt[0] = 0x81
+	pkt[1] = op
+	binary.BigEndian.PutUint16(pkt[6:], status)
+	binary.BigEndian.PutUint32(pkt[8:], uint32(len(value)))
+	copy(pkt[24:], value)
+	w.Write(pkt)
+}
+
+// serve handles SASL requests until the connection is closed.
+func serve(conn net.Conn, server *sasl.Negotiator) {
+	defer conn.Close()
+	for {
+		var hdr [24]byte
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(hdr[8:]))
+		io.ReadFull(conn, body)
+		op := hdr[1]
+		value := body[binary.BigEndian.Uint16(hdr[2:]):]
+
+		if op == memcachesasl.OpListMechs {
+			respond(conn, op, memcachesasl.StatusSuccess, []byte("SCRAM-SHA-256 PLAIN"))
+			continue
+		}
+		more, challenge, err := server.Step(value)
+		switch {
+		case err != nil:
+			respond(conn, op, memcachesasl.StatusAuthErr, []byte("Auth failure"))
+		case more:
+			respond(conn, op, memcachesasl.StatusContinue, challenge)
+		default:
+			respond(conn, op, memcachesasl.StatusSuccess, challenge)
+		}
+	}
+}
+
+func TestListMechanisms(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go serve(serverConn, nil)
+
+	mechs, err := memcachesasl.ListMechanisms(clientConn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []string{"SCRAM-SHA-256", "PLAIN"}; !reflect.DeepEqual(mechs, want) {
+		t.Errorf("Unexpected mechanisms: want=%v, got=%v", want, mechs)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		password string
+		fail     bool
+	}{
+		{name: "plain", mech: sasl.Plain, password: "pencil"},
+		{name: "plain-bad-password", mech: sasl.Plain, password: "pen", fail: true},
+		{name: "scram", mech: sasl.ScramSha256, password: "pencil"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			go serve(serverConn, sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s)))
+
+			client := sasl.NewClient(tc.mech, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(tc.password), nil
+			}))
+			err := memcachesasl.Authenticate(clientConn, client)
+			switch {
+			case tc.fail && !errors.Is(err, sasl.ErrAuthn):
+				t.Errorf("Expected authentication error, got %v", err)
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
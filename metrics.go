@@ -0,0 +1,148 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"time"
+)
+
+// A MetricsRecorder receives measurements about negotiations.
+// Implementations must be safe for concurrent use since a single recorder is
+// normally shared by many negotiators.
+type MetricsRecorder interface {
+	// Negotiation is called once for each negotiation when it completes or fails
+	// with the total time spent from the first step to the last.
+	Negotiation(mechanism string, success bool, d time.Duration)
+
+	// KDF is called each time a mechanism runs an expensive key derivation
+	// function such as PBKDF2 with the time that it took.
+	KDF(mechanism string, d time.Duration)
+}
+
+// Metrics causes the negotiator to report the outcome and duration of each
+// negotiation to r.
+func Metrics(r MetricsRecorder) Option {
+	return func(n *Negotiator) {
+		n.metrics = r
+	}
+}
+
+// KDFCost is the cost of the key derivation and verification performed during
+// a negotiation, which operators can use to tell when iteration counts need
+// tuning or to spot attempts to exhaust the CPU of a server.
+type KDFCost struct {
+	// Iterations is the iteration count of the SCRAM credentials that were
+	// used, or zero if the mechanism does not use a key derivation function.
+	Iterations int
+
+	// KDF is the time that clients spent deriving keys.
+	// It is zero if cached or precomputed keys were used.
+	KDF time.Duration
+
+	// Verify is the time that servers spent verifying the proof or password of
+	// the client, including any time spent in a ScramVerifier or
+	// PasswordVerifier.
+	Verify time.Duration
+}
+
+// A KDFCostRecorder is a MetricsRecorder that also receives the KDFCost of
+// each negotiation.
+// If the recorder passed to Metrics implements it, KDFCost is called once for
+// each negotiation right after Negotiation.
+type KDFCostRecorder interface {
+	MetricsRecorder
+	KDFCost(mechanism string, success bool, cost KDFCost)
+}
+
+// A CorrelatedRecorder is a MetricsRecorder that also receives the ID of each
+// negotiation (see NegotiationID), for example to attach it to the measurement
+// as an exemplar.
+// The ID should not be used as a label since every negotiation would create a
+// new series.
+// If the recorder passed to Metrics implements it, NegotiationWithID is called
+// in place of Negotiation.
+type CorrelatedRecorder interface {
+	MetricsRecorder
+	NegotiationWithID(id, mechanism string, success bool, d time.Duration)
+}
+
+// KDFCost returns the cost of the key derivation and verification performed
+// so far by the current negotiation.
+func (c *Negotiator) KDFCost() KDFCost {
+	return c.timing.cost
+}
+
+// Steps returns the number of times that Step has been called since the
+// negotiator was created or last reset, including the step in progress if any.
+// Protocols can use it along with FirstStep and LastStep to implement idle
+// timeouts or to report where a negotiation stalled.
+func (c *Negotiator) Steps() int {
+	return c.timing.steps
+}
+
+// FirstStep returns the time (according to the Clock option) at which Step was
+// first called since the negotiator was created or last reset, or the zero
+// time if it has not been called.
+func (c *Negotiator) FirstStep() time.Time {
+	return c.timing.first
+}
+
+// LastStep returns the time (according to the Clock option) at which Step was
+// most recently called, or the zero time if it has not been called since the
+// negotiator was created or last reset.
+func (c *Negotiator) LastStep() time.Time {
+	return c.timing.last
+}
+
+type negotiationMetrics struct {
+	steps int
+	first time.Time
+	last  time.Time
+	done  bool
+	cost  KDFCost
+}
+
+// observeStep is called before each step to count it and start the
+// negotiation timer.
+func (c *Negotiator) observeStep() {
+	now := c.Now()
+	c.timing.steps++
+	c.timing.last = now
+	if c.timing.first.IsZero() {
+		c.timing.first = now
+	}
+}
+
+// observeOutcome is called when a negotiation completes or fails.
+func (c *Negotiator) observeOutcome(success bool) {
+	if c.metrics == nil || c.timing.done {
+		return
+	}
+	c.timing.done = true
+	d := c.Now().Sub(c.timing.first)
+	if r, ok := c.metrics.(CorrelatedRecorder); ok {
+		r.NegotiationWithID(c.negotiationID, c.mechanism.Name, success, d)
+	} else {
+		c.metrics.Negotiation(c.mechanism.Name, success, d)
+	}
+	if r, ok := c.metrics.(KDFCostRecorder); ok {
+		r.KDFCost(c.mechanism.Name, success, c.timing.cost)
+	}
+}
+
+// observeKDF records the time since start as time spent in a KDF.
+func (c *Negotiator) observeKDF(start time.Time) {
+	d := c.Now().Sub(start)
+	c.timing.cost.KDF += d
+	if c.metrics != nil {
+		c.metrics.KDF(c.mechanism.Name, d)
+	}
+}
+
+// observeVerify records the time since start as time spent verifying the
+// client.
+func (c *Negotiator) observeVerify(start time.Time) {
+	c.timing.cost.Verify += c.Now().Sub(start)
+}
@@ -0,0 +1,171 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+type countingRecorder struct {
+	success, failure, kdf int
+}
+
+func (r *countingRecorder) Negotiation(_ string, success bool, _ time.Duration) {
+	if success {
+		r.success++
+		return
+	}
+	r.failure++
+}
+
+func (r *countingRecorder) KDF(string, time.Duration) {
+	r.kdf++
+}
+
+func TestMetrics(t *testing.T) {
+	r := &countingRecorder{}
+	tc := saslTestCases[1]
+	client := NewClient(scram("SCRAM-SHA-1", sha1.New), append([]Option{Metrics(r)}, tc.clientOpts...)...)
+	client.nonce = testNonce
+	for _, step := range tc.steps {
+		if _, _, err := client.Step(step.challenge); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	client.Reset()
+	client.nonce = testNonce
+	if _, _, err := client.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := client.Step([]byte("r=wrongnonce,s=QSXCR+Q6sek8bf92,i=4096")); err == nil {
+		t.Fatal("Expected error from invalid nonce")
+	}
+
+	if *r != (countingRecorder{success: 1, failure: 1, kdf: 1}) {
+		t.Errorf("Unexpected metrics: %+v", *r)
+	}
+}
+
+type durationRecorder struct {
+	negotiation, kdf time.Duration
+}
+
+func (r *durationRecorder) Negotiation(_ string, _ bool, d time.Duration) {
+	r.negotiation = d
+}
+
+func (r *durationRecorder) KDF(_ string, d time.Duration) {
+	r.kdf = d
+}
+
+func TestMetricsClock(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := &durationRecorder{}
+	tc := saslTestCases[1]
+	client := NewClient(scram("SCRAM-SHA-1", sha1.New), append([]Option{Metrics(r), Clock(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})}, tc.clientOpts...)...)
+	client.nonce = testNonce
+	for _, step := range tc.steps {
+		if _, _, err := client.Step(step.challenge); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// The clock is read at the start of each step, and before and after the
+	// KDF.
+	if r.kdf != time.Second || r.negotiation != 5*time.Second {
+		t.Errorf("Unexpected durations: %+v", *r)
+	}
+	if got := client.Now(); !got.Equal(time.Unix(7, 0)) {
+		t.Errorf("Unexpected time from Now: %v", got)
+	}
+}
+
+type costRecorder struct {
+	durationRecorder
+	success bool
+	cost    KDFCost
+}
+
+func (r *costRecorder) KDFCost(_ string, success bool, cost KDFCost) {
+	r.success, r.cost = success, cost
+}
+
+func TestKDFCost(t *testing.T) {
+	tick := func() Option {
+		now := time.Unix(0, 0)
+		return Clock(func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		})
+	}
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	r := &costRecorder{}
+	client := NewClient(ScramSha256, append([]Option{tick()}, scramClientOpts...)...)
+	server := NewServer(ScramSha256, acceptAll, Store(store), Metrics(r), tick())
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if want := (KDFCost{Iterations: 4096, KDF: time.Second}); client.KDFCost() != want {
+		t.Errorf("Unexpected client cost: want=%+v, got=%+v", want, client.KDFCost())
+	}
+	want := KDFCost{Iterations: 4096, Verify: time.Second}
+	if server.KDFCost() != want {
+		t.Errorf("Unexpected server cost: want=%+v, got=%+v", want, server.KDFCost())
+	}
+	if !r.success || r.cost != want {
+		t.Errorf("Unexpected recorded cost: success=%t, cost=%+v", r.success, r.cost)
+	}
+	server.Reset()
+	if server.KDFCost() != (KDFCost{}) {
+		t.Errorf("Expected cost to be cleared by Reset, got %+v", server.KDFCost())
+	}
+}
+
+func TestSteps(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := Clock(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+	client := NewClient(Plain, append([]Option{clock}, plainClientOpts...)...)
+	server := NewServer(Plain, acceptAll, clock)
+	if client.Steps() != 0 || !client.FirstStep().IsZero() || !client.LastStep().IsZero() {
+		t.Fatalf("Expected no steps before negotiation")
+	}
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, n := range []*Negotiator{client, server} {
+		if n.Steps() != 1 {
+			t.Errorf("Unexpected number of steps for %v: %d", n.State(), n.Steps())
+		}
+		if n.FirstStep().IsZero() || n.LastStep().Before(n.FirstStep()) {
+			t.Errorf("Unexpected step times: first=%v, last=%v", n.FirstStep(), n.LastStep())
+		}
+	}
+
+	first := server.FirstStep()
+	server.Reset()
+	if server.Steps() != 0 || !server.FirstStep().IsZero() {
+		t.Errorf("Expected reset to clear steps")
+	}
+	if _, _, err := server.Step([]byte("garbage")); err == nil {
+		t.Fatalf("Expected error for malformed response")
+	}
+	if server.Steps() != 1 || !server.FirstStep().After(first) {
+		t.Errorf("Expected failed step to be counted: steps=%d, first=%v", server.Steps(), server.FirstStep())
+	}
+	server.Reset()
+	server.Step(plainResp)
+	if server.Steps() != 1 || !server.LastStep().After(first) {
+		t.Errorf("Unexpected steps after reset: steps=%d, first=%v, last=%v", server.Steps(), server.FirstStep(), server.LastStep())
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package mqttsasl maps MQTT v5 enhanced authentication onto negotiators from
+// the sasl package.
+//
+// The Authentication Method property carries the mechanism name and each
+// CONNECT, AUTH, and CONNACK packet's Authentication Data property carries one
+// raw SASL message.
+// Encoding the packets is left to the MQTT implementation.
+package mqttsasl
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/jh125486/sasl"
+)
+
+// Reason codes used during enhanced authentication.
+const (
+	ReasonSuccess           byte = 0x00
+	ReasonContinue          byte = 0x18
+	ReasonReauthenticate    byte = 0x19
+	ReasonNotAuthorized     byte = 0x87
+	ReasonBadAuthMethod     byte = 0x8C
+	ReasonProtocolError     byte = 0x82
+	ReasonBadUserOrPass     byte = 0x86
+	ReasonUnspecifiedError  byte = 0x80
+	ReasonServerUnavailable byte = 0x88
+	ReasonServerBusy        byte = 0x89
+)
+
+// ErrMethodMismatch is returned when the peer uses a different authentication
+// method than the negotiator's mechanism.
+var ErrMethodMismatch = errors.New("mqttsasl: authentication method does not match")
+
+// Error is returned by clients when the server fails authentication.
+type Error struct {
+	Reason byte
+}
+
+func (e *Error) Error() string {
+	return "mqttsasl: server failed authentication with reason code 0x" + strconv.FormatUint(uint64(e.Reason), 16)
+}
+
+// Unwrap returns sasl.ErrAuthn so that all failures can be detected with
+// errors.Is.
+func (e *Error) Unwrap() error {
+	return sasl.ErrAuthn
+}
+
+// Temporary reports whether the server was unavailable or busy, meaning that
+// authentication may succeed if it is retried later.
+func (e *Error) Temporary() bool {
+	return e.Reason == ReasonServerUnavailable || e.Reason == ReasonServerBusy
+}
+
+// Client performs enhanced authentication for an MQTT client.
+type Client struct {
+	n *sasl.Negotiator
+}
+
+// NewClient returns a client that authenticates using the client negotiator n.
+func NewClient(n *sasl.Negotiator) *Client {
+	return &Client{n: n}
+}
+
+// Method returns the value of the Authentication Method property.
+func (c *Client) Method() string {
+	return c.n.Mechanism().Name
+}
+
+// Connect returns the Authentication Data to include in the CONNECT packet.
+func (c *Client) Connect() ([]byte, error) {
+	c.n.Reset()
+	_, data, err := c.n.Step(nil)
+	return data, err
+}
+
+// Reauthenticate returns the Authentication Data to include in an AUTH packet
+// with the ReasonReauthenticate reason code.
+func (c *Client) Reauthenticate() ([]byte, error) {
+	return c.Connect()
+}
+
+// Auth handles an AUTH packet from the server.
+// If the reason code is ReasonContinue the returned data should be sent to the
+// server in an AUTH packet with the same reason code.
+// A ReasonSuccess AUTH packet completes re-authentication and nil data is
+// returned.
+func (c *Client) Auth(method string, reason byte, data []byte) ([]byte, error) {
+	if method != c.Method() {
+		return nil, ErrMethodMismatch
+	}
+	switch reason {
+	case ReasonContinue:
+		_, resp, err := c.n.Step(data)
+		return resp, err
+	case ReasonSuccess:
+		return nil, c.finish(data)
+	}
+	return nil, &Error{Reason: reason}
+}
+
+// ConnAck handles the CONNACK packet from the server.
+func (c *Client) ConnAck(method string, reason byte, data []byte) error {
+	if reason != ReasonSuccess {
+		return &Error{Reason: reason}
+	}
+	if method != c.Method() {
+		return ErrMethodMismatch
+	}
+	return c.finish(data)
+}
+
+// finish processes the final data sent with a successful result.
+func (c *Client) finish(data []byte) error {
+	return c.n.Finish(data)
+}
+
+// Server performs enhanced authentication for an MQTT broker.
+//
+// Each method returns the reason code and Authentication Data to send to the
+// client.
+// ReasonContinue means that an AUTH packet should be sent, ReasonSuccess means
+// that a CONNACK packet (or AUTH packet when re-authenticating) should be sent,
+// and any other reason code is accompanied by a non-nil error and the
+// connection should be refused or closed.
+type Server struct {
+	n *sasl.Negotiator
+}
+
+// NewServer returns a server that authenticates clients using the server
+// negotiator n.
+func NewServer(n *sasl.Negotiator) *Server {
+	return &Server{n: n}
+}
+
+// Method returns the value of the Authentication Method property.
+func (s *Server) Method() string {
+	return s.n.Mechanism().Name
+}
+
+// Connect handles the authentication properties of a CONNECT packet.
+func (s *Server) Connect(method string, data []byte) (reason byte, resp []byte, err error) {
+	s.n.Reset()
+	return s.step(method, data)
+}
+
+// Auth handles an AUTH packet from the client.
+func (s *Server) Auth(method string, reason byte, data []byte) (byte, []byte, error) {
+	switch reason {
+	case ReasonContinue:
+		if s.n.Completed() {
+			return ReasonProtocolError, nil, sasl.ErrTooManySteps
+		}
+		return s.step(method, data)
+	case ReasonReauthenticate:
+		return s.Connect(method, data)
+	}
+	return ReasonProtocolError, nil, sasl.ErrInvalidState
+}
+
+func (s *Server) step(method string, data []byte) (byte, []byte, error) {
+	if method != s.Method() {
+		return ReasonBadAuthMethod, nil, ErrMethodMismatch
+	}
+	more, challenge, err := s.n.Step(data)
+	switch {
+	case err != nil:
+		return ReasonNotAuthorized, nil, err
+	case more:
+		return ReasonContinue, challenge, nil
+	}
+	return ReasonSuccess, challenge, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package mqttsasl_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/mqttsasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+// exchange passes packets between the client and server as an MQTT
+// implementation would.
+func exchange(c *mqttsasl.Client, s *mqttsasl.Server, reauth bool) error {
+	var (
+		data []byte
+		err  error
+	)
+	if reauth {
+		data, err = c.Reauthenticate()
+	} else {
+		data, err = c.Connect()
+	}
+	if err != nil {
+		return err
+	}
+
+	var reason byte
+	if reauth {
+		reason, data, err = s.Auth(c.Method(), mqttsasl.ReasonReauthenticate, data)
+	} else {
+		reason, data, err = s.Connect(c.Method(), data)
+	}
+	for {
+		if err != nil && !reauth {
+			return c.ConnAck(s.Method(), reason, nil)
+		}
+		switch {
+		case reason == mqttsasl.ReasonContinue:
+			if data, err = c.Auth(s.Method(), reason, data); err != nil {
+				return err
+			}
+			reason, data, err = s.Auth(c.Method(), mqttsasl.ReasonContinue, data)
+		case reauth:
+			_, err = c.Auth(s.Method(), reason, data)
+			return err
+		default:
+			return c.ConnAck(s.Method(), reason, data)
+		}
+	}
+}
+
+func TestEnhancedAuth(t *testing.T) {
+	st := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptAll := func(*sasl.Negotiator) bool { return true }
+
+	for _, tc := range []struct {
+		name     string
+		password string
+		reauth   bool
+		fail     bool
+	}{
+		{name: "connect", password: "pencil"},
+		{name: "reauth", password: "pencil", reauth: true},
+		{name: "bad-password", password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := mqttsasl.NewClient(sasl.NewClient(sasl.ScramSha256, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(tc.password), nil
+			})))
+			s := mqttsasl.NewServer(sasl.NewServer(sasl.ScramSha256, acceptAll, sasl.Store(st)))
+			err := exchange(c, s, false)
+			if tc.reauth && err == nil {
+				err = exchange(c, s, true)
+			}
+			switch {
+			case tc.fail && !errors.Is(err, sasl.ErrAuthn):
+				t.Errorf("Expected authentication error, got %v", err)
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestBadMethod(t *testing.T) {
+	s := mqttsasl.NewServer(sasl.NewServer(sasl.ScramSha256, nil))
+	reason, _, err := s.Connect("PLAIN", []byte("\x00user\x00pencil"))
+	if reason != mqttsasl.ReasonBadAuthMethod || err != mqttsasl.ErrMethodMismatch {
+		t.Errorf("Unexpected result: reason=%#x, err=%v", reason, err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl_test
+
+import (
+	"bytes"
+	"testing"
+
+	"mellium.im/sasl"
+)
+
+// echoMech is a mechanism defined outside of the package that authenticates the
+// server by checking that it echoes the client's nonce.
+func echoMech(verify bool) sasl.Mechanism {
+	return sasl.Mechanism{
+		Name:         "X-ECHO",
+		Capabilities: sasl.Capabilities{MutualAuth: verify},
+		Start: func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+			return true, n.Nonce(), nil, nil
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+			if n.State().IsServer() {
+				n.SetServerVerified()
+				return false, challenge, nil, nil
+			}
+			if !bytes.Equal(challenge, n.Nonce()) {
+				return false, nil, nil, sasl.ErrInvalidChallenge
+			}
+			if verify {
+				n.SetServerVerified()
+			}
+			return false, nil, nil, nil
+		},
+	}
+}
+
+func TestSetServerVerified(t *testing.T) {
+	for _, verify := range []bool{false, true} {
+		client := sasl.NewClient(echoMech(verify), sasl.RequireMutualAuth())
+		server := sasl.NewServer(echoMech(verify), nil)
+		_, resp, err := client.Step(nil)
+		if err != nil {
+			t.Fatalf("Unexpected client error: %v", err)
+		}
+		_, challenge, err := server.Step(resp)
+		if err != nil {
+			t.Fatalf("Unexpected server error: %v", err)
+		}
+		if server.VerifiedServer() {
+			t.Errorf("Server reported that it verified itself")
+		}
+		_, _, err = client.Step(challenge)
+		switch {
+		case verify && err != nil:
+			t.Errorf("Unexpected error with verified server: %v", err)
+		case !verify && err != sasl.ErrMutualAuth:
+			t.Errorf("Expected ErrMutualAuth without verification, got %v", err)
+		}
+		if client.VerifiedServer() != verify || client.Authenticated() != verify {
+			t.Errorf("Wrong verification state: want=%t, got verified=%t authenticated=%t", verify, client.VerifiedServer(), client.Authenticated())
+		}
+		if verify {
+			client.Reset()
+			if client.VerifiedServer() {
+				t.Errorf("Reset did not clear the verification")
+			}
+		}
+	}
+}
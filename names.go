@@ -0,0 +1,59 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strings"
+)
+
+const plusSuffix = "-PLUS"
+
+// IsPlus reports whether name is the name of a mechanism variant that uses
+// channel binding, such as "SCRAM-SHA-256-PLUS".
+func IsPlus(name string) bool {
+	return strings.HasSuffix(name, plusSuffix)
+}
+
+// TrimPlus returns name without the "-PLUS" suffix, if any, so that the
+// variants of a mechanism with and without channel binding can be compared.
+func TrimPlus(name string) string {
+	return strings.TrimSuffix(name, plusSuffix)
+}
+
+// ScramHash returns the hash function used by the SCRAM mechanism name.
+// Both the variants with and without channel binding are recognized.
+// If name is not a SCRAM mechanism supported by this package, ok is false.
+func ScramHash(name string) (h func() hash.Hash, ok bool) {
+	switch TrimPlus(name) {
+	case "SCRAM-SHA-1":
+		return sha1.New, true
+	case "SCRAM-SHA-256":
+		return sha256.New, true
+	case "SCRAM-SHA-512":
+		return sha512.New, true
+	}
+	return nil, false
+}
+
+// ValidMechanismName reports whether name is a valid SASL mechanism name as
+// defined in RFC 4422 §3.1: between 1 and 20 characters consisting of
+// uppercase letters, digits, hyphens, and underscores.
+func ValidMechanismName(name string) bool {
+	if len(name) == 0 || len(name) > 20 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
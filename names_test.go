@@ -0,0 +1,79 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"testing"
+)
+
+func TestScramHashName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		size int
+	}{
+		{name: "SCRAM-SHA-1", size: sha1.Size},
+		{name: "SCRAM-SHA-1-PLUS", size: sha1.Size},
+		{name: "SCRAM-SHA-256", size: sha256.Size},
+		{name: "SCRAM-SHA-256-PLUS", size: sha256.Size},
+		{name: "SCRAM-SHA-512", size: sha512.Size},
+		{name: "SCRAM-SHA-512-PLUS", size: sha512.Size},
+		{name: "SCRAM-SHA-2560"},
+		{name: "scram-sha-256"},
+		{name: "PLAIN"},
+	} {
+		h, ok := ScramHash(tc.name)
+		if ok != (tc.size != 0) {
+			t.Errorf("%s: unexpected ok=%t", tc.name, ok)
+			continue
+		}
+		if ok && h().Size() != tc.size {
+			t.Errorf("%s: wrong hash size: want=%d, got=%d", tc.name, tc.size, h().Size())
+		}
+	}
+}
+
+func TestPlus(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		plus bool
+		base string
+	}{
+		{name: "SCRAM-SHA-256-PLUS", plus: true, base: "SCRAM-SHA-256"},
+		{name: "SCRAM-SHA-256", base: "SCRAM-SHA-256"},
+		{name: "-PLUS", plus: true, base: ""},
+		{name: "X-PLUSSES", base: "X-PLUSSES"},
+	} {
+		if plus := IsPlus(tc.name); plus != tc.plus {
+			t.Errorf("IsPlus(%q): want=%t, got=%t", tc.name, tc.plus, plus)
+		}
+		if base := TrimPlus(tc.name); base != tc.base {
+			t.Errorf("TrimPlus(%q): want=%q, got=%q", tc.name, tc.base, base)
+		}
+	}
+}
+
+func TestValidMechanismName(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		valid bool
+	}{
+		{name: "PLAIN", valid: true},
+		{name: "SCRAM-SHA-256-PLUS", valid: true},
+		{name: "X_OAUTH2", valid: true},
+		{name: "ABCDEFGHIJKLMNOPQRST", valid: true},
+		{name: "ABCDEFGHIJKLMNOPQRSTU"},
+		{name: ""},
+		{name: "plain"},
+		{name: "SCRAM SHA"},
+		{name: "DIGEST.MD5"},
+	} {
+		if valid := ValidMechanismName(tc.name); valid != tc.valid {
+			t.Errorf("%q: want=%t, got=%t", tc.name, tc.valid, valid)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"encoding/hex"
+	"io"
+)
+
+// negotiationIDLen is the number of random bytes in a generated negotiation ID.
+const negotiationIDLen = 16
+
+// NegotiationIDSource sets the function used to generate negotiation IDs (see
+// NegotiationID), for example to reuse the request or connection ID that the
+// application already uses in its own logs.
+// F is called when the negotiator is created and each time it is reset.
+// The default generates 32 random hexadecimal digits.
+func NegotiationIDSource(f func() string) Option {
+	return func(n *Negotiator) {
+		n.idSource = f
+	}
+}
+
+// NegotiationID returns an identifier for the current negotiation that is
+// unique among negotiations (unless NegotiationIDSource is used) and that
+// changes each time the negotiator is reset.
+//
+// The ID is included in the events, log messages, transcripts, and security
+// properties of the negotiation, and is passed to metrics recorders that
+// implement CorrelatedRecorder, so that everything emitted by one attempt can
+// be correlated across the layers of an application.
+func (c *Negotiator) NegotiationID() string {
+	return c.negotiationID
+}
+
+// newNegotiationID returns the ID of a new negotiation.
+func (c *Negotiator) newNegotiationID() string {
+	if c.idSource != nil {
+		return c.idSource()
+	}
+	b := make([]byte, negotiationIDLen)
+	if _, err := io.ReadFull(entropy, b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+type idRecorder struct {
+	countingRecorder
+	ids []string
+}
+
+func (r *idRecorder) NegotiationWithID(id, _ string, success bool, d time.Duration) {
+	r.ids = append(r.ids, id)
+	r.Negotiation("", success, d)
+}
+
+func TestNegotiationID(t *testing.T) {
+	var events []Event
+	var buf bytes.Buffer
+	rec := &Recorder{}
+	metrics := &idRecorder{}
+	client := NewClient(Plain, append([]Option{
+		Events(func(e Event) { events = append(events, e) }),
+		Logger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		Record(rec),
+		Metrics(metrics),
+	}, plainClientOpts...)...)
+	server := NewServer(Plain, acceptAll)
+
+	id := client.NegotiationID()
+	if len(id) != 2*negotiationIDLen {
+		t.Fatalf("Unexpected negotiation ID %q", id)
+	}
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server.NegotiationID() == id {
+		t.Errorf("Client and server have the same negotiation ID")
+	}
+	for _, e := range events {
+		if e.NegotiationID != id {
+			t.Errorf("Wrong negotiation ID in event: want=%q, got=%q", id, e.NegotiationID)
+		}
+	}
+	for _, m := range rec.Messages() {
+		if m.NegotiationID != id {
+			t.Errorf("Wrong negotiation ID in transcript: want=%q, got=%q", id, m.NegotiationID)
+		}
+	}
+	if len(metrics.ids) != 1 || metrics.ids[0] != id || metrics.success != 1 {
+		t.Errorf("Wrong negotiation IDs passed to recorder: %v", metrics.ids)
+	}
+	if got := client.SecurityProperties().NegotiationID; got != id {
+		t.Errorf("Wrong negotiation ID in security properties: want=%q, got=%q", id, got)
+	}
+	if !strings.Contains(buf.String(), "negotiation_id="+id) {
+		t.Errorf("Expected logs to contain the negotiation ID, got:\n%s", buf.String())
+	}
+
+	client.Reset()
+	if next := client.NegotiationID(); next == id || next == "" {
+		t.Errorf("Expected a new negotiation ID after reset, got %q", next)
+	}
+	if clone := client.Clone(); clone.NegotiationID() == client.NegotiationID() {
+		t.Errorf("Expected clone to have a new negotiation ID")
+	}
+}
+
+func TestNegotiationIDSource(t *testing.T) {
+	var n int
+	client := NewClient(Plain, NegotiationIDSource(func() string {
+		n++
+		return "req-" + string(rune('0'+n))
+	}))
+	if id := client.NegotiationID(); id != "req-1" {
+		t.Errorf("Wrong negotiation ID: %q", id)
+	}
+	client.Reset()
+	if id := client.NegotiationID(); id != "req-2" {
+		t.Errorf("Wrong negotiation ID after reset: %q", id)
+	}
+}
@@ -5,15 +5,23 @@
 package sasl
 
 import (
-	"crypto/rand"
+	"context"
 	"crypto/tls"
-	"strings"
+	"crypto/x509"
+	"fmt"
+	"hash"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/jh125486/sasl/scramwire"
 )
 
 // State represents the current state of a Negotiator.
 // The first two bits represent the actual state of the state machine and the
-// last 3 bits are a bitmask that define the machines behavior.
+// last 4 bits are a bitmask that define the machines behavior.
 // The remaining bits should not be used.
+// Instead of masking bits directly, most code should use the methods on State.
 type State uint8
 
 // The current step of the Server or Client (represented by the first two bits
@@ -37,23 +45,53 @@ const (
 
 	// Receiving bit is on if the machine is a server.
 	Receiving
+
+	// Aborted bit is on if the negotiation was ended with Abort.
+	// The Errored bit is always set along with it.
+	Aborted
 )
 
+// Step returns the current step of the state machine with all other bits
+// cleared.
+// It is one of Initial, AuthTextSent, ResponseSent, or ValidServerResponse.
+func (s State) Step() State {
+	return s & StepMask
+}
+
+// IsServer reports whether the state belongs to a server (whether the
+// Receiving bit is set).
+func (s State) IsServer() bool {
+	return s&Receiving == Receiving
+}
+
+// Errored reports whether the state machine has errored.
+func (s State) Errored() bool {
+	return s&Errored == Errored
+}
+
+// Aborted reports whether the negotiation was ended with Abort.
+func (s State) Aborted() bool {
+	return s&Aborted == Aborted
+}
+
+// RemoteSupportsCB reports whether the remote client or server supports
+// channel binding.
+func (s State) RemoteSupportsCB() bool {
+	return s&RemoteCB == RemoteCB
+}
+
 // NewClient creates a new SASL Negotiator that supports creating authentication
 // requests using the given mechanism.
 func NewClient(m Mechanism, opts ...Option) *Negotiator {
 	machine := &Negotiator{
 		mechanism: m,
-		nonce:     nonce(noncerandlen, rand.Reader),
 	}
 	getOpts(machine, opts...)
-	for _, rname := range machine.remoteMechanisms {
-		lname := m.Name
-		if lname == rname && strings.HasSuffix(lname, "-PLUS") {
-			machine.state |= RemoteCB
-			return machine
-		}
-	}
+	machine.applyWorkarounds()
+	machine.nonce = machine.newNonce()
+	machine.negotiationID = machine.newNegotiationID()
+	machine.setRemoteCB()
+	machine.debug("selected client mechanism", slog.Bool("remote_cb", machine.flags.RemoteSupportsCB()))
 	return machine
 }
 
@@ -61,38 +99,191 @@ func NewClient(m Mechanism, opts ...Option) *Negotiator {
 // authentication requests using the given mechanism.
 // A nil permissions function is the same as a function that always returns
 // false.
+// The negotiator passed to permissions is reused between calls and must not be
+// retained after it returns.
 func NewServer(m Mechanism, permissions func(*Negotiator) bool, opts ...Option) *Negotiator {
 	machine := &Negotiator{
 		mechanism: m,
-		nonce:     nonce(noncerandlen, rand.Reader),
-		state:     AuthTextSent | Receiving,
+		isServer:  true,
 	}
 	getOpts(machine, opts...)
+	machine.applyWorkarounds()
+	machine.nonce = machine.newNonce()
+	machine.negotiationID = machine.newNegotiationID()
 	if permissions != nil {
 		machine.permissions = permissions
 	}
-	for _, rname := range machine.remoteMechanisms {
-		lname := m.Name
-		if lname == rname && strings.HasSuffix(lname, "-PLUS") {
-			machine.state |= RemoteCB
-			return machine
+	machine.setRemoteCB()
+	machine.debug("selected server mechanism", slog.Bool("remote_cb", machine.flags.RemoteSupportsCB()))
+	return machine
+}
+
+// setRemoteCB sets the RemoteCB bit if the remote side advertised the -PLUS
+// variant of the selected mechanism.
+func (c *Negotiator) setRemoteCB() {
+	lname := c.mechanism.Name
+	for _, rname := range c.remoteMechanisms {
+		if lname == rname && IsPlus(lname) {
+			c.flags |= RemoteCB
+			return
 		}
 	}
-	return machine
 }
 
 // A Negotiator represents a SASL client or server state machine that can
 // attempt to negotiate auth. Negotiators should not be used from multiple
 // goroutines, and must be reset between negotiation attempts.
 type Negotiator struct {
-	tlsState         *tls.ConnectionState
-	remoteMechanisms []string
-	credentials      func() (Username, Password, Identity []byte)
-	permissions      func(*Negotiator) bool
-	mechanism        Mechanism
-	state            State
-	nonce            []byte
-	cache            interface{}
+	tlsState          *tls.ConnectionState
+	serverCert        *x509.Certificate
+	remoteMechanisms  []string
+	mechPrefs         []string
+	credentials       func() (Username, Password, Identity []byte)
+	secretSource      SecretSource
+	secretKey         string
+	authzID           []byte
+	prepUsername      func([]byte) ([]byte, error)
+	prepPassword      func([]byte) ([]byte, error)
+	passwordHook      func(username, password []byte) ([]byte, error)
+	canonicalize      func(username []byte) ([]byte, error)
+	authzPrep         func(n *Negotiator, identity []byte) ([]byte, error)
+	prepPlain         bool
+	strictScram       bool
+	postgres          bool
+	cbFlagSet         bool
+	cbAdvertise       bool
+	minIterations     int
+	maxIterations     int
+	kdf               func(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte
+	requireMutual     bool
+	fips              bool
+	insecure          bool
+	permissions       func(*Negotiator) bool
+	store             CredentialStore
+	verifier          ScramVerifier
+	passwords         PasswordVerifier
+	tokenStore        TokenStore
+	extraData         ExtraDataPolicy
+	postAuth          func(*Negotiator, []byte) ([]byte, error)
+	failures          FailurePolicy
+	failureCause      error
+	fakeUserKey       []byte
+	fakeUserIter      int
+	keyCache          KeyCache
+	pinStore          PinStore
+	pinServer         string
+	serverKeyStore    ServerKeyStore
+	serverKeyServer   string
+	service           string
+	host              string
+	port              int
+	serverFQDN        string
+	realm             string
+	realmSelector     func(offered []string) (string, error)
+	properties        map[interface{}]interface{}
+	scoped            []scopedOptions
+	qopPrefs          []QOP
+	minQOP            QOP
+	workarounds       Workaround
+	tolerantBase64    bool
+	authnID           *AuthenticatedIdentity
+	negotiatedID      *AuthenticatedIdentity
+	session           []byte
+	scramSecret       *ScramSecret
+	sealedSecret      *SealedScramSecret
+	sealer            KeySealer
+	scramFragments    scramFragments
+	limiter           *Limiter
+	recorder          *Recorder
+	scramExtFirst     []scramwire.Attribute
+	scramExtFinal     []scramwire.Attribute
+	remoteExts        []scramwire.Attribute
+	mechanism         Mechanism
+	flags             State
+	isServer          bool
+	client            clientMachine
+	server            serverMachine
+	nonce             []byte
+	nonceSource       func() []byte
+	serverNonceSource func() ([]byte, error)
+	negotiationID     string
+	idSource          func() string
+	checkNonces       bool
+	minNonceLen       int
+	clock             func() time.Time
+	cache             interface{}
+	noInitialResp     bool
+	maxMessageSize    int
+	limits            Limits
+	maxBuffer         int
+	wipeSecrets       bool
+	completed         bool
+	cbType            string
+	cbData            []byte
+	noPlus            bool
+	onStateChange     func(mechanism string, old, new State)
+	onEvent           func(Event)
+	stepTimeout       time.Duration
+	deadlineAfter     time.Duration
+	deadline          time.Time
+	ctx               context.Context
+	pending           chan struct{}
+	scratch           *Negotiator
+	scramHash         *scramHash
+	lockedMemory      bool
+	secrets           *secretArena
+	logger            *slog.Logger
+	metrics           MetricsRecorder
+	timing            negotiationMetrics
+
+	// A snapshot of the credentials taken at the start of the negotiation.
+	creds struct {
+		loaded                       bool
+		username, password, identity []byte
+	}
+}
+
+// String returns a description of the negotiator suitable for logging.
+// Credentials are always redacted.
+// It has a value receiver so that negotiators are also redacted when they are
+// formatted by value, for example as a field of another struct.
+func (c Negotiator) String() string {
+	return fmt.Sprintf("{Mechanism:%s State:%#x Credentials:%s}", c.mechanism.Name, uint8(c.State()), c.redactedCreds())
+}
+
+// GoString is like String but is used by the %#v verb.
+// Credentials are always redacted.
+func (c Negotiator) GoString() string {
+	return fmt.Sprintf("sasl.Negotiator{Mechanism:%q, State:%#x, Credentials:%s}", c.mechanism.Name, uint8(c.State()), c.redactedCreds())
+}
+
+func (c *Negotiator) redactedCreds() string {
+	if c.credentials == nil && !c.creds.loaded {
+		return "<nil>"
+	}
+	return "[REDACTED]"
+}
+
+// Mechanism returns the mechanism that the negotiator was created with.
+func (c *Negotiator) Mechanism() Mechanism {
+	return c.mechanism
+}
+
+// ClientFirst reports whether the client sends the first message of the
+// negotiator's mechanism, in which case the protocol should carry it as an
+// initial response (or send an empty challenge if the InitialResponse option
+// disables it).
+// If it is false the server must send a challenge before the client responds.
+func (c *Negotiator) ClientFirst() bool {
+	return !c.mechanism.Capabilities.ServerFirst
+}
+
+// newNonce returns a nonce from the configured source or a random one.
+func (c *Negotiator) newNonce() []byte {
+	if c.nonceSource != nil {
+		return c.nonceSource()
+	}
+	return nonce(noncerandlen, entropy)
 }
 
 // Nonce returns a unique nonce that is reset for each negotiation attempt. It
@@ -104,30 +295,72 @@ func (c *Negotiator) Nonce() []byte {
 // Step attempts to transition the state machine to its next state. If Step is
 // called after a previous invocation generates an error (and the state machine
 // has not been reset to its initial state), Step panics.
+// After Abort, Step returns ErrAborted instead.
+//
+// A nil resp means that there is no message to send (for example, because the
+// mechanism has no initial response), while an empty, non-nil resp must be sent
+// as an empty message.
+// EncodeMessage and DecodeMessage can be used by protocols that represent the
+// two differently.
 func (c *Negotiator) Step(challenge []byte) (more bool, resp []byte, err error) {
-	if c.state&Errored == Errored {
+	return c.StepContext(context.Background(), challenge)
+}
+
+// StepContext is like Step except that ctx is made available to the mechanism
+// and to the callbacks that it calls, such as the credential store and the
+// permissions callback, with the Context method.
+// If ctx is already done StepContext returns its error without calling the
+// mechanism.
+// Callbacks that respect the context give up when it is canceled or its
+// deadline (or the deadline set by the NegotiationTimeout option) expires, to
+// stop callbacks that do not from blocking the step use the StepTimeout option.
+func (c *Negotiator) StepContext(ctx context.Context, challenge []byte) (more bool, resp []byte, err error) {
+	if c.flags.Aborted() {
+		return false, nil, ErrAborted
+	}
+	if c.flags.Errored() {
 		panic("sasl: Step called on a SASL state machine that has errored")
 	}
+	if c.deadlineAfter > 0 {
+		if c.deadline.IsZero() {
+			c.deadline = c.Now().Add(c.deadlineAfter)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	c.ctx = ctx
+	oldState := c.State()
 	defer func() {
 		if err != nil {
-			c.state |= Errored
+			c.fail(false)
+			if c.debugEnabled() {
+				c.debug("step failed", slog.String("error", err.Error()))
+			}
+		}
+		if err != nil || !more {
+			c.observeOutcome(err == nil)
 		}
+		c.stateChanged(oldState)
+		c.emit(Sent, resp, err)
+		err = c.maskFailure(err)
 	}()
 
-	switch c.state & StepMask {
-	case Initial:
-		more, resp, c.cache, err = c.mechanism.Start(c)
-		c.state = c.state&^StepMask | AuthTextSent
-	case AuthTextSent:
-		more, resp, c.cache, err = c.mechanism.Next(c, challenge, c.cache)
-		c.state = c.state&^StepMask | ResponseSent
-	case ResponseSent:
-		more, resp, c.cache, err = c.mechanism.Next(c, challenge, c.cache)
-		c.state = c.state&^StepMask | ValidServerResponse
-	case ValidServerResponse:
-		more, resp, c.cache, err = c.mechanism.Next(c, challenge, c.cache)
+	c.observeStep()
+	if c.State().Step() != Initial {
+		c.emit(Received, challenge, nil)
+	}
+	if c.maxMessageSize > 0 && len(challenge) > c.maxMessageSize {
+		return false, nil, ErrMessageTooLarge
+	}
+	if err = ctx.Err(); err != nil {
+		return false, nil, err
+	}
+	if err = c.checkPolicy(); err != nil {
+		return false, nil, err
 	}
 
+	more, resp, err = c.machine().next(c, challenge)
 	if err != nil {
 		return false, nil, err
 	}
@@ -135,30 +368,397 @@ func (c *Negotiator) Step(challenge []byte) (more bool, resp []byte, err error)
 	return more, resp, err
 }
 
+// Finish processes any additional data sent by the server along with its
+// success outcome (RFC 4422 §5), such as a SCRAM server signature, and makes
+// sure that the mechanism completed.
+// Data should be nil if the server did not send additional data.
+//
+// Finish returns an error if the mechanism rejects the data, if it expected to
+// exchange more messages, or if the server sent data after the mechanism had
+// already finished.
+// It must only be called on clients.
+func (c *Negotiator) Finish(data []byte) error {
+	if c.isServer {
+		return ErrInvalidState
+	}
+	if c.completed {
+		if len(data) > 0 {
+			return TransitionError{Mechanism: c.mechanism.Name, Got: "challenge"}
+		}
+		return nil
+	}
+	_, resp, err := c.Step(data)
+	if err != nil {
+		return err
+	}
+	if !c.completed || len(resp) > 0 {
+		// The mechanism still had something to send that the server will never
+		// see.
+		c.completed = false
+		return ErrAuthn
+	}
+	return nil
+}
+
 // State returns the internal state of the SASL state machine.
 func (c *Negotiator) State() State {
-	return c.state
+	return c.flags | c.machine().step()
+}
+
+// machine returns the state machine of the negotiator's role.
+func (c *Negotiator) machine() roleMachine {
+	if c.isServer {
+		return &c.server
+	}
+	return &c.client
+}
+
+// fail records that the negotiation failed or was aborted.
+func (c *Negotiator) fail(aborted bool) {
+	c.flags |= Errored
+	if aborted {
+		c.flags |= Aborted
+	}
+	c.machine().fail(aborted)
+}
+
+// endStep applies the checks that are common to clients and servers once the
+// mechanism returns and records whether the negotiation completed.
+func (c *Negotiator) endStep(more bool, err error) (bool, error) {
+	if err == nil && !more && c.minQOP > QOPAuth && c.negotiatedQOP() < c.minQOP {
+		err = ErrQOP
+	}
+
+	// If the step timed out the mechanism may still be using the secrets, they
+	// are wiped by Reset once it returns.
+	if c.wipeSecrets && (err != nil || !more) && err != ErrStepTimeout {
+		c.wipe()
+	}
+	c.completed = err == nil && !more
+	if c.completed {
+		c.updatePin()
+	}
+	return more, err
+}
+
+// stepCompleted handles a message that was received after the mechanism
+// completed.
+func (c *Negotiator) stepCompleted(msg []byte) (bool, []byte, error) {
+	resp, err := c.handleExtraData(msg)
+	if err != nil {
+		c.completed = false
+		return false, nil, err
+	}
+	return false, resp, nil
+}
+
+// Completed reports whether the negotiation finished without error.
+// For servers this means that the client was authenticated, for clients it only
+// means that the mechanism has nothing more to send.
+func (c *Negotiator) Completed() bool {
+	return c.completed
+}
+
+// Authenticated reports whether the negotiation completed and the remote side
+// was authenticated.
+// For servers this is the same as Completed.
+// For clients it is only true if the mechanism also verified the server (eg.
+// by checking the SCRAM server signature), so it is always false for
+// mechanisms such as PLAIN that do not provide mutual authentication.
+func (c *Negotiator) Authenticated() bool {
+	if c.isServer {
+		return c.completed
+	}
+	return c.completed && c.client.serverVerified
+}
+
+// VerifiedServer reports whether a client has verified the server's identity,
+// for example by checking the SCRAM server signature.
+// Callers that require mutual authentication should check it before trusting
+// the connection, even if the remote server reports success.
+func (c *Negotiator) VerifiedServer() bool {
+	return !c.isServer && c.client.serverVerified
+}
+
+// SetServerVerified is called by client mechanisms from Start or Next once
+// they have authenticated the server (for example, by checking a signature
+// that only the server could have computed) so that VerifiedServer,
+// Authenticated, and the RequireMutualAuth option take it into account.
+// It has no effect on servers and is undone by Reset.
+func (c *Negotiator) SetServerVerified() {
+	if !c.isServer {
+		c.client.serverVerified = true
+	}
 }
 
 // Reset resets the state machine to its initial state so that it can be reused
 // in another SASL exchange.
+//
+// If a previous step timed out, Reset waits for the mechanism to return.
 func (c *Negotiator) Reset() {
-	c.state = c.state & (Receiving | RemoteCB)
-
-	// Skip the start step for servers
-	if c.state&Receiving == Receiving {
-		c.state = c.state&^StepMask | AuthTextSent
+	if c.pending != nil {
+		<-c.pending
+		c.pending = nil
+	}
+	if c.wipeSecrets {
+		c.wipe()
+	}
+	if c.secrets != nil {
+		c.secrets.reset()
 	}
+	oldState := c.State()
+	defer c.stateChanged(oldState)
+	c.resetState()
+	c.authnID = nil
+}
+
+// ResetWithCredentials is like Reset but also replaces the credentials callback
+// with f.
+// Whether the remote side supports channel binding is remembered, so
+// interactive clients can prompt the user again after a failed attempt (for
+// example, when ErrAuthn is returned because of an invalid proof) and retry on
+// the same connection without creating a new negotiator.
+// Any secret provided with the PrecomputedSecret option is still used in place
+// of the new password.
+func (c *Negotiator) ResetWithCredentials(f func() (Username, Password, Identity []byte)) {
+	c.Reset()
+	c.credentials = f
+}
+
+// Clone returns a new negotiator in its initial state with the same mechanism
+// and options as c and a fresh nonce.
+// The clone does not share any per-negotiation state with c, so both may be
+// used at the same time from different goroutines, for example to race
+// negotiations on several connections to the same server.
+// Clone must not be called while a step is in progress.
+func (c *Negotiator) Clone() *Negotiator {
+	nn := *c
+	nn.pending = nil
+	nn.scratch = nil
+	nn.scramHash = nil
+	nn.secrets = nil
+	nn.authnID = nil
+	nn.scramFragments = scramFragments{}
+	nn.resetState()
+	return &nn
+}
+
+// resetState returns the state machine to its initial state and discards all
+// per-negotiation state without wiping it.
+func (c *Negotiator) resetState() {
+	c.flags &= RemoteCB
+	c.client = clientMachine{}
+	c.server = serverMachine{}
 
-	c.nonce = nonce(noncerandlen, rand.Reader)
+	c.nonce = c.newNonce()
+	c.negotiationID = c.newNegotiationID()
+	c.failureCause = nil
 	c.cache = nil
+	c.completed = false
+	c.cbType, c.cbData = "", nil
+	c.remoteExts = nil
+	c.timing = negotiationMetrics{}
+	c.creds.loaded = false
+	c.creds.username, c.creds.password, c.creds.identity = nil, nil, nil
+	c.negotiatedID = nil
+	c.session = nil
+	c.deadline = time.Time{}
+	c.ctx = nil
+}
+
+// run calls the mechanism's Start function (if start is true) or its Next
+// function, giving up with ErrStepTimeout if a step timeout is set and the
+// mechanism does not return in time.
+//
+// With a step timeout the mechanism is called on a copy of the negotiator that
+// replaces c only if it returns in time, so a mechanism that is still running
+// after the step failed never shares fields with the caller, which goes on to
+// update the state of c.
+// Reset waits for it to return before reusing anything that the copy may still
+// point to, such as the credentials.
+func (c *Negotiator) run(start bool, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+	if c.stepTimeout <= 0 {
+		return c.callMechanism(start, challenge, data)
+	}
+	type result struct {
+		more  bool
+		resp  []byte
+		cache interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	pending := make(chan struct{})
+	c.pending = pending
+	snapshot := new(Negotiator)
+	*snapshot = *c
+	go func() {
+		defer close(pending)
+		var r result
+		r.more, r.resp, r.cache, r.err = snapshot.callMechanism(start, challenge, data)
+		done <- r
+	}()
+
+	timer := time.NewTimer(c.stepTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		*c = *snapshot
+		c.pending = nil
+		return r.more, r.resp, r.cache, r.err
+	case <-timer.C:
+		return false, nil, nil, ErrStepTimeout
+	}
+}
+
+// Context returns the context of the step in progress, which was passed to
+// StepContext, or context.Background if there is none.
+// Mechanisms should pass it to any callback that may block, such as a lookup
+// in a remote credential store, so that the lookup is abandoned when the
+// context is canceled.
+func (c *Negotiator) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// callMechanism calls the mechanism's Start or Next function.
+func (c *Negotiator) callMechanism(start bool, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+	if c.limiter != nil {
+		if !c.limiter.acquire(c.limiter.steps) {
+			return false, nil, nil, ErrBusy
+		}
+		defer c.limiter.release(c.limiter.steps)
+	}
+	if start {
+		if err := c.loadCredentials(); err != nil {
+			return false, nil, nil, err
+		}
+		return c.mechanism.Start(c)
+	}
+	return c.mechanism.Next(c, challenge, data)
+}
+
+// stateChanged calls the OnStateChange callback if the state differs from old.
+func (c *Negotiator) stateChanged(old State) {
+	state := c.State()
+	if state == old {
+		return
+	}
+	if c.debugEnabled() {
+		c.debug("state changed", slog.Any("old", old), slog.Any("new", state))
+	}
+	if c.onStateChange != nil {
+		c.onStateChange(c.mechanism.Name, old, state)
+	}
+}
+
+// wipe overwrites the password and any cached mechanism state with zeros.
+func (c *Negotiator) wipe() {
+	zero(c.creds.password)
+	zero(c.client.deferredResp)
+	if b, ok := c.cache.([]byte); ok {
+		zero(b)
+	}
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// loadCredentials calls the credentials callback and stores the result so that
+// every step of a single negotiation sees the same credentials.
+func (c *Negotiator) loadCredentials() error {
+	if c.credentials == nil && c.secretSource == nil {
+		return nil
+	}
+	var username, password, identity []byte
+	if c.credentials != nil {
+		username, password, identity = c.credentials()
+	}
+	if c.secretSource != nil {
+		secret, err := c.secretSource.Get(c.Context(), c.secretKey)
+		if err != nil {
+			return err
+		}
+		password = secret
+	}
+	if c.lockedMemory && password != nil {
+		locked, err := c.lockSecret(password)
+		if err != nil {
+			return err
+		}
+		if c.wipeSecrets {
+			zero(password)
+		}
+		password = locked
+	}
+	c.creds.username, c.creds.password, c.creds.identity = username, password, identity
+	c.creds.loaded = true
+	return nil
+}
+
+// preparedCredentials returns the username and password after applying the
+// configured normalization (SASLprep by default).
+func (c *Negotiator) preparedCredentials() (username, password []byte, err error) {
+	username, password, _ = c.Credentials()
+	return c.prepare(username, password)
+}
+
+// prepare applies the configured normalization to a username and password.
+func (c *Negotiator) prepare(username, password []byte) ([]byte, []byte, error) {
+	var err error
+	if c.prepUsername != nil {
+		if username, err = c.prepUsername(username); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.prepPassword != nil {
+		prepared, err := c.prepPassword(password)
+		switch {
+		case err == nil:
+			password = prepared
+		case c.postgres:
+			// PostgreSQL uses passwords that cannot be prepared as-is.
+		default:
+			return nil, nil, err
+		}
+	}
+	if c.passwordHook != nil {
+		if password, err = c.passwordHook(username, password); err != nil {
+			return nil, nil, err
+		}
+	}
+	return username, password, nil
+}
+
+// CanonicalUsername returns the canonical form of a username sent by a client
+// as configured by the CanonicalizeUsername option, or username itself if the
+// option was not used.
+// Server mechanisms call it before looking up credentials or calling
+// Permissions.
+func (c *Negotiator) CanonicalUsername(username []byte) ([]byte, error) {
+	if c.canonicalize == nil {
+		return username, nil
+	}
+	return c.canonicalize(username)
 }
 
 // Credentials returns a username, and password for authentication and optional
 // identity for authorization.
+// Once a client negotiation has started the same credentials are returned until
+// the negotiator is reset.
 func (c *Negotiator) Credentials() (username, password, identity []byte) {
-	if c.credentials != nil {
-		return c.credentials()
+	switch {
+	case c.creds.loaded:
+		username, password, identity = c.creds.username, c.creds.password, c.creds.identity
+	case c.credentials != nil:
+		username, password, identity = c.credentials()
+	}
+	if c.authzID != nil {
+		identity = c.authzID
 	}
 	return
 }
@@ -166,13 +766,69 @@ func (c *Negotiator) Credentials() (username, password, identity []byte) {
 // Permissions is the callback used by the server to authenticate the user.
 func (c *Negotiator) Permissions(opts ...Option) bool {
 	if c.permissions != nil {
-		nn := *c
-		getOpts(&nn, opts...)
-		return c.permissions(&nn)
+		// Reuse the same copy of the negotiator for every call to avoid allocating
+		// a new one on each step.
+		if c.scratch == nil {
+			c.scratch = new(Negotiator)
+		}
+		nn := c.scratch
+		*nn = *c
+		nn.scratch = nil
+		nn.creds.loaded = false
+		nn.authzID = nil
+		// Only the credentials are replaced, the rest of the configuration is
+		// the server's.
+		nn.credentials = func() (username, password, identity []byte) {
+			return
+		}
+		nn.permissions = func(_ *Negotiator) bool {
+			return false
+		}
+		for _, o := range opts {
+			o(nn)
+		}
+		if c.authzPrep != nil {
+			if _, _, identity := nn.Credentials(); len(identity) > 0 {
+				identity, err := c.authzPrep(nn, identity)
+				if err != nil {
+					c.debug("rejected authorization identity", slog.String("error", err.Error()))
+					*nn = Negotiator{}
+					return false
+				}
+				nn.authzID = identity
+			}
+		}
+		ok := c.permissions(nn)
+		if ok {
+			username, _, identity := nn.Credentials()
+			c.setNegotiatedID(username, identity)
+		}
+		// Don't hold on to the credentials.
+		*nn = Negotiator{}
+		return ok
 	}
 	return false
 }
 
+// checkPolicy returns an error if the mechanism is not allowed by the
+// negotiator's options.
+func (c *Negotiator) checkPolicy() error {
+	if c.fips && !FIPSApproved(c.mechanism.Name) {
+		return ErrFIPS
+	}
+	if c.insecureTransport() && c.mechanism.Capabilities.RequiresTLS {
+		return InsecureTransportError{Mechanism: c.mechanism.Name}
+	}
+	return nil
+}
+
+// insecureTransport reports whether the transport was declared as not
+// confidential with the Confidential option and no completed TLS handshake was
+// provided with the TLSState option.
+func (c *Negotiator) insecureTransport() bool {
+	return c.insecure && (c.tlsState == nil || !c.tlsState.HandshakeComplete)
+}
+
 // TLSState is the state of any TLS connections being used to negotiate SASL
 // (it can be used for channel binding).
 func (c *Negotiator) TLSState() *tls.ConnectionState {
@@ -182,6 +838,13 @@ func (c *Negotiator) TLSState() *tls.ConnectionState {
 	return nil
 }
 
+// RemoteScramExtensions returns the extension attributes received in SCRAM
+// messages from the remote side during the current negotiation.
+// Servers can check them from the permissions callback.
+func (c *Negotiator) RemoteScramExtensions() []scramwire.Attribute {
+	return c.remoteExts
+}
+
 // RemoteMechanisms is a list of mechanisms as advertised by the other side of a
 // SASL negotiation.
 func (c *Negotiator) RemoteMechanisms() []string {
@@ -190,3 +853,35 @@ func (c *Negotiator) RemoteMechanisms() []string {
 	}
 	return nil
 }
+
+// UpdateRemoteMechanisms replaces the list of mechanisms advertised by the
+// other side of the negotiation, as if it had been provided with the
+// RemoteMechanisms option when the negotiator was created.
+// Long-lived connections can use it when the remote side advertises a new list,
+// for example after STARTTLS, to update whether the remote side supports
+// channel binding and the mechanisms used to update the pin set with the Pin
+// option without creating a new negotiator.
+//
+// UpdateRemoteMechanisms must be called before the first step or after the
+// negotiator is reset, otherwise it returns ErrInvalidState and the list is not
+// changed.
+func (c *Negotiator) UpdateRemoteMechanisms(names ...string) error {
+	if c.pending != nil || !c.atStart() {
+		return ErrInvalidState
+	}
+	c.setRemoteMechanisms(names)
+	return nil
+}
+
+func (c *Negotiator) setRemoteMechanisms(names []string) {
+	c.remoteMechanisms = slices.Clone(names)
+	c.applyWorkarounds()
+	c.flags &^= RemoteCB
+	c.setRemoteCB()
+}
+
+// atStart reports whether no negotiation has been started since the
+// negotiator was created or last reset.
+func (c *Negotiator) atStart() bool {
+	return !c.flags.Errored() && c.machine().atStart()
+}
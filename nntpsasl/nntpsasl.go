@@ -0,0 +1,193 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package nntpsasl implements the client side of the NNTP AUTHINFO SASL
+// command (RFC 4643 §2.4) for any client negotiator.
+package nntpsasl
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/jh125486/sasl"
+)
+
+// maxCommandLen is the maximum length of the AUTHINFO SASL command line
+// (including the trailing CRLF) that may carry an initial response.
+const maxCommandLen = 512
+
+// Response codes used by AUTHINFO SASL.
+const (
+	CodeAuthenticated     = 281
+	CodeAuthenticatedData = 283
+	CodeContinue          = 383
+	CodeRejected          = 481
+	CodeOutOfSequence     = 482
+	CodeUnavailable       = 502
+)
+
+// Error is returned when the server completes the AUTHINFO SASL command with a
+// failure response.
+type Error struct {
+	// Code is the three digit response code.
+	Code int
+
+	// Text is the human readable text sent by the server.
+	Text string
+}
+
+func (e *Error) Error() string {
+	return "nntpsasl: authentication failed: " + e.Text
+}
+
+// Unwrap returns sasl.ErrAuthn if the server rejected the credentials.
+func (e *Error) Unwrap() error {
+	if e.Code == CodeRejected {
+		return sasl.ErrAuthn
+	}
+	return nil
+}
+
+var errMalformed = errors.New("nntpsasl: malformed server response")
+
+// Authenticate runs the AUTHINFO SASL command over rw using the client
+// negotiator n.
+// The initial response is sent with the command unless that would make the
+// command line longer than 512 octets.
+//
+// If rw is a *bufio.ReadWriter its reader is used directly so that no data is
+// lost after the command completes.
+func Authenticate(ctx context.Context, rw io.ReadWriter, n *sasl.Negotiator) error {
+	return sasl.NegotiateConn(ctx, rw, n, NewCodec(rw, n.Mechanism().Name))
+}
+
+// NewCodec returns a codec that frames the AUTHINFO SASL command for use with
+// sasl.NegotiateConn.
+// Responses from the server are read from r.
+func NewCodec(r io.Reader, mechanism string) sasl.Codec {
+	c := &codec{mechanism: mechanism, decode: base64.StdEncoding.DecodeString}
+	if brw, ok := r.(*bufio.ReadWriter); ok {
+		c.r = brw.Reader
+	} else if br, ok := r.(*bufio.Reader); ok {
+		c.r = br
+	} else {
+		c.r = bufio.NewReader(r)
+	}
+	return c
+}
+
+type codec struct {
+	mechanism string
+	started   bool
+	r         *bufio.Reader
+	decode    func(string) ([]byte, error)
+}
+
+func (c *codec) WriteResponse(w io.Writer, resp []byte) error {
+	if c.started {
+		return writeLine(w, encode(resp))
+	}
+	c.started = true
+
+	cmd := "AUTHINFO SASL " + c.mechanism
+	if resp == nil {
+		// The mechanism has no initial response so the first continuation is its
+		// first challenge.
+		return writeLine(w, cmd)
+	}
+	ir, _ := sasl.EncodeInitialResponse(resp, sasl.EmptyAsEquals)
+	if len(cmd)+len(ir)+3 <= maxCommandLen {
+		return writeLine(w, cmd+" "+ir)
+	}
+	if err := writeLine(w, cmd); err != nil {
+		return err
+	}
+	// Without an initial response the server prompts for it with an empty
+	// continuation.
+	challenge, done, err := c.ReadChallenge(nil)
+	switch {
+	case err != nil:
+		return err
+	case done || len(challenge) != 0:
+		return errMalformed
+	}
+	return writeLine(w, ir)
+}
+
+// SetDecoder implements sasl.Base64Codec.
+func (c *codec) SetDecoder(decode func(string) ([]byte, error)) {
+	c.decode = decode
+}
+
+func (c *codec) ReadChallenge(io.Reader) ([]byte, bool, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < 3 {
+		return nil, false, errMalformed
+	}
+	code, text := line[:3], strings.TrimPrefix(line[3:], " ")
+
+	switch code {
+	case "383", "283":
+		data, _, _ := strings.Cut(text, " ")
+		if data == "=" {
+			data = ""
+		}
+		challenge, err := c.decode(data)
+		if err != nil {
+			return nil, false, errMalformed
+		}
+		return challenge, code == "283", nil
+	case "281":
+		return nil, true, nil
+	case "481":
+		return nil, false, &Error{Code: CodeRejected, Text: text}
+	case "482":
+		return nil, false, &Error{Code: CodeOutOfSequence, Text: text}
+	case "502":
+		return nil, false, &Error{Code: CodeUnavailable, Text: text}
+	}
+	return nil, false, errMalformed
+}
+
+// Abort cancels the exchange as described in RFC 4643 §2.4.
+func (c *codec) Abort(w io.Writer) error {
+	if err := writeLine(w, "*"); err != nil {
+		return err
+	}
+	// Consume the 481 response.
+	_, _, err := c.ReadChallenge(nil)
+	var nntpErr *Error
+	if errors.As(err, &nntpErr) {
+		return nil
+	}
+	return err
+}
+
+// encode returns resp encoded as base64, with an empty response represented
+// as "=".
+func encode(resp []byte) string {
+	if len(resp) == 0 {
+		return "="
+	}
+	return base64.StdEncoding.EncodeToString(resp)
+}
+
+func writeLine(w io.Writer, s string) error {
+	_, err := io.WriteString(w, s+"\r\n")
+	if err != nil {
+		return err
+	}
+	if bw, ok := w.(interface{ Flush() error }); ok {
+		return bw.Flush()
+	}
+	return nil
+}
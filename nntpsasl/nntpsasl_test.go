@@ -0,0 +1,196 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package nntpsasl_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/nntpsasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func encode(b []byte) string {
+	if len(b) == 0 {
+		return "="
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// serve implements just enough of an NNTP server to handle a single AUTHINFO
+// SASL command. It returns the lines received from the client.
+func serve(conn net.Conn, server *sasl.Negotiator) []string {
+	defer conn.Close()
+	var lines []string
+	r := bufio.NewReader(conn)
+	readLine := func() (string, bool) {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSuffix(line, "\r\n")
+		lines = append(lines, line)
+		return line, err == nil
+	}
+
+	cmd, ok := readLine()
+	if !ok {
+		return lines
+	}
+	fields := strings.Fields(cmd)
+
+	var resp string
+	switch len(fields) {
+	case 4:
+		resp = fields[3]
+	case 3:
+		io.WriteString(conn, "383 =\r\n")
+		if resp, ok = readLine(); !ok {
+			return lines
+		}
+	default:
+		io.WriteString(conn, "501 unexpected command\r\n")
+		return lines
+	}
+
+	for {
+		if resp == "*" {
+			io.WriteString(conn, "481 canceled\r\n")
+			return lines
+		}
+		if resp == "=" {
+			resp = ""
+		}
+		b, _ := base64.StdEncoding.DecodeString(resp)
+		if server.Completed() {
+			io.WriteString(conn, "281 done\r\n")
+			return lines
+		}
+		more, challenge, err := server.Step(b)
+		switch {
+		case err != nil:
+			io.WriteString(conn, "481 invalid credentials\r\n")
+			return lines
+		case !more && len(challenge) == 0:
+			io.WriteString(conn, "281 done\r\n")
+			return lines
+		case !more:
+			io.WriteString(conn, "283 "+encode(challenge)+" done\r\n")
+			return lines
+		}
+		io.WriteString(conn, "383 "+encode(challenge)+"\r\n")
+		if resp, ok = readLine(); !ok {
+			return lines
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		username string
+		password string
+		fail     bool
+	}{
+		{name: "plain", mech: sasl.Plain, username: "user", password: "pencil"},
+		{name: "plain-long", mech: sasl.Plain, username: strings.Repeat("u", 400), password: "pencil"},
+		{name: "plain-bad-password", mech: sasl.Plain, username: "user", password: "pen", fail: true},
+		{name: "scram", mech: sasl.ScramSha256, username: "user", password: "pencil"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, username: "user", password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			server := sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s))
+			lines := make(chan []string, 1)
+			go func() {
+				lines <- serve(serverConn, server)
+			}()
+
+			client := sasl.NewClient(tc.mech, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte(tc.username), []byte(tc.password), nil
+			}))
+			err := nntpsasl.Authenticate(context.Background(), clientConn, client)
+			switch {
+			case tc.fail && !errors.Is(err, sasl.ErrAuthn):
+				t.Errorf("Expected authentication error, got %v", err)
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if got := <-lines; len(got[0]) > 510 {
+				t.Errorf("AUTHINFO SASL command too long: %d octets", len(got[0])+2)
+			}
+		})
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		bufio.NewReader(serverConn).ReadString('\n')
+		io.WriteString(serverConn, "502 permission denied\r\n")
+	}()
+	err := nntpsasl.Authenticate(context.Background(), clientConn, sasl.NewClient(sasl.Plain))
+	var nntpErr *nntpsasl.Error
+	if !errors.As(err, &nntpErr) || nntpErr.Code != nntpsasl.CodeUnavailable {
+		t.Fatalf("Expected 502 error, got %v", err)
+	}
+	if errors.Is(err, sasl.ErrAuthn) {
+		t.Error("502 should not be reported as an authentication failure")
+	}
+}
+
+func TestAbort(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	abort := make(chan string, 1)
+	go func() {
+		defer serverConn.Close()
+		r := bufio.NewReader(serverConn)
+		r.ReadString('\n')
+		// Reply with a server-first-message containing a nonce that does not
+		// match.
+		io.WriteString(serverConn, "383 "+base64.StdEncoding.EncodeToString([]byte("r=abc,s=c2FsdA==,i=4096"))+"\r\n")
+		line, _ := r.ReadString('\n')
+		io.WriteString(serverConn, "481 canceled\r\n")
+		abort <- line
+	}()
+
+	client := sasl.NewClient(sasl.ScramSha256, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	if err := nntpsasl.Authenticate(context.Background(), clientConn, client); err == nil {
+		t.Fatal("Expected authentication to fail")
+	}
+	if got := <-abort; got != "*\r\n" {
+		t.Errorf("Expected exchange to be canceled, got %q", got)
+	}
+}
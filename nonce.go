@@ -9,6 +9,17 @@ import (
 	"io"
 )
 
+// SetEntropySource replaces the source of random bytes used to generate the
+// nonces of all negotiators that do not use the NonceSource option.
+// It is meant for embedded platforms that do not have crypto/rand, where r
+// should read from a hardware random number generator.
+// Builds with the sasl_tiny build tag do not use crypto/rand, so they must call
+// SetEntropySource before the first negotiation.
+// It is not safe to call SetEntropySource concurrently with negotiations.
+func SetEntropySource(r io.Reader) {
+	entropy = r
+}
+
 // Generates a nonce with n random bytes base64 encoded to ensure that it meets
 // the criteria for inclusion in a SCRAM message.
 func nonce(n int, r io.Reader) []byte {
@@ -0,0 +1,14 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build !sasl_tiny
+
+package sasl
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+var entropy io.Reader = rand.Reader
@@ -79,3 +79,14 @@ func BenchmarkNonce(b *testing.B) {
 		nonce(16, cr)
 	}
 }
+
+func TestSetEntropySource(t *testing.T) {
+	old := entropy
+	defer SetEntropySource(old)
+
+	SetEntropySource(zeroReader{})
+	n := NewClient(Plain)
+	if got, want := string(n.Nonce()), "AAAAAAAAAAAAAAAAAAAAAA"; got != want {
+		t.Errorf("Unexpected nonce from entropy source: want=%q, got=%q", want, got)
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build sasl_tiny
+
+package sasl
+
+import (
+	"errors"
+	"io"
+)
+
+var entropy io.Reader = noEntropy{}
+
+type noEntropy struct{}
+
+func (noEntropy) Read([]byte) (int, error) {
+	return 0, errors.New("No entropy source, SetEntropySource must be called in sasl_tiny builds")
+}
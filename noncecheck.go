@@ -0,0 +1,99 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// NonceError is returned when a SCRAM nonce received from the peer is invalid.
+type NonceError struct {
+	// Mismatch is true if the nonce does not match the nonces sent earlier in
+	// the negotiation and false if it does not meet the requirements set by the
+	// NonceRequirements option.
+	Mismatch bool
+
+	// Reason describes what is wrong with the nonce.
+	Reason string
+}
+
+func (e NonceError) Error() string {
+	return "Invalid nonce: " + e.Reason
+}
+
+// Code returns CodeNonceMismatch if the nonce did not match and
+// CodeInvalidNonce otherwise.
+func (e NonceError) Code() Code {
+	if e.Mismatch {
+		return CodeNonceMismatch
+	}
+	return CodeInvalidNonce
+}
+
+// NonceRequirements makes SCRAM servers reject client nonces, and clients
+// reject the part of the nonce added by the server, that are shorter than
+// minLen bytes or that contain characters not allowed by RFC 5802 (anything
+// other than printable ASCII characters except ",") with a NonceError.
+// By default nonces are only checked against the limits set by the
+// FieldLimits option, which lets peers with weak or non-conforming random
+// number generators authenticate.
+func NonceRequirements(minLen int) Option {
+	return func(n *Negotiator) {
+		n.checkNonces = true
+		n.minNonceLen = minLen
+	}
+}
+
+// ServerNonceSource sets the function that SCRAM servers call to generate the
+// server part of the nonce when they receive the client-first message, in
+// place of the nonce generated when the negotiator is created or reset.
+// Clustered deployments can use it to get nonces from a service that
+// guarantees that they are unique across all servers.
+// Errors returned by f are returned from Step.
+// The nonce must only contain the characters allowed by RFC 5802, otherwise
+// Step returns a NonceError.
+func ServerNonceSource(f func() ([]byte, error)) Option {
+	return func(n *Negotiator) {
+		n.serverNonceSource = f
+	}
+}
+
+// checkNonce returns an error if the nonce, received from the peer, violates
+// the requirements set by the NonceRequirements option.
+func (c *Negotiator) checkNonce(nonce []byte) error {
+	if !c.checkNonces {
+		return nil
+	}
+	if len(nonce) < c.minNonceLen {
+		return NonceError{Reason: "is too short"}
+	}
+	return checkNonceChars(nonce)
+}
+
+// checkNonceChars returns a NonceError if nonce contains characters that are
+// not allowed in a SCRAM nonce (RFC 5802 §7).
+func checkNonceChars(nonce []byte) error {
+	for _, b := range nonce {
+		if b < 0x21 || b > 0x7e || b == ',' {
+			return NonceError{Reason: "contains characters that are not allowed"}
+		}
+	}
+	return nil
+}
+
+// serverNonce returns the server part of the nonce for a SCRAM server.
+func (c *Negotiator) serverNonce() ([]byte, error) {
+	if c.serverNonceSource == nil {
+		return c.Nonce(), nil
+	}
+	nonce, err := c.serverNonceSource()
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) == 0 {
+		return nil, NonceError{Reason: "server nonce source returned an empty nonce"}
+	}
+	if err = checkNonceChars(nonce); err != nil {
+		return nil, err
+	}
+	c.nonce = nonce
+	return nonce, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestNonceRequirements(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	for _, tc := range []struct {
+		name   string
+		client []byte
+		server []byte
+		code   Code
+	}{
+		{name: "valid", client: []byte("fyko+d2lbbFgONRv9qkxdawL"), server: []byte("3rfcNHYJY1ZVvWVs7j")},
+		{name: "short client", client: []byte("abc"), server: []byte("3rfcNHYJY1ZVvWVs7j"), code: CodeInvalidNonce},
+		{name: "client charset", client: []byte("fyko+d2lbbFgONRv9qkx daw"), server: []byte("3rfcNHYJY1ZVvWVs7j"), code: CodeInvalidNonce},
+		{name: "short server", client: []byte("fyko+d2lbbFgONRv9qkxdawL"), server: []byte("3rf"), code: CodeInvalidNonce},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewClient(ScramSha256, append(scramClientOpts, NonceRequirements(16), NonceSource(func() []byte { return tc.client }))...)
+			// The server only enforces requirements on the client nonce, so the short
+			// server nonce is caught by the client.
+			server := NewServer(ScramSha256, acceptAll, Store(store), NonceRequirements(16), NonceSource(func() []byte { return tc.server }))
+			err := negotiate(client, server)
+			var nonceErr NonceError
+			switch {
+			case tc.code == "" && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			case tc.code != "" && (!errors.As(err, &nonceErr) || ErrorCode(err) != tc.code):
+				t.Errorf("Expected NonceError with code %q, got %v", tc.code, err)
+			}
+		})
+	}
+}
+
+func TestNonceMismatch(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	client := NewClient(ScramSha256, scramClientOpts...)
+	server := NewServer(ScramSha256, acceptAll, Store(store))
+	_, resp, _ := client.Step(nil)
+	_, challenge, _ := server.Step(resp)
+	_, resp, _ = client.Step(challenge)
+	// Replace the first character of the combined nonce.
+	for i := 0; i < len(resp)-3; i++ {
+		if string(resp[i:i+3]) == ",r=" {
+			resp[i+3] ^= 1
+			break
+		}
+	}
+	_, _, err := server.Step(resp)
+	if ErrorCode(err) != CodeNonceMismatch {
+		t.Errorf("Expected nonce mismatch, got %v", err)
+	}
+}
+
+func TestServerNonceSource(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	errUnavailable := errors.New("id service unavailable")
+	for _, tc := range []struct {
+		nonce []byte
+		err   error
+		code  Code
+	}{
+		{nonce: []byte("cluster-node-1-000042")},
+		{err: errUnavailable, code: CodeUnknown},
+		{nonce: []byte("has,comma"), code: CodeInvalidNonce},
+		{nonce: []byte{}, code: CodeInvalidNonce},
+	} {
+		server := NewServer(ScramSha256, acceptAll, Store(store), ServerNonceSource(func() ([]byte, error) {
+			return tc.nonce, tc.err
+		}))
+		err := negotiate(NewClient(ScramSha256, scramClientOpts...), server)
+		switch {
+		case tc.code == "" && err != nil:
+			t.Errorf("Unexpected error: %v", err)
+		case tc.code == "" && string(server.Nonce()) != string(tc.nonce):
+			t.Errorf("Server did not use the nonce from the source: %q", server.Nonce())
+		case tc.code != "" && ErrorCode(err) != tc.code:
+			t.Errorf("Unexpected error for nonce %q: want code %q, got %v", tc.nonce, tc.code, err)
+		}
+	}
+}
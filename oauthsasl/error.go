@@ -0,0 +1,93 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package oauthsasl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+
+	"github.com/jh125486/sasl"
+)
+
+// Statuses sent by servers when they reject a token.
+// OAUTHBEARER servers use the error codes from RFC 6750 §3.1 while XOAUTH2
+// servers use HTTP status codes.
+const (
+	StatusInvalidToken      = "invalid_token"
+	StatusInsufficientScope = "insufficient_scope"
+	StatusInvalidRequest    = "invalid_request"
+
+	StatusUnauthorized = "401"
+	StatusForbidden    = "403"
+	StatusBadRequest   = "400"
+)
+
+// Error is the error document sent by the server when it rejects a token
+// (RFC 7628 §3.2.2), or the equivalent document sent by XOAUTH2 servers.
+// It unwraps to sasl.ErrAuthn.
+type Error struct {
+	// Status is the reason that the token was rejected.
+	Status string `json:"status"`
+
+	// Scope is the scope that a token must have to be accepted, if the server
+	// sent one.
+	Scope string `json:"scope,omitempty"`
+
+	// OpenIDConfiguration is the URL of an OpenID Connect discovery document
+	// describing the authorization server that the client should obtain a token
+	// from.
+	OpenIDConfiguration string `json:"openid-configuration,omitempty"`
+
+	// Schemes is the list of authentication schemes supported by XOAUTH2
+	// servers.
+	Schemes string `json:"schemes,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return "oauthsasl: token rejected with status " + strconv.Quote(e.Status)
+}
+
+// Unwrap returns sasl.ErrAuthn.
+func (e *Error) Unwrap() error {
+	return sasl.ErrAuthn
+}
+
+// InvalidToken reports whether the token was rejected because it is expired,
+// revoked, or otherwise invalid, in which case a new token should be obtained
+// (re-authenticating the user if it cannot be refreshed).
+func (e *Error) InvalidToken() bool {
+	return e.Status == StatusInvalidToken || e.Status == StatusUnauthorized
+}
+
+// InsufficientScope reports whether the token was valid but was not granted
+// the scope needed to access the service, in which case the user must consent
+// to the scope in Scope before a new token will be accepted.
+func (e *Error) InsufficientScope() bool {
+	return e.Status == StatusInsufficientScope || e.Status == StatusForbidden
+}
+
+// ParseError parses an error document sent by an OAUTHBEARER or XOAUTH2
+// server.
+// Challenges returned by a Negotiator have already been base64 decoded, but
+// for convenience the document may also still be base64 encoded.
+// If the challenge is not an error document sasl.ErrInvalidChallenge is
+// returned.
+func ParseError(challenge []byte) (*Error, error) {
+	challenge = bytes.TrimSpace(challenge)
+	if len(challenge) > 0 && challenge[0] != '{' {
+		decoded, err := base64.StdEncoding.DecodeString(string(challenge))
+		if err != nil {
+			return nil, sasl.ErrInvalidChallenge
+		}
+		challenge = decoded
+	}
+	e := &Error{}
+	if err := json.Unmarshal(challenge, e); err != nil || e.Status == "" {
+		return nil, sasl.ErrInvalidChallenge
+	}
+	return e, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package oauthsasl_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/oauthsasl"
+)
+
+func TestParseError(t *testing.T) {
+	const (
+		bearer = `{"status":"invalid_token","scope":"example_scope","openid-configuration":"https://example.com/.well-known/openid-configuration"}`
+		xoauth = `{"status":"403","schemes":"Bearer","scope":"https://mail.google.com/"}`
+	)
+	for _, tc := range []struct {
+		challenge    string
+		want         oauthsasl.Error
+		invalidToken bool
+		scope        bool
+		err          error
+	}{
+		{
+			challenge: bearer,
+			want: oauthsasl.Error{
+				Status:              "invalid_token",
+				Scope:               "example_scope",
+				OpenIDConfiguration: "https://example.com/.well-known/openid-configuration",
+			},
+			invalidToken: true,
+		},
+		{
+			challenge: base64.StdEncoding.EncodeToString([]byte(xoauth)),
+			want:      oauthsasl.Error{Status: "403", Schemes: "Bearer", Scope: "https://mail.google.com/"},
+			scope:     true,
+		},
+		{challenge: `{"status":"401","schemes":"Bearer"}`, want: oauthsasl.Error{Status: "401", Schemes: "Bearer"}, invalidToken: true},
+		{challenge: `{"scope":"mail"}`, err: sasl.ErrInvalidChallenge},
+		{challenge: "not base64", err: sasl.ErrInvalidChallenge},
+		{challenge: "", err: sasl.ErrInvalidChallenge},
+	} {
+		e, err := oauthsasl.ParseError([]byte(tc.challenge))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("Unexpected error parsing %q: want=%v, got=%v", tc.challenge, tc.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if *e != tc.want {
+			t.Errorf("Unexpected error document from %q: want=%+v, got=%+v", tc.challenge, tc.want, *e)
+		}
+		if e.InvalidToken() != tc.invalidToken || e.InsufficientScope() != tc.scope {
+			t.Errorf("Unexpected classification of %q: invalid token=%t, insufficient scope=%t", tc.challenge, e.InvalidToken(), e.InsufficientScope())
+		}
+	}
+}
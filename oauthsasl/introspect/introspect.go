@@ -0,0 +1,266 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package introspect implements an oauthsasl.Validator that checks opaque
+// tokens using an OAuth 2.0 token introspection endpoint (RFC 7662).
+package introspect
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/oauthsasl"
+)
+
+// Errors returned when a token is rejected.
+var (
+	ErrInactive = errors.New("introspect: token is not active")
+	ErrAudience = errors.New("introspect: token was not issued for this audience")
+	ErrNoUser   = errors.New("introspect: token does not identify a user")
+)
+
+// Defaults used by New.
+const (
+	// DefaultCacheTTL is how long the result of introspecting a token is
+	// reused.
+	// Results for active tokens are never used after the token expires.
+	DefaultCacheTTL = 5 * time.Minute
+
+	// DefaultMaxEntries is the maximum number of cached results.
+	DefaultMaxEntries = 10000
+)
+
+const maxResponseSize = 1 << 20
+
+// Option configures a Validator.
+type Option func(*Validator)
+
+// HTTPClient sets the client used to call the introspection endpoint.
+// By default a client with a 10 second timeout is used.
+func HTTPClient(c *http.Client) Option {
+	return func(v *Validator) {
+		v.client = c
+	}
+}
+
+// CacheTTL sets how long results are cached.
+// A TTL of zero disables the cache so that revoked tokens are rejected
+// immediately.
+// The default is DefaultCacheTTL.
+func CacheTTL(d time.Duration) Option {
+	return func(v *Validator) {
+		v.ttl = d
+	}
+}
+
+// MaxEntries sets the maximum number of cached results.
+// The default is DefaultMaxEntries.
+func MaxEntries(n int) Option {
+	return func(v *Validator) {
+		v.maxEntries = n
+	}
+}
+
+// Audience makes the validator reject tokens that were not issued for aud.
+// By default the audience is not checked.
+func Audience(aud string) Option {
+	return func(v *Validator) {
+		v.audience = aud
+	}
+}
+
+// Clock sets the function used to get the current time.
+// The default is time.Now.
+func Clock(now func() time.Time) Option {
+	return func(v *Validator) {
+		v.now = now
+	}
+}
+
+// Validator checks tokens using an introspection endpoint.
+// It is safe for concurrent use and should be shared between negotiations so
+// that results are cached.
+type Validator struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	ttl          time.Duration
+	maxEntries   int
+	audience     string
+	now          func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]entry
+}
+
+type entry struct {
+	user    string
+	err     error
+	expires time.Time
+}
+
+// New returns a validator that introspects tokens at endpoint, authenticating
+// to it with the given client credentials.
+func New(endpoint, clientID, clientSecret string, opts ...Option) *Validator {
+	v := &Validator{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		ttl:          DefaultCacheTTL,
+		maxEntries:   DefaultMaxEntries,
+		now:          time.Now,
+	}
+	for _, o := range opts {
+		o(v)
+	}
+	return v
+}
+
+var _ oauthsasl.ContextValidator = (*Validator)(nil)
+
+// Validate implements oauthsasl.Validator.
+// The user is taken from the "username" member of the introspection response,
+// or from "sub" if there is no user name.
+// If the endpoint cannot be reached or reports a server error the error is
+// temporary (see sasl.IsTemporary) and is not cached.
+func (v *Validator) Validate(token string) (string, error) {
+	return v.ValidateContext(context.Background(), token)
+}
+
+// ValidateContext implements oauthsasl.ContextValidator.
+// It is like Validate except that the request to the endpoint is canceled when
+// ctx is done.
+func (v *Validator) ValidateContext(ctx context.Context, token string) (string, error) {
+	// Tokens are cached by their hash so that the cache does not hold bearer
+	// credentials.
+	key := sha256.Sum256([]byte(token))
+	now := v.now()
+
+	v.mu.Lock()
+	e, ok := v.cache[key]
+	v.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.user, e.err
+	}
+
+	e, err := v.introspect(ctx, token, now)
+	if err != nil {
+		return "", err
+	}
+	v.store(key, e, now)
+	return e.user, e.err
+}
+
+type response struct {
+	Active   bool            `json:"active"`
+	Username string          `json:"username"`
+	Sub      string          `json:"sub"`
+	Exp      int64           `json:"exp"`
+	Aud      json.RawMessage `json:"aud"`
+}
+
+// introspect calls the endpoint and returns the result to cache.
+// The error is only set if the result must not be cached.
+func (v *Validator) introspect(ctx context.Context, token string, now time.Time) (entry, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return entry{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// RFC 6749 §2.3.1 requires the credentials to be form encoded first.
+	req.SetBasicAuth(url.QueryEscape(v.clientID), url.QueryEscape(v.clientSecret))
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return entry{}, sasl.Temporary(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := errors.New("introspect: endpoint returned status " + resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			err = sasl.Temporary(err)
+		}
+		return entry{}, err
+	}
+	var r response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&r); err != nil {
+		return entry{}, sasl.Temporary(err)
+	}
+
+	e := entry{expires: now.Add(v.ttl)}
+	switch {
+	case !r.Active:
+		e.err = ErrInactive
+	case r.Exp != 0 && !now.Before(time.Unix(r.Exp, 0)):
+		e.err = ErrInactive
+	case v.audience != "" && !hasAudience(r.Aud, v.audience):
+		e.err = ErrAudience
+	default:
+		e.user = r.Username
+		if e.user == "" {
+			e.user = r.Sub
+		}
+		if e.user == "" {
+			e.err = ErrNoUser
+		}
+		if exp := time.Unix(r.Exp, 0); r.Exp != 0 && exp.Before(e.expires) {
+			e.expires = exp
+		}
+	}
+	return e, nil
+}
+
+// hasAudience reports whether the aud member, which may be a string or an
+// array of strings, contains want.
+func hasAudience(aud json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(aud, &one) == nil {
+		return one == want
+	}
+	var many []string
+	if json.Unmarshal(aud, &many) != nil {
+		return false
+	}
+	for _, a := range many {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Validator) store(key [sha256.Size]byte, e entry, now time.Time) {
+	if v.ttl <= 0 || v.maxEntries <= 0 {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cache == nil {
+		v.cache = make(map[[sha256.Size]byte]entry)
+	}
+	if len(v.cache) >= v.maxEntries {
+		for k, old := range v.cache {
+			if !now.Before(old.expires) {
+				delete(v.cache, k)
+			}
+		}
+		if len(v.cache) >= v.maxEntries {
+			clear(v.cache)
+		}
+	}
+	v.cache[key] = e
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package introspect_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/oauthsasl/introspect"
+)
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var calls int
+	var unavailable bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client%3A1" || secret != "s%26cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if unavailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		resp := map[string]interface{}{"active": false}
+		switch r.PostFormValue("token") {
+		case "valid":
+			resp = map[string]interface{}{"active": true, "username": "user", "aud": "imap", "exp": now.Add(time.Hour).Unix()}
+		case "short":
+			resp = map[string]interface{}{"active": true, "sub": "subject", "aud": []string{"imap", "smtp"}, "exp": now.Add(time.Minute).Unix()}
+		case "other":
+			resp = map[string]interface{}{"active": true, "username": "user", "aud": "smtp"}
+		case "anonymous":
+			resp = map[string]interface{}{"active": true, "aud": "imap"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	v := introspect.New(srv.URL, "client:1", "s&cret", introspect.Audience("imap"), introspect.Clock(func() time.Time {
+		return now
+	}))
+	for _, tc := range []struct {
+		token string
+		user  string
+		err   error
+	}{
+		{token: "valid", user: "user"},
+		{token: "short", user: "subject"},
+		{token: "revoked", err: introspect.ErrInactive},
+		{token: "other", err: introspect.ErrAudience},
+		{token: "anonymous", err: introspect.ErrNoUser},
+	} {
+		user, err := v.Validate(tc.token)
+		if user != tc.user || !errors.Is(err, tc.err) {
+			t.Errorf("Unexpected result for %q: want=%q, %v, got=%q, %v", tc.token, tc.user, tc.err, user, err)
+		}
+	}
+	if calls != 5 {
+		t.Fatalf("Unexpected number of calls: want=5, got=%d", calls)
+	}
+
+	// Results, including negative ones, are cached.
+	v.Validate("valid")
+	v.Validate("revoked")
+	if calls != 5 {
+		t.Errorf("Expected cached results to be used, got %d calls", calls)
+	}
+
+	// Results for tokens that expire before the TTL are only cached until the
+	// token expires.
+	now = now.Add(2 * time.Minute)
+	v.Validate("valid")
+	v.Validate("short")
+	if calls != 6 {
+		t.Errorf("Expected expired token to be introspected again, got %d calls", calls)
+	}
+
+	now = now.Add(introspect.DefaultCacheTTL)
+	unavailable = true
+	if _, err := v.Validate("valid"); !sasl.IsTemporary(err) {
+		t.Errorf("Expected temporary error, got %v", err)
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"active":true,"username":"user"}`))
+	}))
+	defer srv.Close()
+
+	v := introspect.New(srv.URL, "client", "secret", introspect.CacheTTL(0))
+	v.Validate("token")
+	v.Validate("token")
+	if calls != 2 {
+		t.Errorf("Expected every validation to call the endpoint, got %d calls", calls)
+	}
+}
@@ -0,0 +1,448 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package jwt implements an oauthsasl.Validator that accepts JSON Web Tokens
+// (RFC 7519) signed by keys published in a JSON Web Key Set (RFC 7517).
+//
+// Tokens must be signed with RS256, RS384, RS512, PS256, PS384, PS512, ES256,
+// ES384, ES512, or EdDSA (Ed25519), must be issued by the configured issuer for
+// the configured audience, and must have an expiration time.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // Register SHA-256 for crypto.Hash.
+	_ "crypto/sha512" // Register SHA-384 and SHA-512 for crypto.Hash.
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/oauthsasl"
+)
+
+// Errors returned when a token is rejected.
+var (
+	ErrMalformed   = errors.New("jwt: malformed token")
+	ErrAlgorithm   = errors.New("jwt: unsupported or mismatched signing algorithm")
+	ErrUnknownKey  = errors.New("jwt: token was signed by an unknown key")
+	ErrSignature   = errors.New("jwt: invalid signature")
+	ErrExpired     = errors.New("jwt: token is expired or has no expiration time")
+	ErrNotYetValid = errors.New("jwt: token is not valid yet")
+	ErrIssuer      = errors.New("jwt: token was issued by an unexpected issuer")
+	ErrAudience    = errors.New("jwt: token was not issued for this audience")
+	ErrNoUser      = errors.New("jwt: token does not identify a user")
+)
+
+// Defaults used by New.
+const (
+	// DefaultCacheTTL is how long keys fetched from the key set are used before
+	// the key set is fetched again.
+	DefaultCacheTTL = time.Hour
+
+	// DefaultUserClaim is the claim that contains the user name.
+	DefaultUserClaim = "sub"
+)
+
+const (
+	// minRefreshInterval is the minimum time between fetches of the key set when
+	// a token is signed by an unknown key.
+	minRefreshInterval = time.Minute
+
+	maxKeySetSize = 1 << 20
+)
+
+// Option configures a Validator.
+type Option func(*Validator)
+
+// HTTPClient sets the client used to fetch the key set.
+// By default a client with a 10 second timeout is used.
+func HTTPClient(c *http.Client) Option {
+	return func(v *Validator) {
+		v.client = c
+	}
+}
+
+// CacheTTL sets how long keys are cached before the key set is fetched again.
+// The default is DefaultCacheTTL.
+func CacheTTL(d time.Duration) Option {
+	return func(v *Validator) {
+		v.ttl = d
+	}
+}
+
+// Leeway allows for clock skew between the validator and the token issuer when
+// checking the expiration and not before times.
+func Leeway(d time.Duration) Option {
+	return func(v *Validator) {
+		v.leeway = d
+	}
+}
+
+// UserClaim sets the name of the claim that contains the user name.
+// The default is DefaultUserClaim.
+func UserClaim(name string) Option {
+	return func(v *Validator) {
+		v.userClaim = name
+	}
+}
+
+// Clock sets the function used to get the current time.
+// The default is time.Now.
+func Clock(now func() time.Time) Option {
+	return func(v *Validator) {
+		v.now = now
+	}
+}
+
+// Validator checks JSON Web Tokens.
+// It is safe for concurrent use and should be shared between negotiations so
+// that the key set is cached.
+type Validator struct {
+	jwksURL   string
+	issuer    string
+	audience  string
+	client    *http.Client
+	ttl       time.Duration
+	leeway    time.Duration
+	userClaim string
+	now       func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// New returns a validator that accepts tokens issued by issuer for audience
+// and signed by a key from the key set at jwksURL.
+func New(jwksURL, issuer, audience string, opts ...Option) *Validator {
+	v := &Validator{
+		jwksURL:   jwksURL,
+		issuer:    issuer,
+		audience:  audience,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		ttl:       DefaultCacheTTL,
+		userClaim: DefaultUserClaim,
+		now:       time.Now,
+	}
+	for _, o := range opts {
+		o(v)
+	}
+	return v
+}
+
+var _ oauthsasl.Validator = (*Validator)(nil)
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Validate implements oauthsasl.Validator.
+// If the key set cannot be fetched the error is temporary (see
+// sasl.IsTemporary).
+func (v *Validator) Validate(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	key, err := v.key(h.Kid)
+	if err != nil {
+		return "", err
+	}
+	if err := verify(h.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return "", err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	return v.checkClaims(claims)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformed
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+func (v *Validator) checkClaims(claims map[string]interface{}) (string, error) {
+	now := v.now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok || !now.Before(exp.Add(v.leeway)) {
+		return "", ErrExpired
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.leeway).Before(nbf) {
+		return "", ErrNotYetValid
+	}
+	if iss, _ := claims["iss"].(string); iss != v.issuer {
+		return "", ErrIssuer
+	}
+	if !hasAudience(claims["aud"], v.audience) {
+		return "", ErrAudience
+	}
+	user, _ := claims[v.userClaim].(string)
+	if user == "" {
+		return "", ErrNoUser
+	}
+	return user, nil
+}
+
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
+
+func hasAudience(aud interface{}, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []interface{}:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the key with the given ID, fetching the key set if the cached
+// copy has expired or does not contain the key.
+func (v *Validator) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	stale := v.keys == nil || now.Sub(v.fetched) >= v.ttl
+	key, ok := v.lookup(kid)
+	if !stale && !ok && now.Sub(v.fetched) >= minRefreshInterval {
+		// The issuer may have rotated its keys.
+		stale = true
+	}
+	if stale {
+		keys, err := v.fetch()
+		if err != nil {
+			return nil, sasl.Temporary(err)
+		}
+		v.keys, v.fetched = keys, now
+		key, ok = v.lookup(kid)
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// lookup returns the key with the given ID, or the only key if the token does
+// not have a key ID and there is exactly one key.
+func (v *Validator) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k, true
+		}
+	}
+	k, ok := v.keys[kid]
+	return k, ok
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (v *Validator) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := v.client.Get(v.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("jwt: fetching key set failed with status " + resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxKeySetSize)).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Keys of unknown types are skipped so that issuers can add new key types
+		// without breaking existing validators.
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+var errKey = errors.New("jwt: invalid key")
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, errKey
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errKey
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var ecdhCurve ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, errKey
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != size {
+			return nil, errKey
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil || len(y) != size {
+			return nil, errKey
+		}
+		// Make sure that the point is on the curve.
+		if _, err := ecdhCurve.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, errKey
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errKey
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errKey
+}
+
+// verify checks the signature of a token with the algorithm from its header.
+// The key type must match the algorithm so that a token cannot choose a weaker
+// algorithm than the one the key was published for.
+func verify(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var h crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		h = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		h = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		h = crypto.SHA512
+	case "EdDSA":
+	default:
+		return ErrAlgorithm
+	}
+
+	var ok bool
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' && alg[0] != 'P' {
+			return ErrAlgorithm
+		}
+		digest := hashed(h, signed)
+		if alg[0] == 'R' {
+			ok = rsa.VerifyPKCS1v15(key, h, digest, sig) == nil
+		} else {
+			ok = rsa.VerifyPSS(key, h, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		if alg != ecdsaAlg(key.Curve) {
+			return ErrAlgorithm
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrSignature
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		ok = ecdsa.Verify(key, hashed(h, signed), r, s)
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return ErrAlgorithm
+		}
+		ok = ed25519.Verify(key, signed, sig)
+	default:
+		return ErrAlgorithm
+	}
+	if !ok {
+		return ErrSignature
+	}
+	return nil
+}
+
+// ecdsaAlg returns the only algorithm that may be used with keys on curve c.
+func ecdsaAlg(c elliptic.Curve) string {
+	switch c.Params().BitSize {
+	case 256:
+		return "ES256"
+	case 384:
+		return "ES384"
+	case 521:
+		return "ES512"
+	}
+	return ""
+}
+
+func hashed(h crypto.Hash, b []byte) []byte {
+	hh := h.New()
+	hh.Write(b)
+	return hh.Sum(nil)
+}
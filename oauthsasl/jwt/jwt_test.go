@@ -0,0 +1,156 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/oauthsasl/jwt"
+)
+
+var b64 = base64.RawURLEncoding.EncodeToString
+
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestValidate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPub)},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+		}})
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1700000000, 0)
+	v := jwt.New(srv.URL, "https://issuer.example", "imap", jwt.Leeway(time.Minute), jwt.Clock(func() time.Time {
+		return now
+	}))
+	claims := func(mod func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "https://issuer.example",
+			"aud": []string{"smtp", "imap"},
+			"sub": "user@example.com",
+			"exp": now.Add(time.Hour).Unix(),
+			"nbf": now.Add(-time.Hour).Unix(),
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+
+	for i, tc := range []struct {
+		token string
+		err   error
+	}{
+		{token: sign(t, "RS256", "rsa", rsaKey, claims(nil))},
+		{token: sign(t, "ES256", "ec", ecKey, claims(nil))},
+		{token: sign(t, "EdDSA", "ed", edKey, claims(func(c map[string]interface{}) { c["aud"] = "imap" }))},
+		{token: sign(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() })), err: jwt.ErrExpired},
+		{token: sign(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() }))},
+		{token: sign(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) { delete(c, "exp") })), err: jwt.ErrExpired},
+		{token: sign(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) { c["nbf"] = now.Add(time.Hour).Unix() })), err: jwt.ErrNotYetValid},
+		{token: sign(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example" })), err: jwt.ErrIssuer},
+		{token: sign(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) { c["aud"] = "smtp" })), err: jwt.ErrAudience},
+		{token: sign(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) { delete(c, "sub") })), err: jwt.ErrNoUser},
+		{token: sign(t, "ES256", "rsa", ecKey, claims(nil)), err: jwt.ErrAlgorithm},
+		{token: sign(t, "none", "rsa", rsaKey, claims(nil)), err: jwt.ErrAlgorithm},
+		{token: sign(t, "RS256", "ec", rsaKey, claims(nil)), err: jwt.ErrAlgorithm},
+		{token: sign(t, "RS256", "enc", rsaKey, claims(nil)), err: jwt.ErrUnknownKey},
+		{token: sign(t, "ES256", "ec", ecKey, claims(nil))[:40] + "x", err: jwt.ErrMalformed},
+		{token: "a.b", err: jwt.ErrMalformed},
+	} {
+		user, err := v.Validate(tc.token)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%d: Unexpected error: want=%v, got=%v", i, tc.err, err)
+			continue
+		}
+		if err == nil && user != "user@example.com" {
+			t.Errorf("%d: Unexpected user %q", i, user)
+		}
+	}
+
+	// Tampering with the claims invalidates the signature.
+	token := sign(t, "RS256", "rsa", rsaKey, claims(nil))
+	forged := sign(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) { c["sub"] = "admin" }))
+	if _, err := v.Validate(token[:len(token)-342] + forged[len(forged)-342:]); !errors.Is(err, jwt.ErrSignature) {
+		t.Errorf("Unexpected error for forged token: want=%v, got=%v", jwt.ErrSignature, err)
+	}
+
+	// The unknown key was requested too soon after the first fetch to trigger
+	// another one.
+	if fetches != 1 {
+		t.Errorf("Unexpected number of key set fetches: want=1, got=%d", fetches)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := v.Validate(sign(t, "RS256", "enc", rsaKey, claims(nil))); !errors.Is(err, jwt.ErrUnknownKey) || fetches != 2 {
+		t.Errorf("Expected unknown key to trigger a fetch: fetches=%d, err=%v", fetches, err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := v.Validate(sign(t, "RS256", "rsa", rsaKey, claims(nil))); err != nil || fetches != 3 {
+		t.Errorf("Expected expired key set to be fetched again: fetches=%d, err=%v", fetches, err)
+	}
+
+	srv.Close()
+	now = now.Add(2 * time.Hour)
+	if _, err := v.Validate(sign(t, "RS256", "rsa", rsaKey, claims(nil))); !sasl.IsTemporary(err) {
+		t.Errorf("Expected temporary error when the key set cannot be fetched, got %v", err)
+	}
+}
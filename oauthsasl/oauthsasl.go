@@ -0,0 +1,323 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package oauthsasl implements the OAUTHBEARER mechanism (RFC 7628), which
+// authenticates using OAuth 2.0 bearer tokens.
+//
+// Bearer tokens are sent in the clear, so the mechanism requires a
+// confidential transport such as TLS.
+package oauthsasl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/scramwire"
+)
+
+// A TokenSource returns access tokens for clients.
+type TokenSource interface {
+	// Token returns an access token.
+	// If refresh is true the server rejected the previous token, so a new token
+	// should be obtained instead of returning a cached one.
+	Token(refresh bool) (string, error)
+}
+
+// TokenSourceFunc is an adapter that lets an ordinary function be used as a
+// TokenSource.
+type TokenSourceFunc func(refresh bool) (string, error)
+
+// Token calls f(refresh).
+func (f TokenSourceFunc) Token(refresh bool) (string, error) {
+	return f(refresh)
+}
+
+// SecretTokens returns a TokenSource that fetches a token from src using key
+// each time one is needed, so tokens that are rotated in a secrets manager are
+// picked up without restarting the client.
+func SecretTokens(src sasl.SecretSource, key string) TokenSource {
+	return TokenSourceFunc(func(bool) (string, error) {
+		token, err := src.Get(context.Background(), key)
+		if err != nil {
+			return "", err
+		}
+		return string(token), nil
+	})
+}
+
+// A Validator checks bearer tokens for servers.
+type Validator interface {
+	// Validate returns the user that the token was issued to or an error if the
+	// token is not valid.
+	// If the error is an *Error it is sent to the client, otherwise the client
+	// is sent an error with StatusInvalidToken.
+	// Temporary errors (see sasl.IsTemporary), such as failing to reach an
+	// authorization server, are returned from Step instead.
+	Validate(token string) (user string, err error)
+}
+
+// A ContextValidator is a Validator that can abandon validation when the
+// negotiation's context (see sasl.Negotiator.StepContext) is canceled or
+// expires, for example while waiting for an authorization server.
+// If the validator passed to Server implements it, ValidateContext is called
+// instead of Validate.
+type ContextValidator interface {
+	Validator
+	ValidateContext(ctx context.Context, token string) (user string, err error)
+}
+
+// ValidatorFunc is an adapter that lets an ordinary function be used as a
+// Validator.
+type ValidatorFunc func(token string) (string, error)
+
+// Validate calls f(token).
+func (f ValidatorFunc) Validate(token string) (string, error) {
+	return f(token)
+}
+
+const kvsep = 0x01
+
+var capabilities = sasl.Capabilities{
+	RequiresTLS: true,
+	Plaintext:   true,
+}
+
+// attempt records the outcome of a negotiation for Negotiate.
+type attempt struct {
+	refresh  bool
+	rejected *Error
+}
+
+// Client returns a client-side OAUTHBEARER mechanism that authenticates with
+// tokens from ts.
+// The authorization identity from the sasl.Credentials or
+// sasl.AuthorizationIdentity options (or the username, if there is no
+// authorization identity) is sent to the server along with the host and port
+// from the sasl.Host option, if set.
+//
+// If the server rejects the token the client sends the empty response required
+// by RFC 7628 and the server fails the exchange.
+// Use Negotiate to retry with a fresh token.
+func Client(ts TokenSource) sasl.Mechanism {
+	return client(ts, nil)
+}
+
+func client(ts TokenSource, a *attempt) sasl.Mechanism {
+	return sasl.Mechanism{
+		Name:         "OAUTHBEARER",
+		Capabilities: capabilities,
+		Start: func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+			token, err := ts.Token(a != nil && a.refresh)
+			if err != nil {
+				return false, nil, nil, err
+			}
+			username, _, identity := n.Credentials()
+			if len(identity) == 0 {
+				identity = username
+			}
+			resp := []byte("n,")
+			if len(identity) > 0 {
+				resp = append(resp, "a="...)
+				resp = append(resp, scramwire.Escape(identity)...)
+			}
+			resp = append(resp, ',', kvsep)
+			if host, port := n.Host(); host != "" {
+				resp = append(resp, "host="+host...)
+				resp = append(resp, kvsep)
+				if port != 0 {
+					resp = append(resp, "port="+strconv.Itoa(port)...)
+					resp = append(resp, kvsep)
+				}
+			}
+			resp = append(resp, "auth=Bearer "+token...)
+			// The exchange is normally complete after the initial response, but if
+			// the server rejects the token Next is called with its error document.
+			return false, append(resp, kvsep, kvsep), nil, nil
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+			if n.State().IsServer() || n.State().Step() != sasl.AuthTextSent {
+				return false, nil, nil, sasl.ErrTooManySteps
+			}
+			e, err := ParseError(challenge)
+			if err != nil {
+				return false, nil, nil, err
+			}
+			if a != nil {
+				a.rejected = e
+			}
+			return true, []byte{kvsep}, nil, nil
+		},
+	}
+}
+
+// Negotiate calls do with a client negotiator for the OAUTHBEARER mechanism,
+// created with the given options, that authenticates with tokens from ts.
+// Do is expected to run a complete exchange, for example by calling
+// sasl.NegotiateConn.
+//
+// If the server rejects the token as invalid (for example, because it has
+// expired) the negotiator is reset and do is called once more
+// after ts is asked for a fresh token.
+// If authentication still fails, the returned error wraps the *Error sent by
+// the server.
+func Negotiate(ts TokenSource, do func(*sasl.Negotiator) error, opts ...sasl.Option) error {
+	a := &attempt{}
+	n := sasl.NewClient(client(ts, a), opts...)
+	err := do(n)
+	if err != nil && a.rejected != nil && a.rejected.InvalidToken() {
+		a.refresh, a.rejected = true, nil
+		n.Reset()
+		err = do(n)
+	}
+	if err != nil && a.rejected != nil && !errors.As(err, new(*Error)) {
+		err = errors.Join(err, a.rejected)
+	}
+	return err
+}
+
+// serverState is cached by the server between the error challenge and the
+// client's empty response.
+type serverState struct {
+	err *Error
+}
+
+// Server returns a server-side OAUTHBEARER mechanism that checks tokens using
+// v.
+// Once v accepts a token the user it returns is passed to the negotiator's
+// permissions callback as the username along with the authorization identity
+// requested by the client, if any.
+//
+// If the negotiator is aborted (see sasl.Negotiator.Abort) before an error was
+// sent, the message that it returns is an error document with the status
+// StatusInvalidRequest.
+func Server(v Validator) sasl.Mechanism {
+	return sasl.Mechanism{
+		Name:         "OAUTHBEARER",
+		Capabilities: capabilities,
+		Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+			return false, nil, nil, sasl.ErrInvalidState
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			if !n.State().IsServer() {
+				return false, nil, nil, sasl.ErrInvalidState
+			}
+			if st, ok := data.(*serverState); ok {
+				// The client must acknowledge the error with a single separator.
+				if len(challenge) != 1 || challenge[0] != kvsep {
+					return false, nil, nil, sasl.ErrInvalidChallenge
+				}
+				return false, nil, nil, st.err
+			}
+
+			identity, token, err := parseClientResponse(challenge)
+			if err != nil {
+				return false, nil, nil, err
+			}
+			if err = n.CheckField("identity", identity); err != nil {
+				return false, nil, nil, err
+			}
+			var user string
+			if cv, ok := v.(ContextValidator); ok {
+				user, err = cv.ValidateContext(n.Context(), token)
+			} else {
+				user, err = v.Validate(token)
+			}
+			if err != nil && sasl.IsTemporary(err) {
+				// The token may be valid, so fail the exchange instead of telling the
+				// client to get a new one.
+				return false, nil, nil, err
+			}
+			if err != nil {
+				var e *Error
+				if !errors.As(err, &e) {
+					e = &Error{Status: StatusInvalidToken}
+				}
+				return sendError(n, e)
+			}
+			username, err := n.CanonicalUsername([]byte(user))
+			if err != nil {
+				return false, nil, nil, err
+			}
+			if !n.Permissions(sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return username, nil, identity
+			})) {
+				if n.FailurePolicy() == sasl.FailureGeneric {
+					// Fail the same way as for an invalid token so that clients cannot
+					// tell that the token was valid.
+					return sendError(n, &Error{Status: StatusInvalidToken})
+				}
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, nil, nil, nil
+		},
+		Abort: func(_ *sasl.Negotiator, data interface{}) []byte {
+			if _, ok := data.(*serverState); ok {
+				// The error was already sent and only the client's acknowledgement is
+				// outstanding.
+				return nil
+			}
+			// Report the abort as a malformed request rather than an invalid token so
+			// that clients do not discard a token that may be valid.
+			doc, _ := json.Marshal(&Error{Status: StatusInvalidRequest})
+			return doc
+		},
+	}
+}
+
+// sendError returns the error challenge for e and remembers it until the client
+// acknowledges it.
+// With the sasl.FailureGeneric policy only the status "invalid_token" and the
+// OpenID Connect discovery document are sent.
+func sendError(n *sasl.Negotiator, e *Error) (bool, []byte, interface{}, error) {
+	if n.FailurePolicy() == sasl.FailureGeneric {
+		e = &Error{Status: StatusInvalidToken, OpenIDConfiguration: e.OpenIDConfiguration}
+	}
+	doc, err := json.Marshal(e)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	return true, doc, &serverState{err: e}, nil
+}
+
+// parseClientResponse returns the authorization identity and bearer token from
+// a client response.
+func parseClientResponse(msg []byte) (identity []byte, token string, err error) {
+	gs2, rest, ok := bytes.Cut(msg, []byte{kvsep})
+	if !ok || !bytes.HasSuffix(rest, []byte{kvsep, kvsep}) {
+		return nil, "", sasl.ErrInvalidChallenge
+	}
+	// Channel binding is not supported, so the flag must be "n" or "y".
+	flag, gs2, _ := bytes.Cut(gs2, []byte{','})
+	authz, gs2, ok := bytes.Cut(gs2, []byte{','})
+	if !ok || len(gs2) != 0 || (string(flag) != "n" && string(flag) != "y") {
+		return nil, "", sasl.ErrInvalidChallenge
+	}
+	if len(authz) > 0 {
+		a, ok := bytes.CutPrefix(authz, []byte("a="))
+		if !ok {
+			return nil, "", sasl.ErrInvalidChallenge
+		}
+		if identity, err = scramwire.Unescape(a); err != nil {
+			return nil, "", sasl.ErrInvalidChallenge
+		}
+	}
+
+	for _, kv := range bytes.Split(bytes.TrimSuffix(rest, []byte{kvsep, kvsep}), []byte{kvsep}) {
+		key, value, ok := bytes.Cut(kv, []byte{'='})
+		if !ok || string(key) != "auth" {
+			continue
+		}
+		scheme, t, ok := strings.Cut(string(value), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || t == "" {
+			return nil, "", sasl.ErrInvalidChallenge
+		}
+		return identity, t, nil
+	}
+	return nil, "", sasl.ErrInvalidChallenge
+}
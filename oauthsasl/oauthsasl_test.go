@@ -0,0 +1,207 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package oauthsasl_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/oauthsasl"
+	"github.com/jh125486/sasl/sasltest"
+)
+
+// tokens returns "expired" until it is asked to refresh.
+var tokens = oauthsasl.TokenSourceFunc(func(refresh bool) (string, error) {
+	if refresh {
+		return "fresh", nil
+	}
+	return "expired", nil
+})
+
+var validator = oauthsasl.ValidatorFunc(func(token string) (string, error) {
+	switch token {
+	case "fresh", "valid":
+		return "user@example.com", nil
+	case "forbidden":
+		return "", &oauthsasl.Error{Status: "insufficient_scope", Scope: "mail"}
+	}
+	return "", errors.New("unknown token")
+})
+
+func serverFor(t *testing.T, wantIdentity string) *sasl.Negotiator {
+	return sasl.NewServer(oauthsasl.Server(validator), func(n *sasl.Negotiator) bool {
+		user, _, identity := n.Credentials()
+		if string(user) != "user@example.com" || string(identity) != wantIdentity {
+			t.Errorf("Unexpected credentials: user=%q, identity=%q", user, identity)
+			return false
+		}
+		return true
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	client := sasl.NewClient(oauthsasl.Client(oauthsasl.TokenSourceFunc(func(bool) (string, error) {
+		return "valid", nil
+	})), sasl.AuthorizationIdentity([]byte("a,b=c")), sasl.Host("imap.example.com", 993))
+	transcript, err := sasltest.Negotiate(client, serverFor(t, "a,b=c"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	const want = "n,a=a=2Cb=3Dc,\x01host=imap.example.com\x01port=993\x01auth=Bearer valid\x01\x01"
+	if len(transcript) == 0 || string(transcript[0].Data) != want {
+		t.Errorf("Unexpected initial response: want=%q, got=%v", want, transcript)
+	}
+}
+
+func TestRejected(t *testing.T) {
+	client := sasl.NewClient(oauthsasl.Client(oauthsasl.TokenSourceFunc(func(bool) (string, error) {
+		return "forbidden", nil
+	})))
+	_, err := sasltest.Negotiate(client, serverFor(t, ""))
+	var e *oauthsasl.Error
+	if !errors.As(err, &e) || e.Status != "insufficient_scope" || e.Scope != "mail" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !errors.Is(err, sasl.ErrAuthn) {
+		t.Errorf("Expected error to unwrap to ErrAuthn")
+	}
+}
+
+func TestNegotiateRefresh(t *testing.T) {
+	var attempts int
+	err := oauthsasl.Negotiate(tokens, func(n *sasl.Negotiator) error {
+		attempts++
+		_, err := sasltest.Negotiate(n, serverFor(t, "user@example.com"))
+		return err
+	}, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user@example.com"), nil, nil
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Unexpected number of attempts: want=2, got=%d", attempts)
+	}
+}
+
+func TestNegotiateNoRetry(t *testing.T) {
+	var attempts int
+	err := oauthsasl.Negotiate(oauthsasl.TokenSourceFunc(func(bool) (string, error) {
+		return "forbidden", nil
+	}), func(n *sasl.Negotiator) error {
+		attempts++
+		// Simulate a protocol that reports the failure with its own error after
+		// the client acknowledges the error document.
+		if _, err := sasltest.Negotiate(n, serverFor(t, "")); err == nil {
+			t.Error("Expected authentication to fail")
+		}
+		return errors.New("NO authentication failed")
+	})
+	var e *oauthsasl.Error
+	if !errors.As(err, &e) || e.Status != "insufficient_scope" {
+		t.Errorf("Expected error to wrap the server's error document, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Unexpected number of attempts: want=1, got=%d", attempts)
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	server := sasl.NewServer(oauthsasl.Server(oauthsasl.ValidatorFunc(func(string) (string, error) {
+		return "", sasl.Temporary(errors.New("authorization server unavailable"))
+	})), nil)
+	client := sasl.NewClient(oauthsasl.Client(oauthsasl.TokenSourceFunc(func(bool) (string, error) {
+		return "valid", nil
+	})))
+	if _, err := sasltest.Negotiate(client, server); !sasl.IsTemporary(err) {
+		t.Errorf("Expected temporary error, got %v", err)
+	}
+}
+
+type ctxKey struct{}
+
+type contextValidator struct {
+	oauthsasl.ValidatorFunc
+	got chan interface{}
+}
+
+func (v contextValidator) ValidateContext(ctx context.Context, token string) (string, error) {
+	v.got <- ctx.Value(ctxKey{})
+	return v.Validate(token)
+}
+
+func TestValidateContext(t *testing.T) {
+	v := contextValidator{ValidatorFunc: validator, got: make(chan interface{}, 1)}
+	client := sasl.NewClient(oauthsasl.Client(oauthsasl.TokenSourceFunc(func(bool) (string, error) {
+		return "valid", nil
+	})))
+	server := sasl.NewServer(oauthsasl.Server(v), func(*sasl.Negotiator) bool { return true })
+	_, resp, err := client.Step(nil)
+	if err != nil {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "conn-1")
+	if _, _, err = server.StepContext(ctx, resp); err != nil {
+		t.Fatalf("Unexpected server error: %v", err)
+	}
+	if got := <-v.got; got != "conn-1" {
+		t.Errorf("Validator did not get the step context: %v", got)
+	}
+}
+
+func TestGenericFailure(t *testing.T) {
+	for _, token := range []string{"forbidden", "valid"} {
+		t.Run(token, func(t *testing.T) {
+			client := sasl.NewClient(oauthsasl.Client(oauthsasl.TokenSourceFunc(func(bool) (string, error) {
+				return token, nil
+			})))
+			// The "valid" token is accepted by the validator but the permissions
+			// callback denies it.
+			server := sasl.NewServer(oauthsasl.Server(validator), func(*sasl.Negotiator) bool {
+				return false
+			}, sasl.FailureReasons(sasl.FailureGeneric))
+			transcript, err := sasltest.Negotiate(client, server)
+			if err != sasl.ErrAuthn {
+				t.Fatalf("Expected ErrAuthn, got %v", err)
+			}
+			const want = `{"status":"invalid_token"}`
+			if len(transcript) < 2 || string(transcript[1].Data) != want {
+				t.Errorf("Expected generic error document %s, got %v", want, transcript)
+			}
+		})
+	}
+}
+
+func TestAbort(t *testing.T) {
+	server := serverFor(t, "")
+	const want = `{"status":"invalid_request"}`
+	if msg := server.Abort(); string(msg) != want {
+		t.Errorf("Wrong abort message: want=%s, got=%q", want, msg)
+	}
+
+	// Once the error has been sent there is nothing more to send.
+	server = serverFor(t, "")
+	if _, _, err := server.Step([]byte("n,,\x01auth=Bearer forbidden\x01\x01")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if msg := server.Abort(); msg != nil {
+		t.Errorf("Unexpected abort message after error: %q", msg)
+	}
+	if !server.State().Aborted() {
+		t.Errorf("Expected aborted state")
+	}
+}
+
+func TestInvalidAuthzID(t *testing.T) {
+	for _, authz := range []string{"=", "==", "n==="} {
+		server := serverFor(t, "")
+		_, _, err := server.Step([]byte("n,a=" + authz + ",\x01auth=Bearer valid\x01\x01"))
+		if !errors.Is(err, sasl.ErrInvalidChallenge) {
+			t.Errorf("Expected ErrInvalidChallenge for authzid %q, got %v", authz, err)
+		}
+	}
+}
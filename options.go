@@ -6,12 +6,31 @@ package sasl
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"hash"
+	"log/slog"
+	"time"
+
+	"github.com/jh125486/sasl/scramwire"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 // An Option represents an input to a SASL state machine.
 type Option func(*Negotiator)
 
+// DefaultMaxMessageSize is the maximum size of a challenge or response that
+// will be accepted by a Negotiator unless the MaxMessageSize option is used.
+const DefaultMaxMessageSize = 64 * 1024
+
 func getOpts(n *Negotiator, o ...Option) {
+	n.maxMessageSize = DefaultMaxMessageSize
+	n.maxBuffer = DefaultMaxBuffer
+	n.fips = fipsBuild
+	n.minIterations = DefaultMinIterations
+	n.maxIterations = DefaultMaxIterations
+	n.kdf = pbkdf2.Key
+	n.prepUsername = saslprep
+	n.prepPassword = saslprep
 	n.credentials = func() (username, password, identity []byte) {
 		return
 	}
@@ -25,12 +44,26 @@ func getOpts(n *Negotiator, o ...Option) {
 
 // TLSState lets the state machine negotiate channel binding with a TLS session
 // if supported by the underlying mechanism.
+// If the handshake has completed the transport is also considered confidential
+// by the Confidential option, so callers that always use Confidential(false)
+// only need to provide the TLS state when there is one.
 func TLSState(cs tls.ConnectionState) Option {
 	return func(n *Negotiator) {
 		n.tlsState = &cs
 	}
 }
 
+// ServerCertificate sets the certificate that a server presents on the TLS
+// connection given by the TLSState option, which lets it verify
+// tls-server-end-point channel binding (RFC 5929).
+// This is the channel binding type used by PostgreSQL clients, and the only one
+// available on TLS 1.3 connections, which have no tls-unique value.
+func ServerCertificate(cert *x509.Certificate) Option {
+	return func(n *Negotiator) {
+		n.serverCert = cert
+	}
+}
+
 // RemoteMechanisms sets a list of mechanisms supported by the remote client or
 // server with which the state machine will be negotiating.
 // It is used to determine if the server supports channel binding.
@@ -43,11 +76,339 @@ func RemoteMechanisms(m ...string) Option {
 // Credentials provides the negotiator with a username and password to
 // authenticate with and (optionally) an authorization identity.
 // Identity will normally be left empty to act as the username.
-// The Credentials function is called lazily and may be called multiple times by
-// the mechanism.
-// It is not memoized by the negotiator.
+// The Credentials function is called once at the start of each negotiation and
+// the result is used until the negotiator is reset, so long-lived clients can
+// pick up rotated passwords or freshly minted tokens by calling Reset before
+// the next attempt.
 func Credentials(f func() (Username, Password, Identity []byte)) Option {
 	return func(n *Negotiator) {
 		n.credentials = f
 	}
 }
+
+// MaxMessageSize limits the size of challenges (or responses, for servers) that
+// the state machine will accept.
+// If a larger message is passed to Step, it returns ErrMessageTooLarge.
+// A size of zero or less disables the limit.
+func MaxMessageSize(size int) Option {
+	return func(n *Negotiator) {
+		n.maxMessageSize = size
+	}
+}
+
+// WipeSecrets causes the negotiator to overwrite the password returned by the
+// Credentials function, and any keys derived from it, with zeros once the
+// negotiation completes or fails and when the negotiator is reset.
+// This reduces the window in which secrets remain in memory, but means that the
+// Credentials function must return a fresh copy of the password each time it is
+// called.
+func WipeSecrets() Option {
+	return func(n *Negotiator) {
+		n.wipeSecrets = true
+	}
+}
+
+// OnStateChange registers a function that is called every time the state of the
+// negotiator changes (including when it errors or is reset) with the name of the
+// mechanism and the old and new states.
+// The function is called synchronously from Step and Reset and should not call
+// back into the negotiator.
+func OnStateChange(f func(mechanism string, old, new State)) Option {
+	return func(n *Negotiator) {
+		n.onStateChange = f
+	}
+}
+
+// Logger causes the negotiator to log mechanism selection, state transitions,
+// and failures to l at the debug level.
+// Challenges, responses, and credentials are never logged.
+func Logger(l *slog.Logger) Option {
+	return func(n *Negotiator) {
+		n.logger = l
+	}
+}
+
+// AuthorizationIdentity sets the identity that a client wishes to act as
+// (sometimes called the authzid) when it differs from the username being
+// authenticated, overriding any identity returned by the Credentials function.
+// This is used, for example, when logging in as another user with
+// administrative credentials.
+// Any escaping required by the mechanism is performed automatically.
+func AuthorizationIdentity(identity []byte) Option {
+	return func(n *Negotiator) {
+		n.authzID = identity
+	}
+}
+
+// NoSASLprep disables the SASLprep (RFC 4013) normalization that SCRAM
+// mechanisms apply to usernames and passwords.
+// This should only be used for byte-exact compatibility with legacy systems
+// that do not normalize credentials.
+func NoSASLprep() Option {
+	return func(n *Negotiator) {
+		n.prepUsername = nil
+		n.prepPassword = nil
+	}
+}
+
+// PasswordHook sets a function that transforms the password after it has been
+// normalized and before it is used by the mechanism.
+// It is given the normalized username and password and returns the password to
+// use in their place.
+//
+// For example, MongoDB's SCRAM-SHA-1 expects the password to be the hex encoded
+// MD5 digest of "username:mongo:password" and does not apply SASLprep, so a
+// MongoDB client would use NoSASLprep along with a hook that returns that
+// digest.
+func PasswordHook(f func(username, password []byte) ([]byte, error)) Option {
+	return func(n *Negotiator) {
+		n.passwordHook = f
+	}
+}
+
+// Normalize replaces the SASLprep normalization with custom functions for
+// usernames and passwords, for example to apply the same folding rules that a
+// legacy user database applied when accounts were created.
+// A nil function leaves the corresponding credential unmodified.
+// The functions must not modify their argument.
+func Normalize(username, password func([]byte) ([]byte, error)) Option {
+	return func(n *Negotiator) {
+		n.prepUsername = username
+		n.prepPassword = password
+	}
+}
+
+// CanonicalizeUsername sets a function that servers apply to the
+// authentication identity sent by the client before looking up its
+// credentials, for example to case-fold it, strip a domain, or map an alias to
+// the account name.
+// The canonical username is the one passed to the permissions callback and
+// reported by Identity.
+// If f returns an error the negotiation fails with that error.
+func CanonicalizeUsername(f func(username []byte) ([]byte, error)) Option {
+	return func(n *Negotiator) {
+		n.canonicalize = f
+	}
+}
+
+// PlainSASLprep causes the PLAIN mechanism to apply SASLprep (RFC 4013), or
+// whatever other normalization has been configured, to the username and
+// password, as RFC 4616 recommends.
+// It is disabled by default for compatibility with servers that do not
+// normalize credentials.
+func PlainSASLprep() Option {
+	return func(n *Negotiator) {
+		n.prepPlain = true
+	}
+}
+
+// StrictSCRAM causes SCRAM clients to reject server messages that do not
+// exactly match the grammar in RFC 5802, including messages with duplicate or
+// out of order attributes and non-printable characters.
+// By default unknown or malformed attributes are ignored.
+func StrictSCRAM() Option {
+	return func(n *Negotiator) {
+		n.strictScram = true
+	}
+}
+
+// PostgreSQL makes SCRAM clients behave like the PostgreSQL client library so
+// that they can authenticate to PostgreSQL servers:
+//
+//   - the username is left empty because the server uses the one from the
+//     startup message,
+//   - the -PLUS variants use tls-server-end-point channel binding (RFC 5929)
+//     computed from the server certificate in the TLS state,
+//   - a client with TLS that selected a mechanism without channel binding sends
+//     the "y" flag to signal that it supports channel binding but the server
+//     did not advertise it (unless AdvertiseChannelBinding(false) is used),
+//   - authorization identities are never sent, and
+//   - passwords that cannot be prepared with SASLprep (or the normalization set
+//     by options such as PRECIS or Normalize) are used as-is.
+//
+// PostgreSQL frames SCRAM messages without base64 encoding, so the messages
+// from the negotiator may be sent in its SASLInitialResponse and SASLResponse
+// messages unmodified.
+func PostgreSQL() Option {
+	return func(n *Negotiator) {
+		n.postgres = true
+	}
+}
+
+// MinIterations sets the smallest iteration count that a SCRAM client will
+// accept from the server.
+// A malicious server (or an attacker that can modify its messages) could
+// otherwise ask the client to derive a weak salted password.
+func MinIterations(iter int) Option {
+	return func(n *Negotiator) {
+		n.minIterations = iter
+	}
+}
+
+// MaxIterations sets the largest iteration count that a SCRAM client will
+// accept from the server.
+// Without a limit a malicious server could pin the client's CPU by requesting
+// billions of iterations.
+// A limit of zero or less disables the check.
+func MaxIterations(iter int) Option {
+	return func(n *Negotiator) {
+		n.maxIterations = iter
+	}
+}
+
+// KDF replaces the PBKDF2 implementation used by SCRAM clients to calculate the
+// salted password (the Hi() function in RFC 5802).
+// It has the same signature as pbkdf2.Key and must return the same result for
+// the negotiation to succeed against a standard server, but may be used to
+// dispatch the calculation to a hardware security module or an accelerated
+// implementation, such as the SubtleCrypto backed one in the webcrypto package
+// for clients compiled for js/wasm.
+func KDF(f func(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte) Option {
+	return func(n *Negotiator) {
+		n.kdf = f
+	}
+}
+
+// RequireMutualAuth causes client negotiations to fail with ErrMutualAuth
+// unless the mechanism authenticated the server (for example, by verifying the
+// SCRAM server signature) and reported it with SetServerVerified.
+// If the mechanism finishes without authenticating the server its final
+// response is discarded, so a downgrade to a mechanism such as PLAIN never
+// sends credentials.
+func RequireMutualAuth() Option {
+	return func(n *Negotiator) {
+		n.requireMutual = true
+	}
+}
+
+// InitialResponse controls whether clients send the initial response of a
+// client-first mechanism along with the authentication request (for example,
+// when the protocol supports SASL-IR) or wait for an empty challenge from the
+// server.
+// If send is false, the first call to Step returns a nil response and the
+// initial response is returned by the second call, which must be passed an
+// empty challenge.
+// The default is to send the initial response.
+func InitialResponse(send bool) Option {
+	return func(n *Negotiator) {
+		n.noInitialResp = !send
+	}
+}
+
+// AdvertiseChannelBinding controls the channel binding flag sent by SCRAM
+// clients that support channel binding (because the TLSState option was used)
+// but selected a mechanism without it.
+// If advertise is true the "y" flag is sent, which lets a server that supports
+// channel binding detect that the -PLUS mechanisms were stripped from the list
+// that it advertised and fail the exchange.
+// If it is false the "n" flag is sent, which is compatible with servers that
+// wrongly reject "y" even though they do not support channel binding.
+// The default is false unless the PostgreSQL option is used.
+func AdvertiseChannelBinding(advertise bool) Option {
+	return func(n *Negotiator) {
+		n.cbFlagSet = true
+		n.cbAdvertise = advertise
+	}
+}
+
+// ScramExtensions adds extension attributes to the SCRAM messages sent by the
+// negotiator.
+// Clients add first to the client-first message and final to the client-final
+// message, servers add them to the server-first and server-final messages.
+// Extension names must not be used by SCRAM itself and values must not contain
+// commas.
+// Extensions sent by the remote side are available from
+// RemoteScramExtensions.
+func ScramExtensions(first, final []scramwire.Attribute) Option {
+	return func(n *Negotiator) {
+		n.scramExtFirst = first
+		n.scramExtFinal = final
+	}
+}
+
+// Confidential declares whether the transport provides confidentiality (for
+// example, because it uses TLS).
+// If it does not, mechanisms that require a confidential transport such as
+// PLAIN fail with an InsecureTransportError on the first call to Step, as
+// required by RFC 4616 §6.
+// A completed TLS handshake provided with the TLSState option always counts as
+// confidential.
+// Without this option no policy is applied.
+func Confidential(ok bool) Option {
+	return func(n *Negotiator) {
+		n.insecure = !ok
+	}
+}
+
+// InsecureTransportError is returned when a mechanism that requires a
+// confidential transport is used on a transport that was declared as not
+// confidential with the Confidential option.
+type InsecureTransportError struct {
+	Mechanism string
+}
+
+func (e InsecureTransportError) Error() string {
+	return "Mechanism " + e.Mechanism + " requires a confidential transport"
+}
+
+// Code returns CodeInsecureTransport.
+func (InsecureTransportError) Code() Code {
+	return CodeInsecureTransport
+}
+
+// StepTimeout limits the wall-clock time that each call to Step may take,
+// including any credential callbacks, key derivation, or calls to a credential
+// store made by the mechanism.
+// If a step takes longer Step returns ErrStepTimeout.
+// The mechanism keeps running in the background until it returns, so the
+// negotiator must be reset (which waits for it) before it can be reused.
+func StepTimeout(d time.Duration) Option {
+	return func(n *Negotiator) {
+		n.stepTimeout = d
+	}
+}
+
+// NegotiationTimeout limits the time that a negotiation may take from its first
+// step until it completes.
+// The deadline is added to the context of every step (see StepContext) so that
+// credential stores and other callbacks that respect the context give up when
+// it expires, and steps started after the deadline fail with
+// context.DeadlineExceeded.
+// A duration of zero or less disables the limit.
+func NegotiationTimeout(d time.Duration) Option {
+	return func(n *Negotiator) {
+		n.deadlineAfter = d
+	}
+}
+
+// LockedMemory causes the negotiator to copy the password returned by the
+// Credentials function, and the keys that SCRAM clients derive from it, into
+// memory that is locked into RAM so that it is never written to swap and that
+// is not managed by the garbage collector.
+// The memory is zeroed when the negotiator is reset and released once the
+// negotiator is garbage collected, so slices returned by Credentials must not be
+// used after that.
+// If the memory cannot be allocated or locked (for example, because the
+// RLIMIT_MEMLOCK limit has been reached or the platform does not support it)
+// Step fails with ErrLockedMemory.
+// Locked memory is only supported on Linux and macOS.
+//
+// The original password is zeroed after it is copied if the WipeSecrets option
+// is also used.
+// Normalization and PasswordHook may still make short lived copies of the
+// password on the heap.
+func LockedMemory() Option {
+	return func(n *Negotiator) {
+		n.lockedMemory = true
+	}
+}
+
+// NonceSource sets the function used to generate the nonce for each
+// negotiation attempt.
+// It exists so that mechanisms can be tested against fixed test vectors and
+// should never be used outside of tests.
+func NonceSource(f func() []byte) Option {
+	return func(n *Negotiator) {
+		n.nonceSource = f
+	}
+}
@@ -0,0 +1,17 @@
+module github.com/jh125486/sasl/otelsasl
+
+go 1.25.0
+
+replace github.com/jh125486/sasl => ../
+
+require (
+	github.com/jh125486/sasl v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.46.0
+	go.opentelemetry.io/otel/trace v1.46.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	golang.org/x/crypto v0.0.0-20190320223903-b7391e95e576 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
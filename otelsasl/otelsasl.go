@@ -0,0 +1,151 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package otelsasl records SASL negotiations as OpenTelemetry spans.
+//
+// It is a separate module so that users of the sasl package who do not need
+// tracing are not forced to depend on OpenTelemetry.
+package otelsasl // import "github.com/jh125486/sasl/otelsasl"
+
+import (
+	"context"
+
+	"github.com/jh125486/sasl"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys set on spans and events.
+const (
+	MechanismKey      = attribute.Key("sasl.mechanism")
+	ChannelBindingKey = attribute.Key("sasl.channel_binding")
+	NegotiationIDKey  = attribute.Key("sasl.negotiation_id")
+	OutcomeKey        = attribute.Key("sasl.outcome")
+	StepKey           = attribute.Key("sasl.step")
+	MoreKey           = attribute.Key("sasl.more")
+)
+
+// A Negotiator wraps a sasl.Negotiator and emits a span for each negotiation.
+// The span is started by the first step and ended when the negotiation
+// completes, fails, is aborted, or is reset.
+// The channel binding attribute is set when the span ends, once the type that
+// was negotiated is known.
+type Negotiator struct {
+	*sasl.Negotiator
+
+	ctx    context.Context
+	tracer trace.Tracer
+	span   trace.Span
+	step   int
+}
+
+// Wrap returns a Negotiator that records negotiations performed by n using
+// tracer.
+// Spans are created as children of any span in ctx.
+func Wrap(ctx context.Context, tracer trace.Tracer, n *sasl.Negotiator) *Negotiator {
+	return &Negotiator{
+		Negotiator: n,
+		ctx:        ctx,
+		tracer:     tracer,
+	}
+}
+
+// Step calls the underlying negotiators Step method and records an event on the
+// current span.
+func (n *Negotiator) Step(challenge []byte) (more bool, resp []byte, err error) {
+	return n.StepContext(context.Background(), challenge)
+}
+
+// StepContext is like Step except that ctx is passed to the underlying
+// negotiators StepContext method.
+func (n *Negotiator) StepContext(ctx context.Context, challenge []byte) (more bool, resp []byte, err error) {
+	if n.span == nil {
+		n.start()
+	}
+	n.step++
+	more, resp, err = n.Negotiator.StepContext(ctx, challenge)
+	n.span.AddEvent("step", trace.WithAttributes(
+		StepKey.Int(n.step),
+		MoreKey.Bool(more),
+	))
+	n.finish(more, err)
+	return more, resp, err
+}
+
+// Finish calls the underlying negotiators Finish method and ends the span of
+// the negotiation.
+func (n *Negotiator) Finish(data []byte) error {
+	err := n.Negotiator.Finish(data)
+	if n.span != nil {
+		n.span.AddEvent("finish")
+		n.finish(false, err)
+	}
+	return err
+}
+
+// Abort ends any in progress span and aborts the underlying negotiator.
+func (n *Negotiator) Abort() []byte {
+	msg := n.Negotiator.Abort()
+	if n.span != nil {
+		n.end("aborted")
+	}
+	return msg
+}
+
+// Reset ends any in progress span and resets the underlying negotiator.
+func (n *Negotiator) Reset() {
+	if n.span != nil {
+		n.end("aborted")
+	}
+	n.Negotiator.Reset()
+}
+
+// Span returns the span for the negotiation in progress, or nil if no
+// negotiation is in progress.
+func (n *Negotiator) Span() trace.Span {
+	return n.span
+}
+
+func (n *Negotiator) start() {
+	name := n.Mechanism().Name
+	kind := trace.SpanKindClient
+	if n.State().IsServer() {
+		kind = trace.SpanKindServer
+	}
+	_, n.span = n.tracer.Start(n.ctx, "sasl "+name,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(
+			MechanismKey.String(name),
+			NegotiationIDKey.String(n.NegotiationID()),
+		),
+	)
+	n.step = 0
+}
+
+// finish ends the span if the negotiation failed or completed.
+func (n *Negotiator) finish(more bool, err error) {
+	switch {
+	case err != nil:
+		n.span.RecordError(err)
+		n.span.SetStatus(codes.Error, err.Error())
+		n.end("failure")
+	case !more:
+		n.span.SetStatus(codes.Ok, "")
+		n.end("success")
+	}
+}
+
+func (n *Negotiator) end(outcome string) {
+	cbType, _ := n.ChannelBinding()
+	if cbType == "" {
+		cbType = "none"
+	}
+	n.span.SetAttributes(
+		OutcomeKey.String(outcome),
+		ChannelBindingKey.String(cbType),
+	)
+	n.span.End()
+	n.span = nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package otelsasl_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/otelsasl"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type recordingSpan struct {
+	noop.Span
+	attrs  []attribute.KeyValue
+	events []string
+	status codes.Code
+	ended  bool
+}
+
+func (s *recordingSpan) AddEvent(name string, _ ...trace.EventOption) {
+	s.events = append(s.events, name)
+}
+func (s *recordingSpan) SetAttributes(kv ...attribute.KeyValue) { s.attrs = append(s.attrs, kv...) }
+func (s *recordingSpan) SetStatus(code codes.Code, _ string)    { s.status = code }
+func (s *recordingSpan) End(...trace.SpanEndOption)             { s.ended = true }
+
+type recordingTracer struct {
+	noop.Tracer
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, _ string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	s := &recordingSpan{attrs: cfg.Attributes()}
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func attr(s *recordingSpan, key attribute.Key) string {
+	for _, kv := range s.attrs {
+		if kv.Key == key {
+			return kv.Value.AsString()
+		}
+	}
+	return ""
+}
+
+func TestChannelBindingFinish(t *testing.T) {
+	tlsState := sasl.TLSState(tls.ConnectionState{TLSUnique: []byte("finishedmessage")})
+	creds := sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	})
+	tracer := &recordingTracer{}
+	client := otelsasl.Wrap(context.Background(), tracer, sasl.NewClient(sasl.ScramSha256Plus, tlsState, creds, sasl.RemoteMechanisms("SCRAM-SHA-256-PLUS")))
+	server := sasl.NewServer(sasl.ScramSha256Plus, func(*sasl.Negotiator) bool { return true }, tlsState, sasl.Store(store{
+		"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096),
+	}))
+
+	_, resp, err := client.StepContext(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+	_, challenge, err := server.Step(resp)
+	if err != nil {
+		t.Fatalf("Unexpected server error: %v", err)
+	}
+	if _, resp, err = client.StepContext(context.Background(), challenge); err != nil {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+	if _, challenge, err = server.Step(resp); err != nil {
+		t.Fatalf("Unexpected server error: %v", err)
+	}
+	if client.Span() == nil {
+		t.Fatal("Expected span to be in progress before Finish")
+	}
+	if err = client.Finish(challenge); err != nil {
+		t.Fatalf("Unexpected error finishing: %v", err)
+	}
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(tracer.spans))
+	}
+	s := tracer.spans[0]
+	if !s.ended || client.Span() != nil {
+		t.Error("Expected Finish to end the span")
+	}
+	if got := attr(s, otelsasl.OutcomeKey); got != "success" {
+		t.Errorf("Wrong outcome: want=success, got=%q", got)
+	}
+	if got := attr(s, otelsasl.ChannelBindingKey); got != sasl.ChannelBindingTLSUnique {
+		t.Errorf("Wrong channel binding: want=%q, got=%q", sasl.ChannelBindingTLSUnique, got)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	tracer := &recordingTracer{}
+	n := otelsasl.Wrap(context.Background(), tracer, sasl.NewClient(sasl.ScramSha256, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	})))
+	if _, _, err := n.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	n.Abort()
+	if len(tracer.spans) != 1 || !tracer.spans[0].ended {
+		t.Fatal("Expected Abort to end the span")
+	}
+	if got := attr(tracer.spans[0], otelsasl.OutcomeKey); got != "aborted" {
+		t.Errorf("Wrong outcome: want=aborted, got=%q", got)
+	}
+}
+
+func TestSpanPerNegotiation(t *testing.T) {
+	tracer := &recordingTracer{}
+	n := otelsasl.Wrap(context.Background(), tracer, sasl.NewClient(sasl.Plain, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	})))
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := n.Step(nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		n.Reset()
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(tracer.spans))
+	}
+	ids := make(map[string]bool)
+	for _, s := range tracer.spans {
+		if !s.ended {
+			t.Error("Expected span to be ended")
+		}
+		if s.status != codes.Ok {
+			t.Errorf("Wrong span status: want=%v, got=%v", codes.Ok, s.status)
+		}
+		if len(s.events) != 1 {
+			t.Errorf("Expected 1 event, got %d", len(s.events))
+		}
+		want := map[attribute.Key]string{
+			otelsasl.MechanismKey:      "PLAIN",
+			otelsasl.ChannelBindingKey: "none",
+			otelsasl.OutcomeKey:        "success",
+		}
+		for _, kv := range s.attrs {
+			if kv.Key == otelsasl.NegotiationIDKey {
+				ids[kv.Value.AsString()] = true
+			}
+			if v, ok := want[kv.Key]; ok {
+				if kv.Value.AsString() != v {
+					t.Errorf("Wrong value for %s: want=%q, got=%q", kv.Key, v, kv.Value.AsString())
+				}
+				delete(want, kv.Key)
+			}
+		}
+		if len(want) != 0 {
+			t.Errorf("Missing attributes: %v", want)
+		}
+	}
+	delete(ids, "")
+	if len(ids) != 2 {
+		t.Errorf("Expected each span to have a different negotiation ID, got %v", ids)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package passdb
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jh125486/sasl"
+)
+
+// File is a sasl.CredentialStore backed by a passwd-file (see ReadFile) that
+// can be reloaded while it is in use, for small deployments that do not have a
+// user database.
+// Lines in the format written by htpasswd with bcrypt ("user:$2y$...") and by
+// the scramcred command are both supported.
+//
+// Each reload replaces all users at once, so a negotiation never sees a
+// partially written file, and if the new file can not be read or parsed the
+// previous users are kept.
+// A File is safe for concurrent use.
+type File struct {
+	path string
+
+	mu      sync.RWMutex
+	store   Store
+	modTime time.Time
+	size    int64
+}
+
+// OpenFile reads the passwd-file at path.
+func OpenFile(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the file again and replaces the users if it was read
+// successfully.
+func (f *File) Reload() error {
+	fd, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	store, err := ReadFile(fd)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.store = store
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
+
+// Watch checks the file for changes every interval and reloads it when its
+// modification time or size changes until ctx is canceled.
+// Errors from reloading the file are passed to onError (if it is not nil) and
+// the file is tried again after the next change.
+// Watch blocks, so it is normally run in its own goroutine.
+func (f *File) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(f.path)
+		if err == nil {
+			f.mu.RLock()
+			changed := !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+			f.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err = f.Reload(); err != nil {
+				// Don't report the same broken file on every tick.
+				f.mu.Lock()
+				f.modTime, f.size = info.ModTime(), info.Size()
+				f.mu.Unlock()
+			}
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// Store returns the users from the most recent successful load.
+// The returned Store must not be modified.
+func (f *File) Store() Store {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.store
+}
+
+// ScramCredentials implements sasl.CredentialStore.
+func (f *File) ScramCredentials(mechanism string, username []byte) (sasl.StoredCredentials, error) {
+	return f.Store().ScramCredentials(mechanism, username)
+}
+
+// VerifyPassword reports whether password is the password of username.
+func (f *File) VerifyPassword(username, password []byte) bool {
+	return f.Store().VerifyPassword(username, password)
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package passdb_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/jh125486/sasl/passdb"
+)
+
+func TestFileWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	hash, err := bcrypt.GenerateFromPassword([]byte("pencil"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error generating bcrypt hash: %v", err)
+	}
+	write := func(contents string) {
+		t.Helper()
+		// Write the file atomically like most editors and provisioning tools.
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(contents), 0600); err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatalf("Error renaming file: %v", err)
+		}
+	}
+	write("juliet:" + string(hash) + "\n")
+
+	f, err := passdb.OpenFile(path)
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	if !f.VerifyPassword([]byte("juliet"), []byte("pencil")) {
+		t.Fatalf("Expected juliet's password to be verified")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 10)
+	go f.Watch(ctx, time.Millisecond, func(err error) {
+		errs <- err
+	})
+
+	waitFor := func(cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for the file to be reloaded")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	write("juliet:{PLAIN}pencil\nromeo:" + string(hash) + "\n")
+	waitFor(func() bool { return f.VerifyPassword([]byte("romeo"), []byte("pencil")) })
+	if len(f.Store()) != 2 {
+		t.Errorf("Expected 2 users after reload, got %d", len(f.Store()))
+	}
+
+	write("juliet:{MD5}abc\n")
+	select {
+	case err := <-errs:
+		if !errors.Is(err, passdb.ErrScheme) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for reload error")
+	}
+	if !f.VerifyPassword([]byte("romeo"), []byte("pencil")) {
+		t.Errorf("Expected previous users to be kept after a failed reload")
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package passdb reads the password formats used by Dovecot and Cyrus SASL so
+// that existing mail user databases can be used with the sasl package without
+// resetting every password.
+//
+// Passwords in the SCRAM schemes provide sasl.StoredCredentials and can be
+// used with the SCRAM mechanisms, passwords in any of the other supported
+// schemes can only be checked against a password sent using PLAIN.
+// The supported formats are:
+//
+//	{SCRAM-SHA-1}, {SCRAM-SHA-256}, {SCRAM-SHA-512}
+//	    iterations,salt,StoredKey,ServerKey in Dovecot's format (the fields may
+//	    also be separated by "$")
+//	SCRAM-SHA-256$iterations:salt$StoredKey:ServerKey
+//	    the RFC 5803 format used by Cyrus SASL and PostgreSQL
+//	{PLAIN}, {CLEAR}, {CLEARTEXT}
+//	{SHA}, {SHA1}, {SHA256}, {SHA512}, {SSHA}, {SSHA256}, {SSHA512}
+//	    optionally with a ".HEX" or ".B64" suffix
+//	{CRYPT}, {SHA256-CRYPT}, {SHA512-CRYPT}, {BLF-CRYPT}
+//	    with the $5$ (SHA-256), $6$ (SHA-512), and $2a$, $2b$, or $2y$ (bcrypt)
+//	    methods of crypt(3)
+//
+// A password without a scheme is treated as {CRYPT} (Dovecot's default) if it
+// starts with "$".
+//
+// Users can be read from a passwd-file with ReadFile, or with OpenFile to
+// reload the file when it changes.
+package passdb // import "github.com/jh125486/sasl/passdb"
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/jh125486/sasl"
+)
+
+// Errors returned when parsing passwords.
+var (
+	ErrMalformed = errors.New("passdb: malformed password")
+	ErrScheme    = errors.New("passdb: unsupported password scheme")
+)
+
+// Password is a password parsed from one of the supported formats.
+type Password struct {
+	// Scheme is the upper case name of the password scheme without braces, for
+	// example "SCRAM-SHA-256" or "SHA512-CRYPT".
+	Scheme string
+
+	// Scram holds the stored credentials if Scheme is one of the SCRAM schemes.
+	Scram sasl.StoredCredentials
+
+	// data is the decoded hash or the crypt string for the other schemes.
+	data []byte
+}
+
+// String implements fmt.Stringer without revealing the password.
+func (p Password) String() string {
+	return "{" + p.Scheme + "}[REDACTED]"
+}
+
+// Parse parses a password in one of the supported formats.
+func Parse(s string) (Password, error) {
+	scheme, data, ok := cutScheme(s)
+	if !ok {
+		switch {
+		case strings.HasPrefix(strings.ToUpper(s), "SCRAM-"):
+			return parseRFC5803(s)
+		case strings.HasPrefix(s, "$"):
+			scheme, data = "CRYPT", s
+		default:
+			return Password{}, ErrScheme
+		}
+	}
+
+	p := Password{Scheme: scheme}
+	if _, ok := sasl.ScramHash(scheme); ok && !sasl.IsPlus(scheme) {
+		// Dovecot separates the fields with commas, but "$" is also used by some
+		// tools.
+		fields := strings.FieldsFunc(data, func(r rune) bool { return r == ',' || r == '$' })
+		if len(fields) != 4 {
+			return Password{}, ErrMalformed
+		}
+		var err error
+		p.Scram, err = parseScram(fields[0], fields[1], fields[2], fields[3])
+		return p, err
+	}
+
+	base, enc, _ := strings.Cut(scheme, ".")
+	switch base {
+	case "PLAIN", "CLEAR", "CLEARTEXT":
+		if enc != "" {
+			return Password{}, ErrScheme
+		}
+		p.data = []byte(data)
+	case "SHA", "SHA1", "SHA256", "SHA512", "SSHA", "SSHA256", "SSHA512":
+		var err error
+		switch enc {
+		case "", "B64":
+			p.data, err = base64.StdEncoding.DecodeString(data)
+		case "HEX":
+			p.data, err = hex.DecodeString(data)
+		default:
+			return Password{}, ErrScheme
+		}
+		size := digestHash(base)().Size()
+		salted := strings.HasPrefix(base, "SS")
+		// Salted hashes are followed by a non-empty salt.
+		if err != nil || (salted && len(p.data) <= size) || (!salted && len(p.data) != size) {
+			return Password{}, ErrMalformed
+		}
+	case "CRYPT", "SHA256-CRYPT", "SHA512-CRYPT", "BLF-CRYPT":
+		if enc != "" {
+			return Password{}, ErrScheme
+		}
+		if !supportedCrypt(data) {
+			return Password{}, ErrScheme
+		}
+		p.data = []byte(data)
+	default:
+		return Password{}, ErrScheme
+	}
+	return p, nil
+}
+
+// Verify reports whether password matches p.
+// Passwords in the SCRAM schemes are checked by deriving the stored
+// credentials from password, so the password should already have been
+// normalized with sasl.SASLprep.
+func (p Password) Verify(password []byte) bool {
+	if h, ok := sasl.ScramHash(p.Scheme); ok {
+		creds := sasl.DeriveStoredCredentials(h, password, p.Scram.Salt, p.Scram.Iterations)
+		return subtle.ConstantTimeCompare(creds.StoredKey, p.Scram.StoredKey)&subtle.ConstantTimeCompare(creds.ServerKey, p.Scram.ServerKey) == 1
+	}
+
+	base, _, _ := strings.Cut(p.Scheme, ".")
+	switch base {
+	case "PLAIN", "CLEAR", "CLEARTEXT":
+		return subtle.ConstantTimeCompare(password, p.data) == 1
+	case "SHA", "SHA1", "SHA256", "SHA512", "SSHA", "SSHA256", "SSHA512":
+		h := digestHash(base)()
+		sum, salt := p.data[:h.Size()], p.data[h.Size():]
+		h.Write(password)
+		h.Write(salt)
+		return subtle.ConstantTimeCompare(h.Sum(nil), sum) == 1
+	case "CRYPT", "SHA256-CRYPT", "SHA512-CRYPT", "BLF-CRYPT":
+		return verifyCrypt(password, string(p.data))
+	}
+	return false
+}
+
+// cutScheme splits a password in the {SCHEME}data format.
+func cutScheme(s string) (scheme, data string, ok bool) {
+	if !strings.HasPrefix(s, "{") {
+		return "", "", false
+	}
+	scheme, data, ok = strings.Cut(s[1:], "}")
+	if !ok || scheme == "" {
+		return "", "", false
+	}
+	return strings.ToUpper(scheme), data, true
+}
+
+// parseRFC5803 parses a password in the format defined by RFC 5803:
+// mechanism$iterations:salt$StoredKey:ServerKey.
+func parseRFC5803(s string) (Password, error) {
+	scheme, rest, _ := strings.Cut(s, "$")
+	scheme = strings.ToUpper(scheme)
+	if _, ok := sasl.ScramHash(scheme); !ok || sasl.IsPlus(scheme) {
+		return Password{}, ErrScheme
+	}
+	params, keys, ok := strings.Cut(rest, "$")
+	if !ok {
+		return Password{}, ErrMalformed
+	}
+	iter, salt, ok := strings.Cut(params, ":")
+	if !ok {
+		return Password{}, ErrMalformed
+	}
+	storedKey, serverKey, ok := strings.Cut(keys, ":")
+	if !ok {
+		return Password{}, ErrMalformed
+	}
+	creds, err := parseScram(iter, salt, storedKey, serverKey)
+	return Password{Scheme: scheme, Scram: creds}, err
+}
+
+func parseScram(iter, salt, storedKey, serverKey string) (creds sasl.StoredCredentials, err error) {
+	creds.Iterations, err = strconv.Atoi(iter)
+	if err != nil || creds.Iterations < 1 {
+		return creds, ErrMalformed
+	}
+	for _, f := range []struct {
+		dst *[]byte
+		src string
+	}{
+		{&creds.Salt, salt},
+		{&creds.StoredKey, storedKey},
+		{&creds.ServerKey, serverKey},
+	} {
+		*f.dst, err = base64.StdEncoding.DecodeString(f.src)
+		if err != nil || len(*f.dst) == 0 {
+			return sasl.StoredCredentials{}, ErrMalformed
+		}
+	}
+	if len(creds.StoredKey) != len(creds.ServerKey) {
+		return sasl.StoredCredentials{}, ErrMalformed
+	}
+	return creds, nil
+}
+
+func digestHash(scheme string) func() hash.Hash {
+	switch scheme {
+	case "SHA256", "SSHA256":
+		return sha256.New
+	case "SHA512", "SSHA512":
+		return sha512.New
+	}
+	return sha1.New
+}
+
+func supportedCrypt(s string) bool {
+	switch {
+	case strings.HasPrefix(s, "$5$"), strings.HasPrefix(s, "$6$"):
+		_, _, _, ok := parseShaCrypt(s)
+		return ok
+	case strings.HasPrefix(s, "$2a$"), strings.HasPrefix(s, "$2b$"), strings.HasPrefix(s, "$2y$"):
+		_, err := bcrypt.Cost([]byte(s))
+		return err == nil
+	}
+	return false
+}
+
+func verifyCrypt(password []byte, s string) bool {
+	if strings.HasPrefix(s, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(s), password) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(shaCrypt(password, s)), []byte(s)) == 1
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package passdb_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/passdb"
+	"github.com/jh125486/sasl/sasltest"
+)
+
+func ssha256(password, salt string) []byte {
+	sum := sha256.Sum256([]byte(password + salt))
+	return append(sum[:], salt...)
+}
+
+func TestParseVerify(t *testing.T) {
+	creds := sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)
+	b64 := base64.StdEncoding.EncodeToString
+	sum := sha256.Sum256([]byte("pencil"))
+	blf, err := bcrypt.GenerateFromPassword([]byte("pencil"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error generating bcrypt hash: %v", err)
+	}
+
+	for _, tc := range []struct {
+		password string
+		scheme   string
+		err      error
+		valid    string
+	}{
+		{password: "{SCRAM-SHA-256}4096," + b64(creds.Salt) + "," + b64(creds.StoredKey) + "," + b64(creds.ServerKey), scheme: "SCRAM-SHA-256", valid: "pencil"},
+		{password: "{scram-sha-256}4096$" + b64(creds.Salt) + "$" + b64(creds.StoredKey) + "$" + b64(creds.ServerKey), scheme: "SCRAM-SHA-256", valid: "pencil"},
+		{password: "SCRAM-SHA-256$4096:" + b64(creds.Salt) + "$" + b64(creds.StoredKey) + ":" + b64(creds.ServerKey), scheme: "SCRAM-SHA-256", valid: "pencil"},
+		{password: "{SCRAM-SHA-256}4096," + b64(creds.Salt) + "," + b64(creds.StoredKey), err: passdb.ErrMalformed},
+		{password: "{SCRAM-SHA-256}x," + b64(creds.Salt) + "," + b64(creds.StoredKey) + "," + b64(creds.ServerKey), err: passdb.ErrMalformed},
+		{password: "SCRAM-SHA-256$4096:" + b64(creds.Salt), err: passdb.ErrMalformed},
+		{password: "{SCRAM-SHA-256-PLUS}4096,c2FsdA==,AA==,AA==", err: passdb.ErrScheme},
+		{password: "{PLAIN}pencil", scheme: "PLAIN", valid: "pencil"},
+		{password: "{SHA256}" + b64(sum[:]), scheme: "SHA256", valid: "pencil"},
+		{password: "{SHA256.HEX}" + hex.EncodeToString(sum[:]), scheme: "SHA256.HEX", valid: "pencil"},
+		{password: "{SHA256}" + b64(sum[:10]), err: passdb.ErrMalformed},
+		{password: "{SSHA256}" + b64(ssha256("pencil", "salt")), scheme: "SSHA256", valid: "pencil"},
+		{password: "{SSHA256}" + b64(sum[:]), err: passdb.ErrMalformed},
+		{password: "{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", scheme: "SHA512-CRYPT", valid: "Hello world!"},
+		{password: "{SHA256-CRYPT}$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", scheme: "SHA256-CRYPT", valid: "Hello world!"},
+		{password: "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", scheme: "CRYPT", valid: "Hello world!"},
+		{password: "{BLF-CRYPT}" + string(blf), scheme: "BLF-CRYPT", valid: "pencil"},
+		{password: "{CRYPT}$1$salt$hash", err: passdb.ErrScheme},
+		{password: "{MD5}abc", err: passdb.ErrScheme},
+		{password: "pencil", err: passdb.ErrScheme},
+	} {
+		t.Run(tc.password, func(t *testing.T) {
+			p, err := passdb.Parse(tc.password)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if p.Scheme != tc.scheme {
+				t.Errorf("Unexpected scheme: want=%q, got=%q", tc.scheme, p.Scheme)
+			}
+			if !p.Verify([]byte(tc.valid)) {
+				t.Errorf("Expected %q to be valid", tc.valid)
+			}
+			if p.Verify([]byte("wrong")) {
+				t.Errorf("Expected wrong password to be invalid")
+			}
+			if s := p.String(); strings.Contains(s, "pencil") || strings.Contains(s, "$") {
+				t.Errorf("String revealed the password: %q", s)
+			}
+		})
+	}
+}
+
+const passwdFile = `# Users migrated from the mail server.
+juliet:{SCRAM-SHA-256}4096,c2FsdA==,u+iLs9qG4xpz8n4iBFJlze/fVOjP2jhqQIXx/NAiFSQ=,elQh4wT48epeZpwXBxlihIdim+BEkjp3sUAFd/ICyyI=:1000:1000::/home/juliet
+
+romeo:SCRAM-SHA-256$4096:c2FsdA==$u+iLs9qG4xpz8n4iBFJlze/fVOjP2jhqQIXx/NAiFSQ=:elQh4wT48epeZpwXBxlihIdim+BEkjp3sUAFd/ICyyI=:1001:1001
+nurse:{PLAIN}pencil
+`
+
+func TestStore(t *testing.T) {
+	store, err := passdb.ReadFile(strings.NewReader(passwdFile))
+	if err != nil {
+		t.Fatalf("Error reading passwd-file: %v", err)
+	}
+	if len(store) != 3 {
+		t.Fatalf("Expected 3 users, got %d", len(store))
+	}
+
+	for _, tc := range []struct {
+		user string
+		err  bool
+	}{
+		{user: "juliet"},
+		{user: "romeo"},
+		{user: "nurse", err: true},
+		{user: "tybalt", err: true},
+	} {
+		t.Run(tc.user, func(t *testing.T) {
+			client := sasl.NewClient(sasl.ScramSha256, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte(tc.user), []byte("pencil"), nil
+			}))
+			server := sasl.NewServer(sasl.ScramSha256, func(*sasl.Negotiator) bool { return true }, sasl.Store(store))
+			_, err := sasltest.Negotiate(client, server)
+			if (err != nil) != tc.err {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+
+	if !store.VerifyPassword([]byte("nurse"), []byte("pencil")) {
+		t.Errorf("Expected PLAIN password to be verified")
+	}
+	if store.VerifyPassword([]byte("tybalt"), []byte("pencil")) {
+		t.Errorf("Expected unknown user not to be verified")
+	}
+
+	_, err = passdb.ReadFile(strings.NewReader("juliet:{PLAIN}pencil\nromeo:{MD5}abc\n"))
+	if !errors.Is(err, passdb.ErrScheme) || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Unexpected error for unsupported scheme: %v", err)
+	}
+}
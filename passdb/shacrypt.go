@@ -0,0 +1,172 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package passdb
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Parameters of the SHA-crypt methods, see
+// https://www.akkadia.org/drepper/SHA-crypt.txt
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptMaxSalt       = 16
+)
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// The order in which the bytes of the final digest are encoded, three at a
+// time.
+var (
+	sha256CryptOrder = []int{
+		0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14, 15, 25, 5, 6, 16, 26,
+		27, 7, 17, 18, 28, 8, 9, 19, 29,
+	}
+	sha512CryptOrder = []int{
+		0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4, 47, 5, 26, 6, 27, 48,
+		28, 49, 7, 50, 8, 29, 9, 30, 51, 31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55,
+		13, 56, 14, 35, 15, 36, 57, 37, 58, 16, 59, 17, 38, 18, 39, 60, 40, 61, 19,
+		62, 20, 41,
+	}
+)
+
+// parseShaCrypt returns the hash function, number of rounds, and salt from a
+// $5$ or $6$ crypt string and reports whether it was well formed.
+// The rounds are 0 if they were not given explicitly.
+func parseShaCrypt(s string) (h func() hash.Hash, rounds int, salt string, ok bool) {
+	switch {
+	case strings.HasPrefix(s, "$5$"):
+		h = sha256.New
+	case strings.HasPrefix(s, "$6$"):
+		h = sha512.New
+	default:
+		return nil, 0, "", false
+	}
+	s = s[3:]
+	if r, ok := strings.CutPrefix(s, "rounds="); ok {
+		n, rest, ok := strings.Cut(r, "$")
+		if !ok {
+			return nil, 0, "", false
+		}
+		var err error
+		rounds, err = strconv.Atoi(n)
+		if err != nil || rounds < 1 {
+			return nil, 0, "", false
+		}
+		rounds = min(max(rounds, shaCryptMinRounds), shaCryptMaxRounds)
+		s = rest
+	}
+	salt, _, _ = strings.Cut(s, "$")
+	return h, rounds, salt[:min(len(salt), shaCryptMaxSalt)], true
+}
+
+// shaCrypt computes the $5$ or $6$ crypt string of password using the
+// parameters from setting, which may be a complete crypt string.
+// It returns an empty string if setting is not valid.
+func shaCrypt(password []byte, setting string) string {
+	newHash, rounds, salt, ok := parseShaCrypt(setting)
+	if !ok {
+		return ""
+	}
+
+	// repeat returns the first n bytes of b repeated.
+	repeat := func(b []byte, n int) []byte {
+		out := make([]byte, 0, n)
+		for len(out) < n {
+			out = append(out, b[:min(len(b), n-len(out))]...)
+		}
+		return out
+	}
+
+	h := newHash()
+	h.Write(password)
+	h.Write([]byte(salt))
+	h.Write(password)
+	b := h.Sum(nil)
+
+	h.Reset()
+	h.Write(password)
+	h.Write([]byte(salt))
+	h.Write(repeat(b, len(password)))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	h.Reset()
+	for range password {
+		h.Write(password)
+	}
+	p := repeat(h.Sum(nil), len(password))
+
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write([]byte(salt))
+	}
+	sp := repeat(h.Sum(nil), len(salt))
+
+	n := rounds
+	if n == 0 {
+		n = shaCryptDefaultRounds
+	}
+	c := a
+	for i := 0; i < n; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sp)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(c[:0])
+	}
+
+	var out strings.Builder
+	out.WriteString(setting[:3])
+	if rounds != 0 {
+		out.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	out.WriteString(salt)
+	out.WriteByte('$')
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	order := sha256CryptOrder
+	if len(c) == sha512.Size {
+		order = sha512CryptOrder
+	}
+	for i := 0; i < len(order); i += 3 {
+		encode(uint(c[order[i]])<<16|uint(c[order[i+1]])<<8|uint(c[order[i+2]]), 4)
+	}
+	if len(c) == sha512.Size {
+		encode(uint(c[63]), 2)
+	} else {
+		encode(uint(c[31])<<8|uint(c[30]), 3)
+	}
+	return out.String()
+}
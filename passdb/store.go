@@ -0,0 +1,74 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package passdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jh125486/sasl"
+)
+
+// Store is a sasl.CredentialStore backed by parsed passwords.
+// A Store must not be modified while it is being used by a negotiator.
+type Store map[string]Password
+
+// ReadFile reads users from a file in Dovecot's passwd-file format: one user
+// per line as "username:password" optionally followed by more colon separated
+// fields, which are ignored.
+// Empty lines and lines starting with "#" are skipped.
+func ReadFile(r io.Reader) (Store, error) {
+	s := make(Store)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, rest, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: %w", line, ErrMalformed)
+		}
+		p, err := Parse(cutPassword(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		s[user] = p
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// cutPassword returns the password field from the rest of a passwd-file line.
+// RFC 5803 passwords contain two colons, so they end after the ServerKey.
+func cutPassword(rest string) string {
+	fields := strings.SplitN(rest, ":", 4)
+	if len(fields) < 3 || !strings.Contains(fields[0], "$") || !strings.HasPrefix(strings.ToUpper(fields[0]), "SCRAM-") {
+		return fields[0]
+	}
+	return strings.Join(fields[:3], ":")
+}
+
+// ScramCredentials implements sasl.CredentialStore.
+// If the user does not exist or their password is not in the SCRAM scheme
+// matching mechanism, sasl.ErrUnknownUser is returned.
+func (s Store) ScramCredentials(mechanism string, username []byte) (sasl.StoredCredentials, error) {
+	p, ok := s[string(username)]
+	if !ok || p.Scheme != sasl.TrimPlus(mechanism) {
+		return sasl.StoredCredentials{}, sasl.ErrUnknownUser
+	}
+	return p.Scram, nil
+}
+
+// VerifyPassword reports whether password is the password of username.
+// It can be used to check PLAIN credentials from a permissions function.
+func (s Store) VerifyPassword(username, password []byte) bool {
+	p, ok := s[string(username)]
+	return ok && p.Verify(password)
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+)
+
+// A PasswordVerifier checks the plaintext passwords received by PLAIN servers,
+// for example against an LDAP directory or another upstream authority that
+// never reveals the stored passwords.
+type PasswordVerifier interface {
+	// VerifyPassword returns nil if password is the password of username, an
+	// error wrapping ErrAuthn if it is not, or any other error (ideally marked
+	// with Temporary) if it could not be checked.
+	VerifyPassword(ctx context.Context, username, password []byte) error
+}
+
+// PasswordVerifierFunc is a function that implements PasswordVerifier.
+type PasswordVerifierFunc func(ctx context.Context, username, password []byte) error
+
+// VerifyPassword calls f(ctx, username, password).
+func (f PasswordVerifierFunc) VerifyPassword(ctx context.Context, username, password []byte) error {
+	return f(ctx, username, password)
+}
+
+// VerifyPasswords makes PLAIN servers check passwords with v before calling
+// the permissions function, which then only has to decide whether the user is
+// authorized.
+// The username passed to v has been prepared and canonicalized in the same way
+// as the one passed to the permissions function, and the context is the one
+// returned by the Context method.
+func VerifyPasswords(v PasswordVerifier) Option {
+	return func(n *Negotiator) {
+		n.passwords = v
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestVerifyPasswords(t *testing.T) {
+	errDown := Temporary(errors.New("directory unavailable"))
+	for _, tc := range []struct {
+		name     string
+		verifier PasswordVerifier
+		err      error
+	}{
+		{name: "accept", verifier: PasswordVerifierFunc(func(_ context.Context, username, password []byte) error {
+			if string(username) != "Kurt" || string(password) != "xipj3plmq" {
+				return ErrAuthn
+			}
+			return nil
+		})},
+		{name: "reject", verifier: PasswordVerifierFunc(func(context.Context, []byte, []byte) error {
+			return ErrAuthn
+		}), err: ErrAuthn},
+		{name: "temporary", verifier: PasswordVerifierFunc(func(context.Context, []byte, []byte) error {
+			return errDown
+		}), err: errDown},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var permitted bool
+			server := NewServer(Plain, func(*Negotiator) bool {
+				permitted = true
+				return true
+			}, VerifyPasswords(tc.verifier))
+			err := negotiate(NewClient(Plain, plainClientOpts...), server)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if permitted != (tc.err == nil) {
+				t.Errorf("Permissions called=%t with error %v", permitted, err)
+			}
+		})
+	}
+}
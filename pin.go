@@ -0,0 +1,141 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"sync"
+)
+
+// Strength ranks mechanisms by how well they resist an active attacker.
+type Strength uint8
+
+// Strengths from weakest to strongest.
+const (
+	// StrengthPlaintext mechanisms send the password in the clear.
+	StrengthPlaintext Strength = iota
+
+	// StrengthHashed mechanisms do not reveal the password but do not
+	// authenticate the server.
+	StrengthHashed
+
+	// StrengthMutual mechanisms authenticate the server.
+	StrengthMutual
+
+	// StrengthChannelBinding mechanisms also bind the authentication to the TLS
+	// connection.
+	StrengthChannelBinding
+)
+
+// Strength returns the strength of a mechanism with these capabilities.
+func (c Capabilities) Strength() Strength {
+	switch {
+	case c.ChannelBinding:
+		return StrengthChannelBinding
+	case c.MutualAuth:
+		return StrengthMutual
+	case c.Plaintext:
+		return StrengthPlaintext
+	}
+	return StrengthHashed
+}
+
+// A PinStore records the strongest mechanism each server has advertised so
+// that clients can refuse to authenticate with a weaker mechanism later, which
+// mitigates attacks that strip mechanisms from the list advertised by the
+// server.
+type PinStore interface {
+	GetPin(server string) (s Strength, ok bool)
+	PutPin(server string, s Strength)
+}
+
+// Pin makes a client check and update the pin for server in store.
+// If the mechanism is weaker than the strongest mechanism previously
+// advertised by server, the first step fails with ErrDowngrade.
+// The pin is only updated after a negotiation completes, using the mechanisms
+// from the RemoteMechanisms option.
+//
+// The strength of advertised mechanisms is taken from the capabilities of the
+// mechanisms provided by this package, unknown mechanisms are treated as
+// StrengthChannelBinding if their name ends in "-PLUS" and as
+// StrengthPlaintext otherwise.
+func Pin(store PinStore, server string) Option {
+	return func(n *Negotiator) {
+		n.pinStore = store
+		n.pinServer = server
+	}
+}
+
+// checkPin returns ErrDowngrade if the mechanism is weaker than the pin.
+func (c *Negotiator) checkPin() error {
+	if c.pinStore == nil || c.isServer {
+		return nil
+	}
+	pin, ok := c.pinStore.GetPin(c.pinServer)
+	if ok && c.mechanism.Capabilities.Strength() < pin {
+		return ErrDowngrade
+	}
+	return nil
+}
+
+// updatePin raises the pin to the strongest advertised mechanism.
+func (c *Negotiator) updatePin() {
+	if c.pinStore == nil || c.isServer {
+		return
+	}
+	var advertised Strength
+	for _, name := range c.remoteMechanisms {
+		if s := mechanismStrength(name); s > advertised {
+			advertised = s
+		}
+	}
+	if pin, ok := c.pinStore.GetPin(c.pinServer); !ok || advertised > pin {
+		c.pinStore.PutPin(c.pinServer, advertised)
+	}
+}
+
+func mechanismStrength(name string) Strength {
+	for _, m := range []Mechanism{Plain, ScramSha1, ScramSha1Plus, ScramSha256, ScramSha256Plus, ScramSha512, ScramSha512Plus} {
+		if m.Name == name {
+			return m.Capabilities.Strength()
+		}
+	}
+	if IsPlus(name) {
+		return StrengthChannelBinding
+	}
+	return StrengthPlaintext
+}
+
+// MemoryPinStore is a PinStore that stores pins in memory.
+// The zero value is an empty store ready to use.
+type MemoryPinStore struct {
+	mu   sync.Mutex
+	pins map[string]Strength
+}
+
+// GetPin returns the pin stored for server, if any.
+func (s *MemoryPinStore) GetPin(server string) (Strength, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pin, ok := s.pins[server]
+	return pin, ok
+}
+
+// PutPin stores the pin for server.
+func (s *MemoryPinStore) PutPin(server string, pin Strength) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pins == nil {
+		s.pins = make(map[string]Strength)
+	}
+	s.pins[server] = pin
+}
+
+// Forget removes the pin for server, for example after the server was
+// intentionally reconfigured.
+func (s *MemoryPinStore) Forget(server string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pins, server)
+}
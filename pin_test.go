@@ -0,0 +1,68 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestPin(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	pins := &MemoryPinStore{}
+	creds := Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	})
+	auth := func(m Mechanism, remote ...string) error {
+		client := NewClient(m, creds, RemoteMechanisms(remote...), Pin(pins, "example.net"))
+		return negotiate(client, NewServer(m, acceptAll, Store(store)))
+	}
+
+	// Nothing is pinned yet, so PLAIN is allowed and pins what was advertised.
+	if err := auth(Plain, "PLAIN", "SCRAM-SHA-256"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pin, _ := pins.GetPin("example.net"); pin != StrengthMutual {
+		t.Fatalf("Unexpected pin: want=%d, got=%d", StrengthMutual, pin)
+	}
+
+	// The SCRAM mechanism has been stripped from the list.
+	if err := auth(Plain, "PLAIN"); err != ErrDowngrade {
+		t.Errorf("Expected ErrDowngrade, got %v", err)
+	}
+	if pin, _ := pins.GetPin("example.net"); pin != StrengthMutual {
+		t.Errorf("Pin was lowered: %d", pin)
+	}
+
+	// Other servers are unaffected.
+	client := NewClient(Plain, creds, Pin(pins, "example.com"))
+	if _, _, err := client.Step(nil); err != nil {
+		t.Errorf("Unexpected error for unpinned server: %v", err)
+	}
+
+	if err := auth(ScramSha256, "SCRAM-SHA-256"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	pins.Forget("example.net")
+	if err := auth(Plain, "PLAIN"); err != nil {
+		t.Errorf("Unexpected error after forgetting pin: %v", err)
+	}
+}
+
+func TestStrength(t *testing.T) {
+	for _, tc := range []struct {
+		m    Mechanism
+		want Strength
+	}{
+		{m: Plain, want: StrengthPlaintext},
+		{m: ScramSha256, want: StrengthMutual},
+		{m: ScramSha256Plus, want: StrengthChannelBinding},
+	} {
+		if got := tc.m.Capabilities.Strength(); got != tc.want {
+			t.Errorf("Wrong strength for %s: want=%d, got=%d", tc.m.Name, tc.want, got)
+		}
+	}
+}
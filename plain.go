@@ -6,14 +6,72 @@ package sasl
 
 import (
 	"bytes"
+	"unicode/utf8"
 )
 
 var plainSep = []byte{0}
 
+// CredentialError is returned when a username, password, or authorization
+// identity cannot be encoded by a mechanism, for example because it contains a
+// NUL byte or is not valid UTF-8.
+// It is also returned when a field received from the peer violates the limits
+// set by the FieldLimits option.
+// The credential itself is never included in the error.
+type CredentialError struct {
+	// Field is "username", "password", "identity", "nonce", "salt", or "trace".
+	Field string
+
+	// Reason describes what is wrong with the field.
+	Reason string
+}
+
+func (e CredentialError) Error() string {
+	return "Invalid " + e.Field + ": " + e.Reason
+}
+
+// Code returns CodeInvalidCredential.
+func (CredentialError) Code() Code {
+	return CodeInvalidCredential
+}
+
+// checkPlainCredentials makes sure that the credentials can be used in a PLAIN
+// message as defined in RFC 4616 §2.
+func checkPlainCredentials(username, password, identity []byte) error {
+	for _, f := range [...]struct {
+		name string
+		b    []byte
+	}{
+		{name: "username", b: username},
+		{name: "password", b: password},
+		{name: "identity", b: identity},
+	} {
+		switch {
+		case bytes.IndexByte(f.b, 0) != -1:
+			return CredentialError{Field: f.name, Reason: "contains a NUL byte"}
+		case !utf8.Valid(f.b):
+			return CredentialError{Field: f.name, Reason: "is not valid UTF-8"}
+		}
+	}
+	return nil
+}
+
 var plain = Mechanism{
 	Name: "PLAIN",
+	Capabilities: Capabilities{
+		RequiresTLS: true,
+		Plaintext:   true,
+	},
 	Start: func(m *Negotiator) (more bool, resp []byte, _ interface{}, err error) {
-		username, password, identity := m.credentials()
+		username, password, identity := m.Credentials()
+		if m.prepPlain {
+			username, password, err = m.preparedCredentials()
+			if err != nil {
+				return false, nil, nil, err
+			}
+		}
+		if err = checkPlainCredentials(username, password, identity); err != nil {
+			return false, nil, nil, err
+		}
 		payload := make([]byte, 0, len(identity)+len(username)+len(password)+2)
 		payload = append(payload, identity...)
 		payload = append(payload, '\x00')
@@ -25,21 +83,52 @@ var plain = Mechanism{
 	Next: func(m *Negotiator, challenge []byte, _ interface{}) (more bool, resp []byte, _ interface{}, err error) {
 		// If we're a client, or we're a server that's past the AuthTextSent step,
 		// we should never actually hit this step.
-		if m.State()&Receiving != Receiving || m.State()&StepMask != AuthTextSent {
+		if !m.State().IsServer() || m.State().Step() != AuthTextSent {
 			err = ErrTooManySteps
 			return
 		}
 
 		// If we're a server, validate that the challenge looks like:
 		// "Identity\x00Username\x00Password"
-		parts := bytes.Split(challenge, plainSep)
-		if len(parts) != 3 {
+		identity, rest, ok := bytes.Cut(challenge, plainSep)
+		if !ok {
 			err = ErrInvalidChallenge
 			return
 		}
+		username, password, ok := bytes.Cut(rest, plainSep)
+		if !ok {
+			err = ErrInvalidChallenge
+			return
+		}
+		if err = checkPlainCredentials(username, password, identity); err != nil {
+			return
+		}
+		if err = m.CheckField("username", username); err != nil {
+			return
+		}
+		if err = m.CheckField("identity", identity); err != nil {
+			return
+		}
+
+		if m.prepPlain {
+			if username, password, err = m.prepare(username, password); err != nil {
+				return
+			}
+		}
 
+		if username, err = m.CanonicalUsername(username); err != nil {
+			return
+		}
+		if m.passwords != nil {
+			verifyStart := m.Now()
+			err = m.passwords.VerifyPassword(m.Context(), username, password)
+			m.observeVerify(verifyStart)
+			if err != nil {
+				return
+			}
+		}
 		if m.Permissions(Credentials(func() (Username, Password, Identity []byte) {
-			return parts[1], parts[2], parts[0]
+			return username, password, identity
 		})) {
 			// Everything checks out as far as we know and the server should continue
 			// to authenticate the user.
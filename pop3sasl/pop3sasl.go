@@ -0,0 +1,167 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package pop3sasl implements the client side of the POP3 AUTH command
+// (RFC 5034) for any client negotiator.
+package pop3sasl
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/jh125486/sasl"
+)
+
+// maxCommandLen is the maximum length of the AUTH command line (including the
+// trailing CRLF) that may carry an initial response.
+const maxCommandLen = 255
+
+// Error is returned when the server completes the AUTH command with -ERR.
+type Error struct {
+	// Text is the human readable text sent by the server, including any
+	// response code such as [AUTH].
+	Text string
+}
+
+func (e *Error) Error() string {
+	return "pop3sasl: authentication failed: -ERR " + e.Text
+}
+
+// Unwrap returns sasl.ErrAuthn so that all failures can be detected with
+// errors.Is.
+func (e *Error) Unwrap() error {
+	return sasl.ErrAuthn
+}
+
+// Temporary reports whether the server sent the SYS/TEMP (RFC 3206) or IN-USE
+// (RFC 2449) response codes, meaning that authentication may succeed if it is
+// retried later.
+func (e *Error) Temporary() bool {
+	if !strings.HasPrefix(e.Text, "[") {
+		return false
+	}
+	code, _, ok := strings.Cut(e.Text[1:], "]")
+	return ok && (strings.EqualFold(code, "SYS/TEMP") || strings.EqualFold(code, "IN-USE"))
+}
+
+var errMalformed = errors.New("pop3sasl: malformed server response")
+
+// Authenticate runs the AUTH command over rw using the client negotiator n.
+// The initial response is sent with the command unless that would make the
+// command line longer than 255 octets.
+//
+// If rw is a *bufio.ReadWriter its reader is used directly so that no data is
+// lost after the command completes.
+func Authenticate(ctx context.Context, rw io.ReadWriter, n *sasl.Negotiator) error {
+	return sasl.NegotiateConn(ctx, rw, n, NewCodec(rw, n.Mechanism().Name))
+}
+
+// NewCodec returns a codec that frames the AUTH command for use with
+// sasl.NegotiateConn.
+// Responses from the server are read from r.
+func NewCodec(r io.Reader, mechanism string) sasl.Codec {
+	c := &codec{mechanism: mechanism, decode: base64.StdEncoding.DecodeString}
+	if brw, ok := r.(*bufio.ReadWriter); ok {
+		c.r = brw.Reader
+	} else if br, ok := r.(*bufio.Reader); ok {
+		c.r = br
+	} else {
+		c.r = bufio.NewReader(r)
+	}
+	return c
+}
+
+type codec struct {
+	mechanism string
+	started   bool
+	r         *bufio.Reader
+	decode    func(string) ([]byte, error)
+}
+
+func (c *codec) WriteResponse(w io.Writer, resp []byte) error {
+	if c.started {
+		return writeLine(w, base64.StdEncoding.EncodeToString(resp))
+	}
+	c.started = true
+
+	cmd := "AUTH " + c.mechanism
+	if resp == nil {
+		// The mechanism has no initial response so the first continuation is its
+		// first challenge.
+		return writeLine(w, cmd)
+	}
+	ir, _ := sasl.EncodeInitialResponse(resp, sasl.EmptyAsEquals)
+	if len(cmd)+len(ir)+3 <= maxCommandLen {
+		return writeLine(w, cmd+" "+ir)
+	}
+	if err := writeLine(w, cmd); err != nil {
+		return err
+	}
+	// Without an initial response the server prompts for it with an empty
+	// continuation.
+	challenge, done, err := c.ReadChallenge(nil)
+	switch {
+	case err != nil:
+		return err
+	case done || len(challenge) != 0:
+		return errMalformed
+	}
+	return writeLine(w, base64.StdEncoding.EncodeToString(resp))
+}
+
+// SetDecoder implements sasl.Base64Codec.
+func (c *codec) SetDecoder(decode func(string) ([]byte, error)) {
+	c.decode = decode
+}
+
+func (c *codec) ReadChallenge(io.Reader) ([]byte, bool, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	switch {
+	case line == "+" || strings.HasPrefix(line, "+ "):
+		challenge, err := c.decode(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, false, errMalformed
+		}
+		return challenge, false, nil
+	case line == "+OK" || strings.HasPrefix(line, "+OK "):
+		return nil, true, nil
+	case line == "-ERR" || strings.HasPrefix(line, "-ERR "):
+		return nil, false, &Error{Text: strings.TrimSpace(line[4:])}
+	}
+	return nil, false, errMalformed
+}
+
+// Abort cancels the exchange as described in RFC 5034 §4.
+func (c *codec) Abort(w io.Writer) error {
+	if err := writeLine(w, "*"); err != nil {
+		return err
+	}
+	// Consume the -ERR response.
+	_, _, err := c.ReadChallenge(nil)
+	var popErr *Error
+	if errors.As(err, &popErr) {
+		return nil
+	}
+	return err
+}
+
+func writeLine(w io.Writer, s string) error {
+	_, err := io.WriteString(w, s+"\r\n")
+	if err != nil {
+		return err
+	}
+	if bw, ok := w.(interface{ Flush() error }); ok {
+		return bw.Flush()
+	}
+	return nil
+}
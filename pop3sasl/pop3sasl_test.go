@@ -0,0 +1,205 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package pop3sasl_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/pop3sasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+// serve implements just enough of a POP3 server to handle a single AUTH
+// command. It returns the lines received from the client.
+func serve(conn net.Conn, server *sasl.Negotiator) []string {
+	defer conn.Close()
+	var lines []string
+	r := bufio.NewReader(conn)
+	readLine := func() (string, bool) {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSuffix(line, "\r\n")
+		lines = append(lines, line)
+		return line, err == nil
+	}
+
+	cmd, ok := readLine()
+	if !ok {
+		return lines
+	}
+	fields := strings.Fields(cmd)
+
+	var resp string
+	switch len(fields) {
+	case 3:
+		resp = fields[2]
+		if resp == "=" {
+			resp = ""
+		}
+	case 2:
+		io.WriteString(conn, "+ \r\n")
+		if resp, ok = readLine(); !ok {
+			return lines
+		}
+	default:
+		io.WriteString(conn, "-ERR unexpected command\r\n")
+		return lines
+	}
+
+	for {
+		if resp == "*" {
+			io.WriteString(conn, "-ERR canceled\r\n")
+			return lines
+		}
+		b, _ := base64.StdEncoding.DecodeString(resp)
+		if server.Completed() {
+			io.WriteString(conn, "+OK done\r\n")
+			return lines
+		}
+		more, challenge, err := server.Step(b)
+		switch {
+		case err != nil:
+			io.WriteString(conn, "-ERR [AUTH] invalid credentials\r\n")
+			return lines
+		case !more && len(challenge) == 0:
+			io.WriteString(conn, "+OK done\r\n")
+			return lines
+		}
+		io.WriteString(conn, "+ "+base64.StdEncoding.EncodeToString(challenge)+"\r\n")
+		if resp, ok = readLine(); !ok {
+			return lines
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		username string
+		password string
+		fail     bool
+	}{
+		{name: "plain", mech: sasl.Plain, username: "user", password: "pencil"},
+		{name: "plain-long", mech: sasl.Plain, username: strings.Repeat("u", 250), password: "pencil"},
+		{name: "plain-bad-password", mech: sasl.Plain, username: "user", password: "pen", fail: true},
+		{name: "scram", mech: sasl.ScramSha256, username: "user", password: "pencil"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, username: "user", password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			server := sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s))
+			lines := make(chan []string, 1)
+			go func() {
+				lines <- serve(serverConn, server)
+			}()
+
+			client := sasl.NewClient(tc.mech, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte(tc.username), []byte(tc.password), nil
+			}))
+			err := pop3sasl.Authenticate(context.Background(), clientConn, client)
+			switch {
+			case tc.fail && !errors.Is(err, sasl.ErrAuthn):
+				t.Errorf("Expected authentication error, got %v", err)
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+			got := <-lines
+			if len(got[0]) > 253 {
+				t.Errorf("AUTH command too long: %d octets", len(got[0])+2)
+			}
+		})
+	}
+}
+
+func TestLongInitialResponse(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	lines := make(chan []string, 1)
+	go func() {
+		lines <- serve(serverConn, sasl.NewServer(sasl.Plain, func(*sasl.Negotiator) bool { return true }))
+	}()
+	client := sasl.NewClient(sasl.Plain, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte(strings.Repeat("u", 250)), []byte("pencil"), nil
+	}))
+	if err := pop3sasl.Authenticate(context.Background(), clientConn, client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := <-lines; got[0] != "AUTH PLAIN" {
+		t.Errorf("Expected initial response to be omitted, got %q", got[0])
+	}
+}
+
+func TestAbort(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	lines := make(chan []string, 1)
+	go func() {
+		defer serverConn.Close()
+		r := bufio.NewReader(serverConn)
+		cmd, _ := r.ReadString('\n')
+		// Reply with a server-first-message containing a nonce that does not
+		// match.
+		io.WriteString(serverConn, "+ "+base64.StdEncoding.EncodeToString([]byte("r=abc,s=c2FsdA==,i=4096"))+"\r\n")
+		abort, _ := r.ReadString('\n')
+		io.WriteString(serverConn, "-ERR canceled\r\n")
+		lines <- []string{cmd, abort}
+	}()
+
+	client := sasl.NewClient(sasl.ScramSha256, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	if err := pop3sasl.Authenticate(context.Background(), clientConn, client); err == nil {
+		t.Fatal("Expected authentication to fail")
+	}
+	if got := <-lines; got[1] != "*\r\n" {
+		t.Errorf("Expected exchange to be canceled, got %q", got[1])
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		temp bool
+	}{
+		{text: "[SYS/TEMP] try again later", temp: true},
+		{text: "[IN-USE] mailbox locked", temp: true},
+		{text: "[SYS/PERM] account disabled"},
+		{text: "[AUTH] invalid credentials"},
+		{text: "SYS/TEMP"},
+	} {
+		err := &pop3sasl.Error{Text: tc.text}
+		if temp := sasl.IsTemporary(err); temp != tc.temp {
+			t.Errorf("IsTemporary(%v) = %t, want %t", err, temp, tc.temp)
+		}
+	}
+}
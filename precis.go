@@ -0,0 +1,25 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build !sasl_tiny
+
+package sasl
+
+import (
+	"golang.org/x/text/secure/precis"
+)
+
+// PRECIS replaces the SASLprep normalization with the PRECIS profiles defined
+// in RFC 8265: UsernameCaseMapped for usernames and OpaqueString for
+// passwords.
+// This should be used when the remote side has moved to PRECIS (as many modern
+// XMPP servers have) since the two normalizations do not always agree.
+//
+// PRECIS is not available in builds with the sasl_tiny build tag.
+func PRECIS() Option {
+	return func(n *Negotiator) {
+		n.prepUsername = precis.UsernameCaseMapped.Bytes
+		n.prepPassword = precis.OpaqueString.Bytes
+	}
+}
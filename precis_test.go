@@ -0,0 +1,32 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build !sasl_tiny
+
+package sasl
+
+import (
+	"testing"
+)
+
+func TestPRECIS(t *testing.T) {
+	tc := saslTest{
+		mechanism: plain,
+		clientOpts: []Option{
+			PlainSASLprep(),
+			PRECIS(),
+			Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("\uFF2Burt"), []byte("Xi\u00A0pj"), nil
+			}),
+		},
+		steps: []saslStep{
+			{resp: []byte("\x00kurt\x00Xi pj"), more: false},
+		},
+	}
+	client := NewClient(tc.mechanism, tc.clientOpts...)
+	for run := 1; run < 3; run++ {
+		testClient(t, client, tc, run)
+		client.Reset()
+	}
+}
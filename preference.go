@@ -0,0 +1,55 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"slices"
+)
+
+// PreferMechanisms sets the order in which mechanisms are tried by a
+// FailoverClient and advertised by a ServerFactory, for example to prefer
+// OAUTHBEARER over SCRAM for domains that use single sign-on while keeping both
+// enabled.
+// Mechanisms that are not named keep their original order after the named
+// ones.
+func PreferMechanisms(names ...string) Option {
+	return func(n *Negotiator) {
+		n.mechPrefs = names
+	}
+}
+
+// SelectMechanism returns the most preferred mechanism from mechs that the
+// remote side advertised in remote.
+// Mechanisms are ordered by prefs and then by their order in mechs (see
+// PreferMechanisms).
+// If remote is nil every mechanism is assumed to be supported.
+func SelectMechanism(mechs []Mechanism, remote []string, prefs ...string) (Mechanism, bool) {
+	for _, m := range orderMechanisms(mechs, prefs) {
+		if remote == nil || slices.Contains(remote, m.Name) {
+			return m, true
+		}
+	}
+	return Mechanism{}, false
+}
+
+// orderMechanisms returns mechs with the mechanisms named in prefs first, in
+// the order of prefs, followed by the rest in their original order.
+// If prefs is empty mechs is returned unchanged.
+func orderMechanisms(mechs []Mechanism, prefs []string) []Mechanism {
+	if len(prefs) == 0 {
+		return mechs
+	}
+	rank := func(m Mechanism) int {
+		if i := slices.Index(prefs, m.Name); i >= 0 {
+			return i
+		}
+		return len(prefs)
+	}
+	ordered := slices.Clone(mechs)
+	slices.SortStableFunc(ordered, func(a, b Mechanism) int {
+		return rank(a) - rank(b)
+	})
+	return ordered
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSelectMechanism(t *testing.T) {
+	mechs := []Mechanism{ScramSha256Plus, ScramSha256, Plain}
+	for i, tc := range []struct {
+		remote []string
+		prefs  []string
+		want   string
+		ok     bool
+	}{
+		0: {want: "SCRAM-SHA-256-PLUS", ok: true},
+		1: {remote: []string{"PLAIN", "SCRAM-SHA-256"}, want: "SCRAM-SHA-256", ok: true},
+		2: {remote: []string{"PLAIN", "SCRAM-SHA-256"}, prefs: []string{"PLAIN"}, want: "PLAIN", ok: true},
+		3: {prefs: []string{"OAUTHBEARER", "SCRAM-SHA-256"}, want: "SCRAM-SHA-256", ok: true},
+		4: {remote: []string{}},
+		5: {remote: []string{"OAUTHBEARER"}, prefs: []string{"OAUTHBEARER"}},
+	} {
+		m, ok := SelectMechanism(mechs, tc.remote, tc.prefs...)
+		if ok != tc.ok || m.Name != tc.want {
+			t.Errorf("%d: want=%q (%t), got=%q (%t)", i, tc.want, tc.ok, m.Name, ok)
+		}
+	}
+}
+
+func TestOrderMechanisms(t *testing.T) {
+	mechs := []Mechanism{ScramSha256Plus, ScramSha256, ScramSha1, Plain}
+	got := orderMechanisms(mechs, []string{"PLAIN", "SCRAM-SHA-1"})
+	var names []string
+	for _, m := range got {
+		names = append(names, m.Name)
+	}
+	want := []string{"PLAIN", "SCRAM-SHA-1", "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256"}
+	if !slices.Equal(names, want) {
+		t.Errorf("want=%v, got=%v", want, names)
+	}
+	if mechs[0].Name != "SCRAM-SHA-256-PLUS" {
+		t.Error("orderMechanisms modified its input")
+	}
+}
+
+func TestServerFactoryPreference(t *testing.T) {
+	f := NewServerFactory([]Mechanism{ScramSha256, Plain}, nil, PreferMechanisms("PLAIN"))
+	f.Tenant("example.org", nil, PreferMechanisms("SCRAM-SHA-256"))
+	if got := f.Mechanisms(""); !slices.Equal(got, []string{"PLAIN", "SCRAM-SHA-256"}) {
+		t.Errorf("Unexpected default mechanisms: %v", got)
+	}
+	if got := f.Mechanisms("example.org"); !slices.Equal(got, []string{"SCRAM-SHA-256", "PLAIN"}) {
+		t.Errorf("Unexpected tenant mechanisms: %v", got)
+	}
+}
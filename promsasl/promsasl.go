@@ -0,0 +1,147 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package promsasl implements a sasl.MetricsRecorder that exports Prometheus
+// metrics.
+//
+// It is a separate module so that users of the sasl package who do not need
+// metrics are not forced to depend on the Prometheus client.
+package promsasl // import "github.com/jh125486/sasl/promsasl"
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/jh125486/sasl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	_ sasl.KDFCostRecorder    = (*Recorder)(nil)
+	_ sasl.CorrelatedRecorder = (*Recorder)(nil)
+)
+
+// Recorder is a sasl.KDFCostRecorder, a sasl.CorrelatedRecorder, and a
+// prometheus.Collector.
+type Recorder struct {
+	negotiations *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	kdf          *prometheus.HistogramVec
+	verify       *prometheus.HistogramVec
+	iterations   *prometheus.HistogramVec
+}
+
+// New returns a Recorder that exports metrics with the given namespace.
+// The recorder must be registered with a prometheus.Registerer before its
+// metrics will be exported.
+func New(namespace string) *Recorder {
+	return &Recorder{
+		negotiations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sasl",
+			Name:      "negotiations_total",
+			Help:      "Number of completed SASL negotiations by mechanism and outcome.",
+		}, []string{"mechanism", "outcome"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "sasl",
+			Name:      "negotiation_duration_seconds",
+			Help:      "Time from the first to the last step of SASL negotiations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"mechanism", "outcome"}),
+		kdf: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "sasl",
+			Name:      "kdf_duration_seconds",
+			Help:      "Time spent running key derivation functions such as PBKDF2.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+		}, []string{"mechanism"}),
+		verify: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "sasl",
+			Name:      "verify_duration_seconds",
+			Help:      "Time servers spent verifying client proofs and passwords by mechanism and outcome.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"mechanism", "outcome"}),
+		iterations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "sasl",
+			Name:      "kdf_iterations",
+			Help:      "Iteration counts of the SCRAM credentials used by negotiations.",
+			Buckets:   prometheus.ExponentialBuckets(4096, 2, 8),
+		}, []string{"mechanism"}),
+	}
+}
+
+// Negotiation implements sasl.MetricsRecorder.
+func (r *Recorder) Negotiation(mechanism string, success bool, d time.Duration) {
+	outcome := "failure"
+	if success {
+		outcome = "success"
+	}
+	r.negotiations.WithLabelValues(mechanism, outcome).Inc()
+	r.duration.WithLabelValues(mechanism, outcome).Observe(d.Seconds())
+}
+
+// maxExemplarID is the length of the longest negotiation ID that is attached
+// to durations as an exemplar, which keeps the exemplar within the limit set by
+// the OpenMetrics format.
+const maxExemplarID = 64
+
+// NegotiationWithID implements sasl.CorrelatedRecorder.
+// It is like Negotiation except that the negotiation ID is attached to the
+// duration as the exemplar label "negotiation_id", so that slow negotiations
+// can be found in the logs.
+func (r *Recorder) NegotiationWithID(id, mechanism string, success bool, d time.Duration) {
+	outcome := "failure"
+	if success {
+		outcome = "success"
+	}
+	r.negotiations.WithLabelValues(mechanism, outcome).Inc()
+	obs := r.duration.WithLabelValues(mechanism, outcome)
+	if eo, ok := obs.(prometheus.ExemplarObserver); ok && id != "" && len(id) <= maxExemplarID && utf8.ValidString(id) {
+		eo.ObserveWithExemplar(d.Seconds(), prometheus.Labels{"negotiation_id": id})
+		return
+	}
+	obs.Observe(d.Seconds())
+}
+
+// KDF implements sasl.MetricsRecorder.
+func (r *Recorder) KDF(mechanism string, d time.Duration) {
+	r.kdf.WithLabelValues(mechanism).Observe(d.Seconds())
+}
+
+// KDFCost implements sasl.KDFCostRecorder.
+// Verification times are only recorded for negotiations that verified the
+// client and iteration counts only for mechanisms that use them.
+func (r *Recorder) KDFCost(mechanism string, success bool, cost sasl.KDFCost) {
+	if cost.Verify > 0 {
+		outcome := "failure"
+		if success {
+			outcome = "success"
+		}
+		r.verify.WithLabelValues(mechanism, outcome).Observe(cost.Verify.Seconds())
+	}
+	if cost.Iterations > 0 {
+		r.iterations.WithLabelValues(mechanism).Observe(float64(cost.Iterations))
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (r *Recorder) Describe(ch chan<- *prometheus.Desc) {
+	r.negotiations.Describe(ch)
+	r.duration.Describe(ch)
+	r.kdf.Describe(ch)
+	r.verify.Describe(ch)
+	r.iterations.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *Recorder) Collect(ch chan<- prometheus.Metric) {
+	r.negotiations.Collect(ch)
+	r.duration.Collect(ch)
+	r.kdf.Collect(ch)
+	r.verify.Collect(ch)
+	r.iterations.Collect(ch)
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package promsasl_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/promsasl"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecorder(t *testing.T) {
+	r := promsasl.New("test")
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(r); err != nil {
+		t.Fatalf("Error registering recorder: %v", err)
+	}
+
+	client := sasl.NewClient(sasl.Plain, sasl.Metrics(r), sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	if _, _, err := client.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	const want = `
+# HELP test_sasl_negotiations_total Number of completed SASL negotiations by mechanism and outcome.
+# TYPE test_sasl_negotiations_total counter
+test_sasl_negotiations_total{mechanism="PLAIN",outcome="success"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "test_sasl_negotiations_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKDFCost(t *testing.T) {
+	r := promsasl.New("test")
+	r.KDFCost("PLAIN", true, sasl.KDFCost{})
+	if n := testutil.CollectAndCount(r, "test_sasl_verify_duration_seconds", "test_sasl_kdf_iterations"); n != 0 {
+		t.Errorf("Expected empty costs not to be recorded, got %d series", n)
+	}
+	r.KDFCost("SCRAM-SHA-256", false, sasl.KDFCost{Iterations: 4096, Verify: time.Millisecond})
+	if n := testutil.CollectAndCount(r, "test_sasl_verify_duration_seconds", "test_sasl_kdf_iterations"); n != 2 {
+		t.Errorf("Expected verification time and iterations to be recorded, got %d series", n)
+	}
+}
+
+func TestExemplar(t *testing.T) {
+	r := promsasl.New("test")
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(r); err != nil {
+		t.Fatalf("Error registering recorder: %v", err)
+	}
+
+	client := sasl.NewClient(sasl.Plain, sasl.Metrics(r), sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	if _, _, err := client.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != "test_sasl_negotiation_duration_seconds" {
+			continue
+		}
+		for _, b := range f.GetMetric()[0].GetHistogram().GetBucket() {
+			if e := b.GetExemplar(); e != nil {
+				if l := e.GetLabel(); len(l) != 1 || l[0].GetValue() != client.NegotiationID() {
+					t.Errorf("Wrong exemplar labels: %v", l)
+				}
+				return
+			}
+		}
+	}
+	t.Errorf("No exemplar recorded for negotiation %s", client.NegotiationID())
+}
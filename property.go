@@ -0,0 +1,54 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// A Property is a typed key for a mechanism-specific setting, such as the qop
+// preferred by DIGEST-MD5 or the trace string sent by ANONYMOUS.
+// Mechanisms that need settings which do not apply to other mechanisms should
+// declare a Property as a package level variable instead of expecting a new
+// Option to be added to this package:
+//
+//	var QOP = sasl.NewProperty[string]("DIGEST-MD5 qop")
+//
+// Users then configure it with the Option returned by Set and the mechanism
+// reads it back with Get.
+// Properties are compared by identity, so two properties created with the same
+// name do not conflict.
+type Property[T any] struct {
+	name string
+}
+
+// NewProperty returns a new property.
+// The name is only used to describe the property and should include the
+// mechanism or package that uses it.
+func NewProperty[T any](name string) *Property[T] {
+	return &Property[T]{name: name}
+}
+
+// String returns the name of the property.
+func (p *Property[T]) String() string {
+	return p.name
+}
+
+// Set returns an option that sets the property to v.
+func (p *Property[T]) Set(v T) Option {
+	return func(n *Negotiator) {
+		// Copy the map instead of modifying it because it may be shared with
+		// other negotiators, such as the one passed to the permissions callback.
+		props := make(map[interface{}]interface{}, len(n.properties)+1)
+		for k, val := range n.properties {
+			props[k] = val
+		}
+		props[p] = v
+		n.properties = props
+	}
+}
+
+// Get returns the value of the property on n.
+// If the property was not set, the zero value of T and false are returned.
+func (p *Property[T]) Get(n *Negotiator) (v T, ok bool) {
+	v, ok = n.properties[p].(T)
+	return v, ok
+}
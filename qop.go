@@ -0,0 +1,108 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"strings"
+)
+
+// QOP is a set of quality of protection values negotiated by mechanisms such
+// as DIGEST-MD5 and GSSAPI that can provide a security layer.
+// Stronger protection has a larger value, so values can be compared to find
+// out which is strongest.
+type QOP uint8
+
+// Quality of protection values.
+const (
+	// QOPAuth is authentication only with no security layer.
+	QOPAuth QOP = 1 << iota
+
+	// QOPAuthInt is authentication with integrity protection.
+	QOPAuthInt
+
+	// QOPAuthConf is authentication with integrity and confidentiality
+	// protection.
+	QOPAuthConf
+)
+
+// String returns the names of the values in the set as they appear on the
+// wire, separated by commas.
+func (q QOP) String() string {
+	var names []string
+	if q&QOPAuth != 0 {
+		names = append(names, "auth")
+	}
+	if q&QOPAuthInt != 0 {
+		names = append(names, "auth-int")
+	}
+	if q&QOPAuthConf != 0 {
+		names = append(names, "auth-conf")
+	}
+	return strings.Join(names, ",")
+}
+
+// QOPPreference sets the quality of protection values that are acceptable in
+// order of preference.
+// By default the strongest value offered by the remote side is used.
+func QOPPreference(prefs ...QOP) Option {
+	return func(n *Negotiator) {
+		n.qopPrefs = prefs
+	}
+}
+
+// RequireQOP makes the negotiation fail with ErrQOP unless it results in at
+// least min protection.
+// For example, RequireQOP(QOPAuthInt) refuses to complete a negotiation that
+// did not establish integrity protection, including with mechanisms that do
+// not support a security layer at all.
+func RequireQOP(min QOP) Option {
+	return func(n *Negotiator) {
+		n.minQOP = min
+	}
+}
+
+// SelectQOP is used by mechanisms to pick one of the quality of protection
+// values offered by the remote side using the QOPPreference and RequireQOP
+// options.
+// If none of the offered values are acceptable, ErrQOP is returned.
+func (c *Negotiator) SelectQOP(offered QOP) (QOP, error) {
+	if c.qopPrefs != nil {
+		for _, q := range c.qopPrefs {
+			if offered&q == q && q >= c.minQOP {
+				return q, nil
+			}
+		}
+		return 0, ErrQOP
+	}
+	for q := QOPAuthConf; q >= QOPAuth && q >= c.minQOP; q >>= 1 {
+		if offered&q == q {
+			return q, nil
+		}
+	}
+	return 0, ErrQOP
+}
+
+// NegotiatedQOP returns the quality of protection established by the
+// negotiation.
+// If the negotiation has not completed it returns zero.
+// If the mechanism did not negotiate a security layer it returns QOPAuth,
+// otherwise it returns the QOP of the layer if it has a method
+// "QOP() sasl.QOP" or QOPAuthInt if it does not.
+func (c *Negotiator) NegotiatedQOP() QOP {
+	if !c.completed {
+		return 0
+	}
+	return c.negotiatedQOP()
+}
+
+func (c *Negotiator) negotiatedQOP() QOP {
+	switch l := c.cache.(type) {
+	case interface{ QOP() QOP }:
+		return l.QOP()
+	case SecurityLayer:
+		return QOPAuthInt
+	}
+	return QOPAuth
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"testing"
+)
+
+func TestSelectQOP(t *testing.T) {
+	all := QOPAuth | QOPAuthInt | QOPAuthConf
+	for i, tc := range []struct {
+		opts    []Option
+		offered QOP
+		want    QOP
+		err     error
+	}{
+		{offered: all, want: QOPAuthConf},
+		{offered: QOPAuth | QOPAuthInt, want: QOPAuthInt},
+		{offered: QOPAuth, want: QOPAuth},
+		{offered: 0, err: ErrQOP},
+		{opts: []Option{RequireQOP(QOPAuthInt)}, offered: QOPAuth, err: ErrQOP},
+		{opts: []Option{QOPPreference(QOPAuth, QOPAuthInt)}, offered: all, want: QOPAuth},
+		{opts: []Option{QOPPreference(QOPAuth, QOPAuthInt), RequireQOP(QOPAuthInt)}, offered: all, want: QOPAuthInt},
+		{opts: []Option{QOPPreference(QOPAuthConf)}, offered: QOPAuth | QOPAuthInt, err: ErrQOP},
+	} {
+		q, err := NewClient(plain, tc.opts...).SelectQOP(tc.offered)
+		if err != tc.err {
+			t.Errorf("%d: Unexpected error: want=%v, got=%v", i, tc.err, err)
+		}
+		if q != tc.want {
+			t.Errorf("%d: Unexpected QOP: want=%v, got=%v", i, tc.want, q)
+		}
+	}
+}
+
+func TestQOPString(t *testing.T) {
+	if s := (QOPAuth | QOPAuthConf).String(); s != "auth,auth-conf" {
+		t.Errorf("Unexpected string: %q", s)
+	}
+}
+
+func TestRequireQOP(t *testing.T) {
+	newMech := func(layer interface{}) Mechanism {
+		return Mechanism{
+			Name: "X-QOP",
+			Start: func(*Negotiator) (bool, []byte, interface{}, error) {
+				return false, nil, layer, nil
+			},
+		}
+	}
+	for i, tc := range []struct {
+		layer interface{}
+		opts  []Option
+		want  QOP
+		err   error
+	}{
+		{want: QOPAuth},
+		{layer: xorLayer{max: 8}, want: QOPAuthInt},
+		{opts: []Option{RequireQOP(QOPAuthInt)}, err: ErrQOP},
+		{layer: xorLayer{max: 8}, opts: []Option{RequireQOP(QOPAuthInt)}, want: QOPAuthInt},
+		{layer: xorLayer{max: 8}, opts: []Option{RequireQOP(QOPAuthConf)}, err: ErrQOP},
+	} {
+		c := NewClient(newMech(tc.layer), tc.opts...)
+		_, _, err := c.Step(nil)
+		if err != tc.err {
+			t.Errorf("%d: Unexpected error: want=%v, got=%v", i, tc.err, err)
+		}
+		if q := c.NegotiatedQOP(); q != tc.want {
+			t.Errorf("%d: Unexpected negotiated QOP: want=%v, got=%v", i, tc.want, q)
+		}
+	}
+}
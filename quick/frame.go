@@ -0,0 +1,170 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package quick
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// maxFrameSize is the largest message that will be read with the
+// LengthPrefixed framing.
+const maxFrameSize = 1 << 20
+
+// Message types.
+// They are used as the type octet of the LengthPrefixed framing.
+const (
+	msgAuth      byte = 'A'
+	msgResponse  byte = 'R'
+	msgAbort     byte = '*'
+	msgChallenge byte = '+'
+	msgSuccess   byte = 'S'
+	msgFailure   byte = 'F'
+)
+
+// framer reads and writes messages using one of the framings.
+type framer struct {
+	frame  Frame
+	r      *bufio.Reader
+	w      io.Writer
+	decode func(string) ([]byte, error)
+}
+
+// joinAuth returns the data of an auth message.
+// With the LengthPrefixed framing the mechanism name is separated from the
+// initial response by a NUL octet, which is omitted if there is no initial
+// response.
+func (f *framer) joinAuth(mechanism string, initial []byte) []byte {
+	data := []byte(mechanism)
+	if initial == nil {
+		return data
+	}
+	if f.frame == LengthPrefixed {
+		data = append(data, 0)
+		return append(data, initial...)
+	}
+	return append(append(data, ' '), encode(initial)...)
+}
+
+// splitAuth returns the mechanism name and initial response (which is nil if
+// there was none) from the data of an auth message.
+func (f *framer) splitAuth(data []byte) (string, []byte, error) {
+	if f.frame == LengthPrefixed {
+		name, initial, ok := bytes.Cut(data, []byte{0})
+		if !ok {
+			return string(name), nil, nil
+		}
+		return string(name), append([]byte{}, initial...), nil
+	}
+	name, initial, ok := strings.Cut(string(data), " ")
+	if !ok {
+		return name, nil, nil
+	}
+	resp, err := f.decodeData(initial)
+	if err != nil {
+		return "", nil, err
+	}
+	if resp == nil {
+		resp = []byte{}
+	}
+	return name, resp, nil
+}
+
+func (f *framer) write(kind byte, data []byte) error {
+	if f.frame == LengthPrefixed {
+		buf := make([]byte, 5, 5+len(data))
+		binary.BigEndian.PutUint32(buf, uint32(1+len(data)))
+		buf[4] = kind
+		_, err := f.w.Write(append(buf, data...))
+		return err
+	}
+
+	var line string
+	switch kind {
+	case msgAuth:
+		line = "AUTH " + string(data)
+	case msgResponse:
+		line = encode(data)
+	case msgAbort:
+		line = "*"
+	case msgChallenge:
+		line = "+ " + encode(data)
+	case msgSuccess:
+		line = "OK"
+		if len(data) > 0 {
+			line += " " + encode(data)
+		}
+	case msgFailure:
+		line = "NO " + string(data)
+	}
+	_, err := io.WriteString(f.w, line+"\r\n")
+	return err
+}
+
+func (f *framer) read() (kind byte, data []byte, err error) {
+	if f.frame == LengthPrefixed {
+		var size [4]byte
+		if _, err := io.ReadFull(f.r, size[:]); err != nil {
+			return 0, nil, err
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n == 0 || n > maxFrameSize {
+			return 0, nil, ErrMalformed
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(f.r, buf); err != nil {
+			return 0, nil, err
+		}
+		return buf[0], buf[1:], nil
+	}
+
+	line, err := f.r.ReadString('\n')
+	if err != nil {
+		return 0, nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	// Responses are a single base64 word, which may also be "AUTH", so the
+	// message type is determined by the word and whether it is followed by more
+	// data.
+	status, rest, _ := strings.Cut(line, " ")
+	switch {
+	case status == "AUTH" && rest != "":
+		return msgAuth, []byte(rest), nil
+	case status == "*":
+		return msgAbort, nil, nil
+	case status == "+":
+		data, err = f.decodeData(rest)
+		return msgChallenge, data, err
+	case status == "OK":
+		data, err = f.decodeData(rest)
+		return msgSuccess, data, err
+	case status == "NO":
+		return msgFailure, []byte(rest), nil
+	}
+	data, err = f.decodeData(line)
+	return msgResponse, data, err
+}
+
+func (f *framer) decodeData(s string) ([]byte, error) {
+	if s == "" || s == "=" {
+		return nil, nil
+	}
+	data, err := f.decode(s)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return data, nil
+}
+
+func encode(b []byte) string {
+	if len(b) == 0 {
+		return "="
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,190 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package quick runs complete SASL negotiations over a stream with a single
+// function call, for small tools and tests that do not speak an existing
+// protocol and do not need to drive the state machine themselves.
+//
+// The client starts by sending the name of the mechanism and its initial
+// response, if any.
+// The server then sends challenges until it reports success, along with any
+// additional data, or failure.
+// Messages are framed as lines of base64 (see Lines) by default or with a
+// length prefix (see LengthPrefixed) if the Framing property is set on both
+// sides.
+package quick // import "github.com/jh125486/sasl/quick"
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jh125486/sasl"
+)
+
+// Errors returned by Client and Server.
+var (
+	ErrMalformed = errors.New("quick: malformed message")
+	ErrAborted   = errors.New("quick: the client aborted the exchange")
+)
+
+// Frame is a way of framing messages on the stream.
+type Frame uint8
+
+// Framings supported by Client and Server.
+const (
+	// Lines frames each message as a line ending in CRLF.
+	// The client sends "AUTH <mechanism> [<initial response>]" followed by one
+	// line per response, or "*" to abort.
+	// The server sends "+ <challenge>" to continue, "OK [<data>]" on success, or
+	// "NO <reason>" on failure.
+	// All data is base64 encoded, with "=" for an empty message.
+	Lines Frame = iota
+
+	// LengthPrefixed frames each message as its length as a four octet unsigned
+	// integer in network byte order followed by a one octet message type and the
+	// raw data, which is more efficient for large messages.
+	LengthPrefixed
+)
+
+// Framing is the property that selects the framing used by Client and Server.
+// The default is Lines.
+var Framing = sasl.NewProperty[Frame]("quick framing")
+
+// Client authenticates to the server on rw using mech.
+// The options are used to create the client negotiator as if they had been
+// passed to sasl.NewClient.
+//
+// If rw has a SetDeadline method it is used to abort blocking reads and writes
+// when ctx is canceled or its deadline expires.
+// If rw is a *bufio.ReadWriter its reader is used directly so that no data is
+// lost after the negotiation completes.
+func Client(ctx context.Context, rw io.ReadWriter, mech sasl.Mechanism, opts ...sasl.Option) error {
+	n := sasl.NewClient(mech, opts...)
+	return sasl.NegotiateConn(ctx, rw, n, &clientCodec{framer: newFramer(rw, n), mechanism: mech.Name})
+}
+
+// Server accepts a negotiation from the client on rw using one of mechs and
+// returns the negotiator, for example so that the caller can check the
+// credentials or identity of the client.
+// Permissions and opts are used to create the server negotiator as if they had
+// been passed to sasl.NewServer.
+//
+// If the client selects a mechanism that is not in mechs the client is sent a
+// failure and sasl.ErrMechanismNotSupported is returned.
+// Deadlines and buffering are handled as described for Client.
+func Server(ctx context.Context, rw io.ReadWriter, mechs []sasl.Mechanism, permissions func(*sasl.Negotiator) bool, opts ...sasl.Option) (n *sasl.Negotiator, err error) {
+	if conn, ok := rw.(interface{ SetDeadline(time.Time) error }); ok {
+		stop := context.AfterFunc(ctx, func() {
+			conn.SetDeadline(time.Unix(1, 0))
+		})
+		defer func() {
+			if !stop() && err != nil {
+				err = ctx.Err()
+			}
+			conn.SetDeadline(time.Time{})
+		}()
+	}
+
+	// The mechanism is not known until the first message has been read, so the
+	// framing is read from a negotiator with just the options applied.
+	var scratch sasl.Negotiator
+	for _, o := range opts {
+		o(&scratch)
+	}
+	f := newFramer(rw, &scratch)
+	kind, data, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	if kind != msgAuth {
+		return nil, ErrMalformed
+	}
+	name, initial, err := f.splitAuth(data)
+	if err != nil {
+		return nil, err
+	}
+
+	n, more, challenge, err := sasl.Accept(mechs, permissions, name, initial, opts...)
+	for {
+		if err == nil {
+			err = ctx.Err()
+		}
+		switch {
+		case err != nil:
+			f.write(msgFailure, []byte("authentication failed"))
+			return n, err
+		case !more:
+			return n, f.write(msgSuccess, challenge)
+		}
+		if err = f.write(msgChallenge, challenge); err != nil {
+			return n, err
+		}
+		kind, data, err = f.read()
+		switch {
+		case err != nil:
+			return n, err
+		case kind == msgAbort:
+			return n, ErrAborted
+		case kind != msgResponse:
+			return n, ErrMalformed
+		}
+		more, challenge, err = n.StepContext(ctx, data)
+	}
+}
+
+// clientCodec implements sasl.Codec for Client.
+type clientCodec struct {
+	framer    *framer
+	mechanism string
+	started   bool
+}
+
+func (c *clientCodec) WriteResponse(_ io.Writer, resp []byte) error {
+	if c.started {
+		return c.framer.write(msgResponse, resp)
+	}
+	c.started = true
+	return c.framer.write(msgAuth, c.framer.joinAuth(c.mechanism, resp))
+}
+
+// SetDecoder implements sasl.Base64Codec.
+func (c *clientCodec) SetDecoder(decode func(string) ([]byte, error)) {
+	c.framer.decode = decode
+}
+
+func (c *clientCodec) ReadChallenge(io.Reader) ([]byte, bool, error) {
+	kind, data, err := c.framer.read()
+	if err != nil {
+		return nil, false, err
+	}
+	switch kind {
+	case msgChallenge:
+		return data, false, nil
+	case msgSuccess:
+		return data, true, nil
+	case msgFailure:
+		return nil, false, fmt.Errorf("%w: %s", sasl.ErrAuthn, data)
+	}
+	return nil, false, ErrMalformed
+}
+
+// Abort implements sasl.Aborter.
+func (c *clientCodec) Abort(io.Writer) error {
+	return c.framer.write(msgAbort, nil)
+}
+
+func newFramer(rw io.ReadWriter, n *sasl.Negotiator) *framer {
+	f := &framer{w: rw, decode: n.DecodeBase64}
+	f.frame, _ = Framing.Get(n)
+	if brw, ok := rw.(*bufio.ReadWriter); ok {
+		f.r = brw.Reader
+	} else {
+		f.r = bufio.NewReader(rw)
+	}
+	return f
+}
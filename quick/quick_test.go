@@ -0,0 +1,94 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package quick_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/quick"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func credentials(password string) sasl.Option {
+	return sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte(password), nil
+	})
+}
+
+func acceptPencil(n *sasl.Negotiator) bool {
+	user, pass, _ := n.Credentials()
+	if n.Mechanism().Name != sasl.Plain.Name {
+		return string(user) == "user"
+	}
+	return string(user) == "user" && string(pass) == "pencil"
+}
+
+func TestQuick(t *testing.T) {
+	scramStore := sasl.Store(store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)})
+	mechs := []sasl.Mechanism{sasl.ScramSha256, sasl.Plain}
+	for _, frame := range []quick.Frame{quick.Lines, quick.LengthPrefixed} {
+		for i, tc := range []struct {
+			mech      sasl.Mechanism
+			password  string
+			clientErr error
+			serverErr error
+		}{
+			0: {mech: sasl.Plain, password: "pencil"},
+			1: {mech: sasl.ScramSha256, password: "pencil"},
+			2: {mech: sasl.Plain, password: "pen", clientErr: sasl.ErrAuthn, serverErr: sasl.ErrAuthn},
+			3: {mech: sasl.ScramSha256, password: "pen", clientErr: sasl.ErrAuthn, serverErr: sasl.ErrAuthn},
+			4: {mech: sasl.ScramSha1, password: "pencil", clientErr: sasl.ErrAuthn, serverErr: sasl.ErrMechanismNotSupported},
+		} {
+			t.Run(strconv.Itoa(int(frame))+"/"+strconv.Itoa(i), func(t *testing.T) {
+				c, s := net.Pipe()
+				defer c.Close()
+				defer s.Close()
+				ctx := context.Background()
+				framing := quick.Framing.Set(frame)
+
+				errs := make(chan error, 1)
+				go func() {
+					errs <- quick.Client(ctx, c, tc.mech, framing, credentials(tc.password), sasl.Confidential(true))
+				}()
+				n, err := quick.Server(ctx, s, mechs, acceptPencil, framing, scramStore)
+				if !errors.Is(err, tc.serverErr) {
+					t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+				}
+				if err := <-errs; !errors.Is(err, tc.clientErr) {
+					t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+				}
+				if tc.serverErr == nil && !n.Completed() {
+					t.Error("Expected server negotiation to complete")
+				}
+			})
+		}
+	}
+}
+
+func TestServerContext(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := quick.Server(ctx, s, []sasl.Mechanism{sasl.Plain}, acceptPencil); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
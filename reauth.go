@@ -0,0 +1,116 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+)
+
+// AuthenticatedIdentity is the identity established by a successful
+// negotiation.
+type AuthenticatedIdentity struct {
+	// Username is the authentication identity.
+	Username []byte
+
+	// Identity is the authorization identity, which is empty if it is the same
+	// as the username.
+	Identity []byte
+}
+
+// Reauthenticate prepares a negotiator that completed successfully for a
+// second negotiation on the same session, as permitted by protocols such as
+// AMQP, LDAP, and XMPP SASL2.
+// Unlike Reset it remembers the identity that was authenticated: Identity
+// continues to return it until the new negotiation completes, after which it
+// is available from PreviousIdentity.
+//
+// If the negotiator has not completed, Reauthenticate returns ErrInvalidState
+// and does nothing.
+// If the new negotiation fails, the negotiator must be reset or reauthenticated
+// again before it is reused and the previous identity should be considered
+// authenticated or not according to the rules of the protocol.
+func (c *Negotiator) Reauthenticate() error {
+	if !c.completed {
+		return ErrInvalidState
+	}
+	authnID, session := c.negotiatedID, c.session
+	c.Reset()
+	c.authnID, c.session = authnID, session
+	return nil
+}
+
+// Upgrade prepares a negotiator that completed an ANONYMOUS negotiation for a
+// second negotiation on the same session using m, for example so that a guest
+// of a chat service can register or log in without reconnecting.
+// It is like Reauthenticate except that the mechanism is replaced and opts are
+// applied, for example to provide the credentials that the anonymous session
+// did not need, and the anonymous identity is available from PreviousIdentity
+// once the new negotiation completes.
+//
+// The session token returned by SessionToken is kept, so applications can use
+// it to link the authenticated identity to the state of the anonymous session.
+// Servers can read it from the negotiator passed to the permissions callback.
+//
+// If the negotiator has not completed an ANONYMOUS negotiation, Upgrade
+// returns ErrInvalidState and does nothing.
+func (c *Negotiator) Upgrade(m Mechanism, opts ...Option) error {
+	if c.mechanism.Name != Anonymous.Name || c.session == nil {
+		return ErrInvalidState
+	}
+	if err := c.Reauthenticate(); err != nil {
+		return err
+	}
+	c.mechanism = m
+	c.applyScoped()
+	for _, o := range opts {
+		o(c)
+	}
+	c.applyWorkarounds()
+	c.flags &^= RemoteCB
+	c.setRemoteCB()
+	return nil
+}
+
+// SessionToken returns a random token that identifies the session established
+// by an ANONYMOUS negotiation, or nil if there is none.
+// The token is generated when the ANONYMOUS negotiation completes, kept by
+// Upgrade and Reauthenticate, and discarded by Reset.
+// It is never sent to the remote side.
+func (c *Negotiator) SessionToken() []byte {
+	return c.session
+}
+
+// Identity returns the identity established by the most recent successful
+// negotiation.
+// While a reauthentication is in progress it returns the identity that was
+// previously authenticated.
+// On servers the identity is the one that the permissions callback approved.
+func (c *Negotiator) Identity() (id AuthenticatedIdentity, ok bool) {
+	if c.completed && c.negotiatedID != nil {
+		return *c.negotiatedID, true
+	}
+	if c.authnID != nil {
+		return *c.authnID, true
+	}
+	return id, false
+}
+
+// PreviousIdentity returns the identity that was replaced by a successful
+// reauthentication.
+func (c *Negotiator) PreviousIdentity() (id AuthenticatedIdentity, ok bool) {
+	if !c.completed || c.authnID == nil {
+		return id, false
+	}
+	return *c.authnID, true
+}
+
+// setNegotiatedID records username and identity as the identity established by
+// the current negotiation.
+func (c *Negotiator) setNegotiatedID(username, identity []byte) {
+	c.negotiatedID = &AuthenticatedIdentity{
+		Username: bytes.Clone(username),
+		Identity: bytes.Clone(identity),
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"testing"
+)
+
+func TestReauthenticate(t *testing.T) {
+	username := "user1"
+	client := NewClient(Plain, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte(username), []byte("pencil"), nil
+	}))
+	server := NewServer(Plain, acceptAll)
+
+	checkID := func(n *Negotiator, current, previous string) {
+		t.Helper()
+		id, ok := n.Identity()
+		if got := string(id.Username); ok != (current != "") || got != current {
+			t.Errorf("Unexpected identity: want=%q, got=%q (%t)", current, got, ok)
+		}
+		id, ok = n.PreviousIdentity()
+		if got := string(id.Username); ok != (previous != "") || got != previous {
+			t.Errorf("Unexpected previous identity: want=%q, got=%q (%t)", previous, got, ok)
+		}
+	}
+
+	if err := client.Reauthenticate(); err != ErrInvalidState {
+		t.Errorf("Expected reauthenticating before completion to fail, got: %v", err)
+	}
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkID(client, "user1", "")
+	checkID(server, "user1", "")
+
+	username = "user2"
+	for _, n := range []*Negotiator{client, server} {
+		if err := n.Reauthenticate(); err != nil {
+			t.Fatalf("Unexpected error reauthenticating: %v", err)
+		}
+		checkID(n, "user1", "")
+	}
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkID(client, "user2", "user1")
+	checkID(server, "user2", "user1")
+
+	client.Reset()
+	checkID(client, "", "")
+}
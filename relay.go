@@ -0,0 +1,185 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/jh125486/sasl/scramwire"
+)
+
+// A Relay observes an exchange that a proxy forwards between a downstream
+// client and an upstream server without terminating it, for example in an
+// XMPP or IMAP gateway that must know who logged in to route the session.
+//
+// The proxy passes each message to Response or Challenge as it forwards it
+// and reports the result sent by the server with Outcome.
+// The relay never modifies messages or holds any credentials; the identity is
+// taken from the messages of the client and is only reported by Identity once
+// the server has accepted it.
+// The identity is known for PLAIN and the SCRAM family, and only the
+// authorization identity for EXTERNAL and OAUTHBEARER.
+// The -PLUS variants of SCRAM cannot be relayed across a proxy that terminates
+// TLS because the channel binding data differs on each side (see
+// RelayMechanisms).
+//
+// A Relay is safe for concurrent use so that the two directions may be pumped
+// by separate goroutines.
+type Relay struct {
+	mu        sync.Mutex
+	mechanism string
+	started   bool
+	done      bool
+	success   bool
+	known     bool
+	id        AuthenticatedIdentity
+}
+
+// NewRelay returns a relay for an exchange using the named mechanism, which is
+// normally taken from the client's authentication request.
+func NewRelay(mechanism string) *Relay {
+	return &Relay{mechanism: mechanism}
+}
+
+// Mechanism returns the name of the mechanism being relayed.
+func (r *Relay) Mechanism() string {
+	return r.mechanism
+}
+
+// Response observes a response forwarded from the client to the server.
+// The first response is the initial response, which is nil if the client did
+// not send one.
+func (r *Relay) Response(resp []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return
+	}
+	if !r.started && resp == nil {
+		// The client is waiting for an empty challenge before sending the initial
+		// response.
+		return
+	}
+	if !r.started {
+		r.started = true
+		r.id, r.known = relayIdentity(r.mechanism, resp)
+	}
+}
+
+// Challenge observes a challenge forwarded from the server to the client,
+// including any additional data sent with a success message.
+// A SCRAM server-final-message that reports an error is recorded as a failure
+// even if the proxy does not call Outcome.
+func (r *Relay) Challenge(challenge []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done || !r.started {
+		return
+	}
+	if _, ok := ScramHash(r.mechanism); ok && bytes.HasPrefix(challenge, []byte("e=")) {
+		r.done = true
+	}
+}
+
+// Outcome records whether the server reported success or failure.
+// Messages observed after the outcome is recorded are ignored.
+func (r *Relay) Outcome(success bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	r.success = success
+}
+
+// Done reports whether the outcome of the exchange has been recorded.
+func (r *Relay) Done() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.done
+}
+
+// Identity returns the identity of the client once the server has reported
+// success.
+// If the exchange has not succeeded, or the identity cannot be determined from
+// the messages of the mechanism being relayed, ok is false.
+func (r *Relay) Identity() (id AuthenticatedIdentity, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.success || !r.known {
+		return AuthenticatedIdentity{}, false
+	}
+	return AuthenticatedIdentity{
+		Username: append([]byte(nil), r.id.Username...),
+		Identity: append([]byte(nil), r.id.Identity...),
+	}, true
+}
+
+// RelayMechanisms returns the mechanisms advertised by an upstream server that
+// can be offered to downstream clients through a proxy that terminates TLS,
+// which are all of them except the variants that use channel binding.
+func RelayMechanisms(names []string) []string {
+	relayed := make([]string, 0, len(names))
+	for _, name := range names {
+		if !IsPlus(name) {
+			relayed = append(relayed, name)
+		}
+	}
+	return relayed
+}
+
+// relayIdentity returns the identity claimed by the first response of the
+// client for mechanisms where it is sent in the clear.
+func relayIdentity(mechanism string, resp []byte) (AuthenticatedIdentity, bool) {
+	var id AuthenticatedIdentity
+	if _, ok := ScramHash(mechanism); ok {
+		clientFirst, err := scramwire.ParseClientFirst(resp)
+		if err != nil {
+			return id, false
+		}
+		id.Username = clientFirst.Username
+		id.Identity = clientFirst.Authzid
+		return id, true
+	}
+
+	switch mechanism {
+	case "PLAIN":
+		identity, rest, ok := bytes.Cut(resp, plainSep)
+		if !ok {
+			return id, false
+		}
+		username, _, ok := bytes.Cut(rest, plainSep)
+		if !ok || len(username) == 0 {
+			return id, false
+		}
+		id.Username = append([]byte(nil), username...)
+		id.Identity = append([]byte(nil), identity...)
+		return id, true
+	case "EXTERNAL":
+		// The authentication identity comes from the lower layer, so only the
+		// requested authorization identity is known.
+		id.Identity = append([]byte(nil), resp...)
+		return id, len(resp) > 0
+	case "OAUTHBEARER":
+		// The GS2 header is followed by key/value pairs separated by 0x01.
+		gs2, _, ok := bytes.Cut(resp, []byte{1})
+		if !ok {
+			return id, false
+		}
+		fields := bytes.Split(gs2, []byte{','})
+		if len(fields) < 2 || !bytes.HasPrefix(fields[1], []byte("a=")) {
+			return id, false
+		}
+		authzid, err := scramwire.Unescape(fields[1][2:])
+		if err != nil || len(authzid) == 0 {
+			return id, false
+		}
+		id.Identity = authzid
+		return id, true
+	}
+	return id, false
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+// relayNegotiate is like negotiate but passes every message through r.
+func relayNegotiate(r *Relay, client, server *Negotiator) error {
+	var challenge []byte
+	for {
+		_, resp, err := client.Step(challenge)
+		if err != nil {
+			return err
+		}
+		r.Response(resp)
+		more, c, err := server.Step(resp)
+		r.Challenge(c)
+		if err != nil {
+			r.Outcome(false)
+			return err
+		}
+		if !more {
+			r.Outcome(true)
+			return client.Finish(c)
+		}
+		challenge = c
+	}
+}
+
+func TestRelay(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	wrongPass := Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pen"), []byte("admin")
+	})
+	withAuthz := Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), []byte("admin")
+	})
+	for _, tc := range []struct {
+		name   string
+		client *Negotiator
+		server *Negotiator
+		ok     bool
+		id     AuthenticatedIdentity
+	}{
+		{
+			name:   "scram",
+			client: NewClient(ScramSha256, withAuthz),
+			server: NewServer(ScramSha256, acceptAll, Store(store)),
+			ok:     true,
+			id:     AuthenticatedIdentity{Username: []byte("user"), Identity: []byte("admin")},
+		},
+		{
+			name:   "scram failure",
+			client: NewClient(ScramSha256, wrongPass),
+			server: NewServer(ScramSha256, acceptAll, Store(store)),
+		},
+		{
+			name:   "plain",
+			client: NewClient(Plain, plainClientOpts...),
+			server: NewServer(Plain, acceptAll),
+			ok:     true,
+			id:     AuthenticatedIdentity{Username: []byte("Kurt"), Identity: []byte("Ursel")},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRelay(tc.client.Mechanism().Name)
+			err := relayNegotiate(r, tc.client, tc.server)
+			if (err == nil) != tc.ok {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !r.Done() {
+				t.Errorf("Expected the relay to be done")
+			}
+			id, ok := r.Identity()
+			if ok != tc.ok {
+				t.Fatalf("Unexpected ok: want=%t, got=%t", tc.ok, ok)
+			}
+			if ok && !reflect.DeepEqual(id, tc.id) {
+				t.Errorf("Unexpected identity: want=%+v, got=%+v", tc.id, id)
+			}
+		})
+	}
+}
+
+func TestRelayOAuthBearer(t *testing.T) {
+	r := NewRelay("OAUTHBEARER")
+	r.Response([]byte("n,a=user@example.com,\x01auth=Bearer token\x01\x01"))
+	if _, ok := r.Identity(); ok {
+		t.Fatalf("Expected no identity before the outcome")
+	}
+	r.Outcome(true)
+	id, ok := r.Identity()
+	if !ok || string(id.Identity) != "user@example.com" || id.Username != nil {
+		t.Errorf("Unexpected identity: ok=%t, id=%+v", ok, id)
+	}
+}
+
+func TestRelayMechanisms(t *testing.T) {
+	got := RelayMechanisms([]string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "PLAIN"})
+	if want := []string{"SCRAM-SHA-256", "PLAIN"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected mechanisms: want=%v, got=%v", want, got)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// ReplayError is returned by Replay when the negotiator does not behave the
+// same way as it did when the transcript was recorded.
+type ReplayError struct {
+	// Index is the index of the message in the transcript.
+	Index int
+
+	// Want is the recorded message and Got the message that was generated
+	// instead, redacted in the same way as the recorded message.
+	Want, Got RecordedMessage
+}
+
+func (e ReplayError) Error() string {
+	return fmt.Sprintf("Replayed message %d does not match the transcript: want %s, got %s", e.Index, describeMessage(e.Want), describeMessage(e.Got))
+}
+
+// Code returns CodeReplayMismatch.
+func (ReplayError) Code() Code {
+	return CodeReplayMismatch
+}
+
+func describeMessage(m RecordedMessage) string {
+	if m.Err != nil {
+		return fmt.Sprintf("error %q", m.Err)
+	}
+	if m.Data == nil && m.Length < 0 {
+		return "no message"
+	}
+	return fmt.Sprintf("%q", m.Data)
+}
+
+// Replay resets n and drives it with the messages that were received in
+// transcript, checking that each message it sends (or error it returns) is the
+// same as the one that was recorded.
+// This makes it possible to reproduce a failed negotiation against a server
+// that can not be reached from a debugging environment, for example to bisect
+// the change that broke it.
+//
+// The negotiator must be configured with the same credentials and options
+// that were in use when the transcript was recorded.
+// For SCRAM the nonce is taken from the transcript.
+// Received messages must not be redacted, so for mechanisms that send secrets
+// in both directions such as SCRAM the transcript must have been recorded with
+// Recorder.Unsafe set.
+//
+// If the negotiator sends a different message a ReplayError is returned, and
+// if a received message was redacted ErrRedacted is returned.
+func Replay(n *Negotiator, transcript []RecordedMessage) error {
+	n.Reset()
+	if nonce := transcriptNonce(n, transcript); nonce != nil {
+		n.nonce = nonce
+	}
+
+	var challenge []byte
+	for i, want := range transcript {
+		if want.Direction == Received {
+			if want.Redacted {
+				return fmt.Errorf("message %d: %w", i, ErrRedacted)
+			}
+			challenge = want.Data
+			continue
+		}
+
+		_, resp, err := n.Step(challenge)
+		challenge = nil
+		got := RecordedMessage{Direction: Sent, Length: -1, Err: err}
+		if resp != nil {
+			got.Length = len(resp)
+			got.Data = resp
+			if want.Redacted {
+				got.Data, got.Redacted = redactMessage(n.mechanism.Name, resp)
+			}
+		}
+		if !sameMessage(want, got) {
+			return ReplayError{Index: i, Want: want, Got: got}
+		}
+		if err != nil {
+			if i != len(transcript)-1 {
+				return ReplayError{Index: i + 1, Want: transcript[i+1], Got: RecordedMessage{Length: -1, Err: err}}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func sameMessage(want, got RecordedMessage) bool {
+	if (want.Err == nil) != (got.Err == nil) || (want.Err != nil && want.Err.Error() != got.Err.Error()) {
+		return false
+	}
+	return want.Length == got.Length && bytes.Equal(want.Data, got.Data)
+}
+
+// transcriptNonce returns the nonce that n used when the transcript was
+// recorded, if it can be determined.
+func transcriptNonce(n *Negotiator, transcript []RecordedMessage) []byte {
+	if !strings.HasPrefix(n.mechanism.Name, "SCRAM-") {
+		return nil
+	}
+	var clientNonce []byte
+	for _, m := range transcript {
+		nonce := scramAttr(m.Data, 'r')
+		switch {
+		case nonce == nil:
+		case !n.State().IsServer() && m.Direction == Sent:
+			return nonce
+		case n.State().IsServer() && m.Direction == Received && clientNonce == nil:
+			clientNonce = nonce
+		case n.State().IsServer() && m.Direction == Sent && clientNonce != nil:
+			return bytes.TrimPrefix(nonce, clientNonce)
+		}
+	}
+	return nil
+}
+
+// scramAttr returns the value of the attribute named name in msg, or nil if it
+// is not present.
+func scramAttr(msg []byte, name byte) []byte {
+	for _, field := range bytes.Split(msg, []byte{','}) {
+		if len(field) >= 2 && field[0] == name && field[1] == '=' {
+			return append([]byte{}, field[2:]...)
+		}
+	}
+	return nil
+}
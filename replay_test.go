@@ -0,0 +1,66 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	clientRec, serverRec := &Recorder{Unsafe: true}, &Recorder{Unsafe: true}
+	client := NewClient(ScramSha256, append(scramClientOpts, Record(clientRec))...)
+	server := NewServer(ScramSha256, acceptAll, Store(store), Record(serverRec))
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := Replay(NewClient(ScramSha256, scramClientOpts...), clientRec.Messages()); err != nil {
+		t.Errorf("Unexpected error replaying client: %v", err)
+	}
+	if err := Replay(NewServer(ScramSha256, acceptAll, Store(store)), serverRec.Messages()); err != nil {
+		t.Errorf("Unexpected error replaying server: %v", err)
+	}
+
+	wrong := NewClient(ScramSha256, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("wrong"), nil
+	}))
+	var mismatch ReplayError
+	if err := Replay(wrong, clientRec.Messages()); !errors.As(err, &mismatch) || mismatch.Index != 2 {
+		t.Errorf("Expected mismatch of client-final message, got %v", err)
+	}
+	if ErrorCode(mismatch) != CodeReplayMismatch {
+		t.Errorf("Unexpected code: %q", ErrorCode(mismatch))
+	}
+
+	// A safe SCRAM transcript can not be replayed past the redacted server
+	// signature.
+	safe := &Recorder{}
+	client = NewClient(ScramSha256, append(scramClientOpts, Record(safe))...)
+	if err := negotiate(client, NewServer(ScramSha256, acceptAll, Store(store))); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := Replay(NewClient(ScramSha256, scramClientOpts...), safe.Messages()); !errors.Is(err, ErrRedacted) {
+		t.Errorf("Expected ErrRedacted, got %v", err)
+	}
+}
+
+func TestReplayPlain(t *testing.T) {
+	rec := &Recorder{}
+	if err := negotiate(NewClient(Plain, append(plainClientOpts, Record(rec))...), NewServer(Plain, acceptAll)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := Replay(NewClient(Plain, plainClientOpts...), rec.Messages()); err != nil {
+		t.Errorf("Unexpected error replaying redacted PLAIN transcript: %v", err)
+	}
+	other := NewClient(Plain, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("Ursel"), []byte("xipj3plmq"), []byte("Kurt")
+	}))
+	if err := Replay(other, rec.Messages()); !errors.As(err, new(ReplayError)) {
+		t.Errorf("Expected mismatch for different username, got %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"io"
+	"sync"
+	"time"
+)
+
+// Defaults used by NewMemoryTokenStore.
+const (
+	// DefaultTokenTTL is how long tokens issued by a MemoryTokenStore are valid.
+	DefaultTokenTTL = 30 * 24 * time.Hour
+
+	// DefaultTokensPerUser is the number of tokens kept for each user by a
+	// MemoryTokenStore, which allows a user to have several devices.
+	DefaultTokensPerUser = 8
+
+	// tokenLen is the number of random bytes in a token issued by a
+	// MemoryTokenStore.
+	tokenLen = 32
+)
+
+// ResumptionToken is an opaque token issued to a client after it authenticated
+// that lets it authenticate again quickly, for example when reconnecting, with
+// a token based mechanism such as the HT-* family or a proprietary fast
+// reconnect scheme.
+type ResumptionToken struct {
+	// Mechanism is the name of the mechanism that the token may be used with.
+	Mechanism string
+
+	// Token is the secret that is sent to the client.
+	Token []byte
+
+	// Expires is when the token stops being accepted.
+	Expires time.Time
+}
+
+// A TokenStore mints and checks resumption tokens.
+// Implementations must be safe for concurrent use.
+type TokenStore interface {
+	// IssueToken returns a new token for use with mechanism that authenticates
+	// id.
+	IssueToken(ctx context.Context, mechanism string, id AuthenticatedIdentity) (ResumptionToken, error)
+
+	// CheckToken calls match with each unexpired token issued for mechanism to
+	// username until it returns true and then returns the identity that the
+	// token was issued to.
+	// Mechanisms that do not send the token itself, such as those that send a
+	// keyed hash of it, compute the expected message in match.
+	// If no token matches the returned error wraps ErrAuthn.
+	CheckToken(ctx context.Context, mechanism string, username []byte, match func(token []byte) bool) (AuthenticatedIdentity, error)
+}
+
+// ResumptionTokens sets the store used by servers to issue resumption tokens
+// with IssueToken and by token based mechanisms to check them with
+// CheckToken.
+func ResumptionTokens(s TokenStore) Option {
+	return func(n *Negotiator) {
+		n.tokenStore = s
+	}
+}
+
+// IssueToken returns a new resumption token for use with mechanism that
+// authenticates the identity established by the negotiation, for the protocol
+// to send to the client along with (or after) its success message.
+//
+// It must only be called on servers that have completed a negotiation and
+// have a token store set with the ResumptionTokens option, otherwise it
+// returns ErrInvalidState.
+func (c *Negotiator) IssueToken(ctx context.Context, mechanism string) (ResumptionToken, error) {
+	if c.tokenStore == nil || !c.isServer || !c.completed {
+		return ResumptionToken{}, ErrInvalidState
+	}
+	id, ok := c.Identity()
+	if !ok {
+		return ResumptionToken{}, ErrInvalidState
+	}
+	return c.tokenStore.IssueToken(ctx, mechanism, id)
+}
+
+// CheckToken is used by token based server mechanisms to find the token that
+// a client authenticated with using the store set by the ResumptionTokens
+// option (see TokenStore).
+// If no store is set the error is ErrAuthn.
+// The returned identity must still be approved by Permissions.
+func (c *Negotiator) CheckToken(username []byte, match func(token []byte) bool) (AuthenticatedIdentity, error) {
+	if c.tokenStore == nil {
+		return AuthenticatedIdentity{}, ErrAuthn
+	}
+	return c.tokenStore.CheckToken(c.Context(), c.mechanism.Name, username, match)
+}
+
+// MemoryTokenStore is a TokenStore that keeps tokens in memory, which is
+// suitable for servers that run as a single process or that use sticky
+// sessions.
+// Tokens are random and a limited number of the most recent tokens are kept for
+// each user, so issuing a token to a user with too many evicts their oldest.
+type MemoryTokenStore struct {
+	ttl     time.Duration
+	perUser int
+	now     func() time.Time
+
+	mu    sync.Mutex
+	users map[string][]storedToken
+}
+
+type storedToken struct {
+	ResumptionToken
+	id AuthenticatedIdentity
+}
+
+// NewMemoryTokenStore returns a token store that issues tokens that are valid
+// for ttl and keeps at most perUser tokens for each user.
+// A ttl or perUser of zero or less uses DefaultTokenTTL or
+// DefaultTokensPerUser respectively.
+func NewMemoryTokenStore(ttl time.Duration, perUser int) *MemoryTokenStore {
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	if perUser <= 0 {
+		perUser = DefaultTokensPerUser
+	}
+	return &MemoryTokenStore{
+		ttl:     ttl,
+		perUser: perUser,
+		now:     time.Now,
+	}
+}
+
+// IssueToken implements TokenStore.
+func (s *MemoryTokenStore) IssueToken(_ context.Context, mechanism string, id AuthenticatedIdentity) (ResumptionToken, error) {
+	token := make([]byte, tokenLen)
+	if _, err := io.ReadFull(entropy, token); err != nil {
+		return ResumptionToken{}, err
+	}
+	now := s.now()
+	t := storedToken{
+		ResumptionToken: ResumptionToken{Mechanism: mechanism, Token: token, Expires: now.Add(s.ttl)},
+		id: AuthenticatedIdentity{
+			Username: bytes.Clone(id.Username),
+			Identity: bytes.Clone(id.Identity),
+		},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users == nil {
+		s.users = make(map[string][]storedToken)
+	}
+	key := string(id.Username)
+	tokens := liveTokens(s.users[key], now)
+	if len(tokens) >= s.perUser {
+		tokens = tokens[len(tokens)-s.perUser+1:]
+	}
+	s.users[key] = append(tokens, t)
+	return ResumptionToken{Mechanism: mechanism, Token: bytes.Clone(token), Expires: t.Expires}, nil
+}
+
+// CheckToken implements TokenStore.
+func (s *MemoryTokenStore) CheckToken(_ context.Context, mechanism string, username []byte, match func(token []byte) bool) (AuthenticatedIdentity, error) {
+	now := s.now()
+	key := string(username)
+	s.mu.Lock()
+	tokens := liveTokens(s.users[key], now)
+	// Drop expired tokens so that users who never reconnect do not keep them
+	// forever.
+	if len(tokens) == 0 {
+		delete(s.users, key)
+	} else {
+		s.users[key] = tokens
+	}
+	s.mu.Unlock()
+	for _, t := range tokens {
+		if t.Mechanism == mechanism && match(bytes.Clone(t.Token)) {
+			return t.id, nil
+		}
+	}
+	return AuthenticatedIdentity{}, ErrAuthn
+}
+
+// Revoke discards all tokens issued to username, for example when the user
+// changes their password or logs out of all devices.
+func (s *MemoryTokenStore) Revoke(username []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, string(username))
+}
+
+// RevokeToken discards token, for example when the user logs out of one device.
+func (s *MemoryTokenStore) RevokeToken(token []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for user, tokens := range s.users {
+		for i, t := range tokens {
+			if subtle.ConstantTimeCompare(t.Token, token) == 1 {
+				s.users[user] = append(tokens[:i:i], tokens[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// liveTokens returns a copy of the tokens that have not expired at now.
+func liveTokens(tokens []storedToken, now time.Time) []storedToken {
+	out := make([]storedToken, 0, len(tokens))
+	for _, t := range tokens {
+		if now.Before(t.Expires) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
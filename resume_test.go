@@ -0,0 +1,116 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+)
+
+// tokenMech is a minimal token based mechanism in the style of the HT-*
+// mechanisms: the client sends its username and an HMAC keyed with the token.
+var tokenMech = Mechanism{
+	Name: "X-TEST-TOKEN",
+	Start: func(m *Negotiator) (bool, []byte, interface{}, error) {
+		username, token, _ := m.Credentials()
+		return false, append(append(username, 0), tokenMAC(token)...), nil, nil
+	},
+	Next: func(m *Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		username, mac, ok := bytes.Cut(challenge, []byte{0})
+		if !m.State().IsServer() || !ok {
+			return false, nil, nil, ErrInvalidChallenge
+		}
+		id, err := m.CheckToken(username, func(token []byte) bool {
+			return hmac.Equal(tokenMAC(token), mac)
+		})
+		if err != nil {
+			return false, nil, nil, err
+		}
+		if !m.Permissions(Credentials(func() ([]byte, []byte, []byte) {
+			return id.Username, nil, id.Identity
+		})) {
+			return false, nil, nil, ErrAuthn
+		}
+		return false, nil, nil, nil
+	},
+}
+
+func tokenMAC(token []byte) []byte {
+	h := hmac.New(sha256.New, token)
+	h.Write([]byte("Initiator"))
+	return h.Sum(nil)
+}
+
+func TestResumptionTokens(t *testing.T) {
+	store := NewMemoryTokenStore(time.Hour, 2)
+	now := time.Unix(1000, 0)
+	store.now = func() time.Time { return now }
+
+	server := NewServer(Plain, acceptAll, ResumptionTokens(store))
+	if _, err := server.IssueToken(context.Background(), tokenMech.Name); err != ErrInvalidState {
+		t.Fatalf("Expected ErrInvalidState before negotiation, got %v", err)
+	}
+	if err := negotiate(NewClient(Plain, plainClientOpts...), server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tok, err := server.IssueToken(context.Background(), tokenMech.Name)
+	if err != nil {
+		t.Fatalf("Error issuing token: %v", err)
+	}
+	if tok.Mechanism != tokenMech.Name || len(tok.Token) != tokenLen || !tok.Expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Unexpected token: %+v", tok)
+	}
+
+	resume := func(token []byte) (*Negotiator, error) {
+		client := NewClient(tokenMech, Credentials(func() ([]byte, []byte, []byte) {
+			return []byte("Kurt"), token, nil
+		}))
+		server := NewServer(tokenMech, acceptAll, ResumptionTokens(store))
+		return server, negotiate(client, server)
+	}
+	s, err := resume(tok.Token)
+	if err != nil {
+		t.Fatalf("Error resuming with token: %v", err)
+	}
+	if id, _ := s.Identity(); string(id.Username) != "Kurt" || string(id.Identity) != "Ursel" {
+		t.Errorf("Wrong identity after resumption: %+v", id)
+	}
+	if _, err = resume([]byte("wrong")); !errors.Is(err, ErrAuthn) {
+		t.Errorf("Expected ErrAuthn for wrong token, got %v", err)
+	}
+
+	// Issuing more tokens than the limit evicts the oldest.
+	for i := 0; i < 2; i++ {
+		if _, err = server.IssueToken(context.Background(), tokenMech.Name); err != nil {
+			t.Fatalf("Error issuing token: %v", err)
+		}
+	}
+	if _, err = resume(tok.Token); !errors.Is(err, ErrAuthn) {
+		t.Errorf("Expected evicted token to be rejected, got %v", err)
+	}
+
+	tok, _ = server.IssueToken(context.Background(), tokenMech.Name)
+	store.RevokeToken(tok.Token)
+	if _, err = resume(tok.Token); !errors.Is(err, ErrAuthn) {
+		t.Errorf("Expected revoked token to be rejected, got %v", err)
+	}
+
+	tok, _ = server.IssueToken(context.Background(), tokenMech.Name)
+	store.Revoke([]byte("Kurt"))
+	if _, err = resume(tok.Token); !errors.Is(err, ErrAuthn) {
+		t.Errorf("Expected tokens of revoked user to be rejected, got %v", err)
+	}
+
+	tok, _ = server.IssueToken(context.Background(), tokenMech.Name)
+	now = now.Add(time.Hour)
+	if _, err = resume(tok.Token); !errors.Is(err, ErrAuthn) {
+		t.Errorf("Expected expired token to be rejected, got %v", err)
+	}
+}
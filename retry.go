@@ -0,0 +1,93 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Defaults used by Retry for zero fields of a RetryPolicy.
+const (
+	DefaultRetryAttempts  = 3
+	DefaultInitialBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff     = 10 * time.Second
+)
+
+// RetryPolicy controls how Retry retries failed negotiations.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first.
+	// If it is zero DefaultRetryAttempts is used.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.
+	// The delay doubles after each retry up to MaxBackoff and a random jitter of
+	// up to half the delay is subtracted from it so that many clients that
+	// failed at the same time do not retry at the same time.
+	// If they are zero DefaultInitialBackoff and DefaultMaxBackoff are used.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Refresh is called when an attempt fails with an error that is not
+	// temporary.
+	// If it returns true (for example, because it obtained a new access token or
+	// reloaded a password that was changed) the negotiation is retried
+	// immediately, otherwise the error is returned.
+	// Refresh is called at most once per call to Retry.
+	Refresh func(err error) bool
+}
+
+// Retry calls attempt until it succeeds, returns an error that is not
+// temporary (see IsTemporary and RetryPolicy.Refresh), or the policy's
+// maximum number of attempts is reached.
+// Attempt is expected to run a complete exchange, including connecting to the
+// server if necessary, and is passed ctx.
+//
+// Retry returns the error from the last attempt.
+// If ctx is canceled while waiting to retry the returned error wraps both the
+// last error and the error from ctx.
+func Retry(ctx context.Context, p RetryPolicy, attempt func(context.Context) error) error {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultMaxBackoff
+	}
+
+	backoff := p.InitialBackoff
+	refreshed := false
+	var err error
+	for i := 0; i < p.MaxAttempts; i++ {
+		if err = attempt(ctx); err == nil {
+			return nil
+		}
+		if i == p.MaxAttempts-1 {
+			break
+		}
+		if !IsTemporary(err) {
+			if refreshed || p.Refresh == nil || !p.Refresh(err) {
+				return err
+			}
+			refreshed = true
+			continue
+		}
+
+		delay := backoff - time.Duration(rand.Int63n(int64(backoff/2)+1))
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-t.C:
+		}
+		backoff = min(2*backoff, p.MaxBackoff)
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	errBusy := Temporary(errors.New("busy"))
+	fast := RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	for _, tc := range []struct {
+		name     string
+		policy   RetryPolicy
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{name: "success", policy: fast, errs: []error{nil}, attempts: 1},
+		{name: "temporary", policy: fast, errs: []error{errBusy, errBusy, nil}, attempts: 3},
+		{name: "exhausted", policy: fast, errs: []error{errBusy, errBusy, errBusy, nil}, attempts: 3, err: errBusy},
+		{name: "permanent", policy: fast, errs: []error{ErrAuthn, nil}, attempts: 1, err: ErrAuthn},
+		{
+			name: "refresh",
+			policy: RetryPolicy{InitialBackoff: time.Millisecond, Refresh: func(err error) bool {
+				return errors.Is(err, ErrAuthn)
+			}},
+			errs:     []error{ErrAuthn, nil},
+			attempts: 2,
+		},
+		{
+			name: "refresh-once",
+			policy: RetryPolicy{InitialBackoff: time.Millisecond, Refresh: func(error) bool {
+				return true
+			}},
+			errs:     []error{ErrAuthn, ErrAuthn, nil},
+			attempts: 2,
+			err:      ErrAuthn,
+		},
+		{
+			name:     "max-attempts",
+			policy:   RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+			errs:     []error{errBusy, errBusy, nil},
+			attempts: 2,
+			err:      errBusy,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			err := Retry(context.Background(), tc.policy, func(context.Context) error {
+				err := tc.errs[attempts]
+				attempts++
+				return err
+			})
+			if !errors.Is(err, tc.err) || (tc.err == nil && err != nil) {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if attempts != tc.attempts {
+				t.Errorf("Unexpected number of attempts: want=%d, got=%d", tc.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errBusy := Temporary(errors.New("busy"))
+	err := Retry(ctx, RetryPolicy{InitialBackoff: time.Hour}, func(context.Context) error {
+		cancel()
+		return errBusy
+	})
+	if !errors.Is(err, errBusy) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected busy and canceled errors, got %v", err)
+	}
+}
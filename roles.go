@@ -0,0 +1,310 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+)
+
+// ServerState is the state of a ServerNegotiator.
+// Unlike State it only has the steps that make sense for a server.
+type ServerState uint8
+
+// States of a ServerNegotiator.
+const (
+	// AwaitingAuth means that no response has been received from the client
+	// since the negotiator was created or reset.
+	AwaitingAuth ServerState = iota
+
+	// ChallengeSent means that a challenge was sent and the server is waiting
+	// for the next response from the client.
+	ChallengeSent
+
+	// ServerSucceeded means that the client was authenticated.
+	ServerSucceeded
+
+	// ServerFailed means that the negotiation failed and the negotiator must be
+	// reset before it is reused.
+	ServerFailed
+
+	// ServerAborted means that the negotiation was ended with Abort and the
+	// negotiator must be reset before it is reused.
+	ServerAborted
+)
+
+func (s ServerState) String() string {
+	switch s {
+	case AwaitingAuth:
+		return "awaiting auth"
+	case ChallengeSent:
+		return "challenge sent"
+	case ServerSucceeded:
+		return "succeeded"
+	case ServerFailed:
+		return "failed"
+	case ServerAborted:
+		return "aborted"
+	}
+	return "unknown"
+}
+
+// roleMachine is the state machine of one side of a negotiation.
+// A Negotiator delegates its steps to the machine of its role so that the
+// client and server sequences are kept apart.
+type roleMachine interface {
+	// next handles one message from the remote side.
+	next(c *Negotiator, msg []byte) (more bool, resp []byte, err error)
+
+	// step returns the step bits of State, including Receiving for servers.
+	step() State
+
+	// atStart reports whether nothing has been received or sent.
+	atStart() bool
+
+	// fail records that the negotiation failed or was aborted.
+	fail(aborted bool)
+}
+
+// clientMachine is the state machine of a client.
+// It is a value so that it is copied along with the negotiator by the step
+// timeout and by Clone.
+type clientMachine struct {
+	cur            State
+	deferredResp   []byte
+	deferredMore   bool
+	serverVerified bool
+}
+
+func (m *clientMachine) next(c *Negotiator, challenge []byte) (more bool, resp []byte, err error) {
+	// A client that completed with its initial response has not received
+	// anything from the server yet, so the first challenge is still passed to
+	// the mechanism (for example, an OAUTHBEARER error document).
+	if c.completed && m.cur != AuthTextSent {
+		return c.stepCompleted(challenge)
+	}
+
+	switch m.cur {
+	case Initial:
+		if err = c.checkPin(); err != nil {
+			return false, nil, err
+		}
+		more, resp, c.cache, err = c.run(true, nil, nil)
+		m.cur = AuthTextSent
+		if err == nil && c.noInitialResp && resp != nil {
+			// Hold the initial response until the server sends an empty challenge.
+			m.deferredResp, m.deferredMore = resp, more
+			more, resp = true, nil
+		}
+	case AuthTextSent:
+		if m.deferredResp != nil {
+			more, resp = m.deferredMore, m.deferredResp
+			m.deferredResp = nil
+			if len(challenge) > 0 {
+				err = TransitionError{Mechanism: c.mechanism.Name, Got: "non-empty challenge", Want: "empty challenge"}
+			}
+			break
+		}
+		more, resp, c.cache, err = c.run(false, challenge, c.cache)
+		m.cur = ResponseSent
+	case ResponseSent:
+		more, resp, c.cache, err = c.run(false, challenge, c.cache)
+		m.cur = ValidServerResponse
+	case ValidServerResponse:
+		more, resp, c.cache, err = c.run(false, challenge, c.cache)
+	}
+
+	if err == nil && !more && c.requireMutual && !m.serverVerified {
+		// Discard the response so that credentials from mechanisms like PLAIN are
+		// never sent.
+		err = ErrMutualAuth
+	}
+	more, err = c.endStep(more, err)
+	if c.completed {
+		username, _, identity := c.Credentials()
+		c.setNegotiatedID(username, identity)
+	}
+	return more, resp, err
+}
+
+func (m *clientMachine) step() State {
+	return m.cur
+}
+
+func (m *clientMachine) atStart() bool {
+	return m.cur == Initial
+}
+
+// fail does nothing since client failures are only recorded by the Errored
+// and Aborted bits of State.
+func (m *clientMachine) fail(aborted bool) {}
+
+// serverMachine is the state machine of a server.
+// Servers begin by receiving the client's initial response so there is no
+// start step.
+type serverMachine struct {
+	state     ServerState
+	responses int
+}
+
+func (m *serverMachine) next(c *Negotiator, response []byte) (more bool, challenge []byte, err error) {
+	if c.completed {
+		return c.stepCompleted(response)
+	}
+	more, challenge, c.cache, err = c.run(false, response, c.cache)
+	m.responses++
+	more, err = c.endStep(more, err)
+	switch {
+	case err != nil:
+		m.state = ServerFailed
+	case more:
+		m.state = ChallengeSent
+	default:
+		m.state = ServerSucceeded
+	}
+	return more, challenge, err
+}
+
+// step maps the number of responses that were received to the steps of
+// State.
+func (m *serverMachine) step() State {
+	switch m.responses {
+	case 0:
+		return AuthTextSent | Receiving
+	case 1:
+		return ResponseSent | Receiving
+	}
+	return ValidServerResponse | Receiving
+}
+
+func (m *serverMachine) atStart() bool {
+	return m.state == AwaitingAuth
+}
+
+func (m *serverMachine) fail(aborted bool) {
+	if aborted {
+		m.state = ServerAborted
+		return
+	}
+	m.state = ServerFailed
+}
+
+// ClientNegotiator is a Negotiator that can only be used as a client.
+// It only has the methods that make sense for clients, so code that is
+// written against it cannot call server methods by mistake.
+type ClientNegotiator struct {
+	n *Negotiator
+}
+
+// NewClientNegotiator is like NewClient except that it returns a
+// ClientNegotiator.
+func NewClientNegotiator(m Mechanism, opts ...Option) *ClientNegotiator {
+	return &ClientNegotiator{n: NewClient(m, opts...)}
+}
+
+// Negotiator returns the underlying negotiator, for example to pass it to
+// functions that take a Negotiator.
+func (c *ClientNegotiator) Negotiator() *Negotiator {
+	return c.n
+}
+
+// Step is like the Step method on Negotiator.
+func (c *ClientNegotiator) Step(challenge []byte) (more bool, resp []byte, err error) {
+	return c.n.StepContext(context.Background(), challenge)
+}
+
+// StepContext is like the StepContext method on Negotiator.
+func (c *ClientNegotiator) StepContext(ctx context.Context, challenge []byte) (more bool, resp []byte, err error) {
+	return c.n.StepContext(ctx, challenge)
+}
+
+// Finish is like the Finish method on Negotiator.
+func (c *ClientNegotiator) Finish(data []byte) error {
+	return c.n.Finish(data)
+}
+
+// State returns the state of the client.
+func (c *ClientNegotiator) State() State {
+	return c.n.State()
+}
+
+// Completed reports whether the mechanism has nothing more to send.
+// It does not mean that the server was authenticated, see Authenticated.
+func (c *ClientNegotiator) Completed() bool {
+	return c.n.Completed()
+}
+
+// Authenticated reports whether the negotiation completed and the server was
+// verified.
+func (c *ClientNegotiator) Authenticated() bool {
+	return c.n.Authenticated()
+}
+
+// Abort is like the Abort method on Negotiator.
+// Clients have no message to send when they abort, so it returns nothing.
+func (c *ClientNegotiator) Abort() {
+	c.n.Abort()
+}
+
+// Reset is like the Reset method on Negotiator.
+func (c *ClientNegotiator) Reset() {
+	c.n.Reset()
+}
+
+// ServerNegotiator is a Negotiator that can only be used as a server.
+// It reports its progress with ServerState instead of the client oriented
+// steps of State.
+type ServerNegotiator struct {
+	n *Negotiator
+}
+
+// NewServerNegotiator is like NewServer except that it returns a
+// ServerNegotiator.
+func NewServerNegotiator(m Mechanism, permissions func(*Negotiator) bool, opts ...Option) *ServerNegotiator {
+	return &ServerNegotiator{n: NewServer(m, permissions, opts...)}
+}
+
+// Negotiator returns the underlying negotiator, for example to pass it to
+// functions that take a Negotiator.
+func (s *ServerNegotiator) Negotiator() *Negotiator {
+	return s.n
+}
+
+// Step is like the Step method on Negotiator except that it takes the client's
+// response and returns the next challenge.
+func (s *ServerNegotiator) Step(response []byte) (more bool, challenge []byte, err error) {
+	return s.n.StepContext(context.Background(), response)
+}
+
+// StepContext is like Step except that ctx is made available to the mechanism
+// and its callbacks as described on the StepContext method of Negotiator.
+func (s *ServerNegotiator) StepContext(ctx context.Context, response []byte) (more bool, challenge []byte, err error) {
+	return s.n.StepContext(ctx, response)
+}
+
+// State returns the state of the server.
+func (s *ServerNegotiator) State() ServerState {
+	return s.n.server.state
+}
+
+// Authenticated reports whether the client was authenticated.
+func (s *ServerNegotiator) Authenticated() bool {
+	return s.n.Completed()
+}
+
+// Identity returns the identity of the client once it has been
+// authenticated.
+func (s *ServerNegotiator) Identity() (id AuthenticatedIdentity, ok bool) {
+	return s.n.Identity()
+}
+
+// Abort is like the Abort method on Negotiator.
+func (s *ServerNegotiator) Abort() []byte {
+	return s.n.Abort()
+}
+
+// Reset is like the Reset method on Negotiator.
+func (s *ServerNegotiator) Reset() {
+	s.n.Reset()
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestServerNegotiatorState(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	client := NewClientNegotiator(ScramSha256, scramClientOpts...)
+	server := NewServerNegotiator(ScramSha256, acceptAll, Store(store))
+	if s := server.State(); s != AwaitingAuth {
+		t.Fatalf("Wrong initial state: want=%v, got=%v", AwaitingAuth, s)
+	}
+
+	_, resp, err := client.Step(nil)
+	if err != nil {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+	_, challenge, err := server.Step(resp)
+	if err != nil {
+		t.Fatalf("Unexpected server error: %v", err)
+	}
+	if s := server.State(); s != ChallengeSent {
+		t.Fatalf("Wrong state after first step: want=%v, got=%v", ChallengeSent, s)
+	}
+	if _, resp, err = client.Step(challenge); err != nil {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+	more, challenge, err := server.Step(resp)
+	if err != nil || more {
+		t.Fatalf("Expected server to finish: more=%t, err=%v", more, err)
+	}
+	if s := server.State(); s != ServerSucceeded || !server.Authenticated() {
+		t.Fatalf("Wrong state after success: want=%v, got=%v", ServerSucceeded, s)
+	}
+	if err = client.Finish(challenge); err != nil || !client.Authenticated() {
+		t.Fatalf("Expected client to verify server, got %v", err)
+	}
+	if id, ok := server.Identity(); !ok || string(id.Username) != "user" {
+		t.Errorf("Wrong identity: %+v", id)
+	}
+
+	server.Reset()
+	if s := server.State(); s != AwaitingAuth {
+		t.Fatalf("Wrong state after reset: want=%v, got=%v", AwaitingAuth, s)
+	}
+	if _, _, err = server.Step([]byte("garbage")); err == nil {
+		t.Fatalf("Expected error for malformed response")
+	}
+	if s := server.State(); s != ServerFailed {
+		t.Errorf("Wrong state after error: want=%v, got=%v", ServerFailed, s)
+	}
+
+	server.Reset()
+	server.Abort()
+	if s := server.State(); s != ServerAborted {
+		t.Errorf("Wrong state after abort: want=%v, got=%v", ServerAborted, s)
+	}
+	if state := server.Negotiator().State(); !state.IsServer() || !state.Aborted() {
+		t.Errorf("Wrong negotiator state after abort: %#x", uint8(state))
+	}
+}
+
+func TestResetKeepsRole(t *testing.T) {
+	for _, n := range []*Negotiator{
+		NewClient(Plain, plainClientOpts...),
+		NewServer(Plain, acceptAll),
+	} {
+		want := n.State()
+		n.Step([]byte("garbage"))
+		n.Reset()
+		if got := n.State(); got != want {
+			t.Errorf("Wrong state after reset: want=%v, got=%v", want, got)
+		}
+	}
+}
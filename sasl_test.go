@@ -5,11 +5,17 @@
 package sasl
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // saslStep is from the perspective of a client, challenge is issued by the
@@ -56,6 +62,10 @@ var (
 	})}
 )
 
+var scramClientOpts = []Option{Credentials(func() ([]byte, []byte, []byte) {
+	return []byte("user"), []byte("pencil"), []byte{}
+})}
+
 func acceptAll(_ *Negotiator) bool {
 	return true
 }
@@ -316,6 +326,154 @@ var saslTestCases = [...]saslTest{
 			{resp: []byte("Ursel\x00Kurt\x00xipj3plmq\x00"), serverErr: true, more: false},
 		},
 	},
+	16: {
+		skipClient: true,
+		mechanism:  plain,
+		perm:       acceptAll,
+		serverOpts: []Option{MaxMessageSize(len(plainResp) - 1)},
+		steps: []saslStep{
+			{resp: plainResp, serverErr: true, more: false},
+		},
+	},
+	17: {
+		skipServer: true,
+		mechanism:  scram("SCRAM-SHA-1", sha1.New),
+		clientOpts: []Option{
+			Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte("pencil"), []byte{}
+			}),
+			MaxMessageSize(16),
+		},
+		steps: []saslStep{
+			{
+				resp: []byte(`n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL`),
+				more: true,
+			},
+			{
+				challenge: []byte(`r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096`),
+				clientErr: true,
+			},
+		},
+	},
+	18: {
+		skipServer: true,
+		mechanism:  scram("SCRAM-SHA-1", sha1.New),
+		clientOpts: []Option{
+			Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte("pencil"), []byte("ignored")
+			}),
+			AuthorizationIdentity([]byte("ad,m=in")),
+		},
+		steps: []saslStep{
+			{
+				resp: []byte(`n,a=ad=2Cm=3Din,n=user,r=fyko+d2lbbFgONRv9qkxdawL`),
+				more: true,
+			},
+		},
+	},
+	19: {
+		skipServer: true,
+		mechanism:  plain,
+		clientOpts: append([]Option{AuthorizationIdentity([]byte("admin"))}, plainClientOpts...),
+		steps: []saslStep{
+			{resp: []byte("admin\x00Kurt\x00xipj3plmq"), more: false},
+		},
+	},
+	20: {
+		mechanism: plain,
+		perm: func(n *Negotiator) bool {
+			user, pass, _ := n.Credentials()
+			return string(user) == "IX" && string(pass) == "a b"
+		},
+		clientOpts: []Option{
+			PlainSASLprep(),
+			Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("I\u00ADX"), []byte("a\u00A0b"), nil
+			}),
+		},
+		serverOpts: []Option{PlainSASLprep()},
+		steps: []saslStep{
+			{resp: []byte("\x00IX\x00a b"), more: false},
+		},
+	},
+	21: {
+		skipServer: true,
+		mechanism:  plain,
+		clientOpts: append([]Option{RequireMutualAuth()}, plainClientOpts...),
+		steps: []saslStep{
+			{resp: nil, clientErr: true, more: false},
+		},
+	},
+	22: {
+		skipServer: true,
+		mechanism:  scram("SCRAM-SHA-1", sha1.New),
+		clientOpts: append([]Option{RequireMutualAuth()}, scramClientOpts...),
+		steps: []saslStep{
+			{
+				resp: []byte(`n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL`),
+				more: true,
+			},
+			{
+				challenge: []byte(`r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096`),
+				resp:      []byte(`c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=`),
+				more:      true,
+			},
+			{
+				challenge: []byte(`v=rmF9pqV8S7suAoZWja4dJRkFsKQ=`),
+				resp:      nil,
+				more:      false,
+			},
+		},
+	},
+	23: {
+		mechanism:  plain,
+		perm:       acceptAll,
+		clientOpts: append([]Option{FIPS()}, plainClientOpts...),
+		serverOpts: []Option{FIPS()},
+		steps: []saslStep{
+			{resp: nil, clientErr: true, serverErr: true, more: false},
+		},
+	},
+	24: {
+		skipServer: true,
+		mechanism:  scram("SCRAM-SHA-256", sha256.New),
+		clientOpts: append([]Option{FIPS()}, scramClientOpts...),
+		steps: []saslStep{
+			{
+				resp: []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL"),
+				more: true,
+			},
+		},
+	},
+	25: {
+		mechanism: plain,
+		perm: func(n *Negotiator) bool {
+			user, pass, _ := n.Credentials()
+			return string(user) == "kurt" && string(pass) == "XIPJ"
+		},
+		clientOpts: []Option{
+			PlainSASLprep(),
+			Normalize(legacyFold, func(b []byte) ([]byte, error) {
+				return bytes.ToUpper(b), nil
+			}),
+			Credentials(func() ([]byte, []byte, []byte) {
+				return []byte(" Kurt "), []byte("xipj"), nil
+			}),
+		},
+		serverOpts: []Option{PlainSASLprep(), Normalize(legacyFold, nil)},
+		steps: []saslStep{
+			{resp: []byte("\x00kurt\x00XIPJ"), more: false},
+		},
+	},
+}
+
+// legacyFold is a normalization function like those applied by some legacy user
+// databases.
+func legacyFold(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty username")
+	}
+	return bytes.ToLower(bytes.TrimSpace(b)), nil
 }
 
 func testClient(t *testing.T, client *Negotiator, tc saslTest, run int) {
@@ -381,18 +539,14 @@ func testServer(t *testing.T, server *Negotiator, tc saslTest, run int) {
 func TestSASL(t *testing.T) {
 	for i, tc := range saslTestCases {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			client := NewClient(tc.mechanism, tc.clientOpts...)
-			server := NewServer(tc.mechanism, tc.perm, tc.serverOpts...)
+			// Use the nonce from all of our test vectors.
+			fixedNonce := NonceSource(func() []byte { return testNonce })
+			client := NewClient(tc.mechanism, append(tc.clientOpts[:len(tc.clientOpts):len(tc.clientOpts)], fixedNonce)...)
+			server := NewServer(tc.mechanism, tc.perm, append(tc.serverOpts[:len(tc.serverOpts):len(tc.serverOpts)], fixedNonce)...)
 
 			// Run each test twice to make sure that Reset actually sets the state back
 			// to the initial state.
 			for run := 1; run < 3; run++ {
-				// Reset the nonce to the one used by all of our test vectors.
-				// TODO: this is an internal state detail. Instead of mutating it, make
-				// an option to set the RNG and pass in a dummy one.
-				client.nonce = testNonce
-				server.nonce = testNonce
-
 				if !tc.skipClient {
 					testClient(t, client, tc, run)
 				}
@@ -406,3 +560,672 @@ func TestSASL(t *testing.T) {
 		})
 	}
 }
+
+func TestCredentialsLoadedOncePerNegotiation(t *testing.T) {
+	calls := 0
+	client := NewClient(scram("SCRAM-SHA-1", sha1.New), Credentials(func() ([]byte, []byte, []byte) {
+		calls++
+		return []byte("user" + strconv.Itoa(calls)), []byte("pencil"), nil
+	}))
+	client.nonce = testNonce
+
+	for run := 1; run < 3; run++ {
+		_, resp, err := client.Step(nil)
+		if err != nil {
+			t.Fatalf("Run %d: unexpected error: %v", run, err)
+		}
+		if want := "n,,n=user" + strconv.Itoa(run) + ",r=" + string(testNonce); string(resp) != want {
+			t.Errorf("Run %d: expected response `%s', got `%s'", run, want, resp)
+		}
+		if calls != run {
+			t.Errorf("Run %d: expected credentials to be loaded %d times, got %d", run, run, calls)
+		}
+		client.Reset()
+		client.nonce = testNonce
+	}
+}
+
+func TestWipeSecrets(t *testing.T) {
+	var pass []byte
+	client := NewClient(plain, WipeSecrets(), Credentials(func() ([]byte, []byte, []byte) {
+		pass = []byte("xipj3plmq")
+		return []byte("Kurt"), pass, []byte("Ursel")
+	}))
+	_, resp, err := client.Step(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(resp) != string(plainResp) {
+		t.Errorf("Got invalid response text:\nexpected `%s'\n     got `%s'", plainResp, resp)
+	}
+	for _, b := range pass {
+		if b != 0 {
+			t.Fatalf("Expected password to be wiped, got %q", pass)
+		}
+	}
+}
+
+func TestStringRedactsCredentials(t *testing.T) {
+	client := NewClient(plain, plainClientOpts...)
+	if _, _, err := client.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wrapper := struct{ N Negotiator }{N: *client}
+	for _, v := range []interface{}{client, *client, wrapper, &wrapper} {
+		for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+			if s := fmt.Sprintf(verb, v); strings.Contains(s, "xipj3plmq") {
+				t.Errorf("Formatting %T with %s leaked the password: %s", v, verb, s)
+			}
+		}
+	}
+}
+
+func TestCompletedAndAuthenticated(t *testing.T) {
+	for _, i := range []int{0, 1, 8} {
+		tc := saslTestCases[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			n := NewClient(tc.mechanism, tc.clientOpts...)
+			n.nonce = testNonce
+			more := true
+			for _, step := range tc.steps {
+				if !more {
+					break
+				}
+				var err error
+				more, _, err = n.Step(step.challenge)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if !n.Completed() {
+				t.Error("Expected negotiation to be completed")
+			}
+			isScram := tc.mechanism.Name != plain.Name
+			if n.Authenticated() != isScram {
+				t.Errorf("Unexpected value for Authenticated: %v", n.Authenticated())
+			}
+			n.Reset()
+			if n.Completed() || n.Authenticated() {
+				t.Error("Expected Reset to clear completion state")
+			}
+		})
+	}
+}
+
+func TestOnStateChange(t *testing.T) {
+	type transition struct {
+		old, new State
+	}
+	var got []transition
+	client := NewClient(plain, append([]Option{OnStateChange(func(mech string, old, new State) {
+		if mech != plain.Name {
+			t.Errorf("Wrong mechanism name: want=%q, got=%q", plain.Name, mech)
+		}
+		got = append(got, transition{old: old, new: new})
+	})}, plainClientOpts...)...)
+
+	if _, _, err := client.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := client.Step(nil); err == nil {
+		t.Fatal("Expected error on second step")
+	}
+	client.Reset()
+
+	want := []transition{
+		{old: Initial, new: AuthTextSent},
+		{old: AuthTextSent, new: ResponseSent | Errored},
+		{old: ResponseSent | Errored, new: Initial},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Wrong number of transitions: want=%v, got=%v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Wrong transition %d: want=%v, got=%v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	for _, tc := range []struct {
+		m    Mechanism
+		caps Capabilities
+	}{
+		{m: Plain, caps: Capabilities{RequiresTLS: true, Plaintext: true}},
+		{m: ScramSha1, caps: Capabilities{MutualAuth: true, RoundTrips: 2}},
+		{m: ScramSha256Plus, caps: Capabilities{ChannelBinding: true, MutualAuth: true, RoundTrips: 2}},
+	} {
+		if tc.m.Capabilities != tc.caps {
+			t.Errorf("Wrong capabilities for %s: want=%+v, got=%+v", tc.m.Name, tc.caps, tc.m.Capabilities)
+		}
+	}
+}
+
+func TestTypedMechanism(t *testing.T) {
+	type counter struct {
+		steps int
+	}
+	m := TypedMechanism[*counter]{
+		Name: "COUNT",
+		Start: func(*Negotiator) (bool, []byte, *counter, error) {
+			return true, []byte("0"), &counter{}, nil
+		},
+		Next: func(_ *Negotiator, _ []byte, c *counter) (bool, []byte, *counter, error) {
+			c.steps++
+			return c.steps < 3, []byte(strconv.Itoa(c.steps)), c, nil
+		},
+	}.Mechanism()
+
+	client := NewClient(m)
+	for i := 0; i < 4; i++ {
+		more, resp, err := client.Step(nil)
+		if err != nil {
+			t.Fatalf("Step %d: unexpected error: %v", i, err)
+		}
+		if string(resp) != strconv.Itoa(i) {
+			t.Errorf("Step %d: wrong response: want=%d, got=%s", i, i, resp)
+		}
+		if more != (i < 3) {
+			t.Errorf("Step %d: unexpected value for more: %v", i, more)
+		}
+	}
+}
+
+func TestStepTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	m := Mechanism{
+		Name: "SLOW",
+		Start: func(n *Negotiator) (bool, []byte, interface{}, error) {
+			if _, pass, _ := n.Credentials(); string(pass) == "slow" {
+				<-unblock
+			}
+			return false, []byte("done"), nil, nil
+		},
+	}
+	pass := "slow"
+	client := NewClient(m, StepTimeout(10*time.Millisecond), Credentials(func() ([]byte, []byte, []byte) {
+		return nil, []byte(pass), nil
+	}))
+	if _, _, err := client.Step(nil); err != ErrStepTimeout {
+		t.Fatalf("Expected ErrStepTimeout, got %v", err)
+	}
+	if client.State()&Errored != Errored {
+		t.Error("Expected error state to be set after timeout")
+	}
+
+	close(unblock)
+	client.Reset()
+	pass = "fast"
+	_, resp, err := client.Step(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error after reset: %v", err)
+	}
+	if string(resp) != "done" {
+		t.Errorf("Unexpected response: %q", resp)
+	}
+}
+
+// slowStore blocks lookups until unblock is closed.
+type slowStore struct {
+	mapStore
+	unblock chan struct{}
+}
+
+func (s slowStore) ScramCredentials(mechanism string, username []byte) (StoredCredentials, error) {
+	<-s.unblock
+	return s.mapStore.ScramCredentials(mechanism, username)
+}
+
+// TestStepTimeoutRace must be run with -race to be useful.
+func TestStepTimeoutRace(t *testing.T) {
+	store := slowStore{
+		mapStore: mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)},
+		unblock:  make(chan struct{}),
+	}
+	client := NewClient(ScramSha256, scramClientOpts...)
+	server := NewServer(ScramSha256, acceptAll, Store(store), StepTimeout(5*time.Millisecond))
+	_, clientFirst, err := client.Step(nil)
+	if err != nil {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+	if _, _, err = server.Step(clientFirst); err != ErrStepTimeout {
+		t.Fatalf("Expected ErrStepTimeout, got %v", err)
+	}
+	// The mechanism is still running and reads the state of its negotiator once
+	// the lookup returns.
+	close(store.unblock)
+	for i := 0; i < 20; i++ {
+		if !server.State().Errored() || server.Completed() {
+			t.Fatal("Server state changed after the step timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	server.Reset()
+	client.Reset()
+	if err = negotiate(client, server); err != nil {
+		t.Errorf("Unexpected error after reset: %v", err)
+	}
+}
+
+func TestConfidential(t *testing.T) {
+	for _, tc := range []struct {
+		m            Mechanism
+		confidential bool
+		tls          *tls.ConnectionState
+		fail         bool
+	}{
+		{m: Plain, confidential: false, fail: true},
+		{m: Plain, confidential: true},
+		{m: ScramSha256, confidential: false},
+		{m: Plain, confidential: false, tls: &tls.ConnectionState{HandshakeComplete: true}},
+		{m: Plain, confidential: false, tls: &tls.ConnectionState{}, fail: true},
+	} {
+		opts := []Option{Confidential(tc.confidential)}
+		if tc.tls != nil {
+			opts = append(opts, TLSState(*tc.tls))
+		}
+		for _, n := range []*Negotiator{
+			NewClient(tc.m, append(opts, scramClientOpts[0])...),
+			NewServer(tc.m, acceptAll, opts...),
+		} {
+			_, _, err := n.Step(nil)
+			var insecureErr InsecureTransportError
+			isInsecure := errors.As(err, &insecureErr)
+			switch {
+			case tc.fail && !isInsecure:
+				t.Errorf("%s (confidential=%t): expected InsecureTransportError, got %v", tc.m.Name, tc.confidential, err)
+			case !tc.fail && isInsecure:
+				t.Errorf("%s (confidential=%t): unexpected error: %v", tc.m.Name, tc.confidential, err)
+			case isInsecure && insecureErr.Mechanism != tc.m.Name:
+				t.Errorf("Wrong mechanism in error: want=%s, got=%s", tc.m.Name, insecureErr.Mechanism)
+			}
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	template := NewClient(ScramSha256, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	// Start the template to make sure that clones do not inherit its state.
+	if _, _, err := template.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	clones := make([]*Negotiator, 4)
+	errs := make(chan error, len(clones))
+	for i := range clones {
+		clones[i] = template.Clone()
+		go func(client *Negotiator) {
+			errs <- negotiate(client, NewServer(ScramSha256, acceptAll, Store(store)))
+		}(clones[i])
+	}
+	for range clones {
+		if err := <-errs; err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	for i, client := range clones {
+		if !client.Authenticated() {
+			t.Errorf("Clone %d did not authenticate", i)
+		}
+		if string(client.Nonce()) == string(template.Nonce()) {
+			t.Errorf("Clone %d reused the template nonce", i)
+		}
+	}
+	if template.State()&StepMask != AuthTextSent || template.Completed() {
+		t.Errorf("Template state changed by clones: %v", template)
+	}
+}
+
+func TestResetWithCredentials(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	cs := tls.ConnectionState{TLSUnique: []byte{0, 1, 2, 3, 4}}
+	newServer := func() *Negotiator {
+		return NewServer(ScramSha256Plus, acceptAll, Store(store), TLSState(cs))
+	}
+	client := NewClient(ScramSha256Plus, TLSState(cs), RemoteMechanisms("SCRAM-SHA-256-PLUS"), Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pen"), nil
+	}))
+	if !client.State().RemoteSupportsCB() {
+		t.Fatalf("Expected remote channel binding support to be set")
+	}
+	err := negotiate(client, newServer())
+	if !errors.Is(err, ErrAuthn) {
+		t.Fatalf("Expected authentication to fail with the wrong password, got: %v", err)
+	}
+
+	client.ResetWithCredentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	})
+	if !client.State().RemoteSupportsCB() {
+		t.Errorf("Remote channel binding support was lost on reset")
+	}
+	if err := negotiate(client, newServer()); err != nil {
+		t.Fatalf("Unexpected error after resetting credentials: %v", err)
+	}
+	if !client.Authenticated() {
+		t.Errorf("Expected client to be authenticated after retrying")
+	}
+}
+
+func BenchmarkPlainServerStep(b *testing.B) {
+	server := NewServer(plain, acceptAll)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := server.Step(plainResp); err != nil {
+			b.Fatal(err)
+		}
+		server.Reset()
+	}
+}
+
+func TestConstantTimeEqual(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		eq   bool
+	}{
+		{a: "", b: "", eq: true},
+		{a: "pencil", b: "pencil", eq: true},
+		{a: "pencil", b: "pencil2"},
+		{a: "pencil", b: "Pencil"},
+		{a: "", b: "pencil"},
+	} {
+		if eq := ConstantTimeEqual([]byte(tc.a), []byte(tc.b)); eq != tc.eq {
+			t.Errorf("ConstantTimeEqual(%q, %q) = %t, want %t", tc.a, tc.b, eq, tc.eq)
+		}
+	}
+}
+
+func TestPlainCredentials(t *testing.T) {
+	for _, tc := range []struct {
+		username, password, identity string
+		err                          error
+	}{
+		{username: "Kurt", password: "xipj3plmq", identity: "Ursel"},
+		{username: "Kürt", password: "☃"},
+		{username: "Ku\x00rt", password: "xipj3plmq", err: CredentialError{Field: "username", Reason: "contains a NUL byte"}},
+		{username: "Kurt", password: "xipj\x003plmq", err: CredentialError{Field: "password", Reason: "contains a NUL byte"}},
+		{username: "Kurt", password: "xipj3plmq", identity: "\x00", err: CredentialError{Field: "identity", Reason: "contains a NUL byte"}},
+		{username: "Kurt\xff", password: "xipj3plmq", err: CredentialError{Field: "username", Reason: "is not valid UTF-8"}},
+		{username: "Kurt", password: "\xc3", err: CredentialError{Field: "password", Reason: "is not valid UTF-8"}},
+	} {
+		client := NewClient(plain, Credentials(func() ([]byte, []byte, []byte) {
+			return []byte(tc.username), []byte(tc.password), []byte(tc.identity)
+		}))
+		if _, _, err := client.Step(nil); err != tc.err {
+			t.Errorf("Client %q: unexpected error: want=%v, got=%v", tc.username, tc.err, err)
+		}
+		if strings.ContainsRune(tc.username, 0) || strings.ContainsRune(tc.password, 0) || strings.ContainsRune(tc.identity, 0) {
+			// NUL bytes can't be sent to the server.
+			continue
+		}
+		server := NewServer(plain, acceptAll)
+		_, _, err := server.Step([]byte(tc.identity + "\x00" + tc.username + "\x00" + tc.password))
+		if err != tc.err {
+			t.Errorf("Server %q: unexpected error: want=%v, got=%v", tc.username, tc.err, err)
+		}
+	}
+}
+
+func TestStateMethods(t *testing.T) {
+	for _, tc := range []struct {
+		state    State
+		step     State
+		server   bool
+		errored  bool
+		remoteCB bool
+	}{
+		{state: Initial, step: Initial},
+		{state: AuthTextSent | Receiving, step: AuthTextSent, server: true},
+		{state: ResponseSent | RemoteCB, step: ResponseSent, remoteCB: true},
+		{state: ValidServerResponse | Errored | Receiving | RemoteCB, step: ValidServerResponse, server: true, errored: true, remoteCB: true},
+	} {
+		if step := tc.state.Step(); step != tc.step {
+			t.Errorf("%#x: Step() = %#x, want %#x", uint8(tc.state), uint8(step), uint8(tc.step))
+		}
+		if server := tc.state.IsServer(); server != tc.server {
+			t.Errorf("%#x: IsServer() = %t, want %t", uint8(tc.state), server, tc.server)
+		}
+		if errored := tc.state.Errored(); errored != tc.errored {
+			t.Errorf("%#x: Errored() = %t, want %t", uint8(tc.state), errored, tc.errored)
+		}
+		if cb := tc.state.RemoteSupportsCB(); cb != tc.remoteCB {
+			t.Errorf("%#x: RemoteSupportsCB() = %t, want %t", uint8(tc.state), cb, tc.remoteCB)
+		}
+	}
+}
+
+func TestServiceHost(t *testing.T) {
+	var got []string
+	m := Mechanism{
+		Name: "X-SERVICE",
+		Start: func(m *Negotiator) (bool, []byte, interface{}, error) {
+			host, port := m.Host()
+			got = []string{m.Service(), host, strconv.Itoa(port), m.ServerFQDN()}
+			return false, nil, nil, nil
+		},
+	}
+	for _, tc := range []struct {
+		opts []Option
+		want []string
+	}{
+		{want: []string{"", "", "0", ""}},
+		{opts: []Option{Service("imap"), Host("example.net", 993)}, want: []string{"imap", "example.net", "993", "example.net"}},
+		{opts: []Option{Host("example.net", 0), ServerFQDN("imap1.example.net")}, want: []string{"", "example.net", "0", "imap1.example.net"}},
+	} {
+		if _, _, err := NewClient(m, tc.opts...).Step(nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Unexpected values: want=%q, got=%q", tc.want, got)
+		}
+	}
+}
+
+func TestSelectRealm(t *testing.T) {
+	errSelector := errors.New("selector error")
+	for i, tc := range []struct {
+		opts    []Option
+		offered []string
+		want    string
+		err     error
+	}{
+		{},
+		{offered: []string{"example.net"}, want: "example.net"},
+		{offered: []string{"example.net", "example.com"}, err: ErrRealm},
+		{opts: []Option{Realm("example.org")}, offered: []string{"example.net", "example.com"}, want: "example.org"},
+		{
+			opts: []Option{Realm("example.org"), RealmSelector(func(offered []string) (string, error) {
+				return offered[len(offered)-1], nil
+			})},
+			offered: []string{"example.net", "example.com"},
+			want:    "example.com",
+		},
+		{
+			opts: []Option{RealmSelector(func([]string) (string, error) {
+				return "", errSelector
+			})},
+			offered: []string{"example.net"},
+			err:     errSelector,
+		},
+	} {
+		realm, err := NewClient(plain, tc.opts...).SelectRealm(tc.offered)
+		if err != tc.err {
+			t.Errorf("%d: Unexpected error: want=%v, got=%v", i, tc.err, err)
+		}
+		if realm != tc.want {
+			t.Errorf("%d: Unexpected realm: want=%q, got=%q", i, tc.want, realm)
+		}
+	}
+}
+
+func TestProperty(t *testing.T) {
+	qop := NewProperty[string]("test qop")
+	other := NewProperty[string]("test qop")
+	trace := NewProperty[[]byte]("test trace")
+	if s := qop.String(); s != "test qop" {
+		t.Errorf("Unexpected name: %q", s)
+	}
+
+	n := NewClient(plain, qop.Set("auth-int"), trace.Set([]byte("trace")))
+	if v, ok := qop.Get(n); !ok || v != "auth-int" {
+		t.Errorf("Unexpected value: want=%q, got=%q (%t)", "auth-int", v, ok)
+	}
+	if v, ok := trace.Get(n); !ok || string(v) != "trace" {
+		t.Errorf("Unexpected value: want=%q, got=%q (%t)", "trace", v, ok)
+	}
+	if v, ok := other.Get(n); ok || v != "" {
+		t.Errorf("Property with the same name should not be set, got=%q", v)
+	}
+	if _, ok := qop.Get(NewClient(plain)); ok {
+		t.Errorf("Property should not be set on a new negotiator")
+	}
+	if v, ok := qop.Get(n.Clone()); !ok || v != "auth-int" {
+		t.Errorf("Property not copied to clone, got=%q (%t)", v, ok)
+	}
+}
+
+func TestInitialResponse(t *testing.T) {
+	want := []byte("Ursel\x00Kurt\x00xipj3plmq")
+
+	client := NewClient(plain, append([]Option{InitialResponse(true)}, plainClientOpts...)...)
+	more, resp, err := client.Step(nil)
+	if err != nil || more || !bytes.Equal(resp, want) {
+		t.Fatalf("Unexpected initial response: more=%t, resp=%q, err=%v", more, resp, err)
+	}
+
+	client = NewClient(plain, append([]Option{InitialResponse(false)}, plainClientOpts...)...)
+	more, resp, err = client.Step(nil)
+	if err != nil || !more || resp != nil {
+		t.Fatalf("Expected no initial response: more=%t, resp=%q, err=%v", more, resp, err)
+	}
+	more, resp, err = client.Step([]byte{})
+	if err != nil || more || !bytes.Equal(resp, want) {
+		t.Fatalf("Unexpected response to empty challenge: more=%t, resp=%q, err=%v", more, resp, err)
+	}
+	if !client.Completed() {
+		t.Error("Expected negotiation to be completed")
+	}
+
+	client.Reset()
+	client.Step(nil)
+	if _, _, err = client.Step([]byte("challenge")); !errors.Is(err, ErrInvalidChallenge) || !errors.As(err, new(TransitionError)) {
+		t.Errorf("Unexpected error for non-empty challenge: want=%v, got=%v", ErrInvalidChallenge, err)
+	}
+}
+
+func TestClientFirst(t *testing.T) {
+	if !NewClient(ScramSha256).ClientFirst() {
+		t.Error("Expected SCRAM to be client-first")
+	}
+	serverFirst := Mechanism{Name: "CRAM-MD5", Capabilities: Capabilities{ServerFirst: true}}
+	if NewClient(serverFirst).ClientFirst() {
+		t.Error("Expected server-first mechanism not to be client-first")
+	}
+}
+
+func TestCanonicalizeUsername(t *testing.T) {
+	canonical := CanonicalizeUsername(func(username []byte) ([]byte, error) {
+		local, _, _ := bytes.Cut(bytes.ToLower(username), []byte("@"))
+		if string(local) == "root" {
+			return nil, ErrUnknownUser
+		}
+		return local, nil
+	})
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+
+	for _, tc := range []struct {
+		mech     Mechanism
+		username string
+		err      error
+	}{
+		{mech: ScramSha256, username: "USER@example.com"},
+		{mech: Plain, username: "User@example.net"},
+		{mech: ScramSha256, username: "root@example.com", err: ErrUnknownUser},
+		{mech: Plain, username: "root", err: ErrUnknownUser},
+	} {
+		var permitted string
+		client := NewClient(tc.mech, Credentials(func() ([]byte, []byte, []byte) {
+			return []byte(tc.username), []byte("pencil"), nil
+		}))
+		server := NewServer(tc.mech, func(n *Negotiator) bool {
+			user, _, _ := n.Credentials()
+			permitted = string(user)
+			return true
+		}, canonical, Store(store))
+		err := negotiate(client, server)
+		if err != tc.err {
+			t.Errorf("%s %q: unexpected error: want=%v, got=%v", tc.mech.Name, tc.username, tc.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		id, _ := server.Identity()
+		if permitted != "user" || string(id.Username) != "user" {
+			t.Errorf("%s %q: expected canonical username, got %q and %q", tc.mech.Name, tc.username, permitted, id.Username)
+		}
+	}
+}
+
+func TestUpdateRemoteMechanisms(t *testing.T) {
+	client := NewClient(ScramSha256Plus, Interop(WorkaroundMechanismCase))
+	if client.State().RemoteSupportsCB() {
+		t.Fatal("Expected remote side not to support channel binding initially")
+	}
+	if err := client.UpdateRemoteMechanisms("scram-sha-256-plus", "plain"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !client.State().RemoteSupportsCB() {
+		t.Error("Expected updated list to set RemoteCB")
+	}
+	if got := client.RemoteMechanisms(); len(got) != 2 || got[0] != "SCRAM-SHA-256-PLUS" {
+		t.Errorf("Workarounds were not applied to the updated list: %v", got)
+	}
+	if err := client.UpdateRemoteMechanisms("PLAIN"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.State().RemoteSupportsCB() {
+		t.Error("Expected RemoteCB to be cleared when -PLUS is no longer advertised")
+	}
+
+	client = NewClient(ScramSha256, scramClientOpts...)
+	client.Step(nil)
+	if err := client.UpdateRemoteMechanisms("SCRAM-SHA-256"); err != ErrInvalidState {
+		t.Errorf("Expected ErrInvalidState during a negotiation, got %v", err)
+	}
+	client.Reset()
+	if err := client.UpdateRemoteMechanisms("SCRAM-SHA-256"); err != nil {
+		t.Errorf("Unexpected error after reset: %v", err)
+	}
+
+	server := NewServer(ScramSha256Plus, acceptAll)
+	if err := server.UpdateRemoteMechanisms("SCRAM-SHA-256-PLUS"); err != nil || !server.State().RemoteSupportsCB() {
+		t.Errorf("Unexpected result updating server: err=%v, state=%v", err, server.State())
+	}
+}
+
+func TestPermissionsKeepsConfig(t *testing.T) {
+	var called bool
+	server := NewServer(Plain, func(n *Negotiator) bool {
+		called = true
+		if n.maxMessageSize != 100 || n.minIterations != 1 {
+			t.Errorf("Permissions lost the server config: maxMessageSize=%d, minIterations=%d", n.maxMessageSize, n.minIterations)
+		}
+		if username, _, _ := n.Credentials(); string(username) != "Kurt" {
+			t.Errorf("Wrong username: %q", username)
+		}
+		return true
+	}, MaxMessageSize(100), MinIterations(1))
+	if _, _, err := server.Step(plainResp); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !called {
+		t.Error("Expected permissions to be called")
+	}
+}
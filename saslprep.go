@@ -0,0 +1,132 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/bidi"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	errPrepProhibited = errors.New("Credentials contain a prohibited character")
+	errPrepBidi       = errors.New("Credentials contain invalid bidirectional text")
+)
+
+// runeRange is an inclusive range of code points.
+type runeRange struct {
+	lo, hi rune
+}
+
+func inTable(r rune, table []runeRange) bool {
+	for _, rr := range table {
+		if r < rr.lo {
+			return false
+		}
+		if r <= rr.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// RFC 3454 table B.1: commonly mapped to nothing.
+var mappedToNothing = []runeRange{
+	{0x00AD, 0x00AD}, {0x034F, 0x034F}, {0x1806, 0x1806}, {0x180B, 0x180D},
+	{0x200B, 0x200D}, {0x2060, 0x2060}, {0xFE00, 0xFE0F}, {0xFEFF, 0xFEFF},
+}
+
+// RFC 3454 table C.1.2: non-ASCII space characters.
+var nonASCIISpace = []runeRange{
+	{0x00A0, 0x00A0}, {0x1680, 0x1680}, {0x2000, 0x200B}, {0x202F, 0x202F},
+	{0x205F, 0x205F}, {0x3000, 0x3000},
+}
+
+// RFC 3454 tables C.1.2 and C.2.1 through C.9 combined and sorted.
+var prohibited = []runeRange{
+	{0x0000, 0x001F}, {0x007F, 0x009F}, {0x00A0, 0x00A0}, {0x0340, 0x0341},
+	{0x06DD, 0x06DD}, {0x070F, 0x070F}, {0x1680, 0x1680}, {0x180E, 0x180E},
+	{0x2000, 0x200F}, {0x2028, 0x202F}, {0x205F, 0x2063}, {0x206A, 0x206F},
+	{0x2FF0, 0x2FFB}, {0x3000, 0x3000}, {0xD800, 0xF8FF}, {0xFDD0, 0xFDEF},
+	{0xFEFF, 0xFEFF}, {0xFFF9, 0xFFFF}, {0x1D173, 0x1D17A}, {0x1FFFE, 0x1FFFF},
+	{0x2FFFE, 0x2FFFF}, {0x3FFFE, 0x3FFFF}, {0x4FFFE, 0x4FFFF}, {0x5FFFE, 0x5FFFF},
+	{0x6FFFE, 0x6FFFF}, {0x7FFFE, 0x7FFFF}, {0x8FFFE, 0x8FFFF}, {0x9FFFE, 0x9FFFF},
+	{0xAFFFE, 0xAFFFF}, {0xBFFFE, 0xBFFFF}, {0xCFFFE, 0xCFFFF}, {0xDFFFE, 0xDFFFF},
+	{0xE0001, 0xE0001}, {0xE0020, 0xE007F}, {0xEFFFE, 0x10FFFF},
+}
+
+// SASLprep applies the SASLprep profile of stringprep defined in RFC 4013 to b
+// in the same way as SCRAM clients do before deriving keys from a password.
+// It is used to normalize passwords before passing them to
+// DeriveStoredCredentials.
+func SASLprep(b []byte) ([]byte, error) {
+	return saslprep(b)
+}
+
+// saslprep applies the SASLprep profile of stringprep defined in RFC 4013 to b,
+// treating it as a query string (unassigned code points are allowed).
+// If b is printable ASCII it is returned unmodified without allocating.
+func saslprep(b []byte) ([]byte, error) {
+	ascii := true
+	for _, c := range b {
+		if c < 0x20 || c >= 0x7F {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return b, nil
+	}
+
+	if !utf8.Valid(b) {
+		return nil, errPrepProhibited
+	}
+
+	// Mapping (RFC 4013 §2.1)
+	mapped := make([]byte, 0, len(b))
+	for _, r := range string(b) {
+		switch {
+		case inTable(r, mappedToNothing):
+		case inTable(r, nonASCIISpace):
+			mapped = append(mapped, ' ')
+		default:
+			mapped = utf8.AppendRune(mapped, r)
+		}
+	}
+
+	// Normalization (RFC 4013 §2.2)
+	out := norm.NFKC.Bytes(mapped)
+
+	// Prohibited output (RFC 4013 §2.3) and bidirectional characters
+	// (RFC 4013 §2.4, RFC 3454 §6).
+	var hasRandAL, hasL bool
+	first, last := true, false
+	for _, r := range string(out) {
+		if inTable(r, prohibited) {
+			return nil, errPrepProhibited
+		}
+		props, _ := bidi.LookupRune(r)
+		switch props.Class() {
+		case bidi.R, bidi.AL:
+			if !hasRandAL && !first {
+				return nil, errPrepBidi
+			}
+			hasRandAL = true
+			last = true
+		case bidi.L:
+			hasL = true
+			last = false
+		default:
+			last = false
+		}
+		first = false
+	}
+	if hasRandAL && (hasL || !last) {
+		return nil, errPrepBidi
+	}
+	return out, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"strconv"
+	"testing"
+)
+
+var saslprepTestCases = [...]struct {
+	in  string
+	out string
+	err error
+}{
+	// Examples from RFC 4013 §3.
+	0: {in: "I\u00ADX", out: "IX"},
+	1: {in: "user", out: "user"},
+	2: {in: "USER", out: "USER"},
+	3: {in: "\u00AA", out: "a"},
+	4: {in: "\u2168", out: "IX"},
+	5: {in: "\u0007", err: errPrepProhibited},
+	6: {in: "\u0627\u0031", err: errPrepBidi},
+
+	7:  {in: "a\u00A0b\u3000c", out: "a b c"},
+	8:  {in: "\u0627\u0031\u0628", out: "\u0627\u0031\u0628"},
+	9:  {in: "\u0627a\u0628", err: errPrepBidi},
+	10: {in: "a\uE000", err: errPrepProhibited},
+	11: {in: "\xff", err: errPrepProhibited},
+	12: {in: "", out: ""},
+}
+
+func TestSASLprep(t *testing.T) {
+	for i, tc := range saslprepTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out, err := saslprep([]byte(tc.in))
+			if err != tc.err {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if string(out) != tc.out {
+				t.Errorf("Unexpected output: want=%q, got=%q", tc.out, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasltest
+
+import (
+	"bytes"
+
+	"github.com/jh125486/sasl"
+)
+
+// Exchange is one round trip of a Mock mechanism: a response sent by the
+// client followed by the challenge sent by the server in reply.
+type Exchange struct {
+	// Response is sent by the client.
+	// The response of the first exchange is the initial response.
+	Response []byte
+
+	// Challenge is sent by the server after it receives Response.
+	// The challenge of the last exchange is the additional data sent with the
+	// success outcome, if any.
+	Challenge []byte
+
+	// ClientErr, if set, is returned by the client in place of sending Response.
+	ClientErr error
+
+	// ServerErr, if set, is returned by the server in place of sending
+	// Challenge.
+	ServerErr error
+}
+
+// Mock is a fake mechanism that sends scripted messages and fails at scripted
+// steps without doing any cryptography, so that applications can test the code
+// that drives a negotiator (for example, the way that messages are framed by
+// their protocol and the way that errors are reported) without a real
+// mechanism or remote peer.
+//
+// Both sides check that every message they receive matches the script and
+// return sasl.ErrInvalidChallenge if it does not.
+// The permissions callback is never called: to make the server reject the
+// client, set ServerErr to sasl.ErrAuthn.
+type Mock struct {
+	// Name is the mechanism name.
+	// If it is empty, "X-MOCK" is used.
+	Name string
+
+	// Capabilities are reported by the mechanism, for example to test policy
+	// such as the Confidential option.
+	// The client always sends the first message, so ServerFirst must not be
+	// set.
+	Capabilities sasl.Capabilities
+
+	// Exchanges is the script.
+	// A mock with no exchanges sends an empty initial response and succeeds.
+	Exchanges []Exchange
+}
+
+// Mechanism returns a mechanism that follows the script.
+// The returned mechanism can be used by any number of negotiators.
+func (m Mock) Mechanism() sasl.Mechanism {
+	name := m.Name
+	if name == "" {
+		name = "X-MOCK"
+	}
+	exchanges := m.Exchanges
+	if len(exchanges) == 0 {
+		exchanges = []Exchange{{Response: []byte{}}}
+	}
+	last := len(exchanges) - 1
+
+	// clientMore reports whether the client expects to process another
+	// challenge after sending the response of exchange i.
+	clientMore := func(i int) bool {
+		return i < last || exchanges[last].Challenge != nil
+	}
+
+	return sasl.TypedMechanism[int]{
+		Name:         name,
+		Capabilities: m.Capabilities,
+		Start: func(n *sasl.Negotiator) (bool, []byte, int, error) {
+			if err := exchanges[0].ClientErr; err != nil {
+				return false, nil, 0, err
+			}
+			return clientMore(0), exchanges[0].Response, 0, nil
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, i int) (bool, []byte, int, error) {
+			if i > last {
+				return false, nil, i, sasl.ErrTooManySteps
+			}
+			ex := exchanges[i]
+			if n.State().IsServer() {
+				switch {
+				case !bytes.Equal(challenge, ex.Response):
+					return false, nil, i, sasl.ErrInvalidChallenge
+				case ex.ServerErr != nil:
+					return false, nil, i, ex.ServerErr
+				}
+				return i < last, ex.Challenge, i + 1, nil
+			}
+
+			if !bytes.Equal(challenge, ex.Challenge) {
+				return false, nil, i, sasl.ErrInvalidChallenge
+			}
+			if i == last {
+				return false, nil, i + 1, nil
+			}
+			i++
+			if err := exchanges[i].ClientErr; err != nil {
+				return false, nil, i, err
+			}
+			return clientMore(i), exchanges[i].Response, i, nil
+		},
+	}.Mechanism()
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasltest_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/sasltest"
+)
+
+func TestMock(t *testing.T) {
+	script := []sasltest.Exchange{
+		{Response: []byte("hello"), Challenge: []byte("continue")},
+		{Response: []byte("proof"), Challenge: []byte("signature")},
+	}
+	sasltest.Run(t, sasltest.Config{
+		Mechanism: sasltest.Mock{Exchanges: script}.Mechanism(),
+		Vectors: []sasltest.Vector{{
+			Steps: []sasltest.Step{
+				{Response: []byte("hello"), More: true},
+				{Challenge: []byte("continue"), Response: []byte("proof"), More: true},
+				{Challenge: []byte("signature")},
+			},
+			SkipServer: true,
+		}},
+	})
+
+	errBroken := errors.New("broken")
+	for _, tc := range []struct {
+		name      string
+		exchanges []sasltest.Exchange
+		err       error
+		messages  int
+	}{
+		{name: "empty", messages: 1},
+		{name: "client", exchanges: []sasltest.Exchange{script[0], {ClientErr: errBroken}}, err: errBroken, messages: 2},
+		{name: "server", exchanges: []sasltest.Exchange{script[0], {Response: []byte("proof"), ServerErr: sasl.ErrAuthn}}, err: sasl.ErrAuthn, messages: 3},
+		{name: "initial", exchanges: []sasltest.Exchange{{ClientErr: errBroken}}, err: errBroken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := sasltest.Mock{Name: "X-TEST", Exchanges: tc.exchanges}.Mechanism()
+			transcript, err := sasltest.Negotiate(sasl.NewClient(m), sasl.NewServer(m, nil))
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if len(transcript) != tc.messages {
+				t.Errorf("Unexpected transcript:\n%s", transcript)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasltest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jh125486/sasl"
+)
+
+// Message is a single message sent during a negotiation.
+type Message struct {
+	// FromServer is true if the message is a challenge sent by the server and
+	// false if it is a response sent by the client.
+	FromServer bool
+	Data       []byte
+}
+
+// Transcript is the list of messages sent during a negotiation in the order
+// they were sent.
+type Transcript []Message
+
+// String returns the transcript with one message per line, each prefixed with
+// "C: " or "S: " depending on the sender.
+func (t Transcript) String() string {
+	var b strings.Builder
+	for _, m := range t {
+		if m.FromServer {
+			b.WriteString("S: ")
+		} else {
+			b.WriteString("C: ")
+		}
+		fmt.Fprintf(&b, "%q\n", m.Data)
+	}
+	return b.String()
+}
+
+// Negotiate runs a client and server negotiator against one another in memory
+// until both have completed or either returns an error.
+// The returned transcript contains every message sent before the error.
+//
+// Negotiate returns sasl.ErrAuthn if the server finishes but the client still
+// expects more data (for example, because the server did not send a SCRAM
+// server signature).
+func Negotiate(client, server *sasl.Negotiator) (Transcript, error) {
+	var transcript Transcript
+	var challenge []byte
+	for {
+		clientMore, resp, err := client.Step(challenge)
+		if err != nil {
+			return transcript, err
+		}
+		if server.Completed() {
+			return transcript, nil
+		}
+		transcript = append(transcript, Message{Data: resp})
+
+		var serverMore bool
+		serverMore, challenge, err = server.Step(resp)
+		if err != nil {
+			return transcript, err
+		}
+		if !serverMore && !clientMore {
+			if len(challenge) != 0 {
+				transcript = append(transcript, Message{FromServer: true, Data: challenge})
+			}
+			return transcript, nil
+		}
+		transcript = append(transcript, Message{FromServer: true, Data: challenge})
+		if !serverMore && len(challenge) == 0 {
+			return transcript, sasl.ErrAuthn
+		}
+	}
+}
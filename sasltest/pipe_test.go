@@ -0,0 +1,78 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasltest_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/sasltest"
+)
+
+func TestNegotiate(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		password string
+		senders  string
+		fail     bool
+	}{
+		{name: "plain", mech: sasl.Plain, password: "pencil", senders: "C"},
+		{name: "plain-bad-password", mech: sasl.Plain, password: "pen", senders: "C", fail: true},
+		{name: "scram", mech: sasl.ScramSha256, password: "pencil", senders: "CSCS"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, password: "pen", senders: "CSC", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := sasl.NewClient(tc.mech, credentials("user", tc.password)...)
+			server := sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s))
+			transcript, err := sasltest.Negotiate(client, server)
+			switch {
+			case tc.fail && !errors.Is(err, sasl.ErrAuthn):
+				t.Errorf("Expected authentication error, got %v", err)
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+			var senders strings.Builder
+			for _, m := range transcript {
+				if m.FromServer {
+					senders.WriteByte('S')
+				} else {
+					senders.WriteByte('C')
+				}
+			}
+			if senders.String() != tc.senders {
+				t.Errorf("Unexpected transcript:\n%s", transcript)
+			}
+			if !tc.fail && !client.Authenticated() && tc.mech.Name != sasl.Plain.Name {
+				t.Error("Expected client to authenticate the server")
+			}
+		})
+	}
+}
+
+func TestNegotiateMissingServerSignature(t *testing.T) {
+	// A mechanism that accepts the client without sending a final message even
+	// though the client expects one.
+	mech := sasl.Mechanism{
+		Name: "X-ONEWAY",
+		Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+			return true, []byte("hello"), nil, nil
+		},
+		Next: func(*sasl.Negotiator, []byte, interface{}) (bool, []byte, interface{}, error) {
+			return false, nil, nil, nil
+		},
+	}
+	transcript, err := sasltest.Negotiate(sasl.NewClient(mech), sasl.NewServer(mech, nil))
+	if !errors.Is(err, sasl.ErrAuthn) {
+		t.Errorf("Expected authentication error, got %v", err)
+	}
+	if got := transcript.String(); got != "C: \"hello\"\nS: \"\"\n" {
+		t.Errorf("Unexpected transcript: %q", got)
+	}
+}
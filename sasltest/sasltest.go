@@ -0,0 +1,291 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package sasltest provides a conformance suite for Mechanism implementations.
+//
+// The suite runs fixed test vectors, a full client/server round trip,
+// malformed input, and a number of state machine abuse cases against a
+// mechanism.
+// It is used to test the mechanisms provided by the sasl package and can be
+// used by third party mechanisms to hold themselves to the same standard.
+//
+// Applications can use the Mock mechanism to test their own code that drives a
+// negotiator.
+package sasltest
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/jh125486/sasl"
+)
+
+// Step is a single step of a test vector.
+// When a vector is replayed against a client, Challenge is passed to Step and
+// Response is the expected result (the first challenge is generally empty
+// because SASL is a client-first protocol).
+// When it is replayed against a server, Response is passed to Step and
+// Challenge is the expected result.
+type Step struct {
+	Challenge []byte
+	Response  []byte
+	More      bool
+	ClientErr bool
+	ServerErr bool
+}
+
+// Vector is a fixed exchange, for example one taken from an RFC.
+// ClientNonce and ServerNonce, if set, are the values returned by the
+// negotiators' Nonce methods.
+type Vector struct {
+	Name          string
+	ClientNonce   []byte
+	ServerNonce   []byte
+	ClientOptions []sasl.Option
+	ServerOptions []sasl.Option
+	Permissions   func(*sasl.Negotiator) bool
+	Steps         []Step
+	SkipClient    bool
+	SkipServer    bool
+}
+
+// DefaultMalformed is the list of malformed messages used when a Config does
+// not set Malformed.
+var DefaultMalformed = [][]byte{
+	[]byte("\xff\xfe\xfd"),
+	[]byte(",,,,,,,,"),
+	[]byte("=\x00=\x00="),
+	[]byte("a=b,c=d,e=f,g=h,i=j,k=l,m=n"),
+}
+
+// Config describes the mechanism under test.
+type Config struct {
+	// Mechanism is the mechanism under test.
+	Mechanism sasl.Mechanism
+
+	// ClientOptions and ServerOptions configure negotiators that should
+	// authenticate successfully against one another.
+	ClientOptions []sasl.Option
+	ServerOptions []sasl.Option
+
+	// Permissions is passed to NewServer.
+	Permissions func(*sasl.Negotiator) bool
+
+	// BadClientOptions configure a client that the server must reject.
+	// If nil, the test is skipped.
+	BadClientOptions []sasl.Option
+
+	// Vectors are fixed exchanges to replay.
+	Vectors []Vector
+
+	// Malformed messages must cause the server to fail when sent as the first
+	// client message and the client to fail when sent as the first challenge.
+	// If nil, DefaultMalformed is used.
+	Malformed [][]byte
+}
+
+var mechName = regexp.MustCompile(`^[A-Z0-9_-]{1,20}$`)
+
+// Run runs the conformance suite against the mechanism described by cfg.
+func Run(t *testing.T, cfg Config) {
+	t.Run("Name", func(t *testing.T) {
+		if !mechName.MatchString(cfg.Mechanism.Name) {
+			t.Errorf("Mechanism name %q is not a valid SASL mechanism name (RFC 4422 §3.1)", cfg.Mechanism.Name)
+		}
+	})
+	t.Run("Vectors", func(t *testing.T) {
+		for i, v := range cfg.Vectors {
+			name := v.Name
+			if name == "" {
+				name = fmt.Sprint(i)
+			}
+			t.Run(name, func(t *testing.T) {
+				runVector(t, cfg.Mechanism, v)
+			})
+		}
+	})
+	t.Run("RoundTrip", func(t *testing.T) {
+		client := sasl.NewClient(cfg.Mechanism, cfg.ClientOptions...)
+		server := sasl.NewServer(cfg.Mechanism, cfg.Permissions, cfg.ServerOptions...)
+		// Run twice to make sure that Reset returns both sides to their initial
+		// state.
+		for run := 1; run < 3; run++ {
+			if _, err := Negotiate(client, server); err != nil {
+				t.Fatalf("Run %d: unexpected error: %v", run, err)
+			}
+			if !server.Completed() {
+				t.Fatalf("Run %d: server did not complete", run)
+			}
+			client.Reset()
+			server.Reset()
+		}
+	})
+	t.Run("BadCredentials", func(t *testing.T) {
+		if cfg.BadClientOptions == nil {
+			t.Skip("no bad credentials configured")
+		}
+		client := sasl.NewClient(cfg.Mechanism, cfg.BadClientOptions...)
+		server := sasl.NewServer(cfg.Mechanism, cfg.Permissions, cfg.ServerOptions...)
+		if _, err := Negotiate(client, server); err == nil {
+			t.Fatal("Expected negotiation with bad credentials to fail")
+		}
+		if server.Completed() {
+			t.Error("Server completed with bad credentials")
+		}
+	})
+	t.Run("Malformed", func(t *testing.T) {
+		malformed := cfg.Malformed
+		if malformed == nil {
+			malformed = DefaultMalformed
+		}
+		for _, msg := range malformed {
+			t.Run(fmt.Sprintf("%q", msg), func(t *testing.T) {
+				server := sasl.NewServer(cfg.Mechanism, cfg.Permissions, cfg.ServerOptions...)
+				if err := step(t, server, msg); err == nil {
+					t.Error("Expected server to reject malformed client message")
+				}
+				checkErrored(t, server)
+
+				client := sasl.NewClient(cfg.Mechanism, cfg.ClientOptions...)
+				more, _, err := client.Step(nil)
+				if err != nil {
+					t.Fatalf("Unexpected error starting client: %v", err)
+				}
+				if !more {
+					t.Skip("mechanism does not receive challenges")
+				}
+				if err := step(t, client, msg); err == nil {
+					t.Error("Expected client to reject malformed challenge")
+				}
+				checkErrored(t, client)
+			})
+		}
+	})
+	t.Run("StepAfterCompletion", func(t *testing.T) {
+		client := sasl.NewClient(cfg.Mechanism, cfg.ClientOptions...)
+		server := sasl.NewServer(cfg.Mechanism, cfg.Permissions, cfg.ServerOptions...)
+		if _, err := Negotiate(client, server); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, n := range []*sasl.Negotiator{client, server} {
+			if err := step(t, n, nil); err == nil {
+				t.Errorf("Expected error stepping %v after completion", n)
+			}
+		}
+	})
+	t.Run("Stateless", func(t *testing.T) {
+		// Mechanisms may be shared between goroutines so interleaving two
+		// negotiations must not affect either of them.
+		var wg sync.WaitGroup
+		errs := make(chan error, 4)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				client := sasl.NewClient(cfg.Mechanism, cfg.ClientOptions...)
+				server := sasl.NewServer(cfg.Mechanism, cfg.Permissions, cfg.ServerOptions...)
+				_, err := Negotiate(client, server)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Errorf("Unexpected error in concurrent negotiation: %v", err)
+			}
+		}
+	})
+}
+
+func runVector(t *testing.T, m sasl.Mechanism, v Vector) {
+	clientOpts, serverOpts := v.ClientOptions, v.ServerOptions
+	if v.ClientNonce != nil {
+		clientOpts = append(clientOpts[:len(clientOpts):len(clientOpts)], fixedNonce(v.ClientNonce))
+	}
+	if v.ServerNonce != nil {
+		serverOpts = append(serverOpts[:len(serverOpts):len(serverOpts)], fixedNonce(v.ServerNonce))
+	}
+	client := sasl.NewClient(m, clientOpts...)
+	server := sasl.NewServer(m, v.Permissions, serverOpts...)
+
+	// Run each vector twice to make sure that Reset actually sets the state back
+	// to the initial state.
+	for run := 1; run < 3; run++ {
+		if !v.SkipClient {
+			for i, s := range v.Steps {
+				more, resp, err := client.Step(s.Challenge)
+				if !checkStep(t, client, run, i, s.ClientErr, err, more, s.More, resp, s.Response) {
+					break
+				}
+			}
+		}
+		if !v.SkipServer {
+			for i, s := range v.Steps {
+				more, challenge, err := server.Step(s.Response)
+				if !checkStep(t, server, run, i, s.ServerErr, err, more, s.More, challenge, s.Challenge) {
+					break
+				}
+			}
+		}
+		client.Reset()
+		server.Reset()
+	}
+}
+
+// checkStep reports any difference from the expected result of a step and
+// returns false if the exchange cannot continue.
+func checkStep(t *testing.T, n *sasl.Negotiator, run, i int, wantErr bool, err error, more, wantMore bool, got, want []byte) bool {
+	t.Helper()
+	side := "Client"
+	if n.State().IsServer() {
+		side = "Server"
+	}
+	switch {
+	case err != nil && !n.State().Errored():
+		t.Errorf("Run %d, %s step %d: error state was not set, got error: %v", run, side, i, err)
+	case err == nil && wantErr:
+		t.Errorf("Run %d, %s step %d: expected step to error", run, side, i)
+	case err != nil && !wantErr:
+		t.Errorf("Run %d, %s step %d: unexpected error: %v", run, side, i, err)
+	case err != nil:
+		return false
+	case string(got) != string(want):
+		t.Errorf("Run %d, %s step %d: unexpected message:\nexpected `%s'\n     got `%s'", run, side, i, want, got)
+	case more != wantMore:
+		t.Errorf("Run %d, %s step %d: unexpected value for more: %v", run, side, i, more)
+	default:
+		return true
+	}
+	return false
+}
+
+func checkErrored(t *testing.T, n *sasl.Negotiator) {
+	t.Helper()
+	if !n.State().Errored() {
+		t.Errorf("Error state was not set on %v", n)
+	}
+}
+
+// step calls n.Step and recovers from any panic so that a misbehaving mechanism
+// fails the test instead of crashing the test binary.
+func step(t *testing.T, n *sasl.Negotiator, msg []byte) (err error) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("Step panicked: %v", r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	_, _, err = n.Step(msg)
+	return err
+}
+
+func fixedNonce(nonce []byte) sasl.Option {
+	return sasl.NonceSource(func() []byte {
+		return append([]byte(nil), nonce...)
+	})
+}
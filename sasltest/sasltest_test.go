@@ -0,0 +1,150 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasltest_test
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/sasltest"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func credentials(username, password string) []sasl.Option {
+	return []sasl.Option{sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte(username), []byte(password), nil
+	})}
+}
+
+func mustDecode(s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func acceptPencil(n *sasl.Negotiator) bool {
+	if n.Mechanism().Name != sasl.Plain.Name {
+		return true
+	}
+	_, pass, _ := n.Credentials()
+	return string(pass) == "pencil"
+}
+
+func TestPlain(t *testing.T) {
+	sasltest.Run(t, sasltest.Config{
+		Mechanism:        sasl.Plain,
+		ClientOptions:    credentials("user", "pencil"),
+		BadClientOptions: credentials("user", "pen"),
+		Permissions:      acceptPencil,
+		Malformed:        [][]byte{[]byte("no separators"), []byte("\x00\x00\x00\x00")},
+		Vectors: []sasltest.Vector{{
+			Name: "RFC4616",
+			ClientOptions: []sasl.Option{sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("Kurt"), []byte("xipj3plmq"), []byte("Ursel")
+			})},
+			Permissions: func(*sasl.Negotiator) bool { return true },
+			Steps: []sasltest.Step{
+				{Response: []byte("Ursel\x00Kurt\x00xipj3plmq")},
+				{ClientErr: true, ServerErr: true},
+			},
+		}},
+	})
+}
+
+func TestScram(t *testing.T) {
+	for _, tc := range []struct {
+		mech    sasl.Mechanism
+		h       func() hash.Hash
+		vectors []sasltest.Vector
+	}{
+		{
+			mech: sasl.ScramSha1,
+			h:    sha1.New,
+			vectors: []sasltest.Vector{{
+				Name:          "RFC5802",
+				ClientNonce:   []byte("fyko+d2lbbFgONRv9qkxdawL"),
+				ClientOptions: credentials("user", "pencil"),
+				SkipServer:    true,
+				Steps: []sasltest.Step{
+					{Response: []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL"), More: true},
+					{
+						Challenge: []byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"),
+						Response:  []byte("c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts="),
+						More:      true,
+					},
+					{Challenge: []byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ=")},
+				},
+			}, {
+				Name:        "RFC5802-Server",
+				ServerNonce: []byte("3rfcNHYJY1ZVvWVs7j"),
+				ServerOptions: []sasl.Option{sasl.Store(store{
+					"user": sasl.DeriveStoredCredentials(sha1.New, []byte("pencil"), mustDecode("QSXCR+Q6sek8bf92"), 4096),
+				})},
+				Permissions: acceptPencil,
+				SkipClient:  true,
+				Steps: []sasltest.Step{
+					{
+						Response:  []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL"),
+						Challenge: []byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"),
+						More:      true,
+					},
+					{
+						Response:  []byte("c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts="),
+						Challenge: []byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ="),
+					},
+				},
+			}},
+		},
+		{
+			mech: sasl.ScramSha256,
+			h:    sha256.New,
+			vectors: []sasltest.Vector{{
+				Name:          "RFC7677",
+				ClientNonce:   []byte("rOprNGfwEbeRWgbNEkqO"),
+				ClientOptions: credentials("user", "pencil"),
+				SkipServer:    true,
+				Steps: []sasltest.Step{
+					{Response: []byte("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"), More: true},
+					{
+						Challenge: []byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"),
+						Response:  []byte("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="),
+						More:      true,
+					},
+					{Challenge: []byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")},
+				},
+			}},
+		},
+		{mech: sasl.ScramSha512, h: sha512.New},
+	} {
+		t.Run(tc.mech.Name, func(t *testing.T) {
+			sasltest.Run(t, sasltest.Config{
+				Mechanism:        tc.mech,
+				ClientOptions:    credentials("user", "pencil"),
+				BadClientOptions: credentials("user", "pen"),
+				ServerOptions: []sasl.Option{sasl.Store(store{
+					"user": sasl.DeriveStoredCredentials(tc.h, []byte("pencil"), []byte("salt"), 4096),
+				})},
+				Permissions: acceptPencil,
+				Vectors:     tc.vectors,
+			})
+		})
+	}
+}
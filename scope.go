@@ -0,0 +1,59 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// scopedOptions are options that only apply to one mechanism.
+type scopedOptions struct {
+	mechanism string
+	opts      []Option
+}
+
+// ForMechanism returns an option that applies opts only to negotiators using
+// the mechanism named mechanism, so that a list of options shared by several
+// mechanisms (for example, the options of a ServerFactory or Config, or those
+// passed to Accept or NewFailover) can use different settings for each:
+//
+//	opts := []sasl.Option{
+//		sasl.MinIterations(4096),
+//		sasl.ForMechanism("SCRAM-SHA-512", sasl.MinIterations(10000)),
+//		sasl.ForMechanism("ANONYMOUS", sasl.Trace.Set("guest")),
+//	}
+//
+// The name must match exactly, so variants with and without channel binding
+// must be listed separately.
+// If the mechanism is already known when the option is applied, opts are
+// applied immediately in the order they appear with the other options.
+// Otherwise they are applied when the mechanism is selected, after the shared
+// options but before any options specific to the connection.
+// Options applied to a negotiator are not undone if its mechanism changes, for
+// example because of Upgrade.
+func ForMechanism(mechanism string, opts ...Option) Option {
+	return func(n *Negotiator) {
+		// Copy the slice instead of appending to it because it may be shared
+		// with other negotiators created from the same template.
+		scoped := make([]scopedOptions, len(n.scoped), len(n.scoped)+1)
+		copy(scoped, n.scoped)
+		n.scoped = append(scoped, scopedOptions{mechanism: mechanism, opts: opts})
+		if n.mechanism.Name == mechanism && mechanism != "" {
+			for _, o := range opts {
+				o(n)
+			}
+		}
+	}
+}
+
+// applyScoped applies the options scoped to the negotiator's mechanism.
+// It must be called when the mechanism is selected after the options were
+// applied, for example when a negotiator is created from a template.
+func (c *Negotiator) applyScoped() {
+	for _, s := range c.scoped {
+		if s.mechanism != c.mechanism.Name {
+			continue
+		}
+		for _, o := range s.opts {
+			o(c)
+		}
+	}
+}
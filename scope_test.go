@@ -0,0 +1,44 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"testing"
+)
+
+func TestForMechanism(t *testing.T) {
+	opts := []Option{
+		MinIterations(4096),
+		ForMechanism("SCRAM-SHA-512", MinIterations(10000)),
+		ForMechanism("ANONYMOUS", Trace.Set("guest")),
+	}
+
+	for _, tc := range []struct {
+		n     *Negotiator
+		iter  int
+		trace string
+	}{
+		{n: NewClient(ScramSha256, opts...), iter: 4096},
+		{n: NewClient(ScramSha512, opts...), iter: 10000},
+		{n: NewClient(Anonymous, opts...), iter: 4096, trace: "guest"},
+		{n: new(ConfigBuilder).With(opts...).Build().NewClient(ScramSha512), iter: 10000},
+		{n: new(ConfigBuilder).With(opts...).Build().NewClient(ScramSha512, MinIterations(1)), iter: 1},
+	} {
+		trace, _ := Trace.Get(tc.n)
+		if tc.n.minIterations != tc.iter || trace != tc.trace {
+			t.Errorf("%s: unexpected options: want iter=%d trace=%q, got iter=%d trace=%q", tc.n.Mechanism().Name, tc.iter, tc.trace, tc.n.minIterations, trace)
+		}
+	}
+
+	f := NewServerFactory([]Mechanism{ScramSha256, Plain}, acceptAll, ForMechanism("PLAIN", Confidential(false)))
+	scram, _ := f.NewServer("SCRAM-SHA-256", "")
+	plain, _ := f.NewServer("PLAIN", "")
+	if scram.insecure || !plain.insecure {
+		t.Errorf("Scoped option applied to the wrong factory server: scram=%t, plain=%t", scram.insecure, plain.insecure)
+	}
+	if again, _ := f.NewServer("PLAIN", ""); len(again.scoped) != 1 {
+		t.Errorf("Scoped options accumulated in the factory template: %d", len(again.scoped))
+	}
+}
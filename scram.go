@@ -6,18 +6,22 @@ package sasl
 
 import (
 	"bytes"
-	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"hash"
 	"strconv"
 	"strings"
 
-	"golang.org/x/crypto/pbkdf2"
+	"github.com/jh125486/sasl/scramwire"
 )
 
 const (
 	gs2HeaderCBSupport         = "p=tls-unique,"
+	gs2HeaderServerEndPoint    = "p=tls-server-end-point,"
 	gs2HeaderNoServerCBSupport = "y,"
 	gs2HeaderNoCBSupport       = "n,"
 )
@@ -27,153 +31,362 @@ var (
 	serverKeyInput = []byte("Server Key")
 )
 
+var (
+	errInvalidEncoding = scramwire.ErrInvalidEncoding
+	errReservedAttr    = errors.New("Reserved attribute `m' is not supported")
+	errChannelBinding  = errors.New("Channel binding data does not match")
+	errNoServerCert    = errors.New("Server certificate is required for tls-server-end-point channel binding")
+	errNotScram        = errors.New("Mechanism is not a SCRAM mechanism")
+)
+
 // The number of random bytes to generate for a nonce.
 const noncerandlen = 16
 
-func getGS2Header(name string, n *Negotiator) (gs2Header []byte) {
+// DefaultMinIterations is the smallest iteration count that SCRAM clients will
+// accept from a server unless the MinIterations option is used.
+// It is the minimum recommended by RFC 5802 and RFC 7677.
+const DefaultMinIterations = 4096
+
+// DefaultMaxIterations is the largest iteration count that SCRAM clients will
+// accept from a server unless the MaxIterations option is used.
+const DefaultMaxIterations = 1 << 21
+
+// IterationCountError is returned by SCRAM clients when the server requests an
+// iteration count outside of the allowed range.
+// A Max of zero means that there is no upper limit.
+type IterationCountError struct {
+	Iterations int
+	Min        int
+	Max        int
+}
+
+func (e IterationCountError) Error() string {
+	if e.Max > 0 && e.Iterations > e.Max {
+		return "Server requested " + strconv.Itoa(e.Iterations) + " iterations, more than the maximum of " + strconv.Itoa(e.Max)
+	}
+	return "Server requested " + strconv.Itoa(e.Iterations) + " iterations, fewer than the minimum of " + strconv.Itoa(e.Min)
+}
+
+// Code returns CodeIterationCount.
+func (IterationCountError) Code() Code {
+	return CodeIterationCount
+}
+
+// ScramError is an error sent by a SCRAM server in the server-final message (the
+// server-error-value from RFC 5802 §7).
+// It is returned by SCRAM clients in place of ErrServerSignature when the server
+// reports an error instead of sending its signature.
+// Values other than the constants below may be sent by the server and compare
+// equal to a ScramError created from the same string.
+type ScramError string
+
+// Errors defined by RFC 5802 §7.
+const (
+	ScramInvalidEncoding                 ScramError = "invalid-encoding"
+	ScramExtensionsNotSupported          ScramError = "extensions-not-supported"
+	ScramInvalidProof                    ScramError = "invalid-proof"
+	ScramChannelBindingsDontMatch        ScramError = "channel-bindings-dont-match"
+	ScramServerDoesSupportChannelBinding ScramError = "server-does-support-channel-binding"
+	ScramChannelBindingNotSupported      ScramError = "channel-binding-not-supported"
+	ScramUnsupportedChannelBindingType   ScramError = "unsupported-channel-binding-type"
+	ScramUnknownUser                     ScramError = "unknown-user"
+	ScramInvalidUsernameEncoding         ScramError = "invalid-username-encoding"
+	ScramNoResources                     ScramError = "no-resources"
+	ScramOtherError                      ScramError = "other-error"
+)
+
+func (e ScramError) Error() string {
+	return "Server returned SCRAM error " + string(e)
+}
+
+// Code returns "scram-" followed by the error, for example
+// "scram-invalid-proof".
+// Errors other than the constants above have the code "scram-other-error" so
+// that servers cannot create arbitrary codes.
+func (e ScramError) Code() Code {
+	switch e {
+	case ScramInvalidEncoding, ScramExtensionsNotSupported, ScramInvalidProof,
+		ScramChannelBindingsDontMatch, ScramServerDoesSupportChannelBinding,
+		ScramChannelBindingNotSupported, ScramUnsupportedChannelBindingType,
+		ScramUnknownUser, ScramInvalidUsernameEncoding, ScramNoResources:
+		return Code("scram-" + string(e))
+	}
+	return Code("scram-" + string(ScramOtherError))
+}
+
+// Unwrap returns ErrAuthn if the server rejected the credentials and
+// ErrUnknownUser if it did not recognize the username, so that the error can
+// be treated like the equivalent failure on the server side.
+func (e ScramError) Unwrap() error {
+	switch e {
+	case ScramInvalidProof:
+		return ErrAuthn
+	case ScramUnknownUser:
+		return ErrUnknownUser
+	}
+	return nil
+}
+
+// Temporary reports whether the server was temporarily unable to complete the
+// authentication (no-resources).
+func (e ScramError) Temporary() bool {
+	return e == ScramNoResources
+}
+
+// gs2Base returns the channel binding flag of the GS2 header and the
+// authorization identity, if any.
+func gs2Base(name string, n *Negotiator) (string, []byte) {
+	if n.postgres {
+		// PostgreSQL only supports tls-server-end-point channel binding and does not
+		// support authorization identities.
+		switch {
+		case n.TLSState() == nil:
+			return gs2HeaderNoCBSupport, nil
+		case IsPlus(name):
+			return gs2HeaderServerEndPoint, nil
+		case n.advertiseCB():
+			return gs2HeaderNoServerCBSupport, nil
+		}
+		return gs2HeaderNoCBSupport, nil
+	}
+
 	_, _, identity := n.Credentials()
 	switch {
-	case n.TLSState() == nil || !strings.HasSuffix(name, "-PLUS"):
-		// We do not support channel binding
-		gs2Header = []byte(gs2HeaderNoCBSupport)
-	case n.State()&RemoteCB == RemoteCB:
+	case n.TLSState() == nil || len(n.TLSState().TLSUnique) == 0:
+		// We do not support channel binding, or the connection has no tls-unique
+		// value (for example, with TLS 1.3)
+		return gs2HeaderNoCBSupport, identity
+	case !IsPlus(name):
+		// We support channel binding but selected a mechanism without it
+		if n.advertiseCB() {
+			return gs2HeaderNoServerCBSupport, identity
+		}
+		return gs2HeaderNoCBSupport, identity
+	case n.State().RemoteSupportsCB():
 		// We support channel binding and the server does too
-		gs2Header = []byte(gs2HeaderCBSupport)
-	case n.State()&RemoteCB != RemoteCB:
-		// We support channel binding but the server does not
-		gs2Header = []byte(gs2HeaderNoServerCBSupport)
+		return gs2HeaderCBSupport, identity
 	}
-	if len(identity) > 0 {
-		gs2Header = append(gs2Header, []byte(`a=`)...)
-		gs2Header = append(gs2Header, identity...)
+	// We support channel binding but the server does not
+	return gs2HeaderNoServerCBSupport, identity
+}
+
+// advertiseCB reports whether a client that supports channel binding but
+// selected a mechanism without it should send the "y" flag.
+func (c *Negotiator) advertiseCB() bool {
+	if c.cbFlagSet {
+		return c.cbAdvertise
 	}
-	gs2Header = append(gs2Header, ',')
-	return
+	return c.postgres
+}
+
+// channelBindingType returns the channel binding type selected by the
+// negotiator's options.
+func channelBindingType(m *Negotiator) string {
+	if m.postgres {
+		return ChannelBindingTLSServerEndPoint
+	}
+	return ChannelBindingTLSUnique
+}
+
+// channelBindingData returns the channel binding data for the TLS connection
+// using the type selected by the negotiator's options.
+func channelBindingData(m *Negotiator, tlsState *tls.ConnectionState) ([]byte, error) {
+	if !m.postgres {
+		return tlsState.TLSUnique, nil
+	}
+	return tlsServerEndPoint(tlsState)
+}
+
+// serverChannelBinding returns the channel binding data of type cbType for the
+// server's TLS connection, or nil if the server cannot provide it (for example,
+// TLS 1.3 connections have no tls-unique value).
+func serverChannelBinding(m *Negotiator, cbType string) []byte {
+	tlsState := m.TLSState()
+	if tlsState == nil {
+		return nil
+	}
+	switch cbType {
+	case ChannelBindingTLSUnique:
+		return tlsState.TLSUnique
+	case ChannelBindingTLSServerEndPoint:
+		if m.serverCert != nil {
+			return certificateHash(m.serverCert)
+		}
+	}
+	return nil
+}
+
+// canBindChannel reports whether a server can verify channel binding to its TLS
+// connection, so that it can offer the -PLUS mechanisms.
+func (c *Negotiator) canBindChannel() bool {
+	return len(serverChannelBinding(c, ChannelBindingTLSUnique)) > 0 ||
+		len(serverChannelBinding(c, ChannelBindingTLSServerEndPoint)) > 0
+}
+
+// offersPlus reports whether a server offers the -PLUS mechanisms on its
+// connection.
+// Servers created by NewServer are assumed to offer them whenever they can bind
+// to the connection, those created by a ServerFactory also need the -PLUS
+// variants to be in the list of mechanisms.
+func (c *Negotiator) offersPlus() bool {
+	return !c.noPlus && c.canBindChannel()
+}
+
+// tlsServerEndPoint returns the tls-server-end-point channel binding data
+// defined in RFC 5929 §4: a hash of the server's certificate.
+func tlsServerEndPoint(tlsState *tls.ConnectionState) ([]byte, error) {
+	if len(tlsState.PeerCertificates) == 0 {
+		return nil, errNoServerCert
+	}
+	return certificateHash(tlsState.PeerCertificates[0]), nil
+}
+
+// certificateHash hashes cert with the hash function of its signature
+// algorithm as required by tls-server-end-point.
+func certificateHash(cert *x509.Certificate) []byte {
+	var h hash.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		h = sha512.New384()
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		h = sha512.New()
+	default:
+		// MD5 and SHA-1 are replaced by SHA-256.
+		h = sha256.New()
+	}
+	h.Write(cert.Raw)
+	return h.Sum(nil)
 }
 
 func scram(name string, fn func() hash.Hash) Mechanism {
-	// BUG(ssw): We need a way to cache the SCRAM client and server key
-	// calculations.
 	return Mechanism{
 		Name: name,
+		Capabilities: Capabilities{
+			ChannelBinding: IsPlus(name),
+			MutualAuth:     true,
+			RoundTrips:     2,
+		},
 		Start: func(m *Negotiator) (bool, []byte, interface{}, error) {
-			user, _, _ := m.Credentials()
-
-			// Escape "=" and ",". This is mostly the same as bytes.Replace but
-			// faster because we can do both replacements in a single pass.
-			n := bytes.Count(user, []byte{'='}) + bytes.Count(user, []byte{','})
-			username := make([]byte, len(user)+(n*2))
-			w := 0
-			start := 0
-			for i := 0; i < n; i++ {
-				j := start
-				j += bytes.IndexAny(user[start:], "=,")
-				w += copy(username[w:], user[start:j])
-				switch user[j] {
-				case '=':
-					w += copy(username[w:], "=3D")
-				case ',':
-					w += copy(username[w:], "=2C")
+			var username []byte
+			// PostgreSQL ignores the SCRAM username in favor of the one from the
+			// startup message and expects it to be empty.
+			if !m.postgres {
+				user, _, err := m.preparedCredentials()
+				if err != nil {
+					return false, nil, nil, err
 				}
-				start = j + 1
+				username = user
 			}
-			copy(username[w:], user[start:])
 
-			clientFirstMessage := make([]byte, 5+len(m.Nonce())+len(username))
-			copy(clientFirstMessage, "n=")
-			copy(clientFirstMessage[2:], username)
-			copy(clientFirstMessage[2+len(username):], ",r=")
-			copy(clientFirstMessage[5+len(username):], m.Nonce())
+			prefix := m.scramFragments.clientFirstPrefix(username)
+			clientFirstMessage := make([]byte, len(prefix), len(prefix)+len(m.Nonce()))
+			copy(clientFirstMessage, prefix)
+			clientFirstMessage = append(clientFirstMessage, m.Nonce()...)
+			clientFirstMessage = appendScramExtensions(clientFirstMessage, m.scramExtFirst)
 
 			return true, append(getGS2Header(name, m), clientFirstMessage...), clientFirstMessage, nil
 		},
 		Next: func(m *Negotiator, challenge []byte, data interface{}) (more bool, resp []byte, cache interface{}, err error) {
-			if challenge == nil || len(challenge) == 0 {
+			if len(challenge) == 0 {
+				// A client that has sent its final message is waiting for the server
+				// signature; if it never arrives the server was not authenticated.
+				if !m.State().IsServer() && m.State().Step() == ResponseSent {
+					return more, resp, cache, ErrServerSignature
+				}
 				return more, resp, cache, ErrInvalidChallenge
 			}
 
-			if m.State()&Receiving == Receiving {
-				panic("not yet implemented")
+			if m.State().IsServer() {
+				return scramServerNext(name, fn, m, challenge, data)
 			}
 			return scramClientNext(name, fn, m, challenge, data)
 		},
+		Abort: func(m *Negotiator, data interface{}) []byte {
+			// Once the server-first-message has been sent the client is waiting for
+			// a server-final-message, which can carry an error instead of the
+			// signature.
+			if _, ok := data.(*scramServerState); ok && m.State().Step() == ResponseSent {
+				return []byte("e=" + ScramOtherError)
+			}
+			return nil
+		},
 	}
 }
 
 func scramClientNext(name string, fn func() hash.Hash, m *Negotiator, challenge []byte, data interface{}) (more bool, resp []byte, cache interface{}, err error) {
-	_, password, _ := m.Credentials()
 	state := m.State()
 
-	switch state & StepMask {
-	case AuthTextSent:
-		iter := -1
-		var salt, nonce []byte
-		for _, field := range bytes.Split(challenge, []byte{','}) {
-			if len(field) < 3 || (len(field) >= 2 && field[1] != '=') {
-				continue
-			}
-			switch field[0] {
-			case 'i':
-				ival := string(bytes.TrimRight(field[2:], "\x00"))
+	// Any workarounds only apply to parsing, the challenge is still used as is
+	// in the AuthMessage.
+	parsed := m.normalizeScram(challenge)
 
-				if iter, err = strconv.Atoi(ival); err != nil {
-					return
-				}
-			case 's':
-				salt = make([]byte, base64.StdEncoding.DecodedLen(len(field)-2))
-				var n int
-				n, err = base64.StdEncoding.Decode(salt, field[2:])
-				salt = salt[:n]
-				if err != nil {
+	switch state.Step() {
+	case AuthTextSent:
+		if err = checkScramOrder(m, parsed, kindServerFirst); err != nil {
+			return
+		}
+		var (
+			iter        int
+			salt, nonce []byte
+		)
+		// Check the length of the salt before decoding it.
+		for _, field := range bytes.Split(parsed, []byte{','}) {
+			if salt, ok := bytes.CutPrefix(field, []byte("s=")); ok {
+				if err = m.checkEncodedSalt(salt); err != nil {
 					return
 				}
-			case 'r':
-				nonce = field[2:]
-			case 'm':
-				// RFC 5802:
-				// m: This attribute is reserved for future extensibility.  In this
-				// version of SCRAM, its presence in a client or a server message
-				// MUST cause authentication failure when the attribute is parsed by
-				// the other end.
-				err = errors.New("Server sent reserved attribute `m'")
-				return
 			}
 		}
-
-		switch {
-		case iter < 0:
-			err = errors.New("Iteration count is invalid")
+		if m.strictScram {
+			nonce, salt, iter, err = parseServerFirstStrict(parsed)
+		} else {
+			nonce, salt, iter, err = parseServerFirst(parsed)
+		}
+		if err != nil {
+			return
+		}
+		if err = m.CheckField("nonce", nonce); err != nil {
 			return
-		case nonce == nil || !bytes.HasPrefix(nonce, m.Nonce()):
-			err = errors.New("Server nonce does not match client nonce")
+		}
+		if err = m.CheckField("salt", salt); err != nil {
+			return
+		}
+		if !bytes.HasPrefix(nonce, m.Nonce()) {
+			err = NonceError{Mismatch: true, Reason: "server nonce does not start with the client nonce"}
+			return
+		}
+		if err = m.checkNonce(nonce[len(m.Nonce()):]); err != nil {
 			return
-		case salt == nil:
-			err = errors.New("Server sent empty salt")
+		}
+		m.remoteExts = append(m.remoteExts, scramExtensions(parsed, "rsi")...)
+		if iter < m.minIterations || (m.maxIterations > 0 && iter > m.maxIterations) {
+			err = IterationCountError{Iterations: iter, Min: m.minIterations, Max: m.maxIterations}
 			return
 		}
 
 		gs2Header := getGS2Header(name, m)
-		tlsState := m.TLSState()
-		var channelBinding []byte
-		if tlsState != nil && strings.HasSuffix(name, "-PLUS") {
-			channelBinding = make(
-				[]byte,
-				2+base64.StdEncoding.EncodedLen(len(gs2Header)+len(tlsState.TLSUnique)),
-			)
-			base64.StdEncoding.Encode(channelBinding[2:], append(gs2Header, tlsState.TLSUnique...))
-			channelBinding[0] = 'c'
-			channelBinding[1] = '='
-		} else {
-			channelBinding = make(
-				[]byte,
-				2+base64.StdEncoding.EncodedLen(len(gs2Header)),
-			)
-			base64.StdEncoding.Encode(channelBinding[2:], gs2Header)
-			channelBinding[0] = 'c'
-			channelBinding[1] = '='
+		cbData := gs2Header
+		if tlsState := m.TLSState(); tlsState != nil && IsPlus(name) && bytes.HasPrefix(gs2Header, []byte("p=")) {
+			var cb []byte
+			if cb, err = channelBindingData(m, tlsState); err != nil {
+				return
+			}
+			if len(cb) == 0 {
+				err = errChannelBinding
+				return
+			}
+			cbData = append(cbData, cb...)
+			// The server verifies the binding, so it is only reported once the
+			// exchange completes.
+			m.cbType, m.cbData = channelBindingType(m), cb
 		}
+		channelBinding := make([]byte, 2+base64.StdEncoding.EncodedLen(len(cbData)))
+		base64.StdEncoding.Encode(channelBinding[2:], cbData)
+		channelBinding[0] = 'c'
+		channelBinding[1] = '='
 		clientFinalMessageWithoutProof := append(channelBinding, []byte(",r=")...)
 		clientFinalMessageWithoutProof = append(clientFinalMessageWithoutProof, nonce...)
+		clientFinalMessageWithoutProof = appendScramExtensions(clientFinalMessageWithoutProof, m.scramExtFinal)
 
 		clientFirstMessage := data.([]byte)
 		authMessage := append(clientFirstMessage, ',')
@@ -181,44 +394,519 @@ func scramClientNext(name string, fn func() hash.Hash, m *Negotiator, challenge
 		authMessage = append(authMessage, ',')
 		authMessage = append(authMessage, clientFinalMessageWithoutProof...)
 
-		saltedPassword := pbkdf2.Key(password, salt, iter, fn().Size(), fn)
-
-		h := hmac.New(fn, saltedPassword)
-		h.Write(serverKeyInput)
-		serverKey := h.Sum(nil)
-		h.Reset()
+		var username, password []byte
+		username, password, err = m.preparedCredentials()
+		if err != nil {
+			return
+		}
 
-		h.Write(clientKeyInput)
-		clientKey := h.Sum(nil)
+		hs := m.scramHasher(fn)
+		m.timing.cost.Iterations = iter
+		st := scramClientState{}
+		var saltedPassword, serverKey, clientKey []byte
+		sec := m.scramSecret
+		if sec == nil && m.sealedSecret != nil {
+			sec, err = m.unsealSecret(salt, iter)
+			if err != nil {
+				return
+			}
+			defer zero(sec.ClientKey)
+			defer zero(sec.ServerKey)
+		}
+		if sec != nil {
+			if sec.Iterations != iter || !bytes.Equal(sec.Salt, salt) {
+				err = ErrSecretMismatch
+				return
+			}
+			if len(sec.SaltedPassword) > 0 {
+				clientKey, serverKey = hs.keys(sec.SaltedPassword)
+			} else {
+				clientKey = append([]byte(nil), sec.ClientKey...)
+				serverKey = append([]byte(nil), sec.ServerKey...)
+			}
+		} else {
+			if m.keyCache != nil {
+				st.id = keyCacheID(name, username, salt, iter)
+				if keys, ok := m.keyCache.GetKeys(st.id); ok {
+					clientKey = append([]byte(nil), keys.ClientKey...)
+					serverKey = append([]byte(nil), keys.ServerKey...)
+				}
+			}
+			if clientKey == nil {
+				err = m.Expensive(func() {
+					kdfStart := m.Now()
+					saltedPassword = m.kdf(password, salt, iter, hs.size(), fn)
+					m.observeKDF(kdfStart)
+				})
+				if err != nil {
+					return
+				}
+				clientKey, serverKey = hs.keys(saltedPassword)
 
-		h = hmac.New(fn, serverKey)
-		h.Write(authMessage)
-		serverSignature := h.Sum(nil)
+				if m.keyCache != nil {
+					st.keys = ScramKeys{
+						ClientKey: append([]byte(nil), clientKey...),
+						ServerKey: append([]byte(nil), serverKey...),
+					}
+				}
+			}
+		}
 
-		h = fn()
-		h.Write(clientKey)
-		storedKey := h.Sum(nil)
-		h = hmac.New(fn, storedKey)
-		h.Write(authMessage)
-		clientSignature := h.Sum(nil)
+		if m.serverKeyStore != nil {
+			st.pinID = serverKeyID(m.serverKeyServer, name, username)
+			st.pin = ServerKeyPin{
+				Salt:        append([]byte(nil), salt...),
+				Iterations:  iter,
+				Fingerprint: hs.digest(nil, serverKey),
+			}
+		}
+		st.serverSignature = hs.mac(nil, serverKey, authMessage)
+		storedKey := hs.digest(nil, clientKey)
+		clientSignature := hs.mac(nil, storedKey, authMessage)
 		clientProof := make([]byte, len(clientKey))
 		xorBytes(clientProof, clientKey, clientSignature)
+		for _, b := range []*[]byte{&saltedPassword, &serverKey, &clientKey, &storedKey, &clientSignature, &clientProof, &st.serverSignature} {
+			if *b, err = m.moveSecret(*b); err != nil {
+				return
+			}
+		}
 
 		encodedClientProof := make([]byte, base64.StdEncoding.EncodedLen(len(clientProof)))
 		base64.StdEncoding.Encode(encodedClientProof, clientProof)
 		clientFinalMessage := append(clientFinalMessageWithoutProof, []byte(",p=")...)
 		clientFinalMessage = append(clientFinalMessage, encodedClientProof...)
 
-		return true, clientFinalMessage, serverSignature, nil
+		if m.wipeSecrets {
+			for _, b := range [][]byte{saltedPassword, serverKey, clientKey, storedKey, clientSignature, clientProof} {
+				zero(b)
+			}
+		}
+
+		return true, clientFinalMessage, st, nil
 	case ResponseSent:
-		clientCalculatedServerFinalMessage := "v=" + base64.StdEncoding.EncodeToString(data.([]byte))
-		if clientCalculatedServerFinalMessage != string(challenge) {
-			err = ErrAuthn
+		if err = checkScramOrder(m, parsed, kindServerFinal); err != nil {
+			return
+		}
+		if m.strictScram {
+			if err = checkServerFinalStrict(parsed); err != nil {
+				return
+			}
+		}
+		st := data.(scramClientState)
+		clientCalculatedServerFinalMessage := "v=" + base64.StdEncoding.EncodeToString(st.serverSignature)
+		if m.wipeSecrets {
+			zero(st.serverSignature)
+		}
+		verifier, _, _ := bytes.Cut(parsed, []byte{','})
+		if value, ok := bytes.CutPrefix(verifier, []byte("e=")); ok && len(value) > 0 {
+			err = ScramError(value)
+			return
+		}
+		if !ConstantTimeEqual([]byte(clientCalculatedServerFinalMessage), verifier) {
+			err = ErrServerSignature
 			return
 		}
+		m.remoteExts = append(m.remoteExts, scramExtensions(parsed, "ve")...)
+		if m.serverKeyStore != nil {
+			if err = m.checkServerKey(st.pinID, st.pin); err != nil {
+				return
+			}
+		}
 		// Success!
+		m.SetServerVerified()
+		if st.keys.ClientKey != nil {
+			m.keyCache.PutKeys(st.id, st.keys)
+		}
 		return false, nil, nil, nil
 	}
 	err = ErrInvalidState
 	return
 }
+
+// scramClientState is cached by clients between the client-final message and
+// the server-final message.
+type scramClientState struct {
+	serverSignature []byte
+	id              KeyCacheID
+	keys            ScramKeys
+	pinID           ServerKeyID
+	pin             ServerKeyPin
+}
+
+// scramServerState is cached by servers between the client-first and
+// client-final messages.
+type scramServerState struct {
+	gs2Header       []byte
+	cbType          string
+	cbData          []byte
+	clientFirstBare []byte
+	serverFirst     []byte
+	nonce           []byte
+	username        []byte
+	identity        []byte
+	creds           StoredCredentials
+
+	// unknown is true if creds were made up for a user that does not exist.
+	unknown bool
+}
+
+func scramServerNext(name string, fn func() hash.Hash, m *Negotiator, challenge []byte, data interface{}) (more bool, resp []byte, cache interface{}, err error) {
+	switch m.State().Step() {
+	case AuthTextSent:
+		if err = checkScramOrder(m, challenge, kindClientFirst); err != nil {
+			return
+		}
+		return scramServerFirst(name, fn, m, challenge)
+	case ResponseSent:
+		if err = checkScramOrder(m, challenge, kindClientFinal); err != nil {
+			return
+		}
+		state, ok := data.(*scramServerState)
+		if !ok {
+			err = ErrInvalidState
+			return
+		}
+		return scramServerFinal(name, fn, m, challenge, state)
+	}
+	err = ErrTooManySteps
+	return
+}
+
+// scramServerFirst handles the client-first message and returns the
+// server-first message.
+func scramServerFirst(name string, fn func() hash.Hash, m *Negotiator, clientFirst []byte) (bool, []byte, interface{}, error) {
+	raw := clientFirst
+	clientFirst = m.normalizeScram(clientFirst)
+	missingGS2 := m.workarounds&WorkaroundMissingGS2Header != 0 && bytes.HasPrefix(clientFirst, []byte("n="))
+	if missingGS2 {
+		clientFirst = append([]byte(gs2HeaderNoCBSupport+","), clientFirst...)
+	}
+
+	// gs2-header = gs2-cbind-flag "," [ authzid ] ","
+	i := bytes.IndexByte(clientFirst, ',')
+	if i == -1 {
+		return false, nil, nil, ErrInvalidChallenge
+	}
+	j := bytes.IndexByte(clientFirst[i+1:], ',')
+	if j == -1 {
+		return false, nil, nil, ErrInvalidChallenge
+	}
+	j += i + 1
+	cbFlag, authzid := clientFirst[:i], clientFirst[i+1:j]
+	state := &scramServerState{
+		gs2Header:       clientFirst[:j+1],
+		clientFirstBare: clientFirst[j+1:],
+	}
+
+	plus := IsPlus(name)
+	switch {
+	case bytes.Equal(cbFlag, []byte("n")):
+		if plus {
+			return false, nil, nil, errChannelBinding
+		}
+	case bytes.Equal(cbFlag, []byte("y")):
+		// The client supports channel binding but thinks that we don't.
+		// If we do, the mechanism list was probably tampered with.
+		if plus || m.offersPlus() {
+			return false, nil, nil, errChannelBinding
+		}
+	case bytes.HasPrefix(cbFlag, []byte("p=")):
+		// Binding to empty data would not bind the exchange to anything.
+		state.cbType = string(cbFlag[2:])
+		state.cbData = serverChannelBinding(m, state.cbType)
+		if !plus || len(state.cbData) == 0 {
+			return false, nil, nil, errChannelBinding
+		}
+	default:
+		return false, nil, nil, ErrInvalidChallenge
+	}
+
+	if len(authzid) > 0 {
+		if !bytes.HasPrefix(authzid, []byte("a=")) {
+			return false, nil, nil, ErrInvalidChallenge
+		}
+		identity, err := scramwire.Unescape(authzid[2:])
+		if err != nil {
+			return false, nil, nil, err
+		}
+		if err = m.CheckField("identity", identity); err != nil {
+			return false, nil, nil, err
+		}
+		state.identity = identity
+	}
+
+	var clientNonce []byte
+	for k, field := range bytes.Split(state.clientFirstBare, []byte{','}) {
+		if len(field) < 2 || field[1] != '=' {
+			return false, nil, nil, ErrInvalidChallenge
+		}
+		switch {
+		case field[0] == 'm':
+			return false, nil, nil, errReservedAttr
+		case k == 0 && field[0] == 'n':
+			username, err := scramwire.Unescape(field[2:])
+			if err != nil {
+				return false, nil, nil, err
+			}
+			if err = m.CheckField("username", username); err != nil {
+				return false, nil, nil, err
+			}
+			state.username = username
+		case k == 1 && field[0] == 'r':
+			clientNonce = field[2:]
+			if err := m.CheckField("nonce", clientNonce); err != nil {
+				return false, nil, nil, err
+			}
+			if err := m.checkNonce(clientNonce); err != nil {
+				return false, nil, nil, err
+			}
+		case k < 2:
+			return false, nil, nil, ErrInvalidChallenge
+		default:
+			m.remoteExts = append(m.remoteExts, scramwire.Attribute{Name: field[0], Value: append([]byte(nil), field[2:]...)})
+		}
+	}
+	if len(state.username) == 0 || len(clientNonce) == 0 {
+		return false, nil, nil, ErrInvalidChallenge
+	}
+	// The AuthMessage must contain the message exactly as it was received, even
+	// if workarounds changed it for parsing.
+	if !missingGS2 {
+		_, raw, _ = bytes.Cut(raw, []byte{','})
+		_, raw, _ = bytes.Cut(raw, []byte{','})
+	}
+	state.clientFirstBare = raw
+
+	if m.store == nil {
+		return false, nil, nil, ErrAuthn
+	}
+	username, err := m.CanonicalUsername(state.username)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	state.username = username
+	creds, err := m.scramCredentials(name, state.username)
+	switch {
+	case errors.Is(err, ErrUnknownUser) && (m.fakeUserKey != nil || m.genericFailures()):
+		creds = m.fakeCredentials(fn, name, state.username)
+		state.unknown = true
+	case err != nil:
+		return false, nil, nil, err
+	}
+	state.creds = creds
+
+	serverNonce, err := m.serverNonce()
+	if err != nil {
+		return false, nil, nil, err
+	}
+	state.nonce = make([]byte, 0, len(clientNonce)+len(serverNonce))
+	state.nonce = append(state.nonce, clientNonce...)
+	state.nonce = append(state.nonce, serverNonce...)
+
+	serverFirst := make([]byte, 0, 3+len(state.nonce)+3+base64.StdEncoding.EncodedLen(len(creds.Salt))+3+10)
+	serverFirst = append(serverFirst, "r="...)
+	serverFirst = append(serverFirst, state.nonce...)
+	serverFirst = append(serverFirst, ",s="...)
+	serverFirst = append(serverFirst, base64.StdEncoding.EncodeToString(creds.Salt)...)
+	serverFirst = append(serverFirst, ",i="...)
+	serverFirst = strconv.AppendInt(serverFirst, int64(creds.Iterations), 10)
+	serverFirst = appendScramExtensions(serverFirst, m.scramExtFirst)
+	state.serverFirst = serverFirst
+
+	return true, serverFirst, state, nil
+}
+
+// scramServerFinal handles the client-final message, verifies the client proof,
+// and returns the server-final message.
+func scramServerFinal(name string, fn func() hash.Hash, m *Negotiator, clientFinal []byte, state *scramServerState) (bool, []byte, interface{}, error) {
+	authFinal := clientFinal
+	clientFinal = m.normalizeScram(clientFinal)
+
+	// The proof must be the last attribute.
+	i := bytes.LastIndex(clientFinal, []byte(",p="))
+	if i == -1 {
+		return false, nil, nil, ErrInvalidChallenge
+	}
+	clientFinalWithoutProof := clientFinal[:i]
+	// The AuthMessage must contain the message exactly as it was received, even
+	// if workarounds changed it for parsing.
+	authFinal = authFinal[:bytes.LastIndexByte(authFinal, ',')]
+	proof, err := base64.StdEncoding.DecodeString(string(clientFinal[i+3:]))
+	if err != nil {
+		return false, nil, nil, err
+	}
+
+	fields := bytes.Split(clientFinalWithoutProof, []byte{','})
+	if len(fields) < 2 || !bytes.HasPrefix(fields[0], []byte("c=")) || !bytes.HasPrefix(fields[1], []byte("r=")) {
+		return false, nil, nil, ErrInvalidChallenge
+	}
+	for _, field := range fields[2:] {
+		if bytes.HasPrefix(field, []byte("m=")) {
+			return false, nil, nil, errReservedAttr
+		}
+	}
+	m.remoteExts = append(m.remoteExts, scramExtensions(bytes.Join(fields[2:], []byte{','}), "")...)
+
+	cbind, err := base64.StdEncoding.DecodeString(string(fields[0][2:]))
+	if err != nil {
+		return false, nil, nil, err
+	}
+	expectedCBind := append(state.gs2Header[:len(state.gs2Header):len(state.gs2Header)], state.cbData...)
+	if !bytes.Equal(cbind, expectedCBind) {
+		return false, nil, nil, errChannelBinding
+	}
+	if len(state.cbData) > 0 {
+		m.cbType, m.cbData = state.cbType, state.cbData
+	}
+	if !bytes.Equal(fields[1][2:], state.nonce) {
+		return false, nil, nil, NonceError{Mismatch: true, Reason: "client-final nonce does not match the client-first and server-first nonces"}
+	}
+
+	authMessage := make([]byte, 0, len(state.clientFirstBare)+len(state.serverFirst)+len(authFinal)+2)
+	authMessage = append(authMessage, state.clientFirstBare...)
+	authMessage = append(authMessage, ',')
+	authMessage = append(authMessage, state.serverFirst...)
+	authMessage = append(authMessage, ',')
+	authMessage = append(authMessage, authFinal...)
+
+	hs := m.scramHasher(fn)
+	m.timing.cost.Iterations = state.creds.Iterations
+	verifyStart := m.Now()
+	switch {
+	case m.verifier != nil && !state.unknown:
+		err = m.verifier.VerifyProof(m.Context(), name, state.username, authMessage, proof)
+	case !verifyScramProof(hs, state.creds.StoredKey, authMessage, proof) || state.unknown:
+		err = ErrAuthn
+	}
+	m.observeVerify(verifyStart)
+	if err != nil {
+		return false, nil, nil, err
+	}
+
+	if !m.Permissions(Credentials(func() (Username, Password, Identity []byte) {
+		return state.username, nil, state.identity
+	})) {
+		return false, nil, nil, ErrAuthn
+	}
+
+	var serverSignature []byte
+	if m.verifier != nil {
+		if serverSignature, err = m.verifier.ServerSignature(m.Context(), name, state.username, authMessage); err != nil {
+			return false, nil, nil, err
+		}
+	} else {
+		serverSignature = hs.mac(nil, state.creds.ServerKey, authMessage)
+	}
+	serverFinal := make([]byte, 2+base64.StdEncoding.EncodedLen(len(serverSignature)))
+	serverFinal[0] = 'v'
+	serverFinal[1] = '='
+	base64.StdEncoding.Encode(serverFinal[2:], serverSignature)
+	serverFinal = appendScramExtensions(serverFinal, m.scramExtFinal)
+
+	return false, serverFinal, nil, nil
+}
+
+// scramExtensions returns the attributes of msg other than those named in
+// known.
+func scramExtensions(msg []byte, known string) []scramwire.Attribute {
+	var ext []scramwire.Attribute
+	for _, field := range bytes.Split(msg, []byte{','}) {
+		if len(field) < 2 || field[1] != '=' || strings.IndexByte(known, field[0]) != -1 {
+			continue
+		}
+		ext = append(ext, scramwire.Attribute{Name: field[0], Value: append([]byte(nil), field[2:]...)})
+	}
+	return ext
+}
+
+func appendScramExtensions(dst []byte, ext []scramwire.Attribute) []byte {
+	for _, a := range ext {
+		dst = append(dst, ',', a.Name, '=')
+		dst = append(dst, a.Value...)
+	}
+	return dst
+}
+
+// parseServerFirst leniently parses a server-first message, skipping anything
+// that it does not understand.
+func parseServerFirst(challenge []byte) (nonce, salt []byte, iter int, err error) {
+	iter = -1
+	for _, field := range bytes.Split(challenge, []byte{','}) {
+		if len(field) < 3 || (len(field) >= 2 && field[1] != '=') {
+			continue
+		}
+		switch field[0] {
+		case 'i':
+			ival := string(bytes.TrimRight(field[2:], "\x00"))
+
+			if iter, err = strconv.Atoi(ival); err != nil {
+				return
+			}
+		case 's':
+			salt = make([]byte, base64.StdEncoding.DecodedLen(len(field)-2))
+			var n int
+			n, err = base64.StdEncoding.Decode(salt, field[2:])
+			salt = salt[:n]
+			if err != nil {
+				return
+			}
+		case 'r':
+			nonce = field[2:]
+		case 'm':
+			// RFC 5802:
+			// m: This attribute is reserved for future extensibility.  In this
+			// version of SCRAM, its presence in a client or a server message
+			// MUST cause authentication failure when the attribute is parsed by
+			// the other end.
+			err = errors.New("Server sent reserved attribute `m'")
+			return
+		}
+	}
+
+	switch {
+	case iter < 0:
+		err = errors.New("Iteration count is invalid")
+	case nonce == nil:
+		err = errors.New("Server nonce does not match client nonce")
+	case salt == nil:
+		err = errors.New("Server sent empty salt")
+	}
+	return
+}
+
+var (
+	errMalformedMessage = errors.New("Malformed SCRAM message")
+	errDuplicateAttr    = errors.New("SCRAM message contains a duplicate attribute")
+)
+
+// parseServerFirstStrict parses a server-first message exactly as specified by
+// the grammar in RFC 5802 using the scramwire package.
+// Since reserved-mext must cause authentication to fail, it is always rejected.
+func parseServerFirstStrict(challenge []byte) (nonce, salt []byte, iter int, err error) {
+	msg, err := scramwire.ParseServerFirst(challenge)
+	if err != nil {
+		return nil, nil, 0, scramwireError(err)
+	}
+	return msg.Nonce, msg.Salt, msg.Iterations, nil
+}
+
+// checkServerFinalStrict validates that a server-final message matches the
+// grammar in RFC 5802 using the scramwire package.
+func checkServerFinalStrict(challenge []byte) error {
+	if _, err := scramwire.ParseServerFinal(challenge); err != nil {
+		return scramwireError(err)
+	}
+	return nil
+}
+
+// scramwireError converts an error from the scramwire parsers to the error
+// returned by the SCRAM mechanisms.
+func scramwireError(err error) error {
+	switch err {
+	case scramwire.ErrReservedAttr:
+		return errReservedAttr
+	case scramwire.ErrDuplicateAttr:
+		return errDuplicateAttr
+	}
+	return errMalformedMessage
+}
@@ -0,0 +1,802 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jh125486/sasl/scramwire"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+type mapStore map[string]StoredCredentials
+
+func (s mapStore) ScramCredentials(_ string, username []byte) (StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func mustDecode(s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestScramServerRFC5802(t *testing.T) {
+	store := mapStore{
+		"user": DeriveStoredCredentials(sha1.New, []byte("pencil"), mustDecode("QSXCR+Q6sek8bf92"), 4096),
+	}
+	server := NewServer(ScramSha1, acceptAll, Store(store))
+	server.nonce = []byte("3rfcNHYJY1ZVvWVs7j")
+
+	steps := []struct {
+		in, out string
+		more    bool
+	}{
+		{
+			in:   "n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL",
+			out:  "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096",
+			more: true,
+		},
+		{
+			in:  "c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=",
+			out: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ=",
+		},
+	}
+	for i, step := range steps {
+		more, resp, err := server.Step([]byte(step.in))
+		if err != nil {
+			t.Fatalf("Step %d: unexpected error: %v", i, err)
+		}
+		if string(resp) != step.out {
+			t.Errorf("Step %d: invalid response:\nexpected `%s'\n     got `%s'", i, step.out, resp)
+		}
+		if more != step.more {
+			t.Errorf("Step %d: unexpected value for more: %v", i, more)
+		}
+	}
+	if !server.Completed() {
+		t.Error("Expected server negotiation to be completed")
+	}
+}
+
+// negotiate runs a client and server against one another until both have
+// completed or either returns an error.
+func negotiate(client, server *Negotiator) error {
+	var challenge []byte
+	for {
+		clientMore, resp, err := client.Step(challenge)
+		if err != nil || server.Completed() {
+			return err
+		}
+		var serverMore bool
+		serverMore, challenge, err = server.Step(resp)
+		if err != nil || (!serverMore && !clientMore) {
+			return err
+		}
+	}
+}
+
+func TestScramRoundTrip(t *testing.T) {
+	const username = ",=,="
+	store := mapStore{
+		username: DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096),
+	}
+	tlsState := TLSState(tls.ConnectionState{TLSUnique: []byte("finishedmessage")})
+
+	for _, tc := range []struct {
+		password string
+		identity string
+		perm     func(*Negotiator) bool
+		fail     bool
+	}{
+		{password: "pencil", identity: "admin", perm: func(n *Negotiator) bool {
+			user, _, ident := n.Credentials()
+			return string(user) == username && string(ident) == "admin"
+		}},
+		{password: "pen", perm: acceptAll, fail: true},
+		{password: "pencil", perm: func(*Negotiator) bool { return false }, fail: true},
+	} {
+		client := NewClient(ScramSha256Plus, tlsState, RemoteMechanisms(ScramSha256Plus.Name), Credentials(func() ([]byte, []byte, []byte) {
+			return []byte(username), []byte(tc.password), []byte(tc.identity)
+		}))
+		server := NewServer(ScramSha256Plus, tc.perm, tlsState, Store(store))
+
+		err := negotiate(client, server)
+		switch {
+		case tc.fail && err == nil:
+			t.Error("Expected negotiation to fail")
+		case !tc.fail && err != nil:
+			t.Errorf("Unexpected error: %v", err)
+		case !tc.fail && !client.Authenticated():
+			t.Error("Expected client to have authenticated the server")
+		}
+	}
+}
+
+func TestEmptyChannelBinding(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	// TLS 1.3 connections have no tls-unique value.
+	tls13 := TLSState(tls.ConnectionState{Version: tls.VersionTLS13, HandshakeComplete: true})
+	tls12 := TLSState(tls.ConnectionState{Version: tls.VersionTLS12, HandshakeComplete: true, TLSUnique: []byte("finishedmessage")})
+
+	server := NewServer(ScramSha256Plus, acceptAll, tls13, Store(store))
+	if _, _, err := server.Step([]byte("p=tls-unique,,n=user,r=" + string(testNonce))); err != errChannelBinding {
+		t.Errorf("Unexpected error: want=%v, got=%v", errChannelBinding, err)
+	}
+
+	client := NewClient(ScramSha256Plus, append([]Option{tls13, RemoteMechanisms("SCRAM-SHA-256-PLUS")}, scramClientOpts...)...)
+	server = NewServer(ScramSha256Plus, acceptAll, tls13, Store(store))
+	if err := negotiate(client, server); err == nil {
+		t.Errorf("Expected negotiation without channel binding data to fail")
+	}
+	if typ, data := server.ChannelBinding(); typ != "" || data != nil {
+		t.Errorf("Unexpected channel binding: %q %q", typ, data)
+	}
+
+	f := NewServerFactory([]Mechanism{ScramSha256Plus, ScramSha256}, acceptAll, Store(store))
+	for _, tc := range []struct {
+		opts []Option
+		want []string
+	}{
+		{want: []string{"SCRAM-SHA-256"}},
+		{opts: []Option{tls13}, want: []string{"SCRAM-SHA-256"}},
+		{opts: []Option{tls12}, want: []string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256"}},
+	} {
+		if got := f.Mechanisms("", tc.opts...); !slices.Equal(got, tc.want) {
+			t.Errorf("Unexpected mechanisms: want=%v, got=%v", tc.want, got)
+		}
+	}
+	if _, err := f.NewServer("SCRAM-SHA-256-PLUS", "", tls13); err != ErrMechanismNotSupported {
+		t.Errorf("Unexpected error: want=%v, got=%v", ErrMechanismNotSupported, err)
+	}
+	if _, err := f.NewServer("SCRAM-SHA-256-PLUS", "", tls12); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestServerChannelBindingFlag(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	tls13 := TLSState(tls.ConnectionState{Version: tls.VersionTLS13, HandshakeComplete: true})
+	tls12 := TLSState(tls.ConnectionState{Version: tls.VersionTLS12, HandshakeComplete: true, TLSUnique: []byte("finishedmessage")})
+	newServer := func(mechs []Mechanism, opts ...Option) *Negotiator {
+		if mechs == nil {
+			return NewServer(ScramSha256, acceptAll, append(opts, Store(store))...)
+		}
+		server, err := NewServerFactory(mechs, acceptAll, Store(store)).NewServer("SCRAM-SHA-256", "", opts...)
+		if err != nil {
+			t.Fatalf("Unexpected error creating server: %v", err)
+		}
+		return server
+	}
+
+	// The "y" flag is only a sign of a downgrade if the server could have offered
+	// channel binding.
+	for i, tc := range []struct {
+		server *Negotiator
+		err    error
+	}{
+		0: {server: newServer(nil)},
+		1: {server: newServer(nil, tls13)},
+		2: {server: newServer(nil, tls12), err: errChannelBinding},
+		3: {server: newServer([]Mechanism{ScramSha256}, tls12)},
+		4: {server: newServer([]Mechanism{ScramSha256Plus, ScramSha256}, tls13)},
+		5: {server: newServer([]Mechanism{ScramSha256Plus, ScramSha256}, tls12), err: errChannelBinding},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if _, _, err := tc.server.Step([]byte("y,,n=user,r=" + string(testNonce))); err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+
+	client := NewClient(ScramSha256, append([]Option{tls13, AdvertiseChannelBinding(true)}, scramClientOpts...)...)
+	if err := negotiate(client, newServer(nil, tls13)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestScramServerRejectsInvalidClientFirst(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha1.New, []byte("pencil"), []byte("salt"), 4096)}
+	for i, msg := range []string{
+		"n,,n=us=2Xer,r=nonce",
+		"n,a=ad=min,n=user,r=nonce",
+		"n,,m=ext,n=user,r=nonce",
+		"p=tls-unique,,n=user,r=nonce",
+		"x,,n=user,r=nonce",
+		"n,,r=nonce,n=user",
+		"n,,n=user",
+		"n,,n=nobody,r=nonce",
+		// More "=" than fit in the unescaped name.
+		"n,,n==,r=abcdefghijklmnop",
+		"n,,n===,r=abcdefghijklmnop",
+		"n,a==,n=user,r=abcdefghijklmnop",
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			for _, w := range []Workaround{0, AllWorkarounds} {
+				server := NewServer(ScramSha1, acceptAll, Store(store), Interop(w))
+				if _, _, err := server.Step([]byte(msg)); err == nil {
+					t.Errorf("Expected error for client-first message %q with workarounds %b", msg, w)
+				}
+			}
+		})
+	}
+}
+
+func TestStrictSCRAM(t *testing.T) {
+	const valid = "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"
+	for i, tc := range []struct {
+		challenge string
+		lenient   bool
+	}{
+		0: {challenge: valid + ",x=ext", lenient: true},
+		1: {challenge: valid + ",r=fyko+d2lbbFgONRv9qkxdawL", lenient: true},
+		2: {challenge: "s=QSXCR+Q6sek8bf92,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,i=4096", lenient: true},
+		3: {challenge: valid + ",m=ext"},
+		4: {challenge: "r=fyko+d2lbbFgONRv9qkxdawL\x01,s=QSXCR+Q6sek8bf92,i=4096", lenient: true},
+		5: {challenge: "r=fyko+d2lbbFgONRv9qkxdawL,s=QSXCR+Q6sek8bf92,i=+4096", lenient: true},
+		6: {challenge: valid + ",,", lenient: true},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			for _, strict := range []bool{false, true} {
+				opts := append([]Option{}, saslTestCases[1].clientOpts...)
+				if strict {
+					opts = append(opts, StrictSCRAM())
+				}
+				client := NewClient(ScramSha1, opts...)
+				client.nonce = testNonce
+				if _, _, err := client.Step(nil); err != nil {
+					t.Fatalf("Unexpected error on first step: %v", err)
+				}
+				_, _, err := client.Step([]byte(tc.challenge))
+				switch {
+				case i == 0 && err != nil:
+					t.Errorf("Unexpected error with strict=%v: %v", strict, err)
+				case i > 0 && strict && err == nil:
+					t.Error("Expected strict parsing to fail")
+				case i > 0 && !strict && tc.lenient && err != nil:
+					t.Errorf("Expected lenient parsing to succeed, got: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckServerFinalStrict(t *testing.T) {
+	for _, tc := range []struct {
+		msg string
+		err error
+	}{
+		{msg: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ="},
+		{msg: "e=invalid-proof,x=ext"},
+		{msg: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ=,m=ext", err: errReservedAttr},
+		{msg: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ=,x=a,x=b", err: errDuplicateAttr},
+		{msg: "v=not base64", err: errMalformedMessage},
+		{msg: "x=rmF9pqV8S7suAoZWja4dJRkFsKQ=", err: errMalformedMessage},
+	} {
+		if err := checkServerFinalStrict([]byte(tc.msg)); err != tc.err {
+			t.Errorf("%q: want=%v, got=%v", tc.msg, tc.err, err)
+		}
+	}
+}
+
+func TestIterationLimits(t *testing.T) {
+	for _, tc := range []struct {
+		opts []Option
+		iter string
+		err  error
+	}{
+		{iter: "4096"},
+		{iter: "4095", err: IterationCountError{Iterations: 4095, Min: DefaultMinIterations, Max: DefaultMaxIterations}},
+		{opts: []Option{MinIterations(1)}, iter: "1"},
+		{opts: []Option{MinIterations(10000)}, iter: "4096", err: IterationCountError{Iterations: 4096, Min: 10000, Max: DefaultMaxIterations}},
+		{iter: "2000000000", err: IterationCountError{Iterations: 2000000000, Min: DefaultMinIterations, Max: DefaultMaxIterations}},
+		{opts: []Option{MaxIterations(4000)}, iter: "4096", err: IterationCountError{Iterations: 4096, Min: DefaultMinIterations, Max: 4000}},
+	} {
+		t.Run(tc.iter, func(t *testing.T) {
+			client := NewClient(ScramSha1, append(tc.opts, saslTestCases[1].clientOpts...)...)
+			client.nonce = testNonce
+			if _, _, err := client.Step(nil); err != nil {
+				t.Fatalf("Unexpected error on first step: %v", err)
+			}
+			_, _, err := client.Step([]byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=" + tc.iter))
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestTuneIterations(t *testing.T) {
+	if iter := TuneIterations(sha256.New, 0); iter != DefaultMinIterations {
+		t.Errorf("Unexpected iterations for zero budget: want=%d, got=%d", DefaultMinIterations, iter)
+	}
+	if iter := TuneIterations(sha256.New, time.Nanosecond); iter != DefaultMinIterations {
+		t.Errorf("Unexpected iterations for tiny budget: want=%d, got=%d", DefaultMinIterations, iter)
+	}
+	if iter := TuneIterations(sha256.New, 5*time.Millisecond); iter < DefaultMinIterations || iter > DefaultMaxIterations {
+		t.Errorf("Iterations out of range: %d", iter)
+	}
+}
+
+func TestScramServerError(t *testing.T) {
+	for _, tc := range []struct {
+		final string
+		err   ScramError
+		is    error
+	}{
+		{final: "e=invalid-proof", err: ScramInvalidProof, is: ErrAuthn},
+		{final: "e=unknown-user", err: ScramUnknownUser, is: ErrUnknownUser},
+		{final: "e=channel-bindings-dont-match", err: ScramChannelBindingsDontMatch, is: ScramChannelBindingsDontMatch},
+		{final: "e=no-resources,x=ext", err: ScramNoResources, is: ScramNoResources},
+		{final: "e=some-future-error", err: ScramError("some-future-error"), is: ScramError("some-future-error")},
+	} {
+		t.Run(tc.final, func(t *testing.T) {
+			client := NewClient(ScramSha1, saslTestCases[1].clientOpts...)
+			client.nonce = testNonce
+			for _, step := range saslTestCases[1].steps[:2] {
+				if _, _, err := client.Step(step.challenge); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			_, _, err := client.Step([]byte(tc.final))
+			var scramErr ScramError
+			switch {
+			case !errors.As(err, &scramErr) || scramErr != tc.err:
+				t.Errorf("Wrong error: want=%v, got=%v", tc.err, err)
+			case !errors.Is(err, tc.is):
+				t.Errorf("Expected error to match %v", tc.is)
+			case IsTemporary(err) != (tc.err == ScramNoResources):
+				t.Errorf("Unexpected value for IsTemporary: %v", IsTemporary(err))
+			}
+		})
+	}
+}
+
+func TestServerSignature(t *testing.T) {
+	for _, final := range []string{"", "v=AAAApqV8S7suAoZWja4dJRkFsKQ=", "v=rmF9pqV8S7suAoZWja4dJRkFsKQ="} {
+		t.Run(final, func(t *testing.T) {
+			client := NewClient(ScramSha1, saslTestCases[1].clientOpts...)
+			client.nonce = testNonce
+			for _, step := range saslTestCases[1].steps[:2] {
+				if _, _, err := client.Step(step.challenge); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if client.VerifiedServer() {
+				t.Fatal("Server should not be verified before the server-final message")
+			}
+			_, _, err := client.Step([]byte(final))
+			valid := final == string(saslTestCases[1].steps[2].challenge)
+			switch {
+			case valid && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			case !valid && err != ErrServerSignature:
+				t.Errorf("Wrong error: want=%v, got=%v", ErrServerSignature, err)
+			}
+			if client.VerifiedServer() != valid {
+				t.Errorf("Unexpected value for VerifiedServer: %v", client.VerifiedServer())
+			}
+		})
+	}
+}
+
+func TestKeyCache(t *testing.T) {
+	store := mapStore{
+		"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096),
+	}
+	keys := &MemoryKeyCache{}
+	id := KeyCacheID{Mechanism: "SCRAM-SHA-256", Username: "user", Salt: "salt", Iterations: 4096}
+	newClient := func(password string) *Negotiator {
+		return NewClient(ScramSha256, CacheKeys(keys), Credentials(func() ([]byte, []byte, []byte) {
+			return []byte("user"), []byte(password), nil
+		}))
+	}
+
+	if err := negotiate(newClient("pen"), NewServer(ScramSha256, acceptAll, Store(store))); err == nil {
+		t.Fatal("Expected negotiation with the wrong password to fail")
+	}
+	if _, ok := keys.GetKeys(id); ok {
+		t.Fatal("Keys were cached after a failed negotiation")
+	}
+
+	if err := negotiate(newClient("pencil"), NewServer(ScramSha256, acceptAll, Store(store))); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := keys.GetKeys(id); !ok {
+		t.Fatal("Keys were not cached after a successful negotiation")
+	}
+
+	// The cached keys are used in place of the password.
+	if err := negotiate(newClient("wrong"), NewServer(ScramSha256, acceptAll, Store(store))); err != nil {
+		t.Fatalf("Cached keys were not used: %v", err)
+	}
+
+	keys.Forget("user")
+	if _, ok := keys.GetKeys(id); ok {
+		t.Fatal("Keys were not forgotten")
+	}
+}
+
+func TestPrecomputedSecret(t *testing.T) {
+	salt := []byte("salt")
+	store := mapStore{
+		"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), salt, 4096),
+	}
+	saltedPassword := pbkdf2.Key([]byte("pencil"), salt, 4096, sha256.Size, sha256.New)
+	clientKey, serverKey := newScramHash(sha256.New, true).keys(saltedPassword)
+
+	for i, tc := range []struct {
+		secret ScramSecret
+		err    error
+	}{
+		0: {secret: ScramSecret{Salt: salt, Iterations: 4096, SaltedPassword: saltedPassword}},
+		1: {secret: ScramSecret{Salt: salt, Iterations: 4096, ClientKey: clientKey, ServerKey: serverKey}},
+		2: {secret: ScramSecret{Salt: salt, Iterations: 8192, SaltedPassword: saltedPassword}, err: ErrSecretMismatch},
+		3: {secret: ScramSecret{Salt: []byte("pepper"), Iterations: 4096, SaltedPassword: saltedPassword}, err: ErrSecretMismatch},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			client := NewClient(ScramSha256, PrecomputedSecret(tc.secret), Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), nil, nil
+			}))
+			server := NewServer(ScramSha256, acceptAll, Store(store))
+			if err := negotiate(client, server); err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestKDF(t *testing.T) {
+	store := mapStore{
+		"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096),
+	}
+	var called int
+	kdf := KDF(func(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
+		called++
+		return pbkdf2.Key(password, salt, iter, keyLen, h)
+	})
+	client := NewClient(ScramSha256, kdf, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	if err := negotiate(client, NewServer(ScramSha256, acceptAll, Store(store))); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if called != 1 {
+		t.Errorf("Expected KDF to be called once, got %d calls", called)
+	}
+}
+
+// newTestCertificate returns a self-signed certificate.
+func newTestCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{SerialNumber: big.NewInt(1)}, &x509.Certificate{SerialNumber: big.NewInt(1)}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %v", err)
+	}
+	return cert
+}
+
+func TestPostgreSQL(t *testing.T) {
+	cert := newTestCertificate(t)
+	endPoint := sha256.Sum256(cert.Raw)
+	tlsState := TLSState(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, TLSUnique: []byte("finishedmessage")})
+
+	for i, tc := range []struct {
+		mech    Mechanism
+		opts    []Option
+		gs2     string
+		cbind   string
+		initErr error
+	}{
+		0: {mech: ScramSha256, gs2: "n,,", cbind: "n,,"},
+		1: {mech: ScramSha256, opts: []Option{tlsState}, gs2: "y,,", cbind: "y,,"},
+		2: {mech: ScramSha256Plus, opts: []Option{tlsState, RemoteMechanisms("SCRAM-SHA-256-PLUS")}, gs2: "p=tls-server-end-point,,", cbind: "p=tls-server-end-point,," + string(endPoint[:])},
+		3: {mech: ScramSha256Plus, opts: []Option{TLSState(tls.ConnectionState{}), RemoteMechanisms("SCRAM-SHA-256-PLUS")}, gs2: "p=tls-server-end-point,,", initErr: errNoServerCert},
+		4: {mech: ScramSha256, opts: []Option{tlsState, AdvertiseChannelBinding(false)}, gs2: "n,,", cbind: "n,,"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			opts := append([]Option{PostgreSQL(), Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("ignored"), []byte("pencil"), []byte("ignored")
+			})}, tc.opts...)
+			client := NewClient(tc.mech, opts...)
+			client.nonce = testNonce
+
+			_, resp, err := client.Step(nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if want := tc.gs2 + "n=,r=" + string(testNonce); string(resp) != want {
+				t.Errorf("Unexpected client-first-message: want=%q, got=%q", want, resp)
+			}
+
+			_, resp, err = client.Step([]byte("r=" + string(testNonce) + "3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"))
+			if err != tc.initErr {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.initErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if want := "c=" + base64.StdEncoding.EncodeToString([]byte(tc.cbind)) + ","; !strings.HasPrefix(string(resp), want) {
+				t.Errorf("Unexpected client-final-message: want prefix %q, got %q", want, resp)
+			}
+		})
+	}
+}
+
+func TestServerEndPointChannelBinding(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	cert := newTestCertificate(t)
+	endPoint := sha256.Sum256(cert.Raw)
+	tls13 := TLSState(tls.ConnectionState{Version: tls.VersionTLS13, HandshakeComplete: true})
+	const clientFirstBare = "n=user,r=fyko+d2lbbFgONRv9qkxdawL"
+
+	// clientFinal returns the client-final-message that a PostgreSQL client with
+	// a username would send for the channel binding data cb.
+	clientFinal := func(serverFirst []byte, cb []byte) []byte {
+		nonce, _, _ := strings.Cut(strings.TrimPrefix(string(serverFirst), "r="), ",")
+		withoutProof := "c=" + base64.StdEncoding.EncodeToString(append([]byte("p=tls-server-end-point,,"), cb...)) + ",r=" + nonce
+		authMessage := clientFirstBare + "," + string(serverFirst) + "," + withoutProof
+		clientKey, _ := newScramHash(sha256.New, true).keys(pbkdf2.Key([]byte("pencil"), []byte("salt"), 4096, sha256.Size, sha256.New))
+		storedKey := sha256.Sum256(clientKey)
+		mac := hmac.New(sha256.New, storedKey[:])
+		mac.Write([]byte(authMessage))
+		proof := mac.Sum(nil)
+		for i := range proof {
+			proof[i] ^= clientKey[i]
+		}
+		return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof))
+	}
+
+	for i, tc := range []struct {
+		opts []Option
+		cb   []byte
+		err  error
+	}{
+		0: {opts: []Option{tls13, ServerCertificate(cert)}, cb: endPoint[:]},
+		1: {opts: []Option{tls13, ServerCertificate(cert)}, cb: []byte("wrong"), err: errChannelBinding},
+		2: {opts: []Option{tls13}, err: errChannelBinding},
+		3: {opts: []Option{ServerCertificate(cert)}, err: errChannelBinding},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			server := NewServer(ScramSha256Plus, acceptAll, append(tc.opts, Store(store))...)
+			_, serverFirst, err := server.Step([]byte("p=tls-server-end-point,," + clientFirstBare))
+			if err == nil {
+				_, _, err = server.Step(clientFinal(serverFirst, tc.cb))
+			}
+			if err != tc.err {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if typ, data := server.ChannelBinding(); typ != ChannelBindingTLSServerEndPoint || !bytes.Equal(data, endPoint[:]) {
+				t.Errorf("Unexpected channel binding: %q %x", typ, data)
+			}
+		})
+	}
+
+	f := NewServerFactory([]Mechanism{ScramSha256Plus, ScramSha256}, acceptAll, Store(store))
+	if got, want := f.Mechanisms("", tls13, ServerCertificate(cert)), []string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256"}; !slices.Equal(got, want) {
+		t.Errorf("Unexpected mechanisms: want=%v, got=%v", want, got)
+	}
+}
+
+func TestAdvertiseChannelBinding(t *testing.T) {
+	tlsState := TLSState(tls.ConnectionState{TLSUnique: []byte("finishedmessage")})
+	for i, tc := range []struct {
+		opts []Option
+		gs2  string
+	}{
+		0: {gs2: "n,,"},
+		1: {opts: []Option{tlsState}, gs2: "n,,"},
+		2: {opts: []Option{tlsState, AdvertiseChannelBinding(true)}, gs2: "y,,"},
+		3: {opts: []Option{tlsState, AdvertiseChannelBinding(false)}, gs2: "n,,"},
+		// Without TLS the client does not support channel binding.
+		4: {opts: []Option{AdvertiseChannelBinding(true)}, gs2: "n,,"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			client := NewClient(ScramSha256, append(tc.opts, scramClientOpts...)...)
+			_, resp, err := client.Step(nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(string(resp), tc.gs2) {
+				t.Errorf("Unexpected gs2 header: want=%q, got=%q", tc.gs2, resp)
+			}
+		})
+	}
+
+	// A server that supports channel binding detects that the client was told
+	// that it does not.
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	client := NewClient(ScramSha256, append([]Option{tlsState, AdvertiseChannelBinding(true)}, scramClientOpts...)...)
+	server := NewServer(ScramSha256, acceptAll, tlsState, Store(store))
+	if err := negotiate(client, server); err != errChannelBinding {
+		t.Errorf("Unexpected error: want=%v, got=%v", errChannelBinding, err)
+	}
+}
+
+func TestPostgreSQLPasswordFallback(t *testing.T) {
+	// Passwords that SASLprep rejects are used without preparation.
+	creds := Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pen\u0007cil"), nil
+	})
+	errRejected := errors.New("rejected")
+	reject := func([]byte) ([]byte, error) {
+		return nil, errRejected
+	}
+	for _, tc := range []struct {
+		opts []Option
+		err  error
+	}{
+		{opts: []Option{creds}, err: errPrepProhibited},
+		{opts: []Option{creds, PostgreSQL()}},
+		// The fallback does not depend on the order of the options.
+		{opts: []Option{creds, Normalize(nil, reject)}, err: errRejected},
+		{opts: []Option{creds, PostgreSQL(), Normalize(nil, reject)}},
+		{opts: []Option{creds, Normalize(nil, reject), PostgreSQL()}},
+		{opts: []Option{creds, PostgreSQL(), NoSASLprep()}},
+		{opts: []Option{creds, NoSASLprep(), PostgreSQL()}},
+	} {
+		client := NewClient(ScramSha256, tc.opts...)
+		client.nonce = testNonce
+		_, _, err := client.Step(nil)
+		if err == nil {
+			_, _, err = client.Step([]byte("r=" + string(testNonce) + "3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"))
+		}
+		if err != tc.err {
+			t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+		}
+	}
+}
+
+func TestPasswordHook(t *testing.T) {
+	hook := PasswordHook(func(username, password []byte) ([]byte, error) {
+		return []byte(string(username) + ":" + string(password)), nil
+	})
+	store := mapStore{
+		"user": DeriveStoredCredentials(sha1.New, []byte("user:pencil"), []byte("salt"), 4096),
+	}
+	client := NewClient(ScramSha1, hook, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	if err := negotiate(client, NewServer(ScramSha1, acceptAll, Store(store))); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestScramExtensions(t *testing.T) {
+	attr := func(name byte, value string) scramwire.Attribute {
+		return scramwire.Attribute{Name: name, Value: []byte(value)}
+	}
+	format := func(ext []scramwire.Attribute) string {
+		var s []string
+		for _, a := range ext {
+			s = append(s, string(a.Name)+"="+string(a.Value))
+		}
+		return strings.Join(s, ",")
+	}
+
+	store := mapStore{
+		"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096),
+	}
+	var seen string
+	server := NewServer(ScramSha256, func(n *Negotiator) bool {
+		seen = format(n.RemoteScramExtensions())
+		return true
+	}, Store(store), ScramExtensions(
+		[]scramwire.Attribute{attr('z', "policy")},
+		[]scramwire.Attribute{attr('w', "session")},
+	))
+	client := NewClient(ScramSha256, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}), ScramExtensions(
+		[]scramwire.Attribute{attr('x', "device1")},
+		[]scramwire.Attribute{attr('y', "token")},
+	))
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !client.VerifiedServer() {
+		t.Error("Expected client to verify the server signature")
+	}
+	if want := "x=device1,y=token"; seen != want {
+		t.Errorf("Unexpected extensions on server: want=%q, got=%q", want, seen)
+	}
+	if want, got := "z=policy,w=session", format(client.RemoteScramExtensions()); got != want {
+		t.Errorf("Unexpected extensions on client: want=%q, got=%q", want, got)
+	}
+
+	client.Reset()
+	if ext := client.RemoteScramExtensions(); ext != nil {
+		t.Errorf("Expected Reset to clear extensions, got %q", format(ext))
+	}
+}
+
+func TestFinish(t *testing.T) {
+	store := mapStore{
+		"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096),
+	}
+	creds := Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	})
+
+	// run steps the client until the server finishes and returns the final
+	// server message without passing it to the client.
+	run := func(client *Negotiator) []byte {
+		server := NewServer(ScramSha256, acceptAll, Store(store))
+		var challenge []byte
+		for {
+			_, resp, err := client.Step(challenge)
+			if err != nil {
+				t.Fatalf("Unexpected client error: %v", err)
+			}
+			more, c, err := server.Step(resp)
+			if err != nil {
+				t.Fatalf("Unexpected server error: %v", err)
+			}
+			if !more {
+				return c
+			}
+			challenge = c
+		}
+	}
+
+	client := NewClient(ScramSha256, creds)
+	final := run(client)
+	if err := client.Finish(final); err != nil {
+		t.Fatalf("Unexpected error finishing with additional data: %v", err)
+	}
+	if !client.Authenticated() {
+		t.Error("Expected client to be authenticated")
+	}
+	if err := client.Finish(final); !errors.Is(err, ErrInvalidChallenge) || !errors.As(err, new(TransitionError)) {
+		t.Errorf("Expected error for data after completion, got %v", err)
+	}
+	if err := client.Finish(nil); err != nil {
+		t.Errorf("Unexpected error finishing a completed negotiation: %v", err)
+	}
+
+	client = NewClient(ScramSha256, creds)
+	run(client)
+	if err := client.Finish(nil); err != ErrServerSignature {
+		t.Errorf("Expected ErrServerSignature without additional data, got %v", err)
+	}
+
+	if err := NewServer(ScramSha256, acceptAll).Finish(nil); err != ErrInvalidState {
+		t.Errorf("Expected ErrInvalidState for server, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/jh125486/sasl/scramwire"
+)
+
+// scramFragments caches the parts of the SCRAM client-first-message that do not
+// change between negotiations with the same configuration and credentials so
+// that a negotiator that is Reset and reused (for example, by a connection
+// pool) does not have to rebuild and re-escape them each time.
+// Unlike the rest of the negotiation state it is not cleared by Reset.
+type scramFragments struct {
+	base      string
+	identity  []byte
+	gs2Header []byte
+
+	username []byte
+	prefix   []byte
+}
+
+// gs2 returns the GS2 header for the given channel binding flag and
+// authorization identity.
+func (f *scramFragments) gs2(base string, identity []byte) []byte {
+	if f.gs2Header == nil || f.base != base || !bytes.Equal(f.identity, identity) {
+		h := make([]byte, 0, len(base)+len(identity)+3)
+		h = append(h, base...)
+		if len(identity) > 0 {
+			h = append(h, "a="...)
+			h = append(h, scramwire.Escape(identity)...)
+		}
+		f.gs2Header = append(h, ',')
+		f.base = base
+		f.identity = bytes.Clone(identity)
+	}
+	// Callers append to the header, so make sure that they can not overwrite the
+	// cached copy.
+	return slices.Clip(f.gs2Header)
+}
+
+// clientFirstPrefix returns the client-first-message-bare up to and including
+// the "r=" attribute name for the (unescaped) username.
+func (f *scramFragments) clientFirstPrefix(username []byte) []byte {
+	if f.prefix == nil || !bytes.Equal(f.username, username) {
+		escaped := scramwire.Escape(username)
+		p := make([]byte, 0, len(escaped)+5)
+		p = append(p, "n="...)
+		p = append(p, escaped...)
+		f.prefix = append(p, ",r="...)
+		f.username = bytes.Clone(username)
+	}
+	return slices.Clip(f.prefix)
+}
+
+func getGS2Header(name string, n *Negotiator) []byte {
+	return n.scramFragments.gs2(gs2Base(name, n))
+}
+
+// ScramFragments returns the GS2 header and the start of the
+// client-first-message-bare (everything before the nonce) most recently sent
+// by a SCRAM client, for example so that a proxy can rewrite the nonce or
+// rebuild the message without parsing it.
+// Both are nil if the negotiator has not started a SCRAM client negotiation.
+// The returned slices must not be modified.
+func (c *Negotiator) ScramFragments() (gs2Header, clientFirstPrefix []byte) {
+	return slices.Clip(c.scramFragments.gs2Header), slices.Clip(c.scramFragments.prefix)
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestScramFragments(t *testing.T) {
+	store := mapStore{"user,": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	identity := []byte("admin")
+	client := NewClient(ScramSha256, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user,"), []byte("pencil"), identity
+	}))
+	if gs2, prefix := client.ScramFragments(); gs2 != nil || prefix != nil {
+		t.Fatalf("Expected no fragments before negotiating, got %q and %q", gs2, prefix)
+	}
+
+	var firstGS2, firstPrefix []byte
+	for i := 0; i < 2; i++ {
+		client.Reset()
+		server := NewServer(ScramSha256, func(*Negotiator) bool { return true }, Store(store))
+		if err := negotiate(client, server); err != nil {
+			t.Fatalf("Negotiation %d failed: %v", i, err)
+		}
+		gs2, prefix := client.ScramFragments()
+		if string(gs2) != "n,a=admin," {
+			t.Errorf("Unexpected GS2 header: %q", gs2)
+		}
+		if string(prefix) != "n=user=2C,r=" {
+			t.Errorf("Unexpected client-first prefix: %q", prefix)
+		}
+		if i == 0 {
+			firstGS2, firstPrefix = gs2, prefix
+			continue
+		}
+		if &gs2[0] != &firstGS2[0] || &prefix[0] != &firstPrefix[0] {
+			t.Errorf("Expected fragments to be reused after Reset")
+		}
+	}
+
+	// Changing the identity rebuilds the header but not the prefix.
+	identity = nil
+	client.Reset()
+	if _, _, err := client.Step(nil); err != nil {
+		t.Fatalf("Error starting negotiation: %v", err)
+	}
+	gs2, prefix := client.ScramFragments()
+	if string(gs2) != "n,," {
+		t.Errorf("Unexpected GS2 header after changing identity: %q", gs2)
+	}
+	if &prefix[0] != &firstPrefix[0] {
+		t.Errorf("Expected prefix to be reused")
+	}
+
+	if gs2, prefix := client.Clone().ScramFragments(); gs2 != nil || prefix != nil {
+		t.Errorf("Expected clone not to share fragments, got %q and %q", gs2, prefix)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/hmac"
+	"hash"
+)
+
+// scramHash calculates the digests and HMACs needed by SCRAM.
+// It reuses the same hash objects for every calculation so that they are only
+// allocated once per negotiator instead of several times per step.
+//
+// In FIPS mode crypto/hmac is used instead so that only the validated
+// implementation ever sees key material.
+type scramHash struct {
+	fn           func() hash.Hash
+	std          bool
+	inner, outer hash.Hash
+	pad, sum     []byte
+}
+
+func newScramHash(fn func() hash.Hash, std bool) *scramHash {
+	s := &scramHash{fn: fn, std: std}
+	s.inner = fn()
+	if !std {
+		s.outer = fn()
+		s.pad = make([]byte, s.inner.BlockSize())
+	}
+	return s
+}
+
+// scramHasher returns the negotiator's scramHash, creating it on first use.
+func (c *Negotiator) scramHasher(fn func() hash.Hash) *scramHash {
+	if c.scramHash == nil {
+		c.scramHash = newScramHash(fn, c.fips)
+	}
+	return c.scramHash
+}
+
+// size returns the size of the digests produced by the hash function.
+func (s *scramHash) size() int {
+	return s.inner.Size()
+}
+
+// digest appends the digest of msg to dst.
+func (s *scramHash) digest(dst, msg []byte) []byte {
+	s.inner.Reset()
+	s.inner.Write(msg)
+	return s.inner.Sum(dst)
+}
+
+// mac appends the HMAC of msg using key to dst as defined in RFC 2104.
+func (s *scramHash) mac(dst, key, msg []byte) []byte {
+	if s.std {
+		h := hmac.New(s.fn, key)
+		h.Write(msg)
+		return h.Sum(dst)
+	}
+
+	if len(key) > len(s.pad) {
+		s.sum = s.digest(s.sum[:0], key)
+		key = s.sum
+	}
+	copy(s.pad, key)
+	clear(s.pad[len(key):])
+	for i := range s.pad {
+		s.pad[i] ^= 0x36
+	}
+	s.inner.Reset()
+	s.inner.Write(s.pad)
+	s.inner.Write(msg)
+	s.sum = s.inner.Sum(s.sum[:0])
+
+	for i := range s.pad {
+		s.pad[i] ^= 0x36 ^ 0x5c
+	}
+	s.outer.Reset()
+	s.outer.Write(s.pad)
+	s.outer.Write(s.sum)
+
+	// Don't leave key material lying around between calls.
+	clear(s.pad)
+	clear(s.sum)
+	return s.outer.Sum(dst)
+}
+
+// keys derives the client and server keys from a salted password.
+func (s *scramHash) keys(saltedPassword []byte) (clientKey, serverKey []byte) {
+	return s.mac(nil, saltedPassword, clientKeyInput), s.mac(nil, saltedPassword, serverKeyInput)
+}
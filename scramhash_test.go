@@ -0,0 +1,34 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"testing"
+)
+
+func TestScramHash(t *testing.T) {
+	for _, fn := range []func() hash.Hash{sha1.New, sha256.New, sha512.New} {
+		hs := newScramHash(fn, false)
+		// Reuse the same hash for keys shorter than, equal to, and longer than the
+		// block size to make sure that no state leaks between calls.
+		for _, keyLen := range []int{0, 20, fn().BlockSize(), 200, 32} {
+			key := bytes.Repeat([]byte{'k'}, keyLen)
+			msg := []byte("n=user,r=fyko+d2lbbFgONRv9qkxdawL")
+
+			h := hmac.New(fn, key)
+			h.Write(msg)
+			want := h.Sum(nil)
+			if got := hs.mac(nil, key, msg); !bytes.Equal(got, want) {
+				t.Errorf("Unexpected HMAC with %d byte key:\nwant=%x\n got=%x", keyLen, want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package scramwire parses and serializes the messages exchanged by the SCRAM
+// family of SASL mechanisms as defined in RFC 5802.
+//
+// It is intended for proxies and debugging tools that need to inspect or
+// rewrite SCRAM exchanges; applications that only need to authenticate should
+// use the mechanisms in the sasl package instead.
+// The parsers are strict: duplicate attributes, attributes in the wrong order,
+// non-printable characters, and the reserved "m" attribute are all rejected.
+package scramwire
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strconv"
+)
+
+// Errors returned by the parsers in this package.
+var (
+	ErrMalformed       = errors.New("scramwire: malformed message")
+	ErrDuplicateAttr   = errors.New("scramwire: duplicate attribute")
+	ErrReservedAttr    = errors.New("scramwire: reserved attribute m is not supported")
+	ErrInvalidEncoding = errors.New("scramwire: username or authorization identity is not properly escaped")
+)
+
+// Attribute is an extension attribute-value pair.
+type Attribute struct {
+	Name  byte
+	Value []byte
+}
+
+// ClientFirst is a client-first-message.
+type ClientFirst struct {
+	// CBFlag is the channel binding flag: "n", "y", or "p=" followed by the
+	// channel binding type.
+	CBFlag string
+
+	// Authzid is the optional authorization identity (unescaped).
+	Authzid []byte
+
+	// Username is the name of the user being authenticated (unescaped).
+	Username []byte
+
+	Nonce      []byte
+	Extensions []Attribute
+}
+
+// GS2Header returns the GS2 header that starts the message.
+func (m ClientFirst) GS2Header() []byte {
+	h := append([]byte(m.CBFlag), ',')
+	if len(m.Authzid) > 0 {
+		h = append(h, "a="...)
+		h = append(h, Escape(m.Authzid)...)
+	}
+	return append(h, ',')
+}
+
+// Bare returns the client-first-message-bare (the message without the GS2
+// header) which is used to calculate the proofs.
+func (m ClientFirst) Bare() []byte {
+	b := append([]byte("n="), Escape(m.Username)...)
+	b = append(b, ",r="...)
+	b = append(b, m.Nonce...)
+	return appendExtensions(b, m.Extensions)
+}
+
+// Append appends the serialized message to dst.
+func (m ClientFirst) Append(dst []byte) []byte {
+	dst = append(dst, m.GS2Header()...)
+	return append(dst, m.Bare()...)
+}
+
+// ParseClientFirst parses a client-first-message.
+func ParseClientFirst(msg []byte) (ClientFirst, error) {
+	var m ClientFirst
+	i := bytes.IndexByte(msg, ',')
+	if i == -1 {
+		return m, ErrMalformed
+	}
+	j := bytes.IndexByte(msg[i+1:], ',')
+	if j == -1 {
+		return m, ErrMalformed
+	}
+	j += i + 1
+
+	m.CBFlag = string(msg[:i])
+	switch {
+	case m.CBFlag == "n" || m.CBFlag == "y":
+	case len(m.CBFlag) > 2 && m.CBFlag[:2] == "p=" && validCBName(m.CBFlag[2:]):
+	default:
+		return m, ErrMalformed
+	}
+
+	if authzid := msg[i+1 : j]; len(authzid) > 0 {
+		if !bytes.HasPrefix(authzid, []byte("a=")) {
+			return m, ErrMalformed
+		}
+		var err error
+		if m.Authzid, err = Unescape(authzid[2:]); err != nil {
+			return m, err
+		}
+	}
+
+	attrs, err := split(msg[j+1:])
+	if err != nil {
+		return m, err
+	}
+	if len(attrs) < 2 || attrs[0].Name != 'n' || attrs[1].Name != 'r' || len(attrs[1].Value) == 0 {
+		return m, ErrMalformed
+	}
+	if m.Username, err = Unescape(attrs[0].Value); err != nil {
+		return m, err
+	}
+	m.Nonce = attrs[1].Value
+	m.Extensions = attrs[2:]
+	return m, nil
+}
+
+// ServerFirst is a server-first-message.
+type ServerFirst struct {
+	Nonce      []byte
+	Salt       []byte
+	Iterations int
+	Extensions []Attribute
+}
+
+// Append appends the serialized message to dst.
+func (m ServerFirst) Append(dst []byte) []byte {
+	dst = append(dst, "r="...)
+	dst = append(dst, m.Nonce...)
+	dst = append(dst, ",s="...)
+	dst = append(dst, base64.StdEncoding.EncodeToString(m.Salt)...)
+	dst = append(dst, ",i="...)
+	dst = strconv.AppendInt(dst, int64(m.Iterations), 10)
+	return appendExtensions(dst, m.Extensions)
+}
+
+// ParseServerFirst parses a server-first-message.
+func ParseServerFirst(msg []byte) (ServerFirst, error) {
+	var m ServerFirst
+	attrs, err := split(msg)
+	if err != nil {
+		return m, err
+	}
+	if len(attrs) < 3 || attrs[0].Name != 'r' || attrs[1].Name != 's' || attrs[2].Name != 'i' {
+		return m, ErrMalformed
+	}
+	if m.Nonce = attrs[0].Value; len(m.Nonce) == 0 {
+		return m, ErrMalformed
+	}
+	if m.Salt, err = base64.StdEncoding.Strict().DecodeString(string(attrs[1].Value)); err != nil || len(m.Salt) == 0 {
+		return m, ErrMalformed
+	}
+	if m.Iterations, err = parsePosInt(attrs[2].Value); err != nil {
+		return m, err
+	}
+	m.Extensions = attrs[3:]
+	return m, nil
+}
+
+// ClientFinal is a client-final-message.
+type ClientFinal struct {
+	// ChannelBinding is the decoded channel binding data (the GS2 header followed
+	// by any channel binding data from the TLS connection).
+	ChannelBinding []byte
+
+	Nonce      []byte
+	Extensions []Attribute
+
+	// Proof is the decoded client proof.
+	Proof []byte
+}
+
+// WithoutProof returns the client-final-message-without-proof which is used to
+// calculate the proofs.
+func (m ClientFinal) WithoutProof() []byte {
+	b := append([]byte("c="), base64.StdEncoding.EncodeToString(m.ChannelBinding)...)
+	b = append(b, ",r="...)
+	b = append(b, m.Nonce...)
+	return appendExtensions(b, m.Extensions)
+}
+
+// Append appends the serialized message to dst.
+func (m ClientFinal) Append(dst []byte) []byte {
+	dst = append(dst, m.WithoutProof()...)
+	dst = append(dst, ",p="...)
+	return append(dst, base64.StdEncoding.EncodeToString(m.Proof)...)
+}
+
+// ParseClientFinal parses a client-final-message.
+func ParseClientFinal(msg []byte) (ClientFinal, error) {
+	var m ClientFinal
+	attrs, err := split(msg)
+	if err != nil {
+		return m, err
+	}
+	last := len(attrs) - 1
+	if len(attrs) < 3 || attrs[0].Name != 'c' || attrs[1].Name != 'r' || attrs[last].Name != 'p' {
+		return m, ErrMalformed
+	}
+	if m.ChannelBinding, err = base64.StdEncoding.Strict().DecodeString(string(attrs[0].Value)); err != nil {
+		return m, ErrMalformed
+	}
+	if m.Nonce = attrs[1].Value; len(m.Nonce) == 0 {
+		return m, ErrMalformed
+	}
+	if m.Proof, err = base64.StdEncoding.Strict().DecodeString(string(attrs[last].Value)); err != nil || len(m.Proof) == 0 {
+		return m, ErrMalformed
+	}
+	m.Extensions = attrs[2:last]
+	return m, nil
+}
+
+// ServerFinal is a server-final-message.
+// Exactly one of Verifier and Error is set.
+type ServerFinal struct {
+	// Verifier is the decoded server signature.
+	Verifier []byte
+
+	// Error is the value of the server-error attribute, eg. "invalid-proof".
+	Error string
+
+	Extensions []Attribute
+}
+
+// Append appends the serialized message to dst.
+func (m ServerFinal) Append(dst []byte) []byte {
+	if m.Error != "" {
+		dst = append(dst, "e="...)
+		dst = append(dst, m.Error...)
+	} else {
+		dst = append(dst, "v="...)
+		dst = append(dst, base64.StdEncoding.EncodeToString(m.Verifier)...)
+	}
+	return appendExtensions(dst, m.Extensions)
+}
+
+// ParseServerFinal parses a server-final-message.
+func ParseServerFinal(msg []byte) (ServerFinal, error) {
+	var m ServerFinal
+	attrs, err := split(msg)
+	if err != nil {
+		return m, err
+	}
+	switch attrs[0].Name {
+	case 'e':
+		if len(attrs[0].Value) == 0 {
+			return m, ErrMalformed
+		}
+		m.Error = string(attrs[0].Value)
+	case 'v':
+		if m.Verifier, err = base64.StdEncoding.Strict().DecodeString(string(attrs[0].Value)); err != nil || len(m.Verifier) == 0 {
+			return m, ErrMalformed
+		}
+	default:
+		return m, ErrMalformed
+	}
+	m.Extensions = attrs[1:]
+	return m, nil
+}
+
+// Escape escapes "=" and "," in a username or authorization identity as
+// required by RFC 5802.
+// If there is nothing to escape, name is returned without being copied.
+func Escape(name []byte) []byte {
+	// This is mostly the same as bytes.Replace but faster because we can do both
+	// replacements in a single pass.
+	n := bytes.Count(name, []byte{'='}) + bytes.Count(name, []byte{','})
+	if n == 0 {
+		return name
+	}
+	escaped := make([]byte, len(name)+(n*2))
+	w := 0
+	start := 0
+	for i := 0; i < n; i++ {
+		j := start
+		j += bytes.IndexAny(name[start:], "=,")
+		w += copy(escaped[w:], name[start:j])
+		switch name[j] {
+		case '=':
+			w += copy(escaped[w:], "=3D")
+		case ',':
+			w += copy(escaped[w:], "=2C")
+		}
+		start = j + 1
+	}
+	copy(escaped[w:], name[start:])
+	return escaped
+}
+
+// Unescape reverses Escape and validates that the name does not contain any
+// other "=" sequences or unescaped commas.
+// If there is nothing to unescape, name is returned without being copied.
+func Unescape(name []byte) ([]byte, error) {
+	if bytes.IndexByte(name, ',') != -1 {
+		return nil, ErrInvalidEncoding
+	}
+	n := bytes.Count(name, []byte{'='})
+	if n == 0 {
+		return name, nil
+	}
+	unescaped := make([]byte, 0, len(name))
+	for {
+		i := bytes.IndexByte(name, '=')
+		if i == -1 {
+			return append(unescaped, name...), nil
+		}
+		unescaped = append(unescaped, name[:i]...)
+		switch {
+		case bytes.HasPrefix(name[i:], []byte("=2C")):
+			unescaped = append(unescaped, ',')
+		case bytes.HasPrefix(name[i:], []byte("=3D")):
+			unescaped = append(unescaped, '=')
+		default:
+			return nil, ErrInvalidEncoding
+		}
+		name = name[i+3:]
+	}
+}
+
+// split splits a message into attributes, validating the characters and
+// rejecting duplicate attributes and the reserved "m" attribute.
+func split(msg []byte) ([]Attribute, error) {
+	for _, c := range msg {
+		if c < 0x20 || c > 0x7E {
+			return nil, ErrMalformed
+		}
+	}
+	fields := bytes.Split(msg, []byte{','})
+	attrs := make([]Attribute, 0, len(fields))
+	var seen [26]bool
+	for _, field := range fields {
+		if len(field) < 2 || field[1] != '=' || !isAlpha(field[0]) {
+			return nil, ErrMalformed
+		}
+		name := field[0] | 0x20
+		if field[0] == 'm' {
+			return nil, ErrReservedAttr
+		}
+		if seen[name-'a'] {
+			return nil, ErrDuplicateAttr
+		}
+		seen[name-'a'] = true
+		attrs = append(attrs, Attribute{Name: field[0], Value: field[2:]})
+	}
+	return attrs, nil
+}
+
+func appendExtensions(dst []byte, ext []Attribute) []byte {
+	for _, a := range ext {
+		dst = append(dst, ',', a.Name, '=')
+		dst = append(dst, a.Value...)
+	}
+	return dst
+}
+
+func parsePosInt(b []byte) (int, error) {
+	if len(b) == 0 || b[0] == '0' {
+		return 0, ErrMalformed
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, ErrMalformed
+		}
+	}
+	i, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, ErrMalformed
+	}
+	return i, nil
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func validCBName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !isAlpha(c) && (c < '0' || c > '9') && c != '.' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package scramwire
+
+import (
+	"strconv"
+	"testing"
+)
+
+var unescapeTestCases = [...]struct {
+	in  string
+	out string
+	err error
+}{
+	0: {in: "user", out: "user"},
+	1: {in: "=2C=3D=2C=3D", out: ",=,="},
+	2: {in: "a=3Db=2Cc", out: "a=b,c"},
+	3: {in: "a=2", err: ErrInvalidEncoding},
+	4: {in: "a=2Xb", err: ErrInvalidEncoding},
+	5: {in: "a=", err: ErrInvalidEncoding},
+	6: {in: "a,b", err: ErrInvalidEncoding},
+	7: {in: "=", err: ErrInvalidEncoding},
+	8: {in: "==", err: ErrInvalidEncoding},
+	9: {in: "n===", err: ErrInvalidEncoding},
+}
+
+func TestUnescape(t *testing.T) {
+	for i, tc := range unescapeTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out, err := Unescape([]byte(tc.in))
+			if err != tc.err {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if string(out) != tc.out {
+				t.Errorf("Unexpected output: want=%q, got=%q", tc.out, out)
+			}
+			if tc.err == nil {
+				if escaped := Escape(out); string(escaped) != tc.in {
+					t.Errorf("Escaping did not round trip: want=%q, got=%q", tc.in, escaped)
+				}
+			}
+		})
+	}
+}
+
+// appender is implemented by all message types.
+type appender interface {
+	Append([]byte) []byte
+}
+
+var roundTripTestCases = [...]struct {
+	in    string
+	parse func([]byte) (appender, error)
+	err   error
+}{
+	// Messages from the example in RFC 5802 §5.
+	0: {in: "n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL", parse: clientFirst},
+	1: {in: "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096", parse: serverFirst},
+	2: {in: "c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=", parse: clientFinal},
+	3: {in: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ=", parse: serverFinal},
+
+	4: {in: "p=tls-unique,a=adm=2Cin,n=us=3Der,r=abc,x=ext", parse: clientFirst},
+	5: {in: "y,,n=user,r=abc", parse: clientFirst},
+	6: {in: "e=invalid-proof", parse: serverFinal},
+	7: {in: "c=biws,r=abc,x=1,p=AAAA", parse: clientFinal},
+	8: {in: "r=abc,s=AAAA,i=1,x=y", parse: serverFirst},
+
+	9:  {in: "q,,n=user,r=abc", parse: clientFirst, err: ErrMalformed},
+	10: {in: "n,b=x,n=user,r=abc", parse: clientFirst, err: ErrMalformed},
+	11: {in: "n,,m=x,n=user,r=abc", parse: clientFirst, err: ErrReservedAttr},
+	12: {in: "n,,n=user,r=abc,r=def", parse: clientFirst, err: ErrDuplicateAttr},
+	13: {in: "n,,r=abc,n=user", parse: clientFirst, err: ErrMalformed},
+	14: {in: "n,,n=us=er,r=abc", parse: clientFirst, err: ErrInvalidEncoding},
+	15: {in: "n,,n=user,r=a\x00bc", parse: clientFirst, err: ErrMalformed},
+	16: {in: "n,,n=user", parse: clientFirst, err: ErrMalformed},
+	17: {in: "r=abc,s=AAAA,i=0", parse: serverFirst, err: ErrMalformed},
+	18: {in: "r=abc,s=AAAA,i=-1", parse: serverFirst, err: ErrMalformed},
+	19: {in: "r=abc,s=!!!!,i=1", parse: serverFirst, err: ErrMalformed},
+	20: {in: "r=abc,i=1,s=AAAA", parse: serverFirst, err: ErrMalformed},
+	21: {in: "c=biws,r=abc", parse: clientFinal, err: ErrMalformed},
+	22: {in: "c=biws,r=abc,p=", parse: clientFinal, err: ErrMalformed},
+	23: {in: "x=1", parse: serverFinal, err: ErrMalformed},
+	24: {in: "v=", parse: serverFinal, err: ErrMalformed},
+}
+
+func clientFirst(b []byte) (appender, error) { return ParseClientFirst(b) }
+func serverFirst(b []byte) (appender, error) { return ParseServerFirst(b) }
+func clientFinal(b []byte) (appender, error) { return ParseClientFinal(b) }
+func serverFinal(b []byte) (appender, error) { return ParseServerFinal(b) }
+
+func TestRoundTrip(t *testing.T) {
+	for i, tc := range roundTripTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			m, err := tc.parse([]byte(tc.in))
+			if err != tc.err {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if out := m.Append(nil); string(out) != tc.in {
+				t.Errorf("Message did not round trip: want=%q, got=%q", tc.in, out)
+			}
+		})
+	}
+}
+
+func TestClientFirstParts(t *testing.T) {
+	m, err := ParseClientFirst([]byte("n,a=admin,n=user,r=abc"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if h := string(m.GS2Header()); h != "n,a=admin," {
+		t.Errorf("Unexpected GS2 header: want=%q, got=%q", "n,a=admin,", h)
+	}
+	if b := string(m.Bare()); b != "n=user,r=abc" {
+		t.Errorf("Unexpected bare message: want=%q, got=%q", "n=user,r=abc", b)
+	}
+	if string(m.Authzid) != "admin" || string(m.Username) != "user" || string(m.Nonce) != "abc" {
+		t.Errorf("Unexpected fields: %+v", m)
+	}
+}
+
+func TestClientFinalWithoutProof(t *testing.T) {
+	m, err := ParseClientFinal([]byte("c=biws,r=abc,p=AAAA"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(m.ChannelBinding) != "n,," {
+		t.Errorf("Unexpected channel binding: want=%q, got=%q", "n,,", m.ChannelBinding)
+	}
+	if w := string(m.WithoutProof()); w != "c=biws,r=abc" {
+		t.Errorf("Unexpected message without proof: want=%q, got=%q", "c=biws,r=abc", w)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"hash"
+)
+
+// A KeySealer protects SCRAM keys with a key held in hardware, such as a TPM
+// or a PKCS#11 token, so that a copy of the sealed keys (for example, from a
+// stolen disk image) cannot be used without the hardware.
+type KeySealer interface {
+	// Seal encrypts keys and returns the sealed blob.
+	Seal(keys ScramKeys) ([]byte, error)
+
+	// Unseal decrypts a blob returned by Seal.
+	// The returned keys are overwritten with zeros once they have been used.
+	Unseal(blob []byte) (ScramKeys, error)
+}
+
+// SealedScramSecret is a ScramSecret whose keys have been sealed by a
+// KeySealer.
+// The salt and iteration count are not secret and are stored in the clear so
+// that they can be checked against the values sent by the server before the
+// keys are unsealed.
+type SealedScramSecret struct {
+	Salt       []byte
+	Iterations int
+	Blob       []byte
+}
+
+// SealScramSecret seals the keys of s with sealer.
+// If s contains a SaltedPassword the keys are first derived from it using h,
+// which must be the hash function of the SCRAM mechanism that will be used.
+// The salted password itself is never sealed.
+func SealScramSecret(h func() hash.Hash, s ScramSecret, sealer KeySealer) (SealedScramSecret, error) {
+	keys := ScramKeys{ClientKey: s.ClientKey, ServerKey: s.ServerKey}
+	if len(s.SaltedPassword) > 0 {
+		keys.ClientKey, keys.ServerKey = newScramHash(h, true).keys(s.SaltedPassword)
+		defer zero(keys.ClientKey)
+		defer zero(keys.ServerKey)
+	}
+	blob, err := sealer.Seal(keys)
+	if err != nil {
+		return SealedScramSecret{}, err
+	}
+	return SealedScramSecret{
+		Salt:       append([]byte(nil), s.Salt...),
+		Iterations: s.Iterations,
+		Blob:       blob,
+	}, nil
+}
+
+// SealedSecret is like PrecomputedSecret except that the keys are unsealed
+// with sealer each time a SCRAM client needs them and are wiped as soon as the
+// proof has been computed.
+func SealedSecret(s SealedScramSecret, sealer KeySealer) Option {
+	return func(n *Negotiator) {
+		n.sealedSecret = &s
+		n.sealer = sealer
+	}
+}
+
+// unsealSecret returns the precomputed secret from the SealedSecret option.
+// The caller must zero the keys of the returned secret once they are no longer
+// needed.
+func (c *Negotiator) unsealSecret(salt []byte, iter int) (*ScramSecret, error) {
+	sec := c.sealedSecret
+	if sec.Iterations != iter || !bytes.Equal(sec.Salt, salt) {
+		return nil, ErrSecretMismatch
+	}
+	keys, err := c.sealer.Unseal(sec.Blob)
+	if err != nil {
+		return nil, err
+	}
+	return &ScramSecret{
+		Salt:       sec.Salt,
+		Iterations: sec.Iterations,
+		ClientKey:  keys.ClientKey,
+		ServerKey:  keys.ServerKey,
+	}, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// xorSealer is a KeySealer that stands in for a hardware key by XORing the
+// keys with a fixed byte.
+type xorSealer struct {
+	key      byte
+	unsealed [][]byte
+}
+
+func (s *xorSealer) Seal(keys ScramKeys) ([]byte, error) {
+	blob := append(append([]byte(nil), keys.ClientKey...), keys.ServerKey...)
+	for i := range blob {
+		blob[i] ^= s.key
+	}
+	return blob, nil
+}
+
+func (s *xorSealer) Unseal(blob []byte) (ScramKeys, error) {
+	if len(blob) != 2*sha256.Size {
+		return ScramKeys{}, errors.New("bad blob")
+	}
+	b := append([]byte(nil), blob...)
+	for i := range b {
+		b[i] ^= s.key
+	}
+	s.unsealed = append(s.unsealed, b)
+	return ScramKeys{ClientKey: b[:sha256.Size], ServerKey: b[sha256.Size:]}, nil
+}
+
+func TestSealedSecret(t *testing.T) {
+	salt := []byte("salt")
+	store := mapStore{
+		"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), salt, 4096),
+	}
+	saltedPassword := pbkdf2.Key([]byte("pencil"), salt, 4096, sha256.Size, sha256.New)
+	clientKey, serverKey := newScramHash(sha256.New, true).keys(saltedPassword)
+	errUnseal := errors.New("unseal failed")
+
+	for i, tc := range []struct {
+		secret ScramSecret
+		sealer KeySealer
+		err    error
+	}{
+		0: {secret: ScramSecret{Salt: salt, Iterations: 4096, SaltedPassword: saltedPassword}},
+		1: {secret: ScramSecret{Salt: salt, Iterations: 4096, ClientKey: clientKey, ServerKey: serverKey}},
+		2: {secret: ScramSecret{Salt: salt, Iterations: 8192, SaltedPassword: saltedPassword}, err: ErrSecretMismatch},
+		3: {
+			secret: ScramSecret{Salt: salt, Iterations: 4096, SaltedPassword: saltedPassword},
+			sealer: failingSealer{err: errUnseal},
+			err:    errUnseal,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			xor := &xorSealer{key: 0x5a}
+			sealed, err := SealScramSecret(sha256.New, tc.secret, xor)
+			if err != nil {
+				t.Fatalf("Error sealing secret: %v", err)
+			}
+			if bytes.Contains(sealed.Blob, clientKey) || bytes.Contains(sealed.Blob, saltedPassword) {
+				t.Fatalf("Sealed blob contains the unsealed keys")
+			}
+			sealer := tc.sealer
+			if sealer == nil {
+				sealer = xor
+			}
+			client := NewClient(ScramSha256, SealedSecret(sealed, sealer), Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), nil, nil
+			}))
+			server := NewServer(ScramSha256, acceptAll, Store(store))
+			if err := negotiate(client, server); err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			for _, b := range xor.unsealed {
+				if !bytes.Equal(b, make([]byte, len(b))) {
+					t.Errorf("Unsealed keys were not wiped")
+				}
+			}
+		})
+	}
+}
+
+type failingSealer struct {
+	err error
+}
+
+func (failingSealer) Seal(ScramKeys) ([]byte, error) {
+	return nil, nil
+}
+
+func (s failingSealer) Unseal([]byte) (ScramKeys, error) {
+	return ScramKeys{}, s.err
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// secretArena hands out memory for secrets from chunks of memory that are
+// locked into RAM and are not managed by the garbage collector.
+// The memory is zeroed when the arena is reset and released when the arena is
+// garbage collected.
+type secretArena struct {
+	chunks [][]byte
+	used   int
+}
+
+func newSecretArena() *secretArena {
+	a := &secretArena{}
+	runtime.SetFinalizer(a, (*secretArena).free)
+	return a
+}
+
+// alloc returns a zeroed slice of n bytes of locked memory.
+func (a *secretArena) alloc(n int) ([]byte, error) {
+	if len(a.chunks) == 0 || len(a.chunks[len(a.chunks)-1])-a.used < n {
+		size := os.Getpagesize()
+		for size < n {
+			size *= 2
+		}
+		chunk, err := lockedAlloc(size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrLockedMemory, err)
+		}
+		a.chunks = append(a.chunks, chunk)
+		a.used = 0
+	}
+	chunk := a.chunks[len(a.chunks)-1]
+	b := chunk[a.used : a.used+n : a.used+n]
+	a.used += n
+	return b, nil
+}
+
+// reset zeros all of the memory so that it can be reused.
+func (a *secretArena) reset() {
+	for _, chunk := range a.chunks {
+		zero(chunk)
+	}
+	if len(a.chunks) > 0 {
+		a.used = 0
+		// Keep the last chunk since it is the largest, release the rest.
+		for _, chunk := range a.chunks[:len(a.chunks)-1] {
+			lockedFree(chunk)
+		}
+		a.chunks = a.chunks[len(a.chunks)-1:]
+	}
+}
+
+// free zeros and releases all of the memory.
+func (a *secretArena) free() {
+	for _, chunk := range a.chunks {
+		zero(chunk)
+		lockedFree(chunk)
+	}
+	a.chunks = nil
+	a.used = 0
+}
+
+// lockSecret returns a copy of b in locked memory.
+func (c *Negotiator) lockSecret(b []byte) ([]byte, error) {
+	if c.secrets == nil {
+		c.secrets = newSecretArena()
+	}
+	locked, err := c.secrets.alloc(len(b))
+	if err != nil {
+		return nil, err
+	}
+	copy(locked, b)
+	return locked, nil
+}
+
+// moveSecret copies b into locked memory and zeros the original if the
+// LockedMemory option was used, otherwise it returns b unchanged.
+func (c *Negotiator) moveSecret(b []byte) ([]byte, error) {
+	if !c.lockedMemory || b == nil {
+		return b, nil
+	}
+	locked, err := c.lockSecret(b)
+	if err != nil {
+		return nil, err
+	}
+	zero(b)
+	return locked, nil
+}
@@ -0,0 +1,28 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build (darwin || linux) && !sasl_tiny
+
+package sasl
+
+import (
+	"syscall"
+)
+
+func lockedAlloc(size int) ([]byte, error) {
+	b, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Mlock(b); err != nil {
+		syscall.Munmap(b)
+		return nil, err
+	}
+	return b, nil
+}
+
+func lockedFree(b []byte) {
+	syscall.Munlock(b)
+	syscall.Munmap(b)
+}
@@ -0,0 +1,17 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build (!darwin && !linux) || sasl_tiny
+
+package sasl
+
+import (
+	"errors"
+)
+
+func lockedAlloc(int) ([]byte, error) {
+	return nil, errors.New("locked memory is not supported on this platform")
+}
+
+func lockedFree([]byte) {}
@@ -0,0 +1,89 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestLockedMemory(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	var pass []byte
+	client := NewClient(ScramSha256, LockedMemory(), WipeSecrets(), Credentials(func() ([]byte, []byte, []byte) {
+		pass = []byte("pencil")
+		return []byte("user"), pass, nil
+	}))
+
+	for run := 1; run < 3; run++ {
+		err := negotiate(client, NewServer(ScramSha256, acceptAll, Store(store)))
+		if errors.Is(err, ErrLockedMemory) {
+			t.Skipf("Locked memory is not available: %v", err)
+		}
+		if err != nil {
+			t.Fatalf("Run %d: unexpected error: %v", run, err)
+		}
+		if !client.Authenticated() {
+			t.Fatalf("Run %d: client did not authenticate", run)
+		}
+		if string(pass) != "\x00\x00\x00\x00\x00\x00" {
+			t.Errorf("Run %d: expected the original password to be wiped, got %q", run, pass)
+		}
+		client.Reset()
+	}
+}
+
+func TestLockedMemoryCredentials(t *testing.T) {
+	pass := []byte("pencil")
+	client := NewClient(Plain, LockedMemory(), Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), pass, nil
+	}))
+	_, resp, err := client.Step(nil)
+	if errors.Is(err, ErrLockedMemory) {
+		t.Skipf("Locked memory is not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(resp) != "\x00user\x00pencil" {
+		t.Errorf("Unexpected response %q", resp)
+	}
+	_, locked, _ := client.Credentials()
+	if string(locked) != "pencil" || &locked[0] == &pass[0] {
+		t.Errorf("Expected a copy of the password, got %q", locked)
+	}
+	client.Reset()
+	if string(locked) != "\x00\x00\x00\x00\x00\x00" {
+		t.Errorf("Expected locked password to be zeroed by Reset, got %q", locked)
+	}
+}
+
+func TestSecretArena(t *testing.T) {
+	a := newSecretArena()
+	defer a.free()
+	small, err := a.alloc(16)
+	if errors.Is(err, ErrLockedMemory) {
+		t.Skipf("Locked memory is not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	copy(small, "secret")
+	large, err := a.alloc(1 << 16)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(small) != 16 || cap(small) != 16 || len(large) != 1<<16 {
+		t.Errorf("Unexpected sizes: len=%d cap=%d, len=%d", len(small), cap(small), len(large))
+	}
+	copy(large, "secret")
+	a.reset()
+	for _, b := range large {
+		if b != 0 {
+			t.Fatal("Expected memory to be zeroed by reset")
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// A SecretSource looks up secrets such as passwords and tokens by key, for
+// example from a secrets manager, so that they can be fetched when they are
+// needed instead of being held in memory for the life of the process.
+type SecretSource interface {
+	// Get returns the secret for key.
+	// The caller owns the returned slice and may overwrite it once it is no
+	// longer needed.
+	// If there is no secret for key an error wrapping ErrSecretNotFound should
+	// be returned.
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// SecretSourceFunc is an adapter that lets an ordinary function be used as a
+// SecretSource.
+type SecretSourceFunc func(ctx context.Context, key string) ([]byte, error)
+
+// Get calls f(ctx, key).
+func (f SecretSourceFunc) Get(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// SecretPassword makes clients fetch the password for each negotiation from
+// src using key, in place of the password returned by the Credentials
+// callback (which is still used for the username and identity, if set).
+// The password is fetched when the negotiation starts, so rotated passwords
+// are picked up by calling Reset.
+// If src returns an error the first step fails with that error.
+func SecretPassword(src SecretSource, key string) Option {
+	return func(n *Negotiator) {
+		n.secretSource = src
+		n.secretKey = key
+	}
+}
+
+// EnvSecrets is a SecretSource that reads secrets from environment variables.
+// The variable for a key is named Prefix followed by the key.
+type EnvSecrets struct {
+	Prefix string
+}
+
+// Get returns the value of the environment variable for key.
+func (s EnvSecrets) Get(_ context.Context, key string) ([]byte, error) {
+	v, ok := os.LookupEnv(s.Prefix + key)
+	if !ok {
+		return nil, fmt.Errorf("%w: environment variable %s is not set", ErrSecretNotFound, s.Prefix+key)
+	}
+	return []byte(v), nil
+}
+
+// FileSecrets is a SecretSource that reads each secret from a file in Dir
+// named after its key, such as the files that container orchestrators mount
+// for secrets.
+// A single trailing newline is removed from the contents of the file.
+type FileSecrets struct {
+	Dir string
+}
+
+// Get returns the contents of the file for key.
+// Keys must be local file names, they may not contain ".." elements or be
+// absolute paths.
+func (s FileSecrets) Get(_ context.Context, key string) ([]byte, error) {
+	if !filepath.IsLocal(key) {
+		return nil, fmt.Errorf("%w: invalid key %q", ErrSecretNotFound, key)
+	}
+	b, err := os.ReadFile(filepath.Join(s.Dir, key))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %v", ErrSecretNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSuffix(b, []byte{'\n'})
+	return bytes.TrimSuffix(b, []byte{'\r'}), nil
+}
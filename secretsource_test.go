@@ -0,0 +1,72 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretPassword(t *testing.T) {
+	passwords := []string{"xipj3plmq", "rotated"}
+	calls := 0
+	src := SecretSourceFunc(func(_ context.Context, key string) ([]byte, error) {
+		if key != "kurt" {
+			return nil, ErrSecretNotFound
+		}
+		p := passwords[calls]
+		calls++
+		return []byte(p), nil
+	})
+	client := NewClient(Plain, Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("Kurt"), []byte("ignored"), nil
+	}), SecretPassword(src, "kurt"))
+
+	for _, want := range passwords {
+		_, resp, err := client.Step(nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got := string(resp); got != "\x00Kurt\x00"+want {
+			t.Errorf("Unexpected response: %q", got)
+		}
+		client.Reset()
+	}
+
+	client = NewClient(Plain, SecretPassword(src, "missing"))
+	if _, _, err := client.Step(nil); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+}
+
+func TestEnvSecrets(t *testing.T) {
+	t.Setenv("SASL_TEST_PASSWORD", "pencil")
+	src := EnvSecrets{Prefix: "SASL_TEST_"}
+	if b, err := src.Get(context.Background(), "PASSWORD"); err != nil || string(b) != "pencil" {
+		t.Errorf("Unexpected result: %q, %v", b, err)
+	}
+	if _, err := src.Get(context.Background(), "MISSING"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+}
+
+func TestFileSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "password"), []byte("pencil\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	src := FileSecrets{Dir: dir}
+	if b, err := src.Get(context.Background(), "password"); err != nil || string(b) != "pencil" {
+		t.Errorf("Unexpected result: %q, %v", b, err)
+	}
+	for _, key := range []string{"missing", "../password", "/etc/passwd", ""} {
+		if _, err := src.Get(context.Background(), key); !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("%q: expected ErrSecretNotFound, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// Channel binding types defined in RFC 5929.
+const (
+	ChannelBindingTLSUnique         = "tls-unique"
+	ChannelBindingTLSServerEndPoint = "tls-server-end-point"
+)
+
+// SecurityProperties describes the protection provided by a completed
+// negotiation, for example so that audit logs can record how a session was
+// authenticated without inspecting mechanism names.
+type SecurityProperties struct {
+	// Mechanism is the name of the mechanism used.
+	Mechanism string
+
+	// NegotiationID is the ID of the negotiation (see NegotiationID).
+	NegotiationID string
+
+	// Plaintext is true if the credentials were sent in the clear by the
+	// mechanism, whether or not the transport was encrypted.
+	Plaintext bool
+
+	// ChannelBinding is true if the authentication was bound to the TLS
+	// connection and the binding was verified.
+	ChannelBinding bool
+
+	// MutualAuth is true if the server was authenticated to the client.
+	// It is only set on clients.
+	MutualAuth bool
+
+	// SecurityLayer is true if the mechanism negotiated a security layer that
+	// protects the data exchanged after authentication.
+	SecurityLayer bool
+}
+
+// SecurityProperties returns the security properties of the negotiation.
+// If the negotiation has not completed successfully only the mechanism name and
+// negotiation ID are set.
+func (c *Negotiator) SecurityProperties() SecurityProperties {
+	p := SecurityProperties{Mechanism: c.mechanism.Name, NegotiationID: c.negotiationID}
+	if !c.completed {
+		return p
+	}
+	p.Plaintext = c.mechanism.Capabilities.Plaintext
+	p.ChannelBinding = len(c.cbData) > 0
+	p.MutualAuth = c.VerifiedServer()
+	p.SecurityLayer = c.SecurityLayer() != nil
+	return p
+}
+
+// ChannelBinding returns the channel binding type (for example,
+// ChannelBindingTLSUnique) and data that the negotiation was bound to, so that
+// protocol extensions can bind tokens issued after authentication to the same
+// channel.
+// If the negotiation has not completed successfully or was not bound to the
+// TLS connection, cbType is empty and data is nil.
+// The data must not be modified.
+func (c *Negotiator) ChannelBinding() (cbType string, data []byte) {
+	if !c.completed || len(c.cbData) == 0 {
+		return "", nil
+	}
+	return c.cbType, c.cbData
+}
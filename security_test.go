@@ -0,0 +1,129 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"testing"
+)
+
+func TestSecurityProperties(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	tlsState := TLSState(tls.ConnectionState{TLSUnique: []byte("finishedmessage")})
+	layer := Mechanism{
+		Name: "X-LAYER",
+		Start: func(*Negotiator) (bool, []byte, interface{}, error) {
+			return true, nil, nil, nil
+		},
+		Next: func(*Negotiator, []byte, interface{}) (bool, []byte, interface{}, error) {
+			return false, nil, xorLayer{max: 8}, nil
+		},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		client *Negotiator
+		server *Negotiator
+		want   SecurityProperties
+	}{
+		{
+			name:   "plain",
+			client: NewClient(Plain, plainClientOpts...),
+			server: NewServer(Plain, acceptAll),
+			want:   SecurityProperties{Mechanism: "PLAIN", Plaintext: true},
+		},
+		{
+			name:   "scram",
+			client: NewClient(ScramSha256, scramClientOpts...),
+			server: NewServer(ScramSha256, acceptAll, Store(store)),
+			want:   SecurityProperties{Mechanism: "SCRAM-SHA-256", MutualAuth: true},
+		},
+		{
+			name:   "scram-plus",
+			client: NewClient(ScramSha256Plus, append([]Option{tlsState, RemoteMechanisms("SCRAM-SHA-256-PLUS")}, scramClientOpts...)...),
+			server: NewServer(ScramSha256Plus, acceptAll, tlsState, Store(store)),
+			want:   SecurityProperties{Mechanism: "SCRAM-SHA-256-PLUS", ChannelBinding: true, MutualAuth: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.client.SecurityProperties(); got != (SecurityProperties{Mechanism: tc.want.Mechanism, NegotiationID: tc.client.NegotiationID()}) {
+				t.Errorf("Unexpected properties before negotiation: %+v", got)
+			}
+			if err := negotiate(tc.client, tc.server); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			want := tc.want
+			want.NegotiationID = tc.client.NegotiationID()
+			if got := tc.client.SecurityProperties(); got != want {
+				t.Errorf("Unexpected client properties: want=%+v, got=%+v", want, got)
+			}
+			want.MutualAuth = false
+			want.NegotiationID = tc.server.NegotiationID()
+			if got := tc.server.SecurityProperties(); got != want {
+				t.Errorf("Unexpected server properties: want=%+v, got=%+v", want, got)
+			}
+			tc.client.Reset()
+			if got := tc.client.SecurityProperties(); got.ChannelBinding {
+				t.Error("Expected channel binding to be cleared by Reset")
+			}
+		})
+	}
+
+	c := NewClient(layer)
+	c.Step(nil)
+	c.Step([]byte("challenge"))
+	if got := c.SecurityProperties(); !got.SecurityLayer {
+		t.Errorf("Expected security layer, got %+v", got)
+	}
+}
+
+func TestChannelBinding(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	tlsState := TLSState(tls.ConnectionState{TLSUnique: []byte("finishedmessage")})
+
+	client := NewClient(ScramSha256Plus, append([]Option{tlsState, RemoteMechanisms("SCRAM-SHA-256-PLUS")}, scramClientOpts...)...)
+	server := NewServer(ScramSha256Plus, acceptAll, tlsState, Store(store))
+	if typ, data := client.ChannelBinding(); typ != "" || data != nil {
+		t.Errorf("Unexpected channel binding before negotiation: %q %q", typ, data)
+	}
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, n := range []*Negotiator{client, server} {
+		typ, data := n.ChannelBinding()
+		if typ != ChannelBindingTLSUnique || string(data) != "finishedmessage" {
+			t.Errorf("Unexpected channel binding: %q %q", typ, data)
+		}
+	}
+
+	client = NewClient(ScramSha256, append([]Option{tlsState}, scramClientOpts...)...)
+	server = NewServer(ScramSha256, acceptAll, Store(store))
+	if err := negotiate(client, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if typ, data := client.ChannelBinding(); typ != "" || data != nil {
+		t.Errorf("Unexpected channel binding without -PLUS: %q %q", typ, data)
+	}
+
+	// TLS 1.3 connections have no tls-unique value, so there is nothing to bind
+	// to.
+	tls13 := TLSState(tls.ConnectionState{Version: tls.VersionTLS13, HandshakeComplete: true})
+	client = NewClient(ScramSha256, append([]Option{tls13, AdvertiseChannelBinding(true)}, scramClientOpts...)...)
+	if _, resp, err := client.Step(nil); err != nil || !bytes.HasPrefix(resp, []byte("n,,")) {
+		t.Errorf("Expected client without channel binding data to send the n flag, got %q (%v)", resp, err)
+	}
+	client = NewClient(ScramSha256Plus, append([]Option{tls13, RemoteMechanisms("SCRAM-SHA-256-PLUS")}, scramClientOpts...)...)
+	server = NewServer(ScramSha256Plus, acceptAll, tls13, Store(store))
+	if err := negotiate(client, server); err == nil {
+		t.Errorf("Expected -PLUS negotiation without channel binding data to fail")
+	}
+	for _, n := range []*Negotiator{client, server} {
+		if typ, data := n.ChannelBinding(); typ != "" || data != nil || n.SecurityProperties().ChannelBinding {
+			t.Errorf("Unexpected channel binding without data: %q %q", typ, data)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// A SecurityLayer provides the integrity or confidentiality protection that
+// some mechanisms (such as DIGEST-MD5 and GSSAPI) negotiate for the data
+// exchanged after authentication as described in RFC 4422 §3.7.
+//
+// Mechanisms that negotiate a security layer should return it as the data from
+// their final step.
+type SecurityLayer interface {
+	// Wrap protects a buffer of at most MaxSendSize bytes for sending.
+	Wrap(p []byte) ([]byte, error)
+
+	// Unwrap verifies and decodes a buffer received from the peer.
+	Unwrap(p []byte) ([]byte, error)
+
+	// MaxSendSize is the largest buffer that may be passed to Wrap.
+	MaxSendSize() int
+
+	// MaxRecvSize is the largest protected buffer that will be accepted from
+	// the peer.
+	MaxRecvSize() int
+}
+
+// DefaultMaxBuffer is the size of the largest protected buffer that will be
+// accepted from the peer unless the MaxBuffer option is used.
+// It is the default maxbuf of DIGEST-MD5 (RFC 2831 §2.1.1).
+const DefaultMaxBuffer = 64 * 1024
+
+// MaxBuffer sets the size of the largest protected buffer that will be accepted
+// from the peer after a security layer is negotiated.
+// Mechanisms that negotiate a security layer advertise it to the peer (for
+// example, as the maxbuf directive of DIGEST-MD5) and should return a layer
+// with a MaxRecvSize no larger than it.
+// The size the peer advertises in return should be used as the layer's
+// MaxSendSize (less any overhead added by Wrap) so that WrapConn fragments
+// large writes into buffers that the peer will accept.
+func MaxBuffer(size int) Option {
+	return func(n *Negotiator) {
+		n.maxBuffer = size
+	}
+}
+
+// MaxBuffer returns the size of the largest protected buffer that the
+// negotiator will advertise to the peer.
+func (c *Negotiator) MaxBuffer() int {
+	return c.maxBuffer
+}
+
+// BufferSizes returns the buffer limits agreed with the peer: the largest
+// buffer that may be passed to the security layer for sending and the largest
+// protected buffer that will be accepted from the peer.
+// If no security layer was negotiated both are zero.
+func (c *Negotiator) BufferSizes() (send, recv int) {
+	l := c.SecurityLayer()
+	if l == nil {
+		return 0, 0
+	}
+	return l.MaxSendSize(), l.MaxRecvSize()
+}
+
+// SecurityLayer returns the security layer negotiated by the mechanism or nil
+// if the negotiation has not completed successfully or the mechanism did not
+// negotiate a security layer.
+func (c *Negotiator) SecurityLayer() SecurityLayer {
+	if !c.completed {
+		return nil
+	}
+	l, _ := c.cache.(SecurityLayer)
+	return l
+}
+
+// WrapConn returns an io.ReadWriter that uses the security layer l to protect
+// all data written to and read from rw.
+// Each protected buffer is preceded by its length as a four octet unsigned
+// integer in network byte order.
+func WrapConn(rw io.ReadWriter, l SecurityLayer) io.ReadWriter {
+	return &secureConn{rw: rw, layer: l}
+}
+
+type secureConn struct {
+	rw    io.ReadWriter
+	layer SecurityLayer
+	buf   []byte
+}
+
+func (c *secureConn) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if max := c.layer.MaxSendSize(); max > 0 && len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		wrapped, err := c.layer.Wrap(chunk)
+		if err != nil {
+			return n, err
+		}
+		frame := make([]byte, 4+len(wrapped))
+		binary.BigEndian.PutUint32(frame, uint32(len(wrapped)))
+		copy(frame[4:], wrapped)
+		if _, err = c.rw.Write(frame); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+func (c *secureConn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		var size [4]byte
+		if _, err := io.ReadFull(c.rw, size[:]); err != nil {
+			return 0, err
+		}
+		l := binary.BigEndian.Uint32(size[:])
+		if max := c.layer.MaxRecvSize(); max > 0 && l > uint32(max) {
+			return 0, ErrMessageTooLarge
+		}
+		wrapped := make([]byte, l)
+		if _, err := io.ReadFull(c.rw, wrapped); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		var err error
+		if c.buf, err = c.layer.Unwrap(wrapped); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// xorLayer is a toy security layer that "encrypts" data by flipping bits and
+// appends a checksum byte.
+type xorLayer struct {
+	max int
+}
+
+func (l xorLayer) Wrap(p []byte) ([]byte, error) {
+	out := make([]byte, len(p)+1)
+	for i, b := range p {
+		out[i] = b ^ 0xff
+		out[len(p)] += b
+	}
+	return out, nil
+}
+
+func (l xorLayer) Unwrap(p []byte) ([]byte, error) {
+	if len(p) == 0 {
+		return nil, errors.New("short buffer")
+	}
+	out := make([]byte, len(p)-1)
+	var sum byte
+	for i, b := range p[:len(p)-1] {
+		out[i] = b ^ 0xff
+		sum += out[i]
+	}
+	if sum != p[len(p)-1] {
+		return nil, errors.New("bad checksum")
+	}
+	return out, nil
+}
+
+func (l xorLayer) MaxSendSize() int { return l.max }
+func (l xorLayer) MaxRecvSize() int { return l.max + 1 }
+
+func TestWrapConn(t *testing.T) {
+	const msg = "the quick brown fox jumps over the lazy dog"
+	var buf bytes.Buffer
+	conn := WrapConn(&buf, xorLayer{max: 8})
+
+	n, err := io.WriteString(conn, msg)
+	if err != nil {
+		t.Fatalf("Unexpected error writing: %v", err)
+	}
+	if n != len(msg) {
+		t.Errorf("Unexpected write length: want=%d, got=%d", len(msg), n)
+	}
+	if want := len(msg) + 6*(4+1); buf.Len() != want {
+		t.Errorf("Unexpected number of bytes on the wire: want=%d, got=%d", want, buf.Len())
+	}
+	if bytes.Contains(buf.Bytes(), []byte("quick")) {
+		t.Error("Data was not protected by the security layer")
+	}
+
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error reading: %v", err)
+	}
+	if string(out) != msg {
+		t.Errorf("Unexpected data: want=%q, got=%q", msg, out)
+	}
+}
+
+func TestWrapConnTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := WrapConn(&buf, xorLayer{max: 16}).Write([]byte("0123456789")); err != nil {
+		t.Fatalf("Unexpected error writing: %v", err)
+	}
+	_, err := WrapConn(&buf, xorLayer{max: 4}).Read(make([]byte, 16))
+	if err != ErrMessageTooLarge {
+		t.Errorf("Unexpected error: want=%v, got=%v", ErrMessageTooLarge, err)
+	}
+}
+
+func TestNegotiatorSecurityLayer(t *testing.T) {
+	mech := Mechanism{
+		Name: "X-LAYER",
+		Start: func(*Negotiator) (bool, []byte, interface{}, error) {
+			return true, nil, nil, nil
+		},
+		Next: func(*Negotiator, []byte, interface{}) (bool, []byte, interface{}, error) {
+			return false, nil, xorLayer{max: 8}, nil
+		},
+	}
+	c := NewClient(mech)
+	if _, _, err := c.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if l := c.SecurityLayer(); l != nil {
+		t.Errorf("Expected no security layer before completion, got %v", l)
+	}
+	if _, _, err := c.Step([]byte("challenge")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := c.SecurityLayer().(xorLayer); !ok {
+		t.Errorf("Expected negotiated security layer, got %T", c.SecurityLayer())
+	}
+	c.Reset()
+	if l := c.SecurityLayer(); l != nil {
+		t.Errorf("Expected no security layer after reset, got %v", l)
+	}
+}
+
+func TestBufferSizes(t *testing.T) {
+	// The peer advertises a maxbuf of 5 and the layer adds one byte of overhead.
+	mech := Mechanism{
+		Name: "X-LAYER",
+		Start: func(n *Negotiator) (bool, []byte, interface{}, error) {
+			return false, nil, xorLayer{max: n.MaxBuffer() - 1}, nil
+		},
+	}
+	c := NewClient(mech)
+	if size := c.MaxBuffer(); size != DefaultMaxBuffer {
+		t.Errorf("Unexpected default max buffer: want=%d, got=%d", DefaultMaxBuffer, size)
+	}
+	if send, recv := c.BufferSizes(); send != 0 || recv != 0 {
+		t.Errorf("Expected no buffer sizes before completion, got %d, %d", send, recv)
+	}
+
+	c = NewClient(mech, MaxBuffer(5))
+	if _, _, err := c.Step(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	send, recv := c.BufferSizes()
+	if send != 4 || recv != 5 {
+		t.Errorf("Unexpected buffer sizes: want=4, 5, got=%d, %d", send, recv)
+	}
+
+	var buf bytes.Buffer
+	conn := WrapConn(&buf, c.SecurityLayer())
+	if _, err := conn.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("Unexpected error writing: %v", err)
+	}
+	if want := 3*4 + 10 + 3; buf.Len() != want {
+		t.Errorf("Write was not fragmented: want=%d bytes on the wire, got=%d", want, buf.Len())
+	}
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error reading: %v", err)
+	}
+	if string(out) != "0123456789" {
+		t.Errorf("Unexpected data: %q", out)
+	}
+}
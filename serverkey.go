@@ -0,0 +1,119 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"sync"
+)
+
+// ServerKeyID identifies the SCRAM key material pinned for a user of a server.
+// Mechanism is the name of the SCRAM mechanism without the "-PLUS" suffix.
+type ServerKeyID struct {
+	Server    string
+	Mechanism string
+	Username  string
+}
+
+// ServerKeyPin is the SCRAM key material that a server used to prove its
+// identity.
+// Fingerprint is a hash of the ServerKey, never the key itself, so pins may be
+// stored without protecting them as secrets.
+type ServerKeyPin struct {
+	Salt        []byte
+	Iterations  int
+	Fingerprint []byte
+}
+
+// Equal reports whether p and other are the same pin.
+func (p ServerKeyPin) Equal(other ServerKeyPin) bool {
+	return p.Iterations == other.Iterations &&
+		bytes.Equal(p.Salt, other.Salt) &&
+		ConstantTimeEqual(p.Fingerprint, other.Fingerprint)
+}
+
+// A ServerKeyStore remembers the SCRAM key material that each server used the
+// first time a client authenticated to it (trust on first use).
+type ServerKeyStore interface {
+	GetServerKey(id ServerKeyID) (pin ServerKeyPin, ok bool)
+	PutServerKey(id ServerKeyID, pin ServerKeyPin)
+}
+
+// PinServerKey makes SCRAM clients check the salt, iteration count, and
+// ServerKey used by server against those stored in store.
+// The first time a user authenticates to server they are stored, later
+// negotiations fail with ErrServerKeyChanged if they differ even if the server
+// signature is valid.
+// This gives long-lived clients a signal that they may be talking to an
+// impersonator that obtained the password, for example because the salt it
+// sends is not the one the real server uses.
+//
+// Changing the password of the user or the credentials stored by the server
+// also changes the pin, so applications must remove the pin when they do so
+// intentionally.
+func PinServerKey(store ServerKeyStore, server string) Option {
+	return func(n *Negotiator) {
+		n.serverKeyStore = store
+		n.serverKeyServer = server
+	}
+}
+
+func serverKeyID(server, name string, username []byte) ServerKeyID {
+	return ServerKeyID{
+		Server:    server,
+		Mechanism: TrimPlus(name),
+		Username:  string(username),
+	}
+}
+
+// checkServerKey compares pin to the stored pin for id, storing it if there is
+// none.
+func (c *Negotiator) checkServerKey(id ServerKeyID, pin ServerKeyPin) error {
+	old, ok := c.serverKeyStore.GetServerKey(id)
+	if !ok {
+		c.serverKeyStore.PutServerKey(id, pin)
+		return nil
+	}
+	if !old.Equal(pin) {
+		return ErrServerKeyChanged
+	}
+	return nil
+}
+
+// MemoryServerKeyStore is a ServerKeyStore that stores pins in memory.
+// The zero value is an empty store ready to use.
+type MemoryServerKeyStore struct {
+	mu   sync.Mutex
+	pins map[ServerKeyID]ServerKeyPin
+}
+
+// GetServerKey returns the pin stored for id, if any.
+func (s *MemoryServerKeyStore) GetServerKey(id ServerKeyID) (ServerKeyPin, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pin, ok := s.pins[id]
+	return pin, ok
+}
+
+// PutServerKey stores the pin for id.
+func (s *MemoryServerKeyStore) PutServerKey(id ServerKeyID, pin ServerKeyPin) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pins == nil {
+		s.pins = make(map[ServerKeyID]ServerKeyPin)
+	}
+	s.pins[id] = pin
+}
+
+// Forget removes all pins stored for username on server.
+func (s *MemoryServerKeyStore) Forget(server, username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.pins {
+		if id.Server == server && id.Username == username {
+			delete(s.pins, id)
+		}
+	}
+}
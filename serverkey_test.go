@@ -0,0 +1,50 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestPinServerKey(t *testing.T) {
+	genuine := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	impostor := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("other salt"), 4096)}
+	pins := &MemoryServerKeyStore{}
+	auth := func(server string, store mapStore) error {
+		client := NewClient(ScramSha256, append([]Option{PinServerKey(pins, server)}, scramClientOpts...)...)
+		return negotiate(client, NewServer(ScramSha256, acceptAll, Store(store)))
+	}
+
+	if err := auth("example.net", genuine); err != nil {
+		t.Fatalf("Unexpected error on first use: %v", err)
+	}
+	id := ServerKeyID{Server: "example.net", Mechanism: "SCRAM-SHA-256", Username: "user"}
+	pin, ok := pins.GetServerKey(id)
+	if !ok || pin.Iterations != 4096 || string(pin.Salt) != "salt" || len(pin.Fingerprint) != sha256.Size {
+		t.Fatalf("Unexpected pin: %+v", pin)
+	}
+	if err := auth("example.net", genuine); err != nil {
+		t.Errorf("Unexpected error with pinned key: %v", err)
+	}
+
+	// The impostor knows the password but not the server's salt.
+	if err := auth("example.net", impostor); err != ErrServerKeyChanged {
+		t.Errorf("Expected ErrServerKeyChanged, got %v", err)
+	}
+	if p, _ := pins.GetServerKey(id); !p.Equal(pin) {
+		t.Error("Pin was replaced after a mismatch")
+	}
+
+	// Other servers are unaffected.
+	if err := auth("example.com", impostor); err != nil {
+		t.Errorf("Unexpected error for unpinned server: %v", err)
+	}
+
+	pins.Forget("example.net", "user")
+	if err := auth("example.net", impostor); err != nil {
+		t.Errorf("Unexpected error after forgetting pin: %v", err)
+	}
+}
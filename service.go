@@ -0,0 +1,94 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// Service sets the name of the service being authenticated to as registered
+// with IANA (for example "imap", "smtp", or "xmpp").
+// It is not used by any of the mechanisms in this package but is needed by
+// mechanisms such as GSSAPI to construct the service principal name and
+// DIGEST-MD5 to construct the digest-uri.
+func Service(name string) Option {
+	return func(n *Negotiator) {
+		n.service = name
+	}
+}
+
+// Host sets the host name and port that the client connected to (or that the
+// server is listening on), for example for the host and port fields of
+// OAUTHBEARER.
+// A port of zero means that the port is unknown.
+func Host(host string, port int) Option {
+	return func(n *Negotiator) {
+		n.host = host
+		n.port = port
+	}
+}
+
+// ServerFQDN sets the fully qualified domain name of the server when it differs
+// from the host set with the Host option, for example after following a DNS SRV
+// record or a load balancer.
+func ServerFQDN(fqdn string) Option {
+	return func(n *Negotiator) {
+		n.serverFQDN = fqdn
+	}
+}
+
+// Service returns the service name set with the Service option.
+func (c *Negotiator) Service() string {
+	return c.service
+}
+
+// Host returns the host name and port set with the Host option.
+func (c *Negotiator) Host() (host string, port int) {
+	return c.host, c.port
+}
+
+// ServerFQDN returns the fully qualified domain name of the server set with the
+// ServerFQDN option, or if it was not set, the host set with the Host option.
+func (c *Negotiator) ServerFQDN() string {
+	if c.serverFQDN != "" {
+		return c.serverFQDN
+	}
+	return c.host
+}
+
+// Realm sets the realm that clients authenticate in for mechanisms that use
+// realms, such as DIGEST-MD5 and those backed by Kerberos.
+// Servers use it as the realm that they offer.
+func Realm(realm string) Option {
+	return func(n *Negotiator) {
+		n.realm = realm
+	}
+}
+
+// RealmSelector sets a function that clients use to choose a realm when the
+// server offers more than one.
+// If the function is set it takes precedence over the Realm option.
+func RealmSelector(f func(offered []string) (string, error)) Option {
+	return func(n *Negotiator) {
+		n.realmSelector = f
+	}
+}
+
+// SelectRealm is used by mechanisms to pick a realm from those offered by the
+// remote side.
+// If a RealmSelector was set it is called, otherwise the realm set with the
+// Realm option is returned.
+// If neither was set and only one realm was offered, it is returned.
+// If more than one realm was offered and none could be chosen, ErrRealm is
+// returned.
+func (c *Negotiator) SelectRealm(offered []string) (string, error) {
+	switch {
+	case c.realmSelector != nil:
+		return c.realmSelector(offered)
+	case c.realm != "":
+		return c.realm, nil
+	case len(offered) == 1:
+		return offered[0], nil
+	case len(offered) > 1:
+		return "", ErrRealm
+	}
+	return "", nil
+}
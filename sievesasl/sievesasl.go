@@ -0,0 +1,252 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package sievesasl implements the client side of the ManageSieve AUTHENTICATE
+// command (RFC 5804 §2.1) for any client negotiator.
+package sievesasl
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jh125486/sasl"
+)
+
+// maxQuoted is the longest string that is sent as a quoted string, longer
+// strings are sent as non-synchronizing literals.
+const maxQuoted = 1024
+
+// Error is returned when the server completes the AUTHENTICATE command with a
+// NO or BYE response.
+type Error struct {
+	// Status is the status of the response, NO or BYE.
+	Status string
+
+	// Text is the rest of the response, including any response code.
+	Text string
+}
+
+func (e *Error) Error() string {
+	return "sievesasl: authentication failed: " + e.Status + " " + e.Text
+}
+
+// Unwrap returns sasl.ErrAuthn so that all failures can be detected with
+// errors.Is.
+func (e *Error) Unwrap() error {
+	return sasl.ErrAuthn
+}
+
+// Temporary reports whether the server sent the TRYLATER response code
+// (RFC 5804 §1.3), meaning that authentication may succeed if it is retried
+// later.
+func (e *Error) Temporary() bool {
+	if !strings.HasPrefix(e.Text, "(") {
+		return false
+	}
+	code, _, ok := strings.Cut(e.Text[1:], ")")
+	code, _, _ = strings.Cut(code, " ")
+	return ok && strings.EqualFold(code, "TRYLATER")
+}
+
+var errMalformed = errors.New("sievesasl: malformed server response")
+
+// Authenticate runs the AUTHENTICATE command over rw using the client
+// negotiator n.
+// The initial response is always sent with the command.
+// Additional data sent in the SASL response code of the final OK response is
+// passed to the negotiator.
+//
+// If rw is a *bufio.ReadWriter its reader is used directly so that no data is
+// lost after the command completes.
+func Authenticate(ctx context.Context, rw io.ReadWriter, n *sasl.Negotiator) error {
+	return sasl.NegotiateConn(ctx, rw, n, NewCodec(rw, n.Mechanism().Name))
+}
+
+// NewCodec returns a codec that frames the AUTHENTICATE command for use with
+// sasl.NegotiateConn.
+// Responses from the server are read from r.
+func NewCodec(r io.Reader, mechanism string) sasl.Codec {
+	c := &codec{mechanism: mechanism, decode: base64.StdEncoding.DecodeString}
+	if brw, ok := r.(*bufio.ReadWriter); ok {
+		c.r = brw.Reader
+	} else if br, ok := r.(*bufio.Reader); ok {
+		c.r = br
+	} else {
+		c.r = bufio.NewReader(r)
+	}
+	return c
+}
+
+type codec struct {
+	mechanism string
+	started   bool
+	r         *bufio.Reader
+	decode    func(string) ([]byte, error)
+}
+
+func (c *codec) WriteResponse(w io.Writer, resp []byte) error {
+	if !c.started {
+		c.started = true
+		cmd := "AUTHENTICATE " + Quote(c.mechanism)
+		ir, ok := sasl.EncodeInitialResponse(resp, sasl.EmptyAsBase64)
+		if !ok {
+			// The mechanism has no initial response so the first continuation is
+			// its first challenge.
+			return writeLine(w, cmd)
+		}
+		return writeLine(w, cmd+" "+Quote(ir))
+	}
+	return writeLine(w, Quote(base64.StdEncoding.EncodeToString(resp)))
+}
+
+// SetDecoder implements sasl.Base64Codec.
+func (c *codec) SetDecoder(decode func(string) ([]byte, error)) {
+	c.decode = decode
+}
+
+func (c *codec) ReadChallenge(io.Reader) ([]byte, bool, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	if line != "" && (line[0] == '"' || line[0] == '{') {
+		s, rest, err := c.readString(line)
+		if err != nil {
+			return nil, false, err
+		}
+		if rest != "" {
+			return nil, false, errMalformed
+		}
+		challenge, err := c.decode(s)
+		if err != nil {
+			return nil, false, errMalformed
+		}
+		return challenge, false, nil
+	}
+
+	status, text, _ := strings.Cut(line, " ")
+	switch strings.ToUpper(status) {
+	case "OK":
+		const code = `(SASL `
+		if len(text) < len(code) || !strings.EqualFold(text[:len(code)], code) {
+			return nil, true, nil
+		}
+		s, rest, err := c.readString(text[len(code):])
+		if err != nil || !strings.HasPrefix(rest, ")") {
+			return nil, false, errMalformed
+		}
+		data, err := c.decode(s)
+		if err != nil {
+			return nil, false, errMalformed
+		}
+		return data, true, nil
+	case "NO", "BYE":
+		return nil, false, &Error{Status: strings.ToUpper(status), Text: text}
+	}
+	return nil, false, errMalformed
+}
+
+// readString parses a quoted string or literal from the start of s, reading
+// the literal data and the remainder of the line from the connection if
+// necessary.
+func (c *codec) readString(s string) (str, rest string, err error) {
+	if strings.HasPrefix(s, `"`) {
+		return Unquote(s)
+	}
+	size, ok := strings.CutPrefix(s, "{")
+	if !ok {
+		return "", "", errMalformed
+	}
+	size, ok = strings.CutSuffix(size, "}")
+	if !ok {
+		return "", "", errMalformed
+	}
+	size = strings.TrimSuffix(size, "+")
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 0 || n > sasl.DefaultMaxMessageSize*2 {
+		return "", "", errMalformed
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(c.r, b); err != nil {
+		return "", "", err
+	}
+	rest, err = c.r.ReadString('\n')
+	if err != nil {
+		return "", "", err
+	}
+	return string(b), strings.TrimRight(rest, "\r\n"), nil
+}
+
+// Abort cancels the exchange as described in RFC 5804 §2.1.
+func (c *codec) Abort(w io.Writer) error {
+	if err := writeLine(w, `"*"`); err != nil {
+		return err
+	}
+	// Consume the NO response.
+	_, _, err := c.ReadChallenge(nil)
+	var sieveErr *Error
+	if errors.As(err, &sieveErr) {
+		return nil
+	}
+	return err
+}
+
+// Quote returns s as a ManageSieve string.
+// Short strings are quoted and escaped, longer strings or strings containing
+// line breaks are returned as non-synchronizing literals.
+func Quote(s string) string {
+	if len(s) > maxQuoted || strings.ContainsAny(s, "\r\n\x00") {
+		return "{" + strconv.Itoa(len(s)) + "+}\r\n" + s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Unquote parses the quoted string at the start of s and returns its value and
+// the remainder of s.
+func Unquote(s string) (str, rest string, err error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", errMalformed
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i == len(s) || (s[i] != '"' && s[i] != '\\') {
+				return "", "", errMalformed
+			}
+		case '"':
+			return b.String(), strings.TrimPrefix(s[i+1:], " "), nil
+		}
+		b.WriteByte(s[i])
+	}
+	return "", "", errMalformed
+}
+
+func writeLine(w io.Writer, s string) error {
+	_, err := io.WriteString(w, s+"\r\n")
+	if err != nil {
+		return err
+	}
+	if bw, ok := w.(interface{ Flush() error }); ok {
+		return bw.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sievesasl_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/sievesasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+// serve implements just enough of a ManageSieve server to handle a single
+// AUTHENTICATE command. Challenges are sent as literals and the final server
+// message is sent in the SASL response code.
+func serve(conn net.Conn, server *sasl.Negotiator) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	readString := func() (string, bool) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		s, _, err := sievesasl.Unquote(strings.TrimSuffix(line, "\r\n"))
+		return s, err == nil
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	_, args, _ := strings.Cut(strings.TrimSuffix(line, "\r\n"), " ")
+	_, args, err = sievesasl.Unquote(args)
+	if err != nil {
+		io.WriteString(conn, "NO \"unexpected command\"\r\n")
+		return
+	}
+	resp, _, err := sievesasl.Unquote(args)
+	if err != nil {
+		io.WriteString(conn, "NO \"unexpected command\"\r\n")
+		return
+	}
+
+	for {
+		if resp == "*" {
+			io.WriteString(conn, "NO \"canceled\"\r\n")
+			return
+		}
+		b, _ := base64.StdEncoding.DecodeString(resp)
+		if server.Completed() {
+			io.WriteString(conn, "OK\r\n")
+			return
+		}
+		more, challenge, err := server.Step(b)
+		switch {
+		case err != nil:
+			io.WriteString(conn, "NO \"invalid credentials\"\r\n")
+			return
+		case !more:
+			if len(challenge) == 0 {
+				io.WriteString(conn, "OK\r\n")
+			} else {
+				io.WriteString(conn, "OK (SASL \""+base64.StdEncoding.EncodeToString(challenge)+"\")\r\n")
+			}
+			return
+		}
+		enc := base64.StdEncoding.EncodeToString(challenge)
+		io.WriteString(conn, "{"+strconv.Itoa(len(enc))+"}\r\n"+enc+"\r\n")
+		var ok bool
+		if resp, ok = readString(); !ok {
+			return
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		password string
+		fail     bool
+	}{
+		{name: "plain", mech: sasl.Plain, password: "pencil"},
+		{name: "plain-bad-password", mech: sasl.Plain, password: "pen", fail: true},
+		{name: "scram", mech: sasl.ScramSha256, password: "pencil"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			server := sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s))
+			go serve(serverConn, server)
+
+			client := sasl.NewClient(tc.mech, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(tc.password), nil
+			}))
+			err := sievesasl.Authenticate(context.Background(), clientConn, client)
+			switch {
+			case tc.fail && !errors.Is(err, sasl.ErrAuthn):
+				t.Errorf("Expected authentication error, got %v", err)
+			case !tc.fail && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if !tc.fail && tc.mech.Name == sasl.ScramSha256.Name && !client.VerifiedServer() {
+				t.Error("Server signature from the SASL response code was not verified")
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{in: "", out: `""`},
+		{in: "PLAIN", out: `"PLAIN"`},
+		{in: `a"b\c`, out: `"a\"b\\c"`},
+		{in: "a\r\nb", out: "{4+}\r\na\r\nb"},
+		{in: strings.Repeat("a", 1025), out: "{1025+}\r\n" + strings.Repeat("a", 1025)},
+	} {
+		if got := sievesasl.Quote(tc.in); got != tc.out {
+			t.Errorf("Unexpected quoted string for %q: want=%q, got=%q", tc.in, tc.out, got)
+		}
+		if strings.HasPrefix(tc.out, "{") {
+			continue
+		}
+		s, rest, err := sievesasl.Unquote(tc.out + " rest")
+		if err != nil {
+			t.Fatalf("Unexpected error unquoting %q: %v", tc.out, err)
+		}
+		if s != tc.in || rest != "rest" {
+			t.Errorf("Unexpected unquoted string: want=%q, got=%q (rest %q)", tc.in, s, rest)
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	abort := make(chan string, 1)
+	go func() {
+		defer serverConn.Close()
+		r := bufio.NewReader(serverConn)
+		r.ReadString('\n')
+		// Reply with a server-first-message containing a nonce that does not
+		// match.
+		io.WriteString(serverConn, `"`+base64.StdEncoding.EncodeToString([]byte("r=abc,s=c2FsdA==,i=4096"))+"\"\r\n")
+		line, _ := r.ReadString('\n')
+		io.WriteString(serverConn, "NO \"canceled\"\r\n")
+		abort <- line
+	}()
+
+	client := sasl.NewClient(sasl.ScramSha256, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	if err := sievesasl.Authenticate(context.Background(), clientConn, client); err == nil {
+		t.Fatal("Expected authentication to fail")
+	}
+	if got := <-abort; got != "\"*\"\r\n" {
+		t.Errorf("Expected exchange to be canceled, got %q", got)
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		temp bool
+	}{
+		{text: `(TRYLATER) "Server is busy"`, temp: true},
+		{text: `(trylater)`, temp: true},
+		{text: `(SASL "dj1ybUY5cHFWOFM3c3VBb1pXamE0ZEpSa0ZzS1E9")`},
+		{text: `"Authentication failed"`},
+	} {
+		err := &sievesasl.Error{Status: "NO", Text: tc.text}
+		if temp := sasl.IsTemporary(err); temp != tc.temp {
+			t.Errorf("IsTemporary(%v) = %t, want %t", err, temp, tc.temp)
+		}
+	}
+}
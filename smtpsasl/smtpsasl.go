@@ -0,0 +1,63 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package smtpsasl lets the net/smtp client authenticate using the mechanisms
+// in the sasl package.
+package smtpsasl
+
+import (
+	"errors"
+	"net/smtp"
+
+	"github.com/jh125486/sasl"
+)
+
+// ErrUnencrypted is returned when a mechanism that sends credentials in the
+// clear would be used over a connection without TLS.
+var ErrUnencrypted = errors.New("smtpsasl: refusing to use mechanism over an unencrypted connection")
+
+// Auth returns an smtp.Auth that authenticates using the client negotiator n.
+// The negotiator is reset at the start of each authentication attempt.
+//
+// net/smtp handles base64 encoding and cancels the exchange if the negotiator
+// returns an error.
+// Mechanisms that require TLS are refused over unencrypted connections unless
+// the server is on localhost.
+func Auth(n *sasl.Negotiator) smtp.Auth {
+	return auth{n: n}
+}
+
+type auth struct {
+	n *sasl.Negotiator
+}
+
+func (a auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	mech := a.n.Mechanism()
+	if mech.Capabilities.RequiresTLS && !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, ErrUnencrypted
+	}
+	a.n.Reset()
+	_, resp, err := a.n.Step(nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return mech.Name, resp, nil
+}
+
+func (a auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		// The server has accepted the exchange. SMTP cannot carry additional data
+		// with the success response so the mechanism must already be finished.
+		if !a.n.Completed() {
+			return nil, sasl.ErrAuthn
+		}
+		return nil, nil
+	}
+	_, resp, err := a.n.Step(fromServer)
+	return resp, err
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
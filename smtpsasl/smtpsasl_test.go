@@ -0,0 +1,99 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package smtpsasl_test
+
+import (
+	"crypto/sha256"
+	"net/smtp"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/smtpsasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func credentials() sasl.Option {
+	return sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	})
+}
+
+// exchange drives a as net/smtp would against server.
+func exchange(t *testing.T, a smtp.Auth, server *sasl.Negotiator) error {
+	t.Helper()
+	mech, resp, err := a.Start(&smtp.ServerInfo{Name: "mail.example.net", TLS: true})
+	if err != nil {
+		return err
+	}
+	if mech != server.Mechanism().Name {
+		t.Fatalf("Unexpected mechanism: want=%q, got=%q", server.Mechanism().Name, mech)
+	}
+	for {
+		more, challenge, err := server.Step(resp)
+		if err != nil {
+			return err
+		}
+		if !more && len(challenge) == 0 {
+			break
+		}
+		if resp, err = a.Next(challenge, true); err != nil {
+			return err
+		}
+		if !more {
+			// The final server data was sent as a 334 challenge, the (empty)
+			// response is then answered with 235.
+			break
+		}
+	}
+	_, err = a.Next([]byte("2.7.0 Authentication successful"), false)
+	return err
+}
+
+func TestAuth(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptAll := func(*sasl.Negotiator) bool { return true }
+
+	a := smtpsasl.Auth(sasl.NewClient(sasl.ScramSha256, credentials()))
+	// Run twice to make sure the negotiator is reset between attempts.
+	for i := 0; i < 2; i++ {
+		if err := exchange(t, a, sasl.NewServer(sasl.ScramSha256, acceptAll, sasl.Store(s))); err != nil {
+			t.Fatalf("Unexpected error on attempt %d: %v", i, err)
+		}
+	}
+
+	a = smtpsasl.Auth(sasl.NewClient(sasl.Plain, credentials()))
+	if err := exchange(t, a, sasl.NewServer(sasl.Plain, acceptAll)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestAuthEarlySuccess(t *testing.T) {
+	a := smtpsasl.Auth(sasl.NewClient(sasl.ScramSha256, credentials()))
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "mail.example.net", TLS: true}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := a.Next([]byte("2.7.0 Authentication successful"), false); err != sasl.ErrAuthn {
+		t.Errorf("Unexpected error: want=%v, got=%v", sasl.ErrAuthn, err)
+	}
+}
+
+func TestAuthUnencrypted(t *testing.T) {
+	a := smtpsasl.Auth(sasl.NewClient(sasl.Plain, credentials()))
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "mail.example.net"}); err != smtpsasl.ErrUnencrypted {
+		t.Errorf("Unexpected error: want=%v, got=%v", smtpsasl.ErrUnencrypted, err)
+	}
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "localhost"}); err != nil {
+		t.Errorf("Unexpected error on localhost: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/tls"
+	"log/slog"
+)
+
+// StartTLS prepares the negotiator for authentication over a connection that
+// has just been upgraded to TLS, for example with the STARTTLS command of SMTP
+// or IMAP, so that the same negotiator can be used before and after the
+// upgrade.
+//
+// Any negotiation in progress is abandoned and the negotiator is reset as if
+// by Reset.
+// The TLS state then replaces any state provided earlier, which lets -PLUS
+// mechanisms bind to the new session and makes the transport confidential for
+// mechanisms that require TLS (see the Confidential option).
+// Protocols require clients to discard the mechanisms advertised before the
+// upgrade because an attacker could have modified them, so remoteMechanisms
+// always replaces the previous list (see UpdateRemoteMechanisms) even if it is
+// empty.
+//
+// The mechanism is then checked against the policy set by the options, and if
+// it would not be allowed (for example, because it is weaker than the
+// mechanism pinned with the Pin option) the error that the first step would
+// have returned is returned instead, so that the caller can select a different
+// mechanism before sending anything.
+// The negotiator can still be used if an error is returned, but the first step
+// will fail with the same error.
+func (c *Negotiator) StartTLS(cs tls.ConnectionState, remoteMechanisms ...string) error {
+	c.Reset()
+	c.tlsState = &cs
+	c.setRemoteMechanisms(remoteMechanisms)
+	c.debug("attached TLS state", slog.Bool("handshake_complete", cs.HandshakeComplete), slog.Bool("remote_cb", c.flags.RemoteSupportsCB()))
+
+	if err := c.checkPolicy(); err != nil {
+		return err
+	}
+	return c.checkPin()
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"testing"
+)
+
+func TestStartTLS(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	cs := tls.ConnectionState{HandshakeComplete: true, TLSUnique: []byte("finishedmessage")}
+
+	client := NewClient(ScramSha256Plus, append(scramClientOpts, RemoteMechanisms("SCRAM-SHA-256"))...)
+	if _, _, err := client.Step(nil); err != nil {
+		t.Fatalf("Unexpected error starting negotiation: %v", err)
+	}
+	if err := client.StartTLS(cs, "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.State() != Initial|RemoteCB {
+		t.Errorf("Negotiator was not reset with channel binding support: state=%v", client.State())
+	}
+	server := NewServer(ScramSha256Plus, acceptAll, Store(store), TLSState(cs))
+	if err := negotiate(client, server); err != nil {
+		t.Errorf("Unexpected error negotiating after StartTLS: %v", err)
+	}
+	if typ, _ := server.ChannelBinding(); typ == "" {
+		t.Error("Expected negotiation to use channel binding")
+	}
+
+	client = NewClient(Plain, append(plainClientOpts, Confidential(false))...)
+	var insecureErr InsecureTransportError
+	if err := client.StartTLS(tls.ConnectionState{}); !errors.As(err, &insecureErr) {
+		t.Errorf("Expected InsecureTransportError for incomplete handshake, got %v", err)
+	}
+	if err := client.StartTLS(cs, "PLAIN"); err != nil {
+		t.Errorf("Unexpected error after handshake: %v", err)
+	}
+
+	pins := &MemoryPinStore{}
+	pins.PutPin("example.net", StrengthMutual)
+	client = NewClient(Plain, append(plainClientOpts, Pin(pins, "example.net"))...)
+	if err := client.StartTLS(cs, "PLAIN"); err != ErrDowngrade {
+		t.Errorf("Expected ErrDowngrade, got %v", err)
+	}
+}
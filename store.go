@@ -0,0 +1,122 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"hash"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// StoredCredentials are the values that a server stores for a SCRAM user in
+// place of their password as described in RFC 5802 §3.
+type StoredCredentials struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// A CredentialStore is used by servers to look up the credentials of the user
+// being authenticated.
+type CredentialStore interface {
+	// ScramCredentials returns the stored credentials of username for use with
+	// the named SCRAM mechanism.
+	// If the user does not exist, ErrUnknownUser should be returned.
+	ScramCredentials(mechanism string, username []byte) (StoredCredentials, error)
+}
+
+// A ContextCredentialStore is a CredentialStore that can abandon a lookup when
+// the negotiation's context (see StepContext) is canceled or expires.
+// If the store passed to the Store option implements it, servers call
+// ScramCredentialsContext instead of ScramCredentials.
+type ContextCredentialStore interface {
+	CredentialStore
+	ScramCredentialsContext(ctx context.Context, mechanism string, username []byte) (StoredCredentials, error)
+}
+
+// scramCredentials looks up the credentials of username in the store, passing
+// on the negotiation's context if the store supports it.
+func (c *Negotiator) scramCredentials(mechanism string, username []byte) (StoredCredentials, error) {
+	if s, ok := c.store.(ContextCredentialStore); ok {
+		return s.ScramCredentialsContext(c.Context(), mechanism, username)
+	}
+	return c.store.ScramCredentials(mechanism, username)
+}
+
+// Store sets the credential store used by servers to authenticate users.
+func Store(s CredentialStore) Option {
+	return func(n *Negotiator) {
+		n.store = s
+	}
+}
+
+// DeriveStoredCredentials calculates the SCRAM credentials that a server should
+// store for a password using the hash function h (eg. sha256.New for
+// SCRAM-SHA-256).
+// The password should already have been normalized using SASLprep.
+func DeriveStoredCredentials(h func() hash.Hash, password, salt []byte, iter int) StoredCredentials {
+	saltedPassword := pbkdf2.Key(password, salt, iter, h().Size(), h)
+
+	clientKey, serverKey := newScramHash(h, fipsBuild).keys(saltedPassword)
+
+	storedKey := h()
+	storedKey.Write(clientKey)
+
+	return StoredCredentials{
+		Salt:       salt,
+		Iterations: iter,
+		StoredKey:  storedKey.Sum(nil),
+		ServerKey:  serverKey,
+	}
+}
+
+// TuneIterations measures how long PBKDF2 takes on the current machine using
+// the hash function h and returns the iteration count that takes roughly budget
+// to compute.
+// It is meant to be called once when a server starts so that new credentials
+// can be derived with as many iterations as the hardware allows instead of the
+// minimum.
+// The result is never smaller than DefaultMinIterations or larger than
+// DefaultMaxIterations, so it will always be accepted by clients using the
+// default limits.
+func TuneIterations(h func() hash.Hash, budget time.Duration) int {
+	if budget <= 0 {
+		return DefaultMinIterations
+	}
+
+	password := []byte("password")
+	salt := make([]byte, 16)
+	size := h().Size()
+
+	// Keep doubling the iteration count until a single run takes a reasonable
+	// fraction of the budget so that timer resolution and startup costs don't
+	// skew the result.
+	iter := 1024
+	var elapsed time.Duration
+	for {
+		start := time.Now()
+		pbkdf2.Key(password, salt, iter, size, h)
+		elapsed = time.Since(start)
+		if elapsed >= budget/4 || iter >= DefaultMaxIterations {
+			break
+		}
+		iter *= 2
+	}
+	if elapsed <= 0 {
+		return DefaultMaxIterations
+	}
+
+	n := int(float64(iter) * float64(budget) / float64(elapsed))
+	switch {
+	case n < DefaultMinIterations:
+		return DefaultMinIterations
+	case n > DefaultMaxIterations:
+		return DefaultMaxIterations
+	}
+	return n
+}
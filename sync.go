@@ -0,0 +1,125 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"sync/atomic"
+)
+
+// SyncNegotiator wraps a Negotiator so that it can be shared between
+// goroutines, for example between the read and write loops of a connection.
+// All methods are serialized with a mutex.
+//
+// Calling Step while another call to Step is in progress is always a bug (the
+// order of the challenges would be undefined), so instead of waiting the second
+// call fails with ErrConcurrentStep and the negotiator is left untouched.
+type SyncNegotiator struct {
+	mu       sync.Mutex
+	stepping atomic.Bool
+	n        *Negotiator
+}
+
+// Synchronized returns a SyncNegotiator that wraps n.
+// Once wrapped, n should not be used directly.
+func Synchronized(n *Negotiator) *SyncNegotiator {
+	return &SyncNegotiator{n: n}
+}
+
+// Step calls Step on the underlying negotiator.
+// If another goroutine is already in Step it returns ErrConcurrentStep.
+func (s *SyncNegotiator) Step(challenge []byte) (more bool, resp []byte, err error) {
+	if !s.stepping.CompareAndSwap(false, true) {
+		return false, nil, ErrConcurrentStep
+	}
+	defer s.stepping.Store(false)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n.Step(challenge)
+}
+
+// StepContext calls StepContext on the underlying negotiator.
+// If another goroutine is already in Step it returns ErrConcurrentStep.
+func (s *SyncNegotiator) StepContext(ctx context.Context, challenge []byte) (more bool, resp []byte, err error) {
+	if !s.stepping.CompareAndSwap(false, true) {
+		return false, nil, ErrConcurrentStep
+	}
+	defer s.stepping.Store(false)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n.StepContext(ctx, challenge)
+}
+
+// Reset calls Reset on the underlying negotiator, waiting for any step that is
+// in progress to finish.
+func (s *SyncNegotiator) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n.Reset()
+}
+
+// Abort calls Abort on the underlying negotiator, waiting for any step that is
+// in progress to finish.
+func (s *SyncNegotiator) Abort() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n.Abort()
+}
+
+// ResetWithCredentials resets the underlying negotiator and replaces its
+// credentials callback.
+func (s *SyncNegotiator) ResetWithCredentials(f func() (Username, Password, Identity []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n.ResetWithCredentials(f)
+}
+
+// State returns the state of the underlying negotiator.
+func (s *SyncNegotiator) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n.State()
+}
+
+// Completed reports whether the underlying negotiator completed.
+func (s *SyncNegotiator) Completed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n.Completed()
+}
+
+// Authenticated reports whether the underlying negotiator completed and
+// authenticated the remote side.
+func (s *SyncNegotiator) Authenticated() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n.Authenticated()
+}
+
+// Mechanism returns the mechanism of the underlying negotiator.
+func (s *SyncNegotiator) Mechanism() Mechanism {
+	return s.n.Mechanism()
+}
+
+// VerifiedServer reports whether the underlying negotiator verified the server.
+func (s *SyncNegotiator) VerifiedServer() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n.VerifiedServer()
+}
+
+// Credentials returns the credentials of the underlying negotiator.
+func (s *SyncNegotiator) Credentials() (username, password, identity []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n.Credentials()
+}
+
+// TLSState returns the TLS state of the underlying negotiator.
+func (s *SyncNegotiator) TLSState() *tls.ConnectionState {
+	return s.n.TLSState()
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"testing"
+)
+
+func TestSyncNegotiator(t *testing.T) {
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	m := Mechanism{
+		Name: "SLOW",
+		Start: func(*Negotiator) (bool, []byte, interface{}, error) {
+			close(entered)
+			<-unblock
+			return false, []byte("done"), nil, nil
+		},
+	}
+	n := Synchronized(NewClient(m))
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := n.Step(nil)
+		errs <- err
+	}()
+	<-entered
+	if _, _, err := n.Step(nil); err != ErrConcurrentStep {
+		t.Errorf("Expected ErrConcurrentStep, got %v", err)
+	}
+	close(unblock)
+	if err := <-errs; err != nil {
+		t.Fatalf("Unexpected error from first step: %v", err)
+	}
+	if !n.Completed() {
+		t.Error("Expected negotiator to complete")
+	}
+	if n.State()&Errored == Errored {
+		t.Error("Concurrent step should not set the error state")
+	}
+	n.Reset()
+	if n.Completed() {
+		t.Error("Expected Reset to clear completion")
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"errors"
+)
+
+// Temporary wraps err to mark it as a temporary failure, for example because a
+// mechanism could not reach the credential store or token endpoint that it
+// depends on.
+func Temporary(err error) error {
+	if err == nil {
+		return nil
+	}
+	return temporaryError{err: err}
+}
+
+type temporaryError struct {
+	err error
+}
+
+func (e temporaryError) Error() string   { return e.err.Error() }
+func (e temporaryError) Unwrap() error   { return e.err }
+func (e temporaryError) Temporary() bool { return true }
+
+// IsTemporary reports whether err is a temporary failure, such as the server
+// being busy, that may go away if the negotiation is retried later.
+// Other failures, such as invalid credentials, are permanent and retrying them
+// will only put extra load on the server.
+//
+// An error is temporary if the first error in its tree with a Temporary method
+// reports true (for example, errors wrapped with Temporary and the errors
+// returned by the protocol packages when the server reports a temporary
+// failure), if it is ErrStepTimeout, ErrBusy, or context.DeadlineExceeded, or if
+// it is a timeout such as a network timeout.
+func IsTemporary(err error) bool {
+	if errors.Is(err, ErrStepTimeout) || errors.Is(err, ErrBusy) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var t interface{ Temporary() bool }
+	if errors.As(err, &t) {
+		return t.Temporary()
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestIsTemporary(t *testing.T) {
+	errUnavailable := errors.New("token endpoint unavailable")
+	for i, tc := range []struct {
+		err  error
+		temp bool
+	}{
+		0: {err: nil},
+		1: {err: ErrAuthn},
+		2: {err: ErrUnknownUser},
+		3: {err: Temporary(errUnavailable), temp: true},
+		4: {err: fmt.Errorf("step: %w", Temporary(errUnavailable)), temp: true},
+		5: {err: Retryable(Temporary(errUnavailable)), temp: true},
+		6: {err: ErrStepTimeout, temp: true},
+		7: {err: context.DeadlineExceeded, temp: true},
+		8: {err: fmt.Errorf("read: %w", os.ErrDeadlineExceeded), temp: true},
+		9: {err: Temporary(nil)},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			if temp := IsTemporary(tc.err); temp != tc.temp {
+				t.Errorf("IsTemporary(%v) = %t, want %t", tc.err, temp, tc.temp)
+			}
+			if errors.Unwrap(Temporary(errUnavailable)) != errUnavailable {
+				t.Error("Temporary did not wrap the original error")
+			}
+		})
+	}
+}
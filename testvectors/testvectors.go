@@ -0,0 +1,142 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package testvectors contains the example exchanges from the RFCs that define
+// the SCRAM and OAUTHBEARER mechanisms as plain data.
+//
+// The package does not depend on the sasl package so that adapters, alternative
+// implementations, and verification tools can replay the vectors against
+// whatever implementation they are testing.
+// The vectors are checked against the mechanisms in this module by the
+// package's own tests.
+package testvectors
+
+// Message is a single message sent during an exchange.
+type Message struct {
+	// FromServer is true if the message is a challenge sent by the server and
+	// false if it is a response sent by the client.
+	FromServer bool
+
+	// Data is the message before any encoding applied by the protocol, such as
+	// the base64 encoding used by IMAP and SMTP.
+	Data string
+}
+
+// Vector is an example exchange and the inputs that produce it.
+type Vector struct {
+	// Name identifies the vector in test output.
+	Name string
+
+	// Source is the document and section that the vector was taken from.
+	Source string
+
+	// Mechanism is the SASL mechanism name.
+	Mechanism string
+
+	// Username, Password, and AuthzID are the credentials used by the client.
+	// AuthzID is empty if the client does not request an authorization identity.
+	Username string
+	Password string
+	AuthzID  string
+
+	// ClientNonce and ServerNonce are the nonces generated by each side of a
+	// SCRAM exchange.
+	// The nonce sent by the server is ClientNonce followed by ServerNonce.
+	ClientNonce string
+	ServerNonce string
+
+	// Salt and Iterations are the SCRAM parameters stored by the server for the
+	// user.
+	Salt       []byte
+	Iterations int
+
+	// Token, Host, and Port are sent by OAUTHBEARER clients.
+	// Port is zero if the client does not send one.
+	Token string
+	Host  string
+	Port  int
+
+	// Messages is the exchange in the order that it was sent.
+	Messages []Message
+
+	// Fails is true if the server rejects the client at the end of the
+	// exchange.
+	Fails bool
+}
+
+// The error document sent in RFC 7628 §4.3 without the whitespace added for
+// readability.
+const oauthError = `{"status":"invalid_token","scope":"example_scope","openid-configuration":"https://example.com/.well-known/openid-configuration"}`
+
+// SCRAM contains the examples from the RFCs that define the SCRAM mechanisms.
+var SCRAM = []Vector{{
+	Name:        "RFC5802",
+	Source:      "RFC 5802 §5",
+	Mechanism:   "SCRAM-SHA-1",
+	Username:    "user",
+	Password:    "pencil",
+	ClientNonce: "fyko+d2lbbFgONRv9qkxdawL",
+	ServerNonce: "3rfcNHYJY1ZVvWVs7j",
+	Salt:        []byte("\x41\x25\xc2\x47\xe4\x3a\xb1\xe9\x3c\x6d\xff\x76"),
+	Iterations:  4096,
+	Messages: []Message{
+		{Data: "n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL"},
+		{FromServer: true, Data: "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"},
+		{Data: "c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts="},
+		{FromServer: true, Data: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ="},
+	},
+}, {
+	Name:        "RFC7677",
+	Source:      "RFC 7677 §3",
+	Mechanism:   "SCRAM-SHA-256",
+	Username:    "user",
+	Password:    "pencil",
+	ClientNonce: "rOprNGfwEbeRWgbNEkqO",
+	ServerNonce: "%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0",
+	Salt:        []byte("\x5b\x6d\x99\x68\x9d\x12\x35\x8e\xec\xa0\x4b\x14\x12\x36\xfa\x81"),
+	Iterations:  4096,
+	Messages: []Message{
+		{Data: "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"},
+		{FromServer: true, Data: "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"},
+		{Data: "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="},
+		{FromServer: true, Data: "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="},
+	},
+}}
+
+// OAuthBearer contains the examples from RFC 7628.
+var OAuthBearer = []Vector{{
+	Name:      "RFC7628-Success",
+	Source:    "RFC 7628 §4.1",
+	Mechanism: "OAUTHBEARER",
+	Username:  "user@example.com",
+	AuthzID:   "user@example.com",
+	Token:     "vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg==",
+	Host:      "server.example.com",
+	Port:      143,
+	Messages: []Message{
+		{Data: "n,a=user@example.com,\x01host=server.example.com\x01port=143\x01auth=Bearer vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg==\x01\x01"},
+	},
+}, {
+	Name:      "RFC7628-Failure",
+	Source:    "RFC 7628 §4.3",
+	Mechanism: "OAUTHBEARER",
+	Username:  "user@example.com",
+	AuthzID:   "user@example.com",
+	Token:     "vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg==",
+	Host:      "server.example.com",
+	Port:      587,
+	Messages: []Message{
+		{Data: "n,a=user@example.com,\x01host=server.example.com\x01port=587\x01auth=Bearer vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg==\x01\x01"},
+		{FromServer: true, Data: oauthError},
+		{Data: "\x01"},
+	},
+	Fails: true,
+}}
+
+// All returns every vector in the package.
+func All() []Vector {
+	all := make([]Vector, 0, len(SCRAM)+len(OAuthBearer))
+	all = append(all, SCRAM...)
+	return append(all, OAuthBearer...)
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package testvectors_test
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/oauthsasl"
+	"github.com/jh125486/sasl/sasltest"
+	"github.com/jh125486/sasl/testvectors"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+func fixedNonce(nonce string) sasl.Option {
+	return sasl.NonceSource(func() []byte {
+		return []byte(nonce)
+	})
+}
+
+// negotiators returns a client and server that should reproduce v.
+func negotiators(t *testing.T, v testvectors.Vector) (client, server *sasl.Negotiator) {
+	clientOpts := []sasl.Option{sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte(v.Username), []byte(v.Password), []byte(v.AuthzID)
+	})}
+	acceptAll := func(*sasl.Negotiator) bool { return true }
+	switch v.Mechanism {
+	case sasl.ScramSha1.Name, sasl.ScramSha256.Name:
+		m, h := sasl.ScramSha1, sha1.New
+		if v.Mechanism == sasl.ScramSha256.Name {
+			m, h = sasl.ScramSha256, sha256.New
+		}
+		client = sasl.NewClient(m, append(clientOpts, fixedNonce(v.ClientNonce))...)
+		server = sasl.NewServer(m, acceptAll, fixedNonce(v.ServerNonce), sasl.Store(store{
+			v.Username: sasl.DeriveStoredCredentials(h, []byte(v.Password), v.Salt, v.Iterations),
+		}))
+	case "OAUTHBEARER":
+		client = sasl.NewClient(oauthsasl.Client(oauthsasl.TokenSourceFunc(func(bool) (string, error) {
+			return v.Token, nil
+		})), append(clientOpts, sasl.Host(v.Host, v.Port))...)
+		server = sasl.NewServer(oauthsasl.Server(oauthsasl.ValidatorFunc(func(token string) (string, error) {
+			if v.Fails {
+				return "", &oauthsasl.Error{
+					Status:              oauthsasl.StatusInvalidToken,
+					Scope:               "example_scope",
+					OpenIDConfiguration: "https://example.com/.well-known/openid-configuration",
+				}
+			}
+			return v.Username, nil
+		})), acceptAll)
+	default:
+		t.Fatalf("No mechanism for vector %s", v.Mechanism)
+	}
+	return client, server
+}
+
+func TestVectors(t *testing.T) {
+	for _, v := range testvectors.All() {
+		t.Run(v.Name, func(t *testing.T) {
+			transcript, err := sasltest.Negotiate(negotiators(t, v))
+			switch {
+			case v.Fails && err == nil:
+				t.Errorf("Expected negotiation to fail")
+			case !v.Fails && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if len(transcript) != len(v.Messages) {
+				t.Fatalf("Wrong number of messages: want=%d, got:\n%s", len(v.Messages), transcript)
+			}
+			for i, m := range v.Messages {
+				if got := transcript[i]; got.FromServer != m.FromServer || string(got.Data) != m.Data {
+					t.Errorf("Message %d: want=%q (from server: %t), got=%q (from server: %t)", i, m.Data, m.FromServer, got.Data, got.FromServer)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package thriftsasl implements the client side of the Thrift SASL transport
+// used by Hadoop services such as HiveServer2 and Impala.
+//
+// During the handshake each message is a status byte followed by a four octet
+// big endian length and the payload.
+// After authentication each frame is a four octet length followed by the data,
+// which is protected by the negotiated security layer (if any).
+package thriftsasl
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/jh125486/sasl"
+)
+
+// Status bytes sent during the handshake.
+const (
+	StatusStart    byte = 1
+	StatusOK       byte = 2
+	StatusBad      byte = 3
+	StatusError    byte = 4
+	StatusComplete byte = 5
+)
+
+// MaxFrameSize is the largest frame that will be read from the server unless a
+// security layer sets a lower limit.
+const MaxFrameSize = 16 * 1024 * 1024
+
+var errMalformed = errors.New("thriftsasl: malformed message")
+
+// Error is returned when the server fails the handshake.
+type Error struct {
+	Status  byte
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "thriftsasl: server rejected authentication: " + e.Message
+}
+
+// Unwrap returns sasl.ErrAuthn so that all failures can be detected with
+// errors.Is.
+func (e *Error) Unwrap() error {
+	return sasl.ErrAuthn
+}
+
+// WriteMessage writes a handshake message.
+func WriteMessage(w io.Writer, status byte, payload []byte) error {
+	msg := make([]byte, 5+len(payload))
+	msg[0] = status
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(payload)))
+	copy(msg[5:], payload)
+	_, err := w.Write(msg)
+	return err
+}
+
+// ReadMessage reads a handshake message.
+func ReadMessage(r io.Reader) (status byte, payload []byte, err error) {
+	var hdr [5]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	l := binary.BigEndian.Uint32(hdr[1:])
+	if l > sasl.DefaultMaxMessageSize {
+		return 0, nil, sasl.ErrMessageTooLarge
+	}
+	payload = make([]byte, l)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], payload, nil
+}
+
+// Handshake authenticates to the server over rw using the client negotiator n.
+// If the negotiator returns an error it is reported to the server with an
+// ERROR message.
+func Handshake(rw io.ReadWriter, n *sasl.Negotiator) error {
+	_, resp, err := n.Step(nil)
+	if err != nil {
+		return err
+	}
+	if err = WriteMessage(rw, StatusStart, []byte(n.Mechanism().Name)); err != nil {
+		return err
+	}
+	if err = writeResponse(rw, n, resp); err != nil {
+		return err
+	}
+
+	for {
+		status, payload, err := ReadMessage(rw)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case StatusOK:
+			if _, resp, err = n.Step(payload); err != nil {
+				WriteMessage(rw, StatusError, []byte(err.Error()))
+				return err
+			}
+			if err = writeResponse(rw, n, resp); err != nil {
+				return err
+			}
+		case StatusComplete:
+			return n.Finish(payload)
+		case StatusBad, StatusError:
+			return &Error{Status: status, Message: string(payload)}
+		default:
+			return errMalformed
+		}
+	}
+}
+
+func writeResponse(w io.Writer, n *sasl.Negotiator, resp []byte) error {
+	status := StatusOK
+	if n.Completed() {
+		status = StatusComplete
+	}
+	return WriteMessage(w, status, resp)
+}
+
+// Conn is a framed connection to the server after a successful handshake.
+// Writes are buffered until Flush is called, which sends them as a single frame
+// as expected by Thrift framed transports.
+type Conn struct {
+	rw    io.ReadWriter
+	layer sasl.SecurityLayer
+	wbuf  []byte
+	rbuf  []byte
+}
+
+// Dial performs the handshake over rw and returns the resulting connection.
+// If the mechanism negotiated a security layer it is used to protect each
+// frame.
+func Dial(rw io.ReadWriter, n *sasl.Negotiator) (*Conn, error) {
+	if err := Handshake(rw, n); err != nil {
+		return nil, err
+	}
+	return &Conn{rw: rw, layer: n.SecurityLayer()}, nil
+}
+
+// Write buffers p until the next call to Flush.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wbuf = append(c.wbuf, p...)
+	return len(p), nil
+}
+
+// Flush sends the buffered data as a single frame.
+func (c *Conn) Flush() error {
+	frame := c.wbuf
+	c.wbuf = c.wbuf[:0]
+	if c.layer != nil {
+		if max := c.layer.MaxSendSize(); max > 0 && len(frame) > max {
+			return sasl.ErrMessageTooLarge
+		}
+		var err error
+		if frame, err = c.layer.Wrap(frame); err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, 4+len(frame))
+	binary.BigEndian.PutUint32(buf, uint32(len(frame)))
+	copy(buf[4:], frame)
+	_, err := c.rw.Write(buf)
+	return err
+}
+
+// Read reads data from the current frame, reading the next frame from the
+// server if the current one has been consumed.
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		var hdr [4]byte
+		if _, err := io.ReadFull(c.rw, hdr[:]); err != nil {
+			return 0, err
+		}
+		max := uint32(MaxFrameSize)
+		if c.layer != nil && c.layer.MaxRecvSize() > 0 {
+			max = uint32(c.layer.MaxRecvSize())
+		}
+		l := binary.BigEndian.Uint32(hdr[:])
+		if l > max {
+			return 0, sasl.ErrMessageTooLarge
+		}
+		frame := make([]byte, l)
+		if _, err := io.ReadFull(c.rw, frame); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if c.layer != nil {
+			var err error
+			if frame, err = c.layer.Unwrap(frame); err != nil {
+				return 0, err
+			}
+		}
+		c.rbuf = frame
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package thriftsasl_test
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/thriftsasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+// serve runs the server side of the handshake then echoes a single frame.
+func serve(conn net.Conn, server *sasl.Negotiator) {
+	defer conn.Close()
+	status, mech, err := thriftsasl.ReadMessage(conn)
+	if err != nil || status != thriftsasl.StatusStart || string(mech) != server.Mechanism().Name {
+		thriftsasl.WriteMessage(conn, thriftsasl.StatusBad, []byte("bad start"))
+		return
+	}
+	for {
+		_, resp, err := thriftsasl.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if !server.Completed() {
+			more, challenge, err := server.Step(resp)
+			if err != nil {
+				thriftsasl.WriteMessage(conn, thriftsasl.StatusBad, []byte("authentication failed"))
+				return
+			}
+			if more || len(challenge) > 0 {
+				thriftsasl.WriteMessage(conn, thriftsasl.StatusOK, challenge)
+				continue
+			}
+		}
+		thriftsasl.WriteMessage(conn, thriftsasl.StatusComplete, nil)
+		break
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+	io.ReadFull(conn, frame)
+	conn.Write(append(hdr[:], frame...))
+}
+
+func TestDial(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		password string
+		fail     bool
+	}{
+		{name: "plain", mech: sasl.Plain, password: "pencil"},
+		{name: "plain-bad-password", mech: sasl.Plain, password: "pen", fail: true},
+		{name: "scram", mech: sasl.ScramSha256, password: "pencil"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, password: "pen", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			go serve(serverConn, sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s)))
+
+			client := sasl.NewClient(tc.mech, sasl.Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(tc.password), nil
+			}))
+			conn, err := thriftsasl.Dial(clientConn, client)
+			if tc.fail {
+				var thriftErr *thriftsasl.Error
+				if !errors.As(err, &thriftErr) || !errors.Is(err, sasl.ErrAuthn) {
+					t.Errorf("Expected authentication error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			io.WriteString(conn, "hello, ")
+			io.WriteString(conn, "world")
+			if err = conn.Flush(); err != nil {
+				t.Fatalf("Unexpected error flushing: %v", err)
+			}
+			buf := make([]byte, 12)
+			n, err := io.ReadFull(conn, buf)
+			if err != nil {
+				t.Fatalf("Unexpected error reading: %v", err)
+			}
+			if string(buf[:n]) != "hello, world" {
+				t.Errorf("Unexpected frame: want=%q, got=%q", "hello, world", buf[:n])
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// redacted replaces secret values in recorded messages.
+const redacted = "[REDACTED]"
+
+// A Recorder records the messages sent and received by a negotiator so that
+// the exact exchange can be attached to bug reports, for example when
+// debugging interoperability problems with another implementation.
+//
+// By default values that would let someone who obtains the transcript recover
+// or replay the credentials are replaced with "[REDACTED]": the password sent
+// by PLAIN, the client proof and server signature of SCRAM, and the tokens sent
+// by OAUTHBEARER and XOAUTH2.
+// Messages of other mechanisms are not recorded at all, only their lengths.
+// Everything else, such as usernames, nonces, and salts, is kept as is.
+// Setting Unsafe records every message exactly, which must only be done with
+// throwaway credentials.
+//
+// A Recorder is safe for concurrent use, but it should only be passed to one
+// negotiator at a time.
+type Recorder struct {
+	// Unsafe disables redaction.
+	Unsafe bool
+
+	mu        sync.Mutex
+	mechanism string
+	server    bool
+	messages  []RecordedMessage
+}
+
+// RecordedMessage is a message recorded by a Recorder.
+type RecordedMessage struct {
+	// Direction is whether the message was received or sent.
+	Direction Direction
+
+	// NegotiationID is the ID of the negotiation that the message belongs to
+	// (see NegotiationID), which tells apart the negotiations in a transcript
+	// of a negotiator that was reset.
+	NegotiationID string
+
+	// Data is the message after base64 decoding, with secrets replaced if
+	// Redacted is true.
+	// It is nil if there was no message.
+	Data []byte
+
+	// Length is the length of the original message, or -1 if there was no
+	// message.
+	Length int
+
+	// Redacted reports whether Data differs from the original message.
+	Redacted bool
+
+	// Err is the error returned by Step, if any.
+	Err error
+}
+
+// Record causes the negotiator to record every message that it receives or
+// sends in r.
+func Record(r *Recorder) Option {
+	return func(n *Negotiator) {
+		n.recorder = r
+	}
+}
+
+// Messages returns the messages recorded so far.
+func (r *Recorder) Messages() []RecordedMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedMessage(nil), r.messages...)
+}
+
+// Reset discards the recorded messages.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = nil
+}
+
+// record adds a message sent or received by c.
+func (r *Recorder) record(c *Negotiator, dir Direction, msg []byte, err error) {
+	m := RecordedMessage{Direction: dir, NegotiationID: c.negotiationID, Length: -1, Err: err}
+	if msg != nil {
+		m.Length = len(msg)
+		if r.Unsafe {
+			m.Data = append([]byte{}, msg...)
+		} else {
+			m.Data, m.Redacted = redactMessage(c.mechanism.Name, msg)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mechanism = c.mechanism.Name
+	r.server = c.isServer
+	r.messages = append(r.messages, m)
+}
+
+// redactMessage returns a copy of msg with any secrets replaced and reports
+// whether anything was replaced.
+func redactMessage(mechanism string, msg []byte) ([]byte, bool) {
+	switch {
+	case strings.HasPrefix(mechanism, "SCRAM-"):
+		return redactFields(msg, ',', func(field []byte) (int, bool) {
+			if bytes.HasPrefix(field, []byte("p=")) || bytes.HasPrefix(field, []byte("v=")) {
+				return 2, true
+			}
+			return 0, false
+		})
+	case mechanism == "PLAIN":
+		// authzid NUL authcid NUL passwd
+		if i := bytes.LastIndexByte(msg, 0); i >= 0 && i < len(msg)-1 {
+			return append(append([]byte{}, msg[:i+1]...), redacted...), true
+		}
+		return append([]byte{}, msg...), false
+	case mechanism == "OAUTHBEARER" || mechanism == "XOAUTH2":
+		return redactFields(msg, 0x01, func(field []byte) (int, bool) {
+			if bytes.HasPrefix(field, []byte("auth=")) {
+				return 5, true
+			}
+			return 0, false
+		})
+	}
+	return nil, true
+}
+
+// redactFields splits msg on sep and replaces the value of every field for
+// which secret returns true, starting at the returned offset.
+func redactFields(msg []byte, sep byte, secret func([]byte) (int, bool)) ([]byte, bool) {
+	out := make([]byte, 0, len(msg))
+	var changed bool
+	for i, field := range bytes.Split(msg, []byte{sep}) {
+		if i > 0 {
+			out = append(out, sep)
+		}
+		if n, ok := secret(field); ok {
+			out = append(out, field[:n]...)
+			out = append(out, redacted...)
+			changed = true
+			continue
+		}
+		out = append(out, field...)
+	}
+	return out, changed
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build !sasl_tiny
+
+package sasl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// MarshalJSON implements json.Marshaler.
+// Messages are encoded as base64 (the default encoding for byte slices in
+// encoding/json) so that binary messages are preserved exactly.
+func (r *Recorder) MarshalJSON() ([]byte, error) {
+	type message struct {
+		Direction     string `json:"direction"`
+		NegotiationID string `json:"negotiation_id,omitempty"`
+		Data          []byte `json:"data"`
+		Length        int    `json:"length"`
+		Redacted      bool   `json:"redacted,omitempty"`
+		Err           string `json:"error,omitempty"`
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := struct {
+		Mechanism string    `json:"mechanism"`
+		Role      string    `json:"role"`
+		Unsafe    bool      `json:"unsafe,omitempty"`
+		Messages  []message `json:"messages"`
+	}{
+		Mechanism: r.mechanism,
+		Role:      "client",
+		Unsafe:    r.Unsafe,
+		Messages:  make([]message, 0, len(r.messages)),
+	}
+	if r.server {
+		out.Role = "server"
+	}
+	for _, m := range r.messages {
+		msg := message{
+			Direction:     m.Direction.String(),
+			NegotiationID: m.NegotiationID,
+			Data:          m.Data,
+			Length:        m.Length,
+			Redacted:      m.Redacted,
+		}
+		if m.Err != nil {
+			msg.Err = m.Err.Error()
+		}
+		out.Messages = append(out.Messages, msg)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler for transcripts created by
+// MarshalJSON so that they can be passed to Replay.
+// Errors are restored as errors with the same text.
+func (r *Recorder) UnmarshalJSON(b []byte) error {
+	var in struct {
+		Mechanism string `json:"mechanism"`
+		Role      string `json:"role"`
+		Unsafe    bool   `json:"unsafe"`
+		Messages  []struct {
+			Direction     string `json:"direction"`
+			NegotiationID string `json:"negotiation_id"`
+			Data          []byte `json:"data"`
+			Length        int    `json:"length"`
+			Redacted      bool   `json:"redacted"`
+			Err           string `json:"error"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	messages := make([]RecordedMessage, 0, len(in.Messages))
+	for _, m := range in.Messages {
+		msg := RecordedMessage{NegotiationID: m.NegotiationID, Data: m.Data, Length: m.Length, Redacted: m.Redacted}
+		switch m.Direction {
+		case Received.String():
+			msg.Direction = Received
+		case Sent.String():
+			msg.Direction = Sent
+		default:
+			return fmt.Errorf("Unknown message direction %q", m.Direction)
+		}
+		if m.Err != "" {
+			msg.Err = errors.New(m.Err)
+		}
+		messages = append(messages, msg)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Unsafe = in.Unsafe
+	r.mechanism = in.Mechanism
+	r.server = in.Role == "server"
+	r.messages = messages
+	return nil
+}
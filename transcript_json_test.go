@@ -0,0 +1,63 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build !sasl_tiny
+
+package sasl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+)
+
+func TestRecorderJSON(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+
+	var r Recorder
+	client := NewClient(ScramSha256, append(scramClientOpts, Record(&r))...)
+	if err := negotiate(client, NewServer(ScramSha256, acceptAll, Store(store))); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	msgs := r.Messages()
+
+	out, err := json.Marshal(&r)
+	if err != nil {
+		t.Fatalf("Error marshaling transcript: %v", err)
+	}
+	var decoded struct {
+		Mechanism string
+		Role      string
+		Messages  []struct {
+			Direction string
+			Data      []byte
+			Redacted  bool
+		}
+	}
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatalf("Error unmarshaling transcript: %v", err)
+	}
+	if decoded.Mechanism != "SCRAM-SHA-256" || decoded.Role != "client" || len(decoded.Messages) != 5 || decoded.Messages[1].Direction != "received" || !bytes.Equal(decoded.Messages[0].Data, msgs[0].Data) {
+		t.Errorf("Unexpected JSON transcript: %s", out)
+	}
+
+	// Round trip an unsafe transcript through JSON as if it had been attached to
+	// a bug report and make sure that it can still be replayed.
+	rec := &Recorder{Unsafe: true}
+	client = NewClient(ScramSha256, append(scramClientOpts, Record(rec))...)
+	if err := negotiate(client, NewServer(ScramSha256, acceptAll, Store(store))); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out, err = json.Marshal(rec); err != nil {
+		t.Fatalf("Error marshaling transcript: %v", err)
+	}
+	var loaded Recorder
+	if err := json.Unmarshal(out, &loaded); err != nil {
+		t.Fatalf("Error unmarshaling transcript: %v", err)
+	}
+	if err := Replay(NewClient(ScramSha256, scramClientOpts...), loaded.Messages()); err != nil {
+		t.Errorf("Unexpected error replaying loaded transcript: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+
+	var r Recorder
+	client := NewClient(ScramSha256, append(scramClientOpts, Record(&r))...)
+	if err := negotiate(client, NewServer(ScramSha256, acceptAll, Store(store))); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	msgs := r.Messages()
+	if len(msgs) != 5 {
+		t.Fatalf("Wrong number of messages: want=5, got=%d", len(msgs))
+	}
+	for i, want := range []struct {
+		dir      Direction
+		redacted bool
+		contains string
+	}{
+		{dir: Sent, contains: "n=user,r="},
+		{dir: Received, contains: ",s=c2FsdA==,i=4096"},
+		{dir: Sent, redacted: true, contains: ",p=[REDACTED]"},
+		{dir: Received, redacted: true, contains: "v=[REDACTED]"},
+		{dir: Sent},
+	} {
+		m := msgs[i]
+		if m.Direction != want.dir || m.Redacted != want.redacted || !bytes.Contains(m.Data, []byte(want.contains)) {
+			t.Errorf("Unexpected message %d: want=%v %t %q, got=%v %t %q", i, want.dir, want.redacted, want.contains, m.Direction, m.Redacted, m.Data)
+		}
+	}
+	if msgs[4].Data != nil || msgs[4].Length != -1 {
+		t.Errorf("Expected missing final message to be recorded as nil, got %q (%d)", msgs[4].Data, msgs[4].Length)
+	}
+}
+
+func TestRecorderRedaction(t *testing.T) {
+	for i, tc := range []struct {
+		mechanism string
+		msg       string
+		unsafe    bool
+		want      string
+		redacted  bool
+	}{
+		0: {mechanism: "PLAIN", msg: "admin\x00Kurt\x00xipj3plmq", want: "admin\x00Kurt\x00[REDACTED]", redacted: true},
+		1: {mechanism: "PLAIN", msg: "admin\x00Kurt\x00xipj3plmq", unsafe: true, want: "admin\x00Kurt\x00xipj3plmq"},
+		2: {mechanism: "OAUTHBEARER", msg: "n,a=user,\x01host=example.com\x01auth=Bearer token\x01\x01", want: "n,a=user,\x01host=example.com\x01auth=[REDACTED]\x01\x01", redacted: true},
+		3: {mechanism: "SCRAM-SHA-1", msg: "e=invalid-proof", want: "e=invalid-proof"},
+		4: {mechanism: "GSSAPI", msg: "opaque", redacted: true},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			r := &Recorder{Unsafe: tc.unsafe}
+			n := &Negotiator{mechanism: Mechanism{Name: tc.mechanism}}
+			r.record(n, Sent, []byte(tc.msg), nil)
+			m := r.Messages()[0]
+			if string(m.Data) != tc.want || m.Redacted != tc.redacted || m.Length != len(tc.msg) {
+				t.Errorf("Unexpected message: want=%q (%t), got=%q (%t, %d)", tc.want, tc.redacted, m.Data, m.Redacted, m.Length)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+// TransitionError is returned when a negotiator receives a message that is not
+// allowed at the current point of the negotiation, such as a SCRAM
+// client-final message before the client-first message or a challenge sent to
+// a client that has nothing more to receive.
+// It is reported before the mechanism tries to parse the message, so the peer
+// can be identified as sending messages out of order instead of sending a
+// malformed message.
+//
+// TransitionError wraps ErrInvalidChallenge.
+type TransitionError struct {
+	// Mechanism is the name of the negotiator's mechanism.
+	Mechanism string
+
+	// Got is the kind of message that was received, for example
+	// "client-final-message" or "challenge".
+	Got string
+
+	// Want is the kind of message that was expected, or the empty string if no
+	// message was allowed.
+	Want string
+}
+
+func (e TransitionError) Error() string {
+	if e.Want == "" {
+		return "Mechanism " + e.Mechanism + " received " + e.Got + " when no message was expected"
+	}
+	return "Mechanism " + e.Mechanism + " received " + e.Got + " when " + e.Want + " was expected"
+}
+
+// Unwrap returns ErrInvalidChallenge.
+func (TransitionError) Unwrap() error {
+	return ErrInvalidChallenge
+}
+
+// Code returns CodeInvalidTransition.
+func (TransitionError) Code() Code {
+	return CodeInvalidTransition
+}
+
+// Kinds of SCRAM messages reported in TransitionErrors.
+const (
+	kindClientFirst = "client-first-message"
+	kindServerFirst = "server-first-message"
+	kindClientFinal = "client-final-message"
+	kindServerFinal = "server-final-message"
+)
+
+// scramMessageKind guesses the kind of a SCRAM message from its first
+// attribute, or returns the empty string if it is not recognized.
+func scramMessageKind(msg []byte) string {
+	if len(msg) < 2 {
+		return ""
+	}
+	switch {
+	case msg[0] == 'n' || msg[0] == 'y':
+		// The channel binding flag of the gs2-header (or the username if the
+		// gs2-header is missing).
+		return kindClientFirst
+	case msg[0] == 'p' && msg[1] == '=':
+		return kindClientFirst
+	case msg[0] == 'c' && msg[1] == '=':
+		return kindClientFinal
+	case (msg[0] == 'r' || msg[0] == 'm') && msg[1] == '=':
+		return kindServerFirst
+	case (msg[0] == 'v' || msg[0] == 'e') && msg[1] == '=':
+		return kindServerFinal
+	}
+	return ""
+}
+
+// checkScramOrder returns a TransitionError if msg is recognizably a different
+// kind of SCRAM message than want.
+func checkScramOrder(m *Negotiator, msg []byte, want string) error {
+	if got := scramMessageKind(msg); got != "" && got != want {
+		return TransitionError{Mechanism: m.mechanism.Name, Got: got, Want: want}
+	}
+	return nil
+}
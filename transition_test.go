@@ -0,0 +1,84 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestTransitionError(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	newServer := func() *Negotiator { return NewServer(ScramSha256, acceptAll, Store(store)) }
+
+	_, clientFirst, _ := NewClient(ScramSha256, scramClientOpts...).Step(nil)
+
+	for _, tc := range []struct {
+		name  string
+		steps func() error
+		want  TransitionError
+	}{
+		{
+			name: "server got client-final first",
+			steps: func() error {
+				_, _, err := newServer().Step([]byte("c=biws,r=abc,p=AAAA"))
+				return err
+			},
+			want: TransitionError{Mechanism: "SCRAM-SHA-256", Got: kindClientFinal, Want: kindClientFirst},
+		},
+		{
+			name: "server got client-first twice",
+			steps: func() error {
+				server := newServer()
+				if _, _, err := server.Step(clientFirst); err != nil {
+					return err
+				}
+				_, _, err := server.Step(clientFirst)
+				return err
+			},
+			want: TransitionError{Mechanism: "SCRAM-SHA-256", Got: kindClientFirst, Want: kindClientFinal},
+		},
+		{
+			name: "client got server-final first",
+			steps: func() error {
+				client := NewClient(ScramSha256, scramClientOpts...)
+				client.Step(nil)
+				_, _, err := client.Step([]byte("v=AAAA"))
+				return err
+			},
+			want: TransitionError{Mechanism: "SCRAM-SHA-256", Got: kindServerFinal, Want: kindServerFirst},
+		},
+		{
+			name: "client got server-first twice",
+			steps: func() error {
+				client := NewClient(ScramSha256, scramClientOpts...)
+				server := newServer()
+				_, resp, _ := client.Step(nil)
+				_, serverFirst, err := server.Step(resp)
+				if err != nil {
+					return err
+				}
+				if _, _, err = client.Step(serverFirst); err != nil {
+					return err
+				}
+				_, _, err = client.Step(serverFirst)
+				return err
+			},
+			want: TransitionError{Mechanism: "SCRAM-SHA-256", Got: kindServerFirst, Want: kindServerFinal},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.steps()
+			var got TransitionError
+			if !errors.As(err, &got) || got != tc.want {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.want, err)
+			}
+			if !errors.Is(err, ErrInvalidChallenge) || ErrorCode(err) != CodeInvalidTransition {
+				t.Errorf("Expected error to wrap ErrInvalidChallenge with code %q", CodeInvalidTransition)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"sync"
+)
+
+// fakeSaltLen is the length of the salts generated for unknown users.
+const fakeSaltLen = 16
+
+// FakeUnknownUsers changes how SCRAM servers respond when the credential store
+// returns ErrUnknownUser.
+// By default the first step fails with ErrUnknownUser, which lets the protocol
+// report that the user does not exist (the unknown-user error of RFC 5802).
+// With FakeUnknownUsers the server instead continues the negotiation with a
+// salt derived from the username using key and the iteration count iter
+// (DefaultMinIterations if iter is zero or less) and fails with ErrAuthn when
+// it checks the client proof, so that clients cannot tell whether a user exists
+// from the messages sent by the server.
+//
+// The same username always gets the same salt as long as key does not change,
+// so key must be kept secret and should be the same on every server that
+// shares a credential store.
+// Iter should match the iteration count used for most real users.
+// The permissions callback is never called for unknown users.
+func FakeUnknownUsers(key []byte, iter int) Option {
+	return func(n *Negotiator) {
+		n.fakeUserKey = key
+		n.fakeUserIter = iter
+	}
+}
+
+// processFakeKey returns a random key that is used to fake the credentials of
+// unknown users for the FailureGeneric policy if FakeUnknownUsers was not used.
+var processFakeKey = sync.OnceValue(func() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(entropy, key); err != nil {
+		panic(err)
+	}
+	return key
+})
+
+// fakeCredentials returns deterministic credentials for an unknown user that
+// no client proof will match.
+func (c *Negotiator) fakeCredentials(fn func() hash.Hash, name string, username []byte) StoredCredentials {
+	key := c.fakeUserKey
+	if key == nil {
+		key = processFakeKey()
+	}
+	mac := hmac.New(sha256.New, key)
+	derive := func(label string, size int) []byte {
+		mac.Reset()
+		mac.Write([]byte(label))
+		mac.Write([]byte{0})
+		mac.Write(username)
+		var out []byte
+		for len(out) < size {
+			out = mac.Sum(out)
+			mac.Write(out[len(out)-sha256.Size:])
+		}
+		return out[:size]
+	}
+	iter := c.fakeUserIter
+	if iter <= 0 {
+		iter = DefaultMinIterations
+	}
+	size := fn().Size()
+	return StoredCredentials{
+		Salt:       derive("salt", fakeSaltLen),
+		Iterations: iter,
+		StoredKey:  derive(name+" stored key", size),
+		ServerKey:  derive(name+" server key", size),
+	}
+}
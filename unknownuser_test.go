@@ -0,0 +1,61 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestFakeUnknownUsers(t *testing.T) {
+	store := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	creds := func(username string) Option {
+		return Credentials(func() ([]byte, []byte, []byte) {
+			return []byte(username), []byte("pencil"), nil
+		})
+	}
+
+	server := NewServer(ScramSha256, acceptAll, Store(store))
+	if err := negotiate(NewClient(ScramSha256, creds("nobody")), server); err != ErrUnknownUser {
+		t.Errorf("Expected ErrUnknownUser by default, got %v", err)
+	}
+
+	key := FakeUnknownUsers([]byte("secret"), 8192)
+	serverFirst := func(username string) []byte {
+		client := NewClient(ScramSha512, creds(username))
+		server := NewServer(ScramSha512, acceptAll, Store(store), key)
+		_, resp, _ := client.Step(nil)
+		_, challenge, err := server.Step(resp)
+		if err != nil {
+			t.Fatalf("Unexpected error for unknown user: %v", err)
+		}
+		_, salt, iter, _ := parseServerFirst(challenge)
+		if iter != 8192 {
+			t.Errorf("Unexpected iteration count: want=8192, got=%d", iter)
+		}
+		return salt
+	}
+	if salt := serverFirst("nobody"); !bytes.Equal(salt, serverFirst("nobody")) || bytes.Equal(salt, serverFirst("somebody")) {
+		t.Error("Expected salt to be derived from the username")
+	}
+
+	var called bool
+	server = NewServer(ScramSha256, func(*Negotiator) bool {
+		called = true
+		return true
+	}, Store(store), key)
+	if err := negotiate(NewClient(ScramSha256, creds("nobody")), server); err != ErrAuthn {
+		t.Errorf("Expected ErrAuthn for unknown user, got %v", err)
+	}
+	if called {
+		t.Error("Permissions was called for an unknown user")
+	}
+
+	server = NewServer(ScramSha256, acceptAll, Store(store), key)
+	if err := negotiate(NewClient(ScramSha256, creds("user")), server); err != nil {
+		t.Errorf("Unexpected error for known user: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// validationInputs are the malformed messages that Validate sends to clients
+// and servers.
+var validationInputs = [][]byte{
+	[]byte("\xff\xfe\xfd"),
+	[]byte(",,,,,,,,"),
+	[]byte("=\x00=\x00="),
+	[]byte("a=b,c=d,e=f,g=h,i=j,k=l,m=n"),
+	[]byte(strings.Repeat("A", 4096)),
+}
+
+// ValidationProblem is a violation of a generic invariant found by Validate.
+type ValidationProblem struct {
+	// Check is the name of the check that failed, such as "server malformed".
+	Check string
+
+	// Detail describes what went wrong.
+	Detail string
+}
+
+func (p ValidationProblem) String() string {
+	return p.Check + ": " + p.Detail
+}
+
+// ValidationReport is the result of Validate.
+type ValidationReport struct {
+	Mechanism string
+	Problems  []ValidationProblem
+}
+
+// OK reports whether no problems were found.
+func (r ValidationReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Err returns an error describing all of the problems, or nil if there were
+// none.
+func (r ValidationReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	msgs := make([]string, 0, len(r.Problems))
+	for _, p := range r.Problems {
+		msgs = append(msgs, p.String())
+	}
+	return fmt.Errorf("sasl: mechanism %q failed validation: %s", r.Mechanism, strings.Join(msgs, "; "))
+}
+
+func (r *ValidationReport) add(check, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, ValidationProblem{Check: check, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Validate exercises m against invariants that every mechanism must hold and
+// returns a report of any violations, for example so that applications can
+// check third party mechanisms when they are registered:
+//
+//	if err := sasl.Validate(m).Err(); err != nil {
+//		panic(err)
+//	}
+//
+// It checks that the name is valid, that clients and servers never panic when
+// given empty or malformed messages, that servers never report success for
+// malformed messages, that mechanisms which declare ServerFirst send no
+// initial response, and that once a step reports that no more messages are
+// expected further messages are rejected.
+//
+// The negotiators are created with dummy credentials, a credential store that
+// knows no users, a permissions callback that denies everyone, and a completed
+// TLS connection; opts are applied after these defaults, for example to
+// provide credentials that the mechanism needs to start.
+// Validate does not check that a successful negotiation is possible, which is
+// tested by the sasltest package.
+func Validate(m Mechanism, opts ...Option) ValidationReport {
+	r := ValidationReport{Mechanism: m.Name}
+	if !ValidMechanismName(m.Name) {
+		r.add("name", "%q is not a valid mechanism name", m.Name)
+	}
+	if m.Start == nil || m.Next == nil {
+		r.add("functions", "Start and Next must both be set")
+		return r
+	}
+
+	defaults := []Option{
+		Credentials(func() ([]byte, []byte, []byte) {
+			return []byte("user"), []byte("pencil"), nil
+		}),
+		Store(validationStore{}),
+		TLSState(tls.ConnectionState{HandshakeComplete: true, TLSUnique: make([]byte, 12)}),
+		RemoteMechanisms(m.Name),
+	}
+	opts = append(defaults[:len(defaults):len(defaults)], opts...)
+	denyAll := func(*Negotiator) bool { return false }
+	newClient := func() *Negotiator { return NewClient(m, opts...) }
+	newServer := func() *Negotiator { return NewServer(m, denyAll, opts...) }
+
+	// Client start and the more=false contract.
+	var started bool
+	r.guard("client start", func() {
+		client := newClient()
+		more, resp, err := client.Step(nil)
+		if err != nil {
+			return
+		}
+		started = true
+		if m.Capabilities.ServerFirst && resp != nil {
+			r.add("client start", "mechanism declares ServerFirst but sent an initial response")
+		}
+		if !more {
+			r.checkFinished("client finished", client)
+		}
+	})
+
+	// Clients given empty and malformed challenges.
+	if started {
+		for _, in := range append([][]byte{nil, {}}, validationInputs...) {
+			r.guard("client malformed", func() {
+				client := newClient()
+				more, _, err := client.Step(nil)
+				if err != nil || !more {
+					return
+				}
+				client.Step(in)
+			})
+		}
+	}
+
+	// Servers given no, empty, and malformed initial responses.
+	for _, in := range append([][]byte{nil, {}}, validationInputs...) {
+		r.guard("server malformed", func() {
+			server := newServer()
+			for i := 0; i < 4; i++ {
+				more, _, err := server.Step(in)
+				switch {
+				case err != nil:
+					return
+				case !more:
+					r.add("server malformed", "server reported success for %s even though permission was denied", describeInput(in))
+					return
+				}
+				// Keep sending the same message in case the mechanism only parses it
+				// in a later step.
+			}
+		})
+	}
+	return r
+}
+
+// checkFinished checks that a negotiator whose last step returned more=false
+// rejects another message.
+func (r *ValidationReport) checkFinished(check string, n *Negotiator) {
+	if _, _, err := n.Step([]byte("unexpected")); err == nil {
+		r.add(check, "mechanism accepted a message after reporting that it was done")
+	}
+}
+
+// guard runs f and records a problem if it panics.
+func (r *ValidationReport) guard(check string, f func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			r.add(check, "panicked: %v", p)
+		}
+	}()
+	f()
+}
+
+func describeInput(in []byte) string {
+	switch {
+	case in == nil:
+		return "no initial response"
+	case len(in) == 0:
+		return "an empty response"
+	case len(in) > 32:
+		return fmt.Sprintf("a %d byte response", len(in))
+	}
+	return fmt.Sprintf("the response %q", in)
+}
+
+// validationStore is the credential store used by Validate, which knows no
+// users.
+type validationStore struct{}
+
+func (validationStore) ScramCredentials(string, []byte) (StoredCredentials, error) {
+	return StoredCredentials{}, ErrUnknownUser
+}
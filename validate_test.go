@@ -0,0 +1,52 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"testing"
+)
+
+func TestValidateBuiltin(t *testing.T) {
+	for _, m := range []Mechanism{Plain, Anonymous, ScramSha1, ScramSha1Plus, ScramSha256, ScramSha256Plus, ScramSha512, ScramSha512Plus} {
+		t.Run(m.Name, func(t *testing.T) {
+			if err := Validate(m).Err(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := Mechanism{
+		Name:         "bad name",
+		Capabilities: Capabilities{ServerFirst: true},
+		Start: func(*Negotiator) (bool, []byte, interface{}, error) {
+			return false, []byte{}, nil, nil
+		},
+		Next: func(n *Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+			if len(challenge) > 0 && challenge[0] == 0xff {
+				panic("unexpected input")
+			}
+			return false, nil, nil, nil
+		},
+	}
+	r := Validate(bad)
+	checks := make(map[string]bool)
+	for _, p := range r.Problems {
+		checks[p.Check] = true
+	}
+	for _, want := range []string{"name", "client start", "client finished", "server malformed"} {
+		if !checks[want] {
+			t.Errorf("Expected %q problem, got %v", want, r.Problems)
+		}
+	}
+	if r.OK() || r.Err() == nil {
+		t.Errorf("Expected report to fail")
+	}
+
+	if r := Validate(Mechanism{Name: "EMPTY"}); len(r.Problems) != 1 || r.Problems[0].Check != "functions" {
+		t.Errorf("Expected missing functions to be reported, got %v", r.Problems)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"hash"
+)
+
+// A ScramVerifier checks SCRAM client proofs and computes server signatures on
+// behalf of a server so that the StoredKey and ServerKey of users never have to
+// be loaded into the server process, for example because they are kept in an
+// HSM or by a remote authentication service.
+// When a verifier is used the credential store only needs to return the salt
+// and iteration count of each user.
+//
+// The auth message is the one defined in RFC 5802 §3.
+// VerifyScramProof and ScramServerSignature can be used to implement the
+// methods wherever the keys are kept.
+type ScramVerifier interface {
+	// VerifyProof returns nil if proof is the valid client proof for username
+	// and authMessage, ErrAuthn if it is not, or any other error if it could not
+	// be checked.
+	VerifyProof(ctx context.Context, mechanism string, username, authMessage, proof []byte) error
+
+	// ServerSignature returns the server signature for username and
+	// authMessage.
+	// It is only called after the client proof has been verified.
+	ServerSignature(ctx context.Context, mechanism string, username, authMessage []byte) ([]byte, error)
+}
+
+// Verifier makes SCRAM servers use v to check client proofs and compute server
+// signatures instead of the StoredKey and ServerKey returned by the credential
+// store.
+// The context passed to v is the one returned by the Context method.
+func Verifier(v ScramVerifier) Option {
+	return func(n *Negotiator) {
+		n.verifier = v
+	}
+}
+
+// VerifyScramProof reports whether proof is the valid client proof for
+// authMessage given the StoredKey of a user, using the hash function h of the
+// SCRAM mechanism (eg. sha256.New for SCRAM-SHA-256).
+func VerifyScramProof(h func() hash.Hash, storedKey, authMessage, proof []byte) bool {
+	return verifyScramProof(newScramHash(h, fipsBuild), storedKey, authMessage, proof)
+}
+
+// ScramServerSignature returns the server signature for authMessage given the
+// ServerKey of a user, using the hash function h of the SCRAM mechanism.
+func ScramServerSignature(h func() hash.Hash, serverKey, authMessage []byte) []byte {
+	return newScramHash(h, fipsBuild).mac(nil, serverKey, authMessage)
+}
+
+func verifyScramProof(hs *scramHash, storedKey, authMessage, proof []byte) bool {
+	clientSignature := hs.mac(nil, storedKey, authMessage)
+	if len(proof) != len(clientSignature) {
+		return false
+	}
+	clientKey := make([]byte, len(proof))
+	xorBytes(clientKey, proof, clientSignature)
+	return ConstantTimeEqual(hs.digest(nil, clientKey), storedKey)
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// hsm is a ScramVerifier that keeps the keys away from the credential store.
+type hsm struct {
+	keys mapStore
+	err  error
+}
+
+func (v hsm) VerifyProof(_ context.Context, _ string, username, authMessage, proof []byte) error {
+	if v.err != nil {
+		return v.err
+	}
+	if !VerifyScramProof(sha256.New, v.keys[string(username)].StoredKey, authMessage, proof) {
+		return ErrAuthn
+	}
+	return nil
+}
+
+func (v hsm) ServerSignature(_ context.Context, _ string, username, authMessage []byte) ([]byte, error) {
+	return ScramServerSignature(sha256.New, v.keys[string(username)].ServerKey, authMessage), nil
+}
+
+func TestVerifier(t *testing.T) {
+	keys := mapStore{"user": DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	// The store only has the salt and iteration count.
+	store := mapStore{"user": {Salt: keys["user"].Salt, Iterations: keys["user"].Iterations}}
+	errHSM := errors.New("hsm unavailable")
+
+	for _, tc := range []struct {
+		name     string
+		password string
+		err      error
+	}{
+		{name: "valid", password: "pencil"},
+		{name: "invalid", password: "pen", err: ErrAuthn},
+		{name: "error", password: "pencil", err: errHSM},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var verr error
+			if tc.err == errHSM {
+				verr = errHSM
+			}
+			client := NewClient(ScramSha256, Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(tc.password), nil
+			}))
+			server := NewServer(ScramSha256, acceptAll, Store(store), Verifier(hsm{keys: keys, err: verr}))
+			if err := negotiate(client, server); err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if tc.err == nil && !client.VerifiedServer() {
+				t.Error("Client did not verify the server signature")
+			}
+		})
+	}
+}
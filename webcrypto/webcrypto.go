@@ -0,0 +1,69 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package webcrypto offloads the PBKDF2 calculation performed by SCRAM clients
+// to the SubtleCrypto API of the browser (or other JavaScript host) when
+// compiled for js/wasm, where the pure Go implementation can take several
+// seconds at common iteration counts.
+//
+// Key can be used with the sasl.KDF option on every platform:
+//
+//	client := sasl.NewClient(sasl.ScramSha256, sasl.KDF(webcrypto.Key), …)
+//
+// On other platforms, or if SubtleCrypto is not available or does not support
+// the hash, Key uses the pure Go implementation.
+//
+// On js/wasm Key blocks until the promise returned by SubtleCrypto resolves, so
+// negotiations must not be stepped from inside a callback registered with
+// syscall/js (which would block the event loop and deadlock) but from a
+// separate goroutine.
+package webcrypto // import "github.com/jh125486/sasl/webcrypto"
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"reflect"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+type hashKey struct {
+	typ  reflect.Type
+	size int
+}
+
+// hashNames maps the hashes that SubtleCrypto supports for PBKDF2 to their
+// names.
+// SHA-384 and SHA-512 share a type, so the size is part of the key.
+var hashNames = map[hashKey]string{}
+
+func init() {
+	for name, h := range map[string]func() hash.Hash{
+		"SHA-1":   sha1.New,
+		"SHA-256": sha256.New,
+		"SHA-384": sha512.New384,
+		"SHA-512": sha512.New,
+	} {
+		hashNames[keyOf(h)] = name
+	}
+}
+
+func keyOf(h func() hash.Hash) hashKey {
+	d := h()
+	return hashKey{typ: reflect.TypeOf(d), size: d.Size()}
+}
+
+// Key derives a key from password and salt using PBKDF2 with iter iterations
+// and the hash h.
+// It has the same signature and returns the same result as pbkdf2.Key.
+func Key(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
+	if name, ok := hashNames[keyOf(h)]; ok && iter > 0 && keyLen > 0 {
+		if key, err := subtleKey(password, salt, iter, keyLen, name); err == nil {
+			return key
+		}
+	}
+	return pbkdf2.Key(password, salt, iter, keyLen, h)
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package webcrypto
+
+import (
+	"errors"
+	"syscall/js"
+)
+
+var errUnavailable = errors.New("webcrypto: SubtleCrypto is not available")
+
+// Available reports whether Key can use SubtleCrypto.
+// SubtleCrypto is only available in secure contexts, so it is missing from
+// pages that are not served over HTTPS.
+func Available() bool {
+	return !subtle().IsUndefined()
+}
+
+func subtle() js.Value {
+	c := js.Global().Get("crypto")
+	if c.IsUndefined() || c.IsNull() {
+		return js.Undefined()
+	}
+	return c.Get("subtle")
+}
+
+func subtleKey(password, salt []byte, iter, keyLen int, hashName string) ([]byte, error) {
+	s := subtle()
+	if s.IsUndefined() || s.IsNull() {
+		return nil, errUnavailable
+	}
+	pw := uint8Array(password)
+	// Don't leave a copy of the password in the JavaScript heap.
+	defer pw.Call("fill", 0)
+	key, err := await(s.Call("importKey", "raw", pw, "PBKDF2", false, []interface{}{"deriveBits"}))
+	if err != nil {
+		return nil, err
+	}
+	bits, err := await(s.Call("deriveBits", map[string]interface{}{
+		"name":       "PBKDF2",
+		"salt":       uint8Array(salt),
+		"iterations": iter,
+		"hash":       hashName,
+	}, key, keyLen*8))
+	if err != nil {
+		return nil, err
+	}
+	arr := js.Global().Get("Uint8Array").New(bits)
+	defer arr.Call("fill", 0)
+	out := make([]byte, keyLen)
+	if js.CopyBytesToGo(out, arr) != keyLen {
+		return nil, errors.New("webcrypto: deriveBits returned a short key")
+	}
+	return out, nil
+}
+
+func uint8Array(b []byte) js.Value {
+	arr := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(arr, b)
+	return arr
+}
+
+// await blocks until the promise p settles and returns its value or an error
+// if it was rejected.
+func await(p js.Value) (js.Value, error) {
+	var (
+		v    js.Value
+		err  error
+		done = make(chan struct{})
+	)
+	resolve := js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+		v = args[0]
+		close(done)
+		return nil
+	})
+	defer resolve.Release()
+	reject := js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+		err = errors.New("webcrypto: " + args[0].Call("toString").String())
+		close(done)
+		return nil
+	})
+	defer reject.Release()
+	p.Call("then", resolve, reject)
+	<-done
+	return v, err
+}
@@ -0,0 +1,23 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+//go:build !js
+
+package webcrypto
+
+import (
+	"errors"
+)
+
+var errUnavailable = errors.New("webcrypto: SubtleCrypto is only available on js/wasm")
+
+// Available reports whether Key can use SubtleCrypto, which is never the case
+// on this platform.
+func Available() bool {
+	return false
+}
+
+func subtleKey([]byte, []byte, int, int, string) ([]byte, error) {
+	return nil, errUnavailable
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package webcrypto_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/jh125486/sasl/webcrypto"
+)
+
+func TestKey(t *testing.T) {
+	t.Logf("SubtleCrypto available: %t", webcrypto.Available())
+	for _, tc := range []struct {
+		name string
+		h    func() hash.Hash
+	}{
+		{name: "SHA-1", h: sha1.New},
+		{name: "SHA-256", h: sha256.New},
+		{name: "SHA-384", h: sha512.New384},
+		{name: "SHA-512", h: sha512.New},
+		// Not supported by SubtleCrypto, so always computed in Go.
+		{name: "MD5", h: md5.New},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			password, salt := []byte("pencil"), []byte("QSXCR+Q6sek8bf92")
+			want := pbkdf2.Key(password, salt, 4096, tc.h().Size(), tc.h)
+			got := webcrypto.Key(password, salt, 4096, tc.h().Size(), tc.h)
+			if !bytes.Equal(got, want) {
+				t.Errorf("Wrong key: want=%x, got=%x", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package xmppsasl implements the client side of Extensible SASL Profile
+// (XEP-0388, also known as SASL2) for negotiators from the sasl package.
+//
+// After the mechanism finishes the server may ask the client to continue with
+// one or more tasks (for example, a second factor or a token exchange).
+// Tasks are implemented as sasl.Mechanism values and driven by their own
+// negotiators so that any task can be plugged in without changes to this
+// package.
+// Reading and writing the XML stream is left to the caller.
+package xmppsasl
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+
+	"github.com/jh125486/sasl"
+)
+
+// NS is the namespace used by SASL2 elements.
+const NS = "urn:xmpp:sasl:2"
+
+// Errors returned by the client.
+var (
+	ErrUnexpectedElement = errors.New("xmppsasl: unexpected element")
+	ErrNoTask            = errors.New("xmppsasl: server requested tasks that are not supported")
+)
+
+// Error is returned when the server sends a failure element.
+type Error struct {
+	// Condition is the local name of the defined condition, for example
+	// "not-authorized".
+	Condition string
+
+	// Text is the optional human readable text sent by the server.
+	Text string
+}
+
+func (e *Error) Error() string {
+	if e.Text != "" {
+		return "xmppsasl: authentication failed: " + e.Condition + ": " + e.Text
+	}
+	return "xmppsasl: authentication failed: " + e.Condition
+}
+
+// Unwrap returns sasl.ErrAuthn so that all failures can be detected with
+// errors.Is.
+func (e *Error) Unwrap() error {
+	return sasl.ErrAuthn
+}
+
+// Temporary reports whether the condition is temporary-auth-failure, meaning
+// that authentication may succeed if it is retried later.
+func (e *Error) Temporary() bool {
+	return e.Condition == "temporary-auth-failure"
+}
+
+// Data is a SASL message that is base64 encoded when marshaled.
+// An empty message is encoded as "=".
+type Data []byte
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Data) MarshalText() ([]byte, error) {
+	if len(d) == 0 {
+		return []byte("="), nil
+	}
+	b := make([]byte, base64.StdEncoding.EncodedLen(len(d)))
+	base64.StdEncoding.Encode(b, d)
+	return b, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Data) UnmarshalText(b []byte) error {
+	if len(b) == 0 || string(b) == "=" {
+		*d = Data{}
+		return nil
+	}
+	out := make([]byte, base64.StdEncoding.DecodedLen(len(b)))
+	n, err := base64.StdEncoding.Decode(out, b)
+	if err != nil {
+		return err
+	}
+	*d = out[:n]
+	return nil
+}
+
+// UserAgent identifies the client software and device.
+type UserAgent struct {
+	ID       string `xml:"id,attr,omitempty"`
+	Software string `xml:"software,omitempty"`
+	Device   string `xml:"device,omitempty"`
+}
+
+// Authenticate is the element that starts the exchange.
+type Authenticate struct {
+	XMLName         xml.Name   `xml:"urn:xmpp:sasl:2 authenticate"`
+	Mechanism       string     `xml:"mechanism,attr"`
+	InitialResponse *Data      `xml:"initial-response,omitempty"`
+	UserAgent       *UserAgent `xml:"user-agent,omitempty"`
+}
+
+// Challenge carries a challenge from the server.
+type Challenge struct {
+	XMLName xml.Name `xml:"urn:xmpp:sasl:2 challenge"`
+	Data    Data     `xml:",chardata"`
+}
+
+// Response carries a response from the client.
+type Response struct {
+	XMLName xml.Name `xml:"urn:xmpp:sasl:2 response"`
+	Data    Data     `xml:",chardata"`
+}
+
+// Success is sent by the server when authentication and all tasks have
+// completed.
+// AdditionalData holds the final server message of the mechanism or task, if
+// any (for example, the SCRAM server signature).
+type Success struct {
+	XMLName                 xml.Name `xml:"urn:xmpp:sasl:2 success"`
+	AdditionalData          Data     `xml:"additional-data,omitempty"`
+	AuthorizationIdentifier string   `xml:"authorization-identifier"`
+}
+
+// Failure is sent by the server when authentication fails.
+type Failure struct {
+	XMLName   xml.Name `xml:"urn:xmpp:sasl:2 failure"`
+	Condition struct {
+		XMLName xml.Name
+	} `xml:",any"`
+	Text string `xml:"text,omitempty"`
+}
+
+// Continue is sent by the server when the mechanism succeeded but the client
+// must complete one of the listed tasks before authentication succeeds.
+type Continue struct {
+	XMLName        xml.Name `xml:"urn:xmpp:sasl:2 continue"`
+	AdditionalData Data     `xml:"additional-data,omitempty"`
+	Tasks          []string `xml:"tasks>task"`
+	Text           string   `xml:"text,omitempty"`
+}
+
+// Next selects the task to run after a Continue element.
+// If the task has an initial response it is sent base64 encoded as the
+// element's character data.
+type Next struct {
+	XMLName xml.Name `xml:"urn:xmpp:sasl:2 next"`
+	Task    string   `xml:"task,attr"`
+	Data    string   `xml:",chardata"`
+}
+
+// TaskData carries a task challenge or response.
+type TaskData struct {
+	XMLName xml.Name `xml:"urn:xmpp:sasl:2 task-data"`
+	Data    Data     `xml:",chardata"`
+}
+
+// Client drives a client negotiator and any tasks requested by the server.
+type Client struct {
+	n       *sasl.Negotiator
+	tasks   []*sasl.Negotiator
+	current *sasl.Negotiator
+	success *Success
+}
+
+// NewClient returns a client that authenticates using the client negotiator n.
+// If the server asks the client to continue, the first offered task with the
+// same name as the mechanism of one of the task negotiators is run.
+func NewClient(n *sasl.Negotiator, tasks ...*sasl.Negotiator) *Client {
+	return &Client{n: n, tasks: tasks, current: n}
+}
+
+// Authenticate returns the element that starts the exchange.
+// The user agent is optional.
+func (c *Client) Authenticate(ua *UserAgent) (*Authenticate, error) {
+	_, resp, err := c.n.Step(nil)
+	if err != nil {
+		return nil, err
+	}
+	a := &Authenticate{
+		Mechanism: c.n.Mechanism().Name,
+		UserAgent: ua,
+	}
+	// A nil response means the mechanism has no initial response, which is
+	// different from an empty one.
+	if resp != nil {
+		ir := Data(resp)
+		a.InitialResponse = &ir
+	}
+	return a, nil
+}
+
+// Handle processes an element received from the server and returns the element
+// to send in reply, if any.
+// The element must be one of *Challenge, *Continue, *TaskData, *Success, or
+// *Failure.
+// Once done is true the exchange has finished successfully.
+func (c *Client) Handle(el interface{}) (reply interface{}, done bool, err error) {
+	switch el := el.(type) {
+	case *Challenge:
+		if c.current != c.n {
+			return nil, false, ErrUnexpectedElement
+		}
+		_, resp, err := c.n.Step(el.Data)
+		if err != nil {
+			return nil, false, err
+		}
+		return &Response{Data: resp}, false, nil
+	case *TaskData:
+		if c.current == c.n {
+			return nil, false, ErrUnexpectedElement
+		}
+		_, resp, err := c.current.Step(el.Data)
+		if err != nil {
+			return nil, false, err
+		}
+		return &TaskData{Data: resp}, false, nil
+	case *Continue:
+		if err := c.current.Finish(el.AdditionalData); err != nil {
+			return nil, false, err
+		}
+		return c.next(el.Tasks)
+	case *Success:
+		if err := c.current.Finish(el.AdditionalData); err != nil {
+			return nil, false, err
+		}
+		c.success = el
+		return nil, true, nil
+	case *Failure:
+		return nil, false, &Error{Condition: el.Condition.XMLName.Local, Text: el.Text}
+	}
+	return nil, false, ErrUnexpectedElement
+}
+
+// next starts the first supported task.
+func (c *Client) next(offered []string) (interface{}, bool, error) {
+	for _, name := range offered {
+		for _, t := range c.tasks {
+			if t.Mechanism().Name != name {
+				continue
+			}
+			c.current = t
+			_, resp, err := t.Step(nil)
+			if err != nil {
+				return nil, false, err
+			}
+			next := &Next{Task: name}
+			if len(resp) > 0 {
+				next.Data = base64.StdEncoding.EncodeToString(resp)
+			}
+			return next, false, nil
+		}
+	}
+	return nil, false, ErrNoTask
+}
+
+// Success returns the success element received from the server, or nil if the
+// exchange has not completed.
+// It can be used to access the additional data and the authorization
+// identifier.
+func (c *Client) Success() *Success {
+	return c.success
+}
@@ -0,0 +1,224 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package xmppsasl_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"testing"
+
+	"github.com/jh125486/sasl"
+	"github.com/jh125486/sasl/xmppsasl"
+)
+
+type store map[string]sasl.StoredCredentials
+
+func (s store) ScramCredentials(_ string, username []byte) (sasl.StoredCredentials, error) {
+	creds, ok := s[string(username)]
+	if !ok {
+		return creds, sasl.ErrUnknownUser
+	}
+	return creds, nil
+}
+
+// otp is a task that sends a fixed one time password.
+var otp = sasl.Mechanism{
+	Name: "X-OTP",
+	Start: func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+		_, pass, _ := n.Credentials()
+		return false, pass, nil, nil
+	},
+	Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		if n.State()&sasl.Receiving == sasl.Receiving {
+			if string(challenge) != "123456" {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, nil, nil, nil
+		}
+		return false, nil, nil, sasl.ErrTooManySteps
+	},
+}
+
+// roundTrip marshals v and unmarshals it into a new value of the same type so
+// that the wire format is exercised.
+func roundTrip[T any](t *testing.T, v *T) *T {
+	t.Helper()
+	b, err := xml.Marshal(v)
+	if err != nil {
+		t.Fatalf("Error marshaling %T: %v", v, err)
+	}
+	out := new(T)
+	if err = xml.Unmarshal(b, out); err != nil {
+		t.Fatalf("Error unmarshaling %s: %v", b, err)
+	}
+	return out
+}
+
+// serve responds to a client message as a SASL2 server would, running the
+// task once the mechanism has finished if task is not nil.
+func serve(t *testing.T, server, task *sasl.Negotiator, msg []byte) interface{} {
+	t.Helper()
+	n := server
+	if server.Completed() {
+		n = task
+	}
+	more, challenge, err := n.Step(msg)
+	switch {
+	case err != nil:
+		return roundTrip(t, &xmppsasl.Failure{Text: "invalid credentials"})
+	case more && n == task:
+		return roundTrip(t, &xmppsasl.TaskData{Data: challenge})
+	case more:
+		return roundTrip(t, &xmppsasl.Challenge{Data: challenge})
+	case n == server && task != nil:
+		return roundTrip(t, &xmppsasl.Continue{AdditionalData: challenge, Tasks: []string{"X-UNKNOWN", task.Mechanism().Name}})
+	}
+	return roundTrip(t, &xmppsasl.Success{AdditionalData: challenge, AuthorizationIdentifier: "user@example.net"})
+}
+
+func TestClient(t *testing.T) {
+	s := store{"user": sasl.DeriveStoredCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096)}
+	acceptPencil := func(n *sasl.Negotiator) bool {
+		if n.Mechanism().Name != sasl.Plain.Name {
+			return true
+		}
+		_, pass, _ := n.Credentials()
+		return string(pass) == "pencil"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mech     sasl.Mechanism
+		password string
+		code     string
+		task     bool
+		fail     bool
+	}{
+		{name: "plain", mech: sasl.Plain, password: "pencil"},
+		{name: "plain-bad-password", mech: sasl.Plain, password: "pen", fail: true},
+		{name: "scram", mech: sasl.ScramSha256, password: "pencil"},
+		{name: "scram-bad-password", mech: sasl.ScramSha256, password: "pen", fail: true},
+		{name: "scram-task", mech: sasl.ScramSha256, password: "pencil", code: "123456", task: true},
+		{name: "scram-task-bad-code", mech: sasl.ScramSha256, password: "pencil", code: "000000", task: true, fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := sasl.NewServer(tc.mech, acceptPencil, sasl.Store(s))
+			var serverTask *sasl.Negotiator
+			if tc.task {
+				serverTask = sasl.NewServer(otp, nil)
+			}
+			client := xmppsasl.NewClient(
+				sasl.NewClient(tc.mech, sasl.Credentials(func() ([]byte, []byte, []byte) {
+					return []byte("user"), []byte(tc.password), nil
+				})),
+				sasl.NewClient(otp, sasl.Credentials(func() ([]byte, []byte, []byte) {
+					return nil, []byte(tc.code), nil
+				})),
+			)
+
+			auth, err := client.Authenticate(&xmppsasl.UserAgent{Software: "test"})
+			if err != nil {
+				t.Fatalf("Error starting authentication: %v", err)
+			}
+			auth = roundTrip(t, auth)
+			if auth.Mechanism != tc.mech.Name || auth.InitialResponse == nil {
+				t.Fatalf("Unexpected authenticate element: %+v", auth)
+			}
+			el := serve(t, server, serverTask, *auth.InitialResponse)
+
+			var done bool
+			for !done {
+				var reply interface{}
+				reply, done, err = client.Handle(el)
+				if err != nil {
+					break
+				}
+				switch reply := reply.(type) {
+				case *xmppsasl.Response:
+					el = serve(t, server, serverTask, roundTrip(t, reply).Data)
+				case *xmppsasl.TaskData:
+					el = serve(t, server, serverTask, roundTrip(t, reply).Data)
+				case *xmppsasl.Next:
+					next := roundTrip(t, reply)
+					if next.Task != otp.Name {
+						t.Fatalf("Unexpected task: %q", next.Task)
+					}
+					msg, _ := base64.StdEncoding.DecodeString(next.Data)
+					el = serve(t, server, serverTask, msg)
+				case nil:
+				default:
+					t.Fatalf("Unexpected reply %T", reply)
+				}
+			}
+			switch {
+			case tc.fail && !errors.Is(err, sasl.ErrAuthn):
+				t.Fatalf("Expected authentication error, got %v", err)
+			case !tc.fail && err != nil:
+				t.Fatalf("Unexpected error: %v", err)
+			case tc.fail:
+				return
+			}
+			if got := client.Success().AuthorizationIdentifier; got != "user@example.net" {
+				t.Errorf("Unexpected authorization identifier: %q", got)
+			}
+		})
+	}
+}
+
+func TestNoTask(t *testing.T) {
+	client := xmppsasl.NewClient(sasl.NewClient(sasl.Plain, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	})))
+	if _, err := client.Authenticate(nil); err != nil {
+		t.Fatalf("Error starting authentication: %v", err)
+	}
+	_, _, err := client.Handle(&xmppsasl.Continue{Tasks: []string{"X-UNKNOWN"}})
+	if !errors.Is(err, xmppsasl.ErrNoTask) {
+		t.Errorf("Expected ErrNoTask, got %v", err)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	const raw = `<failure xmlns='urn:xmpp:sasl:2'><aborted xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/><text>This is a terrible example.</text></failure>`
+	var f xmppsasl.Failure
+	if err := xml.Unmarshal([]byte(raw), &f); err != nil {
+		t.Fatalf("Error unmarshaling failure: %v", err)
+	}
+	client := xmppsasl.NewClient(sasl.NewClient(sasl.Plain))
+	_, _, err := client.Handle(&f)
+	var saslErr *xmppsasl.Error
+	if !errors.As(err, &saslErr) {
+		t.Fatalf("Expected *Error, got %v", err)
+	}
+	if saslErr.Condition != "aborted" || saslErr.Text != "This is a terrible example." {
+		t.Errorf("Unexpected error: %+v", saslErr)
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	if !sasl.IsTemporary(&xmppsasl.Error{Condition: "temporary-auth-failure"}) {
+		t.Error("Expected temporary-auth-failure to be temporary")
+	}
+	if sasl.IsTemporary(&xmppsasl.Error{Condition: "not-authorized"}) {
+		t.Error("Expected not-authorized to be permanent")
+	}
+}
+
+func TestSuccessAdditionalData(t *testing.T) {
+	const raw = `<success xmlns='urn:xmpp:sasl:2'><additional-data>SGVsbG8sIHdvcmxkIQ==</additional-data><authorization-identifier>juliet@montague.example/Balcony/a987dsh9a87sdh</authorization-identifier></success>`
+	var s xmppsasl.Success
+	if err := xml.Unmarshal([]byte(raw), &s); err != nil {
+		t.Fatalf("Error unmarshaling success: %v", err)
+	}
+	want, _ := base64.StdEncoding.DecodeString("SGVsbG8sIHdvcmxkIQ==")
+	if string(s.AdditionalData) != string(want) {
+		t.Errorf("Unexpected additional data: want=%q, got=%q", want, s.AdditionalData)
+	}
+	if s.AuthorizationIdentifier != "juliet@montague.example/Balcony/a987dsh9a87sdh" {
+		t.Errorf("Unexpected authorization identifier: %q", s.AuthorizationIdentifier)
+	}
+}